@@ -0,0 +1,258 @@
+// Package auth provides short-lived tokens for http outputs: tokens of the OAuth2 client credentials flow
+// and tokens rotated in a file. Tokens are refreshed before expiry and after the endpoint responds with 401.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// TypeOAuth2 requests tokens from the token endpoint with the OAuth2 client credentials flow.
+	TypeOAuth2 = "oauth2"
+	// TypeTokenFile reads the token from the file, it's read again once the file is modified.
+	TypeTokenFile = "token_file"
+)
+
+// Config is the `auth` object of the output config.
+type Config struct {
+	// Type is `oauth2` or `token_file`.
+	Type string `json:"type" required:"true" options:"oauth2|token_file"`
+
+	// TokenURL is the token endpoint, it's required for `oauth2`.
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+
+	// TokenFile is the file with the token, it's required for `token_file`.
+	TokenFile string `json:"token_file"`
+
+	// RefreshBefore is how long before the expiry the token is refreshed.
+	RefreshBefore  cfg.Duration `json:"refresh_before" default:"1m" parse:"duration"`
+	RefreshBefore_ time.Duration
+
+	// Header is the request header with the token.
+	Header string `json:"header" default:"Authorization"`
+	// Scheme precedes the token in the header.
+	Scheme string `json:"scheme" default:"Bearer"`
+}
+
+// Source caches the token and refreshes it when it's about to expire or is invalidated.
+type Source struct {
+	header string
+	scheme string
+
+	refreshBefore time.Duration
+	// fetch returns the new token and its expiry, zero expiry means the token doesn't expire
+	fetch func(ctx context.Context) (string, time.Time, error)
+	// isChanged returns true if the token should be fetched again regardless of the expiry
+	isChanged func() bool
+
+	mu     *sync.Mutex
+	token  string
+	expiry time.Time
+	now    func() time.Time
+}
+
+// New checks the config, sets defaults and returns the token source.
+// The client is used for requests to the token endpoint.
+func New(config *Config, client *http.Client) (*Source, error) {
+	if err := cfg.Parse(config, nil); err != nil {
+		return nil, err
+	}
+
+	s := &Source{
+		header:        config.Header,
+		scheme:        config.Scheme,
+		refreshBefore: config.RefreshBefore_,
+		isChanged:     func() bool { return false },
+		mu:            &sync.Mutex{},
+		now:           time.Now,
+	}
+
+	switch config.Type {
+	case TypeOAuth2:
+		if config.TokenURL == "" {
+			return nil, fmt.Errorf("token_url should be set for %q auth", TypeOAuth2)
+		}
+		s.fetch = newOAuth2Fetch(config, client)
+	case TypeTokenFile:
+		if config.TokenFile == "" {
+			return nil, fmt.Errorf("token_file should be set for %q auth", TypeTokenFile)
+		}
+		s.fetch, s.isChanged = newFileFetch(config.TokenFile)
+	default:
+		return nil, fmt.Errorf("unknown auth type %q", config.Type)
+	}
+
+	return s, nil
+}
+
+func newOAuth2Fetch(config *Config, client *http.Client) func(ctx context.Context) (string, time.Time, error) {
+	credentials := &clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     config.TokenURL,
+		Scopes:       config.Scopes,
+	}
+
+	return func(ctx context.Context) (string, time.Time, error) {
+		if client != nil {
+			ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+		}
+
+		// the token isn't cached by the oauth2 package, so it's requested on each call
+		token, err := credentials.Token(ctx)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		return token.AccessToken, token.Expiry, nil
+	}
+}
+
+func newFileFetch(file string) (func(ctx context.Context) (string, time.Time, error), func() bool) {
+	modTime := time.Time{}
+	fetch := func(_ context.Context) (string, time.Time, error) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		modTime = info.ModTime()
+
+		return strings.TrimSpace(string(data)), time.Time{}, nil
+	}
+
+	isChanged := func() bool {
+		info, err := os.Stat(file)
+		if err != nil {
+			// keep the last token, the file may be replaced right now
+			return false
+		}
+
+		return !info.ModTime().Equal(modTime)
+	}
+
+	return fetch, isChanged
+}
+
+// Token returns the cached token or fetches the new one.
+// If the refresh fails, the cached token is returned until it expires.
+func (s *Source) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.token != "" && !s.isChanged() && (s.expiry.IsZero() || now.Add(s.refreshBefore).Before(s.expiry)) {
+		return s.token, nil
+	}
+
+	token, expiry, err := s.fetch(ctx)
+	if err != nil {
+		if s.token != "" && (s.expiry.IsZero() || now.Before(s.expiry)) {
+			return s.token, nil
+		}
+		return "", fmt.Errorf("can't fetch token: %w", err)
+	}
+
+	s.token = token
+	s.expiry = expiry
+
+	return s.token, nil
+}
+
+// Invalidate drops the cached token, e.g. if the endpoint has rejected it.
+func (s *Source) Invalidate() {
+	s.mu.Lock()
+	s.token = ""
+	s.expiry = time.Time{}
+	s.mu.Unlock()
+}
+
+// Authorize sets the token header of the request.
+func (s *Source) Authorize(req *http.Request) error {
+	token, err := s.Token(req.Context())
+	if err != nil {
+		return err
+	}
+
+	if s.scheme == "" {
+		req.Header.Set(s.header, token)
+	} else {
+		req.Header.Set(s.header, s.scheme+" "+token)
+	}
+
+	return nil
+}
+
+// Transport authorizes requests, if the response is 401 the token is invalidated and the request is retried once.
+type Transport struct {
+	Base   http.RoundTripper
+	Source *Source
+}
+
+// NewTransport wraps the base transport, http.DefaultTransport is used if it's nil.
+func NewTransport(base http.RoundTripper, source *Source) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{Base: base, Source: source}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the request shouldn't be modified by the round tripper
+	authorized := req.Clone(req.Context())
+	if err := t.Source.Authorize(authorized); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.Base.RoundTrip(authorized)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// the body can't be sent again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	t.Source.Invalidate()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	if err := t.Source.Authorize(retry); err != nil {
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+
+	return t.Base.RoundTrip(retry)
+}
+
+// CloseIdleConnections closes idle connections of the base transport, so http.Client.CloseIdleConnections works.
+func (t *Transport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if c, ok := t.Base.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func newTokenServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	issued := atomic.NewInt32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.Form.Get("grant_type"), "wrong grant type")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token%d","token_type":"bearer","expires_in":3600}`, issued.Inc())
+	}))
+	t.Cleanup(server.Close)
+
+	return server, issued
+}
+
+func TestOAuth2(t *testing.T) {
+	server, issued := newTokenServer(t)
+	source, err := New(&Config{Type: TypeOAuth2, TokenURL: server.URL, ClientID: "file-d", ClientSecret: "secret"}, nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	source.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		token, err := source.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "token1", token, "token isn't cached")
+	}
+
+	// the token is refreshed a minute before the expiry
+	now = now.Add(time.Hour - 30*time.Second)
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token2", token, "token isn't refreshed")
+	assert.Equal(t, int32(2), issued.Load(), "wrong count of token requests")
+}
+
+func TestTokenFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(file, []byte("first\n"), 0o600))
+
+	source, err := New(&Config{Type: TypeTokenFile, TokenFile: file}, nil)
+	require.NoError(t, err)
+
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "first", token, "wrong token")
+
+	require.NoError(t, os.WriteFile(file, []byte("second"), 0o600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+
+	token, err = source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "second", token, "rotated token isn't read")
+
+	// the last token is used while the file is replaced
+	require.NoError(t, os.Remove(file))
+	token, err = source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "second", token, "wrong token")
+}
+
+func TestTransportRetryOnUnauthorized(t *testing.T) {
+	tokenServer, issued := newTokenServer(t)
+	source, err := New(&Config{Type: TypeOAuth2, TokenURL: tokenServer.URL}, nil)
+	require.NoError(t, err)
+
+	requests := atomic.NewInt32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Inc()
+		// the first token is revoked
+		if r.Header.Get("Authorization") != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "payload", string(body), "body isn't sent again")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, source)}
+	req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader([]byte("payload")))
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "request isn't retried")
+	assert.Equal(t, int32(2), requests.Load(), "request should be retried once")
+	assert.Equal(t, int32(2), issued.Load(), "token isn't fetched again")
+	assert.Empty(t, req.Header.Get("Authorization"), "original request is modified")
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(&Config{Type: TypeOAuth2}, nil)
+	assert.Error(t, err, "token url isn't checked")
+
+	_, err = New(&Config{Type: TypeTokenFile}, nil)
+	assert.Error(t, err, "token file isn't checked")
+
+	_, err = New(&Config{Type: "basic"}, nil)
+	assert.Error(t, err, "type isn't checked")
+}
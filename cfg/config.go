@@ -18,6 +18,7 @@ import (
 type Config struct {
 	Vault        VaultConfig
 	PanicTimeout time.Duration
+	Panic        PanicConfig
 	Pipelines    map[string]*PipelineConfig
 }
 
@@ -34,6 +35,16 @@ type PipelineConfig struct {
 	Raw *simplejson.Json
 }
 
+// PanicConfig describes how recovered panics are reported and handled.
+type PanicConfig struct {
+	// Sinks are the names of the report sinks: log, webhook or metric.
+	Sinks          []string
+	WebhookURL     string
+	WebhookTimeout time.Duration
+	// Restart tells to restart the panicked goroutine instead of waiting for PanicTimeout.
+	Restart bool
+}
+
 type VaultConfig struct {
 	Token     string
 	Address   string
@@ -47,41 +58,56 @@ func NewConfig() *Config {
 			Address:   "",
 			ShouldUse: false,
 		},
+		Panic: PanicConfig{
+			Sinks:          []string{"log"},
+			WebhookTimeout: 5 * time.Second,
+		},
 		Pipelines: make(map[string]*PipelineConfig, 20),
 	}
 }
 
 func NewConfigFromFile(path string) *Config {
+	config, err := LoadConfigFromFile(path)
+	if err != nil {
+		logger.Fatalf("%s", err.Error())
+	}
+
+	return config
+}
+
+// LoadConfigFromFile reads the config like NewConfigFromFile, but it returns an error instead of failing,
+// so the config can be reloaded without the risk to crash.
+func LoadConfigFromFile(path string) (*Config, error) {
 	logger.Infof("reading config %q", path)
 	yamlContents, err := ioutil.ReadFile(path)
 	if err != nil {
-		logger.Fatalf("can't read config file %q: %s", path, err)
+		return nil, fmt.Errorf("can't read config file %q: %w", path, err)
 	}
 
 	jsonContents, err := yaml.YAMLToJSON(yamlContents)
 	if err != nil {
 		logger.Infof("config content:\n%s", logger.Numerate(string(yamlContents)))
-		logger.Fatalf("can't parse config file yaml %q: %s", path, err.Error())
+		return nil, fmt.Errorf("can't parse config file yaml %q: %w", path, err)
 	}
 
 	json, err := simplejson.NewJson(jsonContents)
 	if err != nil {
-		logger.Fatalf("can't convert config to json %q: %s", path, err.Error())
+		return nil, fmt.Errorf("can't convert config to json %q: %w", path, err)
 	}
 
 	err = applyEnvs(json)
 	if err != nil {
-		logger.Fatalf("can't get config values from environments: %s", err.Error())
+		return nil, fmt.Errorf("can't get config values from environments: %w", err)
 	}
 
 	config := parseConfig(json)
 	if !config.Vault.ShouldUse {
-		return config
+		return config, nil
 	}
 
 	vault, err := newVault(config.Vault.Address, config.Vault.Token)
 	if err != nil {
-		logger.Fatalf("can't create vault client: %s", err.Error())
+		return nil, fmt.Errorf("can't create vault client: %w", err)
 	}
 
 	for _, p := range config.Pipelines {
@@ -90,7 +116,7 @@ func NewConfigFromFile(path string) *Config {
 
 	logger.Infof("config parsed, found %d pipelines", len(config.Pipelines))
 
-	return config
+	return config, nil
 }
 
 func applyEnvs(json *simplejson.Json) error {
@@ -153,9 +179,37 @@ func parseConfig(json *simplejson.Json) *Config {
 	}
 	config.PanicTimeout = panicTimeout
 
+	parsePanicConfig(json.Get("panic"), &config.Panic)
+
 	return config
 }
 
+func parsePanicConfig(json *simplejson.Json, config *PanicConfig) {
+	if sinks, err := json.Get("sinks").StringArray(); err == nil && len(sinks) != 0 {
+		config.Sinks = sinks
+	}
+
+	for _, sink := range config.Sinks {
+		if sink != "log" && sink != "webhook" && sink != "metric" {
+			logger.Fatalf("unknown panic sink %q, should be one of log, webhook, metric", sink)
+		}
+		if sink == "webhook" && json.Get("webhook_url").MustString() == "" {
+			logger.Fatalf("panic webhook_url isn't set for the webhook sink")
+		}
+	}
+
+	config.WebhookURL = json.Get("webhook_url").MustString()
+	if timeout := json.Get("webhook_timeout").MustString(); timeout != "" {
+		webhookTimeout, err := time.ParseDuration(timeout)
+		if err != nil {
+			logger.Fatalf("can't parse panic webhook_timeout: %s", err.Error())
+		}
+		config.WebhookTimeout = webhookTimeout
+	}
+
+	config.Restart = json.Get("restart").MustBool()
+}
+
 func applyVault(vault secreter, json *simplejson.Json) {
 	if a, err := json.Array(); err == nil {
 		for i := range a {
@@ -312,7 +366,10 @@ func ParseField(v reflect.Value, vField reflect.Value, tField reflect.StructFiel
 			if err != nil {
 				return fmt.Errorf("default value for field %s should be int, got=%s: %w", tField.Name, tag, err)
 			}
-			vField.SetInt(int64(val))
+			// zero value means the field isn't set, so fields with a default can't be explicitly set to zero
+			if vField.Int() == 0 {
+				vField.SetInt(int64(val))
+			}
 		case reflect.Slice:
 			if vField.Len() == 0 {
 				val := strings.Fields(tag)
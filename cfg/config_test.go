@@ -1,6 +1,7 @@
 package cfg
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
 	"time"
@@ -30,6 +31,14 @@ type strDefault struct {
 	T string `default:"sync"`
 }
 
+type intDefault struct {
+	T int `default:"5"`
+}
+
+type intDefaultJSON struct {
+	T int `json:"t" default:"5"`
+}
+
 type strDuration struct {
 	T  Duration `default:"5s" parse:"duration"`
 	T_ time.Duration
@@ -89,6 +98,43 @@ func TestParseDefault(t *testing.T) {
 	assert.Equal(t, "sync", s.T, "wrong value")
 }
 
+func TestParseIntDefault(t *testing.T) {
+	s := &intDefault{}
+	err := Parse(s, nil)
+
+	assert.NoError(t, err, "shouldn't be an error")
+	assert.Equal(t, 5, s.T, "wrong value")
+
+	s = &intDefault{T: 10}
+	err = Parse(s, nil)
+
+	assert.NoError(t, err, "shouldn't be an error")
+	assert.Equal(t, 10, s.T, "default value shouldn't override the set one")
+
+	s = &intDefault{T: -1}
+	err = Parse(s, nil)
+
+	assert.NoError(t, err, "shouldn't be an error")
+	assert.Equal(t, -1, s.T, "default value shouldn't override the negative one")
+}
+
+func TestParseIntDefaultJSON(t *testing.T) {
+	s := &intDefaultJSON{}
+	require.NoError(t, json.Unmarshal([]byte(`{"t":10}`), s))
+	require.NoError(t, Parse(s, nil))
+	assert.Equal(t, 10, s.T, "value from the config shouldn't be overridden")
+
+	s = &intDefaultJSON{}
+	require.NoError(t, json.Unmarshal([]byte(`{}`), s))
+	require.NoError(t, Parse(s, nil))
+	assert.Equal(t, 5, s.T, "default value isn't set for the missing field")
+
+	s = &intDefaultJSON{}
+	require.NoError(t, json.Unmarshal([]byte(`{"t":0}`), s))
+	require.NoError(t, Parse(s, nil))
+	assert.Equal(t, 5, s.T, "zero value should be treated as the missing one")
+}
+
 func TestParseDuration(t *testing.T) {
 	s := &strDuration{}
 	err := Parse(s, nil)
@@ -330,3 +376,16 @@ func TestApplyVault(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePanicConfig(t *testing.T) {
+	json, err := simplejson.NewJson([]byte(`{"sinks":["log","webhook"],"webhook_url":"http://localhost/panics","webhook_timeout":"1s","restart":true}`))
+	require.NoError(t, err)
+
+	config := NewConfig().Panic
+	parsePanicConfig(json, &config)
+
+	assert.Equal(t, []string{"log", "webhook"}, config.Sinks, "wrong sinks")
+	assert.Equal(t, "http://localhost/panics", config.WebhookURL, "wrong webhook url")
+	assert.Equal(t, time.Second, config.WebhookTimeout, "wrong webhook timeout")
+	assert.True(t, config.Restart, "wrong restart")
+}
@@ -1,65 +1,112 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
-	"os/signal"
 	"runtime/debug"
-	"syscall"
+	"strings"
 
 	"github.com/alecthomas/kingpin"
 	"github.com/ozonru/file.d/cfg"
 	"github.com/ozonru/file.d/fd"
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/longpanic"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
 	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/automaxprocs/maxprocs"
 
 	_ "github.com/ozonru/file.d/plugin/action/add_host"
+	_ "github.com/ozonru/file.d/plugin/action/anonymize_ip"
+	_ "github.com/ozonru/file.d/plugin/action/charset"
+	_ "github.com/ozonru/file.d/plugin/action/clock_skew"
 	_ "github.com/ozonru/file.d/plugin/action/convert_date"
 	_ "github.com/ozonru/file.d/plugin/action/debug"
 	_ "github.com/ozonru/file.d/plugin/action/discard"
+	_ "github.com/ozonru/file.d/plugin/action/ecs"
 	_ "github.com/ozonru/file.d/plugin/action/flatten"
 	_ "github.com/ozonru/file.d/plugin/action/join"
 	_ "github.com/ozonru/file.d/plugin/action/json_decode"
 	_ "github.com/ozonru/file.d/plugin/action/keep_fields"
+	_ "github.com/ozonru/file.d/plugin/action/meta_to_fields"
 	_ "github.com/ozonru/file.d/plugin/action/modify"
+	_ "github.com/ozonru/file.d/plugin/action/normalize_level"
+	_ "github.com/ozonru/file.d/plugin/action/parse_csv"
 	_ "github.com/ozonru/file.d/plugin/action/parse_es"
+	_ "github.com/ozonru/file.d/plugin/action/parse_kv"
 	_ "github.com/ozonru/file.d/plugin/action/parse_re2"
+	_ "github.com/ozonru/file.d/plugin/action/parse_user_agent"
+	_ "github.com/ozonru/file.d/plugin/action/parse_xml"
 	_ "github.com/ozonru/file.d/plugin/action/remove_fields"
 	_ "github.com/ozonru/file.d/plugin/action/rename"
 	_ "github.com/ozonru/file.d/plugin/action/throttle"
+	_ "github.com/ozonru/file.d/plugin/input/beats"
 	_ "github.com/ozonru/file.d/plugin/input/dmesg"
 	_ "github.com/ozonru/file.d/plugin/input/fake"
-	_ "github.com/ozonru/file.d/plugin/input/file"
+	"github.com/ozonru/file.d/plugin/input/file"
+	_ "github.com/ozonru/file.d/plugin/input/grpc"
 	_ "github.com/ozonru/file.d/plugin/input/http"
 	_ "github.com/ozonru/file.d/plugin/input/journalctl"
 	_ "github.com/ozonru/file.d/plugin/input/k8s"
 	_ "github.com/ozonru/file.d/plugin/input/kafka"
+	_ "github.com/ozonru/file.d/plugin/input/statsd"
+	_ "github.com/ozonru/file.d/plugin/output/azure_blob"
+	_ "github.com/ozonru/file.d/plugin/output/azure_eventhub"
+	_ "github.com/ozonru/file.d/plugin/output/bigquery"
+	_ "github.com/ozonru/file.d/plugin/output/cloudwatch"
 	_ "github.com/ozonru/file.d/plugin/output/devnull"
 	_ "github.com/ozonru/file.d/plugin/output/elasticsearch"
+	_ "github.com/ozonru/file.d/plugin/output/failover"
 	_ "github.com/ozonru/file.d/plugin/output/file"
 	_ "github.com/ozonru/file.d/plugin/output/gelf"
 	_ "github.com/ozonru/file.d/plugin/output/kafka"
 	_ "github.com/ozonru/file.d/plugin/output/s3"
+	_ "github.com/ozonru/file.d/plugin/output/sentry"
 	_ "github.com/ozonru/file.d/plugin/output/splunk"
 	_ "github.com/ozonru/file.d/plugin/output/stdout"
+	_ "github.com/ozonru/file.d/plugin/output/webhook"
 )
 
 var (
 	fileD   *fd.FileD
-	exit    = make(chan bool)
 	version = "v0.0.1"
 
 	config = kingpin.Flag("config", `config file name`).Required().ExistingFile()
 	http   = kingpin.Flag("http", `http listen addr eg. ":9000", "off" to disable`).Default(":9000").String()
 
+	metricsHTTP = kingpin.Flag("metrics-http", `http listen addr of metrics eg. ":9001", "off" to disable, metrics are served on --http addr if it's empty`).String()
+	pprofHTTP   = kingpin.Flag("pprof-http", `http listen addr of pprof eg. "127.0.0.1:6060", "off" to disable, pprof is served on --http addr if it's empty`).String()
+	auditLog    = kingpin.Flag("audit-log", `file to append state-changing requests to admin endpoints to, e.g. hold or reload`).String()
+	dnsCacheTTL = kingpin.Flag("dns-cache-ttl", `how long addresses of output endpoints are cached, "0s" disables caching`).Default(netutil.DefaultCacheTTL.String()).Duration()
+
+	crd                = kingpin.Flag("crd", `create pipelines from FileDPipeline k8s custom resources`).Bool()
+	crdNamespace       = kingpin.Flag("crd-namespace", `namespace to watch FileDPipeline resources, all namespaces if it's empty`).String()
+	crdAllowNamespaces = kingpin.Flag("crd-allow-namespace", `namespace FileDPipeline resources are accepted from, all namespaces if it isn't set, may be repeated`).Strings()
+	crdAllowPlugins    = kingpin.Flag("crd-allow-plugin", `restricted plugin FileDPipeline resources may use, e.g. "input/file", may be repeated`).Strings()
+
+	selfTest        = kingpin.Flag("selftest", `send a marker event through every pipeline to its output, exit non-zero if it isn't delivered`).Bool()
+	selfTestTimeout = kingpin.Flag("selftest-timeout", `how long to wait for the marker event delivery`).Default("30s").Duration()
+
+	shutdownTimeout = kingpin.Flag("shutdown-timeout", `how long to wait for pipelines to stop on SIGTERM or SIGINT, file.d exits with code 4 after it`).Default("1m").Duration()
+
+	runCmd          = kingpin.Command("run", `run pipelines`).Default()
+	testCmd         = kingpin.Command("test", `pass fixture lines through actions of pipelines and compare output documents with the expected ones, exit non-zero if they differ`)
+	fixturesDir     = testCmd.Flag("fixtures", `dir with <pipeline_name>.input and <pipeline_name>.expected fixture files`).Required().ExistingDir()
+	fixturesTimeout = testCmd.Flag("timeout", `how long to wait for events of the fixture to leave the pipeline`).Default("10s").Duration()
+
+	offsetsCmd       = kingpin.Command("offsets", `move offsets of file and k8s inputs between nodes in the portable json format`)
+	offsetsPipeline  = offsetsCmd.Flag("pipeline", `pipeline whose input offsets are moved`).Required().String()
+	offsetsExportCmd = offsetsCmd.Command("export", `write offsets of the pipeline input to the json file`)
+	offsetsOut       = offsetsExportCmd.Flag("out", `json file to write offsets to`).Required().String()
+	offsetsImportCmd = offsetsCmd.Command("import", `match files of the json file with files of this node and write their offsets to the offsets file, file.d should be stopped`)
+	offsetsIn        = offsetsImportCmd.Flag("in", `json file with exported offsets`).Required().ExistingFile()
+
 	gcPercent = 20
 )
 
 func main() {
 	kingpin.Version(version)
-	kingpin.Parse()
+	command := kingpin.Parse()
 
 	logger.Infof("hi!")
 
@@ -69,39 +116,145 @@ func main() {
 
 	_, _ = maxprocs.Set(maxprocs.Logger(logger.Debugf))
 
-	go listenSignals()
+	switch command {
+	case testCmd.FullCommand():
+		runFixtures()
+		return
+	case offsetsExportCmd.FullCommand():
+		exportOffsets()
+		return
+	case offsetsImportCmd.FullCommand():
+		importOffsets()
+		return
+	}
+
+	if *selfTest {
+		runSelfTest()
+		return
+	}
+
+	fileD = newFileD()
+	codes := fileD.ListenSignals(*shutdownTimeout)
 	longpanic.Go(start)
 
-	<-exit
+	code := <-codes
 	logger.Infof("see you soon...")
+	os.Exit(code)
 }
 
-func start() {
+func newFileD() *fd.FileD {
 	cfg := cfg.NewConfigFromFile(*config)
 	longpanic.SetTimeout(cfg.PanicTimeout)
+	netutil.DefaultResolver.SetTTL(*dnsCacheTTL)
+
+	fileD := fd.New(cfg, *http)
+	fileD.SetConfigPath(*config)
+	fileD.SetMetricsAddr(*metricsHTTP)
+	fileD.SetPprofAddr(*pprofHTTP)
+	fileD.SetAuditLog(*auditLog)
 
-	fileD = fd.New(cfg, *http)
+	return fileD
+}
+
+func start() {
 	fileD.Start()
+	if *crd {
+		fileD.StartCRD(*crdNamespace, &fd.CRDPolicy{Namespaces: *crdAllowNamespaces, Plugins: *crdAllowPlugins})
+	}
 }
 
-func listenSignals() {
-	signalChan := make(chan os.Signal)
-	signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGTERM)
-
-	for {
-		s := <-signalChan
-
-		switch s {
-		case syscall.SIGHUP:
-			logger.Infof("SIGHUP received")
-			fileD.Stop()
-			start()
-		case syscall.SIGINT:
-			fallthrough
-		case syscall.SIGTERM:
-			logger.Infof("SIGTERM received")
-			fileD.Stop()
-			exit <- true
+func runSelfTest() {
+	fileD = newFileD()
+	start()
+	err := fileD.SelfTest(*selfTestTimeout)
+	fileD.Stop()
+
+	if err != nil {
+		logger.Errorf("%s", err.Error())
+		os.Exit(1)
+	}
+	logger.Infof("self test passed")
+}
+
+func runFixtures() {
+	results, err := fd.New(cfg.NewConfigFromFile(*config), "off").TestFixtures(*fixturesDir, *fixturesTimeout)
+	if err != nil {
+		logger.Errorf("%s", err.Error())
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			logger.Errorf("pipeline %q: can't run fixture: %s", result.Pipeline, result.Err.Error())
+		case len(result.Diffs) != 0:
+			failed++
+			logger.Errorf("pipeline %q: fixture failed:\n%s", result.Pipeline, strings.Join(result.Diffs, "\n"))
+		default:
+			logger.Infof("pipeline %q: fixture passed", result.Pipeline)
 		}
 	}
+
+	if failed != 0 {
+		logger.Errorf("%d of %d fixtures failed", failed, len(results))
+		os.Exit(1)
+	}
+	logger.Infof("all fixtures passed")
+}
+
+// offsetsFile finds the offsets file of the pipeline input in the config.
+func offsetsFile() string {
+	inputConfig, err := fd.New(cfg.NewConfigFromFile(*config), "off").InputConfig(*offsetsPipeline)
+	if err != nil {
+		logger.Fatalf("can't get input config: %s", err.Error())
+	}
+
+	offsetsFile, err := file.OffsetsFileOf(inputConfig)
+	if err != nil {
+		logger.Fatalf("can't get offsets file of pipeline %q: %s", *offsetsPipeline, err.Error())
+	}
+
+	return offsetsFile
+}
+
+func exportOffsets() {
+	offsets, err := file.ExportOffsets(offsetsFile())
+	if err != nil {
+		logger.Fatalf("can't export offsets: %s", err.Error())
+	}
+
+	data, err := json.MarshalIndent(offsets, "", "  ")
+	if err != nil {
+		logger.Fatalf("can't encode offsets: %s", err.Error())
+	}
+	data = append(data, '\n')
+
+	if err := os.WriteFile(*offsetsOut, data, 0o600); err != nil {
+		logger.Fatalf("can't write offsets: %s", err.Error())
+	}
+	logger.Infof("offsets of %d files are exported to %s", len(offsets.Files), *offsetsOut)
+}
+
+func importOffsets() {
+	data, err := os.ReadFile(*offsetsIn)
+	if err != nil {
+		logger.Fatalf("can't read offsets: %s", err.Error())
+	}
+
+	offsets := &file.PortableOffsets{}
+	if err := json.Unmarshal(data, offsets); err != nil {
+		logger.Fatalf("can't decode offsets: %s", err.Error())
+	}
+
+	result, err := file.ImportOffsets(offsetsFile(), offsets)
+	if err != nil {
+		logger.Fatalf("can't import offsets: %s", err.Error())
+	}
+
+	for name, reason := range result.Skipped {
+		logger.Warnf("offsets of %s are skipped: %s", name, reason)
+	}
+	logger.Infof("offsets of %d files are imported, %d files are skipped", len(result.Imported), len(result.Skipped))
 }
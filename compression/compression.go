@@ -0,0 +1,221 @@
+// Package compression compresses request bodies of http outputs. In the `auto` mode it prefers zstd
+// and falls back to gzip and then to no compression if the server rejects the encoding.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+)
+
+const (
+	CodecNone = "none"
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+	// ModeAuto negotiates the codec: zstd, then gzip, then none.
+	ModeAuto = "auto"
+)
+
+// renegotiateInterval is how long the fallback codec is used before the preferred one is tried again,
+// so the better codec is picked up once the server supports it.
+var renegotiateInterval = time.Hour
+
+var autoCodecs = []string{CodecZstd, CodecGzip, CodecNone}
+
+// Negotiator picks the codec of request bodies and tracks rejections of the server.
+// The nil negotiator doesn't compress bodies.
+type Negotiator struct {
+	codecs []string
+
+	current     *atomic.Int32 // index of the current codec
+	fallbackAt  *atomic.Int64 // unix nano time of the last fallback
+	now         func() time.Time
+	zstd        *zstd.Encoder
+	gzipWriters *sync.Pool
+
+	codecGauge      *prometheus.GaugeVec
+	rawBytes        *prometheus.CounterVec
+	compressedBytes *prometheus.CounterVec
+}
+
+func newMetrics(registry prometheus.Registerer) (*prometheus.GaugeVec, *prometheus.CounterVec, *prometheus.CounterVec) {
+	codecGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "file_d",
+		Subsystem: "output",
+		Name:      "compression_codec",
+		Help:      "the codec negotiated by the output is 1, others are 0",
+	}, []string{"pipeline", "output", "codec"})
+	rawBytes := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "file_d",
+		Subsystem: "output",
+		Name:      "compression_raw_bytes_total",
+		Help:      "the size of request bodies before compression",
+	}, []string{"pipeline", "output", "codec"})
+	compressedBytes := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "file_d",
+		Subsystem: "output",
+		Name:      "compression_compressed_bytes_total",
+		Help:      "the size of request bodies after compression",
+	}, []string{"pipeline", "output", "codec"})
+
+	// metrics are shared by outputs of the pipeline, e.g. by routed ones
+	codecGauge = register(registry, codecGauge).(*prometheus.GaugeVec)
+	rawBytes = register(registry, rawBytes).(*prometheus.CounterVec)
+	compressedBytes = register(registry, compressedBytes).(*prometheus.CounterVec)
+
+	return codecGauge, rawBytes, compressedBytes
+}
+
+// register returns the collector registered already if there is any.
+func register(registry prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	err := registry.Register(c)
+	if registered, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return registered.ExistingCollector
+	}
+
+	return c
+}
+
+// New returns the negotiator of the mode: `auto`, `zstd`, `gzip` or `none`.
+// Only the `auto` mode falls back to other codecs. Metrics are registered in the registry of the pipeline.
+func New(mode string, registry prometheus.Registerer, pipelineName string, outputName string) (*Negotiator, error) {
+	codecs := autoCodecs
+	switch mode {
+	case ModeAuto:
+	case CodecZstd, CodecGzip, CodecNone:
+		codecs = []string{mode}
+	default:
+		return nil, fmt.Errorf("unknown compression %q", mode)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't create zstd encoder: %w", err)
+	}
+
+	codecGauge, rawBytes, compressedBytes := newMetrics(registry)
+	labels := prometheus.Labels{"pipeline": pipelineName, "output": outputName}
+	n := &Negotiator{
+		codecs:     codecs,
+		current:    atomic.NewInt32(0),
+		fallbackAt: atomic.NewInt64(0),
+		now:        time.Now,
+		zstd:       encoder,
+		gzipWriters: &sync.Pool{New: func() interface{} {
+			return gzip.NewWriter(nil)
+		}},
+
+		codecGauge:      codecGauge.MustCurryWith(labels),
+		rawBytes:        rawBytes.MustCurryWith(labels),
+		compressedBytes: compressedBytes.MustCurryWith(labels),
+	}
+	n.setCodecGauge(codecs[0])
+
+	return n, nil
+}
+
+// Codec returns the current codec, the preferred one is tried again after the renegotiate interval.
+func (n *Negotiator) Codec() string {
+	if n == nil {
+		return CodecNone
+	}
+
+	index := n.current.Load()
+	if index > 0 && n.now().UnixNano()-n.fallbackAt.Load() > renegotiateInterval.Nanoseconds() {
+		if n.current.CAS(index, 0) {
+			n.setCodecGauge(n.codecs[0])
+		}
+		index = 0
+	}
+
+	return n.codecs[index]
+}
+
+// Encode compresses the body with the current codec and returns the codec,
+// the body itself is returned if the codec is `none`.
+func (n *Negotiator) Encode(body []byte) ([]byte, string) {
+	if n == nil {
+		return body, CodecNone
+	}
+	codec := n.Codec()
+
+	var out []byte
+	switch codec {
+	case CodecZstd:
+		out = n.zstd.EncodeAll(body, make([]byte, 0, len(body)/4))
+	case CodecGzip:
+		buf := bytes.NewBuffer(make([]byte, 0, len(body)/4))
+		w := n.gzipWriters.Get().(*gzip.Writer)
+		w.Reset(buf)
+		_, _ = w.Write(body)
+		_ = w.Close()
+		n.gzipWriters.Put(w)
+		out = buf.Bytes()
+	default:
+		out = body
+	}
+
+	n.rawBytes.WithLabelValues(codec).Add(float64(len(body)))
+	n.compressedBytes.WithLabelValues(codec).Add(float64(len(out)))
+
+	return out, codec
+}
+
+// SetHeader sets the `Content-Encoding` header of the request body encoded with the codec.
+func SetHeader(req *http.Request, codec string) {
+	if codec != CodecNone {
+		req.Header.Set("Content-Encoding", codec)
+	}
+}
+
+// Reject checks the response of the request encoded with the codec.
+// If the server doesn't support the encoding, the next codec is used and true is returned, so the request should be sent again.
+// The encoding is considered unsupported on the `415` status or on the `400` status if the response body mentions the encoding,
+// other bad requests are rejected because of the content.
+func (n *Negotiator) Reject(codec string, status int, body []byte) bool {
+	if n == nil || !isEncodingRejected(codec, status, body) {
+		return false
+	}
+
+	index := n.current.Load()
+	if n.codecs[index] != codec || int(index) == len(n.codecs)-1 {
+		// another request has already switched the codec, or there is nothing to fall back to
+		return n.codecs[index] != codec
+	}
+
+	if n.current.CAS(index, index+1) {
+		n.fallbackAt.Store(n.now().UnixNano())
+		n.setCodecGauge(n.codecs[index+1])
+	}
+
+	return true
+}
+
+func isEncodingRejected(codec string, status int, body []byte) bool {
+	switch status {
+	case http.StatusUnsupportedMediaType:
+		return true
+	case http.StatusBadRequest:
+		body = bytes.ToLower(body)
+		return codec != CodecNone && bytes.Contains(body, []byte(codec)) || bytes.Contains(body, []byte("content-encoding"))
+	default:
+		return false
+	}
+}
+
+func (n *Negotiator) setCodecGauge(current string) {
+	for _, codec := range autoCodecs {
+		value := 0.0
+		if codec == current {
+			value = 1
+		}
+		n.codecGauge.WithLabelValues(codec).Set(value)
+	}
+}
@@ -0,0 +1,83 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var body = []byte(strings.Repeat(`{"message":"compressible line"}`+"\n", 100))
+
+func TestEncode(t *testing.T) {
+	zstdNegotiator, err := New(CodecZstd, prometheus.NewRegistry(), "test_encode", "zstd")
+	require.NoError(t, err)
+	out, codec := zstdNegotiator.Encode(body)
+	assert.Equal(t, CodecZstd, codec)
+	decoder, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	decoded, err := decoder.DecodeAll(out, nil)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded, "wrong zstd body")
+
+	gzipNegotiator, err := New(CodecGzip, prometheus.NewRegistry(), "test_encode", "gzip")
+	require.NoError(t, err)
+	out, codec = gzipNegotiator.Encode(body)
+	assert.Equal(t, CodecGzip, codec)
+	r, err := gzip.NewReader(bytes.NewReader(out))
+	require.NoError(t, err)
+	decoded, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded, "wrong gzip body")
+	assert.Equal(t, float64(len(body)), testutil.ToFloat64(gzipNegotiator.rawBytes.WithLabelValues(CodecGzip)), "wrong raw bytes metric")
+	assert.Equal(t, float64(len(out)), testutil.ToFloat64(gzipNegotiator.compressedBytes.WithLabelValues(CodecGzip)), "wrong compressed bytes metric")
+
+	out, codec = (*Negotiator)(nil).Encode(body)
+	assert.Equal(t, CodecNone, codec)
+	assert.Equal(t, body, out, "body is compressed without the negotiator")
+}
+
+func TestNegotiation(t *testing.T) {
+	n, err := New(ModeAuto, prometheus.NewRegistry(), "test_negotiation", "elasticsearch")
+	require.NoError(t, err)
+	now := time.Now()
+	n.now = func() time.Time { return now }
+
+	assert.Equal(t, CodecZstd, n.Codec(), "zstd isn't preferred")
+	assert.False(t, n.Reject(CodecZstd, http.StatusInternalServerError, nil), "codec is rejected by the server error")
+	assert.False(t, n.Reject(CodecZstd, http.StatusBadRequest, []byte(`{"error":"mapper_parsing_exception"}`)), "codec is rejected by the bad content")
+
+	assert.True(t, n.Reject(CodecZstd, http.StatusUnsupportedMediaType, nil), "zstd isn't rejected")
+	assert.Equal(t, CodecGzip, n.Codec(), "wrong fallback codec")
+	// the concurrent request encoded with zstd is just sent again
+	assert.True(t, n.Reject(CodecZstd, http.StatusUnsupportedMediaType, nil), "request isn't sent again")
+	assert.Equal(t, CodecGzip, n.Codec(), "codec is switched twice")
+	assert.Equal(t, float64(1), testutil.ToFloat64(n.codecGauge.WithLabelValues(CodecGzip)), "wrong codec metric")
+
+	assert.True(t, n.Reject(CodecGzip, http.StatusBadRequest, []byte("Content-Encoding gzip isn't supported")), "gzip isn't rejected")
+	assert.Equal(t, CodecNone, n.Codec(), "wrong fallback codec")
+	assert.False(t, n.Reject(CodecNone, http.StatusUnsupportedMediaType, nil), "there is nothing to fall back to")
+
+	now = now.Add(renegotiateInterval + time.Second)
+	assert.Equal(t, CodecZstd, n.Codec(), "preferred codec isn't tried again")
+}
+
+func TestFixedCodec(t *testing.T) {
+	n, err := New(CodecGzip, prometheus.NewRegistry(), "test_fixed", "splunk")
+	require.NoError(t, err)
+
+	assert.False(t, n.Reject(CodecGzip, http.StatusUnsupportedMediaType, nil), "fixed codec falls back")
+	assert.Equal(t, CodecGzip, n.Codec())
+
+	_, err = New("brotli", prometheus.NewRegistry(), "test_fixed", "splunk")
+	assert.Error(t, err, "unknown codec isn't checked")
+}
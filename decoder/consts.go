@@ -7,6 +7,8 @@ const (
 	RAW
 	CRI
 	POSTGRES
+	CSV
+	OPAQUE
 )
 
 type DecoderType int
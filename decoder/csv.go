@@ -0,0 +1,99 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+const (
+	csvQuote = '"'
+
+	CSVDefaultDelimiter = ','
+	TSVDefaultDelimiter = '\t'
+)
+
+// SplitCSV splits the CSV line into the values and appends them to the out.
+// Quoted values may contain delimiters, a quote inside the quoted value is escaped by doubling it.
+// Values are sub slices of the data unless they contain escaped quotes.
+//
+// Examples of format:
+// 2021-06-22T16:24:27Z,GET,200,"/api/v1/list?a=1,2"
+// 2021-06-22T16:24:27Z,POST,500,"message with ""quotes"""
+func SplitCSV(out [][]byte, data []byte, delimiter byte) ([][]byte, error) {
+	data = bytes.TrimRight(data, "\r\n")
+
+	pos := 0
+	for {
+		if pos < len(data) && data[pos] == csvQuote {
+			value, next, err := readQuotedCSV(data, pos+1)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, value)
+
+			if next == len(data) {
+				return out, nil
+			}
+			if data[next] != delimiter {
+				return out, fmt.Errorf("delimiter is expected after the quoted value at position %d", next)
+			}
+			pos = next + 1
+			continue
+		}
+
+		end := bytes.IndexByte(data[pos:], delimiter)
+		if end < 0 {
+			return append(out, data[pos:]), nil
+		}
+
+		out = append(out, data[pos:pos+end])
+		pos += end + 1
+	}
+}
+
+func readQuotedCSV(data []byte, pos int) ([]byte, int, error) {
+	start := pos
+	var unescaped []byte
+	for pos < len(data) {
+		if data[pos] != csvQuote {
+			pos++
+			continue
+		}
+
+		if pos+1 < len(data) && data[pos+1] == csvQuote {
+			// escaped quote, so the value can't be a sub slice anymore
+			unescaped = append(unescaped, data[start:pos+1]...)
+			pos += 2
+			start = pos
+			continue
+		}
+
+		if unescaped == nil {
+			return data[start:pos], pos + 1, nil
+		}
+
+		return append(unescaped, data[start:pos]...), pos + 1, nil
+	}
+
+	return nil, pos, fmt.Errorf("closing quote is not found")
+}
+
+// DecodeCSV splits the CSV line and adds the values to the event using the column names.
+// Values without a column are skipped.
+func DecodeCSV(event *insaneJSON.Root, data []byte, columns []string, delimiter byte) error {
+	values, err := SplitCSV(make([][]byte, 0, len(columns)), data, delimiter)
+	if err != nil {
+		return err
+	}
+
+	for i, value := range values {
+		if i == len(columns) {
+			break
+		}
+		event.AddFieldNoAlloc(event, columns[i]).MutateToBytesCopy(event, value)
+	}
+
+	return nil
+}
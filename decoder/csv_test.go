@@ -0,0 +1,37 @@
+package decoder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestCSV(t *testing.T) {
+	root := insaneJSON.Spawn()
+	_ = root.DecodeString("{}")
+	err := DecodeCSV(root, []byte("2021-06-22T16:24:27Z,GET,\"/api?a=1,2\",\"say \"\"hi\"\"\",,extra\n"), []string{"time", "method", "path", "message", "empty"}, CSVDefaultDelimiter)
+
+	assert.NoError(t, err, "error while decoding csv log")
+	assert.Equal(t, `{"time":"2021-06-22T16:24:27Z","method":"GET","path":"/api?a=1,2","message":"say \"hi\"","empty":""}`, root.EncodeToString())
+}
+
+func TestTSV(t *testing.T) {
+	root := insaneJSON.Spawn()
+	_ = root.DecodeString("{}")
+	err := DecodeCSV(root, []byte("GET\t200\r\n"), []string{"method", "status", "path"}, TSVDefaultDelimiter)
+
+	assert.NoError(t, err, "error while decoding tsv log")
+	assert.Equal(t, `{"method":"GET","status":"200"}`, root.EncodeToString())
+}
+
+func TestCSVErr(t *testing.T) {
+	root := insaneJSON.Spawn()
+	_ = root.DecodeString("{}")
+
+	err := DecodeCSV(root, []byte("GET,\"unclosed\n"), []string{"method", "path"}, CSVDefaultDelimiter)
+	assert.Error(t, err, "no error for unclosed quote")
+
+	err = DecodeCSV(root, []byte("GET,\"quoted\"tail\n"), []string{"method", "path"}, CSVDefaultDelimiter)
+	assert.Error(t, err, "no error for text after quote")
+}
@@ -0,0 +1,122 @@
+package fd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+)
+
+// adminRequest is the record of the access log and the audit log.
+type adminRequest struct {
+	Time         string `json:"time"`
+	RemoteAddr   string `json:"remote_addr"`
+	ForwardedFor string `json:"forwarded_for,omitempty"`
+	User         string `json:"user,omitempty"`
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	Query        string `json:"query,omitempty"`
+	Status       int    `json:"status"`
+	Duration     string `json:"duration"`
+}
+
+// auditLog appends state-changing admin requests to the file as JSON lines for change tracking.
+type auditLog struct {
+	mu   *sync.Mutex
+	file *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit log %q: %w", path, err)
+	}
+
+	return &auditLog{mu: &sync.Mutex{}, file: file}, nil
+}
+
+// write appends the record and syncs the file, so the operation is tracked even if file.d crashes after it.
+func (a *auditLog) write(request *adminRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.file.Write(data); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *auditLog) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.file.Close(); err != nil {
+		logger.Errorf("can't close audit log: %s", err.Error())
+	}
+}
+
+// statusRecorder keeps the status of the response for logs.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// serveAdmin logs requests to admin endpoints and writes state-changing ones to the audit log if it's set.
+func (f *FileD) serveAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		user, _, _ := r.BasicAuth()
+		request := &adminRequest{
+			Time:         startedAt.UTC().Format(time.RFC3339Nano),
+			RemoteAddr:   r.RemoteAddr,
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+			User:         user,
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			Query:        r.URL.RawQuery,
+			Status:       recorder.status,
+			Duration:     time.Since(startedAt).String(),
+		}
+
+		logger.Infof("admin request: remote=%s forwarded for=%q user=%q method=%s path=%s status=%d duration=%s",
+			request.RemoteAddr, request.ForwardedFor, request.User, request.Method, request.Path, request.Status, request.Duration)
+
+		if f.audit == nil || !isStateChanging(r) {
+			return
+		}
+		if err := f.audit.write(request); err != nil {
+			logger.Errorf("can't write audit log: %s", err.Error())
+		}
+	})
+}
+
+// isStateChanging checks if the admin request changes the state of file.d,
+// some endpoints change it on GET requests, e.g. `reset` of the file input.
+func isStateChanging(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return true
+	}
+
+	return r.URL.Path == "/freeosmem" || strings.HasSuffix(r.URL.Path, "/reset")
+}
@@ -0,0 +1,56 @@
+package fd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	f := New(cfg.NewConfig(), ":9000")
+	audit, err := openAuditLog(path)
+	require.NoError(t, err)
+	f.audit = audit
+
+	mux := f.newHTTPMuxes()[":9000"]
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/live", nil),
+		httptest.NewRequest(http.MethodPost, "/pipelines/unknown/hold", nil),
+		httptest.NewRequest(http.MethodGet, "/pipelines/unknown/0/reset?file=1", nil),
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	} {
+		r.SetBasicAuth("admin", "secret")
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	audit.close()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2, "only state-changing requests should be audited")
+
+	requests := make([]*adminRequest, 0, len(lines))
+	for _, line := range lines {
+		request := &adminRequest{}
+		require.NoError(t, json.Unmarshal([]byte(line), request))
+		requests = append(requests, request)
+	}
+
+	assert.Equal(t, http.MethodPost, requests[0].Method)
+	assert.Equal(t, "/pipelines/unknown/hold", requests[0].Path)
+	assert.Equal(t, http.StatusNotFound, requests[0].Status)
+	assert.Equal(t, "admin", requests[0].User)
+
+	assert.Equal(t, "/pipelines/unknown/0/reset", requests[1].Path)
+	assert.Equal(t, "file=1", requests[1].Query)
+}
@@ -0,0 +1,544 @@
+package fd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/logger"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const crdResyncInterval = 5 * time.Minute
+
+// PipelineGroupVersion is the API group of FileDPipeline custom resources.
+var PipelineGroupVersion = schema.GroupVersion{Group: "filed.ozon.ru", Version: "v1alpha1"}
+
+// FileDPipeline is the custom resource which defines a pipeline, its `spec` is the same as a pipeline section of the config.
+type FileDPipeline struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   json.RawMessage     `json:"spec"`
+	Status FileDPipelineStatus `json:"status,omitempty"`
+}
+
+// FileDPipelineStatus reports whether the pipeline of the resource is created.
+type FileDPipelineStatus struct {
+	// ObservedGeneration is the generation of the resource the status is about.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Pipeline is the name of the pipeline created from the resource.
+	Pipeline string `json:"pipeline,omitempty"`
+	// Error is the reason the resource is rejected, the previous version of the pipeline keeps working then.
+	Error string `json:"error,omitempty"`
+}
+
+// CRDPolicy restricts resources, since their authors may have fewer permissions on the node than file.d.
+type CRDPolicy struct {
+	// Namespaces are namespaces resources are accepted from, all namespaces are allowed if it's empty.
+	Namespaces []string
+	// Plugins are restricted plugins resources may use, e.g. `input/file`.
+	Plugins []string
+}
+
+// restrictedCRDPlugins read or write files of the node or listen on its ports,
+// resources can't use them unless they're allowed by the policy.
+var restrictedCRDPlugins = map[string]bool{
+	"input/file":       true,
+	"input/k8s":        true,
+	"input/journalctl": true,
+	"input/dmesg":      true,
+	"input/http":       true,
+	"input/grpc":       true,
+	"input/beats":      true,
+	"input/statsd":     true,
+	"output/file":      true,
+	"output/s3":        true,
+}
+
+type FileDPipelineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []FileDPipeline `json:"items"`
+}
+
+func (in *FileDPipeline) DeepCopyObject() k8sruntime.Object {
+	out := &FileDPipeline{
+		TypeMeta: in.TypeMeta,
+		Spec:     append(json.RawMessage(nil), in.Spec...),
+		Status:   in.Status,
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+
+	return out
+}
+
+func (in *FileDPipelineList) DeepCopyObject() k8sruntime.Object {
+	out := &FileDPipelineList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	for i := range in.Items {
+		out.Items = append(out.Items, *in.Items[i].DeepCopyObject().(*FileDPipeline))
+	}
+
+	return out
+}
+
+// crdController materializes pipelines from FileDPipeline custom resources.
+type crdController struct {
+	fileD  *FileD
+	stopCh chan struct{}
+
+	// namespaces and plugins are allowed by the policy, all namespaces are allowed if namespaces are empty
+	namespaces map[string]bool
+	plugins    map[string]bool
+	// updateStatus writes the status of the resource, it's nil if statuses aren't reported
+	updateStatus func(obj *FileDPipeline) error
+
+	mu        *sync.Mutex
+	pipelines map[string]*crdPipeline // by pipeline name
+}
+
+type crdPipeline struct {
+	key        string // namespace/name of the resource
+	generation int64
+	pipeline   *pipeline.Pipeline
+	registry   *prometheus.Registry
+	mux        *http.ServeMux
+}
+
+// StartCRD watches FileDPipeline custom resources in the namespace (all namespaces if it's empty)
+// and creates, recreates or removes pipelines when resources change.
+func (f *FileD) StartCRD(namespace string, policy *CRDPolicy) {
+	logger.Infof("watching FileDPipeline resources, namespace=%q", namespace)
+
+	client, err := newPipelineClient()
+	if err != nil {
+		logger.Fatalf("can't create k8s client: %s", err.Error())
+	}
+
+	c := newCRDController(f, policy)
+	c.updateStatus = func(obj *FileDPipeline) error {
+		return writeStatus(client, obj)
+	}
+	f.crd = c
+
+	listWatcher := cache.NewListWatchFromClient(client, "filedpipelines", namespace, fields.Everything())
+	_, controller := cache.NewInformer(listWatcher, &FileDPipeline{}, crdResyncInterval, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.apply(obj.(*FileDPipeline))
+		},
+		UpdateFunc: func(_ interface{}, obj interface{}) {
+			c.apply(obj.(*FileDPipeline))
+		},
+		DeleteFunc: func(obj interface{}) {
+			if deleted, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = deleted.Obj
+			}
+			c.remove(obj.(*FileDPipeline))
+		},
+	})
+	go controller.Run(c.stopCh)
+}
+
+func newCRDController(f *FileD, policy *CRDPolicy) *crdController {
+	c := &crdController{
+		fileD:     f,
+		stopCh:    make(chan struct{}),
+		plugins:   make(map[string]bool),
+		mu:        &sync.Mutex{},
+		pipelines: make(map[string]*crdPipeline),
+	}
+
+	if policy != nil {
+		for _, namespace := range policy.Namespaces {
+			if c.namespaces == nil {
+				c.namespaces = make(map[string]bool)
+			}
+			c.namespaces[namespace] = true
+		}
+		for _, plugin := range policy.Plugins {
+			c.plugins[plugin] = true
+		}
+	}
+
+	return c
+}
+
+// writeStatus updates the status subresource, so authors of the resource see why it's rejected.
+func writeStatus(client *rest.RESTClient, obj *FileDPipeline) error {
+	obj.TypeMeta = metav1.TypeMeta{APIVersion: PipelineGroupVersion.String(), Kind: "FileDPipeline"}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	return client.Put().
+		Namespace(obj.GetNamespace()).
+		Resource("filedpipelines").
+		Name(obj.GetName()).
+		SubResource("status").
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do().
+		Error()
+}
+
+func newPipelineClient() (*rest.RESTClient, error) {
+	scheme := k8sruntime.NewScheme()
+	scheme.AddKnownTypes(PipelineGroupVersion, &FileDPipeline{}, &FileDPipelineList{})
+	metav1.AddToGroupVersion(scheme, PipelineGroupVersion)
+
+	config := rest.CopyConfig(getKubeConfig())
+	config.GroupVersion = &PipelineGroupVersion
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	config.UserAgent = rest.DefaultKubernetesUserAgent()
+
+	return rest.RESTClientFor(config)
+}
+
+func getKubeConfig() *rest.Config {
+	apiConfig, err := rest.InClusterConfig()
+	if err == nil {
+		return apiConfig
+	}
+
+	kubeConfig := filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	apiConfig, err = clientcmd.BuildConfigFromFlags("", kubeConfig)
+	if err != nil {
+		logger.Fatalf("can't get k8s client config: %s", err.Error())
+	}
+
+	return apiConfig
+}
+
+// apply creates the pipeline of the resource or recreates it if the spec is changed.
+// If the new spec is wrong, the previous pipeline keeps working and the error is reported in the status.
+func (c *crdController) apply(obj *FileDPipeline) {
+	key := obj.GetNamespace() + "/" + obj.GetName()
+	name := crdPipelineName(obj.GetNamespace(), obj.GetName())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.pipelines[name]
+	if current != nil && current.key == key && current.generation == obj.GetGeneration() {
+		return
+	}
+
+	status := FileDPipelineStatus{ObservedGeneration: obj.GetGeneration(), Pipeline: name}
+	created, err := c.create(obj, key, name)
+	if err != nil {
+		logger.Errorf("can't create pipeline from FileDPipeline %s: %s", key, err.Error())
+		status.Error = err.Error()
+		c.setStatus(obj, status)
+		return
+	}
+
+	if current != nil {
+		logger.Infof("FileDPipeline %s is changed, recreating pipeline %q", key, name)
+		current.pipeline.Stop()
+	}
+
+	created.pipeline.Start()
+	c.pipelines[name] = created
+	c.setStatus(obj, status)
+}
+
+// create checks the resource and creates its pipeline without starting it.
+func (c *crdController) create(obj *FileDPipeline, key string, name string) (*crdPipeline, error) {
+	if err := c.check(key, name); err != nil {
+		return nil, err
+	}
+	if c.namespaces != nil && !c.namespaces[obj.GetNamespace()] {
+		return nil, fmt.Errorf("namespace %q isn't allowed", obj.GetNamespace())
+	}
+
+	config, err := simplejson.NewJson(obj.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("wrong pipeline: %w", err)
+	}
+	if err := c.checkPlugins(config); err != nil {
+		return nil, err
+	}
+	if err := c.fileD.validatePipeline(obj.Spec); err != nil {
+		return nil, fmt.Errorf("wrong pipeline: %w", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	p, err := c.fileD.newPipeline(name, &cfg.PipelineConfig{Raw: config}, registry)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	p.SetupHTTPHandlers(mux)
+
+	return &crdPipeline{
+		key:        key,
+		generation: obj.GetGeneration(),
+		pipeline:   p,
+		registry:   registry,
+		mux:        mux,
+	}, nil
+}
+
+// setStatus reports the status if it's changed, so writing the same status doesn't trigger updates of the resource.
+func (c *crdController) setStatus(obj *FileDPipeline, status FileDPipelineStatus) {
+	if c.updateStatus == nil || obj.Status == status {
+		return
+	}
+
+	updated := obj.DeepCopyObject().(*FileDPipeline)
+	updated.Status = status
+	if err := c.updateStatus(updated); err != nil {
+		logger.Errorf("can't update status of FileDPipeline %s/%s: %s", obj.GetNamespace(), obj.GetName(), err.Error())
+	}
+}
+
+// checkPlugins rejects resources with restricted plugins which aren't allowed by the policy.
+func (c *crdController) checkPlugins(config *simplejson.Json) error {
+	plugins := []string{string(pipeline.PluginKindInput) + "/" + config.Get("input").Get("type").MustString()}
+
+	actions := config.Get("actions")
+	for index := range actions.MustArray() {
+		plugins = append(plugins, string(pipeline.PluginKindAction)+"/"+actions.GetIndex(index).Get("type").MustString())
+	}
+
+	outputs, err := outputConfigs(config)
+	if err != nil {
+		return err
+	}
+	plugins = append(plugins, outputPlugins(outputs)...)
+
+	for _, plugin := range plugins {
+		if restrictedCRDPlugins[plugin] && !c.plugins[plugin] {
+			return fmt.Errorf("plugin %s isn't allowed in resources", plugin)
+		}
+	}
+
+	return nil
+}
+
+// outputPlugins returns output plugins including nested ones, e.g. outputs of the failover output.
+func outputPlugins(outputs []*simplejson.Json) []string {
+	plugins := make([]string, 0, len(outputs))
+	for _, outputJSON := range outputs {
+		plugins = append(plugins, string(pipeline.PluginKindOutput)+"/"+outputJSON.Get("type").MustString())
+
+		nested := outputJSON.Get("outputs")
+		for i := range nested.MustArray() {
+			plugins = append(plugins, outputPlugins([]*simplejson.Json{nested.GetIndex(i).Get("output")})...)
+		}
+	}
+
+	return plugins
+}
+
+// check rejects resources which pipeline names collide with other pipelines.
+func (c *crdController) check(key string, name string) error {
+	if c.fileD.hasStaticPipeline(name) {
+		return fmt.Errorf("pipeline %q is already defined in the config", name)
+	}
+
+	current := c.pipelines[name]
+	if current != nil && current.key != key {
+		return fmt.Errorf("pipeline %q is already created from FileDPipeline %s", name, current.key)
+	}
+
+	return nil
+}
+
+func (c *crdController) remove(obj *FileDPipeline) {
+	key := obj.GetNamespace() + "/" + obj.GetName()
+	name := crdPipelineName(obj.GetNamespace(), obj.GetName())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.pipelines[name]
+	if current == nil || current.key != key {
+		return
+	}
+
+	logger.Infof("FileDPipeline %s is deleted, removing pipeline %q", key, name)
+	current.pipeline.Stop()
+	delete(c.pipelines, name)
+}
+
+func (c *crdController) stop() {
+	close(c.stopCh)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, p := range c.pipelines {
+		p.pipeline.Stop()
+		delete(c.pipelines, name)
+	}
+}
+
+func (c *crdController) gatherers() []prometheus.Gatherer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	gatherers := make([]prometheus.Gatherer, 0, len(c.pipelines))
+	for _, p := range c.pipelines {
+		gatherers = append(gatherers, p.registry)
+	}
+
+	return gatherers
+}
+
+func (c *crdController) has(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pipelines[name] != nil
+}
+
+// ServeHTTP passes `/pipelines/<pipeline_name>/...` requests to handlers of the pipeline.
+func (c *crdController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := pipelineNameFromPath(r.URL.Path)
+
+	c.mu.Lock()
+	p := c.pipelines[name]
+	c.mu.Unlock()
+
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p.mux.ServeHTTP(w, r)
+}
+
+// crdPipelineName makes the pipeline name usable in metric names.
+func crdPipelineName(namespace string, name string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(namespace + "_" + name)
+}
+
+// validatePipeline checks the pipeline config without failing unlike the pipeline creation,
+// so a wrong resource doesn't crash file.d.
+func (f *FileD) validatePipeline(raw []byte) error {
+	config, err := simplejson.NewJson(raw)
+	if err != nil {
+		return err
+	}
+
+	settings, err := extractPipelineParams(config.Get("settings"))
+	if err != nil {
+		return fmt.Errorf("wrong settings: %w", err)
+	}
+	values := pipelineValues(settings)
+
+	if err := f.validatePlugin(pipeline.PluginKindInput, config.Get("input"), values); err != nil {
+		return err
+	}
+	if electionJSON := config.Get("input").Get("leader_election"); electionJSON.MustMap() != nil {
+		if _, err := parseElectionConfig(electionJSON, values); err != nil {
+			return fmt.Errorf("wrong leader election config: %w", err)
+		}
+	}
+
+	actions := config.Get("actions")
+	for index := range actions.MustArray() {
+		actionJSON := actions.GetIndex(index)
+		if _, err := extractMatchMode(actionJSON); err != nil {
+			return fmt.Errorf("action #%d: %w", index, err)
+		}
+		if _, err := extractConditions(actionJSON.Get("match_fields")); err != nil {
+			return fmt.Errorf("action #%d: %w", index, err)
+		}
+		if _, err := extractActionTimeout(actionJSON); err != nil {
+			return fmt.Errorf("action #%d: %w", index, err)
+		}
+		if err := f.validatePlugin(pipeline.PluginKindAction, actionJSON, values); err != nil {
+			return fmt.Errorf("action #%d: %w", index, err)
+		}
+	}
+
+	outputs, err := outputConfigs(config)
+	if err != nil {
+		return err
+	}
+	outputIDs := make(map[string]bool)
+	for index, outputJSON := range outputs {
+		outputIDs[outputJSON.Get("id").MustString()] = true
+		if _, err := extractMatchMode(outputJSON); err != nil {
+			return fmt.Errorf("output #%d: %w", index, err)
+		}
+		if _, err := extractConditions(outputJSON.Get("match_fields")); err != nil {
+			return fmt.Errorf("output #%d: %w", index, err)
+		}
+		if err := f.validatePlugin(pipeline.PluginKindOutput, outputJSON, values); err != nil {
+			return fmt.Errorf("output #%d: %w", index, err)
+		}
+	}
+
+	routes, err := extractRoutes(config.Get("routes"))
+	if err != nil {
+		return err
+	}
+	for index, route := range routes {
+		for _, id := range route.Outputs {
+			if !outputIDs[id] {
+				return fmt.Errorf("route #%d: unknown output id %q", index, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *FileD) validatePlugin(kind pipeline.PluginKind, configJSON *simplejson.Json, values map[string]int) error {
+	if configJSON.MustMap() == nil {
+		return fmt.Errorf("no %s plugin provided", kind)
+	}
+
+	t := configJSON.Get("type").MustString()
+	if t == "" {
+		return fmt.Errorf("%s doesn't have type", kind)
+	}
+
+	info := f.plugins.Find(kind, t)
+	if info == nil {
+		return fmt.Errorf("unknown %s plugin %q", kind, t)
+	}
+
+	var configBytes []byte
+	if kind == pipeline.PluginKindAction {
+		configBytes = makeActionJSON(configJSON)
+	} else {
+		encoded, err := configJSON.Encode()
+		if err != nil {
+			return err
+		}
+		configBytes = encoded
+	}
+
+	_, config := info.Factory()
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return fmt.Errorf("can't unmarshal config for %s %q: %w", kind, t, err)
+	}
+	if err := cfg.Parse(config, values); err != nil {
+		return fmt.Errorf("wrong config for %s %q: %w", kind, t, err)
+	}
+
+	return nil
+}
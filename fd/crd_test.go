@@ -0,0 +1,155 @@
+package fd
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeConfig struct {
+	Field string `json:"field" required:"true"`
+}
+
+func newFakeFileD() *FileD {
+	registry := &PluginRegistry{plugins: make(map[string]*pipeline.PluginStaticInfo)}
+	for _, kind := range []pipeline.PluginKind{pipeline.PluginKindInput, pipeline.PluginKindAction, pipeline.PluginKindOutput} {
+		registry.plugins[registry.MakeID(kind, "fake")] = &pipeline.PluginStaticInfo{
+			Type: "fake",
+			Factory: func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+				return nil, &fakeConfig{}
+			},
+		}
+	}
+
+	config := cfg.NewConfig()
+	config.Pipelines["default_static"] = &cfg.PipelineConfig{}
+
+	return &FileD{config: config, plugins: registry, mu: &sync.Mutex{}}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	f := newFakeFileD()
+
+	tests := []struct {
+		name string
+		spec string
+		err  bool
+	}{
+		{
+			name: "ok",
+			spec: `{"input":{"type":"fake","field":"1"},"actions":[{"type":"fake","field":"2","match_fields":{"k8s_pod":"/^api-/"}}],"output":{"type":"fake","field":"3"}}`,
+		},
+		{
+			name: "no_input",
+			spec: `{"output":{"type":"fake","field":"3"}}`,
+			err:  true,
+		},
+		{
+			name: "unknown_output",
+			spec: `{"input":{"type":"fake","field":"1"},"output":{"type":"unknown"}}`,
+			err:  true,
+		},
+		{
+			name: "wrong_config",
+			spec: `{"input":{"type":"fake"},"output":{"type":"fake","field":"3"}}`,
+			err:  true,
+		},
+		{
+			name: "wrong_match_mode",
+			spec: `{"input":{"type":"fake","field":"1"},"actions":[{"type":"fake","field":"2","match_mode":"xor"}],"output":{"type":"fake","field":"3"}}`,
+			err:  true,
+		},
+		{
+			name: "routed_outputs",
+			spec: `{"input":{"type":"fake","field":"1"},"outputs":[{"type":"fake","field":"3","match_fields":{"level":"error"}},{"type":"fake","field":"3","match_fields":{"level":"error"},"match_invert":true}]}`,
+		},
+		{
+			name: "wrong_output_match_mode",
+			spec: `{"input":{"type":"fake","field":"1"},"outputs":[{"type":"fake","field":"3","match_mode":"xor"}]}`,
+			err:  true,
+		},
+		{
+			name: "wrong_settings",
+			spec: `{"settings":{"maintenance_interval":"1 minute"},"input":{"type":"fake","field":"1"},"output":{"type":"fake","field":"3"}}`,
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := f.validatePipeline([]byte(tt.spec))
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCRDPipelineName(t *testing.T) {
+	c := &crdController{
+		fileD:     newFakeFileD(),
+		mu:        &sync.Mutex{},
+		pipelines: map[string]*crdPipeline{"team_a_logs": {key: "team-a/logs"}},
+	}
+
+	assert.Equal(t, "team_a_api_logs", crdPipelineName("team-a", "api.logs"))
+
+	assert.NoError(t, c.check("team-a/logs", "team_a_logs"))
+	assert.Error(t, c.check("team/a-logs", "team_a_logs"), "names of different resources shouldn't collide")
+	assert.Error(t, c.check("default/static", "default_static"), "pipelines from the config should have precedence")
+}
+
+func newResource(namespace string, name string, spec string) *FileDPipeline {
+	return &FileDPipeline{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: 1},
+		Spec:       json.RawMessage(spec),
+	}
+}
+
+func TestCRDApplyStatus(t *testing.T) {
+	c := newCRDController(newReloadFileD(), &CRDPolicy{Namespaces: []string{"team-a"}})
+	defer c.stop()
+
+	statuses := make(map[string]FileDPipelineStatus)
+	c.updateStatus = func(obj *FileDPipeline) error {
+		statuses[obj.GetNamespace()+"/"+obj.GetName()] = obj.Status
+		return nil
+	}
+
+	c.apply(newResource("team-a", "logs", `{"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`))
+	assert.True(t, c.has("team_a_logs"), "pipeline isn't created")
+	assert.Equal(t, FileDPipelineStatus{ObservedGeneration: 1, Pipeline: "team_a_logs"}, statuses["team-a/logs"])
+
+	c.apply(newResource("team-b", "logs", `{"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`))
+	assert.False(t, c.has("team_b_logs"), "pipeline of the namespace which isn't allowed is created")
+	assert.Contains(t, statuses["team-b/logs"].Error, "namespace", "error isn't reported")
+
+	c.apply(newResource("team-a", "files", `{"input":{"type":"file","field":"1"},"output":{"type":"stub","field":"1"}}`))
+	assert.False(t, c.has("team_a_files"), "pipeline with the restricted plugin is created")
+	assert.Contains(t, statuses["team-a/files"].Error, "input/file", "error isn't reported")
+
+	c.apply(newResource("team-a", "wrong", `{"settings":{"decoder":"unknown"},"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`))
+	assert.False(t, c.has("team_a_wrong"), "pipeline with wrong settings is created")
+	assert.NotEmpty(t, statuses["team-a/wrong"].Error, "error isn't reported")
+}
+
+func TestCRDCheckPlugins(t *testing.T) {
+	nested := `{"input":{"type":"kafka"},"output":{"type":"failover","outputs":[{"output":{"type":"kafka"}},{"output":{"type":"file"}}]}}`
+	config, err := simplejson.NewJson([]byte(nested))
+	require.NoError(t, err)
+
+	c := newCRDController(newFakeFileD(), nil)
+	assert.Error(t, c.checkPlugins(config), "nested restricted output is allowed")
+
+	c = newCRDController(newFakeFileD(), &CRDPolicy{Plugins: []string{"output/file"}})
+	assert.NoError(t, c.checkPlugins(config), "allowed plugin is rejected")
+}
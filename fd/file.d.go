@@ -1,20 +1,27 @@
 package fd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
 	"runtime"
 	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bitly/go-simplejson"
 	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/leader"
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/longpanic"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 type FileD struct {
@@ -23,7 +30,22 @@ type FileD struct {
 	registry  *prometheus.Registry
 	plugins   *PluginRegistry
 	Pipelines []*pipeline.Pipeline
-	server    *http.Server
+	crd       *crdController
+
+	// metricsAddr and pprofAddr are listen addresses of metrics and pprof endpoints, empty means they're served on httpAddr
+	metricsAddr string
+	pprofAddr   string
+	servers     []*http.Server
+	// auditPath is the file for state-changing admin requests, they aren't audited if it's empty
+	auditPath string
+	audit     *auditLog
+
+	// configPath is the file to reload the config from, the reload isn't supported if it's empty
+	configPath string
+	// reloadMu serializes reloads, mu guards pipelines of the config
+	reloadMu *sync.Mutex
+	mu       *sync.Mutex
+	static   map[string]*staticPipeline
 }
 
 func New(config *cfg.Config, httpAddr string) *FileD {
@@ -32,6 +54,9 @@ func New(config *cfg.Config, httpAddr string) *FileD {
 		httpAddr:  httpAddr,
 		plugins:   DefaultPluginRegistry,
 		Pipelines: make([]*pipeline.Pipeline, 0, 0),
+		reloadMu:  &sync.Mutex{},
+		mu:        &sync.Mutex{},
+		static:    make(map[string]*staticPipeline),
 	}
 }
 
@@ -39,10 +64,40 @@ func (f *FileD) SetConfig(config *cfg.Config) {
 	f.config = config
 }
 
+// SetMetricsAddr sets the listen address of the `/metrics` endpoint, "off" disables it.
+// Metrics are served on the http address if it isn't set.
+func (f *FileD) SetMetricsAddr(addr string) {
+	f.metricsAddr = addr
+}
+
+// SetPprofAddr sets the listen address of `/debug/pprof/` endpoints, "off" disables them.
+// Profiles are served on the http address if it isn't set.
+func (f *FileD) SetPprofAddr(addr string) {
+	f.pprofAddr = addr
+}
+
+// SetAuditLog sets the append-only file for state-changing requests to admin endpoints.
+func (f *FileD) SetAuditLog(path string) {
+	f.auditPath = path
+}
+
+// SetConfigPath sets the file the config is read from to reload it by `ReloadFromFile` or the `/reload` endpoint.
+func (f *FileD) SetConfigPath(path string) {
+	f.configPath = path
+}
+
 func (f *FileD) Start() {
 	logger.Infof("starting file.d")
 
 	f.createRegistry()
+	f.setupPanicSinks()
+	if f.auditPath != "" {
+		audit, err := openAuditLog(f.auditPath)
+		if err != nil {
+			logger.Fatalf("%s", err.Error())
+		}
+		f.audit = audit
+	}
 	f.startHTTP()
 	f.startPipelines()
 }
@@ -52,46 +107,125 @@ func (f *FileD) createRegistry() {
 	f.registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
 	f.registry.MustRegister(prometheus.NewGoCollector())
 
-	prometheus.DefaultGatherer = f.registry
+	prometheus.DefaultGatherer = prometheus.GathererFunc(f.gather)
 	prometheus.DefaultRegisterer = f.registry
 }
 
+func (f *FileD) setupPanicSinks() {
+	sinks := make([]longpanic.Sink, 0, len(f.config.Panic.Sinks))
+	for _, sink := range f.config.Panic.Sinks {
+		switch sink {
+		case "log":
+			sinks = append(sinks, longpanic.LogSink{})
+		case "webhook":
+			sinks = append(sinks, longpanic.NewWebhookSink(f.config.Panic.WebhookURL, f.config.Panic.WebhookTimeout))
+		case "metric":
+			sinks = append(sinks, longpanic.NewMetricSink(f.registry))
+		}
+	}
+
+	longpanic.SetSinks(sinks...)
+	longpanic.SetRestart(f.config.Panic.Restart)
+}
+
+// gather collects metrics of static pipelines and pipelines created from custom resources,
+// each pipeline from custom resource has its own registry to drop its metrics when it's removed.
+func (f *FileD) gather() ([]*dto.MetricFamily, error) {
+	gatherers := prometheus.Gatherers{f.registry}
+	gatherers = append(gatherers, f.staticGatherers()...)
+	if f.crd != nil {
+		gatherers = append(gatherers, f.crd.gatherers()...)
+	}
+
+	return gatherers.Gather()
+}
+
 func (f *FileD) startPipelines() {
-	f.Pipelines = f.Pipelines[:0]
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
+	static := make(map[string]*staticPipeline, len(f.config.Pipelines))
 	for name, config := range f.config.Pipelines {
-		f.addPipeline(name, config)
+		raw, err := config.Raw.Encode()
+		if err != nil {
+			logger.Fatalf("can't encode config of pipeline %q: %s", name, err.Error())
+		}
+		p, err := f.newStaticPipeline(name, config, raw)
+		if err != nil {
+			logger.Fatalf("can't create pipeline %q: %s", name, err.Error())
+		}
+		static[name] = p
 	}
-	for _, p := range f.Pipelines {
-		p.Start()
+	for _, p := range static {
+		p.pipeline.Start()
 	}
+
+	f.mu.Lock()
+	f.static = static
+	f.updatePipelines()
+	f.mu.Unlock()
 }
 
-func (f *FileD) addPipeline(name string, config *cfg.PipelineConfig) {
-	mux := http.DefaultServeMux
-	settings := extractPipelineParams(config.Raw.Get("settings"))
+// newPipeline creates the pipeline without starting it, so a wrong config doesn't affect running pipelines.
+func (f *FileD) newPipeline(name string, config *cfg.PipelineConfig, registry *prometheus.Registry) (*pipeline.Pipeline, error) {
+	settings, err := extractPipelineParams(config.Raw.Get("settings"))
+	if err != nil {
+		return nil, fmt.Errorf("wrong settings: %w", err)
+	}
+	values := pipelineValues(settings)
+
+	logger.Infof("creating pipeline %q: capacity=%d, stream field=%s, decoder=%s", name, settings.Capacity, settings.StreamField, settings.Decoder)
+
+	p := pipeline.New(name, settings, registry)
+	if err := f.setupInput(p, config, values); err != nil {
+		return nil, err
+	}
+	if err := f.setupActions(p, config, values); err != nil {
+		return nil, err
+	}
+	if err := f.setupOutput(p, config, values); err != nil {
+		return nil, err
+	}
+
+	if _, has := config.Raw.CheckGet("routes"); has {
+		routes, err := extractRoutes(config.Raw.Get("routes"))
+		if err != nil {
+			return nil, err
+		}
+		if err := p.SetRoutes(routes); err != nil {
+			return nil, fmt.Errorf("can't set routes: %w", err)
+		}
+	}
+
+	return p, nil
+}
 
-	values := map[string]int{
+// pipelineValues are values which may be used in plugin configs, e.g. `workers_count: gomaxprocs*2`.
+func pipelineValues(settings *pipeline.Settings) map[string]int {
+	return map[string]int{
 		"capacity":   settings.Capacity,
 		"gomaxprocs": runtime.GOMAXPROCS(0),
 	}
+}
 
-	logger.Infof("creating pipeline %q: capacity=%d, stream field=%s, decoder=%s", name, settings.Capacity, settings.StreamField, settings.Decoder)
+// InputConfig returns the parsed config of the pipeline input without creating the pipeline,
+// e.g. to find files with the state of the input.
+func (f *FileD) InputConfig(pipelineName string) (pipeline.AnyConfig, error) {
+	pipelineConfig, has := f.config.Pipelines[pipelineName]
+	if !has {
+		return nil, fmt.Errorf("pipeline %q isn't found in the config", pipelineName)
+	}
 
-	p := pipeline.New(name, settings, f.registry)
-	err := f.setupInput(p, config, values)
+	settings, err := extractPipelineParams(pipelineConfig.Raw.Get("settings"))
 	if err != nil {
-		logger.Fatalf("can't create pipeline %q: %s", name, err.Error())
+		return nil, err
 	}
-
-	f.setupActions(p, config, values)
-
-	err = f.setupOutput(p, config, values)
+	info, err := f.getStaticInfo(pipelineConfig, pipeline.PluginKindInput, pipelineValues(settings))
 	if err != nil {
-		logger.Fatalf("can't create pipeline %q: %s", name, err.Error())
+		return nil, err
 	}
 
-	p.SetupHTTPHandlers(mux)
-	f.Pipelines = append(f.Pipelines, p)
+	return info.Config, nil
 }
 
 func (f *FileD) setupInput(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineConfig, values map[string]int) error {
@@ -100,9 +234,19 @@ func (f *FileD) setupInput(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineCon
 		return err
 	}
 
+	runtimeInfo := f.instantiatePlugin(inputInfo)
+	electionJSON := pipelineConfig.Raw.Get("input").Get("leader_election")
+	if electionJSON.MustMap() != nil {
+		elector, err := f.newElector(electionJSON, values)
+		if err != nil {
+			return fmt.Errorf("wrong leader election config: %w", err)
+		}
+		runtimeInfo.Plugin = newLeaderInput(inputInfo, elector)
+	}
+
 	p.SetInput(&pipeline.InputPluginInfo{
 		PluginStaticInfo:  inputInfo,
-		PluginRuntimeInfo: f.instantiatePlugin(inputInfo),
+		PluginRuntimeInfo: runtimeInfo,
 	})
 
 	for _, actionType := range inputInfo.AdditionalActions {
@@ -121,50 +265,91 @@ func (f *FileD) setupInput(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineCon
 	return nil
 }
 
-func (f *FileD) setupActions(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineConfig, values map[string]int) {
+func (f *FileD) newElector(electionJSON *simplejson.Json, values map[string]int) (leader.Elector, error) {
+	config, err := parseElectionConfig(electionJSON, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return leader.New(config, getKubeConfig)
+}
+
+func parseElectionConfig(electionJSON *simplejson.Json, values map[string]int) (*leader.Config, error) {
+	configJSON, err := electionJSON.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &leader.Config{}
+	err = json.Unmarshal(configJSON, config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Parse(config, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (f *FileD) setupActions(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineConfig, values map[string]int) error {
 	actions := pipelineConfig.Raw.Get("actions")
 	for index := range actions.MustArray() {
 		actionJSON := actions.GetIndex(index)
 		if actionJSON.MustMap() == nil {
-			logger.Fatalf("empty action #%d for pipeline %q", index, p.Name)
+			return fmt.Errorf("empty action #%d", index)
 		}
 
 		t := actionJSON.Get("type").MustString()
 		if t == "" {
-			logger.Fatalf("action #%d doesn't provide type %q", index, p.Name)
+			return fmt.Errorf("action #%d doesn't provide type", index)
+		}
+		if err := f.setupAction(p, index, t, actionJSON, values); err != nil {
+			return err
 		}
-		f.setupAction(p, index, t, actionJSON, values)
 	}
+
+	return nil
 }
 
-func (f *FileD) setupAction(p *pipeline.Pipeline, index int, t string, actionJSON *simplejson.Json, values map[string]int) {
+func (f *FileD) setupAction(p *pipeline.Pipeline, index int, t string, actionJSON *simplejson.Json, values map[string]int) error {
 	logger.Infof("creating action with type %q for pipeline %q", t, p.Name)
-	info := f.plugins.GetActionByType(t)
+	info := f.plugins.Find(pipeline.PluginKindAction, t)
+	if info == nil {
+		return fmt.Errorf("unknown action plugin %q", t)
+	}
 
 	matchMode, err := extractMatchMode(actionJSON)
 	if err != nil {
-		logger.Fatalf("can't extract match mode for action %d/%s in pipeline %q: %s", index, t, p.Name, err.Error())
+		return fmt.Errorf("can't extract match mode for action %d/%s: %w", index, t, err)
 	}
 	matchInvert, err := extractMatchInvert(actionJSON)
 	if err != nil {
-		logger.Fatalf("can't extract invert match mode for action %d/%s in pipeline %q: %s", index, t, p.Name, err.Error())
+		return fmt.Errorf("can't extract invert match mode for action %d/%s: %w", index, t, err)
 	}
 	conditions, err := extractConditions(actionJSON.Get("match_fields"))
 	if err != nil {
-		logger.Fatalf("can't extract conditions for action %d/%s in pipeline %q: %s", index, t, p.Name, err.Error())
+		return fmt.Errorf("can't extract conditions for action %d/%s: %w", index, t, err)
 	}
-	metricName, metricLabels := extractMetrics(actionJSON)
+	metricName, metricLabels, metricMaxLabelValues := extractMetrics(actionJSON)
+	timeout, err := extractActionTimeout(actionJSON)
+	if err != nil {
+		return fmt.Errorf("can't extract timeout for action %d/%s: %w", index, t, err)
+	}
+	skipOnTimeout := actionJSON.Get("action_timeout_skip").MustBool()
 	configJSON := makeActionJSON(actionJSON)
 
 	_, config := info.Factory()
 	err = json.Unmarshal(configJSON, config)
 	if err != nil {
-		logger.Fatalf("can't unmarshal config for %s action in pipeline %q: %s", info.Type, p.Name, err.Error())
+		return fmt.Errorf("can't unmarshal config for %s action: %w", info.Type, err)
 	}
 
 	err = cfg.Parse(config, values)
 	if err != nil {
-		logger.Fatalf("wrong config for %q action in pipeline %q: %s", info.Type, p.Name, err.Error())
+		return fmt.Errorf("wrong config for %q action: %w", info.Type, err)
 	}
 
 	infoCopy := *info
@@ -172,29 +357,100 @@ func (f *FileD) setupAction(p *pipeline.Pipeline, index int, t string, actionJSO
 	infoCopy.Type = t
 
 	p.AddAction(&pipeline.ActionPluginStaticInfo{
-		PluginStaticInfo: &infoCopy,
-		MatchConditions:  conditions,
-		MatchMode:        matchMode,
-		MetricName:       metricName,
-		MetricLabels:     metricLabels,
-		MatchInvert:      matchInvert,
+		PluginStaticInfo:     &infoCopy,
+		MatchConditions:      conditions,
+		MatchMode:            matchMode,
+		MetricName:           metricName,
+		MetricLabels:         metricLabels,
+		MetricMaxLabelValues: metricMaxLabelValues,
+		MatchInvert:          matchInvert,
+		Timeout:              timeout,
+		SkipOnTimeout:        skipOnTimeout,
 	})
+
+	return nil
 }
 
 func (f *FileD) setupOutput(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineConfig, values map[string]int) error {
-	info, err := f.getStaticInfo(pipelineConfig, pipeline.PluginKindOutput, values)
+	outputs, err := outputConfigs(pipelineConfig.Raw)
 	if err != nil {
 		return err
 	}
 
-	p.SetOutput(&pipeline.OutputPluginInfo{
-		PluginStaticInfo:  info,
-		PluginRuntimeInfo: f.instantiatePlugin(info),
-	})
+	for _, outputJSON := range outputs {
+		info, err := f.getPluginStaticInfo(pipeline.PluginKindOutput, outputJSON, values)
+		if err != nil {
+			return err
+		}
+
+		controlChars, err := pipeline.ParseControlCharsMode(outputJSON.Get("control_chars").MustString())
+		if err != nil {
+			return err
+		}
+		serviceFields, err := pipeline.ParseServiceFieldsMode(outputJSON.Get("service_fields").MustString())
+		if err != nil {
+			return err
+		}
+
+		matchMode, err := extractMatchMode(outputJSON)
+		if err != nil {
+			return fmt.Errorf("can't extract match mode for output %s: %w", info.Type, err)
+		}
+		matchInvert, err := extractMatchInvert(outputJSON)
+		if err != nil {
+			return fmt.Errorf("can't extract invert match mode for output %s: %w", info.Type, err)
+		}
+		conditions, err := extractConditions(outputJSON.Get("match_fields"))
+		if err != nil {
+			return fmt.Errorf("can't extract conditions for output %s: %w", info.Type, err)
+		}
+		chaos := (*netutil.Chaos)(nil)
+		if _, has := outputJSON.CheckGet("chaos"); has {
+			chaos, err = extractChaos(outputJSON.Get("chaos"))
+			if err != nil {
+				return fmt.Errorf("wrong chaos for output %s: %w", info.Type, err)
+			}
+			logger.Warnf("chaos is enabled for %q output of pipeline %q, failures are injected into its connections", info.Type, p.Name)
+		}
+
+		p.AddOutput(&pipeline.OutputPluginInfo{
+			PluginStaticInfo:    info,
+			PluginRuntimeInfo:   f.instantiatePlugin(info),
+			ControlChars:        controlChars,
+			ServiceFields:       serviceFields,
+			BatchPartitionField: outputJSON.Get("batch_partition_field").MustString(),
+			LowLatency:          outputJSON.Get("low_latency").MustBool(),
+			Chaos:               chaos,
+			OutputID:            outputJSON.Get("id").MustString(),
+			MatchConditions:     conditions,
+			MatchMode:           matchMode,
+			MatchInvert:         matchInvert,
+		})
+	}
 
 	return nil
 }
 
+// outputConfigs returns either the `output` config or configs of the `outputs` list.
+func outputConfigs(pipelineJSON *simplejson.Json) ([]*simplejson.Json, error) {
+	outputs := pipelineJSON.Get("outputs")
+	count := len(outputs.MustArray())
+	if count == 0 {
+		return []*simplejson.Json{pipelineJSON.Get("output")}, nil
+	}
+
+	if _, has := pipelineJSON.CheckGet("output"); has {
+		return nil, fmt.Errorf("both output and outputs are set")
+	}
+
+	configs := make([]*simplejson.Json, 0, count)
+	for i := 0; i < count; i++ {
+		configs = append(configs, outputs.GetIndex(i))
+	}
+
+	return configs, nil
+}
+
 func (f *FileD) instantiatePlugin(info *pipeline.PluginStaticInfo) *pipeline.PluginRuntimeInfo {
 	plugin, _ := info.Factory()
 	return &pipeline.PluginRuntimeInfo{
@@ -204,7 +460,10 @@ func (f *FileD) instantiatePlugin(info *pipeline.PluginStaticInfo) *pipeline.Plu
 }
 
 func (f *FileD) getStaticInfo(pipelineConfig *cfg.PipelineConfig, pluginKind pipeline.PluginKind, values map[string]int) (*pipeline.PluginStaticInfo, error) {
-	configJSON := pipelineConfig.Raw.Get(string(pluginKind))
+	return f.getPluginStaticInfo(pluginKind, pipelineConfig.Raw.Get(string(pluginKind)), values)
+}
+
+func (f *FileD) getPluginStaticInfo(pluginKind pipeline.PluginKind, configJSON *simplejson.Json, values map[string]int) (*pipeline.PluginStaticInfo, error) {
 	if configJSON.MustMap() == nil {
 		return nil, fmt.Errorf("no %s plugin provided", pluginKind)
 	}
@@ -214,7 +473,10 @@ func (f *FileD) getStaticInfo(pipelineConfig *cfg.PipelineConfig, pluginKind pip
 	}
 
 	logger.Infof("creating %s with type %q", pluginKind, t)
-	info := f.plugins.Get(pluginKind, t)
+	info := f.plugins.Find(pluginKind, t)
+	if info == nil {
+		return nil, fmt.Errorf("unknown %s plugin %q", pluginKind, t)
+	}
 	configJson, err := configJSON.Encode()
 	if err != nil {
 		logger.Panicf("can't create config json for %s", t)
@@ -228,7 +490,7 @@ func (f *FileD) getStaticInfo(pipelineConfig *cfg.PipelineConfig, pluginKind pip
 
 	err = cfg.Parse(config, values)
 	if err != nil {
-		logger.Fatalf("wrong config for %q plugin %q: %s", pluginKind, t, err.Error())
+		return nil, fmt.Errorf("wrong config for %q plugin %q: %w", pluginKind, t, err)
 	}
 
 	infoCopy := *info
@@ -237,34 +499,130 @@ func (f *FileD) getStaticInfo(pipelineConfig *cfg.PipelineConfig, pluginKind pip
 	return &infoCopy, nil
 }
 
+// SelfTest passes a marker event through every pipeline to its output concurrently
+// and returns an error if some pipeline isn't able to deliver it in the timeout.
+func (f *FileD) SelfTest(timeout time.Duration) error {
+	errs := make([]error, len(f.Pipelines))
+	wg := &sync.WaitGroup{}
+	for i, p := range f.Pipelines {
+		wg.Add(1)
+		go func(i int, p *pipeline.Pipeline) {
+			defer wg.Done()
+			errs[i] = p.SelfTest(timeout)
+		}(i, p)
+	}
+	wg.Wait()
+
+	failed := make([]string, 0)
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("pipeline %q: %s", f.Pipelines[i].Name, err.Error()))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("self test failed: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
 func (f *FileD) Stop() {
+	f.stop()
+}
+
+// stop returns false if some of static pipelines haven't committed all events in their drain timeout.
+func (f *FileD) stop() bool {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
 	logger.Infof("stopping pipelines=%d", len(f.Pipelines))
-	_ = f.server.Shutdown(nil)
-	for _, p := range f.Pipelines {
-		p.Stop()
+	for _, server := range f.servers {
+		_ = server.Shutdown(context.Background())
+	}
+	if f.crd != nil {
+		f.crd.stop()
+	}
+
+	f.mu.Lock()
+	static := f.static
+	f.static = make(map[string]*staticPipeline)
+	f.updatePipelines()
+	f.mu.Unlock()
+
+	isDrained := true
+	for _, p := range static {
+		p.pipeline.Stop()
+		if p.pipeline.DrainTimedOut() {
+			isDrained = false
+		}
 	}
+
+	if f.audit != nil {
+		f.audit.close()
+	}
+
+	return isDrained
 }
 
 func (f *FileD) startHTTP() {
-	if f.httpAddr == "off" {
-		return
+	for addr, mux := range f.newHTTPMuxes() {
+		server := &http.Server{Addr: addr, Handler: mux}
+		f.servers = append(f.servers, server)
+		longpanic.Go(func() { f.listenHTTP(server) })
 	}
+}
 
-	mux := http.DefaultServeMux
+// newHTTPMuxes returns handlers by listen addresses. Admin endpoints are served on the http address,
+// metrics and profiles may be served on their own addresses, e.g. to expose metrics cluster-wide
+// but keep admin endpoints on localhost. Endpoints with the same address share the server.
+func (f *FileD) newHTTPMuxes() map[string]*http.ServeMux {
+	muxes := make(map[string]*http.ServeMux)
+	getMux := func(addr string) *http.ServeMux {
+		if addr == "" {
+			addr = f.httpAddr
+		}
+		if addr == "off" {
+			return nil
+		}
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+
+	// metrics and profiles have more specific patterns, so other requests get to admin endpoints
+	if mux := getMux(f.httpAddr); mux != nil {
+		admin := http.NewServeMux()
+		admin.HandleFunc("/live", f.serveLiveReady)
+		admin.HandleFunc("/ready", f.serveReady)
+		admin.HandleFunc("/freeosmem", f.serveFreeOsMem)
+		admin.HandleFunc("/reload", f.serveReload)
+		admin.HandleFunc("/pipelines/", f.servePipeline)
+		mux.Handle("/", f.serveAdmin(admin))
+	}
+
+	if mux := getMux(f.metricsAddr); mux != nil {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
 
-	mux.HandleFunc("/live", f.serveLiveReady)
-	mux.HandleFunc("/ready", f.serveLiveReady)
-	mux.HandleFunc("/freeosmem", f.serveFreeOsMem)
-	mux.Handle("/metrics", promhttp.Handler())
+	if mux := getMux(f.pprofAddr); mux != nil {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
 
-	f.server = &http.Server{Addr: f.httpAddr, Handler: mux}
-	longpanic.Go(f.listenHTTP)
+	return muxes
 }
 
-func (f *FileD) listenHTTP() {
-	err := f.server.ListenAndServe()
-	if err != nil {
-		logger.Fatalf("http listening error address=%q: %s", f.httpAddr, err.Error())
+func (f *FileD) listenHTTP(server *http.Server) {
+	listener, err := netutil.Listen("tcp", server.Addr)
+	if err == nil {
+		err = server.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		logger.Fatalf("http listening error address=%q: %s", server.Addr, err.Error())
 	}
 }
 
@@ -277,6 +635,28 @@ func (f *FileD) serveLiveReady(_ http.ResponseWriter, _ *http.Request) {
 	logger.Infof("live/ready OK")
 }
 
+// serveReady responds with 503 until all pipelines from the config are started and their plugins are ready.
+func (f *FileD) serveReady(w http.ResponseWriter, _ *http.Request) {
+	f.mu.Lock()
+	pipelines := f.Pipelines
+	// pipelines of the config aren't created yet on the start
+	isStarted := len(f.static) == len(f.config.Pipelines)
+	f.mu.Unlock()
+
+	if !isStarted {
+		http.Error(w, "pipelines aren't started", http.StatusServiceUnavailable)
+		return
+	}
+	for _, p := range pipelines {
+		if !p.IsReady() {
+			http.Error(w, fmt.Sprintf("pipeline %q isn't ready", p.Name), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	logger.Infof("ready OK")
+}
+
 func (f *FileD) servePipelines(_ http.ResponseWriter, _ *http.Request) {
 	logger.Infof("pipelines OK")
 }
@@ -0,0 +1,71 @@
+package fd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMuxes(t *testing.T) {
+	tests := []struct {
+		name        string
+		metricsAddr string
+		pprofAddr   string
+		// endpoints by addresses which should be served
+		served map[string][]string
+	}{
+		{
+			name: "shared",
+			served: map[string][]string{
+				":9000": {"/live", "/metrics", "/debug/pprof/"},
+			},
+		},
+		{
+			name:        "separate",
+			metricsAddr: ":9001",
+			pprofAddr:   "127.0.0.1:6060",
+			served: map[string][]string{
+				":9000":          {"/live"},
+				":9001":          {"/metrics"},
+				"127.0.0.1:6060": {"/debug/pprof/"},
+			},
+		},
+		{
+			name:        "off",
+			metricsAddr: "0.0.0.0:9001",
+			pprofAddr:   "off",
+			served: map[string][]string{
+				":9000":        {"/live"},
+				"0.0.0.0:9001": {"/metrics"},
+			},
+		},
+	}
+
+	all := []string{"/live", "/metrics", "/debug/pprof/"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(cfg.NewConfig(), ":9000")
+			f.SetMetricsAddr(tt.metricsAddr)
+			f.SetPprofAddr(tt.pprofAddr)
+
+			muxes := f.newHTTPMuxes()
+			assert.Len(t, muxes, len(tt.served), "wrong servers count")
+
+			for addr, mux := range muxes {
+				served := make(map[string]bool)
+				for _, path := range tt.served[addr] {
+					served[path] = true
+				}
+
+				for _, path := range all {
+					w := httptest.NewRecorder()
+					mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+					assert.Equal(t, served[path], w.Code == http.StatusOK, "wrong serving of %s on %s", path, addr)
+				}
+			}
+		})
+	}
+}
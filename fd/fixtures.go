@@ -0,0 +1,174 @@
+package fd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/logger"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	fixtureInputExt    = ".input"
+	fixtureExpectedExt = ".expected"
+)
+
+// FixtureResult is the result of running fixture lines through actions of the pipeline.
+type FixtureResult struct {
+	Pipeline string
+	// Diffs describe documents which differ from the expected ones
+	Diffs []string
+	// Err is set if the fixture can't be run
+	Err error
+}
+
+func (r *FixtureResult) IsPassed() bool {
+	return r.Err == nil && len(r.Diffs) == 0
+}
+
+// TestFixtures runs fixtures of pipelines in-process and compares output documents with the expected ones.
+// The fixture of the pipeline is the pair of files in the dir: `<pipeline_name>.input` with input lines
+// and `<pipeline_name>.expected` with the expected output documents, one JSON document per line.
+// Lines are decoded by the decoder of the pipeline and are passed through its actions,
+// the input and the output of the pipeline aren't created. Pipelines without fixtures are skipped.
+func (f *FileD) TestFixtures(dir string, timeout time.Duration) ([]*FixtureResult, error) {
+	names := make([]string, 0, len(f.config.Pipelines))
+	for name := range f.config.Pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]*FixtureResult, 0)
+	for _, name := range names {
+		inputPath := filepath.Join(dir, name+fixtureInputExt)
+		if _, err := os.Stat(inputPath); os.IsNotExist(err) {
+			logger.Infof("pipeline %q doesn't have fixtures, skipping", name)
+			continue
+		}
+
+		result := &FixtureResult{Pipeline: name}
+		result.Diffs, result.Err = f.runFixture(name, inputPath, filepath.Join(dir, name+fixtureExpectedExt), timeout)
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("there are no fixtures of pipelines in %s", dir)
+	}
+
+	return results, nil
+}
+
+func (f *FileD) runFixture(name string, inputPath string, expectedPath string, timeout time.Duration) ([]string, error) {
+	input, err := os.ReadFile(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't read input lines: %w", err)
+	}
+	expectedData, err := os.ReadFile(expectedPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't read expected documents: %w", err)
+	}
+	expected := nonEmptyLines(expectedData)
+
+	// setting up actions modifies their configs, so the config is copied
+	raw, err := f.config.Pipelines[name].Raw.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("can't encode pipeline config: %w", err)
+	}
+	rawCopy, err := simplejson.NewJson(raw)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode pipeline config: %w", err)
+	}
+	config := &cfg.PipelineConfig{Raw: rawCopy}
+
+	settings, err := extractPipelineParams(config.Raw.Get("settings"))
+	if err != nil {
+		return nil, fmt.Errorf("wrong settings: %w", err)
+	}
+	// fixtures shouldn't touch files of the running file.d
+	settings.DeadLetterFile = ""
+	if settings.DecodeErrors == pipeline.DecodeErrorsDeadLetter {
+		settings.DecodeErrors = pipeline.DecodeErrorsSkip
+	}
+	settings.SpoolDir = ""
+	values := map[string]int{
+		"capacity":   settings.Capacity,
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+	}
+
+	p := pipeline.New(name, settings, prometheus.NewRegistry())
+	p.SetFixtureMode()
+	if err := f.setupActions(p, config, values); err != nil {
+		return nil, err
+	}
+	p.Start()
+	actual, err := p.RunFixture(inputLines(input), timeout)
+	p.Stop()
+	if err != nil {
+		return nil, err
+	}
+
+	return diffDocuments(expected, actual)
+}
+
+// diffDocuments compares documents as JSON values, so the order of fields doesn't matter.
+func diffDocuments(expected []string, actual []string) ([]string, error) {
+	diffs := make([]string, 0)
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			diffs = append(diffs, fmt.Sprintf("document #%d is missing, expected: %s", i+1, expected[i]))
+		case i >= len(expected):
+			diffs = append(diffs, fmt.Sprintf("unexpected document #%d: %s", i+1, actual[i]))
+		default:
+			var expectedValue, actualValue interface{}
+			if err := json.Unmarshal([]byte(expected[i]), &expectedValue); err != nil {
+				return nil, fmt.Errorf("wrong expected document #%d: %w", i+1, err)
+			}
+			if err := json.Unmarshal([]byte(actual[i]), &actualValue); err != nil {
+				return nil, fmt.Errorf("wrong output document #%d: %w", i+1, err)
+			}
+			if !reflect.DeepEqual(expectedValue, actualValue) {
+				diffs = append(diffs, fmt.Sprintf("document #%d differs, expected: %s, actual: %s", i+1, expected[i], actual[i]))
+			}
+		}
+	}
+
+	return diffs, nil
+}
+
+// inputLines splits data into lines keeping line ends, since the input passes lines to the pipeline with them.
+func inputLines(data []byte) [][]byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return lines[:len(lines)-1]
+	}
+	if last[len(last)-1] != '\n' {
+		lines[len(lines)-1] = append(last, '\n')
+	}
+
+	return lines
+}
+
+func nonEmptyLines(data []byte) []string {
+	lines := make([]string, 0)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		lines = append(lines, string(line))
+	}
+
+	return lines
+}
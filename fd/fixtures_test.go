@@ -0,0 +1,111 @@
+package fd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// markAction sets the field of the config to `marked`.
+type markAction struct {
+	field string
+}
+
+type markConfig struct {
+	Field string `json:"field" required:"true"`
+}
+
+func (a *markAction) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+	a.field = config.(*markConfig).Field
+}
+
+func (a *markAction) Stop() {}
+
+func (a *markAction) Do(event *pipeline.Event) pipeline.ActionResult {
+	event.Root.AddFieldNoAlloc(event.Root, a.field).MutateToString("marked")
+	return pipeline.ActionPass
+}
+
+func newFixturesFileD(t *testing.T, pipelineJSON string) *FileD {
+	registry := &PluginRegistry{plugins: make(map[string]*pipeline.PluginStaticInfo)}
+	registry.plugins[registry.MakeID(pipeline.PluginKindAction, "mark")] = &pipeline.PluginStaticInfo{
+		Type: "mark",
+		Factory: func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+			return &markAction{}, &markConfig{}
+		},
+	}
+
+	raw, err := simplejson.NewJson([]byte(pipelineJSON))
+	require.NoError(t, err)
+
+	config := cfg.NewConfig()
+	config.Pipelines["test"] = &cfg.PipelineConfig{Raw: raw}
+	config.Pipelines["without_fixtures"] = &cfg.PipelineConfig{Raw: raw}
+
+	return &FileD{config: config, plugins: registry}
+}
+
+func writeFixture(t *testing.T, dir string, input string, expected string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.input"), []byte(input), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.expected"), []byte(expected), 0o644))
+}
+
+func TestFixtures(t *testing.T) {
+	f := newFixturesFileD(t, `{"settings":{"decoder":"json"},"actions":[{"type":"mark","field":"status","match_fields":{"level":"error"}}]}`)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		diffs    int
+	}{
+		{
+			name:     "passed",
+			input:    "{\"level\":\"error\"}\n{\"level\":\"info\"}\n",
+			expected: "{\"status\":\"marked\",\"level\":\"error\"}\n\n{\"level\":\"info\"}\n",
+		},
+		{
+			name:     "wrong_document",
+			input:    "{\"level\":\"error\"}\n{\"level\":\"info\"}",
+			expected: "{\"level\":\"error\"}\n{\"level\":\"info\"}\n",
+			diffs:    1,
+		},
+		{
+			name:     "missing_and_unexpected",
+			input:    "{\"level\":\"info\"}\n",
+			expected: "{\"level\":\"info\"}\n{\"level\":\"debug\"}\n",
+			diffs:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.input, tt.expected)
+
+			results, err := f.TestFixtures(dir, 5*time.Second)
+			require.NoError(t, err)
+			require.Equal(t, 1, len(results), "pipeline without fixtures isn't skipped")
+
+			result := results[0]
+			assert.Equal(t, "test", result.Pipeline)
+			assert.NoError(t, result.Err)
+			assert.Equal(t, tt.diffs, len(result.Diffs), "wrong diffs: %v", result.Diffs)
+			assert.Equal(t, tt.diffs == 0, result.IsPassed())
+		})
+	}
+}
+
+func TestFixturesWithoutFixtures(t *testing.T) {
+	f := newFixturesFileD(t, `{"actions":[]}`)
+
+	_, err := f.TestFixtures(t.TempDir(), time.Second)
+	assert.Error(t, err, "no error without fixtures")
+}
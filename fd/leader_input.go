@@ -0,0 +1,88 @@
+package fd
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ozonru/file.d/leader"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+// leaderInput runs the input plugin only while file.d instance is the leader,
+// so the same config can be deployed to all replicas and only one of them consumes.
+// A new instance of the plugin is created each time the leadership is taken.
+type leaderInput struct {
+	info    *pipeline.PluginStaticInfo
+	elector leader.Elector
+
+	config pipeline.AnyConfig
+	params *pipeline.InputPluginParams
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     *sync.Mutex
+	plugin pipeline.InputPlugin
+}
+
+func newLeaderInput(info *pipeline.PluginStaticInfo, elector leader.Elector) *leaderInput {
+	return &leaderInput{
+		info:    info,
+		elector: elector,
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (l *leaderInput) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	l.config = config
+	l.params = params
+	l.done = make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+
+	go func() {
+		l.elector.Run(ctx, l.startPlugin, l.stopPlugin)
+		close(l.done)
+	}()
+}
+
+func (l *leaderInput) Stop() {
+	l.cancel()
+	<-l.done
+}
+
+// Commit passes the event to the running plugin,
+// commits of events from the plugin which is stopped after losing the leadership are skipped.
+func (l *leaderInput) Commit(event *pipeline.Event) {
+	l.mu.Lock()
+	plugin := l.plugin
+	l.mu.Unlock()
+
+	if plugin != nil {
+		plugin.Commit(event)
+	}
+}
+
+func (l *leaderInput) startPlugin() {
+	l.params.Logger.Infof("became the leader, starting input plugin")
+
+	plugin, _ := l.info.Factory()
+	input := plugin.(pipeline.InputPlugin)
+
+	l.mu.Lock()
+	l.plugin = input
+	l.mu.Unlock()
+
+	input.Start(l.config, l.params)
+}
+
+func (l *leaderInput) stopPlugin() {
+	l.params.Logger.Infof("lost the leadership, stopping input plugin")
+
+	l.mu.Lock()
+	input := l.plugin
+	l.plugin = nil
+	l.mu.Unlock()
+
+	input.Stop()
+}
@@ -74,3 +74,8 @@ func (r *PluginRegistry) register(pluginKind pipeline.PluginKind, info *pipeline
 
 	return nil
 }
+
+// Find returns nil if the plugin isn't registered, unlike Get it doesn't fail.
+func (r *PluginRegistry) Find(kind pipeline.PluginKind, t string) *pipeline.PluginStaticInfo {
+	return r.plugins[r.MakeID(kind, t)]
+}
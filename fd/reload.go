@@ -0,0 +1,264 @@
+package fd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/logger"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// staticPipeline is a pipeline from the config,
+// it has its own registry and handlers to be replaced on reload.
+type staticPipeline struct {
+	// raw is the encoded pipeline config to find out if the pipeline is changed
+	raw      []byte
+	config   *cfg.PipelineConfig
+	pipeline *pipeline.Pipeline
+	registry *prometheus.Registry
+	mux      *http.ServeMux
+}
+
+func (f *FileD) newStaticPipeline(name string, config *cfg.PipelineConfig, raw []byte) (*staticPipeline, error) {
+	registry := prometheus.NewRegistry()
+	p, err := f.newPipeline(name, config, registry)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	p.SetupHTTPHandlers(mux)
+
+	return &staticPipeline{
+		raw:      raw,
+		config:   config,
+		pipeline: p,
+		registry: registry,
+		mux:      mux,
+	}, nil
+}
+
+// Reload applies pipelines of the config: removed pipelines are stopped,
+// changed pipelines are stopped and created again and new pipelines are started.
+// Unchanged pipelines keep working, a changed pipeline with the wrong config keeps the old one,
+// since the new pipeline is created before the old one is stopped.
+// Offsets are preserved since inputs save them on stop.
+func (f *FileD) Reload(config *cfg.Config) {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
+	f.mu.Lock()
+	current := make(map[string]*staticPipeline, len(f.static))
+	for name, p := range f.static {
+		current[name] = p
+	}
+	f.mu.Unlock()
+
+	for name, p := range current {
+		if _, has := config.Pipelines[name]; has {
+			continue
+		}
+		logger.Infof("pipeline %q is removed from the config, stopping it", name)
+		f.setStaticPipeline(name, nil)
+		p.pipeline.Stop()
+	}
+
+	for name, pipelineConfig := range config.Pipelines {
+		old := current[name]
+		raw, err := pipelineConfig.Raw.Encode()
+		if err != nil {
+			logger.Errorf("can't encode config of pipeline %q: %s", name, err.Error())
+			continue
+		}
+		if old != nil && bytes.Equal(old.raw, raw) {
+			continue
+		}
+		if f.crd != nil && f.crd.has(name) {
+			logger.Errorf("can't reload pipeline %q: it's already created from FileDPipeline", name)
+			continue
+		}
+		if err := f.validatePipeline(raw); err != nil {
+			logger.Errorf("can't reload pipeline %q, wrong config: %s", name, err.Error())
+			continue
+		}
+
+		if old != nil && f.tryResize(name, old, pipelineConfig, raw) {
+			continue
+		}
+
+		p, err := f.newStaticPipeline(name, pipelineConfig, raw)
+		if err != nil {
+			logger.Errorf("can't reload pipeline %q: %s", name, err.Error())
+			continue
+		}
+
+		if old != nil {
+			logger.Infof("pipeline %q is changed, restarting it", name)
+			f.setStaticPipeline(name, nil)
+			old.pipeline.Stop()
+		} else {
+			logger.Infof("pipeline %q is added to the config, starting it", name)
+		}
+
+		p.pipeline.Start()
+		f.setStaticPipeline(name, p)
+	}
+
+	logger.Infof("config is reloaded, pipelines=%d", len(f.Pipelines))
+}
+
+// tryResize changes the capacity of the pipeline at runtime if only the capacity is changed in the config,
+// so events held in the pipeline aren't lost. It returns false if the pipeline should be restarted.
+func (f *FileD) tryResize(name string, old *staticPipeline, config *cfg.PipelineConfig, raw []byte) bool {
+	isCapacityOnly, err := isCapacityOnlyChange(old.raw, raw)
+	if err != nil || !isCapacityOnly {
+		return false
+	}
+
+	settings, err := extractPipelineParams(config.Raw.Get("settings"))
+	if err != nil {
+		return false
+	}
+	if err := old.pipeline.SetCapacity(settings.Capacity); err != nil {
+		logger.Warnf("can't resize pipeline %q, restarting it: %s", name, err.Error())
+		return false
+	}
+
+	f.setStaticPipeline(name, &staticPipeline{
+		raw:      raw,
+		config:   config,
+		pipeline: old.pipeline,
+		registry: old.registry,
+		mux:      old.mux,
+	})
+
+	return true
+}
+
+// isCapacityOnlyChange returns true if encoded pipeline configs differ only by the capacity.
+func isCapacityOnlyChange(oldRaw []byte, newRaw []byte) (bool, error) {
+	oldConfig := make(map[string]interface{})
+	if err := json.Unmarshal(oldRaw, &oldConfig); err != nil {
+		return false, err
+	}
+	newConfig := make(map[string]interface{})
+	if err := json.Unmarshal(newRaw, &newConfig); err != nil {
+		return false, err
+	}
+
+	for _, config := range []map[string]interface{}{oldConfig, newConfig} {
+		if settings, ok := config["settings"].(map[string]interface{}); ok {
+			delete(settings, "capacity")
+		}
+	}
+
+	return reflect.DeepEqual(oldConfig, newConfig), nil
+}
+
+// ReloadFromFile reads the config from the path set by `SetConfigPath` and reloads pipelines.
+func (f *FileD) ReloadFromFile() error {
+	if f.configPath == "" {
+		return fmt.Errorf("config path isn't set")
+	}
+
+	config, err := cfg.LoadConfigFromFile(f.configPath)
+	if err != nil {
+		return err
+	}
+
+	f.Reload(config)
+	return nil
+}
+
+// setStaticPipeline replaces the pipeline in the pipeline list, nil removes it.
+func (f *FileD) setStaticPipeline(name string, p *staticPipeline) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if p == nil {
+		delete(f.static, name)
+		delete(f.config.Pipelines, name)
+	} else {
+		f.static[name] = p
+		f.config.Pipelines[name] = p.config
+	}
+	f.updatePipelines()
+}
+
+// updatePipelines should be called under the lock.
+func (f *FileD) updatePipelines() {
+	pipelines := make([]*pipeline.Pipeline, 0, len(f.static))
+	for _, p := range f.static {
+		pipelines = append(pipelines, p.pipeline)
+	}
+	f.Pipelines = pipelines
+}
+
+func (f *FileD) hasStaticPipeline(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, has := f.config.Pipelines[name]
+	return has
+}
+
+func (f *FileD) staticGatherers() []prometheus.Gatherer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	gatherers := make([]prometheus.Gatherer, 0, len(f.static))
+	for _, p := range f.static {
+		gatherers = append(gatherers, p.registry)
+	}
+
+	return gatherers
+}
+
+func (f *FileD) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := f.ReloadFromFile(); err != nil {
+		logger.Errorf("can't reload config: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// servePipeline passes `/pipelines/<pipeline_name>/...` requests to handlers
+// of the pipeline from the config or from the custom resource.
+func (f *FileD) servePipeline(w http.ResponseWriter, r *http.Request) {
+	name := pipelineNameFromPath(r.URL.Path)
+
+	f.mu.Lock()
+	p := f.static[name]
+	f.mu.Unlock()
+
+	if p != nil {
+		p.mux.ServeHTTP(w, r)
+		return
+	}
+	if f.crd != nil {
+		f.crd.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func pipelineNameFromPath(path string) string {
+	name := strings.TrimPrefix(path, "/pipelines/")
+	if pos := strings.IndexByte(name, '/'); pos >= 0 {
+		name = name[:pos]
+	}
+
+	return name
+}
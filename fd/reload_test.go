@@ -0,0 +1,170 @@
+package fd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubInput struct{}
+
+func (i *stubInput) Start(_ pipeline.AnyConfig, _ *pipeline.InputPluginParams) {}
+func (i *stubInput) Stop()                                                     {}
+func (i *stubInput) Commit(_ *pipeline.Event)                                  {}
+
+type stubOutput struct{}
+
+func (o *stubOutput) Start(_ pipeline.AnyConfig, _ *pipeline.OutputPluginParams) {}
+func (o *stubOutput) Stop()                                                      {}
+func (o *stubOutput) Out(_ *pipeline.Event)                                      {}
+
+func newReloadFileD() *FileD {
+	registry := &PluginRegistry{plugins: make(map[string]*pipeline.PluginStaticInfo)}
+	registry.plugins[registry.MakeID(pipeline.PluginKindInput, "stub")] = &pipeline.PluginStaticInfo{
+		Type: "stub",
+		Factory: func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+			return &stubInput{}, &fakeConfig{}
+		},
+	}
+	registry.plugins[registry.MakeID(pipeline.PluginKindOutput, "stub")] = &pipeline.PluginStaticInfo{
+		Type: "stub",
+		Factory: func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+			return &stubOutput{}, &fakeConfig{}
+		},
+	}
+
+	f := New(cfg.NewConfig(), "off")
+	f.plugins = registry
+
+	return f
+}
+
+func newReloadConfig(t *testing.T, pipelines map[string]string) *cfg.Config {
+	config := cfg.NewConfig()
+	for name, pipelineJSON := range pipelines {
+		raw, err := simplejson.NewJson([]byte(pipelineJSON))
+		require.NoError(t, err)
+		config.Pipelines[name] = &cfg.PipelineConfig{Raw: raw}
+	}
+
+	return config
+}
+
+func TestReload(t *testing.T) {
+	const (
+		first  = `{"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`
+		second = `{"input":{"type":"stub","field":"2"},"output":{"type":"stub","field":"2"}}`
+		wrong  = `{"input":{"type":"stub"},"output":{"type":"stub","field":"2"}}`
+	)
+
+	f := newReloadFileD()
+	f.SetConfig(newReloadConfig(t, map[string]string{"unchanged": first, "changed": first, "removed": first, "wrong": first}))
+	f.startPipelines()
+	defer f.Stop()
+
+	before := make(map[string]*pipeline.Pipeline)
+	for name, p := range f.static {
+		before[name] = p.pipeline
+	}
+
+	f.Reload(newReloadConfig(t, map[string]string{"unchanged": first, "changed": second, "added": second, "wrong": wrong}))
+
+	require.Len(t, f.Pipelines, 4)
+	assert.Same(t, before["unchanged"], f.static["unchanged"].pipeline, "unchanged pipeline shouldn't be restarted")
+	assert.NotSame(t, before["changed"], f.static["changed"].pipeline, "changed pipeline should be restarted")
+	assert.Same(t, before["wrong"], f.static["wrong"].pipeline, "pipeline with wrong config should keep the old one")
+	assert.NotNil(t, f.static["added"], "new pipeline should be started")
+	assert.Nil(t, f.static["removed"], "removed pipeline should be stopped")
+
+	assert.True(t, f.hasStaticPipeline("added"))
+	assert.False(t, f.hasStaticPipeline("removed"))
+}
+
+func TestReloadWrongSettings(t *testing.T) {
+	const initial = `{"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`
+	wrong := []string{
+		`{"decoder":"unknown"}`,
+		`{"decoder":"csv"}`,
+		`{"decoder":"csv","csv_columns":["a"],"csv_delimiter":";;"}`,
+		`{"decoder":"opaque","spool_dir":"/tmp/spool"}`,
+		`{"balance":"field"}`,
+		`{"stream_affinity":[{"pattern":"(","procs":1}]}`,
+		`{"stream_quota":10,"stream_weights":[{"pattern":"(","weight":1}]}`,
+		`{"heartbeat_patterns":["("]}`,
+		`{"capacity":8,"max_capacity":4}`,
+		`{"trace":{"rate":2}}`,
+	}
+
+	for _, settings := range wrong {
+		t.Run(settings, func(t *testing.T) {
+			f := newReloadFileD()
+			f.SetConfig(newReloadConfig(t, map[string]string{"test": initial}))
+			f.startPipelines()
+			defer f.Stop()
+
+			before := f.static["test"].pipeline
+			f.Reload(newReloadConfig(t, map[string]string{
+				"test": `{"settings":` + settings + `,"input":{"type":"stub","field":"2"},"output":{"type":"stub","field":"1"}}`,
+			}))
+			assert.Same(t, before, f.static["test"].pipeline, "pipeline with wrong settings should keep the old one")
+		})
+	}
+}
+
+func TestReloadCapacity(t *testing.T) {
+	const (
+		initial = `{"settings":{"capacity":8},"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`
+		shrunk  = `{"settings":{"capacity":4},"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`
+		grown   = `{"settings":{"capacity":16},"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`
+	)
+
+	f := newReloadFileD()
+	f.SetConfig(newReloadConfig(t, map[string]string{"test": initial}))
+	f.startPipelines()
+	defer f.Stop()
+
+	before := f.static["test"].pipeline
+	f.Reload(newReloadConfig(t, map[string]string{"test": shrunk}))
+	assert.Same(t, before, f.static["test"].pipeline, "pipeline should be resized without the restart")
+
+	// the capacity can't grow beyond the initial one without the max capacity
+	f.Reload(newReloadConfig(t, map[string]string{"test": grown}))
+	assert.NotSame(t, before, f.static["test"].pipeline, "pipeline should be restarted")
+}
+
+func TestIsCapacityOnlyChange(t *testing.T) {
+	is, err := isCapacityOnlyChange([]byte(`{"settings":{"capacity":1,"decoder":"json"}}`), []byte(`{"settings":{"capacity":2,"decoder":"json"}}`))
+	require.NoError(t, err)
+	assert.True(t, is)
+
+	is, err = isCapacityOnlyChange([]byte(`{"settings":{"capacity":1,"decoder":"json"}}`), []byte(`{"settings":{"capacity":2,"decoder":"raw"}}`))
+	require.NoError(t, err)
+	assert.False(t, is)
+}
+
+func TestServeReady(t *testing.T) {
+	f := newReloadFileD()
+	f.SetConfig(newReloadConfig(t, map[string]string{"test": `{"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`}))
+
+	w := httptest.NewRecorder()
+	f.serveReady(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "file.d is ready before pipelines are started")
+
+	f.startPipelines()
+	defer f.Stop()
+
+	w = httptest.NewRecorder()
+	f.serveReady(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "file.d isn't ready")
+}
+
+func TestPipelineNameFromPath(t *testing.T) {
+	assert.Equal(t, "test", pipelineNameFromPath("/pipelines/test"))
+	assert.Equal(t, "test", pipelineNameFromPath("/pipelines/test/events"))
+}
@@ -0,0 +1,92 @@
+package fd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+	"github.com/ozonru/file.d/longpanic"
+)
+
+const (
+	// ExitCodeClean means all pipelines have been drained and stopped, inputs have persisted offsets.
+	ExitCodeClean = 0
+	// ExitCodeDrainTimeout means some pipeline hasn't committed all events in its drain timeout,
+	// they're read again after the restart if the input is able to do it.
+	ExitCodeDrainTimeout = 3
+	// ExitCodeShutdownTimeout means the stop hasn't finished in the shutdown timeout
+	// or the second stop signal is received, offsets may not be persisted.
+	ExitCodeShutdownTimeout = 4
+)
+
+// ListenSignals makes file.d own OS signals: SIGHUP reloads the config from the file,
+// SIGTERM and SIGINT shut file.d down gracefully. The exit code is sent to the returned channel
+// once the shutdown is over, see Shutdown.
+func (f *FileD) ListenSignals(shutdownTimeout time.Duration) <-chan int {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+	codes := make(chan int, 1)
+	longpanic.Go(func() {
+		codes <- f.handleSignals(signals, shutdownTimeout)
+		signal.Stop(signals)
+	})
+
+	return codes
+}
+
+func (f *FileD) handleSignals(signals <-chan os.Signal, shutdownTimeout time.Duration) int {
+	for s := range signals {
+		if s == syscall.SIGHUP {
+			logger.Infof("SIGHUP received, reloading config")
+			if err := f.ReloadFromFile(); err != nil {
+				logger.Errorf("can't reload config: %s", err.Error())
+			}
+			continue
+		}
+
+		logger.Infof("%s received, shutting down", s)
+		return f.shutdown(signals, shutdownTimeout)
+	}
+
+	return ExitCodeClean
+}
+
+// Shutdown drains and stops pipelines, the exit code is returned:
+// ExitCodeClean if all events are committed, ExitCodeDrainTimeout if some pipeline isn't drained in its drain timeout
+// and ExitCodeShutdownTimeout if the stop isn't finished in the shutdown timeout.
+func (f *FileD) Shutdown(timeout time.Duration) int {
+	return f.shutdown(nil, timeout)
+}
+
+// shutdown gives up waiting for the stop on the second stop signal, so the operator is able to kill file.d.
+func (f *FileD) shutdown(signals <-chan os.Signal, timeout time.Duration) int {
+	stopped := make(chan bool, 1)
+	longpanic.Go(func() { stopped <- f.stop() })
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case isDrained := <-stopped:
+			if !isDrained {
+				logger.Warnf("file.d is stopped, but some pipelines aren't drained")
+				return ExitCodeDrainTimeout
+			}
+			return ExitCodeClean
+		case <-timer.C:
+			logger.Errorf("file.d isn't stopped in %s", timeout)
+			return ExitCodeShutdownTimeout
+		case s := <-signals:
+			// reloading is locked by the stop
+			if s == syscall.SIGHUP {
+				continue
+			}
+			logger.Warnf("%s received again, exiting without waiting for the stop", s)
+			return ExitCodeShutdownTimeout
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package fd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// hangingOutput doesn't return from the stop until it's released.
+type hangingOutput struct {
+	stubOutput
+	release chan struct{}
+}
+
+func (o *hangingOutput) Stop() { <-o.release }
+
+func TestShutdown(t *testing.T) {
+	const (
+		clean    = `{"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`
+		draining = `{"settings":{"drain_timeout":"50ms"},"input":{"type":"stub","field":"1"},"output":{"type":"stub","field":"1"}}`
+	)
+
+	f := newReloadFileD()
+	f.SetConfig(newReloadConfig(t, map[string]string{"clean": clean}))
+	f.startPipelines()
+	assert.Equal(t, ExitCodeClean, f.Shutdown(time.Second), "wrong exit code of the clean shutdown")
+
+	// the stub output doesn't commit events, so the pipeline isn't drained
+	f = newReloadFileD()
+	f.SetConfig(newReloadConfig(t, map[string]string{"draining": draining}))
+	f.startPipelines()
+	f.static["draining"].pipeline.In(1, "test.log", 1, []byte(`{"a":1}`+"\n"), false)
+	assert.Equal(t, ExitCodeDrainTimeout, f.Shutdown(time.Second), "wrong exit code of the undrained shutdown")
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	f := newReloadFileD()
+	f.plugins.plugins[f.plugins.MakeID(pipeline.PluginKindOutput, "hanging")] = &pipeline.PluginStaticInfo{
+		Type: "hanging",
+		Factory: func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+			return &hangingOutput{release: release}, &fakeConfig{}
+		},
+	}
+	f.SetConfig(newReloadConfig(t, map[string]string{"hanging": `{"input":{"type":"stub","field":"1"},"output":{"type":"hanging","field":"1"}}`}))
+	f.startPipelines()
+
+	assert.Equal(t, ExitCodeShutdownTimeout, f.Shutdown(50*time.Millisecond), "wrong exit code of the hanging shutdown")
+}
+
+func TestHandleSignals(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	f := newReloadFileD()
+	f.plugins.plugins[f.plugins.MakeID(pipeline.PluginKindOutput, "hanging")] = &pipeline.PluginStaticInfo{
+		Type: "hanging",
+		Factory: func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+			return &hangingOutput{release: release}, &fakeConfig{}
+		},
+	}
+	f.SetConfig(newReloadConfig(t, map[string]string{"hanging": `{"input":{"type":"stub","field":"1"},"output":{"type":"hanging","field":"1"}}`}))
+	f.startPipelines()
+
+	// the reload fails without the config path, but it doesn't stop file.d
+	signals := make(chan os.Signal, 3)
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGTERM
+	signals <- syscall.SIGINT
+
+	// the second stop signal doesn't wait for the hanging stop
+	assert.Equal(t, ExitCodeShutdownTimeout, f.handleSignals(signals, time.Hour), "wrong exit code of the forced shutdown")
+}
@@ -2,29 +2,87 @@ package fd
 
 import (
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/bitly/go-simplejson"
-	"github.com/ozonru/file.d/cfg"
 	"github.com/ozonru/file.d/logger"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
 )
 
-func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
+var metricLabelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func extractPipelineParams(settings *simplejson.Json) (*pipeline.Settings, error) {
 	capacity := pipeline.DefaultCapacity
+	maxCapacity := 0
 	antispamThreshold := 0
+	antispamEvents := false
 	avgLogSize := pipeline.DefaultAvgLogSize
 	streamField := pipeline.DefaultStreamField
 	maintenanceInterval := pipeline.DefaultMaintenanceInterval
 	decoder := "auto"
 	isStrict := false
+	csvColumns := []string(nil)
+	csvDelimiter := ""
+	minEventSize := 0
+	dropEmpty := false
+	heartbeatPatterns := []string(nil)
+	eventJournalSize := 0
+	balance := pipeline.BalanceStream
+	balanceField := ""
+	streamAffinity := []pipeline.StreamAffinity(nil)
+	workStealing := false
+	streamQuota := 0
+	streamWeights := []pipeline.StreamWeight(nil)
+	sourceIdleTimeout := time.Duration(0)
+	metricLabels := map[string]string(nil)
+	lagTimeField := ""
+	maxInFlightPerSource := 0
+	adaptiveBatching := (*pipeline.AdaptiveBatching)(nil)
+	lowLatency := false
+	spoolDir := ""
+	spoolOnBackpressure := false
+	spoolMaxSize := int64(0)
+	spoolSegmentSize := int64(0)
+	maxEventAge := time.Duration(0)
+	deadLetterFile := ""
+	decodeErrors := ""
+	drainTimeout := pipeline.DefaultDrainTimeout
+	readyTimeout := pipeline.DefaultReadyTimeout
+	addServiceFields := false
+	cpuQuota := float64(0)
+	maxProcs := 0
+	memoryLimit := int64(0)
+	jsonNodePoolSize := pipeline.DefaultJSONNodePoolSize
+	maxJSONNodes := 0
+	oversizedJSON := pipeline.OversizedJSONDrop
+	maxEventSize := 0
+	oversizedEvent := pipeline.OversizedEventTruncate
+	rateLimitEvents := 0
+	rateLimitBytes := 0
+	rateLimitPolicy := pipeline.RateLimitBlock
+	rateLimitSample := 0
+	delivery := pipeline.DeliveryBestEffort
+	statsLog := ""
+	statsInterval := time.Duration(0)
+	dedupWindow := 0
+	dedupField := ""
+	dedupFile := ""
+	trace := (*pipeline.Trace)(nil)
 
+	var err error
 	if settings != nil {
 		val := settings.Get("capacity").MustInt()
 		if val != 0 {
 			capacity = val
 		}
 
+		maxCapacity = settings.Get("max_capacity").MustInt()
+		if maxCapacity != 0 && maxCapacity < capacity {
+			return nil, fmt.Errorf("pipeline max capacity %d is less than the capacity %d", maxCapacity, capacity)
+		}
+
 		val = settings.Get("avg_log_size").MustInt()
 		if val != 0 {
 			avgLogSize = val
@@ -44,26 +102,380 @@ func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
 		if str != "" {
 			i, err := time.ParseDuration(str)
 			if err != nil {
-				logger.Fatalf("can't parse pipeline maintenance interval: %s", err.Error())
+				return nil, fmt.Errorf("can't parse pipeline maintenance interval: %s", err.Error())
 			}
 			maintenanceInterval = i
 		}
 
+		statsLog = settings.Get("stats_log").MustString()
+		switch statsLog {
+		case "", pipeline.StatsLogText, pipeline.StatsLogJSON, pipeline.StatsLogOff:
+		default:
+			return nil, fmt.Errorf("wrong pipeline stats log %q, it should be %q, %q or %q", statsLog, pipeline.StatsLogText, pipeline.StatsLogJSON, pipeline.StatsLogOff)
+		}
+		str = settings.Get("stats_interval").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil || i <= 0 {
+				return nil, fmt.Errorf("can't parse pipeline stats interval: %s", str)
+			}
+			statsInterval = i
+		}
+
+		dedupWindow = settings.Get("dedup_window").MustInt()
+		if dedupWindow < 0 {
+			return nil, fmt.Errorf("pipeline dedup window can't be negative: %d", dedupWindow)
+		}
+		dedupField = settings.Get("dedup_field").MustString()
+		dedupFile = settings.Get("dedup_file").MustString()
+		if dedupWindow == 0 && (dedupField != "" || dedupFile != "") {
+			return nil, fmt.Errorf("pipeline dedup field and file require the dedup window")
+		}
+
 		antispamThreshold = settings.Get("antispam_threshold").MustInt()
 		antispamThreshold *= int(maintenanceInterval / time.Second)
+		antispamEvents = settings.Get("antispam_events").MustBool()
 
 		isStrict = settings.Get("is_strict").MustBool()
+
+		csvColumns = settings.Get("csv_columns").MustStringArray()
+		csvDelimiter = settings.Get("csv_delimiter").MustString()
+
+		minEventSize = settings.Get("min_event_size").MustInt()
+		dropEmpty = settings.Get("drop_empty").MustBool()
+		heartbeatPatterns = settings.Get("heartbeat_patterns").MustStringArray()
+		eventJournalSize = settings.Get("event_journal_size").MustInt()
+
+		str = settings.Get("balance").MustString()
+		if str != "" {
+			balance = str
+		}
+		balanceField = settings.Get("balance_field").MustString()
+		if balance == pipeline.BalanceField && balanceField == "" {
+			return nil, fmt.Errorf("pipeline balance field isn't set for the %q balance", pipeline.BalanceField)
+		}
+
+		for i := range settings.Get("stream_affinity").MustArray() {
+			affinity := settings.Get("stream_affinity").GetIndex(i)
+			streamAffinity = append(streamAffinity, pipeline.StreamAffinity{
+				Pattern: affinity.Get("pattern").MustString(),
+				Procs:   affinity.Get("procs").MustInt(),
+			})
+		}
+		workStealing = settings.Get("work_stealing").MustBool()
+
+		streamQuota = settings.Get("stream_quota").MustInt()
+		for i := range settings.Get("stream_weights").MustArray() {
+			weight := settings.Get("stream_weights").GetIndex(i)
+			streamWeights = append(streamWeights, pipeline.StreamWeight{
+				Pattern: weight.Get("pattern").MustString(),
+				Weight:  weight.Get("weight").MustInt(),
+			})
+		}
+		if len(streamWeights) != 0 && streamQuota == 0 {
+			return nil, fmt.Errorf("pipeline stream weights require the stream quota")
+		}
+
+		str = settings.Get("source_idle_timeout").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse pipeline source idle timeout: %s", err.Error())
+			}
+			sourceIdleTimeout = i
+		}
+
+		lagTimeField = settings.Get("lag_time_field").MustString()
+		maxInFlightPerSource = settings.Get("max_in_flight_per_source").MustInt()
+		spoolDir = settings.Get("spool_dir").MustString()
+		spoolOnBackpressure = settings.Get("spool_on_backpressure").MustBool()
+		if spoolOnBackpressure && spoolDir == "" {
+			return nil, fmt.Errorf("pipeline spool on backpressure requires the spool dir")
+		}
+		spoolMaxSize = settings.Get("spool_max_size").MustInt64()
+		if spoolMaxSize < 0 {
+			return nil, fmt.Errorf("pipeline spool max size can't be negative: %d", spoolMaxSize)
+		}
+		spoolSegmentSize = settings.Get("spool_segment_size").MustInt64()
+		if spoolSegmentSize < 0 {
+			return nil, fmt.Errorf("pipeline spool segment size can't be negative: %d", spoolSegmentSize)
+		}
+		deadLetterFile = settings.Get("dead_letter_file").MustString()
+		decodeErrors = settings.Get("decode_errors").MustString()
+		switch decodeErrors {
+		case "", pipeline.DecodeErrorsSkip, pipeline.DecodeErrorsFatal:
+		case pipeline.DecodeErrorsDeadLetter:
+			if deadLetterFile == "" {
+				return nil, fmt.Errorf("pipeline %q decode errors require the dead letter file", pipeline.DecodeErrorsDeadLetter)
+			}
+		default:
+			return nil, fmt.Errorf("wrong pipeline decode errors %q, it should be %q, %q or %q", decodeErrors, pipeline.DecodeErrorsSkip, pipeline.DecodeErrorsDeadLetter, pipeline.DecodeErrorsFatal)
+		}
+
+		str = settings.Get("max_event_age").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil || i <= 0 {
+				return nil, fmt.Errorf("can't parse pipeline max event age: %s", str)
+			}
+			maxEventAge = i
+		}
+
+		str = settings.Get("drain_timeout").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("can't parse pipeline drain timeout: %s", str)
+			}
+			drainTimeout = i
+		}
+
+		str = settings.Get("ready_timeout").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("can't parse pipeline ready timeout: %s", str)
+			}
+			readyTimeout = i
+		}
+
+		addServiceFields = settings.Get("add_service_fields").MustBool()
+
+		cpuQuota = settings.Get("cpu_quota").MustFloat64()
+		if cpuQuota < 0 {
+			return nil, fmt.Errorf("pipeline cpu quota can't be negative: %v", cpuQuota)
+		}
+
+		maxProcs = settings.Get("max_procs").MustInt()
+		if maxProcs < 0 {
+			return nil, fmt.Errorf("pipeline max procs can't be negative: %d", maxProcs)
+		}
+
+		memoryLimit = settings.Get("memory_limit").MustInt64()
+		if memoryLimit < 0 {
+			return nil, fmt.Errorf("pipeline memory limit can't be negative: %d", memoryLimit)
+		}
+
+		val = settings.Get("json_node_pool_size").MustInt()
+		if val < 0 {
+			return nil, fmt.Errorf("pipeline json node pool size can't be negative: %d", val)
+		}
+		if val != 0 {
+			jsonNodePoolSize = val
+		}
+
+		maxJSONNodes = settings.Get("max_json_nodes").MustInt()
+		if maxJSONNodes < 0 {
+			return nil, fmt.Errorf("pipeline max json nodes can't be negative: %d", maxJSONNodes)
+		}
+
+		str = settings.Get("oversized_json").MustString()
+		switch str {
+		case "":
+		case pipeline.OversizedJSONDrop, pipeline.OversizedJSONTruncate:
+			oversizedJSON = str
+		default:
+			return nil, fmt.Errorf("wrong pipeline oversized json mode %q, it should be %q or %q", str, pipeline.OversizedJSONDrop, pipeline.OversizedJSONTruncate)
+		}
+
+		maxEventSize = settings.Get("max_event_size").MustInt()
+		if maxEventSize < 0 {
+			return nil, fmt.Errorf("pipeline max event size can't be negative: %d", maxEventSize)
+		}
+
+		str = settings.Get("oversized_event").MustString()
+		switch str {
+		case "":
+		case pipeline.OversizedEventTruncate, pipeline.OversizedEventDiscard, pipeline.OversizedEventSplit:
+			oversizedEvent = str
+		default:
+			return nil, fmt.Errorf("wrong pipeline oversized event policy %q, it should be %q, %q or %q", str, pipeline.OversizedEventTruncate, pipeline.OversizedEventDiscard, pipeline.OversizedEventSplit)
+		}
+
+		rateLimitEvents = settings.Get("rate_limit_events").MustInt()
+		rateLimitBytes = settings.Get("rate_limit_bytes").MustInt()
+		rateLimitSample = settings.Get("rate_limit_sample").MustInt()
+		if rateLimitEvents < 0 || rateLimitBytes < 0 || rateLimitSample < 0 {
+			return nil, fmt.Errorf("pipeline rate limits can't be negative")
+		}
+
+		str = settings.Get("rate_limit_policy").MustString()
+		switch str {
+		case "":
+		case pipeline.RateLimitBlock, pipeline.RateLimitDrop, pipeline.RateLimitSample:
+			rateLimitPolicy = str
+		default:
+			return nil, fmt.Errorf("wrong pipeline rate limit policy %q, it should be %q, %q or %q", str, pipeline.RateLimitBlock, pipeline.RateLimitDrop, pipeline.RateLimitSample)
+		}
+
+		str = settings.Get("delivery").MustString()
+		switch str {
+		case "":
+		case pipeline.DeliveryBestEffort, pipeline.DeliveryAtLeastOnce:
+			delivery = str
+		default:
+			return nil, fmt.Errorf("wrong pipeline delivery %q, it should be %q or %q", str, pipeline.DeliveryBestEffort, pipeline.DeliveryAtLeastOnce)
+		}
+
+		if _, has := settings.CheckGet("adaptive_batching"); has {
+			adaptiveBatching, err = extractAdaptiveBatching(settings.Get("adaptive_batching"))
+			if err != nil {
+				return nil, err
+			}
+		}
+		lowLatency = settings.Get("low_latency").MustBool()
+
+		if _, has := settings.CheckGet("trace"); has {
+			trace, err = extractTrace(settings.Get("trace"))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for name := range settings.Get("metric_labels").MustMap() {
+			if !metricLabelRe.MatchString(name) {
+				return nil, fmt.Errorf("wrong pipeline metric label name %q", name)
+			}
+			value, err := settings.Get("metric_labels").Get(name).String()
+			if err != nil {
+				return nil, fmt.Errorf("pipeline metric label %q should be a string", name)
+			}
+			if metricLabels == nil {
+				metricLabels = make(map[string]string)
+			}
+			metricLabels[name] = value
+		}
+	}
+
+	result := &pipeline.Settings{
+		Decoder:              decoder,
+		Capacity:             capacity,
+		AvgLogSize:           avgLogSize,
+		AntispamThreshold:    antispamThreshold,
+		AntispamEvents:       antispamEvents,
+		MaintenanceInterval:  maintenanceInterval,
+		StreamField:          streamField,
+		IsStrict:             isStrict,
+		CSVColumns:           csvColumns,
+		CSVDelimiter:         csvDelimiter,
+		MinEventSize:         minEventSize,
+		DropEmpty:            dropEmpty,
+		HeartbeatPatterns:    heartbeatPatterns,
+		EventJournalSize:     eventJournalSize,
+		StreamAffinity:       streamAffinity,
+		StreamQuota:          streamQuota,
+		StreamWeights:        streamWeights,
+		Balance:              balance,
+		BalanceField:         balanceField,
+		SourceIdleTimeout:    sourceIdleTimeout,
+		MetricLabels:         metricLabels,
+		LagTimeField:         lagTimeField,
+		MaxInFlightPerSource: maxInFlightPerSource,
+		AdaptiveBatching:     adaptiveBatching,
+		LowLatency:           lowLatency,
+		SpoolDir:             spoolDir,
+		SpoolOnBackpressure:  spoolOnBackpressure,
+		SpoolMaxSize:         spoolMaxSize,
+		SpoolSegmentSize:     spoolSegmentSize,
+		MaxEventAge:          maxEventAge,
+		DeadLetterFile:       deadLetterFile,
+		DecodeErrors:         decodeErrors,
+		DrainTimeout:         drainTimeout,
+		CPUQuota:             cpuQuota,
+		Trace:                trace,
+		MaxProcs:             maxProcs,
+		MemoryLimit:          memoryLimit,
+		JSONNodePoolSize:     jsonNodePoolSize,
+		MaxJSONNodes:         maxJSONNodes,
+		OversizedJSON:        oversizedJSON,
+		MaxEventSize:         maxEventSize,
+		OversizedEvent:       oversizedEvent,
+		Delivery:             delivery,
+		StatsLog:             statsLog,
+		StatsInterval:        statsInterval,
+		DedupWindow:          dedupWindow,
+		DedupField:           dedupField,
+		DedupFile:            dedupFile,
+		MaxCapacity:          maxCapacity,
+		WorkStealing:         workStealing,
+		RateLimitEvents:      rateLimitEvents,
+		RateLimitBytes:       rateLimitBytes,
+		RateLimitPolicy:      rateLimitPolicy,
+		RateLimitSample:      rateLimitSample,
+		ReadyTimeout:         readyTimeout,
+		AddServiceFields:     addServiceFields,
+	}
+	if err := result.Validate(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func extractTrace(settings *simplejson.Json) (*pipeline.Trace, error) {
+	conditions, err := extractConditions(settings.Get("match_fields"))
+	if err != nil {
+		return nil, fmt.Errorf("can't extract trace conditions: %s", err.Error())
 	}
 
-	return &pipeline.Settings{
-		Decoder:             decoder,
-		Capacity:            capacity,
-		AvgLogSize:          avgLogSize,
-		AntispamThreshold:   antispamThreshold,
-		MaintenanceInterval: maintenanceInterval,
-		StreamField:         streamField,
-		IsStrict:            isStrict,
+	rate := settings.Get("rate").MustFloat64()
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("trace rate should be from 0 to 1: %v", rate)
 	}
+	if len(conditions) == 0 && rate == 0 {
+		return nil, fmt.Errorf("trace should have match fields or rate")
+	}
+
+	return &pipeline.Trace{
+		MatchConditions: conditions,
+		Rate:            rate,
+		Size:            settings.Get("size").MustInt(),
+	}, nil
+}
+
+func extractAdaptiveBatching(settings *simplejson.Json) (*pipeline.AdaptiveBatching, error) {
+	targetLatency, err := time.ParseDuration(settings.Get("target_latency").MustString())
+	if err != nil || targetLatency <= 0 {
+		return nil, fmt.Errorf("adaptive batching target latency should be a positive duration")
+	}
+
+	maxFlushTimeout := time.Duration(0)
+	str := settings.Get("max_flush_timeout").MustString()
+	if str != "" {
+		maxFlushTimeout, err = time.ParseDuration(str)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse adaptive batching max flush timeout: %s", err.Error())
+		}
+	}
+
+	return &pipeline.AdaptiveBatching{
+		TargetLatency:   targetLatency,
+		MinBatchSize:    settings.Get("min_batch_size").MustInt(),
+		MaxFlushTimeout: maxFlushTimeout,
+	}, nil
+}
+
+func extractChaos(settings *simplejson.Json) (*netutil.Chaos, error) {
+	chaos := &netutil.Chaos{
+		ErrorRate:   settings.Get("error_rate").MustFloat64(),
+		PartialRate: settings.Get("partial_rate").MustFloat64(),
+	}
+
+	var err error
+	if str := settings.Get("latency").MustString(); str != "" {
+		chaos.Latency, err = time.ParseDuration(str)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse chaos latency: %w", err)
+		}
+	}
+	if str := settings.Get("latency_jitter").MustString(); str != "" {
+		chaos.LatencyJitter, err = time.ParseDuration(str)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse chaos latency jitter: %w", err)
+		}
+	}
+
+	return chaos, chaos.Validate()
 }
 
 func extractMatchMode(actionJSON *simplejson.Json) (pipeline.MatchMode, error) {
@@ -83,37 +495,68 @@ func extractMatchInvert(actionJSON *simplejson.Json) (bool, error) {
 	return invertMatchMode, nil
 }
 
+// extractActionTimeout returns the time budget of the action, 0 means it isn't limited.
+func extractActionTimeout(actionJSON *simplejson.Json) (time.Duration, error) {
+	str := actionJSON.Get("action_timeout").MustString()
+	if str == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("action timeout can't be negative: %s", str)
+	}
+
+	return timeout, nil
+}
+
 func extractConditions(condJSON *simplejson.Json) (pipeline.MatchConditions, error) {
-	conditions := make(pipeline.MatchConditions, 0, 0)
+	fields := make(map[string]string)
 	for field := range condJSON.MustMap() {
-		value := condJSON.Get(field).MustString()
+		fields[field] = condJSON.Get(field).MustString()
+	}
 
-		condition := pipeline.MatchCondition{
-			Field: field,
-		}
+	return pipeline.NewMatchConditions(fields)
+}
 
-		if len(value) > 0 && value[0] == '/' {
-			r, err := cfg.CompileRegex(value)
-			if err != nil {
-				return nil, fmt.Errorf("can't compile regexp %s: %w", value, err)
-			}
-			condition.Regexp = r
-		} else {
-			condition.Value = value
+func extractRoutes(routesJSON *simplejson.Json) ([]*pipeline.Route, error) {
+	routes := make([]*pipeline.Route, 0)
+	for i := range routesJSON.MustArray() {
+		routeJSON := routesJSON.GetIndex(i)
+
+		matchMode, err := extractMatchMode(routeJSON)
+		if err != nil {
+			return nil, fmt.Errorf("route #%d: %w", i, err)
 		}
-		conditions = append(conditions, condition)
+		conditions, err := extractConditions(routeJSON.Get("match_fields"))
+		if err != nil {
+			return nil, fmt.Errorf("route #%d: %w", i, err)
+		}
+
+		routes = append(routes, &pipeline.Route{
+			MatchConditions: conditions,
+			MatchMode:       matchMode,
+			MatchInvert:     routeJSON.Get("match_invert").MustBool(),
+			Priority:        routeJSON.Get("priority").MustInt(),
+			Outputs:         routeJSON.Get("outputs").MustStringArray(),
+			IsDefault:       routeJSON.Get("default").MustBool(),
+		})
 	}
 
-	return conditions, nil
+	return routes, nil
 }
 
-func extractMetrics(actionJSON *simplejson.Json) (string, []string) {
+func extractMetrics(actionJSON *simplejson.Json) (string, []string, int) {
 	metricName := actionJSON.Get("metric_name").MustString()
 	metricLabels := actionJSON.Get("metric_labels").MustStringArray()
 	if metricLabels == nil {
 		metricLabels = []string{}
 	}
-	return metricName, metricLabels
+	metricMaxLabelValues := actionJSON.Get("metric_max_label_values").MustInt()
+	return metricName, metricLabels, metricMaxLabelValues
 }
 
 func makeActionJSON(actionJSON *simplejson.Json) []byte {
@@ -122,7 +565,10 @@ func makeActionJSON(actionJSON *simplejson.Json) []byte {
 	actionJSON.Del("match_mode")
 	actionJSON.Del("metric_name")
 	actionJSON.Del("metric_labels")
+	actionJSON.Del("metric_max_label_values")
 	actionJSON.Del("match_invert")
+	actionJSON.Del("action_timeout")
+	actionJSON.Del("action_timeout_skip")
 	configJson, err := actionJSON.Encode()
 	if err != nil {
 		logger.Panicf("can't create action json")
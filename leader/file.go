@@ -0,0 +1,68 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+)
+
+// fileElector uses the exclusive lock of the file, so only one of instances sharing the file is the leader.
+// The leader holds the lock until it stops.
+type fileElector struct {
+	config *Config
+}
+
+func newFileElector(config *Config) *fileElector {
+	return &fileElector{config: config}
+}
+
+func (e *fileElector) Run(ctx context.Context, onStarted func(), onStopped func()) {
+	ticker := time.NewTicker(e.config.RetryPeriod_)
+	defer ticker.Stop()
+
+	for {
+		file, err := e.tryLock()
+		if err != nil {
+			logger.Errorf("can't lock file %q: %s", e.config.Path, err.Error())
+		}
+
+		if file != nil {
+			onStarted()
+			<-ctx.Done()
+			onStopped()
+
+			_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			_ = file.Close()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryLock returns nil file if the lock is held by another instance.
+func (e *fileElector) tryLock() (*os.File, error) {
+	file, err := os.OpenFile(e.config.Path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		_ = file.Close()
+		return nil, nil
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
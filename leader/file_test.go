@@ -0,0 +1,72 @@
+package leader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"k8s.io/client-go/rest"
+)
+
+func runElector(t *testing.T, path string) (*atomic.Bool, context.CancelFunc, chan struct{}) {
+	elector, err := New(&Config{Lock: LockFile, Path: path, RetryPeriod_: 10 * time.Millisecond}, nil)
+	require.NoError(t, err)
+
+	isLeader := atomic.NewBool(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		elector.Run(ctx, func() { isLeader.Store(true) }, func() { isLeader.Store(false) })
+		close(done)
+	}()
+
+	return isLeader, cancel, done
+}
+
+func waitLeader(isLeader *atomic.Bool) bool {
+	for i := 0; i < 100 && !isLeader.Load(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return isLeader.Load()
+}
+
+func TestFileElector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+
+	firstIsLeader, firstCancel, firstDone := runElector(t, path)
+	require.True(t, waitLeader(firstIsLeader), "first instance should become the leader")
+
+	secondIsLeader, secondCancel, secondDone := runElector(t, path)
+	defer func() {
+		secondCancel()
+		<-secondDone
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, secondIsLeader.Load(), "only one instance should be the leader")
+
+	firstCancel()
+	<-firstDone
+	assert.False(t, firstIsLeader.Load(), "stopped instance should lose the leadership")
+	assert.True(t, waitLeader(secondIsLeader), "second instance should take the leadership")
+}
+
+func TestNew(t *testing.T) {
+	kubeConfig := func() *rest.Config { return &rest.Config{} }
+
+	_, err := New(&Config{Lock: LockFile}, kubeConfig)
+	assert.Error(t, err, "path is required for file lock")
+
+	_, err = New(&Config{Lock: LockLease, LeaseDuration_: 15 * time.Second, RenewDeadline_: 10 * time.Second}, kubeConfig)
+	assert.Error(t, err, "name is required for lease")
+
+	_, err = New(&Config{Lock: LockLease, Name: "backfill", LeaseDuration_: 5 * time.Second, RenewDeadline_: 10 * time.Second}, kubeConfig)
+	assert.Error(t, err, "lease duration should be greater than renew deadline")
+
+	_, err = New(&Config{Lock: LockLease, Name: "backfill", LeaseDuration_: 15 * time.Second, RenewDeadline_: 10 * time.Second}, kubeConfig)
+	assert.NoError(t, err)
+}
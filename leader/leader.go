@@ -0,0 +1,96 @@
+// Package leader elects one of file.d instances to run singleton inputs.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	LockLease = "lease"
+	LockFile  = "file"
+
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+type Config struct {
+	// Lock is a way to elect the leader: k8s `lease` or `file` lock.
+	Lock string `json:"lock" default:"lease" options:"lease|file"`
+
+	// Name of the k8s lease.
+	Name string `json:"name"`
+
+	// Namespace of the k8s lease, the namespace of file.d pod is used if it's empty.
+	Namespace string `json:"namespace"`
+
+	// Path of the lock file.
+	Path string `json:"path"`
+
+	// LeaseDuration is how long other instances wait before taking the lease of the leader which doesn't renew it.
+	LeaseDuration  cfg.Duration `json:"lease_duration" default:"15s" parse:"duration"`
+	LeaseDuration_ time.Duration
+
+	// RenewDeadline is how long the leader retries to renew the lease before it gives up the leadership.
+	RenewDeadline  cfg.Duration `json:"renew_deadline" default:"10s" parse:"duration"`
+	RenewDeadline_ time.Duration
+
+	// RetryPeriod is how often instances try to become the leader.
+	RetryPeriod  cfg.Duration `json:"retry_period" default:"2s" parse:"duration"`
+	RetryPeriod_ time.Duration
+}
+
+// Elector calls onStarted when the instance becomes the leader and onStopped when it loses the leadership.
+// Callbacks are called in turn, Run blocks until the context is done.
+type Elector interface {
+	Run(ctx context.Context, onStarted func(), onStopped func())
+}
+
+// New creates the elector, kubeConfig is used only for the `lease` lock.
+func New(config *Config, kubeConfig func() *rest.Config) (Elector, error) {
+	switch config.Lock {
+	case LockLease:
+		if config.Name == "" {
+			return nil, fmt.Errorf("lease name isn't set")
+		}
+		if config.LeaseDuration_ <= config.RenewDeadline_ {
+			return nil, fmt.Errorf("lease duration must be greater than renew deadline")
+		}
+		namespace := config.Namespace
+		if namespace == "" {
+			namespace = podNamespace()
+		}
+		return newLeaseElector(config, namespace, kubeConfig())
+	case LockFile:
+		if config.Path == "" {
+			return nil, fmt.Errorf("lock file path isn't set")
+		}
+		return newFileElector(config), nil
+	default:
+		return nil, fmt.Errorf("unknown lock %q", config.Lock)
+	}
+}
+
+func identity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "file.d"
+	}
+
+	return fmt.Sprintf("%s_%d", hostname, os.Getpid())
+}
+
+func podNamespace() string {
+	data, err := ioutil.ReadFile(namespaceFile)
+	if err != nil {
+		return "default"
+	}
+
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,85 @@
+package leader
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ozonru/file.d/logger"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaseElector uses k8s lease, so only one of replicas is the leader.
+type leaseElector struct {
+	config *Config
+	lock   resourcelock.Interface
+
+	// termMu serializes callbacks of successive terms, since k8s elector calls them asynchronously
+	termMu *sync.Mutex
+}
+
+func newLeaseElector(config *Config, namespace string, kubeConfig *rest.Config) (*leaseElector, error) {
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		namespace,
+		config.Name,
+		client.CoreV1(),
+		client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaseElector{
+		config: config,
+		lock:   lock,
+		termMu: &sync.Mutex{},
+	}, nil
+}
+
+func (e *leaseElector) Run(ctx context.Context, onStarted func(), onStopped func()) {
+	isStopped := false
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            e.lock,
+			LeaseDuration:   e.config.LeaseDuration_,
+			RenewDeadline:   e.config.RenewDeadline_,
+			RetryPeriod:     e.config.RetryPeriod_,
+			ReleaseOnCancel: true,
+			Name:            e.config.Name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(termCtx context.Context) {
+					e.termMu.Lock()
+					defer e.termMu.Unlock()
+
+					if isStopped {
+						return
+					}
+					onStarted()
+					<-termCtx.Done()
+					onStopped()
+				},
+				OnStoppedLeading: func() {},
+			},
+		})
+		if err != nil {
+			logger.Errorf("can't create leader elector for lease %q: %s", e.config.Name, err.Error())
+			return
+		}
+
+		elector.Run(ctx)
+	}
+
+	// wait for the last term to stop and prevent the term which isn't started yet
+	e.termMu.Lock()
+	isStopped = true
+	e.termMu.Unlock()
+}
@@ -1,14 +1,20 @@
 // package longpanic defines `Go` func that creates goroutine with defer
 // that waits for somebody to call `RecoverFromPanic` or panics after timeout.
+// Recovered panics are reported to the sinks with the pipeline and the plugin they come from.
 package longpanic
 
 import (
+	"fmt"
+	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/ozonru/file.d/logger"
 	"go.uber.org/atomic"
 )
 
+const restartDelay = time.Second
+
 // instance is a singleton with timeout that every `go func` call should use.
 var instance *LongPanic = NewLongPanic(time.Minute)
 
@@ -17,6 +23,16 @@ func SetTimeout(timeout time.Duration) {
 	instance.timeout = timeout
 }
 
+// SetSinks sets the sinks which receive panic reports.
+func SetSinks(sinks ...Sink) {
+	instance.SetSinks(sinks...)
+}
+
+// SetRestart enables restarting of the panicked goroutines instead of waiting for the timeout.
+func SetRestart(restart bool) {
+	instance.SetRestart(restart)
+}
+
 // Go runs fn in a different goroutine with defer statement that:
 // 1. Recovers from panic
 // 2. Waits for somebody to call `RecoverFromPanic` or timeout
@@ -25,6 +41,11 @@ func Go(fn func()) {
 	instance.Go(fn)
 }
 
+// GoScoped is the same as `Go`, but panic reports contain the scope.
+func GoScoped(scope Scope, fn func()) {
+	instance.GoScoped(scope, fn)
+}
+
 // WithRecover runs fn with defer statement that:
 // 1. Recovers from panic
 // 2. Waits for somebody to call `RecoverFromPanic` or timeout
@@ -38,10 +59,30 @@ func RecoverFromPanic() {
 	instance.RecoverFromPanic()
 }
 
+// Scope tells which pipeline and plugin a goroutine belongs to.
+type Scope struct {
+	Pipeline string `json:"pipeline"`
+	Plugin   string `json:"plugin"`
+}
+
+// Report describes a recovered panic.
+type Report struct {
+	Scope
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Stack   string    `json:"stack"`
+	// Restart is true if the goroutine is going to be restarted.
+	Restart bool `json:"restart"`
+}
+
 // LongPanic is a struct that holds an atomic and a timeout after a defer fn will panic.
 type LongPanic struct {
 	shouldPanic *atomic.Bool
 	timeout     time.Duration
+	restart     *atomic.Bool
+
+	sinksMu *sync.RWMutex
+	sinks   []Sink
 }
 
 // NewLongPanic creates LongPanic.
@@ -49,16 +90,43 @@ func NewLongPanic(timeout time.Duration) *LongPanic {
 	return &LongPanic{
 		shouldPanic: atomic.NewBool(false),
 		timeout:     timeout,
+		restart:     atomic.NewBool(false),
+		sinksMu:     &sync.RWMutex{},
+		sinks:       []Sink{LogSink{}},
 	}
 }
 
+// SetSinks sets the sinks which receive panic reports.
+func (l *LongPanic) SetSinks(sinks ...Sink) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+
+	l.sinks = sinks
+}
+
+// SetRestart enables restarting of the panicked goroutines instead of waiting for the timeout.
+func (l *LongPanic) SetRestart(restart bool) {
+	l.restart.Store(restart)
+}
+
 // Go runs fn in a different goroutine with defer statement that:
 // 1. Recovers from panic
 // 2. Waits for somebody to call `RecoverFromPanic` or timeout
 // 3. Panics if nobody calls `RecoverFromPanic`
 func (l *LongPanic) Go(fn func()) {
+	l.GoScoped(Scope{}, fn)
+}
+
+// GoScoped is the same as `Go`, but panic reports contain the scope.
+// If restart is enabled, the panicked fn is run again in a new goroutine.
+func (l *LongPanic) GoScoped(scope Scope, fn func()) {
 	go func() {
-		defer l.recoverUntilTimeout()
+		defer func() {
+			if l.recoverUntilTimeout(scope, recover(), true) {
+				time.Sleep(restartDelay)
+				l.GoScoped(scope, fn)
+			}
+		}()
 		fn()
 	}()
 }
@@ -68,32 +136,58 @@ func (l *LongPanic) Go(fn func()) {
 // 2. Waits for somebody to call `RecoverFromPanic` or timeout
 // 3. Panics if nobody calls `RecoverFromPanic`
 func (l *LongPanic) WithRecover(fn func()) {
-	defer l.recoverUntilTimeout()
+	defer func() {
+		l.recoverUntilTimeout(Scope{}, recover(), false)
+	}()
 	fn()
 }
 
-// recover waits for somebody to reset the error plugin or panics after a timeout.
-func (l *LongPanic) recoverUntilTimeout() {
-	if err, ok := recover().(error); ok {
-		logger.Error(err.Error())
-		logger.Error("wait for somebody to restart plugins via endpoint")
+// recoverUntilTimeout reports the panic and either tells to restart the goroutine
+// or waits for somebody to reset the error plugin and panics after a timeout.
+func (l *LongPanic) recoverUntilTimeout(scope Scope, recovered interface{}, canRestart bool) bool {
+	if recovered == nil {
+		return false
+	}
 
-		l.shouldPanic.Store(true)
-		t := time.Now()
-		for {
-			time.Sleep(10 * time.Millisecond)
-			if !l.shouldPanic.Load() {
-				logger.Error("panic recovered! Trying to continue execution...")
+	report := &Report{
+		Scope:   scope,
+		Time:    time.Now(),
+		Message: fmt.Sprint(recovered),
+		Stack:   string(debug.Stack()),
+		Restart: canRestart && l.restart.Load(),
+	}
+	l.report(report)
 
-				return
-			}
-			if time.Since(t) > l.timeout {
-				logger.Panic(err.Error())
-			}
+	if report.Restart {
+		return true
+	}
+
+	logger.Error("wait for somebody to restart plugins via endpoint")
+
+	l.shouldPanic.Store(true)
+	t := time.Now()
+	for {
+		time.Sleep(10 * time.Millisecond)
+		if !l.shouldPanic.Load() {
+			logger.Error("panic recovered! Trying to continue execution...")
+
+			return false
+		}
+		if time.Since(t) > l.timeout {
+			logger.Panic(report.Message)
 		}
 	}
 }
 
+func (l *LongPanic) report(report *Report) {
+	l.sinksMu.RLock()
+	defer l.sinksMu.RUnlock()
+
+	for _, sink := range l.sinks {
+		sink.Report(report)
+	}
+}
+
 // RecoverFromPanic is a signal to not wait for the panic and tries to continue the execution.
 func (l *LongPanic) RecoverFromPanic() {
 	l.shouldPanic.Store(false)
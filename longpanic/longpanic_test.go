@@ -0,0 +1,74 @@
+package longpanic
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sinkMock struct {
+	mu      *sync.Mutex
+	reports []*Report
+}
+
+func (s *sinkMock) Report(report *Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reports = append(s.reports, report)
+}
+
+func TestGoScopedRestart(t *testing.T) {
+	sink := &sinkMock{mu: &sync.Mutex{}}
+	l := NewLongPanic(time.Minute)
+	l.SetSinks(sink)
+	l.SetRestart(true)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	calls := 0
+	l.GoScoped(Scope{Pipeline: "test_pipeline", Plugin: "test_plugin"}, func() {
+		calls++
+		wg.Done()
+		if calls == 1 {
+			panic(errors.New("first call panics"))
+		}
+	})
+	wg.Wait()
+
+	assert.Equal(t, 2, calls, "goroutine isn't restarted")
+	assert.Equal(t, 1, len(sink.reports), "wrong reports count")
+	assert.Equal(t, "test_pipeline", sink.reports[0].Pipeline, "wrong report pipeline")
+	assert.Equal(t, "test_plugin", sink.reports[0].Plugin, "wrong report plugin")
+	assert.Equal(t, "first call panics", sink.reports[0].Message, "wrong report message")
+	assert.True(t, sink.reports[0].Restart, "report should tell about restart")
+	assert.NotEmpty(t, sink.reports[0].Stack, "report should contain stack")
+}
+
+func TestWithRecoverWaits(t *testing.T) {
+	sink := &sinkMock{mu: &sync.Mutex{}}
+	l := NewLongPanic(time.Minute)
+	l.SetSinks(sink)
+	l.SetRestart(true)
+
+	done := make(chan struct{})
+	go func() {
+		l.WithRecover(func() {
+			panic("string panic")
+		})
+		close(done)
+	}()
+
+	for !l.shouldPanic.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.RecoverFromPanic()
+	<-done
+
+	assert.Equal(t, 1, len(sink.reports), "wrong reports count")
+	assert.Equal(t, "string panic", sink.reports[0].Message, "wrong report message")
+	assert.False(t, sink.reports[0].Restart, "WithRecover can't restart")
+}
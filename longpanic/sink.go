@@ -0,0 +1,75 @@
+package longpanic
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sink receives reports about recovered panics.
+type Sink interface {
+	Report(report *Report)
+}
+
+// LogSink writes reports to the log.
+type LogSink struct{}
+
+func (LogSink) Report(report *Report) {
+	logger.Errorf("panic in pipeline=%q plugin=%q, restart=%t: %s\n%s", report.Pipeline, report.Plugin, report.Restart, report.Message, report.Stack)
+}
+
+// WebhookSink posts reports as JSON to the URL.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *WebhookSink) Report(report *Report) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		logger.Errorf("can't marshal panic report: %s", err.Error())
+		return
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Errorf("can't send panic report to %q: %s", s.url, err.Error())
+		return
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		logger.Errorf("can't send panic report to %q: status code %d", s.url, resp.StatusCode)
+	}
+}
+
+// MetricSink counts reports by the pipeline and the plugin.
+type MetricSink struct {
+	panics *prometheus.CounterVec
+}
+
+func NewMetricSink(registerer prometheus.Registerer) *MetricSink {
+	panics := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "file_d",
+		Name:      "panics_total",
+		Help:      "how many panics are recovered",
+	}, []string{"pipeline", "plugin"})
+	registerer.MustRegister(panics)
+
+	return &MetricSink{panics: panics}
+}
+
+func (s *MetricSink) Report(report *Report) {
+	s.panics.WithLabelValues(report.Pipeline, report.Plugin).Inc()
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package netutil
+
+import (
+	"syscall"
+)
+
+// bind binds the socket to the source address of the dialed family,
+// it's called by the dialer for every address of the host, so happy eyeballs dialing keeps working.
+func (s *sourceAddrs) bind(network string, _ string, conn syscall.RawConn) error {
+	ip, zone, err := s.addr(network)
+	if err != nil || ip == nil {
+		return err
+	}
+
+	var sa syscall.Sockaddr
+	if ip4 := ip.To4(); ip4 != nil {
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], ip)
+		if zone != "" {
+			sa6.ZoneId = uint32(s.index)
+		}
+		sa = sa6
+	}
+
+	var bindErr error
+	err = conn.Control(func(fd uintptr) {
+		bindErr = syscall.Bind(int(fd), sa)
+	})
+	if err != nil {
+		return err
+	}
+
+	return bindErr
+}
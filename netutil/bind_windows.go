@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package netutil
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func (s *sourceAddrs) bind(_ string, _ string, _ syscall.RawConn) error {
+	return fmt.Errorf("source interface %q isn't supported on windows", s.name)
+}
@@ -0,0 +1,98 @@
+package netutil
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ErrChaos is returned by dials and writes which are failed by the chaos.
+var ErrChaos = errors.New("failure is injected by chaos")
+
+// Chaos injects failures into connections of the dialer, so retries and backoffs of outputs
+// can be exercised in staging against real sinks. It must never be enabled in production.
+type Chaos struct {
+	// ErrorRate is the probability of the dial or the write to fail, the connection is closed then.
+	ErrorRate float64
+	// PartialRate is the probability of the write to send only the half of the data and fail, the connection is closed then.
+	PartialRate float64
+	// Latency is added before each write.
+	Latency time.Duration
+	// LatencyJitter is the max random duration added to the latency.
+	LatencyJitter time.Duration
+
+	random func() float64
+	sleep  func(time.Duration)
+}
+
+// Validate checks rates are probabilities and durations aren't negative.
+func (c *Chaos) Validate() error {
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("chaos error rate should be from 0 to 1: %v", c.ErrorRate)
+	}
+	if c.PartialRate < 0 || c.PartialRate > 1 {
+		return fmt.Errorf("chaos partial rate should be from 0 to 1: %v", c.PartialRate)
+	}
+	if c.ErrorRate+c.PartialRate > 1 {
+		return fmt.Errorf("sum of chaos error and partial rates should be at most 1: %v", c.ErrorRate+c.PartialRate)
+	}
+	if c.Latency < 0 || c.LatencyJitter < 0 {
+		return fmt.Errorf("chaos latency and jitter can't be negative")
+	}
+
+	return nil
+}
+
+func (c *Chaos) dice() float64 {
+	if c.random != nil {
+		return c.random()
+	}
+
+	return rand.Float64()
+}
+
+func (c *Chaos) delay() {
+	latency := c.Latency
+	if c.LatencyJitter > 0 {
+		latency += time.Duration(c.dice() * float64(c.LatencyJitter))
+	}
+	if latency <= 0 {
+		return
+	}
+
+	if c.sleep != nil {
+		c.sleep(latency)
+		return
+	}
+	time.Sleep(latency)
+}
+
+// failsDial returns true if the dial should fail.
+func (c *Chaos) failsDial() bool {
+	return c.dice() < c.ErrorRate
+}
+
+// chaosConn injects latency and failures into writes of the connection, reads aren't affected.
+type chaosConn struct {
+	net.Conn
+	chaos *Chaos
+}
+
+func (c *chaosConn) Write(b []byte) (int, error) {
+	c.chaos.delay()
+
+	dice := c.chaos.dice()
+	switch {
+	case dice < c.chaos.ErrorRate:
+		_ = c.Conn.Close()
+		return 0, ErrChaos
+	case dice < c.chaos.ErrorRate+c.chaos.PartialRate && len(b) > 1:
+		n, _ := c.Conn.Write(b[:len(b)/2])
+		_ = c.Conn.Close()
+		return n, ErrChaos
+	}
+
+	return c.Conn.Write(b)
+}
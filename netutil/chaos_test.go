@@ -0,0 +1,87 @@
+package netutil
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newReceiver returns the address of the listener, the data of each connection is sent to the channel after it's closed.
+func newReceiver(t *testing.T) (string, chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	received := make(chan []byte, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := io.ReadAll(conn)
+			_ = conn.Close()
+			received <- data
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestChaos(t *testing.T) {
+	address, received := newReceiver(t)
+
+	dice := 0.0
+	slept := time.Duration(0)
+	chaos := &Chaos{
+		ErrorRate:     0.1,
+		PartialRate:   0.2,
+		Latency:       time.Second,
+		LatencyJitter: time.Second,
+		random:        func() float64 { return dice },
+		sleep:         func(d time.Duration) { slept += d },
+	}
+	require.NoError(t, chaos.Validate())
+
+	dialer, err := NewDialer(time.Second, "")
+	require.NoError(t, err)
+	dialer.SetChaos(chaos)
+
+	_, err = dialer.Dial("tcp", address)
+	assert.ErrorIs(t, err, ErrChaos, "dial isn't failed")
+
+	// the dice is over the error rate but under the sum with the partial rate
+	dice = 0.15
+	conn, err := dialer.Dial("tcp", address)
+	require.NoError(t, err)
+	n, err := conn.Write([]byte("0123456789"))
+	assert.ErrorIs(t, err, ErrChaos, "write isn't failed")
+	assert.Equal(t, 5, n, "wrong written count")
+	assert.Equal(t, "01234", string(<-received), "wrong partially written data")
+	assert.Equal(t, time.Second+150*time.Millisecond, slept, "wrong latency")
+
+	dice = 0.5
+	conn, err = dialer.Dial("tcp", address)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("0123456789"))
+	assert.NoError(t, err, "write is failed")
+	_ = conn.Close()
+	assert.Equal(t, "0123456789", string(<-received), "wrong written data")
+
+	dialer.SetChaos(nil)
+	dice = 0
+	conn, err = dialer.Dial("tcp", address)
+	require.NoError(t, err, "chaos isn't disabled")
+	_ = conn.Close()
+}
+
+func TestChaosValidate(t *testing.T) {
+	assert.Error(t, (&Chaos{ErrorRate: 1.5}).Validate(), "wrong error rate is accepted")
+	assert.Error(t, (&Chaos{PartialRate: -0.1}).Validate(), "wrong partial rate is accepted")
+	assert.Error(t, (&Chaos{ErrorRate: 0.6, PartialRate: 0.6}).Validate(), "wrong sum of rates is accepted")
+	assert.Error(t, (&Chaos{Latency: -time.Second}).Validate(), "negative latency is accepted")
+}
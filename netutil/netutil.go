@@ -0,0 +1,374 @@
+// package netutil creates listeners and dialers of plugins, so all of them handle IPv6 the same way.
+// Listeners with the `tcp` network are dual-stack: addresses like `:9000` and `[::]:9000` accept both IPv4 and IPv6 connections.
+// Dialers try IPv6 and IPv4 addresses of the host in parallel (happy eyeballs) and may be bound to the source interface,
+// addresses of hosts are cached by the shared resolver.
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FallbackDelay is how long the dialer waits for the IPv6 connection before trying IPv4 addresses of the host.
+const FallbackDelay = 300 * time.Millisecond
+
+// CheckAddress checks the listen or dial address `HOST:PORT`, IPv6 hosts should be in brackets, e.g. `[::1]:9000`.
+func CheckAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+			return fmt.Errorf("wrong address %q, IPv6 host should be in brackets, e.g. [::1]:9000", address)
+		}
+		return fmt.Errorf("wrong address %q: %w", address, err)
+	}
+
+	if i := strings.IndexByte(host, '%'); i != -1 {
+		host = host[:i]
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return fmt.Errorf("wrong address %q, host %q isn't a valid IPv6 address", address, host)
+	}
+
+	return nil
+}
+
+// Listen checks the address and listens it, use `tcp4` or `tcp6` networks to listen only one family.
+func Listen(network string, address string) (net.Listener, error) {
+	if err := CheckAddress(address); err != nil {
+		return nil, err
+	}
+
+	return net.Listen(network, address)
+}
+
+// ListenPacket is like `Listen` for packet networks, e.g. `udp`.
+func ListenPacket(network string, address string) (net.PacketConn, error) {
+	if err := CheckAddress(address); err != nil {
+		return nil, err
+	}
+
+	return net.ListenPacket(network, address)
+}
+
+// Dialer resolves hosts with the shared resolver and dials their addresses,
+// IPv6 and IPv4 addresses are tried in parallel (happy eyeballs).
+type Dialer struct {
+	dialer   *net.Dialer
+	resolver *Resolver
+	chaos    *Chaos
+}
+
+// NewDialer returns the dialer of the default resolver, if the source interface is set
+// connections are made from its address of the dialed family.
+func NewDialer(timeout time.Duration, sourceInterface string) (*Dialer, error) {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if sourceInterface != "" {
+		source, err := newSourceAddrs(sourceInterface)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Control = source.bind
+	}
+
+	return &Dialer{dialer: dialer, resolver: DefaultResolver}, nil
+}
+
+// SetChaos makes the dialer inject failures into dials and connections, nil disables the chaos.
+func (d *Dialer) SetChaos(chaos *Chaos) {
+	d.chaos = chaos
+}
+
+func (d *Dialer) Dial(network string, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext dials addresses of the host, connections are wrapped if the chaos is set.
+func (d *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if d.chaos == nil {
+		return d.dialContext(ctx, network, address)
+	}
+
+	if d.chaos.failsDial() {
+		return nil, ErrChaos
+	}
+	conn, err := d.dialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chaosConn{Conn: conn, chaos: d.chaos}, nil
+}
+
+// dialContext dials addresses of the host. If none of them is available, cached addresses are dropped
+// and the host is resolved again, since they may be stale after the failover of the sink.
+func (d *Dialer) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if !isIPNetwork(network) || isIP(host) {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.dialIPs(ctx, network, ips, port)
+	if err == nil {
+		return conn, nil
+	}
+
+	d.resolver.Invalidate(host)
+	fresh, lookupErr := d.resolver.LookupIP(ctx, host)
+	if lookupErr != nil || isSameIPs(ips, fresh) {
+		return nil, err
+	}
+
+	return d.dialIPs(ctx, network, fresh, port)
+}
+
+// ResetHost drops cached addresses of the host of the failed request and idle connections of the client,
+// so the next request connects to the fresh address of the host.
+func (d *Dialer) ResetHost(client *http.Client, hostPort string) {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+
+	d.resolver.Invalidate(host)
+	client.CloseIdleConnections()
+}
+
+type dialResult struct {
+	conn      net.Conn
+	err       error
+	isPrimary bool
+}
+
+// dialIPs dials addresses of the family of the first address, addresses of the other family
+// are dialed in parallel if the first family doesn't connect in `FallbackDelay`.
+func (d *Dialer) dialIPs(ctx context.Context, network string, ips []net.IP, port string) (net.Conn, error) {
+	var primaries, fallbacks []string
+	isPrimaryIPv4 := false
+	for _, ip := range ips {
+		if !isFamilyOf(network, ip) {
+			continue
+		}
+		if len(primaries) == 0 {
+			isPrimaryIPv4 = isIPv4(ip)
+		}
+		address := net.JoinHostPort(ip.String(), port)
+		if isIPv4(ip) == isPrimaryIPv4 {
+			primaries = append(primaries, address)
+		} else {
+			fallbacks = append(fallbacks, address)
+		}
+	}
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("no %s address of the host is found", network)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	dial := func(addresses []string, isPrimary bool) {
+		conn, err := d.dialSerial(ctx, network, addresses)
+		select {
+		case results <- dialResult{conn: conn, err: err, isPrimary: isPrimary}:
+		case <-ctx.Done():
+			if conn != nil {
+				_ = conn.Close()
+			}
+		}
+	}
+
+	go dial(primaries, true)
+	if len(fallbacks) == 0 {
+		result := <-results
+		return result.conn, result.err
+	}
+
+	fallbackTimer := time.NewTimer(FallbackDelay)
+	defer fallbackTimer.Stop()
+
+	var primaryErr error
+	isFallbackStarted := false
+	failed := 0
+	for {
+		select {
+		case <-fallbackTimer.C:
+			if !isFallbackStarted {
+				isFallbackStarted = true
+				go dial(fallbacks, false)
+			}
+		case result := <-results:
+			if result.err == nil {
+				return result.conn, nil
+			}
+
+			failed++
+			if result.isPrimary {
+				primaryErr = result.err
+				if !isFallbackStarted {
+					isFallbackStarted = true
+					go dial(fallbacks, false)
+				}
+			} else if primaryErr == nil {
+				primaryErr = result.err
+			}
+			if failed == 2 {
+				return nil, primaryErr
+			}
+		}
+	}
+}
+
+func (d *Dialer) dialSerial(ctx context.Context, network string, addresses []string) (net.Conn, error) {
+	var firstErr error
+	for _, address := range addresses {
+		conn, err := d.dialer.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, firstErr
+}
+
+// NewTransport returns the copy of the default http transport which dials with the dialer.
+func NewTransport(dialer *Dialer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	return transport
+}
+
+func isIPNetwork(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		return true
+	default:
+		return false
+	}
+}
+
+func isIP(host string) bool {
+	if i := strings.IndexByte(host, '%'); i != -1 {
+		host = host[:i]
+	}
+
+	return net.ParseIP(host) != nil
+}
+
+func isIPv4(ip net.IP) bool {
+	return ip.To4() != nil
+}
+
+func isFamilyOf(network string, ip net.IP) bool {
+	switch network[len(network)-1] {
+	case '4':
+		return isIPv4(ip)
+	case '6':
+		return !isIPv4(ip)
+	default:
+		return true
+	}
+}
+
+func isSameIPs(a []net.IP, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sourceAddrs are addresses of the source interface the dialer binds sockets to.
+type sourceAddrs struct {
+	name  string
+	index int
+	ipv4  net.IP
+	ipv6  net.IP
+}
+
+func newSourceAddrs(name string) (*sourceAddrs, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't find source interface %q: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("can't get addresses of source interface %q: %w", name, err)
+	}
+
+	s := &sourceAddrs{name: name, index: iface.Index}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := ipNet.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			if s.ipv4 == nil {
+				s.ipv4 = ip4
+			}
+			continue
+		}
+
+		// global addresses are preferred over link-local ones
+		if s.ipv6 == nil || s.ipv6.IsLinkLocalUnicast() && !ip.IsLinkLocalUnicast() {
+			s.ipv6 = ip
+		}
+	}
+
+	if s.ipv4 == nil && s.ipv6 == nil {
+		return nil, fmt.Errorf("source interface %q has no IP addresses", name)
+	}
+
+	return s, nil
+}
+
+// addr returns the source address of the network, the zone is set for link-local IPv6 addresses.
+func (s *sourceAddrs) addr(network string) (net.IP, string, error) {
+	switch network {
+	case "tcp4", "udp4":
+		if s.ipv4 == nil {
+			return nil, "", fmt.Errorf("source interface %q has no IPv4 address", s.name)
+		}
+		return s.ipv4, "", nil
+	case "tcp6", "udp6":
+		if s.ipv6 == nil {
+			return nil, "", fmt.Errorf("source interface %q has no IPv6 address", s.name)
+		}
+		if s.ipv6.IsLinkLocalUnicast() {
+			return s.ipv6, s.name, nil
+		}
+		return s.ipv6, "", nil
+	default:
+		return nil, "", nil
+	}
+}
@@ -0,0 +1,111 @@
+package netutil
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		isOK    bool
+	}{
+		{address: ":9000", isOK: true},
+		{address: "127.0.0.1:9000", isOK: true},
+		{address: "localhost:9000", isOK: true},
+		{address: "[::]:9000", isOK: true},
+		{address: "[::1]:9000", isOK: true},
+		{address: "[fe80::1%eth0]:9000", isOK: true},
+		{address: "::1:9000", isOK: false},
+		{address: "[::1]", isOK: false},
+		{address: "[::zz]:9000", isOK: false},
+		{address: "localhost", isOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := CheckAddress(tt.address)
+			if tt.isOK {
+				assert.NoError(t, err, "address should be accepted")
+			} else {
+				assert.Error(t, err, "address should be rejected")
+			}
+		})
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	if !hasLoopbackIPv6() {
+		t.Skip("IPv6 isn't available")
+	}
+
+	listener, err := Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+		require.NoError(t, err, "can't connect to %s", host)
+		_ = conn.Close()
+	}
+}
+
+func TestDialerSourceInterface(t *testing.T) {
+	lo := loopbackInterface(t)
+
+	listener, err := Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	dialer, err := NewDialer(time.Second, lo)
+	require.NoError(t, err)
+
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	assert.True(t, conn.LocalAddr().(*net.TCPAddr).IP.IsLoopback(), "connection isn't made from the source interface")
+}
+
+func TestDialerUnknownInterface(t *testing.T) {
+	_, err := NewDialer(time.Second, "file-d-unknown0")
+	assert.Error(t, err, "unknown interface should be rejected")
+}
+
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface.Name
+		}
+	}
+
+	t.Skip("loopback interface isn't found")
+	return ""
+}
+
+func hasLoopbackIPv6() bool {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	_ = listener.Close()
+	return true
+}
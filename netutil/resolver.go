@@ -0,0 +1,104 @@
+package netutil
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCacheTTL is how long resolved addresses are cached by the default resolver.
+const DefaultCacheTTL = 30 * time.Second
+
+// DefaultResolver is shared by dialers of all outputs, so hosts are resolved once for all workers.
+var DefaultResolver = NewResolver(DefaultCacheTTL)
+
+// Resolver caches addresses of hosts. The system resolver doesn't expose TTLs of records,
+// so addresses are cached for the TTL of the resolver which should be less or equal to TTLs of records of sinks.
+// Cached addresses are dropped on connection errors, so dialers don't stick to dead addresses after failovers of sinks.
+type Resolver struct {
+	ttl     time.Duration
+	lookup  func(ctx context.Context, host string) ([]net.IPAddr, error)
+	lookups *singleflight.Group
+
+	mu    *sync.Mutex
+	cache map[string]*resolved
+	now   func() time.Time
+}
+
+type resolved struct {
+	ips       []net.IP
+	expiresAt time.Time
+}
+
+// NewResolver returns the resolver caching addresses for the ttl, zero ttl disables caching.
+func NewResolver(ttl time.Duration) *Resolver {
+	return &Resolver{
+		ttl:     ttl,
+		lookup:  net.DefaultResolver.LookupIPAddr,
+		lookups: &singleflight.Group{},
+		mu:      &sync.Mutex{},
+		cache:   make(map[string]*resolved),
+		now:     time.Now,
+	}
+}
+
+// SetTTL changes the ttl of addresses cached after the call, zero ttl disables caching.
+func (r *Resolver) SetTTL(ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ttl = ttl
+	if ttl == 0 {
+		r.cache = make(map[string]*resolved)
+	}
+}
+
+// LookupIP returns addresses of the host, concurrent lookups of the same host are made once.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	r.mu.Lock()
+	entry, has := r.cache[host]
+	if has && r.now().Before(entry.expiresAt) {
+		r.mu.Unlock()
+		return entry.ips, nil
+	}
+	r.mu.Unlock()
+
+	result, err, _ := r.lookups.Do(host, func() (interface{}, error) {
+		addrs, err := r.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+
+		r.mu.Lock()
+		if r.ttl > 0 {
+			r.cache[host] = &resolved{ips: ips, expiresAt: r.now().Add(r.ttl)}
+		}
+		r.mu.Unlock()
+
+		return ips, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]net.IP), nil
+}
+
+// Invalidate drops cached addresses of the host, so the next dial resolves it again.
+func (r *Resolver) Invalidate(host string) {
+	r.mu.Lock()
+	delete(r.cache, host)
+	r.mu.Unlock()
+}
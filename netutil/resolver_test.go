@@ -0,0 +1,114 @@
+package netutil
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type lookupStub struct {
+	ips     []string
+	lookups int
+}
+
+func (l *lookupStub) lookup(_ context.Context, _ string) ([]net.IPAddr, error) {
+	l.lookups++
+	addrs := make([]net.IPAddr, 0, len(l.ips))
+	for _, ip := range l.ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestResolverCache(t *testing.T) {
+	stub := &lookupStub{ips: []string{"10.0.0.1"}}
+	now := time.Now()
+	r := NewResolver(time.Minute)
+	r.lookup = stub.lookup
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ips, err := r.LookupIP(context.Background(), "sink.test")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", ips[0].String(), "wrong address")
+	}
+	assert.Equal(t, 1, stub.lookups, "addresses aren't cached")
+
+	now = now.Add(2 * time.Minute)
+	stub.ips = []string{"10.0.0.2"}
+	ips, err := r.LookupIP(context.Background(), "sink.test")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ips[0].String(), "expired addresses are returned")
+
+	stub.ips = []string{"10.0.0.3"}
+	r.Invalidate("sink.test")
+	ips, err = r.LookupIP(context.Background(), "sink.test")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", ips[0].String(), "invalidated addresses are returned")
+	assert.Equal(t, 3, stub.lookups, "wrong lookups")
+
+	_, err = r.LookupIP(context.Background(), "10.0.0.4")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stub.lookups, "IP address is resolved")
+
+	r.SetTTL(0)
+	_, _ = r.LookupIP(context.Background(), "sink.test")
+	_, _ = r.LookupIP(context.Background(), "sink.test")
+	assert.Equal(t, 5, stub.lookups, "addresses are cached with zero ttl")
+}
+
+func TestDialerReResolvesOnFailure(t *testing.T) {
+	listener, err := Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	// the sink has moved from the cached address
+	stub := &lookupStub{ips: []string{"127.0.0.2"}}
+	r := NewResolver(time.Minute)
+	r.lookup = stub.lookup
+
+	dialer, err := NewDialer(time.Second, "")
+	require.NoError(t, err)
+	dialer.resolver = r
+
+	_, err = r.LookupIP(context.Background(), "sink.test")
+	require.NoError(t, err)
+
+	stub.ips = []string{"127.0.0.1"}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("sink.test", port))
+	require.NoError(t, err, "host isn't resolved again")
+	_ = conn.Close()
+	assert.Equal(t, 2, stub.lookups, "wrong lookups")
+
+	conn, err = dialer.Dial("tcp", net.JoinHostPort("sink.test", port))
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.Equal(t, 2, stub.lookups, "fresh addresses aren't cached")
+}
+
+func TestDialerHappyEyeballs(t *testing.T) {
+	listener, err := Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	// the IPv6 address is unreachable, so the IPv4 one is used
+	stub := &lookupStub{ips: []string{"100::1", "127.0.0.1"}}
+	r := NewResolver(time.Minute)
+	r.lookup = stub.lookup
+
+	dialer, err := NewDialer(5*time.Second, "")
+	require.NoError(t, err)
+	dialer.resolver = r
+
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("sink.test", port))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String(), "wrong address")
+}
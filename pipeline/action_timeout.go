@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+)
+
+const (
+	// actionWatchdogInterval is how often the watchdog checks actions which are running now
+	actionWatchdogInterval = 200 * time.Millisecond
+	// actionSkipPeriod is how long the action exceeding its time budget is skipped if `action_timeout_skip` is set
+	actionSkipPeriod = time.Minute
+	// actionTimeoutLogInterval limits logs about slow actions of the processor
+	actionTimeoutLogInterval = time.Second
+)
+
+// actionTimeouts counts actions exceeding their time budget set by `action_timeout`.
+// Actions can't be interrupted, so the action is reported by the processor when it returns
+// and by the watchdog while it's still running, e.g. stuck in an external lookup.
+type actionTimeouts struct {
+	exceeded *prometheus.CounterVec
+}
+
+func newActionTimeouts(pipelineName string, registry prometheus.Registerer) *actionTimeouts {
+	t := &actionTimeouts{
+		exceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "action_timeouts_total",
+			Help:      "how many times actions have exceeded their time budget",
+		}, []string{"action", "index"}),
+	}
+
+	registry.MustRegister(t.exceeded)
+
+	return t
+}
+
+func (t *actionTimeouts) count(index int, info *ActionPluginStaticInfo) {
+	t.exceeded.WithLabelValues(info.Type, strconv.Itoa(index)).Inc()
+}
+
+// runningAction is the action the processor is doing now, only actions with the time budget are tracked.
+type runningAction struct {
+	// index is -1 if no tracked action is running
+	index atomic.Int32
+	since atomic.Int64
+	// reported is set by the first of the processor and the watchdog who has counted the timeout of the call
+	reported atomic.Bool
+}
+
+// hasActionTimeouts returns true if any action has the time budget, so the watchdog is needed.
+func (p *Pipeline) hasActionTimeouts() bool {
+	for _, info := range p.actionInfos {
+		if info.Timeout > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchActions reports actions which are running longer than their time budget.
+func (p *Pipeline) watchActions() {
+	ticker := time.NewTicker(actionWatchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		for _, proc := range p.listProcs() {
+			proc.checkRunningAction(now)
+		}
+	}
+}
+
+// startTimed marks the action as running, so the watchdog can find it if it's stuck.
+func (p *processor) startTimed(index int) time.Time {
+	now := time.Now()
+	p.running.reported.Store(false)
+	p.running.since.Store(now.UnixNano())
+	p.running.index.Store(int32(index))
+
+	return now
+}
+
+// finishTimed reports the action which has exceeded its time budget with the event sample,
+// the action is skipped for a while if it's allowed.
+func (p *processor) finishTimed(index int, event *Event, start time.Time) {
+	p.running.index.Store(-1)
+
+	info := p.actionInfos[index]
+	took := time.Since(start)
+	if took <= info.Timeout {
+		return
+	}
+
+	if p.running.reported.CAS(false, true) {
+		p.timeouts.count(index, info)
+	}
+	if info.SkipOnTimeout {
+		p.skippedUntil[index] = time.Now().Add(actionSkipPeriod)
+	}
+
+	if time.Since(p.lastTimeoutLog) < actionTimeoutLogInterval {
+		return
+	}
+	p.lastTimeoutLog = time.Now()
+	p.logger.Warnf("action %q #%d has exceeded its time budget %s, it took %s, skipped=%t, event sample: %s",
+		info.Type, index, info.Timeout, took, info.SkipOnTimeout, event.Root.EncodeToString())
+}
+
+// isActionSkipped returns true if the action has recently exceeded its time budget and may be skipped,
+// busy actions hold events of the stream, so they're never skipped.
+func (p *processor) isActionSkipped(index int) bool {
+	if p.busyActions[index] || p.skippedUntil[index].IsZero() {
+		return false
+	}
+	if time.Now().Before(p.skippedUntil[index]) {
+		return true
+	}
+
+	p.skippedUntil[index] = time.Time{}
+	return false
+}
+
+// checkRunningAction is called by the watchdog, it reads only atomics of the processor.
+func (p *processor) checkRunningAction(now time.Time) {
+	index := int(p.running.index.Load())
+	if index < 0 {
+		return
+	}
+
+	info := p.actionInfos[index]
+	took := now.Sub(time.Unix(0, p.running.since.Load()))
+	if took <= info.Timeout || !p.running.reported.CAS(false, true) {
+		return
+	}
+
+	p.timeouts.count(index, info)
+	p.logger.Warnf("action %q #%d of processor %d is running for %s over its time budget %s", info.Type, index, p.id, took, info.Timeout)
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowActionStub marks events and sleeps on events with the `slow` field.
+type slowActionStub struct {
+	delay time.Duration
+}
+
+func (a *slowActionStub) Start(AnyConfig, *ActionPluginParams) {}
+func (a *slowActionStub) Stop()                                {}
+func (a *slowActionStub) Do(event *Event) ActionResult {
+	if event.Root.Dig("slow") != nil {
+		time.Sleep(a.delay)
+	}
+	event.Root.AddFieldNoAlloc(event.Root, "done").MutateToString("true")
+	return ActionPass
+}
+
+func newTimedProc(t *testing.T, skip bool) (*Pipeline, *processor) {
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &outputStub{}}})
+	p.AddAction(&ActionPluginStaticInfo{
+		PluginStaticInfo: &PluginStaticInfo{
+			Type: "slow",
+			Factory: func() (AnyPlugin, AnyConfig) {
+				return &slowActionStub{delay: 30 * time.Millisecond}, nil
+			},
+		},
+		MatchConditions: MatchConditions{},
+		Timeout:         10 * time.Millisecond,
+		SkipOnTimeout:   skip,
+	})
+	require.True(t, p.hasActionTimeouts())
+
+	return p, p.newProc(0)
+}
+
+func doTimed(t *testing.T, proc *processor, json string) *Event {
+	event := newEvent()
+	require.NoError(t, event.Root.DecodeString(json))
+	assert.True(t, proc.doActions(event), "event isn't passed")
+
+	return event
+}
+
+func TestActionTimeout(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		p, proc := newTimedProc(t, skip)
+
+		event := doTimed(t, proc, `{"a":1}`)
+		assert.NotNil(t, event.Root.Dig("done"), "action isn't done")
+		assert.Equal(t, float64(0), testutil.ToFloat64(p.actionTimeouts.exceeded.WithLabelValues("slow", "0")), "fast action is counted")
+
+		doTimed(t, proc, `{"slow":1}`)
+		assert.Equal(t, float64(1), testutil.ToFloat64(p.actionTimeouts.exceeded.WithLabelValues("slow", "0")), "slow action isn't counted")
+
+		// the action is skipped for a while only if it's allowed
+		event = doTimed(t, proc, `{"a":2}`)
+		assert.Equal(t, skip, event.Root.Dig("done") == nil, "wrong skipping of the action, skip=%t", skip)
+
+		proc.skippedUntil[0] = time.Now().Add(-time.Second)
+		event = doTimed(t, proc, `{"a":3}`)
+		assert.NotNil(t, event.Root.Dig("done"), "action is skipped after the skip period")
+	}
+}
+
+func TestActionWatchdog(t *testing.T) {
+	p, proc := newTimedProc(t, false)
+
+	now := time.Now()
+	proc.checkRunningAction(now)
+	assert.Equal(t, float64(0), testutil.ToFloat64(p.actionTimeouts.exceeded.WithLabelValues("slow", "0")), "no action is running")
+
+	start := proc.startTimed(0)
+	proc.checkRunningAction(now)
+	assert.Equal(t, float64(0), testutil.ToFloat64(p.actionTimeouts.exceeded.WithLabelValues("slow", "0")), "action is within the budget")
+
+	// the stuck action is counted once by the watchdog and isn't counted again when it returns
+	proc.checkRunningAction(start.Add(time.Second))
+	proc.checkRunningAction(start.Add(2 * time.Second))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.actionTimeouts.exceeded.WithLabelValues("slow", "0")), "stuck action isn't counted once")
+
+	event := newEvent()
+	require.NoError(t, event.Root.DecodeString(`{}`))
+	proc.finishTimed(0, event, start.Add(-time.Second))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.actionTimeouts.exceeded.WithLabelValues("slow", "0")), "stuck action is counted twice")
+}
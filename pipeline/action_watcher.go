@@ -3,6 +3,9 @@ package pipeline
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,14 +28,83 @@ type actionWatcher struct {
 // It holds an event before the action processing and what it's become after the processing.
 type sample struct {
 	procID      int
+	filter      *sampleFilter
 	readyCh     chan bool
 	eventBefore []byte
 	eventAfter  []byte
 	eventStatus eventStatus
 }
 
+const (
+	defaultSampleTimeout = 5 * time.Second
+	maxSampleTimeout     = time.Minute
+)
+
 var errTimeout = errors.New("timeout while wait an action sample")
 
+// sampleFilter selects the kind of events to sample, the event is checked before the action.
+type sampleFilter struct {
+	conditions MatchConditions
+	mode       MatchMode
+	stream     StreamName
+}
+
+// parseSampleFilter parses query params of the `/sample` endpoint:
+// `match_fields` is repeated as `field:value`, values starting with `/` are regular expressions,
+// `match_mode` is `and` or `or`, `stream` is the stream name and `timeout` is how long to wait for the sample.
+// The filter is nil if the kind of events isn't set.
+func parseSampleFilter(query url.Values) (*sampleFilter, time.Duration, error) {
+	timeout := defaultSampleTimeout
+	if str := query.Get("timeout"); str != "" {
+		t, err := time.ParseDuration(str)
+		if err != nil || t <= 0 || t > maxSampleTimeout {
+			return nil, 0, fmt.Errorf("timeout should be a duration from 0 to %s: %q", maxSampleTimeout, str)
+		}
+		timeout = t
+	}
+
+	mode := MatchModeAnd
+	switch query.Get("match_mode") {
+	case "", "and":
+	case "or":
+		mode = MatchModeOr
+	default:
+		return nil, 0, fmt.Errorf("unknown match mode %q must be or/and", query.Get("match_mode"))
+	}
+
+	fields := make(map[string]string)
+	for _, matcher := range query["match_fields"] {
+		pos := strings.IndexByte(matcher, ':')
+		if pos <= 0 {
+			return nil, 0, fmt.Errorf("match fields should be like field:value: %q", matcher)
+		}
+		fields[matcher[:pos]] = matcher[pos+1:]
+	}
+	conditions, err := NewMatchConditions(fields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stream := StreamName(query.Get("stream"))
+	if len(conditions) == 0 && stream == "" {
+		return nil, timeout, nil
+	}
+
+	return &sampleFilter{conditions: conditions, mode: mode, stream: stream}, timeout, nil
+}
+
+// isMatch returns true for any event if the filter is nil.
+func (f *sampleFilter) isMatch(event *Event) bool {
+	if f == nil {
+		return true
+	}
+	if f.stream != "" && event.streamName != f.stream {
+		return false
+	}
+
+	return len(f.conditions) == 0 || f.conditions.IsMatch(event, f.mode)
+}
+
 // newActionWatcher creates actionWatcher for the given processor.
 func newActionWatcher(procID int) *actionWatcher {
 	return &actionWatcher{
@@ -43,13 +115,13 @@ func newActionWatcher(procID int) *actionWatcher {
 	}
 }
 
-// watch adds a sample and waits until the processor fill it.
+// watch adds a sample and waits until the processor fill it with the event matching the filter.
 // Every processor has its actionWatcher. When the watcher adds the sample,
 // the process tries to fill it (via setEventBefore and setEventAfter methods),
 // and then send `true` to `readyCh`. The watcher then returns the sample
 // and deletes it from waiting samples.
-func (aw *actionWatcher) watch(actionIdx int, timeout time.Duration) (*sample, error) {
-	s := aw.addSample(actionIdx)
+func (aw *actionWatcher) watch(actionIdx int, timeout time.Duration, filter *sampleFilter) (*sample, error) {
+	s := aw.addSample(actionIdx, filter)
 	defer aw.deleteSample(actionIdx, s)
 
 	select {
@@ -60,9 +132,10 @@ func (aw *actionWatcher) watch(actionIdx int, timeout time.Duration) (*sample, e
 	}
 }
 
-func (aw *actionWatcher) addSample(actionIdx int) *sample {
+func (aw *actionWatcher) addSample(actionIdx int, filter *sampleFilter) *sample {
 	s := &sample{
 		procID:      aw.procID,
+		filter:      filter,
 		readyCh:     make(chan bool, 1),
 		eventBefore: nil,
 		eventAfter:  nil,
@@ -97,7 +170,7 @@ func (aw *actionWatcher) deleteSample(actionIdx int, sample *sample) {
 	aw.samplesLen.Dec()
 }
 
-// setEventBefore sets events before for every sample.
+// setEventBefore sets events before for every sample which filter matches the event.
 func (aw *actionWatcher) setEventBefore(index int, event *Event) {
 	if aw.samplesLen.Load() <= 0 {
 		return
@@ -107,7 +180,7 @@ func (aw *actionWatcher) setEventBefore(index int, event *Event) {
 	defer aw.samplesMu.Unlock()
 
 	for _, s := range aw.samples[index] {
-		if s == nil || len(s.eventBefore) > 0 {
+		if s == nil || len(s.eventBefore) > 0 || !s.filter.isMatch(event) {
 			continue
 		}
 		s.eventBefore = event.Root.EncodeToByte()
@@ -126,7 +199,7 @@ func (aw *actionWatcher) setEventAfter(index int, event *Event, result eventStat
 
 	for _, s := range aw.samples[index] {
 		if s == nil || len(s.eventAfter) > 0 || len(s.eventBefore) == 0 {
-			return
+			continue
 		}
 
 		s.eventAfter = event.Root.EncodeToByte()
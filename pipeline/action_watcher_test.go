@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSampleFilter(t *testing.T) {
+	filter, timeout, err := parseSampleFilter(url.Values{})
+	require.NoError(t, err)
+	assert.Nil(t, filter, "filter is set without params")
+	assert.Equal(t, defaultSampleTimeout, timeout, "wrong default timeout")
+
+	filter, timeout, err = parseSampleFilter(url.Values{
+		"match_fields": {"service:billing", "level:/^err/"},
+		"match_mode":   {"or"},
+		"stream":       {"stderr"},
+		"timeout":      {"30s"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, filter)
+	assert.Len(t, filter.conditions, 2, "wrong conditions")
+	assert.Equal(t, MatchModeOr, filter.mode, "wrong match mode")
+	assert.Equal(t, StreamName("stderr"), filter.stream, "wrong stream")
+	assert.Equal(t, 30*time.Second, timeout, "wrong timeout")
+
+	for _, query := range []url.Values{
+		{"match_fields": {"service"}},
+		{"match_fields": {"level:/[/"}},
+		{"match_mode": {"xor"}},
+		{"timeout": {"1h"}},
+		{"timeout": {"-1s"}},
+	} {
+		_, _, err = parseSampleFilter(query)
+		assert.Error(t, err, "wrong query is accepted: %v", query)
+	}
+}
+
+func TestActionWatcherFilter(t *testing.T) {
+	filter, _, err := parseSampleFilter(url.Values{"match_fields": {"service:billing"}, "stream": {"stderr"}})
+	require.NoError(t, err)
+
+	aw := newActionWatcher(1)
+	s := aw.addSample(0, filter)
+	first := aw.addSample(0, nil)
+
+	newSampled := func(json string, stream StreamName) *Event {
+		event := newEvent()
+		require.NoError(t, event.Root.DecodeString(json))
+		event.streamName = stream
+		return event
+	}
+
+	for _, event := range []*Event{
+		newSampled(`{"service":"payments"}`, "stderr"),
+		newSampled(`{"service":"billing"}`, "stdout"),
+		newSampled(`{"service":"billing","n":1}`, "stderr"),
+	} {
+		aw.setEventBefore(0, event)
+		event.Root.AddFieldNoAlloc(event.Root, "done").MutateToString("true")
+		aw.setEventAfter(0, event, eventStatusPassed)
+	}
+
+	select {
+	case <-s.ready():
+	default:
+		t.Fatal("sample of the matching event isn't ready")
+	}
+	assert.Equal(t, `{"service":"billing","n":1}`, string(s.eventBefore), "wrong sampled event")
+
+	result := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(first.Marshal(), &result))
+	assert.Equal(t, map[string]interface{}{"service": "payments"}, result["event_before"], "sample without the filter should get the first event")
+}
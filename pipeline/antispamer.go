@@ -1,31 +1,63 @@
 package pipeline
 
 import (
+	"encoding/json"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ozonru/file.d/logger"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/atomic"
 )
 
+const antispamBanMessage = "source is banned by antispam"
+
 type antispamer struct {
 	unbanIterations int
 	threshold       int
+	interval        time.Duration
 	mu              *sync.RWMutex
 	counters        map[SourceID]*atomic.Int32
+
+	// bans are sources banned since the last report, they're reported if the events are enabled
+	bansMu      *sync.Mutex
+	bans        map[SourceID]*antispamBan
+	bannedTotal prometheus.Counter
+	// banned are names of sources banned right now
+	banned map[SourceID]string
+}
+
+type antispamBan struct {
+	id      SourceID
+	name    string
+	dropped int64
 }
 
-func newAntispamer(threshold int, unbanIterations int, maintenanceInterval time.Duration) *antispamer {
+func newAntispamer(pipelineName string, threshold int, unbanIterations int, maintenanceInterval time.Duration, registry prometheus.Registerer) *antispamer {
 	if threshold != 0 {
-		logger.Infof("antispam enabled, threshold=%d/%d sec", threshold, maintenanceInterval / time.Second)
+		logger.Infof("antispam enabled, threshold=%d/%d sec", threshold, maintenanceInterval/time.Second)
 	}
 
-	return &antispamer{
+	a := &antispamer{
 		threshold:       threshold,
 		unbanIterations: unbanIterations,
+		interval:        maintenanceInterval,
 		counters:        make(map[SourceID]*atomic.Int32),
 		mu:              &sync.RWMutex{},
+		bansMu:          &sync.Mutex{},
+		bans:            make(map[SourceID]*antispamBan),
+		banned:          make(map[SourceID]string),
+		bannedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "antispam_banned_sources_total",
+			Help:      "how many times sources are banned by antispam",
+		}),
 	}
+	registry.MustRegister(a.bannedTotal)
+
+	return a
 }
 
 func (p *antispamer) isSpam(id SourceID, name string, isNewSource bool) bool {
@@ -53,9 +85,82 @@ func (p *antispamer) isSpam(id SourceID, name string, isNewSource bool) bool {
 	if x == int32(p.threshold) {
 		value.Swap(int32(p.unbanIterations * p.threshold))
 		logger.Warnf("antispam: source has been banned id=%d, name=%s", id, name)
+		p.ban(id, name)
+	}
+
+	isSpam := x >= int32(p.threshold)
+	if isSpam {
+		p.countDropped(id)
 	}
 
-	return x >= int32(p.threshold)
+	return isSpam
+}
+
+func (p *antispamer) ban(id SourceID, name string) {
+	p.bannedTotal.Inc()
+
+	p.bansMu.Lock()
+	if _, has := p.bans[id]; !has {
+		p.bans[id] = &antispamBan{id: id, name: name}
+	}
+	p.banned[id] = name
+	p.bansMu.Unlock()
+}
+
+func (p *antispamer) countDropped(id SourceID) {
+	p.bansMu.Lock()
+	if ban, has := p.bans[id]; has {
+		ban.dropped++
+	}
+	p.bansMu.Unlock()
+}
+
+// collectBans returns sources banned since the last call along with the count of their dropped events.
+func (p *antispamer) collectBans() []*antispamBan {
+	p.bansMu.Lock()
+	defer p.bansMu.Unlock()
+
+	if len(p.bans) == 0 {
+		return nil
+	}
+
+	bans := make([]*antispamBan, 0, len(p.bans))
+	for _, ban := range p.bans {
+		bans = append(bans, ban)
+	}
+	p.bans = make(map[SourceID]*antispamBan)
+
+	return bans
+}
+
+// bannedSources returns sources banned right now ordered by id.
+func (p *antispamer) bannedSources() []bannedSourceResp {
+	p.bansMu.Lock()
+	defer p.bansMu.Unlock()
+
+	result := make([]bannedSourceResp, 0, len(p.banned))
+	for id, name := range p.banned {
+		result = append(result, bannedSourceResp{ID: id, Name: name})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result
+}
+
+func (p *antispamer) encodeBan(ban *antispamBan, labels map[string]string) ([]byte, error) {
+	event := make(map[string]interface{}, len(labels)+6)
+	// labels can't override the event fields
+	for k, v := range labels {
+		event[k] = v
+	}
+	event["message"] = antispamBanMessage
+	event["antispam_banned"] = true
+	event["source"] = ban.name
+	event["count"] = ban.dropped
+	event["threshold"] = p.threshold
+	event["interval"] = p.interval.String()
+
+	return json.Marshal(event)
 }
 
 func (p *antispamer) maintenance() {
@@ -76,6 +181,9 @@ func (p *antispamer) maintenance() {
 
 		if isMore && x < p.threshold {
 			logger.Infof("antispam: source has been unbanned id=%d", source)
+			p.bansMu.Lock()
+			delete(p.banned, source)
+			p.bansMu.Unlock()
 		}
 
 		if x > p.unbanIterations*p.threshold {
@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAntispamBans(t *testing.T) {
+	a := newAntispamer("test", 3, antispamUnbanIterations, time.Second, prometheus.NewRegistry())
+
+	for i := 0; i < 5; i++ {
+		a.isSpam(1, "noisy.log", false)
+	}
+	a.isSpam(2, "quiet.log", false)
+
+	bans := a.collectBans()
+	require.Equal(t, 1, len(bans), "wrong bans count")
+	assert.Equal(t, SourceID(1), bans[0].id, "wrong banned source")
+	assert.Equal(t, int64(3), bans[0].dropped, "wrong dropped events count")
+	assert.Equal(t, float64(1), testutil.ToFloat64(a.bannedTotal), "wrong banned sources metric")
+
+	a.isSpam(1, "noisy.log", false)
+	assert.Empty(t, a.collectBans(), "ban should be reported once")
+	assert.Equal(t, []bannedSourceResp{{ID: 1, Name: "noisy.log"}}, a.bannedSources(), "wrong banned sources")
+
+	// the counter is capped by the unban iterations and goes down by the threshold each maintenance
+	for i := 0; i <= antispamUnbanIterations; i++ {
+		a.maintenance()
+	}
+	assert.Empty(t, a.bannedSources(), "source isn't unbanned")
+}
+
+func TestAntispamBanEvent(t *testing.T) {
+	a := newAntispamer("test", 100, antispamUnbanIterations, 5*time.Second, prometheus.NewRegistry())
+
+	data, err := a.encodeBan(&antispamBan{id: 1, name: "noisy.log", dropped: 42}, map[string]string{"dc": "eu1", "count": "ignored"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"dc":"eu1","message":"source is banned by antispam","antispam_banned":true,"source":"noisy.log","count":42,"threshold":100,"interval":"5s"}`, string(data))
+}
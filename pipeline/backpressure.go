@@ -0,0 +1,255 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// backpressure pauses the input while some output signals it's saturated, e.g. it's retrying requests,
+// so events don't pile up until the event pool is exhausted and the pipeline stalls inside the streamer.
+// Inputs implementing `InputPauser` pause reading themselves,
+// calls of `In` from other inputs are blocked until all outputs are resumed.
+// In the spill mode of the holder events are spilled to the disk instead, so inputs aren't paused.
+// The input may also be paused by the request via `POST /pipelines/<pipeline_name>/pause`.
+type backpressure struct {
+	logger *zap.SugaredLogger
+
+	mu        *sync.Mutex
+	saturated map[OutputPluginController]string
+	pauser    InputPauser
+	gate      *InputGate
+	holder    *holder
+	spilling  bool
+	// isPaused is true if the input is paused either by outputs or by the request
+	isPaused          bool
+	isPausedByRequest bool
+
+	paused prometheus.Gauge
+}
+
+func newBackpressure(pipelineName string, logger *zap.SugaredLogger, registry prometheus.Registerer) *backpressure {
+	b := &backpressure{
+		logger:    logger,
+		mu:        &sync.Mutex{},
+		saturated: make(map[OutputPluginController]string),
+		gate:      NewInputGate(),
+		paused: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "input_paused",
+			Help:      "1 if the input is paused since some output signals backpressure or by the request",
+		}),
+	}
+
+	registry.MustRegister(b.paused)
+
+	return b
+}
+
+// set marks the output as saturated or not, the input is paused while any output is saturated.
+func (b *backpressure) set(output OutputPluginController, outputType string, saturated bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, was := b.saturated[output]
+	if was == saturated {
+		return
+	}
+
+	if saturated {
+		b.saturated[output] = outputType
+		b.logger.Warnf("output %q signals backpressure, saturated outputs=%d", outputType, len(b.saturated))
+		if len(b.saturated) == 1 && b.holder != nil && b.holder.spill() {
+			b.spilling = true
+		}
+	} else {
+		delete(b.saturated, output)
+		b.logger.Infof("output %q is resumed, saturated outputs=%d", outputType, len(b.saturated))
+		if len(b.saturated) == 0 && b.spilling {
+			b.spilling = false
+			b.holder.unspill()
+		}
+	}
+
+	b.update()
+}
+
+// setPausedByRequest pauses the input regardless of outputs, e.g. during maintenance of the sink,
+// events already in the pipeline are still delivered.
+func (b *backpressure) setPausedByRequest(paused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.isPausedByRequest == paused {
+		return
+	}
+	b.isPausedByRequest = paused
+	if paused {
+		b.logger.Warnf("input is paused by the request")
+	} else {
+		b.logger.Infof("input is resumed by the request")
+	}
+
+	b.update()
+}
+
+// update pauses or resumes the input, mu should be locked.
+func (b *backpressure) update() {
+	shouldPause := b.isPausedByRequest || len(b.saturated) != 0 && !b.spilling
+	if shouldPause == b.isPaused {
+		return
+	}
+
+	b.isPaused = shouldPause
+	if shouldPause {
+		b.pause()
+	} else {
+		b.resume()
+	}
+}
+
+func (b *backpressure) status() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return map[string]interface{}{
+		"paused":            b.isPaused,
+		"paused_by_request": b.isPausedByRequest,
+		"saturated_outputs": len(b.saturated),
+	}
+}
+
+func (b *backpressure) pause() {
+	b.logger.Warnf("pausing input")
+	b.paused.Set(1)
+	if b.pauser != nil {
+		b.pauser.PauseInput()
+		return
+	}
+	b.gate.Pause()
+}
+
+func (b *backpressure) resume() {
+	b.logger.Infof("resuming input")
+	b.paused.Set(0)
+	if b.pauser != nil {
+		b.pauser.ResumeInput()
+		return
+	}
+	b.gate.Resume()
+}
+
+// wait blocks the input which can't pause itself while the input is paused.
+func (b *backpressure) wait() {
+	if b.pauser != nil {
+		return
+	}
+	b.gate.Wait(nil)
+}
+
+// stop wakes up blocked inputs.
+func (b *backpressure) stop() {
+	b.gate.Stop()
+}
+
+// InputGate blocks reading of the input while it's paused,
+// inputs implementing `InputPauser` may use it to wait for the resume before passing lines to the pipeline.
+type InputGate struct {
+	mu *sync.Mutex
+	// resumed is closed unless the gate is paused
+	resumed chan struct{}
+	stopped bool
+}
+
+func NewInputGate() *InputGate {
+	resumed := make(chan struct{})
+	close(resumed)
+
+	return &InputGate{
+		mu:      &sync.Mutex{},
+		resumed: resumed,
+	}
+}
+
+func (g *InputGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.stopped || !g.isOpen() {
+		return
+	}
+	g.resumed = make(chan struct{})
+}
+
+func (g *InputGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.isOpen() {
+		return
+	}
+	close(g.resumed)
+}
+
+// Stop opens the gate forever, so stopping inputs aren't blocked.
+func (g *InputGate) Stop() {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+
+	g.Resume()
+}
+
+// Wait blocks until the gate is resumed, it returns false if the done channel is closed first.
+func (g *InputGate) Wait(done <-chan struct{}) bool {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// isOpen should be called under the lock.
+func (g *InputGate) isOpen() bool {
+	select {
+	case <-g.resumed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pipeline) servePause(w http.ResponseWriter, r *http.Request) {
+	p.servePauseAction(w, r, true)
+}
+
+func (p *Pipeline) serveResume(w http.ResponseWriter, r *http.Request) {
+	p.servePauseAction(w, r, false)
+}
+
+func (p *Pipeline) servePauseAction(w http.ResponseWriter, r *http.Request, paused bool) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeErr(w, "Use the POST method.")
+		return
+	}
+
+	p.backpressure.setPausedByRequest(paused)
+
+	status := p.backpressure.status()
+	// events which are still draining to outputs
+	status["in_flight"] = p.eventPool.inUse()
+	resp, _ := json.Marshal(status)
+	_, _ = w.Write(resp)
+}
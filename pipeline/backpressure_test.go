@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+type inputPauserMock struct {
+	paused bool
+	calls  int
+}
+
+func (p *inputPauserMock) PauseInput() {
+	p.paused = true
+	p.calls++
+}
+
+func (p *inputPauserMock) ResumeInput() {
+	p.paused = false
+	p.calls++
+}
+
+func TestBackpressure(t *testing.T) {
+	b := newBackpressure("test", logger.Instance, prometheus.NewRegistry())
+	pauser := &inputPauserMock{}
+	b.pauser = pauser
+
+	first := &batcherTail{}
+	second := &batcherTail{}
+
+	b.set(first, "first", true)
+	assert.True(t, pauser.paused, "input should be paused by the saturated output")
+
+	b.set(first, "first", true)
+	b.set(second, "second", true)
+	assert.Equal(t, 1, pauser.calls, "input should be paused once")
+
+	b.set(first, "first", false)
+	assert.True(t, pauser.paused, "input should be paused while some output is saturated")
+
+	b.set(second, "second", false)
+	assert.False(t, pauser.paused, "input should be resumed when all outputs are resumed")
+	assert.Equal(t, 2, pauser.calls)
+}
+
+func TestPauseByRequest(t *testing.T) {
+	b := newBackpressure("test", logger.Instance, prometheus.NewRegistry())
+	pauser := &inputPauserMock{}
+	b.pauser = pauser
+	output := &batcherTail{}
+
+	b.setPausedByRequest(true)
+	assert.True(t, pauser.paused, "input should be paused by the request")
+
+	b.set(output, "output", true)
+	b.set(output, "output", false)
+	assert.True(t, pauser.paused, "input should be paused until it's resumed by the request")
+
+	b.set(output, "output", true)
+	b.setPausedByRequest(false)
+	assert.True(t, pauser.paused, "input should be paused while the output is saturated")
+
+	b.set(output, "output", false)
+	assert.False(t, pauser.paused, "input should be resumed")
+	assert.Equal(t, 2, pauser.calls, "input should be paused once")
+}
+
+func TestServePause(t *testing.T) {
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+
+	w := httptest.NewRecorder()
+	p.servePause(w, httptest.NewRequest("GET", "/pipelines/test/pause", nil))
+	assert.Equal(t, 405, w.Code, "wrong status")
+
+	w = httptest.NewRecorder()
+	p.servePause(w, httptest.NewRequest("POST", "/pipelines/test/pause", nil))
+	assert.Equal(t, 200, w.Code, "wrong status")
+	assert.JSONEq(t, `{"paused":true,"paused_by_request":true,"saturated_outputs":0,"in_flight":0}`, w.Body.String(), "wrong status")
+	assert.False(t, p.backpressure.gate.isOpen(), "input should be paused")
+
+	w = httptest.NewRecorder()
+	p.serveResume(w, httptest.NewRequest("POST", "/pipelines/test/resume", nil))
+	assert.Equal(t, 200, w.Code, "wrong status")
+	assert.JSONEq(t, `{"paused":false,"paused_by_request":false,"saturated_outputs":0,"in_flight":0}`, w.Body.String(), "wrong status")
+	assert.True(t, p.backpressure.gate.isOpen(), "input should be resumed")
+}
+
+func TestBackpressureBlocksIn(t *testing.T) {
+	b := newBackpressure("test", logger.Instance, prometheus.NewRegistry())
+	output := &batcherTail{}
+
+	b.set(output, "output", true)
+
+	done := make(chan struct{})
+	go func() {
+		b.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("input isn't blocked while the output is saturated")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.set(output, "output", false)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("input isn't resumed")
+	}
+}
+
+func TestInputGate(t *testing.T) {
+	g := NewInputGate()
+	assert.True(t, g.Wait(nil), "new gate should be open")
+
+	g.Pause()
+	g.Pause()
+	done := make(chan struct{})
+	close(done)
+	assert.False(t, g.Wait(done), "paused gate should wait")
+
+	g.Resume()
+	g.Resume()
+	assert.True(t, g.Wait(nil))
+
+	g.Pause()
+	g.Stop()
+	assert.True(t, g.Wait(nil), "stopped gate should be open")
+
+	g.Pause()
+	assert.True(t, g.Wait(nil), "stopped gate can't be paused")
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"github.com/ozonru/file.d/cfg"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+)
+
+const (
+	// BalanceStream puts events of the same source and the same value of the stream field into the same stream.
+	BalanceStream = "stream"
+	// BalanceField puts events with the same value of the balance field into the same stream,
+	// so events are processed in order per the key, e.g. `request_id`, rather than per the source.
+	BalanceField = "field"
+	// BalanceRoundRobin spreads events across processors in turn, the order isn't preserved.
+	BalanceRoundRobin = "round_robin"
+	// BalanceLeastLoaded puts the event into the stream with the fewest queued events, the order isn't preserved.
+	BalanceLeastLoaded = "least_loaded"
+
+	// balanceBuckets is the number of streams keys of the balance field are hashed to,
+	// streams are never removed, so keys can't have their own streams.
+	balanceBuckets = 1024
+)
+
+// balancer chooses the stream of the event. Streams are processed by one processor at a time,
+// so the stream is the unit of the order and of the parallelism.
+type balancer struct {
+	strategy string
+	field    []string
+
+	procCount *atomic.Int32
+	next      atomic.Uint64
+	streamer  *streamer
+}
+
+// newBalancer returns the balancer, `procCount` is set when processors are created.
+func newBalancer(strategy string, field string, streamer *streamer) *balancer {
+	if strategy == "" {
+		strategy = BalanceStream
+	}
+
+	return &balancer{
+		strategy: strategy,
+		field:    cfg.ParseFieldSelector(field),
+		streamer: streamer,
+	}
+}
+
+// streamID returns the source id of the stream of the event, it's the source of the event for the `stream` strategy.
+// The source id of the event isn't changed, since inputs commit events by it.
+func (b *balancer) streamID(event *Event) SourceID {
+	switch b.strategy {
+	case BalanceField:
+		return SourceID(hashNode(event.Root.Dig(b.field...)) % balanceBuckets)
+	case BalanceRoundRobin:
+		return SourceID(b.next.Inc() % uint64(b.procCount.Load()))
+	case BalanceLeastLoaded:
+		return b.leastLoaded()
+	default:
+		return event.SourceID
+	}
+}
+
+// leastLoaded returns the stream with the fewest queued events out of a stream per processor,
+// the first one is chosen out of equally loaded streams starting from the next one in turn.
+func (b *balancer) leastLoaded() SourceID {
+	count := uint64(b.procCount.Load())
+	start := b.next.Inc()
+
+	best := SourceID(start % count)
+	bestLen := b.streamer.getStream(best, DefaultStreamName).queued()
+	for i := uint64(1); i < count && bestLen != 0; i++ {
+		id := SourceID((start + i) % count)
+		if l := b.streamer.getStream(id, DefaultStreamName).queued(); l < bestLen {
+			best, bestLen = id, l
+		}
+	}
+
+	return best
+}
+
+// isStreamFieldUsed is false if the stream name is the default one since events of a stream share the key anyway.
+func (b *balancer) isStreamFieldUsed() bool {
+	return b.strategy == BalanceStream
+}
+
+// hashNode returns FNV-1a hash of the value of the node, events without the field share the same hash.
+func hashNode(node *insaneJSON.Node) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+
+	hash := uint64(offset)
+	if node == nil {
+		return hash
+	}
+
+	value := node.AsString()
+	for i := 0; i < len(value); i++ {
+		hash ^= uint64(value[i])
+		hash *= prime
+	}
+
+	return hash
+}
@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+)
+
+func newBalanceEvent(t *testing.T, sourceID SourceID, json string) *Event {
+	root, err := insaneJSON.DecodeString(json)
+	require.NoError(t, err)
+	t.Cleanup(func() { insaneJSON.Release(root) })
+
+	return &Event{Root: root, SourceID: sourceID}
+}
+
+func TestBalanceField(t *testing.T) {
+	b := newBalancer(BalanceField, "request.id", newStreamer())
+	b.procCount = atomic.NewInt32(4)
+
+	first := newBalanceEvent(t, 1, `{"request":{"id":"a"}}`)
+	second := newBalanceEvent(t, 2, `{"request":{"id":"a"}}`)
+	assert.Equal(t, b.streamID(first), b.streamID(second), "events with the same key are in different streams")
+	assert.Equal(t, SourceID(2), second.SourceID, "source id of the event is changed")
+	assert.False(t, b.isStreamFieldUsed(), "stream field is used")
+
+	streams := make(map[SourceID]bool)
+	for i := 0; i < 100; i++ {
+		id := b.streamID(newBalanceEvent(t, 1, fmt.Sprintf(`{"request":{"id":"%d"}}`, i)))
+		assert.Less(t, int(id), balanceBuckets, "wrong stream")
+		streams[id] = true
+	}
+	assert.Greater(t, len(streams), 50, "keys aren't spread across streams")
+
+	missing := newBalanceEvent(t, 1, `{}`)
+	assert.Equal(t, b.streamID(missing), b.streamID(newBalanceEvent(t, 2, `{}`)), "events without the key are in different streams")
+}
+
+func TestBalanceRoundRobin(t *testing.T) {
+	b := newBalancer(BalanceRoundRobin, "", newStreamer())
+	b.procCount = atomic.NewInt32(3)
+
+	event := newBalanceEvent(t, 5, `{}`)
+	ids := make([]SourceID, 0)
+	for i := 0; i < 6; i++ {
+		ids = append(ids, b.streamID(event))
+	}
+
+	assert.Equal(t, []SourceID{1, 2, 0, 1, 2, 0}, ids, "wrong streams")
+	assert.Equal(t, SourceID(5), event.SourceID, "source id of the event is changed")
+}
+
+func TestBalanceLeastLoaded(t *testing.T) {
+	s := newStreamer()
+	b := newBalancer(BalanceLeastLoaded, "", s)
+	b.procCount = atomic.NewInt32(3)
+
+	// streams 0 and 1 have queued events, so the event goes to the stream 2
+	s.getStream(0, DefaultStreamName).len = 5
+	s.getStream(1, DefaultStreamName).len = 2
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, SourceID(2), b.streamID(newBalanceEvent(t, 1, `{}`)), "event isn't put into the least loaded stream")
+	}
+
+	s.getStream(2, DefaultStreamName).len = 3
+	assert.Equal(t, SourceID(1), b.streamID(newBalanceEvent(t, 1, `{}`)), "event isn't put into the least loaded stream")
+}
+
+func TestBalanceStream(t *testing.T) {
+	b := newBalancer("", "", newStreamer())
+
+	assert.Equal(t, BalanceStream, b.strategy, "wrong default strategy")
+	assert.True(t, b.isStreamFieldUsed(), "stream field isn't used")
+	assert.Equal(t, SourceID(3), b.streamID(newBalanceEvent(t, 3, `{}`)), "event isn't in the stream of its source")
+}
@@ -4,16 +4,27 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ozonru/file.d/cfg"
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/longpanic"
+	"go.uber.org/atomic"
 )
 
 type Batch struct {
 	Events    []*Event
+	key       string
 	seq       int64
 	size      int
 	timeout   time.Duration
 	startTime time.Time
+
+	maxEventAge time.Duration
+	isExpired   bool
+
+	// positions of events in the commit queue, they're set only if batches are partitioned
+	positions []int64
+	// err is set by the output if the batch isn't delivered
+	err error
 }
 
 func newBatch(size int, timeout time.Duration) *Batch {
@@ -30,16 +41,43 @@ func newBatch(size int, timeout time.Duration) *Batch {
 
 func (b *Batch) reset() {
 	b.Events = b.Events[:0]
+	b.positions = b.positions[:0]
+	b.key = ""
 	b.startTime = time.Now()
+	b.isExpired = false
+	b.err = nil
 }
 
 func (b *Batch) append(e *Event) {
 	b.Events = append(b.Events, e)
 }
 
+// Key returns the value of the partition field which is the same for all events of the batch,
+// it's empty if batches aren't partitioned.
+func (b *Batch) Key() string {
+	return b.key
+}
+
+// IsExpired returns true if the batch has events older than `max_event_age` of the pipeline.
+// Outputs should stop retrying the expired batch, the batcher sheds its events.
+func (b *Batch) IsExpired() bool {
+	if b.isExpired {
+		return true
+	}
+
+	b.isExpired = hasExpired(b.Events, b.maxEventAge, time.Now())
+	return b.isExpired
+}
+
+// Fail marks the batch as not delivered, e.g. some events are rejected by the receiver.
+// In the at least once delivery mode the batcher retries the batch instead of committing its events.
+func (b *Batch) Fail(err error) {
+	b.err = err
+}
+
 func (b *Batch) isReady() bool {
 	l := len(b.Events)
-	isFull := l == b.size
+	isFull := l >= b.size
 	isTimeout := l > 0 && time.Now().Sub(b.startTime) > b.timeout
 	return isFull || isTimeout
 }
@@ -55,8 +93,17 @@ type Batcher struct {
 	flushTimeout        time.Duration
 	maintenanceInterval time.Duration
 
-	shouldStop bool
-	batch      *Batch
+	// shouldStop is checked under mu, so full batches aren't sent after the channel is closed
+	shouldStop atomic.Bool
+	// workersWg is used to close the channels only after workers are done with the batches
+	workersWg *sync.WaitGroup
+	// batches are the batches being filled by the partition key, the key is empty if batches aren't partitioned
+	batches map[string]*Batch
+	// partitionField is set if the output needs batches with the same value of the field
+	partitionField []string
+	// queue keeps the order of events if batches are partitioned,
+	// since events of a source are spread between batches but inputs need commits in order
+	queue *commitQueue
 
 	// cycle of batches: freeBatches => fullBatches, fullBatches => freeBatches
 	freeBatches chan *Batch
@@ -67,6 +114,18 @@ type Batcher struct {
 
 	outSeq    int64
 	commitSeq int64
+
+	// tuner is set if the adaptive batching is enabled in the pipeline settings
+	tuner *batchTuner
+	// shedder is set if the controller can shed expired events
+	shedder     eventShedder
+	maxEventAge time.Duration
+	// atLeastOnce is set if events are committed only after the output confirms their delivery
+	atLeastOnce   bool
+	retryInterval time.Duration
+	// isUndelivered is set if the batch is given up on the stop, later batches aren't committed then,
+	// otherwise the input would commit offsets past undelivered events
+	isUndelivered bool
 }
 
 type (
@@ -95,33 +154,101 @@ func NewBatcher(
 		batchSize:           batchSize,
 		flushTimeout:        flushTimeout,
 		maintenanceInterval: maintenanceInterval,
+		retryInterval:       deliveryRetryInterval,
+	}
+}
+
+// batchPartitioner is implemented by the pipeline, so outputs get homogeneous batches without changes.
+type batchPartitioner interface {
+	batchPartitionField() []string
+}
+
+func parsePartitionField(info *OutputPluginInfo) []string {
+	if info == nil || info.BatchPartitionField == "" {
+		return nil
 	}
+
+	return cfg.ParseFieldSelector(info.BatchPartitionField)
+}
+
+// deliveryProvider is implemented by the pipeline, so outputs don't have to pass the delivery mode to the batcher.
+type deliveryProvider interface {
+	delivery() string
+}
+
+// adaptiveBatchingProvider is implemented by the pipeline, so outputs don't have to pass the settings to the batcher.
+type adaptiveBatchingProvider interface {
+	adaptiveBatching() *AdaptiveBatching
+}
+
+// lowLatencyProvider is implemented by the pipeline, so the low latency mode may be enabled per output without changes of outputs.
+type lowLatencyProvider interface {
+	lowLatency() bool
 }
 
 func (b *Batcher) Start() {
+	// each event makes the full batch, so it's sent right away and the flush timeout doesn't matter
+	isLowLatency := false
+	if provider, ok := b.controller.(lowLatencyProvider); ok && provider.lowLatency() {
+		isLowLatency = true
+		b.batchSize = 1
+	}
+
+	if provider, ok := b.controller.(adaptiveBatchingProvider); ok && provider.adaptiveBatching() != nil && !isLowLatency {
+		b.tuner = newBatchTuner(*provider.adaptiveBatching(), b.batchSize, b.flushTimeout, b.pipelineName, b.outputType)
+	}
+
+	if shedder, ok := b.controller.(eventShedder); ok && shedder.maxEventAge() > 0 {
+		b.shedder = shedder
+		b.maxEventAge = shedder.maxEventAge()
+	}
+
+	if provider, ok := b.controller.(deliveryProvider); ok {
+		b.atLeastOnce = provider.delivery() == DeliveryAtLeastOnce
+	}
+
+	if partitioner, ok := b.controller.(batchPartitioner); ok {
+		b.partitionField = partitioner.batchPartitionField()
+	}
+	if b.partitionField != nil {
+		b.queue = newCommitQueue()
+	}
+
+	b.batches = make(map[string]*Batch)
 	b.mu = &sync.Mutex{}
 	b.seqMu = &sync.Mutex{}
 	b.cond = sync.NewCond(b.seqMu)
+	b.workersWg = &sync.WaitGroup{}
 
 	b.freeBatches = make(chan *Batch, b.workerCount)
 	b.fullBatches = make(chan *Batch, b.workerCount)
 	for i := 0; i < b.workerCount; i++ {
 		b.freeBatches <- newBatch(b.batchSize, b.flushTimeout)
-		longpanic.Go(b.work)
+		b.workersWg.Add(1)
+		longpanic.GoScoped(b.panicScope(), b.work)
 	}
 
-	longpanic.Go(b.heartbeat)
+	longpanic.GoScoped(b.panicScope(), b.heartbeat)
+}
+
+func (b *Batcher) panicScope() longpanic.Scope {
+	return longpanic.Scope{Pipeline: b.pipelineName, Plugin: b.outputType}
 }
 
 type WorkerData interface{}
 
 func (b *Batcher) work() {
+	defer b.workersWg.Done()
+
 	t := time.Now()
 	events := make([]*Event, 0, 0)
 	data := WorkerData(nil)
 	for batch := range b.fullBatches {
-		b.outFn(&data, batch)
-		events = b.commitBatch(events, batch)
+		isDelivered := b.deliver(&data, batch)
+		if batch.isExpired && b.shedder != nil {
+			b.shedder.shed(b.outputType, batch.Events)
+		}
+		events = b.commitBatch(events, batch, isDelivered)
 
 		shouldRunMaintenance := b.maintenanceFn != nil && b.maintenanceInterval != 0 && time.Now().Sub(t) > b.maintenanceInterval
 		if shouldRunMaintenance {
@@ -131,7 +258,33 @@ func (b *Batcher) work() {
 	}
 }
 
-func (b *Batcher) commitBatch(events []*Event, batch *Batch) []*Event {
+// deliver passes the batch to the output, in the at least once delivery mode it retries the failed batch
+// until it's delivered, expired or the batcher is stopped.
+func (b *Batcher) deliver(data *WorkerData, batch *Batch) (isDelivered bool) {
+	for {
+		start := time.Now()
+		batch.err = nil
+		b.outFn(data, batch)
+		if b.tuner != nil {
+			b.tuner.observe(time.Since(start))
+		}
+
+		if batch.err == nil || !b.atLeastOnce || batch.IsExpired() {
+			return true
+		}
+
+		if b.shouldStop.Load() {
+			logger.Errorf("batch of %d events isn't delivered by output %q of pipeline %q on stop, they aren't committed: %s",
+				len(batch.Events), b.outputType, b.pipelineName, batch.err.Error())
+			return false
+		}
+
+		logger.Errorf("batch isn't delivered by output %q of pipeline %q, retrying: %s", b.outputType, b.pipelineName, batch.err.Error())
+		time.Sleep(b.retryInterval)
+	}
+}
+
+func (b *Batcher) commitBatch(events []*Event, batch *Batch, isDelivered bool) []*Event {
 	// we need to release batch first and then commit events
 	// so lets exchange local slice with batch slice to avoid data copying
 	tmp := events
@@ -147,27 +300,52 @@ func (b *Batcher) commitBatch(events []*Event, batch *Batch) []*Event {
 	}
 	b.commitSeq++
 
-	for _, e := range events {
-		b.controller.Commit(e)
+	if !isDelivered {
+		b.isUndelivered = true
+	}
+
+	switch {
+	case b.isUndelivered:
+	case b.queue != nil:
+		b.queue.done(b.controller.Commit, batch.positions...)
+	default:
+		for _, e := range events {
+			b.controller.Commit(e)
+		}
 	}
 
 	b.cond.Broadcast()
 	b.seqMu.Unlock()
 
+	// it doesn't block since the channel can hold all batches,
+	// the channel isn't closed on the stop, so Add waiting for a batch isn't stuck
 	b.freeBatches <- batch
 
 	return events
 }
 
 func (b *Batcher) heartbeat() {
+	ready := make([]*Batch, 0)
 	for {
-		if b.shouldStop {
+		b.mu.Lock()
+		if b.shouldStop.Load() {
+			b.mu.Unlock()
 			return
 		}
 
-		b.mu.Lock()
-		batch := b.getBatch()
-		b.trySendBatchAndUnlock(batch)
+		ready = ready[:0]
+		for _, batch := range b.batches {
+			if batch.isReady() {
+				b.detach(batch)
+				ready = append(ready, batch)
+			}
+		}
+
+		// it doesn't block since the channel can hold all batches
+		for _, batch := range ready {
+			b.fullBatches <- batch
+		}
+		b.mu.Unlock()
 
 		time.Sleep(time.Millisecond * 100)
 	}
@@ -175,38 +353,140 @@ func (b *Batcher) heartbeat() {
 
 func (b *Batcher) Add(event *Event) {
 	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	batch := b.getBatch()
-	batch.append(event)
+	if b.shouldStop.Load() {
+		logger.Errorf("event is added to the stopped output %q of pipeline %q, it isn't committed", b.outputType, b.pipelineName)
+		return
+	}
 
-	b.trySendBatchAndUnlock(batch)
-}
+	batch := b.getBatch(b.partitionKey(event))
+	batch.append(event)
+	if b.queue != nil {
+		batch.positions = append(batch.positions, b.queue.push(event))
+	}
 
-// trySendBatch mu should be locked and it'll be unlocked after execution of this function
-func (b *Batcher) trySendBatchAndUnlock(batch *Batch) {
 	if !batch.isReady() {
-		b.mu.Unlock()
 		return
 	}
 
+	b.detach(batch)
+	// it doesn't block since the channel can hold all batches
+	b.fullBatches <- batch
+}
+
+func (b *Batcher) partitionKey(event *Event) string {
+	if b.partitionField == nil {
+		return ""
+	}
+
+	return event.Root.Dig(b.partitionField...).AsString()
+}
+
+// detach sets the sequence of the batch to send it, mu should be locked.
+func (b *Batcher) detach(batch *Batch) {
 	batch.seq = b.outSeq
 	b.outSeq++
-	b.batch = nil
-	b.mu.Unlock()
+	delete(b.batches, batch.key)
+}
 
-	b.fullBatches <- batch
+// getBatch returns the batch of the key, mu should be locked.
+// If all batches are taken by other keys, the oldest one is sent to free a batch,
+// otherwise it waits for a batch being sent. It doesn't block on sending
+// since the channel of full batches can hold all batches.
+func (b *Batcher) getBatch(key string) *Batch {
+	if batch, has := b.batches[key]; has {
+		return batch
+	}
+
+	if len(b.batches) == b.workerCount {
+		oldest := b.oldestBatch()
+		b.detach(oldest)
+		b.fullBatches <- oldest
+	}
+	batch := <-b.freeBatches
+
+	batch.reset()
+	// the key may point to the event data
+	batch.key = string([]byte(key))
+	batch.maxEventAge = b.maxEventAge
+	if b.tuner != nil {
+		batch.size, batch.timeout = b.tuner.get()
+	}
+	b.batches[batch.key] = batch
+
+	return batch
 }
 
-func (b *Batcher) getBatch() *Batch {
-	if b.batch == nil {
-		b.batch = <-b.freeBatches
-		b.batch.reset()
+func (b *Batcher) oldestBatch() *Batch {
+	var oldest *Batch
+	for _, batch := range b.batches {
+		if oldest == nil || batch.startTime.Before(oldest.startTime) {
+			oldest = batch
+		}
 	}
-	return b.batch
+
+	return oldest
 }
 
+// Stop waits for workers to finish the batches being sent,
+// failed batches aren't retried then.
 func (b *Batcher) Stop() {
-	b.shouldStop = true
-	close(b.freeBatches)
+	b.shouldStop.Store(true)
+
+	b.mu.Lock()
 	close(b.fullBatches)
+	b.mu.Unlock()
+
+	b.workersWg.Wait()
+}
+
+// commitQueue commits events in the order they're pushed regardless of the order they're sent in,
+// e.g. by partitioned batches or by routed outputs.
+type commitQueue struct {
+	mu *sync.Mutex
+	// head is the position of the first entry
+	head    int64
+	entries []commitEntry
+}
+
+type commitEntry struct {
+	event  *Event
+	isDone bool
+}
+
+func newCommitQueue() *commitQueue {
+	return &commitQueue{mu: &sync.Mutex{}}
+}
+
+func (q *commitQueue) push(event *Event) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.entries = append(q.entries, commitEntry{event: event})
+	return q.head + int64(len(q.entries)) - 1
+}
+
+func (q *commitQueue) isEmpty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries) == 0
+}
+
+// done marks events as sent and commits all sent events which aren't preceded by unsent ones.
+func (q *commitQueue) done(commit func(event *Event), positions ...int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, pos := range positions {
+		q.entries[pos-q.head].isDone = true
+	}
+
+	for len(q.entries) > 0 && q.entries[0].isDone {
+		commit(q.entries[0].event)
+		q.entries[0] = commitEntry{}
+		q.entries = q.entries[1:]
+		q.head++
+	}
 }
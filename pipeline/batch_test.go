@@ -1,12 +1,15 @@
 package pipeline
 
 import (
+	"errors"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ozonru/file.d/logger"
 	"github.com/stretchr/testify/assert"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 )
 
@@ -22,6 +25,7 @@ func (b *batcherTail) Error(err string) {
 	logger.Panic(err)
 }
 
+func (b *batcherTail) Backpressure(bool)  {}
 func (b *batcherTail) WaitOrPanic(string) {}
 func (b *batcherTail) RecoverFromPanic()  {}
 
@@ -84,3 +88,225 @@ func TestBatcher(t *testing.T) {
 	assert.Equal(t, int32(eventCount), commitsCount.Load(), "wrong commits count")
 	assert.Equal(t, int32(eventCount/batchSize), batchCount.Load(), "wrong batches count")
 }
+
+type partitionedTail struct {
+	batcherTail
+}
+
+func (p *partitionedTail) batchPartitionField() []string {
+	return []string{"tenant"}
+}
+
+func TestBatcherPartition(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		// there are more tenants than batches, so the oldest batch is sent to free a batch
+		{name: "eviction", workers: 2},
+		{name: "batch_per_tenant", workers: 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testBatcherPartition(t, tt.workers)
+		})
+	}
+}
+
+func testBatcherPartition(t *testing.T, workers int) {
+	eventCount := 10000
+	tenants := 7
+	batchSize := 100
+
+	wg := sync.WaitGroup{}
+	wg.Add(eventCount)
+
+	mu := &sync.Mutex{}
+	wrongBatches := 0
+	batches := 0
+	batcherOut := func(_ *WorkerData, batch *Batch) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches++
+		for _, event := range batch.Events {
+			if event.Root.Dig("tenant").AsString() != batch.Key() {
+				wrongBatches++
+				return
+			}
+		}
+	}
+
+	lastSeq := int64(-1)
+	tail := &partitionedTail{batcherTail: batcherTail{commit: func(event *Event) {
+		if int64(event.SeqID) <= lastSeq {
+			logger.Panicf("wrong commit sequence: seq=%d, prev seq=%d", event.SeqID, lastSeq)
+		}
+		lastSeq = int64(event.SeqID)
+		wg.Done()
+	}}}
+
+	batcher := NewBatcher("test", "devnull", batcherOut, nil, tail, workers, batchSize, time.Second, 0)
+	batcher.Start()
+
+	// events are added from one goroutine, so commits should keep the order of the whole stream
+	for i := 0; i < eventCount; i++ {
+		root, err := insaneJSON.DecodeString(`{"tenant":"tenant-` + strconv.Itoa(i%tenants) + `"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batcher.Add(&Event{SeqID: uint64(i), Root: root})
+	}
+
+	wg.Wait()
+	batcher.Stop()
+
+	assert.Equal(t, 0, wrongBatches, "batches aren't homogeneous")
+	if workers > tenants {
+		assert.True(t, batches <= eventCount/batchSize+tenants, "batches aren't full: %d", batches)
+	}
+}
+
+type deliveryTail struct {
+	batcherTail
+}
+
+func (d *deliveryTail) delivery() string {
+	return DeliveryAtLeastOnce
+}
+
+func TestBatcherAtLeastOnce(t *testing.T) {
+	batchSize := 10
+
+	attempts := atomic.Int32{}
+	batcherOut := func(_ *WorkerData, batch *Batch) {
+		// the first batch is failed twice
+		if batch.Events[0].SeqID == 0 && attempts.Inc() <= 2 {
+			batch.Fail(errors.New("sink is down"))
+		}
+	}
+
+	committed := make(chan uint64, batchSize*2)
+	tail := &deliveryTail{batcherTail: batcherTail{commit: func(event *Event) {
+		committed <- event.SeqID
+	}}}
+
+	batcher := NewBatcher("test", "devnull", batcherOut, nil, tail, 1, batchSize, time.Hour, 0)
+	batcher.retryInterval = time.Millisecond
+	batcher.Start()
+
+	for i := 0; i < batchSize*2; i++ {
+		batcher.Add(&Event{SeqID: uint64(i)})
+	}
+
+	for i := 0; i < batchSize*2; i++ {
+		select {
+		case seq := <-committed:
+			assert.Equal(t, uint64(i), seq, "wrong commit sequence")
+		case <-time.After(time.Second):
+			t.Fatalf("event %d isn't committed", i)
+		}
+	}
+	assert.Equal(t, int32(3), attempts.Load(), "failed batch isn't retried")
+
+	batcher.Stop()
+}
+
+func TestBatcherUndeliveredOnStop(t *testing.T) {
+	batchSize := 10
+
+	stopped := make(chan struct{})
+	batcherOut := func(_ *WorkerData, batch *Batch) {
+		if batch.Events[0].SeqID == 0 {
+			<-stopped
+			batch.Fail(errors.New("sink is down"))
+		}
+	}
+
+	commits := atomic.Int32{}
+	tail := &deliveryTail{batcherTail: batcherTail{commit: func(event *Event) {
+		commits.Inc()
+	}}}
+
+	batcher := NewBatcher("test", "devnull", batcherOut, nil, tail, 2, batchSize, time.Hour, 0)
+	batcher.retryInterval = time.Millisecond
+	batcher.Start()
+
+	for i := 0; i < batchSize*2; i++ {
+		batcher.Add(&Event{SeqID: uint64(i)})
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stopped)
+	}()
+	batcher.Stop()
+
+	// the second batch is delivered, but it can't be committed after the undelivered one
+	assert.Equal(t, int32(0), commits.Load(), "events are committed after the undelivered batch")
+}
+
+func TestBatcherStopWaitsWorkers(t *testing.T) {
+	batchSize := 10
+
+	attempts := atomic.Int32{}
+	batcherOut := func(_ *WorkerData, batch *Batch) {
+		attempts.Inc()
+		time.Sleep(10 * time.Millisecond)
+		batch.Fail(errors.New("sink is down"))
+	}
+
+	tail := &deliveryTail{batcherTail: batcherTail{commit: func(event *Event) {}}}
+
+	batcher := NewBatcher("test", "devnull", batcherOut, nil, tail, 4, batchSize, time.Hour, 0)
+	batcher.retryInterval = time.Millisecond
+	batcher.Start()
+
+	for i := 0; i < batchSize*4; i++ {
+		batcher.Add(&Event{SeqID: uint64(i)})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// workers are in the retry loop, they shouldn't return batches to the closed channel
+	batcher.Stop()
+	afterStop := attempts.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, afterStop, attempts.Load(), "batches are retried after the stop")
+}
+
+type lowLatencyTail struct {
+	batcherTail
+}
+
+func (l *lowLatencyTail) lowLatency() bool {
+	return true
+}
+
+func TestBatcherLowLatency(t *testing.T) {
+	sizes := make(chan int, 10)
+	batcherOut := func(_ *WorkerData, batch *Batch) {
+		sizes <- len(batch.Events)
+	}
+
+	committed := atomic.Int32{}
+	tail := &lowLatencyTail{batcherTail: batcherTail{commit: func(event *Event) {
+		committed.Inc()
+	}}}
+
+	// the flush timeout is never reached, so events are sent only if they aren't batched
+	batcher := NewBatcher("test", "devnull", batcherOut, nil, tail, 1, 100, time.Hour, 0)
+	batcher.Start()
+
+	for i := 0; i < 3; i++ {
+		batcher.Add(&Event{SeqID: uint64(i)})
+		select {
+		case size := <-sizes:
+			assert.Equal(t, 1, size, "event is batched")
+		case <-time.After(time.Second):
+			t.Fatalf("event %d isn't sent", i)
+		}
+	}
+
+	assert.Eventually(t, func() bool { return committed.Load() == 3 }, time.Second, time.Millisecond, "events aren't committed")
+	batcher.Stop()
+}
@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+)
+
+// AdaptiveBatching makes the batcher tune the batch size and the flush timeout by the output latency.
+type AdaptiveBatching struct {
+	// TargetLatency is the max time of sending a batch by the output which is considered healthy.
+	TargetLatency time.Duration
+	// MinBatchSize is the lower bound of the batch size, the upper bound is the batch size of the output.
+	MinBatchSize int
+	// MaxFlushTimeout is the upper bound of the flush timeout, the lower bound is the flush timeout of the output.
+	MaxFlushTimeout time.Duration
+}
+
+// batchTuner adjusts the batch size and the flush timeout AIMD style.
+// When the output is slower than the target latency the size is halved and the timeout is doubled,
+// so the slow consumer gets fewer and smaller requests. Otherwise the size grows and the timeout shrinks step by step.
+// Retries of failed requests are counted into the latency, so errors slow the batching down as well.
+type batchTuner struct {
+	settings AdaptiveBatching
+
+	maxSize     int
+	sizeStep    int
+	minTimeout  time.Duration
+	timeoutStep time.Duration
+
+	pipelineName string
+	outputType   string
+
+	mu      *sync.Mutex
+	size    int
+	timeout time.Duration
+	isSlow  bool
+}
+
+func newBatchTuner(settings AdaptiveBatching, maxSize int, minTimeout time.Duration, pipelineName string, outputType string) *batchTuner {
+	if settings.MinBatchSize <= 0 {
+		settings.MinBatchSize = 1
+	}
+	if settings.MinBatchSize > maxSize {
+		settings.MinBatchSize = maxSize
+	}
+	if settings.MaxFlushTimeout < minTimeout {
+		settings.MaxFlushTimeout = minTimeout * 10
+	}
+
+	sizeStep := maxSize / 16
+	if sizeStep == 0 {
+		sizeStep = 1
+	}
+
+	return &batchTuner{
+		settings:     settings,
+		maxSize:      maxSize,
+		sizeStep:     sizeStep,
+		minTimeout:   minTimeout,
+		timeoutStep:  minTimeout,
+		pipelineName: pipelineName,
+		outputType:   outputType,
+		mu:           &sync.Mutex{},
+		size:         maxSize,
+		timeout:      minTimeout,
+	}
+}
+
+func (t *batchTuner) get() (int, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.size, t.timeout
+}
+
+func (t *batchTuner) observe(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if latency > t.settings.TargetLatency {
+		t.size /= 2
+		if t.size < t.settings.MinBatchSize {
+			t.size = t.settings.MinBatchSize
+		}
+		t.timeout *= 2
+		if t.timeout > t.settings.MaxFlushTimeout {
+			t.timeout = t.settings.MaxFlushTimeout
+		}
+
+		if !t.isSlow {
+			t.isSlow = true
+			logger.Warnf("slow consumer is detected in pipeline %q, output %q latency=%s, batch size is decreased to %d, flush timeout is increased to %s",
+				t.pipelineName, t.outputType, latency, t.size, t.timeout)
+		}
+		return
+	}
+
+	t.size += t.sizeStep
+	if t.size > t.maxSize {
+		t.size = t.maxSize
+	}
+	t.timeout -= t.timeoutStep
+	if t.timeout < t.minTimeout {
+		t.timeout = t.minTimeout
+	}
+
+	if t.isSlow && t.size == t.maxSize && t.timeout == t.minTimeout {
+		t.isSlow = false
+		logger.Infof("consumer is recovered in pipeline %q, output %q, batch size and flush timeout are restored", t.pipelineName, t.outputType)
+	}
+}
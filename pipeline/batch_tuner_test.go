@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchTuner(t *testing.T) {
+	tuner := newBatchTuner(AdaptiveBatching{TargetLatency: time.Second, MinBatchSize: 10}, 64, 100*time.Millisecond, "test", "devnull")
+
+	size, timeout := tuner.get()
+	assert.Equal(t, 64, size, "wrong initial size")
+	assert.Equal(t, 100*time.Millisecond, timeout, "wrong initial timeout")
+
+	// multiplicative decrease within the bounds
+	for i := 0; i < 5; i++ {
+		tuner.observe(2 * time.Second)
+	}
+	size, timeout = tuner.get()
+	assert.Equal(t, 10, size, "size should be decreased to the min")
+	assert.Equal(t, time.Second, timeout, "timeout should be increased to the max")
+	assert.True(t, tuner.isSlow, "consumer should be slow")
+
+	// additive increase
+	tuner.observe(100 * time.Millisecond)
+	size, timeout = tuner.get()
+	assert.Equal(t, 14, size, "size should be increased by the step")
+	assert.Equal(t, 900*time.Millisecond, timeout, "timeout should be decreased by the step")
+
+	for i := 0; i < 20; i++ {
+		tuner.observe(100 * time.Millisecond)
+	}
+	size, timeout = tuner.get()
+	assert.Equal(t, 64, size, "size should be restored")
+	assert.Equal(t, 100*time.Millisecond, timeout, "timeout should be restored")
+	assert.False(t, tuner.isSlow, "consumer should be recovered")
+}
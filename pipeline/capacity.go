@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// SetCapacity changes the number of events the pipeline may hold at runtime, it can't exceed the max capacity.
+// Events in the pipeline, e.g. in batches of the output, are kept:
+// if the capacity is shrunk, inputs wait until enough events are committed and extra events are released.
+func (p *Pipeline) SetCapacity(capacity int) error {
+	if capacity <= 0 || capacity > p.eventPool.size {
+		return fmt.Errorf("capacity should be from 1 to the max capacity %d: %d", p.eventPool.size, capacity)
+	}
+
+	prev := p.eventPool.capacity.Load()
+	p.eventPool.setCapacity(capacity)
+	p.logger.Infof("capacity of pipeline %q is changed from %d to %d", p.Name, prev, capacity)
+
+	return nil
+}
+
+func (p *Pipeline) capacityStatus() map[string]int64 {
+	return map[string]int64{
+		"capacity":     p.eventPool.capacity.Load(),
+		"max_capacity": int64(p.eventPool.size),
+		"allocated":    p.eventPool.allocated.Load(),
+		"in_use":       p.eventPool.inUse(),
+	}
+}
+
+// serveCapacity shows the capacity on GET and changes it by the `capacity` query param on POST.
+func (p *Pipeline) serveCapacity(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		capacity, err := strconv.Atoi(r.URL.Query().Get("capacity"))
+		if err == nil {
+			err = p.SetCapacity(capacity)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeErr(w, fmt.Sprintf("Capacity should be a number from 1 to %d.", p.eventPool.size))
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeErr(w, "Use the GET or POST method.")
+		return
+	}
+
+	resp, _ := json.Marshal(p.capacityStatus())
+	_, _ = w.Write(resp)
+}
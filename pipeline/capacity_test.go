@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventPoolCapacity(t *testing.T) {
+	p := newEventPool(2, 4, DefaultJSONNodePoolSize, nil)
+	assert.Equal(t, int64(2), p.allocated.Load(), "events should be allocated up to the capacity")
+
+	first := p.get()
+	second := p.get()
+
+	got := make(chan *Event)
+	go func() { got <- p.get() }()
+	select {
+	case <-got:
+		require.Fail(t, "event is taken beyond the capacity")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.setCapacity(4)
+	third := <-got
+	fourth := p.get()
+	assert.Equal(t, int64(4), p.allocated.Load(), "events should be allocated once the capacity grows")
+	assert.Equal(t, int64(4), p.inUse())
+
+	p.setCapacity(1)
+	for _, event := range []*Event{first, second, third, fourth} {
+		p.back(event)
+	}
+	assert.Equal(t, int64(1), p.allocated.Load(), "extra events should be released")
+	assert.Equal(t, int64(0), p.inUse())
+
+	p.back(p.get())
+}
+
+func TestServeCapacity(t *testing.T) {
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaxCapacity: 16, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+
+	w := httptest.NewRecorder()
+	p.serveCapacity(w, httptest.NewRequest("POST", "/pipelines/test/capacity?capacity=12", nil))
+	assert.Equal(t, 200, w.Code, "wrong status")
+	assert.Equal(t, int64(12), p.eventPool.capacity.Load(), "capacity isn't changed")
+
+	w = httptest.NewRecorder()
+	p.serveCapacity(w, httptest.NewRequest("POST", "/pipelines/test/capacity?capacity=32", nil))
+	assert.Equal(t, 400, w.Code, "capacity beyond the max capacity is accepted")
+
+	w = httptest.NewRecorder()
+	p.serveCapacity(w, httptest.NewRequest("GET", "/pipelines/test/capacity", nil))
+	assert.Equal(t, 200, w.Code, "wrong status")
+	assert.JSONEq(t, `{"capacity":12,"max_capacity":16,"allocated":8,"in_use":0}`, w.Body.String(), "wrong status")
+}
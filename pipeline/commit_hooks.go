@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	commitHooksBatchSize     = 256
+	commitHooksFlushInterval = 100 * time.Millisecond
+)
+
+// CommitRecord describes the event which is committed to the input.
+type CommitRecord struct {
+	SourceID   SourceID
+	SourceName string
+	Offset     int64
+	Stream     StreamName
+	Size       int
+}
+
+// CommitObserver gets records of committed events in batches, e.g. to export lag or to bill sources.
+// Observers are called from one goroutine, records are valid only until the observer returns.
+type CommitObserver func(records []CommitRecord)
+
+// commitHooks collects records of committed events and passes them to observers in batches,
+// so the commit path only appends to the buffer.
+// The batch is flushed when it's full or every `commitHooksFlushInterval`.
+type commitHooks struct {
+	observers []CommitObserver
+
+	mu      *sync.Mutex
+	records []CommitRecord
+	// spare is the buffer of the previous batch to avoid allocations
+	spare []CommitRecord
+	full  chan struct{}
+
+	// flushMu serializes calls of observers
+	flushMu *sync.Mutex
+}
+
+func newCommitHooks() *commitHooks {
+	return &commitHooks{
+		mu:      &sync.Mutex{},
+		records: make([]CommitRecord, 0, commitHooksBatchSize),
+		spare:   make([]CommitRecord, 0, commitHooksBatchSize),
+		full:    make(chan struct{}, 1),
+		flushMu: &sync.Mutex{},
+	}
+}
+
+func (h *commitHooks) isEnabled() bool {
+	return len(h.observers) != 0
+}
+
+func (h *commitHooks) add(event *Event) {
+	h.mu.Lock()
+	h.records = append(h.records, CommitRecord{
+		SourceID:   event.SourceID,
+		SourceName: event.SourceName,
+		Offset:     event.Offset,
+		// the name of the stream is copied by the streamer unlike the stream name of the event
+		Stream: event.stream.name,
+		Size:   event.Size,
+	})
+	isFull := len(h.records) >= commitHooksBatchSize
+	h.mu.Unlock()
+
+	if !isFull {
+		return
+	}
+
+	select {
+	case h.full <- struct{}{}:
+	default:
+	}
+}
+
+func (h *commitHooks) flush() {
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+
+	h.mu.Lock()
+	records := h.records
+	h.records = h.spare[:0]
+	h.mu.Unlock()
+
+	if len(records) != 0 {
+		for _, observer := range h.observers {
+			observer(records)
+		}
+	}
+
+	h.spare = records
+}
+
+// run flushes batches until the context is done.
+func (h *commitHooks) run(ctx context.Context) {
+	ticker := time.NewTicker(commitHooksFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.flush()
+			return
+		case <-ticker.C:
+			h.flush()
+		case <-h.full:
+			h.flush()
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitHooks(t *testing.T) {
+	h := newCommitHooks()
+
+	got := make([]CommitRecord, 0)
+	h.observers = append(h.observers, func(records []CommitRecord) {
+		got = append(got, records...)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.run(ctx)
+		close(done)
+	}()
+
+	stream := &stream{name: "stdout"}
+	count := commitHooksBatchSize*2 + 10
+	for i := 0; i < count; i++ {
+		h.add(&Event{SourceID: 1, SourceName: "test.log", Offset: int64(i), Size: 10, stream: stream})
+	}
+
+	cancel()
+	<-done
+
+	assert.Equal(t, count, len(got), "all records should be flushed on stop")
+	for i, record := range got {
+		assert.Equal(t, CommitRecord{SourceID: 1, SourceName: "test.log", Offset: int64(i), Stream: "stdout", Size: 10}, record)
+	}
+}
+
+func TestCommitHooksFlushInterval(t *testing.T) {
+	h := newCommitHooks()
+
+	flushed := make(chan int, 1)
+	h.observers = append(h.observers, func(records []CommitRecord) {
+		flushed <- len(records)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.run(ctx)
+
+	h.add(&Event{stream: &stream{}})
+
+	select {
+	case count := <-flushed:
+		assert.Equal(t, 1, count)
+	case <-time.After(time.Second):
+		t.Fatal("partial batch isn't flushed")
+	}
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"math"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	lagFromReceive   = "receive"
+	lagFromEventTime = "event_time"
+)
+
+// commitLag measures the end-to-end lag of events: the time from the input receive time
+// and from the event timestamp to the commit by the output.
+type commitLag struct {
+	timeField []string
+
+	lag           *prometheus.HistogramVec
+	fromReceive   prometheus.Observer
+	fromEventTime prometheus.Observer
+}
+
+func newCommitLag(pipelineName string, timeField string, registry prometheus.Registerer) *commitLag {
+	l := &commitLag{
+		lag: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "commit_lag_seconds",
+			Help:      "the time from the input receive time or the event timestamp to the commit by the output",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 12),
+		}, []string{"output", "from"}),
+	}
+
+	if timeField != "" {
+		l.timeField = cfg.ParseFieldSelector(timeField)
+	}
+
+	registry.MustRegister(l.lag)
+
+	return l
+}
+
+// start binds the histograms to the output type, it should be called before events are committed.
+func (l *commitLag) start(outputType string) {
+	l.fromReceive = l.lag.WithLabelValues(outputType, lagFromReceive)
+	if l.timeField != nil {
+		l.fromEventTime = l.lag.WithLabelValues(outputType, lagFromEventTime)
+	}
+}
+
+func (l *commitLag) observe(event *Event, now time.Time) {
+	if event.synthetic || event.receivedAt.IsZero() {
+		return
+	}
+
+	l.fromReceive.Observe(now.Sub(event.receivedAt).Seconds())
+
+	if l.timeField == nil {
+		return
+	}
+
+	ts, ok := EventTime(event, l.timeField)
+	if !ok {
+		return
+	}
+
+	// timestamps from the future are counted as zero lag
+	lag := now.Sub(ts).Seconds()
+	if lag < 0 {
+		lag = 0
+	}
+	l.fromEventTime.Observe(lag)
+}
+
+// EventTime gets the timestamp from the field which is either a RFC3339 string or a number of seconds since the epoch.
+func EventTime(event *Event, field []string) (time.Time, bool) {
+	node := event.Root.Dig(field...)
+	if node == nil {
+		return time.Time{}, false
+	}
+
+	if node.IsNumber() {
+		sec, frac := math.Modf(node.AsFloat())
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+	}
+
+	if node.IsString() {
+		ts, err := time.Parse(time.RFC3339Nano, node.AsString())
+		return ts, err == nil
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestCommitLag(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	l := newCommitLag("test", "meta.time", registry)
+	l.start("devnull")
+
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, data := range []string{
+		`{"meta":{"time":"2021-05-01T09:59:58Z"}}`,
+		`{"meta":{"time":1619863197}}`,
+		`{"meta":{"time":"not a time"}}`,
+	} {
+		root, err := insaneJSON.DecodeString(data)
+		require.NoError(t, err)
+		l.observe(&Event{Root: root, receivedAt: now.Add(-time.Second)}, now)
+		insaneJSON.Release(root)
+	}
+	l.observe(&Event{synthetic: true, receivedAt: now.Add(-time.Second)}, now)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(families), "wrong metrics count")
+
+	sums := make(map[string]float64)
+	counts := make(map[string]uint64)
+	for _, metric := range families[0].GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "from" {
+				sums[label.GetValue()] = metric.GetHistogram().GetSampleSum()
+				counts[label.GetValue()] = metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	assert.Equal(t, uint64(3), counts[lagFromReceive], "wrong receive lag count")
+	assert.Equal(t, float64(3), sums[lagFromReceive], "wrong receive lag sum")
+	assert.Equal(t, uint64(2), counts[lagFromEventTime], "wrong event time lag count")
+	assert.Equal(t, float64(5), sums[lagFromEventTime], "wrong event time lag sum")
+}
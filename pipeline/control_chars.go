@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// ControlCharsMode defines what to do with control chars in string values of events before the output encodes them.
+type ControlCharsMode int
+
+const (
+	ControlCharsKeep ControlCharsMode = iota
+	ControlCharsStrip
+	ControlCharsEscape
+)
+
+const hexDigits = "0123456789abcdef"
+
+func ParseControlCharsMode(mode string) (ControlCharsMode, error) {
+	switch mode {
+	case "", "keep":
+		return ControlCharsKeep, nil
+	case "strip":
+		return ControlCharsStrip, nil
+	case "escape":
+		return ControlCharsEscape, nil
+	default:
+		return ControlCharsKeep, fmt.Errorf(`unknown control chars mode %q, should be one of "keep", "strip", "escape"`, mode)
+	}
+}
+
+// controlChars strips or escapes ASCII and C1 control chars except tabs and line breaks,
+// invalid and overlong UTF-8 sequences are replaced with U+FFFD.
+// It isn't thread safe, every processor has its own one.
+type controlChars struct {
+	mode ControlCharsMode
+	buf  []byte
+}
+
+func newControlChars(mode ControlCharsMode) *controlChars {
+	return &controlChars{mode: mode}
+}
+
+func (c *controlChars) isEnabled() bool {
+	return c.mode != ControlCharsKeep
+}
+
+func (c *controlChars) sanitize(event *Event) {
+	c.sanitizeNode(event.Root, event.Root.Node)
+}
+
+func (c *controlChars) sanitizeNode(root *insaneJSON.Root, node *insaneJSON.Node) {
+	switch {
+	case node == nil:
+		return
+	case node.IsString():
+		value := node.AsString()
+		if isClean(value) {
+			return
+		}
+		c.buf = c.appendSanitized(c.buf[:0], value)
+		node.MutateToBytesCopy(root, c.buf)
+	case node.IsObject():
+		for _, field := range node.AsFields() {
+			c.sanitizeNode(root, field.AsFieldValue())
+		}
+	case node.IsArray():
+		for _, elem := range node.AsArray() {
+			c.sanitizeNode(root, elem)
+		}
+	}
+}
+
+func (c *controlChars) appendSanitized(out []byte, value string) []byte {
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			out = append(out, "�"...)
+		case isControl(r):
+			if c.mode == ControlCharsEscape {
+				// C1 chars are escaped by their code point
+				out = append(out, '\\', 'x', hexDigits[r>>4], hexDigits[r&0xf])
+			}
+		default:
+			out = append(out, value[i:i+size]...)
+		}
+		i += size
+	}
+
+	return out
+}
+
+func isControl(r rune) bool {
+	if r == '\t' || r == '\n' || r == '\r' {
+		return false
+	}
+
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
+
+// isClean checks the value in the fast way, most values are ASCII without control chars.
+func isClean(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= utf8.RuneSelf {
+			return isCleanUTF8(value[i:])
+		}
+		if isControl(rune(c)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isCleanUTF8(value string) bool {
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if (r == utf8.RuneError && size == 1) || isControl(r) {
+			return false
+		}
+		i += size
+	}
+
+	return true
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestControlChars(t *testing.T) {
+	data := "{\"message\":\"\\u001b[31mred\\u0000\\tok\\n\",\"nested\":[\"slash \xc0\xaf\",\"\u0085next\"],\"clean\":\"привет\"}"
+
+	tests := []struct {
+		mode    ControlCharsMode
+		message string
+		nested  []string
+	}{
+		{
+			mode:    ControlCharsStrip,
+			message: "[31mred\tok\n",
+			nested:  []string{"slash ��", "next"},
+		},
+		{
+			mode:    ControlCharsEscape,
+			message: `\x1b[31mred\x00` + "\tok\n",
+			nested:  []string{"slash ��", `\x85next`},
+		},
+	}
+
+	for _, tt := range tests {
+		root, err := insaneJSON.DecodeString(data)
+		require.NoError(t, err)
+
+		newControlChars(tt.mode).sanitize(&Event{Root: root})
+
+		assert.Equal(t, tt.message, root.Dig("message").AsString(), "wrong message")
+		for i, elem := range root.Dig("nested").AsArray() {
+			assert.Equal(t, tt.nested[i], elem.AsString(), "wrong nested value")
+		}
+		assert.Equal(t, "привет", root.Dig("clean").AsString(), "clean value is changed")
+
+		insaneJSON.Release(root)
+	}
+}
+
+func TestParseControlCharsMode(t *testing.T) {
+	mode, err := ParseControlCharsMode("")
+	require.NoError(t, err)
+	assert.Equal(t, ControlCharsKeep, mode)
+
+	mode, err = ParseControlCharsMode("escape")
+	require.NoError(t, err)
+	assert.Equal(t, ControlCharsEscape, mode)
+
+	_, err = ParseControlCharsMode("remove")
+	assert.Error(t, err)
+}
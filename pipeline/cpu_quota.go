@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cpuQuotaWindow is the burst of the quota, i.e. processors may exceed the quota for a short time
+const cpuQuotaWindow = 100 * time.Millisecond
+
+// cpuQuota limits the time processors of the pipeline spend in actions to the fraction of cores,
+// so a parsing heavy pipeline doesn't starve other pipelines of the shared deployment.
+// It's the token bucket which is refilled by `cores` seconds per second, processors sleep when it's empty.
+type cpuQuota struct {
+	cores float64
+	burst time.Duration
+
+	mu     *sync.Mutex
+	tokens time.Duration
+	// updatedAt is the time of the last refill, it's in the future while the deficit is paid off by sleeping processors
+	updatedAt time.Time
+
+	throttled prometheus.Counter
+}
+
+func newCPUQuota(pipelineName string, cores float64, registry prometheus.Registerer) *cpuQuota {
+	burst := time.Duration(float64(cpuQuotaWindow) * cores)
+	q := &cpuQuota{
+		cores:     cores,
+		burst:     burst,
+		mu:        &sync.Mutex{},
+		tokens:    burst,
+		updatedAt: time.Now(),
+		throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "cpu_throttled_seconds_total",
+			Help:      "how long processors sleep since actions exceed cpu_quota of the pipeline",
+		}),
+	}
+
+	registry.MustRegister(q.throttled)
+
+	return q
+}
+
+// spend takes the busy time from the bucket and sleeps if it's exceeded.
+func (q *cpuQuota) spend(busy time.Duration) {
+	sleep := q.take(busy, time.Now())
+	if sleep <= 0 {
+		return
+	}
+
+	q.throttled.Add(sleep.Seconds())
+	time.Sleep(sleep)
+}
+
+// take returns how long the processor should sleep to pay off the deficit.
+func (q *cpuQuota) take(busy time.Duration, now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if now.After(q.updatedAt) {
+		q.tokens += time.Duration(float64(now.Sub(q.updatedAt)) * q.cores)
+		if q.tokens > q.burst {
+			q.tokens = q.burst
+		}
+		q.updatedAt = now
+	}
+
+	q.tokens -= busy
+	if q.tokens >= 0 {
+		return 0
+	}
+
+	// the deficit is paid off by this sleep, so other processors don't pay it again
+	q.updatedAt = q.updatedAt.Add(time.Duration(float64(-q.tokens) / q.cores))
+	q.tokens = 0
+
+	return q.updatedAt.Sub(now)
+}
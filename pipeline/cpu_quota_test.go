@@ -0,0 +1,26 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUQuotaTake(t *testing.T) {
+	q := newCPUQuota("test", 0.5, prometheus.NewRegistry())
+	now := q.updatedAt
+
+	// the burst is half of the window
+	assert.Equal(t, time.Duration(0), q.take(50*time.Millisecond, now), "burst is throttled")
+	assert.Equal(t, 20*time.Millisecond, q.take(10*time.Millisecond, now), "wrong sleep for the deficit")
+
+	// the deficit of another processor is paid off by the first one
+	assert.Equal(t, 35*time.Millisecond, q.take(10*time.Millisecond, now.Add(5*time.Millisecond)), "deficit is paid twice")
+
+	// the bucket is refilled up to the burst
+	now = now.Add(time.Second)
+	assert.Equal(t, time.Duration(0), q.take(50*time.Millisecond, now), "bucket isn't refilled")
+	assert.Equal(t, 2*time.Millisecond, q.take(time.Millisecond, now), "bucket is refilled over the burst")
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DecodeErrorsSkip skips lines which can't be decoded.
+	DecodeErrorsSkip = "skip"
+	// DecodeErrorsDeadLetter writes lines which can't be decoded to the dead letter file.
+	DecodeErrorsDeadLetter = "dead_letter"
+	// DecodeErrorsFatal crashes file.d on the first line which can't be decoded.
+	DecodeErrorsFatal = "fatal"
+)
+
+// deadLetter appends events which can't be delivered to the dead letter file, so they aren't lost:
+// events shed by outputs and lines which can't be decoded.
+type deadLetter struct {
+	logger *zap.SugaredLogger
+
+	mu   *sync.Mutex
+	path string
+	file *os.File
+	buf  []byte
+}
+
+// undecodableLine is the dead letter record of the line which can't be decoded.
+// The line is in the `raw` field if it's valid UTF-8, otherwise it's base64 encoded in the `raw_base64` field.
+type undecodableLine struct {
+	DeadLetter string `json:"dead_letter"`
+	Decoder    string `json:"decoder"`
+	Error      string `json:"error"`
+	SourceID   uint64 `json:"source_id"`
+	SourceName string `json:"source_name"`
+	Offset     int64  `json:"offset"`
+	Raw        string `json:"raw,omitempty"`
+	RawBase64  []byte `json:"raw_base64,omitempty"`
+}
+
+func newDeadLetter(path string, logger *zap.SugaredLogger) *deadLetter {
+	return &deadLetter{
+		logger: logger,
+		mu:     &sync.Mutex{},
+		path:   path,
+	}
+}
+
+func (d *deadLetter) isEnabled() bool {
+	return d.path != ""
+}
+
+func (d *deadLetter) writeEvents(events []*Event) {
+	if d.path == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.buf = d.buf[:0]
+	for _, event := range events {
+		d.buf = event.appendBody(d.buf)
+		d.buf = append(d.buf, '\n')
+	}
+	d.write(len(events))
+}
+
+func (d *deadLetter) writeUndecodable(decoder string, err error, sourceID SourceID, sourceName string, offset int64, line []byte) {
+	if d.path == "" {
+		return
+	}
+
+	record := undecodableLine{
+		DeadLetter: "decode_error",
+		Decoder:    decoder,
+		Error:      err.Error(),
+		SourceID:   uint64(sourceID),
+		SourceName: sourceName,
+		Offset:     offset,
+	}
+	line = trimLineEnd(line)
+	if utf8.Valid(line) {
+		record.Raw = string(line)
+	} else {
+		record.RawBase64 = line
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		d.logger.Errorf("can't encode dead letter record of the line offset=%d, source=%d:%s: %s", offset, sourceID, sourceName, err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.buf = append(d.buf[:0], data...)
+	d.buf = append(d.buf, '\n')
+	d.write(1)
+}
+
+// write appends the buffer to the file, mu should be locked.
+func (d *deadLetter) write(count int) {
+	if d.file == nil {
+		file, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			d.logger.Errorf("can't open dead letter file %s, %d events are dropped: %s", d.path, count, err.Error())
+			return
+		}
+		d.file = file
+	}
+
+	if _, err := d.file.Write(d.buf); err != nil {
+		d.logger.Errorf("can't write to dead letter file %s, %d events are dropped: %s", d.path, count, err.Error())
+	}
+}
+
+func (d *deadLetter) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file != nil {
+		_ = d.file.Close()
+		d.file = nil
+	}
+}
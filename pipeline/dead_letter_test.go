@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterUndecodable(t *testing.T) {
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letter.log")
+	settings := &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, IsStrict: true, DeadLetterFile: deadLetterFile}
+	p := New("test", settings, prometheus.NewRegistry())
+
+	// the pipeline is strict, but it keeps running since lines go to the dead letter file
+	p.In(1, "a.log", 10, []byte(`{"message":`+"\n"), false)
+	p.In(2, "b.log", 20, []byte("\xff\xfe\n"), false)
+	p.deadLetter.stop()
+
+	file, err := os.Open(deadLetterFile)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	records := make([]undecodableLine, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := undecodableLine{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Equal(t, 2, len(records), "wrong dead letter records count")
+
+	assert.Equal(t, "decode_error", records[0].DeadLetter)
+	assert.Equal(t, "json", records[0].Decoder)
+	assert.NotEmpty(t, records[0].Error, "error isn't written")
+	assert.Equal(t, uint64(1), records[0].SourceID)
+	assert.Equal(t, "a.log", records[0].SourceName)
+	assert.Equal(t, int64(10), records[0].Offset)
+	assert.Equal(t, `{"message":`, records[0].Raw, "wrong raw line")
+
+	assert.Equal(t, "b.log", records[1].SourceName)
+	assert.Equal(t, "", records[1].Raw, "invalid UTF-8 line is written as a string")
+	assert.Equal(t, []byte("\xff\xfe"), records[1].RawBase64, "wrong raw line")
+}
+
+func TestDecodeErrors(t *testing.T) {
+	settings := &Settings{Decoder: "cri", Capacity: 8, MaintenanceInterval: time.Hour, DecodeErrors: DecodeErrorsSkip}
+	p := New("test", settings, prometheus.NewRegistry())
+	assert.Equal(t, DecodeErrorsSkip, p.decodeErrors)
+
+	// the cri decoder doesn't crash file.d if lines are skipped
+	p.In(1, "a.log", 10, []byte("wrong cri line\n"), false)
+	assert.Equal(t, int64(1), p.skipStats.sources[1].lines[skipReasonDecodeError].Load(), "line isn't skipped")
+
+	settings = &Settings{Decoder: "postgres", Capacity: 8, MaintenanceInterval: time.Hour, IsStrict: true}
+	assert.Equal(t, DecodeErrorsFatal, New("test", settings, prometheus.NewRegistry()).decodeErrors, "strict pipeline should be fatal by default")
+
+	settings = &Settings{Decoder: "cri", Capacity: 8, MaintenanceInterval: time.Hour}
+	assert.Equal(t, DecodeErrorsSkip, New("test", settings, prometheus.NewRegistry()).decodeErrors, "wrong default policy")
+
+	settings = &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, IsStrict: true, DeadLetterFile: filepath.Join(t.TempDir(), "dead-letter.log")}
+	assert.Equal(t, DecodeErrorsDeadLetter, New("test", settings, prometheus.NewRegistry()).decodeErrors, "dead letter file isn't used by default")
+}
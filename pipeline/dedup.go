@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ozonru/file.d/cfg"
+)
+
+// deduper suppresses duplicates of events which inputs read again, e.g. after the unclean shutdown.
+// Keys of committed events are kept in the LRU window of the bounded size, lines with known keys are skipped.
+// Events are keyed by the source and the offset unless the dedup field is set.
+// Keys are added on the commit rather than on the input, so events which haven't been delivered aren't lost on the re-read.
+type deduper struct {
+	mu    *sync.Mutex
+	size  int
+	field []string
+	file  string
+
+	keys    map[dedupKey]*list.Element
+	order   *list.List // the front is the most recently committed key
+	changed bool
+}
+
+type dedupKey struct {
+	SourceID SourceID `json:"source_id,omitempty"`
+	Offset   int64    `json:"offset,omitempty"`
+	Value    string   `json:"value,omitempty"`
+}
+
+// dedupState is the content of the dedup file, keys are ordered from the oldest to the newest.
+type dedupState struct {
+	Keys []dedupKey `json:"keys"`
+}
+
+func newDeduper(size int, field string, file string) *deduper {
+	return &deduper{
+		mu:    &sync.Mutex{},
+		size:  size,
+		field: cfg.ParseFieldSelector(field),
+		file:  file,
+		keys:  make(map[dedupKey]*list.Element),
+		order: list.New(),
+	}
+}
+
+// load reads the window from the file if it's set and exists. It's called on the start
+// rather than on the creation, since the file is saved by the previous pipeline on its stop.
+func (d *deduper) load() error {
+	if !d.isEnabled() || d.file == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(d.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't read dedup file: %w", err)
+	}
+
+	state := &dedupState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return fmt.Errorf("can't decode dedup file: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, key := range state.Keys {
+		d.add(key)
+	}
+	d.changed = false
+
+	return nil
+}
+
+func (d *deduper) isEnabled() bool {
+	return d.size > 0
+}
+
+// isFieldKeyed returns true if events are keyed by the dedup field, so keys are known only after decoding.
+func (d *deduper) isFieldKeyed() bool {
+	return len(d.field) > 0
+}
+
+// fieldKey returns the value of the dedup field of the event, events without the field aren't deduplicated.
+func (d *deduper) fieldKey(event *Event) (string, bool) {
+	node := event.Root.Dig(d.field...)
+	if node == nil {
+		return "", false
+	}
+
+	// the value is copied since it points to the event buffer
+	return string(node.AsBytes()), true
+}
+
+// isDuplicate checks whether the event with the key has been already committed.
+func (d *deduper) isDuplicate(key dedupKey) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	element, has := d.keys[key]
+	if has {
+		d.order.MoveToFront(element)
+	}
+
+	return has
+}
+
+// commit adds the key of the committed event to the window.
+func (d *deduper) commit(event *Event) {
+	key := dedupKey{SourceID: event.SourceID, Offset: event.Offset}
+	if d.isFieldKeyed() {
+		if !event.hasDedupValue {
+			return
+		}
+		key = dedupKey{Value: event.dedupValue}
+	}
+
+	d.mu.Lock()
+	d.add(key)
+	d.mu.Unlock()
+}
+
+func (d *deduper) add(key dedupKey) {
+	d.changed = true
+	if element, has := d.keys[key]; has {
+		d.order.MoveToFront(element)
+		return
+	}
+
+	d.keys[key] = d.order.PushFront(key)
+	if d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.keys, oldest.Value.(dedupKey))
+	}
+}
+
+// save writes the window to the dedup file if it has changed since the last save.
+func (d *deduper) save() error {
+	if !d.isEnabled() || d.file == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	if !d.changed {
+		d.mu.Unlock()
+		return nil
+	}
+	state := &dedupState{Keys: make([]dedupKey, 0, d.order.Len())}
+	for element := d.order.Back(); element != nil; element = element.Prev() {
+		state.Keys = append(state.Keys, element.Value.(dedupKey))
+	}
+	d.changed = false
+	d.mu.Unlock()
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("can't encode dedup window: %w", err)
+	}
+
+	tmp := d.file + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return fmt.Errorf("can't write dedup file: %w", err)
+	}
+	if err := os.Rename(tmp, d.file); err != nil {
+		return fmt.Errorf("can't replace dedup file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduperWindow(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dedup.json")
+	d := newDeduper(2, "", file)
+	require.NoError(t, d.load())
+
+	d.commit(&Event{SourceID: 1, Offset: 10})
+	d.commit(&Event{SourceID: 1, Offset: 20})
+	assert.True(t, d.isDuplicate(dedupKey{SourceID: 1, Offset: 10}), "committed key isn't found")
+	assert.False(t, d.isDuplicate(dedupKey{SourceID: 2, Offset: 10}), "key of another source is found")
+
+	// the first key is recently used, so the second one is evicted
+	d.commit(&Event{SourceID: 1, Offset: 30})
+	assert.True(t, d.isDuplicate(dedupKey{SourceID: 1, Offset: 10}), "recently used key is evicted")
+	assert.False(t, d.isDuplicate(dedupKey{SourceID: 1, Offset: 20}), "oldest key isn't evicted")
+
+	require.NoError(t, d.save())
+	loaded := newDeduper(2, "", file)
+	require.NoError(t, loaded.load())
+	assert.True(t, loaded.isDuplicate(dedupKey{SourceID: 1, Offset: 10}), "window isn't loaded")
+	assert.True(t, loaded.isDuplicate(dedupKey{SourceID: 1, Offset: 30}), "window isn't loaded")
+
+	// the order is kept, so the key checked last is evicted last
+	loaded.commit(&Event{SourceID: 1, Offset: 40})
+	assert.False(t, loaded.isDuplicate(dedupKey{SourceID: 1, Offset: 10}), "wrong order of the loaded window")
+}
+
+func TestDedupOffsets(t *testing.T) {
+	settings := &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, DedupWindow: 100}
+	p := New("test", settings, prometheus.NewRegistry())
+	p.SetFixtureMode()
+	p.Start()
+	defer p.Stop()
+
+	lines := [][]byte{[]byte(`{"a":1}`), []byte(`{"a":2}`)}
+	events, err := p.RunFixture(lines, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, events, 2, "wrong events count")
+
+	// lines are read again from the same offsets
+	events, err = p.RunFixture(lines, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, events, 0, "duplicates aren't skipped")
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.skipStats.lines.WithLabelValues("duplicate")), "wrong skipped lines")
+}
+
+func TestDedupField(t *testing.T) {
+	settings := &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, DedupWindow: 100, DedupField: "id"}
+	p := New("test", settings, prometheus.NewRegistry())
+	p.SetFixtureMode()
+	p.Start()
+	defer p.Stop()
+
+	events, err := p.RunFixture([][]byte{[]byte(`{"id":"a"}`), []byte(`{"id":"b"}`)}, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, events, 2, "wrong events count")
+
+	events, err = p.RunFixture([][]byte{[]byte(`{"id":"b"}`), []byte(`{"id":"c"}`), []byte(`{"message":"no id"}`)}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"id":"c"}`, `{"message":"no id"}`}, events, "wrong events")
+}
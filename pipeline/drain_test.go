@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+// batchingOutputStub holds events in the batcher until the flush timeout.
+type batchingOutputStub struct {
+	batcher *Batcher
+	sent    atomic.Int64
+}
+
+func (p *batchingOutputStub) Start(_ AnyConfig, params *OutputPluginParams) {
+	p.batcher = NewBatcher("test", "stub", func(_ *WorkerData, batch *Batch) {
+		p.sent.Add(int64(len(batch.Events)))
+	}, nil, params.Controller, 1, 100, 300*time.Millisecond, 0)
+	p.batcher.Start()
+}
+
+func (p *batchingOutputStub) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *batchingOutputStub) Out(event *Event) {
+	p.batcher.Add(event)
+}
+
+func TestStopDrainsEvents(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		committed    int64
+	}{
+		{name: "drain", drainTimeout: 5 * time.Second, committed: 3},
+		{name: "no_drain", drainTimeout: 0, committed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &committingInputStub{}
+			output := &batchingOutputStub{}
+
+			settings := &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, DrainTimeout: tt.drainTimeout}
+			p := New("test", settings, prometheus.NewRegistry())
+			p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+			p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: output}})
+			p.Start()
+
+			for i := 1; i <= 3; i++ {
+				p.In(1, "test.log", int64(i), []byte(`{"message":"line"}`+"\n"), false)
+			}
+			p.Stop()
+
+			assert.Equal(t, tt.committed, input.committed.Load(), "wrong committed events count")
+			assert.Equal(t, tt.committed, output.sent.Load(), "wrong sent events count")
+			if tt.drainTimeout != 0 {
+				assert.Equal(t, uint64(0), p.In(1, "test.log", 4, []byte(`{"message":"line"}`+"\n"), false), "line is accepted after the drain")
+			}
+		})
+	}
+}
@@ -25,6 +25,43 @@ type Event struct {
 	SourceName string
 	streamName StreamName
 	Size       int // last known event size, it may not be actual
+	// Meta is the metadata of the event set by plugins, e.g. k8s labels, it isn't a part of the JSON body,
+	// so it doesn't collide with fields of the event. Outputs access it with `{{meta.key}}` placeholders.
+	Meta map[string]string
+	// retained is the size counted by the memory budget of the pool, it's released when the event is back
+	retained int
+
+	// receivedAt is the time when the input has passed the event to the pipeline
+	receivedAt time.Time
+	// inFlight counts the event in the in-flight cap of the source
+	inFlight *sourceInFlight
+	// trace is set if the event is tagged by the tracer
+	trace *eventTrace
+
+	// synthetic event is created by the pipeline, so it isn't committed to the input
+	synthetic bool
+	// selfTestID is set for the marker event of the self test
+	selfTestID uint64
+	// spool is set for the event replayed from the spool of the held pipeline
+	spool *spoolSegment
+	// pendingOutputs is the number of outputs which haven't committed the event yet if there are several outputs
+	pendingOutputs int32
+	// origin is set for the copy of the event passed to the extra output
+	origin *Event
+	// parent is set for the event spawned by the action, the parent is finalized after all its spawned events
+	parent *Event
+	// pendingSpawned is the number of spawned events and the parent itself which aren't finalized yet
+	pendingSpawned atomic.Int32
+	// spawnedCommitted is set if the parent or any of its spawned events is committed
+	spawnedCommitted atomic.Bool
+	// routePos is the position of the event in the commit queue of the fan-out if events are routed
+	routePos int64
+	// dedupValue is the value of the dedup field, it's copied on the input since actions may change the field
+	dedupValue    string
+	hasDedupValue bool
+	// payload is the body of the opaque event, outputs get it as is instead of the JSON document
+	payload  []byte
+	isOpaque bool
 
 	action int
 	next   *Event
@@ -86,7 +123,8 @@ func unlockEvent(stream *stream) *Event {
 	return event
 }
 
-func (e *Event) reset() {
+// reset prepares the event for reuse, it returns true if the node pool has grown over 4 sizes and is released.
+func (e *Event) reset(nodePoolSize int) (isPoolReleased bool) {
 	if e.Size > eventSizeGCThreshold {
 		e.Root.ReleaseBufMem()
 	}
@@ -95,8 +133,9 @@ func (e *Event) reset() {
 		e.Buf = make([]byte, 0, 1024)
 	}
 
-	if e.Root.PoolSize() > DefaultJSONNodePoolSize*4 {
+	if e.Root.PoolSize() > nodePoolSize*4 {
 		e.Root.ReleasePoolMem()
+		isPoolReleased = true
 	}
 
 	e.Buf = e.Buf[:0]
@@ -104,7 +143,60 @@ func (e *Event) reset() {
 	e.next = nil
 	e.action = 0
 	e.stream = nil
+	e.synthetic = false
+	e.selfTestID = 0
+	e.spool = nil
+	e.pendingOutputs = 0
+	e.origin = nil
+	e.parent = nil
+	e.pendingSpawned.Store(0)
+	e.spawnedCommitted.Store(false)
+	e.routePos = 0
+	e.dedupValue = ""
+	e.hasDedupValue = false
+	if cap(e.payload) > 4096 {
+		e.payload = nil
+	}
+	e.payload = e.payload[:0]
+	e.isOpaque = false
+	e.receivedAt = time.Time{}
+	e.inFlight = nil
+	e.trace = nil
+	e.resetMeta()
 	e.kind.Swap(eventKindRegular)
+
+	return isPoolReleased
+}
+
+// IsOpaque returns true if the event carries the payload instead of the JSON document,
+// the document of the opaque event is empty.
+func (e *Event) IsOpaque() bool {
+	return e.isOpaque
+}
+
+// Payload returns the body of the opaque event, it's valid until the event is committed.
+func (e *Event) Payload() []byte {
+	return e.payload
+}
+
+// SetPayload makes the event opaque, the data is copied, so it's passed to outputs as is.
+func (e *Event) SetPayload(data []byte) {
+	e.payload = append(e.payload[:0], data...)
+	e.isOpaque = true
+}
+
+// SetMeta sets the metadata value, the map is allocated once and reused by the pool.
+func (e *Event) SetMeta(key string, value string) {
+	if e.Meta == nil {
+		e.Meta = make(map[string]string)
+	}
+	e.Meta[key] = value
+}
+
+func (e *Event) resetMeta() {
+	for key := range e.Meta {
+		delete(e.Meta, key)
+	}
 }
 
 func (e *Event) StreamNameBytes() []byte {
@@ -147,14 +239,24 @@ func (e *Event) SubparseJSON(json []byte) (*insaneJSON.Node, error) {
 	return e.Root.DecodeBytesAdditional(json)
 }
 
+// Encode appends the JSON document or the payload of the opaque event and returns the start of it.
 func (e *Event) Encode(outBuf []byte) ([]byte, int) {
 	l := len(outBuf)
-	outBuf = e.Root.Encode(outBuf)
+	outBuf = e.appendBody(outBuf)
 	e.Size = len(outBuf) - l
 
 	return outBuf, l
 }
 
+// appendBody appends the JSON document or the payload of the opaque event without updating the size.
+func (e *Event) appendBody(out []byte) []byte {
+	if e.isOpaque {
+		return append(out, e.payload...)
+	}
+
+	return e.Root.Encode(out)
+}
+
 func (e *Event) stageStr() string {
 	switch e.stage {
 	case eventStagePool:
@@ -186,12 +288,21 @@ func (e *Event) kindStr() string {
 }
 
 func (e *Event) String() string {
+	if e.isOpaque {
+		return fmt.Sprintf("kind=%s, action=%d, source=%d/%s, stream=%s, stage=%s, payload=%q", e.kindStr(), e.action, e.SourceID, e.SourceName, e.streamName, e.stageStr(), e.payload)
+	}
 	return fmt.Sprintf("kind=%s, action=%d, source=%d/%s, stream=%s, stage=%s, json=%s", e.kindStr(), e.action, e.SourceID, e.SourceName, e.streamName, e.stageStr(), e.Root.EncodeToString())
 }
 
 // channels are slower than this implementation by ~20%
 type eventPool struct {
-	capacity int
+	// size is the length of the ring of events, the capacity can't grow beyond it
+	size int
+	// capacity is the max number of events taken from the pool, it can be changed at runtime
+	capacity atomic.Int64
+	// allocated is the number of events in the ring and taken from the pool,
+	// events are allocated lazily and released once they're back if the capacity is shrunk
+	allocated atomic.Int64
 
 	freeEventsCount int
 	getCounter      atomic.Int64
@@ -202,22 +313,42 @@ type eventPool struct {
 
 	getMu   *sync.Mutex
 	getCond *sync.Cond
+
+	memory *memoryBudget // nil if the memory isn't counted
+	// nodePoolSize is the size of json node pools of events, bigger pools are released
+	nodePoolSize  int
+	releasedPools atomic.Int64
 }
 
-func newEventPool(capacity int) *eventPool {
+// newEventPool creates the pool of the capacity which can grow up to the max capacity,
+// the max capacity is the same as the capacity if it's less.
+func newEventPool(capacity int, maxCapacity int, nodePoolSize int, memory *memoryBudget) *eventPool {
+	size := capacity
+	if maxCapacity > size {
+		size = maxCapacity
+	}
+
 	eventPool := &eventPool{
-		capacity:        capacity,
-		freeEventsCount: capacity,
+		size:            size,
+		nodePoolSize:    nodePoolSize,
+		memory:          memory,
+		freeEventsCount: size,
 		getMu:           &sync.Mutex{},
-		backCounter:     *atomic.NewInt64(int64(capacity)),
+		backCounter:     *atomic.NewInt64(int64(size)),
 	}
+	eventPool.capacity.Store(int64(capacity))
+	eventPool.allocated.Store(int64(capacity))
 
 	eventPool.getCond = sync.NewCond(eventPool.getMu)
 
-	for i := 0; i < capacity; i++ {
+	for i := 0; i < size; i++ {
 		eventPool.free1 = append(eventPool.free1, *atomic.NewBool(true))
 		eventPool.free2 = append(eventPool.free2, *atomic.NewBool(true))
-		eventPool.events = append(eventPool.events, newEvent())
+		var event *Event
+		if i < capacity {
+			event = newEvent()
+		}
+		eventPool.events = append(eventPool.events, event)
 	}
 
 	return eventPool
@@ -226,10 +357,12 @@ func newEventPool(capacity int) *eventPool {
 const maxTries = 3
 
 func (p *eventPool) get() *Event {
-	x := (p.getCounter.Inc() - 1) % int64(p.capacity)
+	ticket := p.getCounter.Inc() - 1
+	x := ticket % int64(p.size)
 	var tries int
 	for {
-		if x < p.backCounter.Load() {
+		// events taken by previous tickets and not returned yet should fit the capacity
+		if ticket < p.backCounter.Load()-int64(p.size)+p.capacity.Load() {
 			// fast path
 			if p.free1[x].CAS(true, false) {
 				break
@@ -256,14 +389,38 @@ func (p *eventPool) get() *Event {
 	event := p.events[x]
 	p.events[x] = nil
 	p.free2[x].Store(false)
+	if event == nil {
+		event = newEvent()
+		p.allocated.Inc()
+	}
+
+	if event.reset(p.nodePoolSize) {
+		p.releasedPools.Inc()
+	}
+	return event
+}
+
+// getSized waits until the event of the size fits the memory budget and gets the event from the pool.
+func (p *eventPool) getSized(size int) *Event {
+	p.memory.acquire(size)
+	event := p.get()
+	event.retained = size
 
-	event.reset()
 	return event
 }
 
 func (p *eventPool) back(event *Event) {
 	event.stage = eventStagePool
-	x := (p.backCounter.Inc() - 1) % int64(p.capacity)
+	if event.retained != 0 {
+		p.memory.release(event.retained)
+		event.retained = 0
+	}
+	// the event is released if the pool has more events than the capacity
+	if allocated := p.allocated.Load(); allocated > p.capacity.Load() && p.allocated.CAS(allocated, allocated-1) {
+		event = nil
+	}
+
+	x := (p.backCounter.Inc() - 1) % int64(p.size)
 	var tries int
 	for {
 		// fast path
@@ -292,11 +449,26 @@ func (p *eventPool) back(event *Event) {
 	p.getCond.Broadcast()
 }
 
+// inUse returns the number of events taken from the pool.
+func (p *eventPool) inUse() int64 {
+	return p.getCounter.Load() - p.backCounter.Load() + int64(p.size)
+}
+
+// setCapacity changes the capacity, it should be from 1 to the size of the ring.
+// Events aren't taken away from the pipeline, extra events are released once they're back to the pool.
+func (p *eventPool) setCapacity(capacity int) {
+	p.capacity.Store(int64(capacity))
+	p.getCond.Broadcast()
+}
+
 func (p *eventPool) dump() string {
 	out := logger.Cond(len(p.events) == 0, logger.Header("no events"), func() string {
 		o := logger.Header("events")
 		for i := 0; i < p.freeEventsCount; i++ {
 			event := p.events[i]
+			if event == nil {
+				continue
+			}
 			o += event.String() + "\n"
 		}
 
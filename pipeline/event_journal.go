@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	DefaultEventJournalSize = 4096
+
+	defaultEventJournalLast = 100
+)
+
+// eventJournal keeps the last committed events in a ring buffer.
+type eventJournal struct {
+	mu    *sync.Mutex
+	items []string
+	total int // total count of added events, the next event is written to items[total % len(items)]
+}
+
+func newEventJournal(size int) *eventJournal {
+	return &eventJournal{
+		mu:    &sync.Mutex{},
+		items: make([]string, size),
+	}
+}
+
+func (j *eventJournal) add(event string) {
+	j.mu.Lock()
+	j.items[j.total%len(j.items)] = event
+	j.total++
+	j.mu.Unlock()
+}
+
+// get returns the event by the index in order of adding.
+// It returns false if the event isn't added yet or is already evicted.
+func (j *eventJournal) get(index int) (string, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if index < 0 || index >= j.total || index < j.total-len(j.items) {
+		return "", false
+	}
+
+	return j.items[index%len(j.items)], true
+}
+
+// last returns up to n last events from the oldest to the newest.
+func (j *eventJournal) last(n int) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if n > len(j.items) {
+		n = len(j.items)
+	}
+	if n > j.total {
+		n = j.total
+	}
+
+	result := make([]string, 0, n)
+	for i := j.total - n; i < j.total; i++ {
+		result = append(result, j.items[i%len(j.items)])
+	}
+
+	return result
+}
+
+// serveEvents returns the last events as a JSON array, the count is set by the `last` query param.
+func (j *eventJournal) serveEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	n := defaultEventJournalLast
+	if last := r.URL.Query().Get("last"); last != "" {
+		var err error
+		n, err = strconv.Atoi(last)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeErr(w, "`last` should be a non-negative number")
+			return
+		}
+	}
+
+	resp := []byte{'['}
+	for i, event := range j.last(n) {
+		if i > 0 {
+			resp = append(resp, ',')
+		}
+		resp = append(resp, event...)
+	}
+	resp = append(resp, ']')
+
+	_, _ = w.Write(resp)
+}
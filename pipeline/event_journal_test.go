@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventJournal(t *testing.T) {
+	j := newEventJournal(3)
+
+	_, has := j.get(0)
+	assert.False(t, has, "empty journal shouldn't have events")
+	assert.Equal(t, []string{}, j.last(10))
+
+	for i := 0; i < 5; i++ {
+		j.add(`{"i":` + strconv.Itoa(i) + `}`)
+	}
+
+	_, has = j.get(1)
+	assert.False(t, has, "evicted event shouldn't be returned")
+	_, has = j.get(5)
+	assert.False(t, has, "not added event shouldn't be returned")
+
+	event, has := j.get(4)
+	assert.True(t, has, "last event should be returned")
+	assert.Equal(t, `{"i":4}`, event)
+
+	assert.Equal(t, []string{`{"i":3}`, `{"i":4}`}, j.last(2))
+	assert.Equal(t, []string{`{"i":2}`, `{"i":3}`, `{"i":4}`}, j.last(10))
+}
+
+func TestEventJournalServe(t *testing.T) {
+	j := newEventJournal(3)
+	j.add(`{"i":0}`)
+	j.add(`{"i":1}`)
+
+	w := httptest.NewRecorder()
+	j.serveEvents(w, httptest.NewRequest("GET", "/pipelines/test/events?last=1", nil))
+	assert.Equal(t, `[{"i":1}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	j.serveEvents(w, httptest.NewRequest("GET", "/pipelines/test/events", nil))
+	assert.Equal(t, `[{"i":0},{"i":1}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	j.serveEvents(w, httptest.NewRequest("GET", "/pipelines/test/events?last=x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
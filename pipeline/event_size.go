@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// OversizedEventTruncate keeps the beginning of the line up to the max event size.
+	OversizedEventTruncate = "truncate"
+	// OversizedEventDiscard drops the line, it's written to the dead letter file if it's set.
+	OversizedEventDiscard = "discard"
+	// OversizedEventSplit passes the line as several events of the max event size.
+	OversizedEventSplit = "split"
+)
+
+var errEventTooBig = errors.New("event is bigger than the max event size")
+
+// eventSizeLimit protects the pipeline from enormous lines, they're handled before decoding,
+// since the decoded document and output buffers take several times more memory than the line.
+type eventSizeLimit struct {
+	maxSize int
+	policy  string
+
+	oversized prometheus.Counter
+}
+
+func newEventSizeLimit(pipelineName string, settings *Settings, registry prometheus.Registerer) *eventSizeLimit {
+	policy := settings.OversizedEvent
+	if policy == "" {
+		policy = OversizedEventTruncate
+	}
+
+	l := &eventSizeLimit{
+		maxSize: settings.MaxEventSize,
+		policy:  policy,
+		oversized: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "oversized_events_total",
+			Help:      "how many lines exceed the max event size",
+		}),
+	}
+
+	registry.MustRegister(l.oversized)
+
+	return l
+}
+
+func (l *eventSizeLimit) isEnabled() bool {
+	return l.maxSize > 0
+}
+
+// isOversized checks the line without the line ending.
+func (l *eventSizeLimit) isOversized(data []byte) bool {
+	if len(trimLineEnd(data)) <= l.maxSize {
+		return false
+	}
+
+	l.oversized.Inc()
+	return true
+}
+
+// truncate returns the beginning of the line of the max size.
+func (l *eventSizeLimit) truncate(data []byte) []byte {
+	return data[:l.cutPos(data)]
+}
+
+// split returns parts of the line of the max size, the line ending is dropped.
+func (l *eventSizeLimit) split(data []byte) [][]byte {
+	data = trimLineEnd(data)
+
+	parts := make([][]byte, 0, len(data)/l.maxSize+1)
+	for len(data) > l.maxSize {
+		pos := l.cutPos(data)
+		parts = append(parts, data[:pos])
+		data = data[pos:]
+	}
+	if len(data) > 0 {
+		parts = append(parts, data)
+	}
+
+	return parts
+}
+
+// cutPos returns the position to cut the data at the max size without breaking utf-8 characters,
+// the data is cut at the max size if it isn't utf-8.
+func (l *eventSizeLimit) cutPos(data []byte) int {
+	pos := l.maxSize
+	for i := 0; i < 3 && pos-i > 0; i++ {
+		// continuation bytes of utf-8 characters are 10xxxxxx
+		if data[pos-i]&0xC0 != 0x80 {
+			return pos - i
+		}
+	}
+
+	return pos
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventSizeLimit(t *testing.T) {
+	l := newEventSizeLimit("test", &Settings{MaxEventSize: 4}, prometheus.NewRegistry())
+	assert.Equal(t, OversizedEventTruncate, l.policy, "wrong default policy")
+
+	assert.False(t, l.isOversized([]byte("abcd\r\n")), "line ending is counted")
+	assert.True(t, l.isOversized([]byte("abcde\n")), "oversized line isn't found")
+
+	assert.Equal(t, "abcd", string(l.truncate([]byte("abcdefg\n"))), "wrong truncated line")
+	assert.Equal(t, []string{"abcd", "efgh", "i"}, partsToStrings(l.split([]byte("abcdefghi\n"))), "wrong parts")
+
+	// "ж" takes 2 bytes, it isn't cut in the middle
+	assert.Equal(t, "abc", string(l.truncate([]byte("abcжd"))), "utf-8 character is broken")
+	assert.Equal(t, []string{"abc", "жde"}, partsToStrings(l.split([]byte("abcжde"))), "utf-8 character is broken")
+}
+
+func TestOversizedEvent(t *testing.T) {
+	huge := `{"message":"` + "0123456789" + `"}`
+
+	tests := []struct {
+		policy string
+		events []string
+	}{
+		{policy: OversizedEventTruncate, events: []string{`{"a":1}`, `{"message":"{\"message\":\"01234"}`}},
+		{policy: OversizedEventDiscard, events: []string{`{"a":1}`}},
+		{policy: OversizedEventSplit, events: []string{`{"a":1}`, `{"message":"{\"message\":\"01234"}`, `{"message":"56789\"}"}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			settings := &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, MaxEventSize: 17, OversizedEvent: tt.policy}
+			p := New("test", settings, prometheus.NewRegistry())
+			p.SetFixtureMode()
+			p.Start()
+			defer p.Stop()
+
+			events, err := p.RunFixture([][]byte{[]byte(`{"a":1}`), []byte(huge)}, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, tt.events, events, "wrong events")
+			assert.Equal(t, float64(1), testutil.ToFloat64(p.eventSize.oversized), "wrong oversized events count")
+		})
+	}
+}
+
+func partsToStrings(parts [][]byte) []string {
+	result := make([]string, 0, len(parts))
+	for _, part := range parts {
+		result = append(result, string(part))
+	}
+
+	return result
+}
@@ -9,7 +9,7 @@ import (
 func BenchmarkEventPoolOneGoroutine(b *testing.B) {
 	const capacity = 32
 
-	p := newEventPool(capacity)
+	p := newEventPool(capacity, capacity, DefaultJSONNodePoolSize, nil)
 
 	for i := 0; i < b.N; i++ {
 		p.back(p.get())
@@ -19,7 +19,7 @@ func BenchmarkEventPoolOneGoroutine(b *testing.B) {
 func BenchmarkEventPoolManyGoroutines(b *testing.B) {
 	const capacity = 32
 
-	p := newEventPool(capacity)
+	p := newEventPool(capacity, capacity, DefaultJSONNodePoolSize, nil)
 
 	for i := 0; i < b.N; i++ {
 		wg := &sync.WaitGroup{}
@@ -39,7 +39,7 @@ func BenchmarkEventPoolManyGoroutines(b *testing.B) {
 func BenchmarkEventPoolSlowestPath(b *testing.B) {
 	const capacity = 32
 
-	p := newEventPool(capacity)
+	p := newEventPool(capacity, capacity, DefaultJSONNodePoolSize, nil)
 
 	for i := 0; i < b.N; i++ {
 		wg := &sync.WaitGroup{}
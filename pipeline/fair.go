@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StreamWeight multiplies the stream quota of streams with names matching the pattern.
+type StreamWeight struct {
+	Pattern string
+	Weight  int
+}
+
+// fairness makes processors take at most the quota of events from a stream in one turn,
+// then the stream goes to the end of the charged queue, so a chatty stream doesn't starve others.
+type fairness struct {
+	quota   int
+	weights []*streamWeight
+}
+
+type streamWeight struct {
+	pattern *regexp.Regexp
+	weight  int
+}
+
+func newFairness(quota int, weights []*streamWeight) *fairness {
+	return &fairness{
+		quota:   quota,
+		weights: weights,
+	}
+}
+
+// streamQuota returns how many events may be taken from the stream in one turn, 0 means no limit.
+func (f *fairness) streamQuota(name StreamName) int {
+	if f == nil || f.quota <= 0 {
+		return 0
+	}
+
+	for _, w := range f.weights {
+		if w.pattern.MatchString(string(name)) {
+			return f.quota * w.weight
+		}
+	}
+
+	return f.quota
+}
+
+// streamLag exposes the count of queued events and the age of the oldest queued event per stream name.
+type streamLag struct {
+	events  *prometheus.GaugeVec
+	seconds *prometheus.GaugeVec
+}
+
+func newStreamLag(pipelineName string, registry prometheus.Registerer) *streamLag {
+	l := &streamLag{
+		events: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "stream_lag_events",
+			Help:      "how many events of streams with the name wait for a processor",
+		}, []string{"stream"}),
+		seconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "stream_lag_seconds",
+			Help:      "how long the oldest event of streams with the name waits for a processor",
+		}, []string{"stream"}),
+	}
+
+	registry.MustRegister(l.events, l.seconds)
+
+	return l
+}
+
+// update sets gauges of streams with queued events, gauges of drained streams are removed.
+func (l *streamLag) update(s *streamer, now time.Time) {
+	events, seconds := collectStreamLag(s, now)
+
+	l.events.Reset()
+	l.seconds.Reset()
+	for name, queued := range events {
+		l.events.WithLabelValues(string(name)).Set(float64(queued))
+		l.seconds.WithLabelValues(string(name)).Set(seconds[name])
+	}
+}
+
+// collectStreamLag returns the count of queued events and the age of the oldest one per stream name,
+// streams without queued events are skipped.
+func collectStreamLag(s *streamer, now time.Time) (map[StreamName]int, map[StreamName]float64) {
+	events := make(map[StreamName]int)
+	seconds := make(map[StreamName]float64)
+
+	s.mu.RLock()
+	for _, source := range s.streams {
+		for name, st := range source {
+			queued, oldest := st.lag()
+			if queued == 0 {
+				continue
+			}
+
+			events[name] += queued
+			if oldest.IsZero() {
+				continue
+			}
+			if age := now.Sub(oldest).Seconds(); age > seconds[name] {
+				seconds[name] = age
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	return events, seconds
+}
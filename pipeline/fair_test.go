@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamQuota(t *testing.T) {
+	f := newFairness(10, []*streamWeight{{pattern: regexp.MustCompile("^audit"), weight: 3}})
+
+	assert.Equal(t, 30, f.streamQuota("audit_log"), "weight isn't applied")
+	assert.Equal(t, 10, f.streamQuota("stdout"), "wrong default quota")
+	assert.Equal(t, 0, (*fairness)(nil).streamQuota("stdout"), "quota is set without fairness")
+}
+
+func TestFairScheduling(t *testing.T) {
+	s := newStreamer()
+	s.fairness = newFairness(2, nil)
+
+	for i := 0; i < 5; i++ {
+		s.putEvent(1, "chatty", &Event{})
+	}
+	s.putEvent(2, "quiet", &Event{})
+
+	chatty := s.joinStream(0, nil)
+	assert.Equal(t, StreamName("chatty"), chatty.name, "streams aren't taken in turn")
+
+	var last *Event
+	for !chatty.isExhausted() {
+		last = chatty.instantGet()
+	}
+	assert.Equal(t, uint64(2), last.SeqID, "quota isn't applied")
+	chatty.yield()
+
+	quiet := s.joinStream(0, nil)
+	assert.Equal(t, StreamName("quiet"), quiet.name, "chatty stream isn't yielded")
+
+	// the yielded stream is charged again only after taken events are committed
+	chatty.commit(last)
+	chatty = s.joinStream(0, nil)
+	assert.Equal(t, StreamName("chatty"), chatty.name, "yielded stream isn't charged again")
+	assert.False(t, chatty.isExhausted(), "quota isn't reset")
+	assert.Equal(t, uint64(3), chatty.instantGet().SeqID, "wrong event")
+}
+
+func TestStreamLag(t *testing.T) {
+	s := newStreamer()
+	lag := newStreamLag("test", prometheus.NewRegistry())
+	now := time.Now()
+
+	s.putEvent(1, "stdout", &Event{receivedAt: now.Add(-time.Second)})
+	s.putEvent(1, "stdout", &Event{receivedAt: now})
+	s.putEvent(2, "stdout", &Event{receivedAt: now.Add(-3 * time.Second)})
+	s.getStream(3, "stderr")
+
+	lag.update(s, now)
+	assert.Equal(t, float64(3), testutil.ToFloat64(lag.events.WithLabelValues("stdout")), "wrong queued events")
+	assert.Equal(t, float64(3), testutil.ToFloat64(lag.seconds.WithLabelValues("stdout")), "wrong lag")
+	assert.Equal(t, 1, testutil.CollectAndCount(lag.events), "drained streams are exposed")
+}
@@ -0,0 +1,266 @@
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+)
+
+// fanOut passes every event to all outputs of the pipeline, the event is committed
+// when all outputs have committed it. Outputs may mutate events, e.g. `gelf` renames fields,
+// so every output except the first one gets a copy of the event.
+//
+// Every output commits events in order, so events are committed to the input in order as well:
+// the output which commits the event last also commits the next event of the source after it.
+//
+// If outputs have match conditions or the pipeline has routes, the event is passed only to the matching outputs and
+// it's discarded if there are none. Outputs get different events then, so the order is restored by queues of sources.
+type fanOut struct {
+	pipeline *Pipeline
+	outputs  []*fanOutput
+	copies   *sync.Pool
+	// queues are set if events are routed
+	queues *sourceQueues
+	// routes are set if the pipeline has the routing table
+	routes *routeTable
+}
+
+// fanOutput is the controller of the output to count commits of events.
+type fanOutput struct {
+	fanOut *fanOut
+	info   *OutputPluginInfo
+	plugin OutputPlugin
+
+	// control chars are sanitized per output since modes may differ, sanitizers aren't thread safe
+	controlChars *sync.Pool
+}
+
+func newFanOut(pipeline *Pipeline, infos []*OutputPluginInfo) *fanOut {
+	f := &fanOut{
+		pipeline: pipeline,
+		copies: &sync.Pool{
+			New: func() interface{} {
+				return newEvent()
+			},
+		},
+	}
+
+	for _, info := range infos {
+		if info.IsRouted() {
+			f.queues = newSourceQueues()
+		}
+
+		mode := info.ControlChars
+		f.outputs = append(f.outputs, &fanOutput{
+			fanOut: f,
+			info:   info,
+			plugin: info.Plugin.(OutputPlugin),
+			controlChars: &sync.Pool{
+				New: func() interface{} {
+					return newControlChars(mode)
+				},
+			},
+		})
+	}
+
+	if pipeline.routes != nil {
+		f.routes = newRouteTable(pipeline.routes, f.outputs)
+		f.queues = newSourceQueues()
+	}
+
+	return f
+}
+
+func (f *fanOut) Start(_ AnyConfig, params *OutputPluginParams) {
+	for _, o := range f.outputs {
+		f.pipeline.logger.Infof("starting output plugin %q", o.info.Type)
+		o.plugin.Start(o.info.Config, &OutputPluginParams{
+			PluginDefaultParams: params.PluginDefaultParams,
+			Controller:          o,
+			Logger:              f.pipeline.logger.Named("output " + o.info.Type),
+			Chaos:               o.info.Chaos,
+		})
+	}
+}
+
+func (f *fanOut) Stop() {
+	for _, o := range f.outputs {
+		o.plugin.Stop()
+	}
+}
+
+// Out copies the event for outputs before any of them gets it, since the first output may mutate it.
+func (f *fanOut) Out(event *Event) {
+	outBuf := [8]*fanOutput{}
+	outputs := outBuf[:0]
+	if f.routes != nil {
+		outputs = f.routes.route(event)
+	} else {
+		for _, o := range f.outputs {
+			if o.info.isMatch(event) {
+				outputs = append(outputs, o)
+			}
+		}
+	}
+
+	if len(outputs) == 0 {
+		f.pipeline.discard(event)
+		return
+	}
+
+	atomic.StoreInt32(&event.pendingOutputs, int32(len(outputs)))
+	if f.queues != nil {
+		event.routePos = f.queues.push(event)
+	}
+
+	buf := [8]*Event{}
+	copies := append(buf[:0], event)
+	for i := 1; i < len(outputs); i++ {
+		copies = append(copies, f.copy(event))
+	}
+
+	for i, o := range outputs {
+		o.sanitize(copies[i])
+		o.plugin.Out(copies[i])
+	}
+}
+
+func (f *fanOut) copy(event *Event) *Event {
+	c := f.copies.Get().(*Event)
+	c.Buf = event.Root.Encode(c.Buf[:0])
+	if err := c.Root.DecodeBytes(c.Buf); err != nil {
+		logger.Panicf("can't copy event: %s", err.Error())
+	}
+
+	c.SeqID = event.SeqID
+	c.Offset = event.Offset
+	c.SourceID = event.SourceID
+	c.SourceName = event.SourceName
+	c.streamName = event.streamName
+	c.Size = event.Size
+	c.receivedAt = event.receivedAt
+	c.synthetic = event.synthetic
+	c.stage = event.stage
+	c.origin = event
+	if event.isOpaque {
+		c.SetPayload(event.payload)
+	}
+	c.resetMeta()
+	for key, value := range event.Meta {
+		c.SetMeta(key, value)
+	}
+
+	return c
+}
+
+func (o *fanOutput) sanitize(event *Event) {
+	if o.info.ServiceFields == ServiceFieldsStrip {
+		stripServiceFields(event)
+	}
+	if o.info.ControlChars == ControlCharsKeep {
+		return
+	}
+
+	controlChars := o.controlChars.Get().(*controlChars)
+	controlChars.sanitize(event)
+	o.controlChars.Put(controlChars)
+}
+
+func (o *fanOutput) Commit(event *Event) {
+	origin := event
+	if event.origin != nil {
+		origin = event.origin
+		o.fanOut.back(event)
+	}
+
+	if atomic.AddInt32(&origin.pendingOutputs, -1) != 0 {
+		return
+	}
+
+	if o.fanOut.queues != nil {
+		o.fanOut.queues.done(o.fanOut.pipeline.Commit, origin)
+		return
+	}
+
+	o.fanOut.pipeline.Commit(origin)
+}
+
+// sourceQueues restore the order of routed events per source, since inputs need commits in order only within the source.
+// So the slow route holds commits only of sources which have events in it.
+type sourceQueues struct {
+	mu     *sync.Mutex
+	queues map[SourceID]*commitQueue
+}
+
+func newSourceQueues() *sourceQueues {
+	return &sourceQueues{
+		mu:     &sync.Mutex{},
+		queues: make(map[SourceID]*commitQueue),
+	}
+}
+
+func (s *sourceQueues) push(event *Event) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, has := s.queues[event.SourceID]
+	if !has {
+		queue = newCommitQueue()
+		s.queues[event.SourceID] = queue
+	}
+
+	return queue.push(event)
+}
+
+// done commits sent events of the source, the queue is removed when it's empty, so queues of gone sources don't pile up.
+func (s *sourceQueues) done(commit func(event *Event), event *Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the event may be reused after the commit
+	sourceID := event.SourceID
+	queue := s.queues[sourceID]
+	queue.done(commit, event.routePos)
+	if queue.isEmpty() {
+		delete(s.queues, sourceID)
+	}
+}
+
+func (f *fanOut) back(event *Event) {
+	event.reset(f.pipeline.eventPool.nodePoolSize)
+	f.copies.Put(event)
+}
+
+func (o *fanOutput) Error(err string) {
+	o.fanOut.pipeline.Error(err)
+}
+
+func (o *fanOutput) Backpressure(saturated bool) {
+	o.fanOut.pipeline.backpressure.set(o, o.info.Type, saturated)
+}
+
+func (o *fanOutput) adaptiveBatching() *AdaptiveBatching {
+	return o.fanOut.pipeline.adaptiveBatching()
+}
+
+func (o *fanOutput) lowLatency() bool {
+	return o.fanOut.pipeline.settings.LowLatency || o.info.LowLatency
+}
+
+func (o *fanOutput) batchPartitionField() []string {
+	return parsePartitionField(o.info)
+}
+
+func (o *fanOutput) delivery() string {
+	return o.fanOut.pipeline.delivery()
+}
+
+func (o *fanOutput) maxEventAge() time.Duration {
+	return o.fanOut.pipeline.maxEventAge()
+}
+
+func (o *fanOutput) shed(outputType string, events []*Event) {
+	o.fanOut.pipeline.shed(outputType, events)
+}
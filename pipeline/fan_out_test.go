@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+type committingInputStub struct {
+	committed atomic.Int64
+}
+
+func (p *committingInputStub) Start(AnyConfig, *InputPluginParams) {}
+func (p *committingInputStub) Stop()                               {}
+func (p *committingInputStub) Commit(*Event) {
+	p.committed.Inc()
+}
+
+// queueOutputStub holds events until they're released by the test.
+type queueOutputStub struct {
+	controller OutputPluginController
+	mu         sync.Mutex
+	events     []*Event
+	messages   []string
+}
+
+func (p *queueOutputStub) Start(_ AnyConfig, params *OutputPluginParams) {
+	p.controller = params.Controller
+}
+func (p *queueOutputStub) Stop() {}
+func (p *queueOutputStub) Out(event *Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, string([]byte(event.Root.Dig("message").AsString())))
+	// the output mutates the event, other outputs shouldn't see it
+	event.Root.Dig("message").MutateToString("mutated")
+	p.events = append(p.events, event)
+}
+
+func (p *queueOutputStub) collected() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.messages...)
+}
+
+func (p *queueOutputStub) commitAll() {
+	p.mu.Lock()
+	events := p.events
+	p.events = nil
+	p.mu.Unlock()
+
+	for _, event := range events {
+		p.controller.Commit(event)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	input := &committingInputStub{}
+	first := &queueOutputStub{}
+	second := &queueOutputStub{}
+
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "first"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: first}})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "second"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: second}, ControlChars: ControlCharsStrip})
+	p.Start()
+	defer p.Stop()
+
+	p.In(1, "test.log", 1, []byte(`{"message":"one"}`+"\n"), false)
+	p.In(1, "test.log", 2, []byte(`{"message":"two\u0007"}`+"\n"), false)
+
+	require.Eventually(t, func() bool {
+		return len(first.collected()) == 2 && len(second.collected()) == 2
+	}, 5*time.Second, time.Millisecond, "events aren't passed to all outputs")
+
+	assert.Equal(t, []string{"one", "two\a"}, first.collected(), "wrong events of the first output")
+	assert.Equal(t, []string{"one", "two"}, second.collected(), "wrong events of the second output")
+
+	first.commitAll()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(0), input.committed.Load(), "events are committed before all outputs have committed them")
+
+	second.commitAll()
+	require.Eventually(t, func() bool { return input.committed.Load() == 2 }, 5*time.Second, time.Millisecond, "events aren't committed")
+}
+
+type offsetsInputStub struct {
+	mu      sync.Mutex
+	offsets []int64
+}
+
+func (p *offsetsInputStub) Start(AnyConfig, *InputPluginParams) {}
+func (p *offsetsInputStub) Stop()                               {}
+func (p *offsetsInputStub) Commit(event *Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offsets = append(p.offsets, event.Offset)
+}
+
+func (p *offsetsInputStub) committed() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int64(nil), p.offsets...)
+}
+
+func TestFanOutRouting(t *testing.T) {
+	input := &offsetsInputStub{}
+	errors := &queueOutputStub{}
+	rest := &queueOutputStub{}
+
+	conditions := MatchConditions{{Field: "level", Value: "error"}}
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "errors"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: errors}, MatchConditions: conditions})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "rest"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: rest}, MatchConditions: conditions, MatchInvert: true})
+	p.Start()
+	defer p.Stop()
+
+	p.In(1, "test.log", 1, []byte(`{"level":"error","message":"one"}`+"\n"), false)
+	p.In(1, "test.log", 2, []byte(`{"level":"info","message":"two"}`+"\n"), false)
+	p.In(1, "test.log", 3, []byte(`{"level":"error","message":"three"}`+"\n"), false)
+
+	require.Eventually(t, func() bool {
+		return len(errors.collected()) == 2 && len(rest.collected()) == 1
+	}, 5*time.Second, time.Millisecond, "events aren't routed")
+
+	assert.Equal(t, []string{"one", "three"}, errors.collected(), "wrong events of the errors output")
+	assert.Equal(t, []string{"two"}, rest.collected(), "wrong events of the rest output")
+
+	rest.commitAll()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, len(input.committed()), "event is committed before preceding events")
+
+	errors.commitAll()
+	require.Eventually(t, func() bool { return len(input.committed()) == 3 }, 5*time.Second, time.Millisecond, "events aren't committed")
+	assert.Equal(t, []int64{1, 2, 3}, input.committed(), "events are committed out of order")
+}
+
+func TestFanOutRoutingSources(t *testing.T) {
+	input := &offsetsInputStub{}
+	errors := &queueOutputStub{}
+	rest := &queueOutputStub{}
+
+	conditions := MatchConditions{{Field: "level", Value: "error"}}
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "errors"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: errors}, MatchConditions: conditions})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "rest"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: rest}, MatchConditions: conditions, MatchInvert: true})
+	p.Start()
+	defer p.Stop()
+
+	p.In(1, "first.log", 1, []byte(`{"level":"error","message":"one"}`+"\n"), false)
+	p.In(2, "second.log", 2, []byte(`{"level":"info","message":"two"}`+"\n"), false)
+
+	require.Eventually(t, func() bool {
+		return len(errors.collected()) == 1 && len(rest.collected()) == 1
+	}, 5*time.Second, time.Millisecond, "events aren't routed")
+
+	// the event of the first source is held by the errors output, it shouldn't hold commits of the second source
+	rest.commitAll()
+	require.Eventually(t, func() bool { return len(input.committed()) == 1 }, 5*time.Second, time.Millisecond, "event of another source isn't committed")
+	assert.Equal(t, []int64{2}, input.committed(), "wrong committed event")
+
+	errors.commitAll()
+	require.Eventually(t, func() bool { return len(input.committed()) == 2 }, 5*time.Second, time.Millisecond, "events aren't committed")
+}
+
+func TestFanOutRoutingDiscard(t *testing.T) {
+	input := &offsetsInputStub{}
+	errors := &queueOutputStub{}
+
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "errors"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: errors}, MatchConditions: MatchConditions{{Field: "level", Value: "error"}}})
+	p.Start()
+	defer p.Stop()
+
+	p.In(1, "test.log", 1, []byte(`{"level":"info","message":"one"}`+"\n"), false)
+	p.In(1, "test.log", 2, []byte(`{"level":"error","message":"two"}`+"\n"), false)
+
+	require.Eventually(t, func() bool { return len(errors.collected()) == 1 }, 5*time.Second, time.Millisecond, "event isn't routed")
+	assert.Equal(t, []string{"two"}, errors.collected(), "not matching event is passed to the output")
+
+	errors.commitAll()
+	require.Eventually(t, func() bool { return len(input.committed()) == 1 }, 5*time.Second, time.Millisecond, "event isn't committed")
+	assert.Equal(t, []int64{2}, input.committed(), "discarded event is committed")
+}
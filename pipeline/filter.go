@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+const (
+	filterReasonEmpty     = "empty"
+	filterReasonHeartbeat = "heartbeat"
+)
+
+// eventFilter drops junk events at the input boundary,
+// so they don't pay the cost of decoding, streaming and actions.
+type eventFilter struct {
+	dropEmpty  bool
+	heartbeats []*regexp.Regexp
+	dropped    *prometheus.CounterVec
+}
+
+func newEventFilter(pipelineName string, settings *Settings, registry prometheus.Registerer) (*eventFilter, error) {
+	f := &eventFilter{
+		dropEmpty: settings.DropEmpty,
+	}
+
+	for _, pattern := range settings.HeartbeatPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.heartbeats = append(f.heartbeats, re)
+	}
+
+	if !f.isEnabled() {
+		return f, nil
+	}
+
+	f.dropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "file_d",
+		Subsystem: "pipeline_" + pipelineName,
+		Name:      "filtered_events_total",
+		Help:      "how many events are dropped by the pipeline filter",
+	}, []string{"reason"})
+	registry.MustRegister(f.dropped)
+
+	return f, nil
+}
+
+func (f *eventFilter) isEnabled() bool {
+	return f.dropEmpty || len(f.heartbeats) > 0
+}
+
+// isJunk checks raw data before decoding, blank data is already skipped by the pipeline if `drop_empty` is set.
+func (f *eventFilter) isJunk(data []byte) bool {
+	for _, re := range f.heartbeats {
+		if re.Match(data) {
+			f.dropped.WithLabelValues(filterReasonHeartbeat).Inc()
+			return true
+		}
+	}
+
+	return false
+}
+
+// isEmpty checks the decoded event: it's empty if it has no fields or all of them are blank strings or nulls.
+func (f *eventFilter) isEmpty(root *insaneJSON.Root) bool {
+	if !f.dropEmpty || !root.IsObject() {
+		return false
+	}
+
+	for _, field := range root.AsFields() {
+		value := field.AsFieldValue()
+		if value.IsNull() {
+			continue
+		}
+		if !value.IsString() || !isBlank(value.AsBytes()) {
+			return false
+		}
+	}
+
+	f.dropped.WithLabelValues(filterReasonEmpty).Inc()
+	return true
+}
@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestEventFilter(t *testing.T) {
+	f, err := newEventFilter("test", &Settings{
+		DropEmpty:         true,
+		HeartbeatPatterns: []string{`^\{"type":"ping"\}`, `healthcheck`},
+	}, prometheus.NewRegistry())
+	assert.NoError(t, err)
+	assert.True(t, f.isEnabled())
+
+	assert.True(t, f.isJunk([]byte(`{"type":"ping"}`)), "heartbeat should be dropped")
+	assert.True(t, f.isJunk([]byte(`GET /healthcheck 200`)), "heartbeat should be dropped")
+	assert.False(t, f.isJunk([]byte(`{"type":"pong"}`)), "event shouldn't be dropped")
+
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	for json, isEmpty := range map[string]bool{
+		`{}`:                             true,
+		`{"message":" ","level":null}`:   true,
+		`{"message":" ","level":"info"}`: false,
+		`{"message":0}`:                  false,
+		`[]`:                             false,
+	} {
+		assert.NoError(t, root.DecodeString(json))
+		assert.Equal(t, isEmpty, f.isEmpty(root), "wrong result for %s", json)
+	}
+}
+
+func TestEventFilterDisabled(t *testing.T) {
+	f, err := newEventFilter("test", &Settings{}, prometheus.NewRegistry())
+	assert.NoError(t, err)
+	assert.False(t, f.isEnabled())
+
+	_, err = newEventFilter("test", &Settings{HeartbeatPatterns: []string{"("}}, prometheus.NewRegistry())
+	assert.Error(t, err)
+}
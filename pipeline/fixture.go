@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const fixtureSourceName = "fixture"
+
+// fixtureInput is the input of the pipeline running fixtures, lines are passed by RunFixture.
+type fixtureInput struct{}
+
+func (i *fixtureInput) Start(_ AnyConfig, _ *InputPluginParams) {}
+func (i *fixtureInput) Stop()                                   {}
+func (i *fixtureInput) Commit(_ *Event)                         {}
+
+// fixtureOutput collects encoded documents the pipeline outputs.
+type fixtureOutput struct {
+	controller OutputPluginController
+
+	mu        *sync.Mutex
+	documents []string
+}
+
+func (o *fixtureOutput) Start(_ AnyConfig, params *OutputPluginParams) {
+	o.controller = params.Controller
+}
+
+func (o *fixtureOutput) Stop() {}
+
+func (o *fixtureOutput) Out(event *Event) {
+	o.mu.Lock()
+	o.documents = append(o.documents, string(event.appendBody(nil)))
+	o.mu.Unlock()
+
+	o.controller.Commit(event)
+}
+
+func (o *fixtureOutput) take() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	documents := o.documents
+	o.documents = nil
+	return documents
+}
+
+// SetFixtureMode replaces the input and the output of the pipeline by stubs,
+// so fixture lines are passed through actions of the pipeline by RunFixture.
+func (p *Pipeline) SetFixtureMode() {
+	p.SetInput(&InputPluginInfo{
+		PluginStaticInfo:  &PluginStaticInfo{Type: "fixture"},
+		PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &fixtureInput{}},
+	})
+
+	p.fixture = &fixtureOutput{mu: &sync.Mutex{}}
+	p.SetOutput(&OutputPluginInfo{
+		PluginStaticInfo:  &PluginStaticInfo{Type: "fixture"},
+		PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: p.fixture},
+	})
+}
+
+// RunFixture passes lines through actions of the started pipeline as lines of one source
+// and returns documents the pipeline outputs. It waits until there are no events in the pipeline,
+// so it returns an error if some events are still held by actions, e.g. by `join`, after the timeout.
+func (p *Pipeline) RunFixture(lines [][]byte, timeout time.Duration) ([]string, error) {
+	if p.fixture == nil {
+		return nil, fmt.Errorf("pipeline %q isn't in the fixture mode", p.Name)
+	}
+
+	offset := int64(0)
+	for i, line := range lines {
+		offset += int64(len(line))
+		p.In(1, fixtureSourceName, offset, line, i == 0)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for p.eventPool.inUse() != 0 {
+		if time.Now().After(deadline) {
+			return p.fixture.take(), fmt.Errorf("%d events are still in the pipeline after %s", p.eventPool.inUse(), timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return p.fixture.take(), nil
+}
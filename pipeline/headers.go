@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// HeaderTemplates render HTTP request headers of outputs from static values and event fields,
+// e.g. `X-Scope-OrgID: {{.tenant}}` for multi-tenant gateways.
+type HeaderTemplates struct {
+	names     []string
+	templates []*EventTemplate
+	isStatic  bool
+}
+
+// HeaderGroup is a part of the batch with the same headers, so it can be sent in one request.
+type HeaderGroup struct {
+	Header http.Header
+	Events []*Event
+}
+
+func ParseHeaderTemplates(headers map[string]string) (*HeaderTemplates, error) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := &HeaderTemplates{isStatic: true}
+	for _, name := range names {
+		template, err := ParseEventTemplate(headers[name])
+		if err != nil {
+			return nil, fmt.Errorf("wrong value of header %s: %w", name, err)
+		}
+		h.names = append(h.names, http.CanonicalHeaderKey(name))
+		h.templates = append(h.templates, template)
+		h.isStatic = h.isStatic && template.IsStatic()
+	}
+
+	return h, nil
+}
+
+// Render renders headers for the event, headers with empty values are skipped.
+func (h *HeaderTemplates) Render(event *Event) http.Header {
+	header := make(http.Header, len(h.names))
+	buf := make([]byte, 0)
+	for i, name := range h.names {
+		buf = h.templates[i].Render(buf[:0], event)
+		if len(buf) == 0 {
+			continue
+		}
+		header.Set(name, string(buf))
+	}
+
+	return header
+}
+
+// Partition splits events into groups with the same headers, the order of events inside groups is kept.
+// Groups are ordered by the first event.
+func (h *HeaderTemplates) Partition(events []*Event) []HeaderGroup {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if h.isStatic {
+		return []HeaderGroup{{Header: h.Render(events[0]), Events: events}}
+	}
+
+	groups := make([]HeaderGroup, 0, 1)
+	indexes := make(map[string]int)
+	key := make([]byte, 0)
+	for _, event := range events {
+		key = key[:0]
+		for _, template := range h.templates {
+			key = template.Render(key, event)
+			key = append(key, 0)
+		}
+
+		index, has := indexes[string(key)]
+		if !has {
+			index = len(groups)
+			indexes[string(key)] = index
+			groups = append(groups, HeaderGroup{Header: h.Render(event)})
+		}
+		groups[index].Events = append(groups[index].Events, event)
+	}
+
+	return groups
+}
@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestHeaderTemplatesPartition(t *testing.T) {
+	headers, err := ParseHeaderTemplates(map[string]string{
+		"x-scope-orgid": "{{.tenant}}",
+		"Authorization": "Bearer token",
+	})
+	require.NoError(t, err)
+
+	events := make([]*Event, 0)
+	for _, data := range []string{`{"tenant":"a","n":1}`, `{"tenant":"b","n":2}`, `{"tenant":"a","n":3}`, `{"n":4}`} {
+		root, err := insaneJSON.DecodeString(data)
+		require.NoError(t, err)
+		events = append(events, &Event{Root: root})
+	}
+
+	groups := headers.Partition(events)
+	require.Len(t, groups, 3, "wrong groups count")
+
+	assert.Equal(t, "a", groups[0].Header.Get("X-Scope-OrgID"), "wrong header")
+	assert.Equal(t, []*Event{events[0], events[2]}, groups[0].Events, "wrong events")
+	assert.Equal(t, "b", groups[1].Header.Get("X-Scope-OrgID"), "wrong header")
+	assert.Equal(t, []*Event{events[1]}, groups[1].Events, "wrong events")
+
+	_, has := groups[2].Header["X-Scope-Orgid"]
+	assert.False(t, has, "empty header is set")
+	assert.Equal(t, "Bearer token", groups[2].Header.Get("Authorization"), "static header isn't set")
+}
+
+func TestHeaderTemplatesStatic(t *testing.T) {
+	headers, err := ParseHeaderTemplates(map[string]string{"Authorization": "Bearer token"})
+	require.NoError(t, err)
+
+	events := []*Event{{Root: insaneJSON.Spawn()}, {Root: insaneJSON.Spawn()}}
+	groups := headers.Partition(events)
+	require.Len(t, groups, 1, "static headers split the batch")
+	assert.Equal(t, events, groups[0].Events, "wrong events")
+
+	_, err = ParseHeaderTemplates(map[string]string{"X-Tenant": "{{.tenant"})
+	assert.Error(t, err, "no error for wrong template")
+}
@@ -0,0 +1,622 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/longpanic"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const (
+	spoolExt = ".spool"
+
+	defaultSpoolSegmentSize = 64 * 1024 * 1024
+)
+
+var (
+	errNoSpoolDir = errors.New("spool dir isn't set, consider setting `spool_dir` in the pipeline settings")
+	errDraining   = errors.New("spooled events wait for the output to commit events passed before the hold, try later")
+)
+
+// holder spools events to the disk instead of passing them to the output while the pipeline is held,
+// e.g. during planned maintenance of the sink. Inputs keep reading since spooled events are committed.
+// On release spooled events are replayed to the output, actions aren't applied to them again.
+//
+// Inputs require commits in order, so spooled events are committed only when
+// all events passed to the output before the hold are committed by it.
+//
+// In the spill mode the pipeline is held automatically while outputs signal backpressure
+// and released once they recover, segments left by the previous run are replayed on the start.
+type holder struct {
+	pipelineName string
+	dir          string
+	logger       *zap.SugaredLogger
+	// isSpillMode holds the pipeline while outputs are saturated instead of pausing inputs
+	isSpillMode bool
+	// maxSize is the total size of segments after which events go to the output even if the pipeline is held, 0 means no limit
+	maxSize int64
+	// isSynced syncs segments to the disk before spooled events are committed, so they survive the crash
+	isSynced    bool
+	segmentSize int64
+	// size is the total size of segments on the disk
+	size   atomic.Int64
+	out    func(event *Event)
+	commit func(event *Event)
+	replay func(data []byte, segment *spoolSegment) bool
+
+	mu   *sync.Mutex
+	held bool
+	// spilling is true if the pipeline is held because of the backpressure rather than by the request
+	spilling bool
+	// releaseOnDrain releases the spilling pipeline once pending events are committed
+	releaseOnDrain bool
+	isFull         bool
+	spool          *spoolSegment
+	spooled        int
+	// outstanding is the number of events passed to the output but not committed yet
+	outstanding int
+	// pending spooled events wait for outstanding events to be committed
+	pending  []*Event
+	flushing bool
+	buf      []byte
+	// replayed segments aren't replayed again by the next release
+	replayed map[string]bool
+}
+
+// spoolSegment is a file of spooled events, it's removed when all replayed events are committed.
+type spoolSegment struct {
+	path    string
+	file    *os.File
+	size    int64
+	removed func(size int64)
+
+	mu       *sync.Mutex
+	pending  int
+	isRead   bool
+	isClosed bool
+}
+
+func newHolder(pipelineName string, dir string, logger *zap.SugaredLogger) *holder {
+	return &holder{
+		pipelineName: pipelineName,
+		dir:          dir,
+		logger:       logger,
+		segmentSize:  defaultSpoolSegmentSize,
+		mu:           &sync.Mutex{},
+		replayed:     make(map[string]bool),
+	}
+}
+
+func (h *holder) isEnabled() bool {
+	return h.dir != ""
+}
+
+// start checks segments left by the previous run, they're replayed on the next release
+// or right away in the spill mode. It should be called when the pipeline is ready to accept replayed events.
+func (h *holder) start() {
+	if h.dir == "" {
+		return
+	}
+
+	h.mu.Lock()
+	segments, err := h.segments()
+	h.mu.Unlock()
+	if err != nil {
+		h.logger.Fatalf("can't read spool dir %s: %s", h.dir, err.Error())
+	}
+
+	for _, path := range segments {
+		if info, err := os.Stat(path); err == nil {
+			h.size.Add(info.Size())
+		}
+	}
+
+	if len(segments) == 0 {
+		return
+	}
+
+	if !h.isSpillMode {
+		h.logger.Warnf("there are %d spool segments left, release the pipeline to replay them", len(segments))
+		return
+	}
+
+	h.logger.Infof("replaying %d spool segments left by the previous run", len(segments))
+	if err := h.release(); err != nil {
+		h.logger.Errorf("can't replay spool segments: %s", err.Error())
+	}
+}
+
+// put passes the event to the output or spools it if the pipeline is held.
+func (h *holder) put(event *Event) {
+	h.mu.Lock()
+	if !h.held || h.checkFull() {
+		h.outstanding++
+		h.mu.Unlock()
+		h.out(event)
+		return
+	}
+
+	h.buf = event.Root.Encode(h.buf[:0])
+	h.buf = append(h.buf, '\n')
+	n, err := h.spool.file.Write(h.buf)
+	h.spool.size += int64(n)
+	h.size.Add(int64(n))
+	if err != nil {
+		// the event isn't lost, it goes to the output
+		h.logger.Errorf("can't write event to spool %s: %s", h.spool.path, err.Error())
+		h.outstanding++
+		h.mu.Unlock()
+		h.out(event)
+		return
+	}
+	h.spooled++
+
+	if h.spool.size >= h.segmentSize {
+		h.rotate()
+	}
+
+	if h.outstanding != 0 || h.flushing {
+		h.pending = append(h.pending, event)
+		h.mu.Unlock()
+		return
+	}
+
+	if h.isSynced {
+		h.pending = append(h.pending, event)
+		h.flush()
+		return
+	}
+	h.mu.Unlock()
+
+	h.commit(event)
+}
+
+// committed is called when the output commits the event, pending spooled events are committed
+// when there are no events in the output anymore.
+func (h *holder) committed() {
+	h.mu.Lock()
+	h.outstanding--
+	if h.outstanding != 0 || h.flushing || len(h.pending) == 0 {
+		h.mu.Unlock()
+		return
+	}
+
+	h.flush()
+}
+
+// flush commits pending spooled events, mu should be locked, it's unlocked on return.
+// If segments are synced, events spooled during the sync are committed by the next round with a single sync.
+func (h *holder) flush() {
+	h.flushing = true
+	for len(h.pending) != 0 && h.outstanding == 0 {
+		pending := h.pending
+		h.pending = nil
+		spool := h.spool
+		h.mu.Unlock()
+
+		if err := h.sync(spool); err != nil {
+			// events are committed by the next flush, so inputs wait for the disk rather than lose events on the crash
+			h.logger.Errorf("can't sync spool %s, spooled events aren't committed: %s", spool.path, err.Error())
+			h.mu.Lock()
+			h.pending = append(pending, h.pending...)
+			break
+		}
+
+		for _, event := range pending {
+			h.commit(event)
+		}
+
+		h.mu.Lock()
+	}
+	h.flushing = false
+
+	if len(h.pending) != 0 {
+		h.mu.Unlock()
+		return
+	}
+
+	if !h.releaseOnDrain {
+		h.mu.Unlock()
+		return
+	}
+	h.releaseOnDrain = false
+	segments, err := h.releaseHeld()
+	h.mu.Unlock()
+
+	if err != nil {
+		h.logger.Errorf("can't release the pipeline after the spill: %s", err.Error())
+		return
+	}
+	h.replaySegments(segments)
+}
+
+// sync syncs the segment to the disk if segments are synced,
+// the segment closed meanwhile is synced on the rotation or the release.
+func (h *holder) sync(spool *spoolSegment) error {
+	if !h.isSynced || spool == nil {
+		return nil
+	}
+
+	err := spool.file.Sync()
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// checkFull returns true if spooled segments exceed the max size, mu should be locked.
+func (h *holder) checkFull() bool {
+	isFull := h.maxSize > 0 && h.size.Load() >= h.maxSize
+	if isFull != h.isFull {
+		h.isFull = isFull
+		if isFull {
+			h.logger.Warnf("spool exceeds the max size %d bytes, events are passed to the output", h.maxSize)
+		} else {
+			h.logger.Infof("spool fits the max size %d bytes again, events are spooled", h.maxSize)
+		}
+	}
+
+	return isFull
+}
+
+// rotate starts the next segment when the current one exceeds the segment size, mu should be locked.
+// The current segment is kept if the next one can't be created.
+func (h *holder) rotate() {
+	next, err := h.openSegment()
+	if err != nil {
+		h.logger.Errorf("can't rotate spool %s: %s", h.spool.path, err.Error())
+		return
+	}
+
+	h.closeSpool()
+	h.spool = next
+}
+
+// closeSpool closes the current segment, it's synced first if segments are synced, mu should be locked.
+func (h *holder) closeSpool() {
+	if h.isSynced {
+		if err := h.spool.file.Sync(); err != nil {
+			h.logger.Errorf("can't sync spool %s: %s", h.spool.path, err.Error())
+		}
+	}
+	if err := h.spool.file.Close(); err != nil {
+		h.logger.Errorf("can't close spool %s: %s", h.spool.path, err.Error())
+	}
+}
+
+func (h *holder) openSegment() (*spoolSegment, error) {
+	path := filepath.Join(h.dir, fmt.Sprintf("%s-%d%s", h.pipelineName, time.Now().UnixNano(), spoolExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spoolSegment{path: path, file: file, mu: &sync.Mutex{}}, nil
+}
+
+func (h *holder) hold() error {
+	if h.dir == "" {
+		return errNoSpoolDir
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// the requested hold isn't released when outputs recover
+	h.spilling = false
+	h.releaseOnDrain = false
+
+	return h.holdHeld()
+}
+
+// holdHeld holds the pipeline, mu should be locked.
+func (h *holder) holdHeld() error {
+	if h.held {
+		return nil
+	}
+
+	if err := os.MkdirAll(h.dir, 0o755); err != nil {
+		return err
+	}
+
+	spool, err := h.openSegment()
+	if err != nil {
+		return err
+	}
+
+	h.spool = spool
+	h.spooled = 0
+	h.held = true
+	h.logger.Infof("pipeline is held, events are spooled to %s", spool.path)
+
+	return nil
+}
+
+// spill holds the pipeline while outputs are saturated, it returns false if events can't be spooled,
+// so the input should be paused instead.
+func (h *holder) spill() bool {
+	if !h.isSpillMode {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.held {
+		// the release is requested, but events wait for the output, so the pipeline keeps spilling
+		if h.spilling {
+			h.releaseOnDrain = false
+		}
+		return true
+	}
+
+	if err := h.holdHeld(); err != nil {
+		h.logger.Errorf("can't spill events to the spool: %s", err.Error())
+		return false
+	}
+	h.spilling = true
+	h.logger.Warnf("outputs are saturated, events are spilled to the spool")
+
+	return true
+}
+
+// unspill releases the pipeline held by `spill`, if spooled events wait for the output
+// the pipeline is released once they are committed.
+func (h *holder) unspill() {
+	h.mu.Lock()
+	if !h.spilling {
+		h.mu.Unlock()
+		return
+	}
+
+	if h.isDraining() {
+		h.releaseOnDrain = true
+		h.mu.Unlock()
+		return
+	}
+
+	segments, err := h.releaseHeld()
+	h.mu.Unlock()
+
+	if err != nil {
+		h.logger.Errorf("can't release the pipeline after the spill: %s", err.Error())
+		return
+	}
+	h.replaySegments(segments)
+}
+
+func (h *holder) isDraining() bool {
+	return len(h.pending) != 0 || h.flushing
+}
+
+// release passes events to the output again and replays all spooled segments in the background.
+func (h *holder) release() error {
+	if h.dir == "" {
+		return errNoSpoolDir
+	}
+
+	h.mu.Lock()
+	if h.held && h.isDraining() {
+		h.mu.Unlock()
+		return errDraining
+	}
+
+	segments, err := h.releaseHeld()
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	h.replaySegments(segments)
+
+	return nil
+}
+
+// releaseHeld releases the pipeline and returns segments to replay, mu should be locked.
+func (h *holder) releaseHeld() ([]string, error) {
+	if h.held {
+		h.closeSpool()
+		h.held = false
+		h.spilling = false
+		h.releaseOnDrain = false
+		h.spool = nil
+		h.logger.Infof("pipeline is released, spooled events=%d", h.spooled)
+	}
+
+	segments, err := h.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range segments {
+		h.replayed[path] = true
+	}
+
+	return segments, nil
+}
+
+func (h *holder) replaySegments(segments []string) {
+	if len(segments) == 0 {
+		return
+	}
+
+	longpanic.GoScoped(longpanic.Scope{Pipeline: h.pipelineName, Plugin: "spool"}, func() {
+		for _, path := range segments {
+			h.replaySegment(path)
+		}
+	})
+}
+
+func (h *holder) replaySegment(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		h.logger.Errorf("can't open spool %s: %s", path, err.Error())
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	segment := &spoolSegment{path: path, mu: &sync.Mutex{}, removed: h.removed}
+	if info, err := file.Stat(); err == nil {
+		segment.size = info.Size()
+	}
+	h.logger.Infof("replaying spool %s", path)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		segment.add()
+		if !h.replay(scanner.Bytes(), segment) {
+			segment.done()
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		h.logger.Errorf("can't read spool %s: %s", path, err.Error())
+		return
+	}
+
+	segment.read()
+}
+
+// segments returns spooled segments which aren't replayed yet, mu should be locked.
+func (h *holder) segments() ([]string, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix := h.pipelineName + "-"
+	segments := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		path := filepath.Join(h.dir, name)
+		if h.spool != nil && h.spool.path == path || h.replayed[path] {
+			continue
+		}
+		segments = append(segments, path)
+	}
+
+	// segments are named by the creation time
+	sort.Slice(segments, func(i, j int) bool {
+		return segmentTime(segments[i]) < segmentTime(segments[j])
+	})
+
+	return segments, nil
+}
+
+func (h *holder) removed(size int64) {
+	h.size.Sub(size)
+}
+
+func (h *holder) status() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return map[string]interface{}{
+		"held":     h.held,
+		"spilling": h.spilling,
+		"spooled":  h.spooled,
+		"pending":  len(h.pending),
+		"size":     h.size.Load(),
+	}
+}
+
+func (h *holder) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.spool != nil {
+		_ = h.spool.file.Close()
+	}
+}
+
+func segmentTime(path string) int64 {
+	name := strings.TrimSuffix(filepath.Base(path), spoolExt)
+	ts, _ := strconv.ParseInt(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	return ts
+}
+
+func (s *spoolSegment) add() {
+	s.mu.Lock()
+	s.pending++
+	s.mu.Unlock()
+}
+
+// done is called when the replayed event is committed.
+func (s *spoolSegment) done() {
+	s.mu.Lock()
+	s.pending--
+	s.tryRemove()
+	s.mu.Unlock()
+}
+
+// read is called when all events of the segment are replayed.
+func (s *spoolSegment) read() {
+	s.mu.Lock()
+	s.isRead = true
+	s.tryRemove()
+	s.mu.Unlock()
+}
+
+func (s *spoolSegment) tryRemove() {
+	if !s.isRead || s.pending != 0 || s.isClosed {
+		return
+	}
+	s.isClosed = true
+	_ = os.Remove(s.path)
+	if s.removed != nil {
+		s.removed(s.size)
+	}
+}
+
+func (h *holder) serveHold(w http.ResponseWriter, r *http.Request) {
+	h.serveAction(w, r, h.hold)
+}
+
+func (h *holder) serveRelease(w http.ResponseWriter, r *http.Request) {
+	h.serveAction(w, r, h.release)
+}
+
+func (h *holder) serveAction(w http.ResponseWriter, r *http.Request, action func() error) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeErr(w, "Use the POST method.")
+		return
+	}
+
+	if err := action(); err != nil {
+		status := http.StatusInternalServerError
+		if err == errNoSpoolDir || err == errDraining {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		writeErr(w, err.Error())
+		return
+	}
+
+	resp, _ := json.Marshal(h.status())
+	_, _ = w.Write(resp)
+}
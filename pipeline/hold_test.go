@@ -0,0 +1,194 @@
+package pipeline
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type collectingOutputStub struct {
+	controller OutputPluginController
+	mu         sync.Mutex
+	messages   []string
+}
+
+func (p *collectingOutputStub) Start(_ AnyConfig, params *OutputPluginParams) {
+	p.controller = params.Controller
+}
+func (p *collectingOutputStub) Stop() {}
+func (p *collectingOutputStub) Out(event *Event) {
+	p.mu.Lock()
+	p.messages = append(p.messages, string([]byte(event.Root.Dig("message").AsString())))
+	p.mu.Unlock()
+	p.controller.Commit(event)
+}
+
+func (p *collectingOutputStub) collected() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.messages...)
+}
+
+func TestHoldAndRelease(t *testing.T) {
+	dir := t.TempDir()
+	output := &collectingOutputStub{}
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour, SpoolDir: dir}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: output}})
+	p.Start()
+	defer p.Stop()
+
+	waitCommitted := func(count int64) {
+		require.Eventually(t, func() bool { return p.totalCommitted.Load() == count }, 5*time.Second, time.Millisecond, "events aren't committed")
+	}
+
+	p.In(1, "test.log", 1, []byte("before\n"), false)
+	waitCommitted(1)
+
+	w := httptest.NewRecorder()
+	p.holder.serveHold(w, httptest.NewRequest("POST", "/pipelines/test/hold", nil))
+	require.Equal(t, 200, w.Code, "wrong status")
+
+	// spooled events are committed, so inputs keep reading
+	for i := int64(0); i < 20; i++ {
+		p.In(1, "test.log", i+2, []byte("held\n"), false)
+	}
+	waitCommitted(21)
+	assert.Equal(t, []string{"before"}, output.collected(), "held events are delivered")
+
+	segments, err := filepath.Glob(filepath.Join(dir, "test-*.spool"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1, "wrong spool segments")
+
+	w = httptest.NewRecorder()
+	p.holder.serveRelease(w, httptest.NewRequest("POST", "/pipelines/test/release", nil))
+	require.Equal(t, 200, w.Code, "wrong status")
+
+	p.In(1, "test.log", 22, []byte("after\n"), false)
+
+	require.Eventually(t, func() bool { return len(output.collected()) == 22 }, 5*time.Second, time.Millisecond, "spooled events aren't replayed")
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(segments[0])
+		return os.IsNotExist(err)
+	}, 5*time.Second, time.Millisecond, "replayed segment isn't removed")
+
+	held := 0
+	for _, message := range output.collected() {
+		if message == "held" {
+			held++
+		}
+	}
+	assert.Equal(t, 20, held, "wrong replayed events")
+}
+
+func TestHoldWithoutSpoolDir(t *testing.T) {
+	h := newHolder("test", "", nil)
+
+	w := httptest.NewRecorder()
+	h.serveHold(w, httptest.NewRequest("POST", "/pipelines/test/hold", nil))
+	assert.Equal(t, 400, w.Code, "wrong status")
+
+	w = httptest.NewRecorder()
+	h.serveHold(w, httptest.NewRequest("GET", "/pipelines/test/hold", nil))
+	assert.Equal(t, 405, w.Code, "wrong status")
+}
+
+func newSpillPipeline(dir string, settings *Settings) (*Pipeline, *collectingOutputStub) {
+	settings.Decoder = "raw"
+	settings.Capacity = 8
+	settings.MaintenanceInterval = time.Hour
+	settings.SpoolDir = dir
+	settings.SpoolOnBackpressure = true
+
+	output := &collectingOutputStub{}
+	p := New("test", settings, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: output}})
+
+	return p, output
+}
+
+func TestSpillOnBackpressure(t *testing.T) {
+	dir := t.TempDir()
+	p, output := newSpillPipeline(dir, &Settings{SpoolSegmentSize: 64})
+	p.Start()
+	defer p.Stop()
+
+	p.Backpressure(true)
+	for i := int64(0); i < 20; i++ {
+		p.In(1, "test.log", i+1, []byte("spilled\n"), false)
+	}
+	require.Eventually(t, func() bool { return p.totalCommitted.Load() == 20 }, 5*time.Second, time.Millisecond, "spilled events aren't committed")
+	assert.Empty(t, output.collected(), "spilled events are delivered")
+	assert.Equal(t, float64(0), testutil.ToFloat64(p.backpressure.paused), "input is paused")
+
+	segments, err := filepath.Glob(filepath.Join(dir, "test-*.spool"))
+	require.NoError(t, err)
+	assert.Greater(t, len(segments), 1, "segments aren't rotated")
+
+	p.Backpressure(false)
+	require.Eventually(t, func() bool { return len(output.collected()) == 20 }, 5*time.Second, time.Millisecond, "spilled events aren't replayed")
+	require.Eventually(t, func() bool {
+		segments, _ := filepath.Glob(filepath.Join(dir, "test-*.spool"))
+		return len(segments) == 0 && p.holder.size.Load() == 0
+	}, 5*time.Second, time.Millisecond, "replayed segments aren't removed")
+}
+
+func TestSpillSynced(t *testing.T) {
+	dir := t.TempDir()
+	p, output := newSpillPipeline(dir, &Settings{SpoolSegmentSize: 64, Delivery: DeliveryAtLeastOnce})
+	p.Start()
+	defer p.Stop()
+	require.True(t, p.holder.isSynced, "segments aren't synced in the at least once delivery mode")
+
+	p.Backpressure(true)
+	for i := int64(0); i < 20; i++ {
+		p.In(1, "test.log", i+1, []byte("spilled\n"), false)
+	}
+	require.Eventually(t, func() bool { return p.totalCommitted.Load() == 20 }, 5*time.Second, time.Millisecond, "synced events aren't committed")
+	assert.Empty(t, output.collected(), "spilled events are delivered")
+
+	p.Backpressure(false)
+	require.Eventually(t, func() bool { return len(output.collected()) == 20 }, 5*time.Second, time.Millisecond, "spilled events aren't replayed")
+}
+
+func TestSpillMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	p, output := newSpillPipeline(dir, &Settings{SpoolMaxSize: 1})
+	p.Start()
+	defer p.Stop()
+
+	p.Backpressure(true)
+	for i := int64(0); i < 5; i++ {
+		p.In(1, "test.log", i+1, []byte("event\n"), false)
+	}
+
+	// the first event fills the spool, so others go to the output
+	require.Eventually(t, func() bool { return len(output.collected()) == 4 }, 5*time.Second, time.Millisecond, "events aren't passed to the output")
+	require.Eventually(t, func() bool { return p.totalCommitted.Load() == 5 }, 5*time.Second, time.Millisecond, "events aren't committed")
+}
+
+func TestSpillReplayOnStart(t *testing.T) {
+	dir := t.TempDir()
+	segment := filepath.Join(dir, "test-1"+spoolExt)
+	require.NoError(t, os.WriteFile(segment, []byte(`{"message":"left"}`+"\n"), 0o644))
+
+	p, output := newSpillPipeline(dir, &Settings{})
+	p.Start()
+	defer p.Stop()
+
+	require.Eventually(t, func() bool { return len(output.collected()) == 1 }, 5*time.Second, time.Millisecond, "left segment isn't replayed")
+	assert.Equal(t, []string{"left"}, output.collected(), "wrong replayed events")
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(segment)
+		return os.IsNotExist(err)
+	}, 5*time.Second, time.Millisecond, "replayed segment isn't removed")
+}
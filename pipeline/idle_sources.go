@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const idleSourceMessage = "source is idle"
+
+// idleSources tracks the last activity of sources to report ones which stop producing events.
+// Sources are identified by names, so a rotated file isn't reported while the new one with the same name is written.
+type idleSources struct {
+	timeout time.Duration
+	labels  map[string]string
+	mu      *sync.RWMutex
+	sources map[string]*sourceActivity
+}
+
+type sourceActivity struct {
+	id       *atomic.Uint64
+	lastSeen *atomic.Int64
+}
+
+type idleSource struct {
+	id       SourceID
+	name     string
+	lastSeen time.Time
+}
+
+func newIdleSources(timeout time.Duration, labels map[string]string) *idleSources {
+	return &idleSources{
+		timeout: timeout,
+		labels:  labels,
+		mu:      &sync.RWMutex{},
+		sources: make(map[string]*sourceActivity),
+	}
+}
+
+func (s *idleSources) isEnabled() bool {
+	return s.timeout > 0
+}
+
+func (s *idleSources) touch(id SourceID, name string, now time.Time) {
+	s.mu.RLock()
+	activity, has := s.sources[name]
+	s.mu.RUnlock()
+
+	if !has {
+		s.mu.Lock()
+		activity, has = s.sources[name]
+		if !has {
+			activity = &sourceActivity{id: atomic.NewUint64(0), lastSeen: atomic.NewInt64(0)}
+			s.sources[name] = activity
+		}
+		s.mu.Unlock()
+	}
+
+	activity.id.Store(uint64(id))
+	activity.lastSeen.Store(now.UnixNano())
+}
+
+// collect returns sources which are idle for the timeout and forgets them,
+// so each source is reported once until it becomes active again.
+func (s *idleSources) collect(now time.Time) []idleSource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var idle []idleSource
+	for name, activity := range s.sources {
+		lastSeen := time.Unix(0, activity.lastSeen.Load())
+		if now.Sub(lastSeen) < s.timeout {
+			continue
+		}
+
+		idle = append(idle, idleSource{
+			id:       SourceID(activity.id.Load()),
+			name:     name,
+			lastSeen: lastSeen,
+		})
+		delete(s.sources, name)
+	}
+
+	return idle
+}
+
+func (s *idleSources) encode(source idleSource) ([]byte, error) {
+	event := make(map[string]interface{}, len(s.labels)+4)
+	// labels can't override the event fields
+	for k, v := range s.labels {
+		event[k] = v
+	}
+	event["message"] = idleSourceMessage
+	event["source_name"] = source.name
+	event["last_event_time"] = source.lastSeen.Format(time.RFC3339)
+	event["idle_timeout"] = s.timeout.String()
+
+	return json.Marshal(event)
+}
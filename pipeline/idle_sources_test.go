@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleSources(t *testing.T) {
+	s := newIdleSources(time.Minute, nil)
+	now := time.Now()
+
+	s.touch(1, "app.log", now)
+	s.touch(2, "app.log", now.Add(time.Second)) // rotated file with the same name
+	s.touch(3, "other.log", now.Add(2*time.Minute))
+
+	assert.Empty(t, s.collect(now.Add(30*time.Second)), "sources aren't idle yet")
+
+	idle := s.collect(now.Add(2 * time.Minute))
+	require.Equal(t, 1, len(idle), "wrong idle sources count")
+	assert.Equal(t, SourceID(2), idle[0].id, "the last source id should be used")
+	assert.Equal(t, "app.log", idle[0].name)
+
+	assert.Empty(t, s.collect(now.Add(2*time.Minute)), "idle source should be reported once")
+
+	s.touch(2, "app.log", now.Add(3*time.Minute))
+	assert.Equal(t, 2, len(s.collect(now.Add(5*time.Minute))), "source should be reported again after it becomes active")
+}
+
+func TestIdleSourceEvent(t *testing.T) {
+	s := newIdleSources(5*time.Minute, map[string]string{"dc": "eu1", "message": "ignored"})
+	lastSeen := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	data, err := s.encode(idleSource{id: 1, name: "app.log", lastSeen: lastSeen})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"dc":"eu1","message":"source is idle","source_name":"app.log","last_event_time":"2021-05-01T10:00:00Z","idle_timeout":"5m0s"}`, string(data))
+}
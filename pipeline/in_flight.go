@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// inFlight caps the number of events of a source which are in the pipeline,
+// so one pathological source can't consume the whole event pool and starve other sources.
+// When the cap is hit, inputs implementing `SourcePauser` are told to pause the source,
+// calls of `In` from other inputs are blocked until events of the source are committed.
+type inFlight struct {
+	max     int
+	mu      *sync.RWMutex
+	sources map[SourceID]*sourceInFlight
+	stopped *atomic.Bool
+}
+
+type sourceInFlight struct {
+	id     SourceID
+	mu     *sync.Mutex
+	cond   *sync.Cond
+	count  int
+	paused bool
+	// isDropped is set by the maintenance, the source is taken from the map again then
+	isDropped bool
+}
+
+func newInFlight(max int) *inFlight {
+	return &inFlight{
+		max:     max,
+		mu:      &sync.RWMutex{},
+		sources: make(map[SourceID]*sourceInFlight),
+		stopped: atomic.NewBool(false),
+	}
+}
+
+func (f *inFlight) isEnabled() bool {
+	return f.max > 0
+}
+
+func (f *inFlight) get(sourceID SourceID) *sourceInFlight {
+	f.mu.RLock()
+	source, has := f.sources[sourceID]
+	f.mu.RUnlock()
+
+	if has {
+		return source
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	source, has = f.sources[sourceID]
+	if !has {
+		mu := &sync.Mutex{}
+		source = &sourceInFlight{id: sourceID, mu: mu, cond: sync.NewCond(mu)}
+		f.sources[sourceID] = source
+	}
+
+	return source
+}
+
+// acquire counts the event of the source, it returns nil if the pipeline is stopped while waiting.
+func (f *inFlight) acquire(sourceID SourceID, pauser SourcePauser) *sourceInFlight {
+	source := f.get(sourceID)
+
+	source.mu.Lock()
+	// the source may be dropped by the maintenance between getting and locking it
+	for source.isDropped {
+		source.mu.Unlock()
+		source = f.get(sourceID)
+		source.mu.Lock()
+	}
+
+	for pauser == nil && source.count >= f.max {
+		if f.stopped.Load() {
+			source.mu.Unlock()
+			return nil
+		}
+		source.cond.Wait()
+	}
+
+	source.count++
+	pause := pauser != nil && !source.paused && source.count >= f.max
+	if pause {
+		source.paused = true
+	}
+	source.mu.Unlock()
+
+	if pause {
+		pauser.PauseSource(sourceID)
+	}
+
+	return source
+}
+
+// release uncounts the committed event, the source is resumed when the half of the cap is free.
+func (f *inFlight) release(source *sourceInFlight, pauser SourcePauser) {
+	source.mu.Lock()
+	source.count--
+	resume := source.paused && source.count <= f.max/2
+	if resume {
+		source.paused = false
+	}
+	source.cond.Signal()
+	source.mu.Unlock()
+
+	if resume {
+		pauser.ResumeSource(source.id)
+	}
+}
+
+// maintenance drops sources without events in the pipeline.
+func (f *inFlight) maintenance() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id, source := range f.sources {
+		source.mu.Lock()
+		if source.count == 0 && !source.paused {
+			source.isDropped = true
+			delete(f.sources, id)
+		}
+		source.mu.Unlock()
+	}
+}
+
+// stop wakes up blocked inputs.
+func (f *inFlight) stop() {
+	f.stopped.Store(true)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, source := range f.sources {
+		source.mu.Lock()
+		source.cond.Broadcast()
+		source.mu.Unlock()
+	}
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+type pauserMock struct {
+	paused map[SourceID]bool
+}
+
+func (p *pauserMock) PauseSource(sourceID SourceID) {
+	p.paused[sourceID] = true
+}
+
+func (p *pauserMock) ResumeSource(sourceID SourceID) {
+	p.paused[sourceID] = false
+}
+
+func TestInFlightPause(t *testing.T) {
+	f := newInFlight(4)
+	pauser := &pauserMock{paused: make(map[SourceID]bool)}
+
+	sources := make([]*sourceInFlight, 0)
+	for i := 0; i < 4; i++ {
+		sources = append(sources, f.acquire(1, pauser))
+	}
+	other := f.acquire(2, pauser)
+
+	assert.True(t, pauser.paused[1], "source should be paused")
+	assert.False(t, pauser.paused[2], "other source shouldn't be paused")
+
+	f.release(sources[0], pauser)
+	assert.True(t, pauser.paused[1], "source should be paused until a half of the cap is free")
+
+	f.release(sources[1], pauser)
+	assert.False(t, pauser.paused[1], "source should be resumed")
+
+	f.release(sources[2], pauser)
+	f.release(sources[3], pauser)
+	f.release(other, pauser)
+	f.maintenance()
+	assert.Equal(t, 0, len(f.sources), "sources without events should be dropped")
+}
+
+func TestInFlightBlock(t *testing.T) {
+	f := newInFlight(2)
+	first := f.acquire(1, nil)
+	f.acquire(1, nil)
+
+	acquired := atomic.NewBool(false)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		f.acquire(1, nil)
+		acquired.Store(true)
+		wg.Done()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, acquired.Load(), "input should be blocked on the source")
+
+	f.release(first, nil)
+	wg.Wait()
+	assert.True(t, acquired.Load(), "input should be unblocked")
+
+	wg.Add(1)
+	go func() {
+		assert.Nil(t, f.acquire(1, nil), "nothing should be acquired after stop")
+		wg.Done()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	f.stop()
+	wg.Wait()
+}
+
+func TestInFlightDroppedSource(t *testing.T) {
+	f := newInFlight(4)
+
+	// the source is dropped by the maintenance after it's got by the input
+	dropped := f.get(1)
+	f.maintenance()
+
+	source := f.acquire(1, nil)
+	assert.NotSame(t, dropped, source, "dropped source shouldn't be counted")
+	assert.Same(t, source, f.sources[1], "source should be tracked again")
+	assert.Equal(t, 1, source.count, "wrong in-flight count")
+}
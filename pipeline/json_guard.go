@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// OversizedJSONDrop drops documents with too many nodes, they're written to the dead letter file if it's set.
+	OversizedJSONDrop = "drop"
+	// OversizedJSONTruncate truncates documents with too many nodes to the `message` field with the raw line.
+	OversizedJSONTruncate = "truncate"
+)
+
+var errTooManyJSONNodes = errors.New("too many json nodes")
+
+// jsonGuard protects processors from pathological documents, e.g. with 10k+ keys,
+// every node of the decoded document stays in the node pool of the event until the pool is released.
+type jsonGuard struct {
+	maxNodes int
+	truncate bool
+
+	oversized prometheus.Counter
+}
+
+func newJSONGuard(pipelineName string, settings *Settings, registry prometheus.Registerer) *jsonGuard {
+	g := &jsonGuard{
+		maxNodes: settings.MaxJSONNodes,
+		truncate: settings.OversizedJSON == OversizedJSONTruncate,
+		oversized: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "oversized_json_total",
+			Help:      "how many json documents exceed the max nodes count",
+		}),
+	}
+
+	registry.MustRegister(g.oversized)
+
+	return g
+}
+
+func (g *jsonGuard) isEnabled() bool {
+	return g.maxNodes > 0
+}
+
+// isOversized checks if the document has more nodes than allowed without decoding it.
+func (g *jsonGuard) isOversized(data []byte) bool {
+	// every node takes at least a byte and a separator, so short documents are skipped
+	if len(data)/2+1 <= g.maxNodes {
+		return false
+	}
+
+	if countJSONNodes(data, g.maxNodes) <= g.maxNodes {
+		return false
+	}
+
+	g.oversized.Inc()
+	return true
+}
+
+// countJSONNodes counts nodes of the document the way the decoder creates them:
+// a node for every container, every field and every value.
+// It stops counting after the limit, the document isn't validated.
+func countJSONNodes(data []byte, limit int) int {
+	count := 1
+	inString := false
+	for i := 0; i < len(data) && count <= limit; i++ {
+		c := data[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[', ',', ':':
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountJSONNodes(t *testing.T) {
+	tests := []struct {
+		json  string
+		count int
+	}{
+		{json: `"a"`, count: 1},
+		{json: `{"a":1}`, count: 3},
+		{json: `{"a":1,"b":[1,2]}`, count: 7},
+		{json: `{"a":"{[,:]}\"{"}`, count: 3},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.count, countJSONNodes([]byte(tt.json), 100), "wrong count for %s", tt.json)
+	}
+
+	assert.Equal(t, 6, countJSONNodes([]byte(`[1,2,3,4,5,6,7,8]`), 5), "counting isn't stopped after the limit")
+}
+
+func TestOversizedJSON(t *testing.T) {
+	huge := `{"a":[` + strings.Repeat("1,", 100) + `1]}`
+
+	for _, mode := range []string{OversizedJSONDrop, OversizedJSONTruncate} {
+		t.Run(mode, func(t *testing.T) {
+			settings := &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, MaxJSONNodes: 50, OversizedJSON: mode}
+			p := New("test", settings, prometheus.NewRegistry())
+			p.SetFixtureMode()
+			p.Start()
+			defer p.Stop()
+
+			events, err := p.RunFixture([][]byte{[]byte(`{"a":1}`), []byte(huge)}, time.Second)
+			require.NoError(t, err)
+
+			if mode == OversizedJSONDrop {
+				require.Len(t, events, 1, "oversized json isn't dropped")
+				assert.Equal(t, float64(1), testutil.ToFloat64(p.skipStats.lines.WithLabelValues("oversized_json")), "wrong skipped lines")
+				return
+			}
+
+			require.Len(t, events, 2, "oversized json isn't truncated")
+			truncated := make(map[string]interface{})
+			require.NoError(t, json.Unmarshal([]byte(events[1]), &truncated))
+			assert.Equal(t, map[string]interface{}{"message": huge}, truncated, "wrong truncated event")
+		})
+	}
+}
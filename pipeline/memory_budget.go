@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+)
+
+// memoryBudget counts bytes of events retained by the pipeline, the count is the size of raw lines.
+// If the limit is set `In` is blocked while retained events exceed it,
+// so a burst of huge events doesn't OOM the process before the event pool is exhausted.
+type memoryBudget struct {
+	limit int64
+	used  atomic.Int64
+
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	stopped bool
+
+	blocked prometheus.Counter
+}
+
+func newMemoryBudget(pipelineName string, limit int64, registry prometheus.Registerer) *memoryBudget {
+	b := &memoryBudget{
+		limit: limit,
+		mu:    &sync.Mutex{},
+		blocked: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "pool_memory_blocked_seconds_total",
+			Help:      "how long the input is blocked since events in the pipeline exceed the memory limit",
+		}),
+	}
+	b.cond = sync.NewCond(b.mu)
+
+	used := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "file_d",
+		Subsystem: "pipeline_" + pipelineName,
+		Name:      "pool_memory_bytes",
+		Help:      "size of events retained by the pipeline",
+	}, func() float64 {
+		return float64(b.used.Load())
+	})
+	limitGauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "file_d",
+		Subsystem: "pipeline_" + pipelineName,
+		Name:      "pool_memory_limit_bytes",
+		Help:      "memory limit of events retained by the pipeline, 0 means no limit",
+	}, func() float64 {
+		return float64(limit)
+	})
+
+	registry.MustRegister(b.blocked, used, limitGauge)
+
+	return b
+}
+
+func (b *memoryBudget) isLimited() bool {
+	return b.limit > 0
+}
+
+// acquire blocks until the event of the size fits the limit.
+// The event bigger than the limit is accepted when nothing is retained, otherwise the pipeline would stall forever.
+func (b *memoryBudget) acquire(size int) {
+	if !b.isLimited() {
+		b.used.Add(int64(size))
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.isExceeded(size) {
+		startedAt := time.Now()
+		for b.isExceeded(size) && !b.stopped {
+			b.cond.Wait()
+		}
+		b.blocked.Add(time.Since(startedAt).Seconds())
+	}
+	b.used.Add(int64(size))
+}
+
+func (b *memoryBudget) isExceeded(size int) bool {
+	used := b.used.Load()
+	return used > 0 && used+int64(size) > b.limit
+}
+
+func (b *memoryBudget) release(size int) {
+	if !b.isLimited() {
+		b.used.Sub(int64(size))
+		return
+	}
+
+	b.mu.Lock()
+	b.used.Sub(int64(size))
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// stop unblocks waiting inputs, the limit isn't applied after it.
+func (b *memoryBudget) stop() {
+	b.mu.Lock()
+	b.stopped = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	b := newMemoryBudget("test", 100, prometheus.NewRegistry())
+
+	b.acquire(60)
+	b.acquire(40)
+	assert.Equal(t, int64(100), b.used.Load(), "wrong used memory")
+
+	acquired := make(chan struct{})
+	go func() {
+		b.acquire(10)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire isn't blocked over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire isn't unblocked after release")
+	}
+
+	b.release(50)
+	assert.Equal(t, int64(0), b.used.Load(), "wrong used memory")
+
+	// the event bigger than the limit is accepted into the empty pipeline
+	b.acquire(1000)
+	assert.Equal(t, int64(1000), b.used.Load(), "wrong used memory")
+
+	unblocked := make(chan struct{})
+	go func() {
+		b.acquire(1)
+		close(unblocked)
+	}()
+
+	b.stop()
+	select {
+	case <-unblocked:
+	case <-time.After(time.Second):
+		t.Fatal("acquire isn't unblocked after stop")
+	}
+}
@@ -10,13 +10,16 @@ import (
 	"go.uber.org/atomic"
 )
 
+// MetricOverflowValue replaces values of the action metric label which exceed the cardinality cap.
+const MetricOverflowValue = "overflow"
+
 type metricsHolder struct {
 	pipelineName       string
 	metricsGen         int // generation is used to drop unused metrics from counters
 	metricsGenTime     time.Time
 	metricsGenInterval time.Duration
 	metrics            []*metrics
-	registry           *prometheus.Registry
+	registry           prometheus.Registerer
 }
 
 type counter struct {
@@ -27,8 +30,9 @@ type counter struct {
 }
 
 type metrics struct {
-	name   string
-	labels []string
+	name     string
+	labels   []string
+	limiters []*labelLimiter
 
 	root *mNode
 
@@ -36,13 +40,68 @@ type metrics struct {
 	previous counter
 }
 
+// labelLimiter caps the number of distinct values of a label during a metrics generation.
+type labelLimiter struct {
+	max    int
+	mu     *sync.RWMutex
+	values map[string]struct{}
+}
+
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{
+		max:    max,
+		mu:     &sync.RWMutex{},
+		values: make(map[string]struct{}),
+	}
+}
+
+// allow returns false if the value is new and the label already has the max number of values.
+func (l *labelLimiter) allow(value string) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	l.mu.RLock()
+	_, has := l.values[value]
+	count := len(l.values)
+	l.mu.RUnlock()
+
+	if has {
+		return true
+	}
+	if count >= l.max {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, has := l.values[value]; has {
+		return true
+	}
+	if len(l.values) >= l.max {
+		return false
+	}
+	// copy value because it may point to the event buffer
+	l.values[string([]byte(value))] = struct{}{}
+
+	return true
+}
+
+func (l *labelLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.values = make(map[string]struct{})
+}
+
 type mNode struct {
 	childs map[string]*mNode
 	mu     *sync.RWMutex
 	self   string
 }
 
-func newMetricsHolder(pipelineName string, registry *prometheus.Registry, metricsGenInterval time.Duration) *metricsHolder {
+func newMetricsHolder(pipelineName string, registry prometheus.Registerer, metricsGenInterval time.Duration) *metricsHolder {
 	return &metricsHolder{
 		pipelineName: pipelineName,
 		registry:     registry,
@@ -52,10 +111,17 @@ func newMetricsHolder(pipelineName string, registry *prometheus.Registry, metric
 	}
 }
 
-func (m *metricsHolder) AddAction(metricName string, metricLabels []string) {
+// AddAction adds metrics of the action, maxLabelValues caps the number of distinct values of each label, 0 means no cap.
+func (m *metricsHolder) AddAction(metricName string, metricLabels []string, maxLabelValues int) {
+	limiters := make([]*labelLimiter, 0, len(metricLabels))
+	for range metricLabels {
+		limiters = append(limiters, newLabelLimiter(maxLabelValues))
+	}
+
 	m.metrics = append(m.metrics, &metrics{
-		name:   metricName,
-		labels: metricLabels,
+		name:     metricName,
+		labels:   metricLabels,
+		limiters: limiters,
 		root: &mNode{
 			childs: make(map[string]*mNode),
 			mu:     &sync.RWMutex{},
@@ -69,12 +135,12 @@ func (m *metricsHolder) start() {
 	m.nextMetricsGen()
 }
 
-func (c *counter) register(registry *prometheus.Registry) {
+func (c *counter) register(registry prometheus.Registerer) {
 	registry.MustRegister(c.count)
 	registry.MustRegister(c.size)
 }
 
-func (c *counter) unregister(registry *prometheus.Registry) {
+func (c *counter) unregister(registry prometheus.Registerer) {
 	registry.Unregister(c.count)
 	registry.Unregister(c.size)
 }
@@ -105,6 +171,10 @@ func (m *metricsHolder) nextMetricsGen() {
 		}
 		cnt.size = prometheus.NewCounterVec(opts, append([]string{"status"}, metrics.labels...))
 
+		for _, limiter := range metrics.limiters {
+			limiter.reset()
+		}
+
 		obsolete := metrics.previous
 
 		metrics.previous = metrics.current
@@ -134,7 +204,7 @@ func (m *metricsHolder) count(event *Event, actionIndex int, eventStatus eventSt
 	valuesBuf = append(valuesBuf, string(eventStatus))
 
 	mn := metrics.root
-	for _, field := range metrics.labels {
+	for i, field := range metrics.labels {
 		val := DefaultFieldValue
 
 		node := event.Root.Dig(field)
@@ -142,6 +212,11 @@ func (m *metricsHolder) count(event *Event, actionIndex int, eventStatus eventSt
 			val = node.AsString()
 		}
 
+		overflowed := !metrics.limiters[i].allow(val)
+		if overflowed {
+			val = MetricOverflowValue
+		}
+
 		mn.mu.RLock()
 		nextMN, has := mn.childs[val]
 		mn.mu.RUnlock()
@@ -150,8 +225,8 @@ func (m *metricsHolder) count(event *Event, actionIndex int, eventStatus eventSt
 			mn.mu.Lock()
 			nextMN, has = mn.childs[val]
 			if !has {
-				key := DefaultFieldValue
-				if node != nil {
+				key := val
+				if node != nil && !overflowed {
 					key = string(node.AsBytes()) // make string from []byte to make map string keys works good
 				}
 
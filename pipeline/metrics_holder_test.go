@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestMetricsHolderLabelCap(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := newMetricsHolder("test", registry, time.Hour)
+	m.AddAction("discards", []string{"service"}, 2)
+	m.start()
+
+	for _, data := range []string{
+		`{"service":"a"}`,
+		`{"service":"b"}`,
+		`{"service":"c"}`,
+		`{"service":"a"}`,
+		`{"service":"d"}`,
+	} {
+		root, err := insaneJSON.DecodeString(data)
+		require.NoError(t, err)
+		m.count(&Event{Root: root}, 0, eventStatusReceived, nil)
+		insaneJSON.Release(root)
+	}
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	counts := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "file_d_pipeline_test_discards_events_count_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "service" {
+					counts[label.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	assert.Equal(t, map[string]float64{"a": 2, "b": 1, MetricOverflowValue: 2}, counts, "wrong counts")
+}
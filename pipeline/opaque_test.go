@@ -0,0 +1,40 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpaqueEvents(t *testing.T) {
+	p := New("test", &Settings{Decoder: "opaque", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetFixtureMode()
+	p.Start()
+	defer p.Stop()
+
+	events, err := p.RunFixture([][]byte{
+		[]byte(`{"a":1}`),
+		[]byte("<13>Oct 17 10:00:00 host app: \"quoted\"\r\n"),
+		{0x00, 0xff, 0x7f},
+	}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"a":1}`, "<13>Oct 17 10:00:00 host app: \"quoted\"", "\x00\xff\x7f"}, events, "payloads are changed")
+}
+
+func TestOpaqueEventEncode(t *testing.T) {
+	event := newEvent()
+	require.NoError(t, event.Root.DecodeString(`{}`))
+	event.SetPayload([]byte("payload"))
+	assert.True(t, event.IsOpaque())
+
+	out, start := event.Encode([]byte("prefix "))
+	assert.Equal(t, "payload", string(out[start:]), "wrong encoded payload")
+	assert.Equal(t, len("payload"), event.Size, "wrong size")
+
+	event.reset(DefaultJSONNodePoolSize)
+	assert.False(t, event.IsOpaque(), "event isn't reset")
+	assert.Empty(t, event.Payload(), "payload isn't reset")
+}
@@ -1,11 +1,14 @@
 package pipeline
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"os"
+	"regexp"
 	"runtime"
 	"strconv"
 	"sync"
@@ -15,6 +18,7 @@ import (
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/longpanic"
 	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
@@ -28,9 +32,19 @@ const (
 	DefaultFieldValue          = "not_set"
 	DefaultStreamName          = StreamName("not_set")
 	DefaultWaitForPanicTimeout = time.Minute
+	DefaultDrainTimeout        = time.Second * 10
+	DefaultReadyTimeout        = time.Second * 30
+
+	// DeliveryBestEffort commits events once the output has handled them, even if some of them are failed.
+	DeliveryBestEffort = "best_effort"
+	// DeliveryAtLeastOnce commits events only after the output confirms their delivery.
+	DeliveryAtLeastOnce = "at_least_once"
 
 	antispamUnbanIterations = 4
 	metricsGenInterval      = time.Hour
+	maxPreviewEvents        = 100
+	procsShrinkTimeout      = time.Second * 30
+	deliveryRetryInterval   = time.Second
 )
 
 type finalizeFn = func(event *Event, notifyInput bool, backEvent bool)
@@ -45,11 +59,22 @@ type InputPluginController interface {
 type ActionPluginController interface {
 	Commit(event *Event)    // commit offset of held event and skip further processing
 	Propagate(event *Event) // throw held event back to pipeline
+	// SetTimeout sets how long the stream of the event waits for the next event while the action is busy,
+	// after that the action gets the timeout event, so it can flush held events on time.
+	// The timeout is checked every 200ms, 0 resets it to the default 30s.
+	SetTimeout(event *Event, timeout time.Duration)
+	// Spawn passes the event derived from the parent, e.g. an element of the batched array, through next actions
+	// and the output. The document is copied, so the root may be a node of the parent.
+	// The parent is committed to the input after all its spawned events are committed.
+	Spawn(parent *Event, root *insaneJSON.Node)
 }
 
 type OutputPluginController interface {
 	Commit(event *Event) // notify input plugin that event is successfully processed and save offsets
 	Error(err string)
+	// Backpressure signals the output can't keep up, e.g. it's retrying requests,
+	// the input is paused until the output calls it with false
+	Backpressure(saturated bool)
 }
 
 type (
@@ -63,40 +88,100 @@ type Pipeline struct {
 
 	decoder          decoder.DecoderType // decoder set in the config
 	suggestedDecoder decoder.DecoderType // decoder suggested by input plugin, it is used when config decoder is set to "auto"
+	csvDelimiter     byte                // delimiter for the csv decoder
 
 	eventPool *eventPool
+	memory    *memoryBudget
+	jsonGuard *jsonGuard
+	eventSize *eventSizeLimit
+	rateLimit *rateLimiter
 	streamer  *streamer
+	balancer  *balancer
+	streamLag *streamLag
+	runQueue  *runQueue
 
-	useSpread      bool
 	disableStreams bool
 	singleProc     bool
-	shouldStop     bool
-
-	input      InputPlugin
-	inputInfo  *InputPluginInfo
-	antispamer *antispamer
-
-	actionInfos  []*ActionPluginStaticInfo
-	Procs        []*processor
+	// decodeErrors is the policy for lines which can't be decoded, see `DecodeErrorsSkip` and others
+	decodeErrors string
+	statsLog     *statsLog
+
+	// ctx is canceled on stop to finish background goroutines, bgWG waits for them.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	bgWG    *sync.WaitGroup
+	stopped chan struct{}
+
+	input        InputPlugin
+	inputInfo    *InputPluginInfo
+	sourcePauser SourcePauser // nil if the input can't pause sources
+	antispamer   *antispamer
+	filter       *eventFilter
+	dedup        *deduper
+	skipStats    *skipStats
+	idleSources  *idleSources
+	commitLag    *commitLag
+	shedder      *shedder
+	deadLetter   *deadLetter
+	inFlight     *inFlight
+	backpressure *backpressure
+	commitHooks  *commitHooks
+	tracer       *tracer // nil if tracing is disabled
+	selfTest     *selfTest
+	holder       *holder
+	// fixture is set if the pipeline runs fixtures instead of the configured input and output
+	fixture *fixtureOutput
+
+	actionInfos []*ActionPluginStaticInfo
+	// procs is the copy-on-write slice of processors, readers load it without locks,
+	// the pool replaces it under procsMu when it grows or shrinks
+	procs        atomic.Value
+	procsMu      sync.Mutex
+	pinPatterns  []*regexp.Regexp
+	procBusyTime *prometheus.CounterVec
+	cpuQuota     *cpuQuota
 	procCount    *atomic.Int32
 	activeProcs  *atomic.Int32
 	actionParams *PluginDefaultParams
 
+	// minProcs is the initial count of processors, the pool doesn't shrink below it
+	minProcs int32
+	// retiringProcs is the count of retired processors which aren't removed yet
+	retiringProcs *atomic.Int32
+	// procsShrinkTimeout is how long most of processors should be idle to shrink the pool
+	procsShrinkTimeout time.Duration
+	// actionTimeouts counts actions exceeding their time budget
+	actionTimeouts *actionTimeouts
+
 	output     OutputPlugin
 	outputInfo *OutputPluginInfo
+	// extraOutputs get events along with the output, fanOut is set if there are any
+	extraOutputs []*OutputPluginInfo
+	fanOut       *fanOut
+	// routes are set if events are passed to outputs by the routing table
+	routes []*Route
 
 	metricsHolder *metricsHolder
 
 	// some debugging shit
-	logger          *zap.SugaredLogger
-	eventLogEnabled bool
-	eventLog        []string
-	eventLogMu      *sync.Mutex
-	inSample        []byte
-	outSample       []byte
-	totalCommitted  atomic.Int64
-	totalSize       atomic.Int64
-	maxSize         int
+	logger         *zap.SugaredLogger
+	journal        *eventJournal
+	inSample       []byte
+	outSample      []byte
+	totalIn        atomic.Int64
+	totalCommitted atomic.Int64
+	totalSize      atomic.Int64
+	rates          *rateMeter
+	maxSize        int
+
+	// draining is set on the stop, lines from the input aren't accepted then
+	draining atomic.Bool
+	// drainTimedOut is set if the stop hasn't waited for all events to be committed
+	drainTimedOut atomic.Bool
+
+	// reporters are plugins reporting readiness, started is set once all plugins are started
+	reporters []ReadyReporter
+	started   atomic.Bool
 }
 
 type Settings struct {
@@ -107,28 +192,232 @@ type Settings struct {
 	AvgLogSize          int
 	StreamField         string
 	IsStrict            bool
+	CSVColumns          []string
+	CSVDelimiter        string
+	MinEventSize        int
+	DropEmpty           bool
+	HeartbeatPatterns   []string
+	EventJournalSize    int
+	StreamAffinity      []StreamAffinity
+	SourceIdleTimeout   time.Duration
+	// AntispamEvents enables synthetic events about sources banned by antispam.
+	AntispamEvents bool
+	// StreamQuota is how many events a processor takes from a stream before switching to the next one, 0 means no limit.
+	StreamQuota int
+	// StreamWeights multiply the stream quota of matching streams.
+	StreamWeights []StreamWeight
+	// Balance is the strategy of choosing streams of events, `stream` by default, see `BalanceStream` and others.
+	Balance string
+	// BalanceField is the field whose values key streams of the `field` balance.
+	BalanceField string
+	// MaxInFlightPerSource caps the number of events of a source in the pipeline, 0 means no cap.
+	MaxInFlightPerSource int
+	// LagTimeField is the event field with the timestamp to measure the commit lag from, the lag from the receive time is measured anyway.
+	LagTimeField string
+	// MetricLabels are attached to all metrics and self-monitoring events of the pipeline.
+	MetricLabels map[string]string
+	// AdaptiveBatching enables the tuning of the output batching, nil means batching is fixed.
+	AdaptiveBatching *AdaptiveBatching
+	// LowLatency makes all outputs send each event as soon as it's processed instead of accumulating batches.
+	LowLatency bool
+	// MaxEventAge is the age after which outputs stop retrying delivery of the event and shed it, 0 means no limit.
+	MaxEventAge time.Duration
+	// DeadLetterFile is the file for shed events and lines which can't be decoded, they're just dropped if it's empty.
+	DeadLetterFile string
+	// DecodeErrors is the policy for lines which can't be decoded by any decoder: `skip`, `dead_letter` or `fatal`.
+	// If it's empty, lines are written to the dead letter file if it's set, otherwise they crash file.d in the strict mode and are skipped if not.
+	DecodeErrors string
+	// SpoolDir is the directory for events spooled while the pipeline is held, holding is disabled if it's empty.
+	SpoolDir string
+	// SpoolOnBackpressure spills events to the spool while outputs signal backpressure instead of pausing inputs.
+	SpoolOnBackpressure bool
+	// SpoolMaxSize is the total size of spool segments after which events aren't spooled anymore, 0 means no limit.
+	SpoolMaxSize int64
+	// SpoolSegmentSize is the size after which the next spool segment is started, 0 means the default size.
+	SpoolSegmentSize int64
+	// DrainTimeout is how long the stop waits for events in the pipeline to be committed, 0 means the stop doesn't wait.
+	DrainTimeout time.Duration
+	// CPUQuota is the number of cores processors may spend in actions, 0 means no limit.
+	CPUQuota float64
+	// Trace tags events to record their path through actions, nil means tracing is disabled.
+	Trace *Trace
+	// MaxProcs limits the count of processors the pool may grow to, 0 means no limit.
+	MaxProcs int
+	// MemoryLimit is the size of raw lines of events in the pipeline after which `In` is blocked, 0 means no limit.
+	MemoryLimit int64
+	// JSONNodePoolSize is the size of the json node pool of the event, pools grown over 4 sizes are released, 0 means the default size.
+	JSONNodePoolSize int
+	// MaxJSONNodes is the max count of nodes of the json document, 0 means no limit.
+	MaxJSONNodes int
+	// OversizedJSON is the way to handle documents with too many nodes, `drop` or `truncate`.
+	OversizedJSON string
+	// MaxEventSize is the max size of the line, it's checked before decoding, 0 means no limit.
+	MaxEventSize int
+	// OversizedEvent is the way to handle lines bigger than the max event size, `truncate`, `discard` or `split`.
+	OversizedEvent string
+	// Delivery is the delivery guarantee of outputs, `best_effort` or `at_least_once`.
+	Delivery string
+	// StatsLog is the format of the periodic stats log: `text`, `json` or `off`, empty means `text`.
+	StatsLog string
+	// StatsInterval is how often the stats are logged, it's rounded up to the maintenance interval, 0 means each maintenance.
+	StatsInterval time.Duration
+	// DedupWindow is the count of keys of committed events lines are checked against to skip duplicates, 0 means no deduplication.
+	DedupWindow int
+	// DedupField is the field whose values key events in the dedup window, events are keyed by the source and the offset if it's empty.
+	DedupField string
+	// DedupFile is the file the dedup window is saved to, so it survives restarts, the window is kept only in memory if it's empty.
+	DedupFile string
+	// MaxCapacity is the max capacity the pipeline can be resized to at runtime, 0 means the capacity can only shrink.
+	MaxCapacity int
+	// WorkStealing makes idle processors of the stream affinity process streams of the shared queue.
+	WorkStealing bool
+	// RateLimitEvents is the max lines per second accepted by the pipeline, 0 means no limit.
+	RateLimitEvents int
+	// RateLimitBytes is the max bytes of lines per second accepted by the pipeline, 0 means no limit.
+	RateLimitBytes int
+	// RateLimitPolicy is the way to handle lines exceeding the rate limit, `block`, `drop` or `sample`.
+	RateLimitPolicy string
+	// RateLimitSample is the one of how many lines exceeding the rate limit is kept by the `sample` policy.
+	RateLimitSample int
+	// ReadyTimeout is how long the start waits for plugins reporting readiness to get ready, 0 means the start doesn't wait.
+	ReadyTimeout time.Duration
+	// AddServiceFields adds `_offset` and `_source_name` service fields to events, outputs may strip them.
+	AddServiceFields bool
+}
+
+// StreamAffinity pins streams with names matching the pattern to the dedicated processors.
+type StreamAffinity struct {
+	Pattern string
+	Procs   int
+}
+
+// Validate checks the settings, so a wrong config may be rejected without creating the pipeline.
+func (s *Settings) Validate() error {
+	switch s.Decoder {
+	case "json", "raw", "cri", "postgres", "auto":
+	case "opaque":
+		// the spool keeps JSON documents one per line, so binary payloads can't be spooled
+		if s.SpoolDir != "" {
+			return fmt.Errorf("opaque events can't be spooled, unset spool_dir")
+		}
+	case "csv", "tsv":
+		if s.CSVDelimiter != "" && len(s.CSVDelimiter) != 1 {
+			return fmt.Errorf("csv delimiter should be a single byte, got %q", s.CSVDelimiter)
+		}
+		if len(s.CSVColumns) == 0 {
+			return fmt.Errorf("csv columns aren't set")
+		}
+	default:
+		return fmt.Errorf("unknown decoder %q", s.Decoder)
+	}
+
+	switch s.Balance {
+	case "", BalanceStream, BalanceRoundRobin, BalanceLeastLoaded:
+	case BalanceField:
+		if s.BalanceField == "" {
+			return fmt.Errorf("balance field isn't set for the %q balance", BalanceField)
+		}
+	default:
+		return fmt.Errorf("unknown balance %q, use %q, %q, %q or %q", s.Balance, BalanceStream, BalanceField, BalanceRoundRobin, BalanceLeastLoaded)
+	}
+
+	for _, affinity := range s.StreamAffinity {
+		if _, err := regexp.Compile(affinity.Pattern); err != nil {
+			return fmt.Errorf("can't compile stream affinity pattern %q: %w", affinity.Pattern, err)
+		}
+		if affinity.Procs <= 0 {
+			return fmt.Errorf("stream affinity procs should be positive for pattern %q", affinity.Pattern)
+		}
+	}
+
+	if s.StreamQuota < 0 {
+		return fmt.Errorf("stream quota should be positive")
+	}
+	for _, w := range s.StreamWeights {
+		if _, err := regexp.Compile(w.Pattern); err != nil {
+			return fmt.Errorf("can't compile stream weight pattern %q: %w", w.Pattern, err)
+		}
+		if w.Weight <= 0 {
+			return fmt.Errorf("stream weight should be positive for pattern %q", w.Pattern)
+		}
+	}
+
+	for _, pattern := range s.HeartbeatPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("can't compile heartbeat pattern %q: %w", pattern, err)
+		}
+	}
+
+	if s.DedupWindow < 0 {
+		return fmt.Errorf("dedup window can't be negative: %d", s.DedupWindow)
+	}
+
+	switch s.DecodeErrors {
+	case "", DecodeErrorsSkip, DecodeErrorsFatal:
+	case DecodeErrorsDeadLetter:
+		if s.DeadLetterFile == "" {
+			return fmt.Errorf("dead letter file isn't set for the %q decode errors", DecodeErrorsDeadLetter)
+		}
+	default:
+		return fmt.Errorf("unknown decode errors %q, use %q, %q or %q", s.DecodeErrors, DecodeErrorsSkip, DecodeErrorsDeadLetter, DecodeErrorsFatal)
+	}
+
+	switch s.StatsLog {
+	case "", StatsLogText, StatsLogJSON, StatsLogOff:
+	default:
+		return fmt.Errorf("unknown stats log %q, use %q, %q or %q", s.StatsLog, StatsLogText, StatsLogJSON, StatsLogOff)
+	}
+
+	return nil
 }
 
 // New creates new pipeline. Consider using `SetupHTTPHandlers` next.
+// The settings should be checked by `Validate` first, wrong settings crash file.d.
 func New(name string, settings *Settings, registry *prometheus.Registry) *Pipeline {
+	if err := settings.Validate(); err != nil {
+		logger.Fatalf("wrong settings of pipeline %q: %s", name, err.Error())
+	}
+
+	var registerer prometheus.Registerer = registry
+	if len(settings.MetricLabels) != 0 {
+		registerer = prometheus.WrapRegistererWith(settings.MetricLabels, registry)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	pipeline := &Pipeline{
 		Name:           name,
 		logger:         logger.Instance.Named(name),
 		settings:       settings,
-		useSpread:      false,
 		disableStreams: false,
+		ctx:            ctx,
+		cancel:         cancel,
+		bgWG:           &sync.WaitGroup{},
+		stopped:        make(chan struct{}),
 		actionParams: &PluginDefaultParams{
 			PipelineName:     name,
 			PipelineSettings: settings,
+			MetricsRegistry:  registerer,
 		},
 
-		metricsHolder: newMetricsHolder(name, registry, metricsGenInterval),
+		metricsHolder: newMetricsHolder(name, registerer, metricsGenInterval),
 		streamer:      newStreamer(),
-		eventPool:     newEventPool(settings.Capacity),
-		antispamer:    newAntispamer(settings.AntispamThreshold, antispamUnbanIterations, settings.MaintenanceInterval),
+		antispamer:    newAntispamer(name, settings.AntispamThreshold, antispamUnbanIterations, settings.MaintenanceInterval, registerer),
+		skipStats:     newSkipStats(name, registerer),
+		idleSources:   newIdleSources(settings.SourceIdleTimeout, settings.MetricLabels),
+		commitLag:     newCommitLag(name, settings.LagTimeField, registerer),
+		inFlight:      newInFlight(settings.MaxInFlightPerSource),
+		selfTest:      newSelfTest(),
+		commitHooks:   newCommitHooks(),
+
+		retiringProcs:      atomic.NewInt32(0),
+		procsShrinkTimeout: procsShrinkTimeout,
+	}
 
-		eventLog:   make([]string, 0, 128),
-		eventLogMu: &sync.Mutex{},
+	if settings.EventJournalSize > 0 {
+		pipeline.journal = newEventJournal(settings.EventJournalSize)
+	}
+	if settings.Trace != nil {
+		pipeline.tracer = newTracer(settings.Trace)
 	}
 
 	switch settings.Decoder {
@@ -140,11 +429,108 @@ func New(name string, settings *Settings, registry *prometheus.Registry) *Pipeli
 		pipeline.decoder = decoder.CRI
 	case "postgres":
 		pipeline.decoder = decoder.POSTGRES
+	case "opaque":
+		pipeline.decoder = decoder.OPAQUE
+	case "csv", "tsv":
+		pipeline.decoder = decoder.CSV
+		pipeline.csvDelimiter = decoder.CSVDefaultDelimiter
+		if settings.Decoder == "tsv" {
+			pipeline.csvDelimiter = decoder.TSVDefaultDelimiter
+		}
+		if settings.CSVDelimiter != "" {
+			pipeline.csvDelimiter = settings.CSVDelimiter[0]
+		}
 	case "auto":
 		pipeline.decoder = decoder.AUTO
+	}
+
+	pipeline.balancer = newBalancer(settings.Balance, settings.BalanceField, pipeline.streamer)
+
+	for _, affinity := range settings.StreamAffinity {
+		pipeline.pinPatterns = append(pipeline.pinPatterns, regexp.MustCompile(affinity.Pattern))
+	}
+
+	weights := make([]*streamWeight, 0, len(settings.StreamWeights))
+	for _, w := range settings.StreamWeights {
+		weights = append(weights, &streamWeight{pattern: regexp.MustCompile(w.Pattern), weight: w.Weight})
+	}
+	if settings.StreamQuota > 0 {
+		pipeline.streamer.fairness = newFairness(settings.StreamQuota, weights)
+	}
+	pipeline.streamLag = newStreamLag(name, registerer)
+	pipeline.runQueue = newRunQueue(name, registerer)
+	pipeline.streamer.stealing = settings.WorkStealing
+
+	pipeline.backpressure = newBackpressure(name, pipeline.logger, registerer)
+	pipeline.memory = newMemoryBudget(name, settings.MemoryLimit, registerer)
+	nodePoolSize := settings.JSONNodePoolSize
+	if nodePoolSize <= 0 {
+		nodePoolSize = DefaultJSONNodePoolSize
+	}
+	pipeline.eventPool = newEventPool(settings.Capacity, settings.MaxCapacity, nodePoolSize, pipeline.memory)
+	registerer.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: "file_d",
+		Subsystem: "pipeline_" + name,
+		Name:      "json_node_pool_released_total",
+		Help:      "how many json node pools of events are exhausted, grown over 4 sizes and released",
+	}, func() float64 {
+		return float64(pipeline.eventPool.releasedPools.Load())
+	}))
+	pipeline.jsonGuard = newJSONGuard(name, settings, registerer)
+	pipeline.eventSize = newEventSizeLimit(name, settings, registerer)
+	pipeline.rateLimit = newRateLimiter(name, settings, registerer)
+
+	pipeline.procBusyTime = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "file_d",
+		Subsystem: "pipeline_" + name,
+		Name:      "processor_busy_seconds_total",
+		Help:      "how long processors are busy with streams, queue is `shared` or a pattern of the stream affinity",
+	}, []string{"queue", "processor"})
+	registerer.MustRegister(pipeline.procBusyTime)
+
+	if settings.CPUQuota > 0 {
+		pipeline.cpuQuota = newCPUQuota(name, settings.CPUQuota, registerer)
+	}
+	pipeline.actionTimeouts = newActionTimeouts(name, registerer)
+
+	filter, err := newEventFilter(name, settings, registerer)
+	if err != nil {
+		pipeline.logger.Fatalf("can't create filter for pipeline %q: %s", name, err.Error())
+	}
+	pipeline.filter = filter
+
+	pipeline.dedup = newDeduper(settings.DedupWindow, settings.DedupField, settings.DedupFile)
+
+	pipeline.deadLetter = newDeadLetter(settings.DeadLetterFile, pipeline.logger)
+	switch settings.DecodeErrors {
+	case "":
+		switch {
+		case pipeline.deadLetter.isEnabled():
+			pipeline.decodeErrors = DecodeErrorsDeadLetter
+		case settings.IsStrict:
+			pipeline.decodeErrors = DecodeErrorsFatal
+		default:
+			pipeline.decodeErrors = DecodeErrorsSkip
+		}
 	default:
-		pipeline.logger.Fatalf("unknown decoder %q for pipeline %q", settings.Decoder, name)
+		pipeline.decodeErrors = settings.DecodeErrors
+	}
+	pipeline.shedder = newShedder(name, pipeline.deadLetter, registerer)
+
+	pipeline.statsLog = newStatsLog(settings.StatsLog, settings.StatsInterval, os.Stdout)
+	pipeline.rates = newRateMeter()
+
+	pipeline.holder = newHolder(name, settings.SpoolDir, pipeline.logger)
+	pipeline.holder.out = pipeline.outputOut
+	pipeline.holder.commit = pipeline.commitSpooled
+	pipeline.holder.replay = pipeline.replaySpooled
+	pipeline.holder.isSpillMode = settings.SpoolOnBackpressure
+	pipeline.holder.maxSize = settings.SpoolMaxSize
+	pipeline.holder.isSynced = settings.Delivery == DeliveryAtLeastOnce
+	if settings.SpoolSegmentSize > 0 {
+		pipeline.holder.segmentSize = settings.SpoolSegmentSize
 	}
+	pipeline.backpressure.holder = pipeline.holder
 
 	return pipeline
 }
@@ -154,6 +540,11 @@ func New(name string, settings *Settings, registry *prometheus.Registry) *Pipeli
 // URL `/pipelines/<pipeline_name>/<plugin_index_in_config>/<plugin_endpoint>`.
 // Input plugin has the index of zero, output plugin has the last index.
 // Actions also have the standard endpoints `/info` and `/sample`.
+// Stats of lines skipped because of decode errors or antispam are available via `/pipelines/<pipeline_name>/skipped`.
+// The last committed events are available via `/pipelines/<pipeline_name>/events?last=100` if the event journal is enabled.
+// Delivery to the output is held and released via `POST /pipelines/<pipeline_name>/hold` and `POST /pipelines/<pipeline_name>/release`.
+// The input is paused and resumed via `POST /pipelines/<pipeline_name>/pause` and `POST /pipelines/<pipeline_name>/resume`.
+// Machine-readable stats of the pipeline are available via `/pipelines/<pipeline_name>/stats.json`.
 func (p *Pipeline) SetupHTTPHandlers(mux *http.ServeMux) {
 	if p.input == nil {
 		p.logger.Panicf("input isn't set for pipeline %q", p.Name)
@@ -164,6 +555,15 @@ func (p *Pipeline) SetupHTTPHandlers(mux *http.ServeMux) {
 
 	prefix := "/pipelines/" + p.Name
 	mux.HandleFunc(prefix, p.servePipeline)
+	mux.HandleFunc(prefix+"/skipped", p.skipStats.serveSkipped)
+	mux.HandleFunc(prefix+"/stats.json", p.serveStatsJSON)
+	mux.HandleFunc(prefix+"/events", p.serveEvents)
+	mux.HandleFunc(prefix+"/trace", p.serveTrace)
+	mux.HandleFunc(prefix+"/hold", p.holder.serveHold)
+	mux.HandleFunc(prefix+"/release", p.holder.serveRelease)
+	mux.HandleFunc(prefix+"/pause", p.servePause)
+	mux.HandleFunc(prefix+"/resume", p.serveResume)
+	mux.HandleFunc(prefix+"/capacity", p.serveCapacity)
 
 	for hName, handler := range p.inputInfo.PluginStaticInfo.Endpoints {
 		mux.HandleFunc(fmt.Sprintf("%s/0/%s", prefix, hName), handler)
@@ -177,9 +577,17 @@ func (p *Pipeline) SetupHTTPHandlers(mux *http.ServeMux) {
 		}
 	}
 
+	mux.HandleFunc(fmt.Sprintf("%s/%d/preview", prefix, len(p.actionInfos)+1), p.serveOutputPreview)
 	for hName, handler := range p.outputInfo.PluginStaticInfo.Endpoints {
 		mux.HandleFunc(fmt.Sprintf("%s/%d/%s", prefix, len(p.actionInfos)+1, hName), handler)
 	}
+
+	// extra outputs follow the output
+	for i, info := range p.extraOutputs {
+		for hName, handler := range info.PluginStaticInfo.Endpoints {
+			mux.HandleFunc(fmt.Sprintf("%s/%d/%s", prefix, len(p.actionInfos)+2+i, hName), handler)
+		}
+	}
 }
 
 func (p *Pipeline) Start() {
@@ -190,21 +598,47 @@ func (p *Pipeline) Start() {
 		p.logger.Panicf("output isn't set for pipeline %q", p.Name)
 	}
 
+	if len(p.extraOutputs) != 0 || p.outputInfo.IsRouted() || p.routes != nil {
+		p.fanOut = newFanOut(p, append([]*OutputPluginInfo{p.outputInfo}, p.extraOutputs...))
+	}
+
+	if err := p.dedup.load(); err != nil {
+		p.logger.Fatalf("can't load dedup window of pipeline %q: %s", p.Name, err.Error())
+	}
+
 	p.initProcs()
 	p.metricsHolder.start()
+	p.commitLag.start(p.outputInfo.Type)
 
 	outputParams := &OutputPluginParams{
 		PluginDefaultParams: p.actionParams,
 		Controller:          p,
 		Logger:              p.logger.Named("output " + p.outputInfo.Type),
+		Chaos:               p.outputInfo.Chaos,
+	}
+	if p.fanOut != nil {
+		p.fanOut.Start(nil, outputParams)
+		deadline := p.readyDeadline()
+		for _, o := range p.fanOut.outputs {
+			p.waitReady("output", o.info.Type, o.plugin, deadline)
+		}
+	} else {
+		p.logger.Infof("starting output plugin %q", p.outputInfo.Type)
+		p.output.Start(p.outputInfo.Config, outputParams)
+		p.waitReady("output", p.outputInfo.Type, p.output, p.readyDeadline())
 	}
-	p.logger.Infof("starting output plugin %q", p.outputInfo.Type)
-	p.output.Start(p.outputInfo.Config, outputParams)
 
-	p.logger.Infof("stating processors, count=%d", len(p.Procs))
-	for _, processor := range p.Procs {
+	procs := p.listProcs()
+	p.logger.Infof("stating processors, count=%d", len(procs))
+	for _, processor := range procs {
 		processor.start(p.actionParams, p.logger)
 	}
+	deadline := p.readyDeadline()
+	for _, processor := range procs {
+		for i, action := range processor.actions {
+			p.waitReady("action", processor.actionInfos[i].Type, action, deadline)
+		}
+	}
 
 	p.logger.Infof("starting input plugin %q", p.inputInfo.Type)
 	inputParams := &InputPluginParams{
@@ -213,18 +647,47 @@ func (p *Pipeline) Start() {
 		Logger:              p.logger.Named("input " + p.inputInfo.Type),
 	}
 	p.input.Start(p.inputInfo.Config, inputParams)
+	p.waitReady("input", p.inputInfo.Type, p.input, p.readyDeadline())
 
 	p.streamer.start()
+	p.holder.start()
 
-	longpanic.Go(p.maintenance)
-	longpanic.Go(p.growProcs)
+	p.goBackground(p.maintenance)
+	p.goBackground(p.growProcs)
+	if p.hasActionTimeouts() {
+		p.goBackground(p.watchActions)
+	}
+	if p.commitHooks.isEnabled() {
+		p.goBackground(func() { p.commitHooks.run(p.ctx) })
+	}
+	p.started.Store(true)
 }
 
+// goBackground runs fn until the pipeline is stopped, `Stop` waits for it to return.
+func (p *Pipeline) goBackground(fn func()) {
+	// done is called once even if fn panics and longpanic restarts it
+	done := &sync.Once{}
+	p.bgWG.Add(1)
+	longpanic.GoScoped(longpanic.Scope{Pipeline: p.Name}, func() {
+		defer done.Do(p.bgWG.Done)
+		fn()
+	})
+}
+
+// Stop stops the pipeline and returns after background goroutines are finished.
 func (p *Pipeline) Stop() {
 	p.logger.Infof("stopping pipeline %q, total committed=%d", p.Name, p.totalCommitted.Load())
 
-	p.logger.Infof("stopping processors count=%d", len(p.Procs))
-	for _, processor := range p.Procs {
+	p.drain()
+	p.cancel()
+	p.bgWG.Wait()
+	p.inFlight.stop()
+	p.backpressure.stop()
+	p.memory.stop()
+
+	procs := p.listProcs()
+	p.logger.Infof("stopping processors count=%d", len(procs))
+	for _, processor := range procs {
 		processor.stop()
 	}
 
@@ -234,40 +697,148 @@ func (p *Pipeline) Stop() {
 	p.input.Stop()
 
 	p.logger.Infof("stopping %q output", p.Name)
-	p.output.Stop()
+	p.deliveryOutput().Stop()
+	if p.commitHooks.isEnabled() {
+		p.commitHooks.flush()
+	}
+	p.holder.stop()
+	p.deadLetter.stop()
+	p.saveDedup()
+
+	close(p.stopped)
+}
+
+// drain stops accepting lines from the input and waits until all events in the pipeline are committed,
+// so events in batches of outputs aren't lost. Lines which aren't accepted are read again after the restart
+// since their offsets aren't committed.
+func (p *Pipeline) drain() {
+	if p.settings.DrainTimeout <= 0 {
+		return
+	}
+
+	p.draining.Store(true)
+	p.logger.Infof("draining pipeline %q, events in the pipeline=%d", p.Name, p.eventPool.inUse())
+
+	deadline := time.Now().Add(p.settings.DrainTimeout)
+	for p.eventPool.inUse() > 0 {
+		if time.Now().After(deadline) {
+			p.logger.Warnf("pipeline %q isn't drained in %s, events aren't committed=%d", p.Name, p.settings.DrainTimeout, p.eventPool.inUse())
+			p.drainTimedOut.Store(true)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.logger.Infof("pipeline %q is drained", p.Name)
+}
+
+// Done returns a channel which is closed when the pipeline is stopped.
+func (p *Pipeline) Done() <-chan struct{} {
+	return p.stopped
+}
 
-	p.shouldStop = true
+// DrainTimedOut returns true if the pipeline has been stopped before all events are committed,
+// they're read again after the restart if the input is able to do it.
+func (p *Pipeline) DrainTimedOut() bool {
+	return p.drainTimedOut.Load()
 }
 
 func (p *Pipeline) SetInput(info *InputPluginInfo) {
 	p.inputInfo = info
 	p.input = info.Plugin.(InputPlugin)
+	p.sourcePauser, _ = info.Plugin.(SourcePauser)
+	p.backpressure.pauser, _ = info.Plugin.(InputPauser)
 }
 
 func (p *Pipeline) GetInput() InputPlugin {
 	return p.input
 }
 
+func (p *Pipeline) adaptiveBatching() *AdaptiveBatching {
+	return p.settings.AdaptiveBatching
+}
+
+func (p *Pipeline) lowLatency() bool {
+	return p.settings.LowLatency || p.outputInfo != nil && p.outputInfo.LowLatency
+}
+
+func (p *Pipeline) batchPartitionField() []string {
+	return parsePartitionField(p.outputInfo)
+}
+
+func (p *Pipeline) delivery() string {
+	return p.settings.Delivery
+}
+
+func (p *Pipeline) maxEventAge() time.Duration {
+	return p.settings.MaxEventAge
+}
+
+func (p *Pipeline) shed(outputType string, events []*Event) {
+	p.logger.Errorf("%d events are shed by %s output since they're older than max event age %s", len(events), outputType, p.settings.MaxEventAge)
+	p.shedder.shed(outputType, events)
+}
+
 func (p *Pipeline) SetOutput(info *OutputPluginInfo) {
 	p.outputInfo = info
 	p.output = info.Plugin.(OutputPlugin)
 }
 
+// AddOutput adds the output which gets all events matching its conditions along with outputs added before.
+// The event is committed to the input when all outputs it's routed to have committed it.
+func (p *Pipeline) AddOutput(info *OutputPluginInfo) {
+	if p.output == nil {
+		p.SetOutput(info)
+		return
+	}
+
+	p.extraOutputs = append(p.extraOutputs, info)
+}
+
 func (p *Pipeline) GetOutput() OutputPlugin {
 	return p.output
 }
 
+// deliveryOutput is the output which events are passed to, it's either the only output or the fan-out to all outputs.
+func (p *Pipeline) deliveryOutput() OutputPlugin {
+	if p.fanOut != nil {
+		return p.fanOut
+	}
+
+	return p.output
+}
+
 func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes []byte, isNewSource bool) uint64 {
-	length := len(bytes)
+	p.backpressure.wait()
+	if p.draining.Load() {
+		return 0
+	}
+	p.totalIn.Inc()
+
+	now := time.Now()
+
+	if p.idleSources.isEnabled() {
+		p.idleSources.touch(sourceID, sourceName, now)
+	}
 
 	// don't process shit
-	isEmpty := length == 0 || (bytes[0] == '\n' && length == 1)
-	isSpam := p.antispamer.isSpam(sourceID, sourceName, isNewSource)
-	if isEmpty || isSpam {
+	if p.isEmptyOrSpam(sourceID, sourceName, bytes, isNewSource) {
 		return 0
 	}
 
-	event := p.eventPool.get()
+	if p.filter.isEnabled() && p.filter.isJunk(bytes) {
+		return 0
+	}
+
+	if p.dedup.isEnabled() && !p.dedup.isFieldKeyed() && p.dedup.isDuplicate(dedupKey{SourceID: sourceID, Offset: offset}) {
+		p.skipStats.add(sourceID, sourceName, skipReasonDuplicate, len(bytes))
+		return 0
+	}
+
+	if p.rateLimit.isEnabled() && !p.rateLimit.allow(len(bytes), p.ctx.Done()) {
+		p.skipStats.add(sourceID, sourceName, skipReasonRateLimit, len(bytes))
+		return 0
+	}
 
 	dec := decoder.NO
 	if p.decoder == decoder.AUTO {
@@ -279,43 +850,120 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 		dec = decoder.JSON
 	}
 
+	if p.eventSize.isEnabled() && p.eventSize.isOversized(bytes) {
+		// truncated lines and parts aren't valid documents of the decoder anymore, so they're passed as raw lines
+		switch p.eventSize.policy {
+		case OversizedEventDiscard:
+			p.discardOversizedEvent(offset, sourceID, sourceName, bytes)
+			return 0
+		case OversizedEventSplit:
+			seqID := uint64(0)
+			for _, part := range p.eventSize.split(bytes) {
+				if id := p.decodeAndStream(decoder.RAW, sourceID, sourceName, offset, part, now); id != 0 {
+					seqID = id
+				}
+			}
+			return seqID
+		default:
+			bytes = p.eventSize.truncate(bytes)
+			dec = decoder.RAW
+		}
+	}
+
+	return p.decodeAndStream(dec, sourceID, sourceName, offset, bytes, now)
+}
+
+// decodeAndStream decodes the line and passes the event to the stream, the sequence id of the event is returned,
+// it's 0 if the event is skipped.
+func (p *Pipeline) decodeAndStream(dec decoder.DecoderType, sourceID SourceID, sourceName string, offset int64, bytes []byte, now time.Time) uint64 {
+	event := p.eventPool.getSized(len(bytes))
+
 	switch dec {
 	case decoder.JSON:
+		if p.jsonGuard.isEnabled() && p.jsonGuard.isOversized(bytes) {
+			if !p.jsonGuard.truncate {
+				p.dropOversizedJSON(event, offset, sourceID, sourceName, bytes)
+				return 0
+			}
+			_ = event.Root.DecodeString("{}")
+			event.Root.AddFieldNoAlloc(event.Root, "message").MutateToBytesCopy(event.Root, trimLineEnd(bytes))
+			break
+		}
 		err := event.parseJSON(bytes)
 		if err != nil {
-			if p.settings.IsStrict {
-				p.logger.Fatalf("wrong json format offset=%d, length=%d, err=%s, source=%d:%s, json=%s", offset, length, err.Error(), sourceID, sourceName, bytes)
-			} else {
-				p.logger.Errorf("wrong json format offset=%d, length=%d, err=%s, source=%d:%s, json=%s", offset, length, err.Error(), sourceID, sourceName, bytes)
-			}
+			p.decodeError(event, "json", err, offset, sourceID, sourceName, bytes)
 			return 0
 		}
 	case decoder.RAW:
 		_ = event.Root.DecodeString("{}")
-		event.Root.AddFieldNoAlloc(event.Root, "message").MutateToBytesCopy(event.Root, bytes[:len(bytes)-1])
+		event.Root.AddFieldNoAlloc(event.Root, "message").MutateToBytesCopy(event.Root, trimLineEnd(bytes))
+	case decoder.OPAQUE:
+		_ = event.Root.DecodeString("{}")
+		event.SetPayload(trimLineEnd(bytes))
 	case decoder.CRI:
 		_ = event.Root.DecodeString("{}")
 		err := decoder.DecodeCRI(event.Root, bytes)
 		if err != nil {
-			p.logger.Fatalf("wrong cri format offset=%d, length=%d, err=%s, source=%d:%s, cri=%s", offset, length, err.Error(), sourceID, sourceName, bytes)
+			p.decodeError(event, "cri", err, offset, sourceID, sourceName, bytes)
 			return 0
 		}
 	case decoder.POSTGRES:
 		_ = event.Root.DecodeString("{}")
 		err := decoder.DecodePostgres(event.Root, bytes)
 		if err != nil {
-			p.logger.Fatalf("wrong postgres format offset=%d, length=%d, err=%s, source=%d:%s, cri=%s", offset, length, err.Error(), sourceID, sourceName, bytes)
+			p.decodeError(event, "postgres", err, offset, sourceID, sourceName, bytes)
+			return 0
+		}
+	case decoder.CSV:
+		_ = event.Root.DecodeString("{}")
+		err := decoder.DecodeCSV(event.Root, bytes, p.settings.CSVColumns, p.csvDelimiter)
+		if err != nil {
+			p.decodeError(event, "csv", err, offset, sourceID, sourceName, bytes)
 			return 0
 		}
 	default:
 		p.logger.Panicf("unknown decoder %d for pipeline %q", p.decoder, p.Name)
 	}
 
+	if p.filter.isEnabled() && p.filter.isEmpty(event.Root) {
+		p.eventPool.back(event)
+		return 0
+	}
+
+	if p.dedup.isEnabled() && p.dedup.isFieldKeyed() {
+		event.dedupValue, event.hasDedupValue = p.dedup.fieldKey(event)
+		if event.hasDedupValue && p.dedup.isDuplicate(dedupKey{Value: event.dedupValue}) {
+			p.skipStats.add(sourceID, sourceName, skipReasonDuplicate, len(bytes))
+			p.eventPool.back(event)
+			return 0
+		}
+	}
+
 	event.Offset = offset
 	event.SourceID = sourceID
 	event.SourceName = sourceName
 	event.streamName = DefaultStreamName
 	event.Size = len(bytes)
+	event.receivedAt = now
+
+	if p.settings.AddServiceFields {
+		addServiceFields(event)
+	}
+
+	if p.tracer != nil {
+		p.tracer.tag(event)
+	}
+
+	if p.inFlight.isEnabled() {
+		event.inFlight = p.inFlight.acquire(sourceID, p.sourcePauser)
+		if event.inFlight == nil {
+			// the offset isn't committed, so the line is read again after the restart
+			p.logger.Warnf("pipeline is stopped while the input is blocked by the in-flight cap, the line isn't processed: offset=%d, source=%d:%s", offset, sourceID, sourceName)
+			p.skipStats.add(sourceID, sourceName, skipReasonStopped, len(bytes))
+			p.eventPool.back(event)
+			return 0
+		}
+	}
 
 	if len(p.inSample) == 0 {
 		p.inSample = event.Root.Encode(p.inSample)
@@ -324,24 +972,134 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 	return p.streamEvent(event)
 }
 
-func (p *Pipeline) streamEvent(event *Event) uint64 {
-	// spread events across all processors
-	if p.useSpread {
-		event.SourceID = SourceID(event.SeqID % uint64(p.procCount.Load()))
+// isEmptyOrSpam checks the event at the input boundary.
+// The event is empty if it's shorter than the minimum size, line endings aren't counted.
+// Lines of only whitespaces are empty as well if `drop_empty` is set.
+func (p *Pipeline) isEmptyOrSpam(sourceID SourceID, sourceName string, bytes []byte, isNewSource bool) bool {
+	data := trimLineEnd(bytes)
+	isEmpty := len(data) == 0 || len(data) < p.settings.MinEventSize || p.settings.DropEmpty && isBlank(data)
+	isSpam := p.antispamer.isSpam(sourceID, sourceName, isNewSource)
+	if isSpam {
+		p.skipStats.add(sourceID, sourceName, skipReasonAntispam, len(bytes))
+	}
+
+	return isEmpty || isSpam
+}
+
+// decodeError handles the line which can't be decoded according to the decode errors policy,
+// the policy is the same for all decoders.
+func (p *Pipeline) decodeError(event *Event, format string, err error, offset int64, sourceID SourceID, sourceName string, bytes []byte) {
+	length := len(bytes)
+	switch p.decodeErrors {
+	case DecodeErrorsDeadLetter:
+		p.logger.Errorf("wrong %s format offset=%d, length=%d, err=%s, source=%d:%s, the line is written to the dead letter file", format, offset, length, err.Error(), sourceID, sourceName)
+		p.deadLetter.writeUndecodable(format, err, sourceID, sourceName, offset, bytes)
+	case DecodeErrorsFatal:
+		p.logger.Fatalf("wrong %s format offset=%d, length=%d, err=%s, source=%d:%s, %s=%s", format, offset, length, err.Error(), sourceID, sourceName, format, bytes)
+	default:
+		p.logger.Errorf("wrong %s format offset=%d, length=%d, err=%s, source=%d:%s, %s=%s", format, offset, length, err.Error(), sourceID, sourceName, format, bytes)
 	}
 
-	if !p.disableStreams {
+	p.skipStats.add(sourceID, sourceName, skipReasonDecodeError, length)
+	p.eventPool.back(event)
+}
+
+func (p *Pipeline) dropOversizedJSON(event *Event, offset int64, sourceID SourceID, sourceName string, bytes []byte) {
+	if p.deadLetter.isEnabled() {
+		p.deadLetter.writeUndecodable("json", errTooManyJSONNodes, sourceID, sourceName, offset, bytes)
+	}
+	p.logger.Errorf("json has more than %d nodes offset=%d, length=%d, source=%d:%s, it's dropped", p.jsonGuard.maxNodes, offset, len(bytes), sourceID, sourceName)
+
+	p.skipStats.add(sourceID, sourceName, skipReasonOversizedJSON, len(bytes))
+	p.eventPool.back(event)
+}
+
+// discardOversizedEvent drops the line which is bigger than the max event size.
+func (p *Pipeline) discardOversizedEvent(offset int64, sourceID SourceID, sourceName string, bytes []byte) {
+	if p.deadLetter.isEnabled() {
+		p.deadLetter.writeUndecodable("raw", errEventTooBig, sourceID, sourceName, offset, bytes)
+	}
+	p.logger.Errorf("event is bigger than %d bytes offset=%d, length=%d, source=%d:%s, it's discarded", p.eventSize.maxSize, offset, len(bytes), sourceID, sourceName)
+
+	p.skipStats.add(sourceID, sourceName, skipReasonOversizedEvent, len(bytes))
+}
+
+// trimLineEnd removes LF or CRLF line ending.
+func trimLineEnd(data []byte) []byte {
+	l := len(data)
+	if l > 0 && data[l-1] == '\n' {
+		l--
+	}
+	if l > 0 && data[l-1] == '\r' {
+		l--
+	}
+
+	return data[:l]
+}
+
+func (p *Pipeline) streamEvent(event *Event) uint64 {
+	if !p.disableStreams && p.balancer.isStreamFieldUsed() {
 		node := event.Root.Dig(p.settings.StreamField)
 		if node != nil {
-			event.streamName = StreamName(node.AsString())
+			event.streamName = streamNameOf(node)
 		}
 	}
 
-	return p.streamer.putEvent(event.SourceID, event.streamName, event)
+	return p.streamer.putEvent(p.balancer.streamID(event), event.streamName, event)
+}
+
+// streamNameOf converts the value of the stream field to the stream name.
+// Numbers are formatted canonically, so `1` and `1.0` get into the same stream,
+// objects, arrays and nulls can't be a stream name, so such events get into the default stream
+// instead of the stream with the empty name.
+func streamNameOf(node *insaneJSON.Node) StreamName {
+	switch {
+	case node.IsString(), node.IsTrue(), node.IsFalse():
+		return StreamName(node.AsString())
+	case node.IsNumber():
+		value := node.AsString()
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return StreamName(value)
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return StreamName(value)
+		}
+		return StreamName(strconv.FormatFloat(number, 'f', -1, 64))
+	default:
+		return DefaultStreamName
+	}
 }
 
 func (p *Pipeline) Commit(event *Event) {
+	// the id is read before the event is back to the pool
+	selfTestID := event.selfTestID
 	p.finalize(event, true, true)
+
+	if p.holder.isEnabled() {
+		p.holder.committed()
+	}
+
+	if selfTestID != 0 {
+		p.selfTest.commit(selfTestID)
+	}
+}
+
+// discard finalizes the event which isn't routed to any output.
+func (p *Pipeline) discard(event *Event) {
+	if event.spool != nil {
+		event.spool.done()
+		event.spool = nil
+	}
+	p.finalize(event, false, true)
+
+	if p.holder.isEnabled() {
+		p.holder.committed()
+	}
+}
+
+func (p *Pipeline) Backpressure(saturated bool) {
+	p.backpressure.set(p, p.outputInfo.Type, saturated)
 }
 
 func (p *Pipeline) Error(err string) {
@@ -357,11 +1115,26 @@ func (p *Pipeline) finalize(event *Event, notifyInput bool, backEvent bool) {
 		return
 	}
 
+	// the parent of spawned events is finalized by the last of them
+	if backEvent && event.pendingSpawned.Load() != 0 {
+		p.releaseSpawned(event, notifyInput)
+		return
+	}
+
 	if notifyInput {
-		p.input.Commit(event)
+		if !event.synthetic {
+			p.input.Commit(event)
+			if p.commitHooks.isEnabled() {
+				p.commitHooks.add(event)
+			}
+			if p.dedup.isEnabled() {
+				p.dedup.commit(event)
+			}
+		}
 
 		p.totalCommitted.Inc()
 		p.totalSize.Add(int64(event.Size))
+		p.commitLag.observe(event, time.Now())
 
 		if len(p.outSample) == 0 && rand.Int()&1 == 1 {
 			p.outSample = event.Root.Encode(p.outSample)
@@ -370,6 +1143,19 @@ func (p *Pipeline) finalize(event *Event, notifyInput bool, backEvent bool) {
 		if event.Size > p.maxSize {
 			p.maxSize = event.Size
 		}
+
+		if event.spool != nil {
+			event.spool.done()
+			event.spool = nil
+		}
+	}
+
+	if backEvent && event.trace != nil {
+		verdict := traceVerdictDiscarded
+		if notifyInput {
+			verdict = traceVerdictCommitted
+		}
+		p.tracer.finish(event, verdict)
 	}
 
 	// todo: avoid shitty event.stream.commit(event)
@@ -379,18 +1165,31 @@ func (p *Pipeline) finalize(event *Event, notifyInput bool, backEvent bool) {
 		return
 	}
 
-	if p.eventLogEnabled {
-		p.eventLogMu.Lock()
-		p.eventLog = append(p.eventLog, event.Root.EncodeToString())
-		p.eventLogMu.Unlock()
+	if p.journal != nil {
+		p.journal.add(string(event.appendBody(nil)))
+	}
+
+	if event.inFlight != nil {
+		p.inFlight.release(event.inFlight, p.sourcePauser)
+		event.inFlight = nil
 	}
 
+	parent := event.parent
 	p.eventPool.back(event)
+
+	if parent != nil {
+		p.releaseSpawned(parent, notifyInput)
+	}
+}
+
+// AddCommitObserver subscribes the observer to events committed to the input, it should be called before the start.
+func (p *Pipeline) AddCommitObserver(observer CommitObserver) {
+	p.commitHooks.observers = append(p.commitHooks.observers, observer)
 }
 
 func (p *Pipeline) AddAction(info *ActionPluginStaticInfo) {
 	p.actionInfos = append(p.actionInfos, info)
-	p.metricsHolder.AddAction(info.MetricName, info.MetricLabels)
+	p.metricsHolder.AddAction(info.MetricName, info.MetricLabels, info.MetricMaxLabelValues)
 }
 
 func (p *Pipeline) initProcs() {
@@ -399,25 +1198,93 @@ func (p *Pipeline) initProcs() {
 	if p.singleProc {
 		procCount = 1
 	}
-	p.logger.Infof("starting pipeline %q: procs=%d", p.Name, procCount)
 
+	// pinned streams need their own processors, so the shared queue should have at least one
+	pinnedCount := 0
+	if !p.singleProc && len(p.pinPatterns) > 0 {
+		p.streamer.pinStreams(p.pinPatterns)
+		for _, affinity := range p.settings.StreamAffinity {
+			pinnedCount += affinity.Procs
+		}
+		if procCount <= pinnedCount {
+			procCount = pinnedCount + 1
+		}
+	}
+	p.logger.Infof("starting pipeline %q: procs=%d, pinned procs=%d", p.Name, procCount, pinnedCount)
+
+	p.minProcs = int32(procCount)
 	p.procCount = atomic.NewInt32(int32(procCount))
+	p.balancer.procCount = p.procCount
 	p.activeProcs = atomic.NewInt32(0)
 
-	p.Procs = make([]*processor, 0, procCount)
-	for i := 0; i < procCount; i++ {
-		p.Procs = append(p.Procs, p.newProc())
+	procs := make([]*processor, 0, procCount)
+	if pinnedCount > 0 {
+		for i, affinity := range p.settings.StreamAffinity {
+			for j := 0; j < affinity.Procs; j++ {
+				procs = append(procs, p.newProc(i+1))
+			}
+		}
+	}
+	for i := pinnedCount; i < procCount; i++ {
+		procs = append(procs, p.newProc(0))
+	}
+	p.addProcs(procs)
+}
+
+// listProcs returns the snapshot of processors, it isn't changed when the pool grows or shrinks,
+// so it's safe to iterate it concurrently with the pool management.
+func (p *Pipeline) listProcs() []*processor {
+	procs, _ := p.procs.Load().([]*processor)
+	return procs
+}
+
+// addProcs publishes the copy of processors with the new ones.
+func (p *Pipeline) addProcs(added []*processor) {
+	p.procsMu.Lock()
+	defer p.procsMu.Unlock()
+
+	current := p.listProcs()
+	procs := make([]*processor, 0, len(current)+len(added))
+	procs = append(procs, current...)
+	procs = append(procs, added...)
+	p.procs.Store(procs)
+}
+
+// queueName returns `shared` for the shared queue or the pattern of the stream affinity.
+func (p *Pipeline) queueName(queue int) string {
+	if queue == 0 {
+		return "shared"
 	}
+
+	return p.pinPatterns[queue-1].String()
 }
 
-func (p *Pipeline) newProc() *processor {
+func (p *Pipeline) newProc(queue int) *processor {
 	proc := NewProcessor(
 		p.metricsHolder,
 		p.activeProcs,
-		p.output,
+		p.deliveryOutput(),
 		p.streamer,
+		queue,
 		p.finalize,
 	)
+	proc.spawn = p.spawn
+	proc.logger = p.logger
+
+	queueName := p.queueName(queue)
+	proc.busyTime = p.procBusyTime.WithLabelValues(queueName, strconv.Itoa(proc.id))
+	if queue > 0 && p.streamer.stealing {
+		proc.stolen = p.runQueue.stolen.WithLabelValues(queueName, strconv.Itoa(proc.id))
+	}
+	proc.cpuQuota = p.cpuQuota
+	proc.timeouts = p.actionTimeouts
+	// the fan-out sanitizes events per output
+	if p.fanOut == nil {
+		proc.controlChars = newControlChars(p.outputInfo.ControlChars)
+		proc.stripServiceFields = p.outputInfo.ServiceFields == ServiceFieldsStrip
+	}
+	proc.holder = p.holder
+
 	for j, info := range p.actionInfos {
 		plugin, _ := info.Factory()
 		proc.AddActionPlugin(&ActionPluginInfo{
@@ -434,18 +1301,36 @@ func (p *Pipeline) newProc() *processor {
 
 func (p *Pipeline) growProcs() {
 	interval := time.Millisecond * 100
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	t := time.Now()
+	idleSince := time.Now()
 	for {
-		time.Sleep(interval)
-		if p.shouldStop {
+		select {
+		case <-p.ctx.Done():
 			return
+		case <-ticker.C:
+		}
+		p.removeRetiredProcs()
+
+		now := time.Now()
+		count, active := p.procCount.Load(), p.activeProcs.Load()
+		if count != active {
+			t = now
 		}
-		if p.procCount.Load() != p.activeProcs.Load() {
-			t = time.Now()
+		// hysteresis: the pool grows when all processors are busy and shrinks when most of them are idle for a long time
+		if active > count/4 {
+			idleSince = now
 		}
 
-		if time.Now().Sub(t) > interval {
+		if now.Sub(t) > interval {
 			p.expandProcs()
+			idleSince = now
+		}
+		if now.Sub(idleSince) > p.procsShrinkTimeout {
+			p.shrinkProcs()
+			idleSince = now
 		}
 	}
 }
@@ -457,51 +1342,114 @@ func (p *Pipeline) expandProcs() {
 
 	from := p.procCount.Load()
 	to := from * 2
+	if p.settings.MaxProcs > 0 && to > int32(p.settings.MaxProcs) {
+		to = int32(p.settings.MaxProcs)
+	}
+	if to <= from {
+		return
+	}
 	p.logger.Infof("processors count expanded from %d to %d", from, to)
 	if to > 10000 {
 		p.logger.Warnf("too many processors: %d", to)
 	}
 
+	procs := make([]*processor, 0, to-from)
 	for x := 0; x < int(to-from); x++ {
-		proc := p.newProc()
-		p.Procs = append(p.Procs, proc)
+		procs = append(procs, p.newProc(0))
+	}
+	p.addProcs(procs)
+	for _, proc := range procs {
 		proc.start(p.actionParams, p.logger)
 	}
 
 	p.procCount.Swap(to)
 }
 
-func (p *Pipeline) maintenance() {
-	lastCommitted := int64(0)
-	lastSize := int64(0)
-	interval := p.settings.MaintenanceInterval
-	for {
-		time.Sleep(interval)
-		if p.shouldStop {
-			return
+// shrinkProcs retires a half of shared processors added by the expanding,
+// they're removed once they exit.
+func (p *Pipeline) shrinkProcs() {
+	if p.singleProc {
+		return
+	}
+
+	from := p.procCount.Load() - p.retiringProcs.Load()
+	to := from / 2
+	if to < p.minProcs {
+		to = p.minProcs
+	}
+	if to >= from {
+		return
+	}
+	p.logger.Infof("processors count shrunk from %d to %d", from, to)
+
+	retiring := from - to
+	procs := p.listProcs()
+	for i := len(procs) - 1; i >= 0 && retiring > 0; i-- {
+		proc := procs[i]
+		if proc.queue != 0 || proc.retired.Load() {
+			continue
 		}
+		proc.retired.Store(true)
+		retiring--
+	}
+	p.retiringProcs.Add(from - to - retiring)
+	p.streamer.wakeProcessors(0)
+}
 
-		p.antispamer.maintenance()
-		p.metricsHolder.maintenance()
+// removeRetiredProcs stops actions of exited processors and removes them from the pool.
+func (p *Pipeline) removeRetiredProcs() {
+	if p.retiringProcs.Load() == 0 {
+		return
+	}
+
+	p.procsMu.Lock()
+	defer p.procsMu.Unlock()
 
-		totalCommitted := p.totalCommitted.Load()
-		deltaCommitted := int(totalCommitted - lastCommitted)
+	current := p.listProcs()
+	procs := make([]*processor, 0, len(current))
+	removed := int32(0)
+	for _, proc := range current {
+		select {
+		case <-proc.exited:
+			proc.stopActions()
+			removed++
+		default:
+			procs = append(procs, proc)
+		}
+	}
+	if removed == 0 {
+		return
+	}
 
-		totalSize := p.totalSize.Load()
-		deltaSize := int(totalSize - lastSize)
+	p.procs.Store(procs)
+	p.retiringProcs.Sub(removed)
+	p.procCount.Sub(removed)
+}
 
-		rate := int(float64(deltaCommitted) * float64(time.Second) / float64(interval))
-		rateMb := float64(deltaSize) * float64(time.Second) / float64(interval) / 1024 / 1024
+func (p *Pipeline) maintenance() {
+	interval := p.settings.MaintenanceInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		tc := totalCommitted
-		if totalCommitted == 0 {
-			tc = 1
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-		p.logger.Infof("%q pipeline stats interval=%ds, active procs=%d/%d, queue=%d/%d, out=%d|%.1fMb, rate=%d/s|%.1fMb/s, total=%d|%.1fMb, avg size=%d, max size=%d", p.Name, interval/time.Second, p.activeProcs.Load(), p.procCount.Load(), p.settings.Capacity-p.eventPool.freeEventsCount, p.settings.Capacity, deltaCommitted, float64(deltaSize)/1024.0/1024.0, rate, rateMb, totalCommitted, float64(totalSize)/1024.0/1024.0, totalSize/tc, p.maxSize)
+		p.emitAntispamBans()
+		p.antispamer.maintenance()
+		p.inFlight.maintenance()
+		p.skipStats.maintenance(time.Now())
+		p.metricsHolder.maintenance()
+		p.emitIdleSources(time.Now())
+		p.streamLag.update(p.streamer, time.Now())
+		p.runQueue.update(p.streamer, p.queueName)
 
-		lastCommitted = totalCommitted
-		lastSize = totalSize
+		p.rates.update(time.Now(), p.totalIn.Load(), p.totalCommitted.Load(), p.totalSize.Load())
+		p.logStats(time.Now())
+		p.saveDedup()
 
 		if len(p.inSample) > 0 {
 			p.logger.Infof("%q pipeline input event sample: %s", p.Name, p.inSample)
@@ -515,8 +1463,125 @@ func (p *Pipeline) maintenance() {
 	}
 }
 
+// logStats logs the stats of the pipeline if the stats interval is over.
+func (p *Pipeline) logStats(now time.Time) {
+	if !p.statsLog.isDue(now) {
+		return
+	}
+
+	stats := p.statsLog.collect(now, p.totalCommitted.Load(), p.totalSize.Load())
+	stats.Pipeline = p.Name
+	stats.ActiveProcs = p.activeProcs.Load()
+	stats.Procs = p.procCount.Load()
+	stats.Queue = int(p.eventPool.inUse())
+	stats.Capacity = int(p.eventPool.capacity.Load())
+	stats.MaxSize = p.maxSize
+
+	p.statsLog.write(p.logger, stats)
+}
+
+// saveDedup saves the dedup window, keys committed after the last save are lost on the crash.
+func (p *Pipeline) saveDedup() {
+	if err := p.dedup.save(); err != nil {
+		p.logger.Errorf("can't save dedup window of pipeline %q: %s", p.Name, err.Error())
+	}
+}
+
+// emitIdleSources passes events about sources which stop producing events through the pipeline,
+// so outputs can alert on silently dying applications.
+func (p *Pipeline) emitIdleSources(now time.Time) {
+	if !p.idleSources.isEnabled() {
+		return
+	}
+
+	for _, source := range p.idleSources.collect(now) {
+		p.logger.Warnf("source %d:%s is idle, last event time=%s", source.id, source.name, source.lastSeen.Format(time.RFC3339))
+
+		data, err := p.idleSources.encode(source)
+		if err != nil {
+			p.logger.Errorf("can't encode idle source event: %s", err.Error())
+			continue
+		}
+
+		p.emitSynthetic(data, source.id, source.name, "idle source")
+	}
+}
+
+// emitAntispamBans passes events about sources banned by antispam through the pipeline,
+// so alerts can fire when a noisy source gets muted.
+func (p *Pipeline) emitAntispamBans() {
+	bans := p.antispamer.collectBans()
+	if !p.settings.AntispamEvents {
+		return
+	}
+
+	for _, ban := range bans {
+		data, err := p.antispamer.encodeBan(ban, p.settings.MetricLabels)
+		if err != nil {
+			p.logger.Errorf("can't encode antispam ban event: %s", err.Error())
+			continue
+		}
+
+		p.emitSynthetic(data, ban.id, ban.name, "antispam ban")
+	}
+}
+
+// emitSynthetic passes the event created by the pipeline itself through actions and the output.
+func (p *Pipeline) emitSynthetic(data []byte, sourceID SourceID, sourceName string, kind string) {
+	event := p.eventPool.get()
+	if err := event.parseJSON(data); err != nil {
+		p.logger.Errorf("can't decode %s event: %s", kind, err.Error())
+		p.eventPool.back(event)
+		return
+	}
+
+	event.synthetic = true
+	event.SourceID = sourceID
+	event.SourceName = sourceName
+	event.streamName = DefaultStreamName
+	event.Size = len(data)
+
+	p.streamEvent(event)
+}
+
+func (p *Pipeline) outputOut(event *Event) {
+	p.deliveryOutput().Out(event)
+}
+
+// commitSpooled commits the event written to the spool as if the output has committed it.
+func (p *Pipeline) commitSpooled(event *Event) {
+	p.finalize(event, true, true)
+}
+
+// replaySpooled passes the spooled event to the output skipping actions, since they've been already applied.
+func (p *Pipeline) replaySpooled(data []byte, segment *spoolSegment) bool {
+	if p.ctx.Err() != nil {
+		return false
+	}
+
+	event := p.eventPool.get()
+	if err := event.parseJSON(data); err != nil {
+		p.logger.Errorf("can't decode spooled event: %s", err.Error())
+		p.eventPool.back(event)
+		segment.done()
+		return true
+	}
+
+	event.synthetic = true
+	event.spool = segment
+	event.action = len(p.actionInfos)
+	event.streamName = DefaultStreamName
+	event.Size = len(data)
+
+	p.streamEvent(event)
+
+	return true
+}
+
 func (p *Pipeline) UseSpread() {
-	p.useSpread = true
+	if p.balancer.strategy == BalanceStream {
+		p.balancer.strategy = BalanceRoundRobin
+	}
 }
 
 func (p *Pipeline) DisableStreams() {
@@ -535,15 +1600,44 @@ func (p *Pipeline) GetEventsTotal() int {
 	return int(p.totalCommitted.Load())
 }
 
+// EnableEventLog enables the event journal with the default size if it isn't enabled by the settings.
 func (p *Pipeline) EnableEventLog() {
-	p.eventLogEnabled = true
+	if p.journal == nil {
+		p.journal = newEventJournal(DefaultEventJournalSize)
+	}
 }
 
+// GetEventLogItem returns the committed event by the index in order of committing.
+// It returns an empty string if the journal is disabled or the event is already evicted from it.
 func (p *Pipeline) GetEventLogItem(index int) string {
-	if index >= len(p.eventLog) {
-		p.logger.Fatalf("can't find log item with index %d", index)
+	if p.journal == nil {
+		return ""
+	}
+
+	event, _ := p.journal.get(index)
+	return event
+}
+
+func (p *Pipeline) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if p.journal == nil {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeErr(w, "Event journal is disabled, consider setting `event_journal_size` in the pipeline settings.")
+		return
 	}
-	return p.eventLog[index]
+
+	p.journal.serveEvents(w, r)
+}
+
+func (p *Pipeline) serveTrace(w http.ResponseWriter, r *http.Request) {
+	if p.tracer == nil {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeErr(w, "Tracing is disabled, consider setting `trace` in the pipeline settings.")
+		return
+	}
+
+	p.tracer.serveTrace(w, r)
 }
 
 func (p *Pipeline) servePipeline(w http.ResponseWriter, _ *http.Request) {
@@ -606,23 +1700,31 @@ func (p *Pipeline) serveActionInfo(info ActionPluginStaticInfo) func(http.Respon
 // serveActionSample creates a handlerFunc for the given action.
 // The func watch every processor, store their events before and after processing,
 // and returns the first result from the fastest processor.
+// Query params select the kind of events, see parseSampleFilter.
 func (p *Pipeline) serveActionSample(actionIndex int) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, _ *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 
 		if p.activeProcs.Load() <= 0 || p.procCount.Load() <= 0 {
-			writeErr(w, "There are no active processors")
 			w.WriteHeader(http.StatusBadRequest)
+			writeErr(w, "There are no active processors")
 
 			return
 		}
 
-		timeout := 5 * time.Second
+		filter, timeout, err := parseSampleFilter(r.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeErr(w, err.Error())
+
+			return
+		}
 
-		samples := make(chan sample, len(p.Procs))
-		for _, proc := range p.Procs {
+		procs := p.listProcs()
+		samples := make(chan sample, len(procs))
+		for _, proc := range procs {
 			go func(proc *processor) {
-				if sample, err := proc.actionWatcher.watch(actionIndex, timeout); err == nil {
+				if sample, err := proc.actionWatcher.watch(actionIndex, timeout, filter); err == nil {
 					samples <- *sample
 				}
 			}(proc)
@@ -632,12 +1734,94 @@ func (p *Pipeline) serveActionSample(actionIndex int) func(http.ResponseWriter,
 		case firstSample := <-samples:
 			_, _ = w.Write(firstSample.Marshal())
 		case <-time.After(timeout):
-			writeErr(w, "Timeout while try to display an event before and after the action processing.")
 			w.WriteHeader(http.StatusInternalServerError)
+			writeErr(w, "Timeout while try to display an event before and after the action processing.")
 		}
 	}
 }
 
+// serveOutputPreview renders the payload the output would send for the sampled events.
+// The count of events is set by the `count` query param.
+func (p *Pipeline) serveOutputPreview(w http.ResponseWriter, r *http.Request) {
+	previewer, ok := p.output.(PayloadPreviewer)
+	if !ok {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeErr(w, fmt.Sprintf("Output %q doesn't support the payload preview.", p.outputInfo.Type))
+		return
+	}
+
+	count := 1
+	if countStr := r.URL.Query().Get("count"); countStr != "" {
+		n, err := strconv.Atoi(countStr)
+		if err != nil || n <= 0 || n > maxPreviewEvents {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			writeErr(w, fmt.Sprintf("Count should be a number from 1 to %d.", maxPreviewEvents))
+			return
+		}
+		count = n
+	}
+
+	events := p.sampleOutputEvents(count, 5*time.Second)
+	if len(events) == 0 {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		writeErr(w, "Timeout while waiting events reaching the output.")
+		return
+	}
+
+	payload := previewer.PreviewPayload(events)
+	for _, event := range events {
+		insaneJSON.Release(event.Root)
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write(payload)
+}
+
+// sampleOutputEvents copies up to count events reaching the output, it returns fewer events on timeout.
+func (p *Pipeline) sampleOutputEvents(count int, timeout time.Duration) []*Event {
+	outputIndex := len(p.actionInfos)
+	procs := p.listProcs()
+	samples := make(chan sample, len(procs))
+	done := make(chan struct{})
+	defer close(done)
+
+	for _, proc := range procs {
+		go func(proc *processor) {
+			for {
+				s, err := proc.actionWatcher.watch(outputIndex, timeout, nil)
+				if err != nil {
+					return
+				}
+				select {
+				case samples <- *s:
+				case <-done:
+					return
+				}
+			}
+		}(proc)
+	}
+
+	events := make([]*Event, 0, count)
+	deadline := time.After(timeout)
+	for len(events) < count {
+		select {
+		case s := <-samples:
+			root, err := insaneJSON.DecodeBytes(s.eventBefore)
+			if err != nil {
+				continue
+			}
+			events = append(events, &Event{Root: root})
+		case <-deadline:
+			return events
+		}
+	}
+
+	return events
+}
+
 func writeErr(w io.Writer, err string) {
 	type ErrResp struct {
 		Error string `json:"error"`
@@ -0,0 +1,261 @@
+package pipeline
+
+import (
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+)
+
+func TestIsEmptyOrSpam(t *testing.T) {
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MinEventSize: 3}, prometheus.NewRegistry())
+
+	for data, isEmpty := range map[string]bool{
+		"":          true,
+		"\n":        true,
+		"\r\n":      true,
+		" \t  \r\n": false,
+		"ab\r\n":    true,
+		"abc\r\n":   false,
+		"abc":       false,
+	} {
+		assert.Equal(t, isEmpty, p.isEmptyOrSpam(0, "test", []byte(data), false), "wrong result for %q", data)
+	}
+
+	// whitespaces are dropped only by the setting
+	p = New("test", &Settings{Decoder: "raw", Capacity: 8, DropEmpty: true}, prometheus.NewRegistry())
+	assert.True(t, p.isEmptyOrSpam(0, "test", []byte(" \t  \r\n"), false), "blank line isn't dropped")
+}
+
+func TestTrimLineEnd(t *testing.T) {
+	assert.Equal(t, "line", string(trimLineEnd([]byte("line\n"))))
+	assert.Equal(t, "line", string(trimLineEnd([]byte("line\r\n"))))
+	assert.Equal(t, "line", string(trimLineEnd([]byte("line"))))
+	assert.Equal(t, "line\n", string(trimLineEnd([]byte("line\n\n"))))
+}
+
+func TestStreamNameOf(t *testing.T) {
+	for value, name := range map[string]StreamName{
+		`"stderr"`: "stderr",
+		`""`:       "",
+		`1`:        "1",
+		`1.0`:      "1",
+		`1e2`:      "100",
+		`-1.5`:     "-1.5",
+		`true`:     "true",
+		`false`:    "false",
+		`null`:     DefaultStreamName,
+		`{"a":1}`:  DefaultStreamName,
+		`[1,2]`:    DefaultStreamName,
+	} {
+		root, err := insaneJSON.DecodeString(`{"stream":` + value + `}`)
+		require.NoError(t, err)
+		assert.Equal(t, name, streamNameOf(root.Dig("stream")), "wrong stream name for %s", value)
+		insaneJSON.Release(root)
+	}
+}
+
+type inputStub struct{}
+
+func (p *inputStub) Start(AnyConfig, *InputPluginParams) {}
+func (p *inputStub) Stop()                               {}
+func (p *inputStub) Commit(*Event)                       {}
+
+type outputStub struct{}
+
+func (p *outputStub) Start(AnyConfig, *OutputPluginParams) {}
+func (p *outputStub) Stop()                                {}
+func (p *outputStub) Out(*Event)                           {}
+
+func TestStopWaitsBackground(t *testing.T) {
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &outputStub{}}})
+	p.Start()
+
+	finished := false
+	p.goBackground(func() {
+		<-p.ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+	})
+
+	select {
+	case <-p.Done():
+		t.Fatal("pipeline is done before stop")
+	default:
+	}
+
+	p.Stop()
+
+	assert.True(t, finished, "stop should wait for background goroutines")
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("pipeline isn't done after stop")
+	}
+}
+
+func TestMetricLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MetricLabels: map[string]string{"dc": "eu1"}}, registry)
+	p.procBusyTime.WithLabelValues("shared", "0").Inc()
+	p.skipStats.lines.WithLabelValues("empty").Inc()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.NotEmpty(t, families, "no metrics are registered")
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			assert.Equal(t, "eu1", labels["dc"], "no static label in metric %s", family.GetName())
+		}
+	}
+}
+
+type previewOutputStub struct {
+	controller OutputPluginController
+}
+
+func (p *previewOutputStub) Start(_ AnyConfig, params *OutputPluginParams) {
+	p.controller = params.Controller
+}
+func (p *previewOutputStub) Stop() {}
+func (p *previewOutputStub) Out(event *Event) {
+	p.controller.Commit(event)
+}
+
+func (p *previewOutputStub) PreviewPayload(events []*Event) []byte {
+	out := make([]byte, 0)
+	for _, event := range events {
+		out = append(out, "event:"...)
+		out, _ = event.Encode(out)
+		out = append(out, '\n')
+	}
+	return out
+}
+
+func TestServeOutputPreview(t *testing.T) {
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &previewOutputStub{}}})
+	p.Start()
+	defer p.Stop()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		offset := int64(0)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			offset++
+			p.In(1, "test.log", offset, []byte("hello\n"), false)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	w := httptest.NewRecorder()
+	p.serveOutputPreview(w, httptest.NewRequest("GET", "/pipelines/test/1/preview?count=2", nil))
+
+	assert.Equal(t, 200, w.Code, "wrong status")
+	assert.Equal(t, strings.Repeat(`event:{"message":"hello"}`+"\n", 2), w.Body.String(), "wrong payload")
+
+	w = httptest.NewRecorder()
+	p.serveOutputPreview(w, httptest.NewRequest("GET", "/pipelines/test/1/preview?count=0", nil))
+	assert.Equal(t, 400, w.Code, "wrong status for wrong count")
+}
+
+// stopCountingActionStub counts stops of its instances.
+type stopCountingActionStub struct {
+	stopped *atomic.Int32
+}
+
+func (a *stopCountingActionStub) Start(AnyConfig, *ActionPluginParams) {}
+func (a *stopCountingActionStub) Stop()                                { a.stopped.Inc() }
+func (a *stopCountingActionStub) Do(*Event) ActionResult               { return ActionPass }
+
+func TestProcsShrink(t *testing.T) {
+	stopped := atomic.NewInt32(0)
+	minProcs := int32(runtime.GOMAXPROCS(0) * 2)
+
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour, MaxProcs: int(minProcs * 3)}, prometheus.NewRegistry())
+	// the pool is shrunk by hand
+	p.procsShrinkTimeout = time.Hour
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &outputStub{}}})
+	p.AddAction(&ActionPluginStaticInfo{
+		PluginStaticInfo: &PluginStaticInfo{
+			Type: "stub",
+			Factory: func() (AnyPlugin, AnyConfig) {
+				return &stopCountingActionStub{stopped: stopped}, nil
+			},
+		},
+		MatchConditions: MatchConditions{},
+	})
+	p.Start()
+
+	p.expandProcs()
+	p.expandProcs()
+	assert.Equal(t, minProcs*3, p.procCount.Load(), "processors count should be limited")
+	p.expandProcs()
+	assert.Equal(t, minProcs*3, p.procCount.Load(), "processors count should be limited")
+
+	p.shrinkProcs()
+	assert.Eventually(t, func() bool {
+		return p.procCount.Load() == minProcs*3-minProcs*3/2
+	}, 3*time.Second, 10*time.Millisecond, "processors aren't removed")
+	assert.Equal(t, minProcs*3/2, stopped.Load(), "actions of removed processors aren't stopped")
+
+	p.shrinkProcs()
+	assert.Eventually(t, func() bool {
+		return p.procCount.Load() == minProcs
+	}, 3*time.Second, 10*time.Millisecond, "pool shouldn't shrink below the initial count")
+
+	p.Stop()
+	assert.Equal(t, int(minProcs), len(p.listProcs()), "wrong processors count")
+	assert.Equal(t, minProcs*3, stopped.Load(), "actions aren't stopped")
+}
+
+func TestProcsSnapshot(t *testing.T) {
+	minProcs := runtime.GOMAXPROCS(0) * 2
+
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour, MaxProcs: minProcs * 4}, prometheus.NewRegistry())
+	p.procsShrinkTimeout = time.Hour
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &outputStub{}}})
+	p.Start()
+	defer p.Stop()
+
+	snapshot := p.listProcs()
+
+	// readers iterate processors while the pool grows, it's checked by the race detector
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			for _, proc := range p.listProcs() {
+				_ = proc.queue
+			}
+		}
+	}()
+	p.expandProcs()
+	p.expandProcs()
+	<-done
+
+	assert.Equal(t, minProcs, len(snapshot), "snapshot is changed by the expanding")
+	assert.Equal(t, minProcs*4, len(p.listProcs()), "wrong processors count")
+}
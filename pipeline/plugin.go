@@ -1,9 +1,14 @@
 package pipeline
 
 import (
+	"fmt"
 	"net/http"
 	"regexp"
+	"time"
 
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/netutil"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -21,6 +26,20 @@ type InputPlugin interface {
 	Commit(*Event)
 }
 
+// SourcePauser is implemented by inputs which can pause reading of a single source.
+// The pipeline pauses a source when it has too many events in the pipeline, see `max_in_flight_per_source` setting.
+type SourcePauser interface {
+	PauseSource(sourceID SourceID)
+	ResumeSource(sourceID SourceID)
+}
+
+// InputPauser is implemented by inputs which can pause reading of all sources.
+// The pipeline pauses the input while some output signals backpressure, see `OutputPluginController.Backpressure`.
+type InputPauser interface {
+	PauseInput()
+	ResumeInput()
+}
+
 type ActionPlugin interface {
 	Start(config AnyConfig, params *ActionPluginParams)
 	Stop()
@@ -36,6 +55,8 @@ type OutputPlugin interface {
 type PluginDefaultParams struct {
 	PipelineName     string
 	PipelineSettings *Settings
+	// MetricsRegistry registers metrics of the plugin along with metrics of the pipeline.
+	MetricsRegistry prometheus.Registerer
 }
 
 type ActionPluginParams struct {
@@ -48,6 +69,8 @@ type OutputPluginParams struct {
 	*PluginDefaultParams
 	Controller OutputPluginController
 	Logger     *zap.SugaredLogger
+	// Chaos should be set to dialers of the output, it's nil unless failures are injected for testing
+	Chaos *netutil.Chaos
 }
 
 type InputPluginParams struct {
@@ -80,11 +103,16 @@ type InputPluginInfo struct {
 type ActionPluginStaticInfo struct {
 	*PluginStaticInfo
 
-	MetricName      string
-	MetricLabels    []string
-	MatchConditions MatchConditions
-	MatchMode       MatchMode
-	MatchInvert     bool
+	MetricName           string
+	MetricLabels         []string
+	MetricMaxLabelValues int
+	MatchConditions      MatchConditions
+	MatchMode            MatchMode
+	MatchInvert          bool
+	// Timeout is the time budget of the action per event, 0 means it isn't limited
+	Timeout time.Duration
+	// SkipOnTimeout makes the processor skip the action for a while after it has exceeded the time budget
+	SkipOnTimeout bool
 }
 
 type ActionPluginInfo struct {
@@ -92,9 +120,47 @@ type ActionPluginInfo struct {
 	*PluginRuntimeInfo
 }
 
+// PayloadPreviewer is implemented by outputs which are able to render the payload
+// they would send for the events, e.g. the bulk request body. It's used by the `preview` endpoint of the output.
+type PayloadPreviewer interface {
+	PreviewPayload(events []*Event) []byte
+}
+
 type OutputPluginInfo struct {
 	*PluginStaticInfo
 	*PluginRuntimeInfo
+
+	// ControlChars defines what to do with control chars in string values before the output gets events
+	ControlChars ControlCharsMode
+	// ServiceFields defines whether the output gets service fields of events, e.g. `_offset`
+	ServiceFields ServiceFieldsMode
+	// BatchPartitionField is the event field to partition batches of the output by, it's empty if batches aren't partitioned
+	BatchPartitionField string
+	// LowLatency makes the output send each event as soon as it's processed, e.g. for audit events
+	LowLatency bool
+	// Chaos injects failures into connections of the output to test retries in staging, it's nil if it's disabled
+	Chaos *netutil.Chaos
+
+	// OutputID is the id of the output routes refer to, it's empty if the output isn't referred
+	OutputID string
+
+	// MatchConditions route events to the output, the output gets all events if there are no conditions
+	MatchConditions MatchConditions
+	MatchMode       MatchMode
+	MatchInvert     bool
+}
+
+// IsRouted returns true if the output gets only events matching its conditions.
+func (i *OutputPluginInfo) IsRouted() bool {
+	return len(i.MatchConditions) != 0
+}
+
+func (i *OutputPluginInfo) isMatch(event *Event) bool {
+	if !i.IsRouted() {
+		return true
+	}
+
+	return i.MatchConditions.IsMatch(event, i.MatchMode) != i.MatchInvert
 }
 
 type AnyPlugin interface{}
@@ -111,6 +177,83 @@ type MatchCondition struct {
 	Regexp *regexp.Regexp
 }
 
+// NewMatchConditions builds conditions from the `event field => value` map,
+// values starting with `/` are treated as regular expressions.
+func NewMatchConditions(fields map[string]string) (MatchConditions, error) {
+	conditions := make(MatchConditions, 0, len(fields))
+	for field, value := range fields {
+		condition := MatchCondition{
+			Field: field,
+		}
+
+		if len(value) > 0 && value[0] == '/' {
+			r, err := cfg.CompileRegex(value)
+			if err != nil {
+				return nil, fmt.Errorf("can't compile regexp %s: %w", value, err)
+			}
+			condition.Regexp = r
+		} else {
+			condition.Value = value
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+func (c MatchConditions) IsMatch(event *Event, mode MatchMode) bool {
+	if mode == MatchModeOr {
+		return c.isMatchOr(event)
+	}
+
+	return c.isMatchAnd(event)
+}
+
+func (c MatchConditions) isMatchOr(event *Event) bool {
+	for _, cond := range c {
+		node := event.Root.Dig(cond.Field)
+		if node == nil {
+			continue
+		}
+		value := node.AsString()
+		match := false
+		if cond.Regexp != nil {
+			match = cond.Regexp.MatchString(value)
+		} else {
+			match = value == cond.Value
+		}
+
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c MatchConditions) isMatchAnd(event *Event) bool {
+	for _, cond := range c {
+		node := event.Root.Dig(cond.Field)
+		if node == nil {
+			return false
+		}
+		value := node.AsString()
+
+		match := false
+		if cond.Regexp != nil {
+			match = cond.Regexp.MatchString(value)
+		} else {
+			match = value == cond.Value
+		}
+
+		if !match {
+			return false
+		}
+	}
+
+	return true
+}
+
 type MatchMode int
 
 const (
@@ -1,8 +1,12 @@
 package pipeline
 
 import (
+	"time"
+
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/longpanic"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
@@ -33,17 +37,35 @@ const (
 	eventStatusDiscarded  eventStatus = "discarded"
 	eventStatusCollapse   eventStatus = "collapsed"
 	eventStatusHold       eventStatus = "held"
+	eventStatusSkipped    eventStatus = "skipped"
 )
 
 // processor is a goroutine which doing pipeline actions
 type processor struct {
 	id            int
+	queue         int // index of the streamer queue to take streams from
 	streamer      *streamer
 	metricsHolder *metricsHolder
 	output        OutputPlugin
 	finalize      finalizeFn
+	spawn         spawnFn
+	controlChars  *controlChars
+	holder        *holder
+	// stripServiceFields is set if the output doesn't get service fields of events
+	stripServiceFields bool
+	logger             *zap.SugaredLogger
 
 	activeCounter *atomic.Int32
+	busyTime      prometheus.Counter
+	// stolen counts streams taken from the shared queue by the processor of the pinned queue
+	stolen prometheus.Counter
+	// cpuQuota is set if the time spent in actions is limited
+	cpuQuota *cpuQuota
+	// timeouts counts actions exceeding their time budget, running is the tracked action being done now
+	timeouts       *actionTimeouts
+	running        runningAction
+	skippedUntil   []time.Time
+	lastTimeoutLog time.Time
 
 	actions          []ActionPlugin
 	actionInfos      []*ActionPluginStaticInfo
@@ -55,6 +77,10 @@ type processor struct {
 
 	heartbeatCh   chan *stream
 	metricsValues []string
+
+	// retired is set when the pool shrinks, the processor exits once it has no busy actions
+	retired *atomic.Bool
+	exited  chan struct{}
 }
 
 var id = 0
@@ -64,10 +90,12 @@ func NewProcessor(
 	activeCounter *atomic.Int32,
 	output OutputPlugin,
 	streamer *streamer,
+	queue int,
 	finalizeFn finalizeFn,
 ) *processor {
 	processor := &processor{
 		id:            id,
+		queue:         queue,
 		streamer:      streamer,
 		metricsHolder: metricsHolder,
 		output:        output,
@@ -77,7 +105,11 @@ func NewProcessor(
 		actionWatcher: newActionWatcher(id),
 
 		metricsValues: make([]string, 0, 0),
+
+		retired: atomic.NewBool(false),
+		exited:  make(chan struct{}),
 	}
+	processor.running.index.Store(-1)
 
 	id++
 
@@ -94,18 +126,30 @@ func (p *processor) start(params *PluginDefaultParams, logger *zap.SugaredLogger
 		})
 	}
 
-	longpanic.Go(p.process)
+	longpanic.GoScoped(longpanic.Scope{Pipeline: params.PipelineName, Plugin: "processor"}, p.process)
 }
 
 func (p *processor) process() {
 	for {
-		st := p.streamer.joinStream()
+		st := p.streamer.joinStream(p.queue, p.retirement())
 		if st == nil {
+			if p.retired.Load() && p.busyActionsTotal == 0 {
+				close(p.exited)
+			}
 			return
 		}
 
+		st.setProc(p.id)
+		if st.queue != p.queue && p.stolen != nil {
+			p.stolen.Inc()
+		}
+
 		p.activeCounter.Inc()
+		start := time.Now()
 		p.dischargeStream(st)
+		if p.busyTime != nil {
+			p.busyTime.Add(time.Since(start).Seconds())
+		}
 		p.activeCounter.Dec()
 	}
 }
@@ -120,6 +164,11 @@ func (p *processor) dischargeStream(st *stream) {
 		if !p.processSequence(event) {
 			return
 		}
+		// actions waiting for sequential events need the stream, so it yields only if there are no busy actions
+		if st.isExhausted() && p.busyActionsTotal == 0 {
+			st.yield()
+			return
+		}
 	}
 }
 
@@ -133,13 +182,33 @@ func (p *processor) processSequence(event *Event) bool {
 			return false
 		}
 
-		event.stage = eventStageOutput
-		p.output.Out(event)
+		p.out(event)
 	}
 
 	return isSuccess
 }
 
+// out passes the event which has gone through all actions to the output.
+func (p *processor) out(event *Event) {
+	if p.controlChars != nil && p.controlChars.isEnabled() {
+		p.controlChars.sanitize(event)
+	}
+	if p.stripServiceFields {
+		stripServiceFields(event)
+	}
+
+	// events reaching the output are sampled for the payload preview
+	p.actionWatcher.setEventBefore(len(p.actions), event)
+	p.actionWatcher.setEventAfter(len(p.actions), event, eventStatusPassed)
+
+	event.stage = eventStageOutput
+	if p.holder != nil && p.holder.isEnabled() {
+		p.holder.put(event)
+	} else {
+		p.output.Out(event)
+	}
+}
+
 func (p *processor) processEvent(event *Event) (isSuccess bool, isPassed bool, e *Event) {
 	for {
 		if event.IsUnlockKind() {
@@ -147,7 +216,7 @@ func (p *processor) processEvent(event *Event) (isSuccess bool, isPassed bool, e
 		}
 		stream := event.stream
 
-		if p.doActions(event) {
+		if p.paceActions(event) {
 			return true, true, event
 		}
 
@@ -166,6 +235,19 @@ func (p *processor) processEvent(event *Event) (isSuccess bool, isPassed bool, e
 	}
 }
 
+// paceActions does actions and sleeps if the pipeline exceeds its cpu quota.
+func (p *processor) paceActions(event *Event) (isPassed bool) {
+	if p.cpuQuota == nil {
+		return p.doActions(event)
+	}
+
+	start := time.Now()
+	isPassed = p.doActions(event)
+	p.cpuQuota.spend(time.Since(start))
+
+	return isPassed
+}
+
 func (p *processor) doActions(event *Event) (isPassed bool) {
 	l := len(p.actions)
 	for index := event.action; index < l; index++ {
@@ -178,14 +260,44 @@ func (p *processor) doActions(event *Event) (isPassed bool) {
 			isMatch = !isMatch
 		}
 
+		var step *traceStep
+		if event.trace != nil {
+			step = &traceStep{Action: index, Type: p.actionInfos[index].Type, Status: eventStatusNotMatched}
+			event.trace.Steps = append(event.trace.Steps, step)
+		}
+
 		if !isMatch {
 			p.countEvent(event, index, eventStatusNotMatched)
 			continue
 		}
 
+		timeout := p.actionInfos[index].Timeout
+		if timeout > 0 && p.isActionSkipped(index) {
+			p.countEvent(event, index, eventStatusSkipped)
+			if step != nil {
+				step.Status = eventStatusSkipped
+			}
+			continue
+		}
+
 		p.actionWatcher.setEventBefore(index, event)
 
-		switch action.Do(event) {
+		if step != nil {
+			step.before(event)
+		}
+		var start time.Time
+		if timeout > 0 {
+			start = p.startTimed(index)
+		}
+		result := action.Do(event)
+		if timeout > 0 {
+			p.finishTimed(index, event, start)
+		}
+		if step != nil {
+			step.after(event, result)
+		}
+
+		switch result {
 		case ActionPass:
 			p.countEvent(event, index, eventStatusPassed)
 			p.tryResetBusy(index)
@@ -255,64 +367,24 @@ func (p *processor) isMatch(index int, event *Event) bool {
 	}
 
 	info := p.actionInfos[index]
-	conds := info.MatchConditions
-	mode := info.MatchMode
-
-	if mode == MatchModeOr {
-		return p.isMatchOr(conds, event)
-	} else {
-		return p.isMatchAnd(conds, event)
-	}
-}
-
-func (p *processor) isMatchOr(conds MatchConditions, event *Event) bool {
-	for _, cond := range conds {
-		node := event.Root.Dig(cond.Field)
-		if node == nil {
-			continue
-		}
-		value := node.AsString()
-		match := false
-		if cond.Regexp != nil {
-			match = cond.Regexp.MatchString(value)
-		} else {
-			match = value == cond.Value
-		}
-
-		if match {
-			return true
-		}
-	}
 
-	return false
+	return info.MatchConditions.IsMatch(event, info.MatchMode)
 }
 
-func (p *processor) isMatchAnd(conds MatchConditions, event *Event) bool {
-	for _, cond := range conds {
-		node := event.Root.Dig(cond.Field)
-		if node == nil {
-			return false
-		}
-		value := node.AsString()
-
-		match := false
-		if cond.Regexp != nil {
-			match = cond.Regexp.MatchString(value)
-		} else {
-			match = value == cond.Value
-		}
-
-		if !match {
-			return false
-		}
+// retirement returns the retired flag if the processor can exit, held events of busy actions don't allow it.
+func (p *processor) retirement() *atomic.Bool {
+	if p.busyActionsTotal != 0 {
+		return nil
 	}
-
-	return true
+	return p.retired
 }
 
 func (p *processor) stop() {
-	p.streamer.unblockProcessor()
+	p.streamer.unblockProcessor(p.queue)
+	p.stopActions()
+}
 
+func (p *processor) stopActions() {
 	for _, action := range p.actions {
 		action.Stop()
 	}
@@ -322,6 +394,7 @@ func (p *processor) AddActionPlugin(info *ActionPluginInfo) {
 	p.actions = append(p.actions, info.Plugin.(ActionPlugin))
 	p.actionInfos = append(p.actionInfos, info.ActionPluginStaticInfo)
 	p.busyActions = append(p.busyActions, false)
+	p.skippedUntil = append(p.skippedUntil, time.Time{})
 }
 
 func (p *processor) Commit(event *Event) {
@@ -333,6 +406,26 @@ func (p *processor) Propagate(event *Event) {
 	p.processSequence(event)
 }
 
+func (p *processor) SetTimeout(event *Event, timeout time.Duration) {
+	if event.stream == nil {
+		return
+	}
+	event.stream.setTimeout(timeout)
+}
+
+// Spawn does next actions right away, it doesn't wait for sequential events of the stream,
+// since the action spawning events is in the middle of processing the parent.
+func (p *processor) Spawn(parent *Event, root *insaneJSON.Node) {
+	event := p.spawn(parent, root)
+	if event == nil {
+		return
+	}
+
+	if p.paceActions(event) {
+		p.out(event)
+	}
+}
+
 func (p *processor) RecoverFromPanic() {
 	p.recoverFromPanic()
 }
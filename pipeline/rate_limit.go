@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// RateLimitBlock makes `In` wait until the line fits the limits, so inputs are slowed down.
+	RateLimitBlock = "block"
+	// RateLimitDrop drops lines exceeding the limits.
+	RateLimitDrop = "drop"
+	// RateLimitSample keeps one of `rate_limit_sample` lines exceeding the limits and drops others.
+	RateLimitSample = "sample"
+
+	defaultRateLimitSample = 10
+)
+
+// rateLimiter limits lines and bytes per second passed to the pipeline regardless of actions,
+// it's the token bucket with the burst of one second of each limit.
+type rateLimiter struct {
+	eventsLimit float64
+	bytesLimit  float64
+	policy      string
+	sample      int
+
+	mu       *sync.Mutex
+	events   float64
+	bytes    float64
+	updateAt time.Time
+	exceeded int
+
+	blocked prometheus.Counter
+}
+
+func newRateLimiter(pipelineName string, settings *Settings, registry prometheus.Registerer) *rateLimiter {
+	policy := settings.RateLimitPolicy
+	if policy == "" {
+		policy = RateLimitBlock
+	}
+	sample := settings.RateLimitSample
+	if sample <= 0 {
+		sample = defaultRateLimitSample
+	}
+
+	l := &rateLimiter{
+		eventsLimit: float64(settings.RateLimitEvents),
+		bytesLimit:  float64(settings.RateLimitBytes),
+		policy:      policy,
+		sample:      sample,
+
+		mu:       &sync.Mutex{},
+		events:   float64(settings.RateLimitEvents),
+		bytes:    float64(settings.RateLimitBytes),
+		updateAt: time.Now(),
+
+		blocked: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "rate_limit_blocked_seconds_total",
+			Help:      "how long the input is blocked since lines exceed the rate limit",
+		}),
+	}
+
+	registry.MustRegister(l.blocked)
+
+	return l
+}
+
+func (l *rateLimiter) isEnabled() bool {
+	return l.eventsLimit > 0 || l.bytesLimit > 0
+}
+
+// allow returns true if the line of the size should be passed,
+// in the block mode it waits until the line fits the limits or done is closed.
+func (l *rateLimiter) allow(size int, done <-chan struct{}) bool {
+	now := time.Now()
+	if l.policy == RateLimitBlock {
+		wait := l.reserve(size, now)
+		if wait <= 0 {
+			return true
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-done:
+		}
+		l.blocked.Add(time.Since(now).Seconds())
+		return true
+	}
+
+	if l.take(size, now) {
+		return true
+	}
+
+	if l.policy == RateLimitSample {
+		l.mu.Lock()
+		l.exceeded++
+		isSampled := (l.exceeded-1)%l.sample == 0
+		l.mu.Unlock()
+		return isSampled
+	}
+
+	return false
+}
+
+// reserve counts the line anyway and returns how long to wait until the limits are paid off.
+func (l *rateLimiter) reserve(size int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	wait := time.Duration(0)
+	if l.eventsLimit > 0 {
+		l.events--
+		if l.events < 0 {
+			wait = time.Duration(-l.events / l.eventsLimit * float64(time.Second))
+		}
+	}
+	if l.bytesLimit > 0 {
+		l.bytes -= float64(size)
+		if l.bytes < 0 {
+			if w := time.Duration(-l.bytes / l.bytesLimit * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+	}
+
+	return wait
+}
+
+// take counts the line if both limits aren't exhausted, the line bigger than the bytes limit is taken if the bucket isn't empty.
+func (l *rateLimiter) take(size int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	if l.eventsLimit > 0 && l.events < 1 {
+		return false
+	}
+	if l.bytesLimit > 0 && l.bytes <= 0 {
+		return false
+	}
+
+	l.events--
+	l.bytes -= float64(size)
+	return true
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.updateAt).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	l.updateAt = now
+
+	l.events += elapsed * l.eventsLimit
+	if l.events > l.eventsLimit {
+		l.events = l.eventsLimit
+	}
+	l.bytes += elapsed * l.bytesLimit
+	if l.bytes > l.bytesLimit {
+		l.bytes = l.bytesLimit
+	}
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	l := newRateLimiter("test", &Settings{RateLimitEvents: 2, RateLimitBytes: 100}, prometheus.NewRegistry())
+	now := l.updateAt
+
+	assert.True(t, l.take(10, now), "line within the limits isn't taken")
+	assert.True(t, l.take(10, now), "line within the limits isn't taken")
+	assert.False(t, l.take(10, now), "line exceeding the events limit is taken")
+
+	now = now.Add(time.Second)
+	assert.True(t, l.take(200, now), "big line isn't taken by the full bucket")
+	assert.False(t, l.take(1, now), "line exceeding the bytes limit is taken")
+
+	// the bucket isn't refilled beyond one second of the limit
+	now = now.Add(10 * time.Second)
+	assert.True(t, l.take(1, now))
+	assert.True(t, l.take(1, now))
+	assert.False(t, l.take(1, now), "burst is bigger than one second")
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter("test", &Settings{RateLimitEvents: 10, RateLimitBytes: 1000}, prometheus.NewRegistry())
+	now := l.updateAt
+
+	assert.Equal(t, time.Duration(0), l.reserve(500, now), "line within the limits shouldn't wait")
+	assert.Equal(t, 500*time.Millisecond, l.reserve(1000, now), "wrong wait for the bytes limit")
+
+	l = newRateLimiter("test", &Settings{RateLimitEvents: 10}, prometheus.NewRegistry())
+	now = l.updateAt
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(0), l.reserve(0, now), "line within the limits shouldn't wait")
+	}
+	assert.Equal(t, 100*time.Millisecond, l.reserve(0, now), "wrong wait for the events limit")
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		passed int
+	}{
+		{policy: RateLimitDrop, passed: 2},
+		// 2 lines fit the limit, 1st, 4th and 7th of exceeding lines are sampled
+		{policy: RateLimitSample, passed: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			l := newRateLimiter("test", &Settings{RateLimitEvents: 2, RateLimitPolicy: tt.policy, RateLimitSample: 3}, prometheus.NewRegistry())
+			// the bucket isn't refilled until the update time, so the test isn't affected by the time
+			l.updateAt = time.Now().Add(time.Hour)
+
+			passed := 0
+			for i := 0; i < 10; i++ {
+				if l.allow(1, nil) {
+					passed++
+				}
+			}
+			assert.Equal(t, tt.passed, passed, "wrong passed count")
+		})
+	}
+}
+
+func TestRateLimitBlock(t *testing.T) {
+	l := newRateLimiter("test", &Settings{RateLimitEvents: 20}, prometheus.NewRegistry())
+	assert.Equal(t, RateLimitBlock, l.policy, "wrong default policy")
+
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		assert.True(t, l.allow(1, nil), "line isn't passed in the block mode")
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond, "input isn't blocked")
+
+	// the debt of 100 lines takes 5s to pay off
+	for i := 0; i < 100; i++ {
+		l.reserve(0, time.Now())
+	}
+	done := make(chan struct{})
+	close(done)
+	start = time.Now()
+	assert.True(t, l.allow(1, done))
+	assert.Less(t, time.Since(start), time.Second, "closed done doesn't unblock the input")
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"time"
+)
+
+// ReadyReporter is implemented by plugins which get ready asynchronously after `Start`,
+// e.g. outputs connecting to the sink in the background.
+// The pipeline starts outputs, actions and the input in order and waits for started plugins to be ready
+// before starting the next ones, so events don't hit plugins which can't handle them yet.
+type ReadyReporter interface {
+	// Ready returns the channel which is closed once the plugin is ready to handle events.
+	Ready() <-chan struct{}
+}
+
+// waitReady waits until the plugin is ready or the deadline is over, false is returned on the timeout.
+// Plugins which don't implement `ReadyReporter` are ready once `Start` returns.
+func (p *Pipeline) waitReady(kind string, pluginType string, plugin AnyPlugin, deadline time.Time) bool {
+	reporter, ok := plugin.(ReadyReporter)
+	if !ok {
+		return true
+	}
+	p.reporters = append(p.reporters, reporter)
+
+	if p.settings.ReadyTimeout <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-reporter.Ready():
+		return true
+	case <-timer.C:
+		p.logger.Warnf("%s plugin %q of pipeline %q isn't ready in %s, starting next plugins anyway", kind, pluginType, p.Name, p.settings.ReadyTimeout)
+		return false
+	}
+}
+
+// readyDeadline returns the deadline of the next plugin kind to get ready.
+func (p *Pipeline) readyDeadline() time.Time {
+	return time.Now().Add(p.settings.ReadyTimeout)
+}
+
+// IsReady returns true if the pipeline is started and all plugins reporting readiness are ready.
+func (p *Pipeline) IsReady() bool {
+	if !p.started.Load() {
+		return false
+	}
+
+	for _, reporter := range p.reporters {
+		select {
+		case <-reporter.Ready():
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// readyOutputStub gets ready when the channel is closed, startedInputStub signals its start to check the order.
+type readyOutputStub struct {
+	outputStub
+	ready chan struct{}
+}
+
+func (p *readyOutputStub) Ready() <-chan struct{} {
+	return p.ready
+}
+
+type startedInputStub struct {
+	inputStub
+	started chan struct{}
+}
+
+func (p *startedInputStub) Start(AnyConfig, *InputPluginParams) {
+	close(p.started)
+}
+
+func newReadyPipeline(readyTimeout time.Duration) (*Pipeline, *readyOutputStub, *startedInputStub) {
+	output := &readyOutputStub{ready: make(chan struct{})}
+	input := &startedInputStub{started: make(chan struct{})}
+
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour, ReadyTimeout: readyTimeout}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: output}})
+
+	return p, output, input
+}
+
+func TestStartWaitsOutputReady(t *testing.T) {
+	p, output, input := newReadyPipeline(time.Minute)
+
+	started := make(chan struct{})
+	go func() {
+		p.Start()
+		close(started)
+	}()
+
+	select {
+	case <-input.started:
+		assert.Fail(t, "input is started before the output is ready")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.False(t, p.IsReady(), "pipeline is ready before the start")
+
+	close(output.ready)
+	<-started
+	<-input.started
+	assert.True(t, p.IsReady(), "pipeline isn't ready")
+	p.Stop()
+}
+
+func TestStartReadyTimeout(t *testing.T) {
+	p, output, input := newReadyPipeline(50 * time.Millisecond)
+
+	p.Start()
+	defer p.Stop()
+
+	<-input.started
+	assert.False(t, p.IsReady(), "pipeline with the output which isn't ready is ready")
+
+	close(output.ready)
+	assert.True(t, p.IsReady(), "pipeline isn't ready once the output is ready")
+}
@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Route passes events matching its conditions to the outputs, it's the declarative alternative
+// to conditions of outputs and chains of discarding actions.
+type Route struct {
+	MatchConditions MatchConditions
+	MatchMode       MatchMode
+	MatchInvert     bool
+	// Priority orders routes from the highest one, routes with the same priority keep the order of the config
+	Priority int
+	// Outputs are IDs of outputs the event is passed to
+	Outputs []string
+	// IsDefault route gets events which don't match other routes, it has no conditions
+	IsDefault bool
+}
+
+// routeTable passes the event to outputs of the first matching route, outputs of routes are resolved on the start,
+// so routing doesn't allocate.
+type routeTable struct {
+	routes []*tableRoute
+	// fallback is nil if there is no default route, events are discarded then
+	fallback *tableRoute
+}
+
+type tableRoute struct {
+	*Route
+	outputs []*fanOutput
+}
+
+func newRouteTable(routes []*Route, outputs []*fanOutput) *routeTable {
+	byID := make(map[string]*fanOutput, len(outputs))
+	for _, o := range outputs {
+		byID[o.info.OutputID] = o
+	}
+
+	t := &routeTable{}
+	for _, route := range routes {
+		r := &tableRoute{Route: route}
+		for _, id := range route.Outputs {
+			r.outputs = append(r.outputs, byID[id])
+		}
+
+		if route.IsDefault {
+			t.fallback = r
+			continue
+		}
+		t.routes = append(t.routes, r)
+	}
+
+	sort.SliceStable(t.routes, func(i, j int) bool {
+		return t.routes[i].Priority > t.routes[j].Priority
+	})
+
+	return t
+}
+
+// route returns outputs of the first matching route, it's empty if the event should be discarded.
+func (t *routeTable) route(event *Event) []*fanOutput {
+	for _, r := range t.routes {
+		if r.MatchConditions.IsMatch(event, r.MatchMode) != r.MatchInvert {
+			return r.outputs
+		}
+	}
+
+	if t.fallback != nil {
+		return t.fallback.outputs
+	}
+
+	return nil
+}
+
+// SetRoutes sets the routing table of the pipeline, it should be called after outputs are added.
+// Outputs get only events of matching routes then, so they can't have their own conditions.
+func (p *Pipeline) SetRoutes(routes []*Route) error {
+	outputs := make(map[string]bool)
+	for _, info := range append([]*OutputPluginInfo{p.outputInfo}, p.extraOutputs...) {
+		if info == nil {
+			return fmt.Errorf("routes are set before outputs")
+		}
+		if info.IsRouted() {
+			return fmt.Errorf("output %q has match conditions, they can't be used along with routes", info.Type)
+		}
+		if info.OutputID == "" {
+			continue
+		}
+		if outputs[info.OutputID] {
+			return fmt.Errorf("output id %q isn't unique", info.OutputID)
+		}
+		outputs[info.OutputID] = true
+	}
+
+	hasDefault := false
+	for i, route := range routes {
+		if route.IsDefault {
+			if hasDefault {
+				return fmt.Errorf("route #%d: there are several default routes", i)
+			}
+			if len(route.MatchConditions) != 0 {
+				return fmt.Errorf("route #%d: default route can't have match conditions", i)
+			}
+			hasDefault = true
+		}
+
+		if len(route.Outputs) == 0 {
+			return fmt.Errorf("route #%d: no outputs", i)
+		}
+		routeOutputs := make(map[string]bool, len(route.Outputs))
+		for _, id := range route.Outputs {
+			if !outputs[id] {
+				return fmt.Errorf("route #%d: unknown output id %q", i, id)
+			}
+			if routeOutputs[id] {
+				return fmt.Errorf("route #%d: output id %q is duplicated", i, id)
+			}
+			routeOutputs[id] = true
+		}
+	}
+
+	p.routes = routes
+	return nil
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	input := &offsetsInputStub{}
+	errors := &queueOutputStub{}
+	audit := &queueOutputStub{}
+	archive := &queueOutputStub{}
+
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "errors"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: errors}, OutputID: "errors"})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "audit"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: audit}, OutputID: "audit"})
+	p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "archive"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: archive}, OutputID: "archive"})
+
+	err := p.SetRoutes([]*Route{
+		{MatchConditions: MatchConditions{{Field: "service", Value: "billing"}}, Outputs: []string{"audit", "archive"}},
+		// the route with the higher priority wins for billing errors
+		{MatchConditions: MatchConditions{{Field: "level", Value: "error"}}, Priority: 10, Outputs: []string{"errors"}},
+		{IsDefault: true, Outputs: []string{"archive"}},
+	})
+	require.NoError(t, err)
+	p.Start()
+	defer p.Stop()
+
+	p.In(1, "test.log", 1, []byte(`{"level":"error","service":"billing","message":"one"}`+"\n"), false)
+	p.In(1, "test.log", 2, []byte(`{"level":"info","service":"billing","message":"two"}`+"\n"), false)
+	p.In(1, "test.log", 3, []byte(`{"level":"info","service":"api","message":"three"}`+"\n"), false)
+
+	require.Eventually(t, func() bool {
+		return len(errors.collected()) == 1 && len(audit.collected()) == 1 && len(archive.collected()) == 2
+	}, 5*time.Second, time.Millisecond, "events aren't routed")
+
+	assert.Equal(t, []string{"one"}, errors.collected(), "wrong events of the errors output")
+	assert.Equal(t, []string{"two"}, audit.collected(), "wrong events of the audit output")
+	assert.Equal(t, []string{"two", "three"}, archive.collected(), "wrong events of the archive output")
+
+	errors.commitAll()
+	audit.commitAll()
+	archive.commitAll()
+	require.Eventually(t, func() bool { return len(input.committed()) == 3 }, 5*time.Second, time.Millisecond, "events aren't committed")
+	assert.Equal(t, []int64{1, 2, 3}, input.committed(), "events are committed out of order")
+}
+
+func TestSetRoutesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []*Route
+	}{
+		{name: "unknown_output", routes: []*Route{{Outputs: []string{"unknown"}}}},
+		{name: "no_outputs", routes: []*Route{{}}},
+		{name: "duplicated_output", routes: []*Route{{Outputs: []string{"first", "first"}}}},
+		{name: "several_defaults", routes: []*Route{{IsDefault: true, Outputs: []string{"first"}}, {IsDefault: true, Outputs: []string{"second"}}}},
+		{name: "default_conditions", routes: []*Route{{IsDefault: true, MatchConditions: MatchConditions{{Field: "a", Value: "b"}}, Outputs: []string{"first"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New("test", &Settings{Decoder: "json", Capacity: 8}, prometheus.NewRegistry())
+			p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "first"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &outputStub{}}, OutputID: "first"})
+			p.AddOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "second"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &outputStub{}}, OutputID: "second"})
+
+			assert.Error(t, p.SetRoutes(tt.routes))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runQueue exposes how streams wait for processors per queue and how deep is the stream each processor is attached to,
+// so uneven load of processors, e.g. by a few hot streams, is visible.
+type runQueue struct {
+	streams    *prometheus.GaugeVec
+	procEvents *prometheus.GaugeVec
+	stolen     *prometheus.CounterVec
+}
+
+func newRunQueue(pipelineName string, registry prometheus.Registerer) *runQueue {
+	q := &runQueue{
+		streams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "run_queue_streams",
+			Help:      "how many streams with events wait for a processor of the queue",
+		}, []string{"queue"}),
+		procEvents: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "processor_queued_events",
+			Help:      "how many events are queued in the stream the processor is attached to",
+		}, []string{"queue", "processor"}),
+		stolen: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "processor_stolen_streams_total",
+			Help:      "how many streams of the shared queue are taken by processors of pinned queues",
+		}, []string{"queue", "processor"}),
+	}
+
+	registry.MustRegister(q.streams, q.procEvents, q.stolen)
+
+	return q
+}
+
+// update sets gauges of queues and processors attached to streams, gauges of idle processors are removed.
+func (q *runQueue) update(s *streamer, queueName func(queue int) string) {
+	for i, count := range s.chargedCounts() {
+		q.streams.WithLabelValues(queueName(i)).Set(float64(count))
+	}
+
+	q.procEvents.Reset()
+	s.mu.RLock()
+	for _, source := range s.streams {
+		for _, st := range source {
+			proc, queued, isAttached := st.attachedProc()
+			if !isAttached {
+				continue
+			}
+			q.procEvents.WithLabelValues(queueName(st.queue), strconv.Itoa(proc)).Add(float64(queued))
+		}
+	}
+	s.mu.RUnlock()
+}
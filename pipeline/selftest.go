@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// SelfTestField is the field of the marker event, so actions can match it.
+const SelfTestField = "file_d_selftest"
+
+// selfTest tracks marker events passed through the pipeline to the output.
+type selfTest struct {
+	seq       *atomic.Uint64
+	committed chan uint64
+}
+
+func newSelfTest() *selfTest {
+	return &selfTest{
+		seq:       atomic.NewUint64(0),
+		committed: make(chan uint64, 1),
+	}
+}
+
+// commit notifies the waiting self test, it's called when the output commits the marker event.
+func (t *selfTest) commit(id uint64) {
+	select {
+	case t.committed <- id:
+	default:
+	}
+}
+
+// SelfTest passes the synthetic marker event through actions to the real output
+// and waits until the output commits it, so it's checked that the pipeline is able to deliver events.
+// The marker isn't committed to the input. It fails if an action discards the marker.
+func (p *Pipeline) SelfTest(timeout time.Duration) error {
+	id := p.selfTest.seq.Inc()
+	data, err := json.Marshal(map[string]interface{}{
+		SelfTestField: id,
+		"pipeline":    p.Name,
+		"time":        time.Now().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+
+	event := p.eventPool.get()
+	if err := event.parseJSON(data); err != nil {
+		p.eventPool.back(event)
+		return err
+	}
+
+	event.synthetic = true
+	event.selfTestID = id
+	event.SourceName = "selftest"
+	event.streamName = DefaultStreamName
+	event.Size = len(data)
+
+	p.streamEvent(event)
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case committed := <-p.selfTest.committed:
+			// skip markers of previous timed out tests
+			if committed == id {
+				return nil
+			}
+		case <-deadline:
+			return fmt.Errorf("marker event isn't committed by the output %q in %s", p.outputInfo.Type, timeout)
+		}
+	}
+}
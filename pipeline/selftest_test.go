@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfTest(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		output OutputPlugin
+		isOK   bool
+	}{
+		{name: "delivered", output: &previewOutputStub{}, isOK: true},
+		{name: "stuck", output: &outputStub{}, isOK: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+			p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+			p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: tt.output}})
+			p.Start()
+			defer p.Stop()
+
+			err := p.SelfTest(100 * time.Millisecond)
+			if tt.isOK {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, p.GetEventsTotal(), "marker isn't committed")
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceFieldPrefix marks top level fields of events as service ones, e.g. `_offset`,
+// they carry operational metadata for debugging and outputs may strip them before encoding.
+const ServiceFieldPrefix = "_"
+
+const (
+	ServiceFieldOffset     = "_offset"
+	ServiceFieldSourceName = "_source_name"
+)
+
+// ServiceFieldsMode defines whether the output gets service fields of events.
+type ServiceFieldsMode int
+
+const (
+	ServiceFieldsKeep ServiceFieldsMode = iota
+	ServiceFieldsStrip
+)
+
+func ParseServiceFieldsMode(mode string) (ServiceFieldsMode, error) {
+	switch mode {
+	case "", "keep":
+		return ServiceFieldsKeep, nil
+	case "strip":
+		return ServiceFieldsStrip, nil
+	default:
+		return ServiceFieldsKeep, fmt.Errorf(`unknown service fields mode %q, should be one of "keep", "strip"`, mode)
+	}
+}
+
+// addServiceFields sets the offset and the source name of the event, opaque events don't have fields.
+func addServiceFields(event *Event) {
+	if event.isOpaque || !event.Root.IsObject() {
+		return
+	}
+
+	event.Root.AddFieldNoAlloc(event.Root, ServiceFieldOffset).MutateToInt(int(event.Offset))
+	event.Root.AddFieldNoAlloc(event.Root, ServiceFieldSourceName).MutateToString(event.SourceName)
+}
+
+// stripServiceFields removes top level fields with the service prefix, nested fields are kept.
+func stripServiceFields(event *Event) {
+	if event.isOpaque || !event.Root.IsObject() {
+		return
+	}
+
+	// the removed field is replaced with the last one, so fields are checked from the end
+	fields := event.Root.AsFields()
+	for i := len(fields) - 1; i >= 0; i-- {
+		if strings.HasPrefix(fields[i].AsString(), ServiceFieldPrefix) {
+			fields[i].AsFieldValue().Suicide()
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripServiceFields(t *testing.T) {
+	event := newEvent()
+	require.NoError(t, event.Root.DecodeString(`{"_offset":1,"a":"b","_source_name":"x","c":{"_d":1},"_e":null}`))
+
+	stripServiceFields(event)
+	assert.Equal(t, `{"c":{"_d":1},"a":"b"}`, event.Root.EncodeToString(), "wrong fields are stripped")
+}
+
+func TestAddServiceFields(t *testing.T) {
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, AddServiceFields: true}, prometheus.NewRegistry())
+	p.SetFixtureMode()
+	p.Start()
+	defer p.Stop()
+
+	events, err := p.RunFixture([][]byte{[]byte(`{"a":1}`), []byte(`"not an object"`)}, time.Second)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Contains(t, events[0], `"_offset":`, "offset isn't added")
+	assert.Contains(t, events[0], `"_source_name":"fixture"`, "source name isn't added")
+	assert.Equal(t, `"not an object"`, events[1], "service fields are added to the value")
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"regexp"
+	"sync"
+)
+
+// Every processor has its own instances of actions, so values built in `Start`,
+// e.g. compiled regexps and pattern tables, are duplicated for every processor.
+// Immutable values should be built once and shared by all instances instead.
+var (
+	sharedMu     = &sync.Mutex{}
+	sharedValues = map[string]*sharedValue{}
+)
+
+type sharedValue struct {
+	once  *sync.Once
+	value interface{}
+	err   error
+}
+
+// LoadShared returns the value of the key built once for all action instances of all pipelines,
+// the value must be immutable or safe for concurrent use. Concurrent calls with the same key wait for the build.
+// The value isn't kept if the build fails, so the next call builds it again.
+func LoadShared(key string, build func() (interface{}, error)) (interface{}, error) {
+	sharedMu.Lock()
+	shared, has := sharedValues[key]
+	if !has {
+		shared = &sharedValue{once: &sync.Once{}}
+		sharedValues[key] = shared
+	}
+	sharedMu.Unlock()
+
+	shared.once.Do(func() {
+		shared.value, shared.err = build()
+	})
+
+	if shared.err != nil {
+		sharedMu.Lock()
+		if sharedValues[key] == shared {
+			delete(sharedValues, key)
+		}
+		sharedMu.Unlock()
+	}
+
+	return shared.value, shared.err
+}
+
+// CompileRegexp compiles the pattern once for all action instances, `regexp.Regexp` is safe for concurrent use.
+func CompileRegexp(pattern string) (*regexp.Regexp, error) {
+	value, err := LoadShared("regexp:"+pattern, func() (interface{}, error) {
+		return regexp.Compile(pattern)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*regexp.Regexp), nil
+}
+
+// MustCompileRegexp is like `CompileRegexp` but panics if the pattern can't be compiled.
+func MustCompileRegexp(pattern string) *regexp.Regexp {
+	re, err := CompileRegexp(pattern)
+	if err != nil {
+		panic(`regexp: Compile(` + pattern + `): ` + err.Error())
+	}
+
+	return re
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestLoadShared(t *testing.T) {
+	builds := atomic.Int32{}
+	build := func() (interface{}, error) {
+		builds.Inc()
+		return &struct{}{}, nil
+	}
+
+	values := make([]interface{}, 16)
+	wg := &sync.WaitGroup{}
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, err := LoadShared("test_shared", build)
+			assert.NoError(t, err)
+			values[i] = value
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), builds.Load(), "value should be built once")
+	for _, value := range values {
+		assert.Same(t, values[0], value, "value isn't shared")
+	}
+
+	_, err := LoadShared("test_shared_err", func() (interface{}, error) {
+		return nil, errors.New("build error")
+	})
+	require.Error(t, err)
+
+	value, err := LoadShared("test_shared_err", build)
+	require.NoError(t, err, "failed build shouldn't be kept")
+	assert.NotNil(t, value)
+}
+
+func TestCompileRegexp(t *testing.T) {
+	re, err := CompileRegexp(`^a+$`)
+	require.NoError(t, err)
+	assert.True(t, re.MatchString("aaa"))
+
+	again, err := CompileRegexp(`^a+$`)
+	require.NoError(t, err)
+	assert.Same(t, re, again, "regexp isn't shared")
+
+	_, err = CompileRegexp(`(`)
+	assert.Error(t, err)
+	assert.Panics(t, func() { MustCompileRegexp(`(`) })
+}
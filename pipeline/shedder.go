@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// shedder counts events which are older than the max age and are dropped instead of retrying their delivery,
+// so a stuck output doesn't pin ancient data forever. Shed events are written to the dead letter file if it's set.
+type shedder struct {
+	deadLetter *deadLetter
+
+	shedEvents *prometheus.CounterVec
+}
+
+// eventShedder is implemented by the pipeline, so outputs don't have to pass the settings to the batcher.
+type eventShedder interface {
+	maxEventAge() time.Duration
+	shed(outputType string, events []*Event)
+}
+
+func newShedder(pipelineName string, deadLetter *deadLetter, registry prometheus.Registerer) *shedder {
+	s := &shedder{
+		deadLetter: deadLetter,
+		shedEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "shed_events_total",
+			Help:      "events which are dropped by the output instead of retrying since they're older than max_event_age",
+		}, []string{"output"}),
+	}
+
+	registry.MustRegister(s.shedEvents)
+
+	return s
+}
+
+// hasExpired returns true if any event is older than the max age, synthetic events don't have the age.
+func hasExpired(events []*Event, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 {
+		return false
+	}
+
+	for _, event := range events {
+		if event.synthetic || event.receivedAt.IsZero() {
+			continue
+		}
+		if now.Sub(event.receivedAt) > maxAge {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *shedder) shed(outputType string, events []*Event) {
+	s.shedEvents.WithLabelValues(outputType).Add(float64(len(events)))
+	s.deadLetter.writeEvents(events)
+}
@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+type shedderTail struct {
+	batcherTail
+	shedder *shedder
+}
+
+func (s *shedderTail) maxEventAge() time.Duration {
+	return time.Minute
+}
+
+func (s *shedderTail) shed(outputType string, events []*Event) {
+	s.shedder.shed(outputType, events)
+}
+
+func TestHasExpired(t *testing.T) {
+	now := time.Now()
+	fresh := &Event{receivedAt: now.Add(-time.Second)}
+	old := &Event{receivedAt: now.Add(-time.Hour)}
+	synthetic := &Event{synthetic: true}
+
+	assert.False(t, hasExpired([]*Event{fresh, synthetic}, time.Minute, now), "fresh events are expired")
+	assert.True(t, hasExpired([]*Event{fresh, old}, time.Minute, now), "old events aren't expired")
+	assert.False(t, hasExpired([]*Event{old}, 0, now), "events are expired without max age")
+}
+
+func TestShedExpiredBatch(t *testing.T) {
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letter.log")
+	deadLetter := newDeadLetter(deadLetterFile, nil)
+	defer deadLetter.stop()
+	s := newShedder("test", deadLetter, prometheus.NewRegistry())
+
+	committed := atomic.Int32{}
+	tail := &shedderTail{batcherTail: batcherTail{commit: func(*Event) { committed.Inc() }}, shedder: s}
+
+	attempts := atomic.Int32{}
+	out := func(_ *WorkerData, batch *Batch) {
+		// the output is down, so it retries until the batch is expired
+		for attempt := 0; ; attempt++ {
+			if attempt > 0 && batch.IsExpired() {
+				break
+			}
+			attempts.Inc()
+		}
+	}
+
+	batcher := NewBatcher("test", "stub", out, nil, tail, 1, 2, time.Second, 0)
+	batcher.Start()
+	defer batcher.Stop()
+
+	for _, message := range []string{"first", "second"} {
+		event := newEvent()
+		require.NoError(t, event.parseJSON([]byte(`{"message":"`+message+`"}`)))
+		event.receivedAt = time.Now().Add(-time.Hour)
+		batcher.Add(event)
+	}
+
+	require.Eventually(t, func() bool { return committed.Load() == 2 }, 5*time.Second, time.Millisecond, "shed events aren't committed")
+	assert.Equal(t, int32(1), attempts.Load(), "expired batch is retried")
+	assert.Equal(t, float64(2), testutil.ToFloat64(s.shedEvents.WithLabelValues("stub")), "wrong shed events metric")
+
+	data, err := os.ReadFile(deadLetterFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"message":"first"}`, `{"message":"second"}`}, strings.Fields(string(data)), "wrong dead letter events")
+}
@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+)
+
+type skipReason int
+
+const (
+	skipReasonDecodeError skipReason = iota
+	skipReasonAntispam
+	skipReasonOversizedJSON
+	skipReasonDuplicate
+	skipReasonOversizedEvent
+	skipReasonRateLimit
+	skipReasonStopped
+	skipReasonsCount
+)
+
+const (
+	// skipStatsIdleTimeout is how long the stats of the source are kept since its last skipped line
+	skipStatsIdleTimeout = 10 * time.Minute
+	// skipStatsMaxSources limits sources with stats, the least recently skipped ones are evicted over the limit
+	skipStatsMaxSources = 10000
+)
+
+var skipReasonNames = [skipReasonsCount]string{"decode_error", "antispam", "oversized_json", "duplicate", "oversized_event", "rate_limit", "stopped"}
+
+// skipStats counts lines and bytes skipped by the pipeline before processing.
+// Metrics are labeled only by the reason to keep cardinality low,
+// the per source stats are available via the `/pipelines/<pipeline_name>/skipped` endpoint.
+// Stats of idle sources are dropped by the maintenance, so removed files don't pile up.
+type skipStats struct {
+	mu      *sync.RWMutex
+	sources map[SourceID]*sourceSkipStats
+
+	lines *prometheus.CounterVec
+	bytes *prometheus.CounterVec
+}
+
+type sourceSkipStats struct {
+	name  string
+	lines [skipReasonsCount]atomic.Int64
+	bytes [skipReasonsCount]atomic.Int64
+	// lastSkip is the unix time in nanoseconds of the last skipped line
+	lastSkip atomic.Int64
+}
+
+type sourceSkipStatsResp struct {
+	SourceID   SourceID `json:"source_id"`
+	SourceName string   `json:"source_name"`
+	Reason     string   `json:"reason"`
+	Lines      int64    `json:"lines"`
+	Bytes      int64    `json:"bytes"`
+}
+
+func newSkipStats(pipelineName string, registry prometheus.Registerer) *skipStats {
+	s := &skipStats{
+		mu:      &sync.RWMutex{},
+		sources: make(map[SourceID]*sourceSkipStats),
+		lines: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "skipped_lines_total",
+			Help:      "how many lines are skipped by the pipeline before processing",
+		}, []string{"reason"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "file_d",
+			Subsystem: "pipeline_" + pipelineName,
+			Name:      "skipped_bytes_total",
+			Help:      "how many bytes are skipped by the pipeline before processing",
+		}, []string{"reason"}),
+	}
+
+	registry.MustRegister(s.lines, s.bytes)
+
+	return s
+}
+
+func (s *skipStats) add(id SourceID, name string, reason skipReason, size int) {
+	s.mu.RLock()
+	stats, has := s.sources[id]
+	s.mu.RUnlock()
+
+	if !has {
+		s.mu.Lock()
+		stats, has = s.sources[id]
+		if !has {
+			stats = &sourceSkipStats{name: name}
+			s.sources[id] = stats
+		}
+		s.mu.Unlock()
+	}
+
+	stats.lines[reason].Inc()
+	stats.bytes[reason].Add(int64(size))
+	stats.lastSkip.Store(time.Now().UnixNano())
+
+	reasonName := skipReasonNames[reason]
+	s.lines.WithLabelValues(reasonName).Inc()
+	s.bytes.WithLabelValues(reasonName).Add(float64(size))
+}
+
+// maintenance drops stats of sources which haven't skipped lines for a while
+// and evicts the least recently skipped sources over the limit.
+func (s *skipStats) maintenance(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idleSince := now.Add(-skipStatsIdleTimeout).UnixNano()
+	for id, stats := range s.sources {
+		if stats.lastSkip.Load() < idleSince {
+			delete(s.sources, id)
+		}
+	}
+
+	if len(s.sources) <= skipStatsMaxSources {
+		return
+	}
+
+	ids := make([]SourceID, 0, len(s.sources))
+	for id := range s.sources {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return s.sources[ids[i]].lastSkip.Load() < s.sources[ids[j]].lastSkip.Load()
+	})
+	for _, id := range ids[:len(ids)-skipStatsMaxSources] {
+		delete(s.sources, id)
+	}
+}
+
+func (s *skipStats) dump() []sourceSkipStatsResp {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]sourceSkipStatsResp, 0)
+	for id, stats := range s.sources {
+		for reason := skipReason(0); reason < skipReasonsCount; reason++ {
+			lines := stats.lines[reason].Load()
+			if lines == 0 {
+				continue
+			}
+
+			result = append(result, sourceSkipStatsResp{
+				SourceID:   id,
+				SourceName: stats.name,
+				Reason:     skipReasonNames[reason],
+				Lines:      lines,
+				Bytes:      stats.bytes[reason].Load(),
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Bytes != result[j].Bytes {
+			return result[i].Bytes > result[j].Bytes
+		}
+		return result[i].SourceID < result[j].SourceID
+	})
+
+	return result
+}
+
+// serveSkipped returns the per source stats sorted by skipped bytes.
+func (s *skipStats) serveSkipped(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	resp, _ := json.Marshal(s.dump())
+	_, _ = w.Write(resp)
+}
@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipStats(t *testing.T) {
+	s := newSkipStats("test", prometheus.NewRegistry())
+
+	s.add(1, "a.log", skipReasonDecodeError, 10)
+	s.add(1, "a.log", skipReasonDecodeError, 5)
+	s.add(1, "a.log", skipReasonAntispam, 100)
+	s.add(2, "b.log", skipReasonDecodeError, 20)
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(s.lines.WithLabelValues("decode_error")))
+	assert.Equal(t, float64(35), testutil.ToFloat64(s.bytes.WithLabelValues("decode_error")))
+	assert.Equal(t, float64(100), testutil.ToFloat64(s.bytes.WithLabelValues("antispam")))
+
+	w := httptest.NewRecorder()
+	s.serveSkipped(w, httptest.NewRequest("GET", "/pipelines/test/skipped", nil))
+
+	expected := `[` +
+		`{"source_id":1,"source_name":"a.log","reason":"antispam","lines":1,"bytes":100},` +
+		`{"source_id":2,"source_name":"b.log","reason":"decode_error","lines":1,"bytes":20},` +
+		`{"source_id":1,"source_name":"a.log","reason":"decode_error","lines":2,"bytes":15}` +
+		`]`
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestSkipStatsMaintenance(t *testing.T) {
+	s := newSkipStats("test", prometheus.NewRegistry())
+
+	s.add(1, "a.log", skipReasonDecodeError, 10)
+	s.add(2, "b.log", skipReasonDecodeError, 20)
+	s.sources[1].lastSkip.Store(time.Now().Add(-2 * skipStatsIdleTimeout).UnixNano())
+
+	s.maintenance(time.Now())
+	assert.Equal(t, 1, len(s.sources), "idle source isn't dropped")
+	assert.NotNil(t, s.sources[2], "active source is dropped")
+	assert.Equal(t, float64(2), testutil.ToFloat64(s.lines.WithLabelValues("decode_error")), "metrics shouldn't be reset")
+
+	for i := 0; i < skipStatsMaxSources+10; i++ {
+		s.add(SourceID(i+10), "c.log", skipReasonAntispam, 1)
+		s.sources[SourceID(i+10)].lastSkip.Store(int64(i) + time.Now().UnixNano())
+	}
+
+	s.maintenance(time.Now())
+	assert.Equal(t, skipStatsMaxSources, len(s.sources), "sources over the limit aren't evicted")
+	assert.Nil(t, s.sources[2], "least recently skipped source isn't evicted")
+	assert.NotNil(t, s.sources[SourceID(skipStatsMaxSources+19)], "most recently skipped source is evicted")
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+type spawnFn = func(parent *Event, root *insaneJSON.Node) *Event
+
+// spawn creates the event derived from the parent by the action, nil is returned if the document can't be copied.
+// Spawned events are synthetic, the offset of the parent is committed instead once the parent
+// and all its spawned events are finalized, so the input doesn't skip lines which aren't delivered yet.
+func (p *Pipeline) spawn(parent *Event, root *insaneJSON.Node) *Event {
+	event := p.eventPool.get()
+	event.Buf = root.Encode(event.Buf[:0])
+	if err := event.parseJSON(event.Buf); err != nil {
+		p.logger.Errorf("can't decode spawned event: %s", err.Error())
+		p.eventPool.back(event)
+		return nil
+	}
+
+	event.synthetic = true
+	event.SeqID = parent.SeqID
+	event.Offset = parent.Offset
+	event.SourceID = parent.SourceID
+	event.SourceName = parent.SourceName
+	event.streamName = parent.streamName
+	event.stream = parent.stream
+	event.receivedAt = parent.receivedAt
+	event.Size = len(event.Buf)
+	event.action = parent.action + 1
+	for key, value := range parent.Meta {
+		event.SetMeta(key, value)
+	}
+
+	// timeout events aren't finalized, so there is nothing to wait for
+	if parent.IsTimeoutKind() {
+		return event
+	}
+
+	// the parent is counted too, since it's finalized by the action after spawning
+	if parent.pendingSpawned.Load() == 0 {
+		parent.pendingSpawned.Store(1)
+	}
+	parent.pendingSpawned.Inc()
+	event.parent = parent
+
+	return event
+}
+
+// releaseSpawned accounts the finalized member of the family of spawned events,
+// the parent is finalized after the last one and it's committed if any of them is committed.
+func (p *Pipeline) releaseSpawned(parent *Event, isCommitted bool) {
+	if isCommitted {
+		parent.spawnedCommitted.Store(true)
+	}
+	if parent.pendingSpawned.Dec() != 0 {
+		return
+	}
+
+	p.finalize(parent, parent.spawnedCommitted.Load(), true)
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// splitActionStub spawns an event for each element of the `messages` array and discards the parent.
+type splitActionStub struct {
+	controller ActionPluginController
+}
+
+func (a *splitActionStub) Start(_ AnyConfig, params *ActionPluginParams) {
+	a.controller = params.Controller
+}
+func (a *splitActionStub) Stop() {}
+
+func (a *splitActionStub) Do(event *Event) ActionResult {
+	messages := event.Root.Dig("messages")
+	if messages == nil {
+		return ActionPass
+	}
+
+	for _, node := range messages.AsArray() {
+		a.controller.Spawn(event, node)
+	}
+
+	return ActionDiscard
+}
+
+func TestSpawn(t *testing.T) {
+	input := &committingInputStub{}
+	output := &queueOutputStub{}
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: input}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: output}})
+	p.AddAction(&ActionPluginStaticInfo{
+		PluginStaticInfo: &PluginStaticInfo{
+			Type: "split",
+			Factory: func() (AnyPlugin, AnyConfig) {
+				return &splitActionStub{}, nil
+			},
+		},
+		MatchConditions: MatchConditions{},
+	})
+	p.Start()
+	defer p.Stop()
+
+	p.In(1, "test.log", 10, []byte(`{"messages":[{"message":"a"},{"message":"b"},{"message":"c"}]}`+"\n"), false)
+
+	require.Eventually(t, func() bool { return len(output.collected()) == 3 }, 5*time.Second, 10*time.Millisecond, "spawned events aren't passed")
+	assert.Equal(t, []string{"a", "b", "c"}, output.collected(), "wrong spawned events")
+
+	output.mu.Lock()
+	last := output.events[2]
+	output.events = output.events[:2]
+	output.mu.Unlock()
+
+	// the parent waits for all spawned events, so it isn't committed yet
+	output.commitAll()
+	assert.Equal(t, int64(0), input.committed.Load(), "parent is committed before spawned events")
+	assert.Equal(t, int64(2), p.eventPool.inUse(), "wrong count of events in use")
+
+	output.controller.Commit(last)
+	assert.Equal(t, int64(1), input.committed.Load(), "parent isn't committed")
+	assert.Equal(t, int64(0), p.eventPool.inUse(), "events aren't back to the pool")
+}
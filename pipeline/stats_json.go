@@ -0,0 +1,181 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pipelineStatsResp is the response of `/pipelines/<pipeline_name>/stats.json`.
+type pipelineStatsResp struct {
+	Pipeline      string             `json:"pipeline"`
+	Events        eventsStatsResp    `json:"events"`
+	Rates         ratesStatsResp     `json:"rates"`
+	Pool          poolStatsResp      `json:"pool"`
+	Procs         procsStatsResp     `json:"procs"`
+	Actions       []actionStatsResp  `json:"actions"`
+	Streams       []streamStatsResp  `json:"streams"`
+	BannedSources []bannedSourceResp `json:"banned_sources"`
+}
+
+type eventsStatsResp struct {
+	In       int64 `json:"in"`
+	Out      int64 `json:"out"`
+	OutBytes int64 `json:"out_bytes"`
+}
+
+type ratesStatsResp struct {
+	In    float64 `json:"in"`
+	Out   float64 `json:"out"`
+	OutMb float64 `json:"out_mb"`
+}
+
+type poolStatsResp struct {
+	Capacity int   `json:"capacity"`
+	InUse    int64 `json:"in_use"`
+}
+
+type procsStatsResp struct {
+	Active int32 `json:"active"`
+	Total  int32 `json:"total"`
+}
+
+type actionStatsResp struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+	// Counters are counts of events by the status, they're collected only if `metric_name` of the action is set
+	Counters map[string]uint64 `json:"counters,omitempty"`
+}
+
+type streamStatsResp struct {
+	Stream     string  `json:"stream"`
+	Queued     int     `json:"queued"`
+	LagSeconds float64 `json:"lag_seconds"`
+}
+
+type bannedSourceResp struct {
+	ID   SourceID `json:"id"`
+	Name string   `json:"name"`
+}
+
+// rateMeter keeps the rates of the last maintenance interval for the stats endpoint.
+type rateMeter struct {
+	mu *sync.Mutex
+
+	lastAt   time.Time
+	lastIn   int64
+	lastOut  int64
+	lastSize int64
+
+	rates ratesStatsResp
+}
+
+func newRateMeter() *rateMeter {
+	return &rateMeter{
+		mu:     &sync.Mutex{},
+		lastAt: time.Now(),
+	}
+}
+
+func (m *rateMeter) update(now time.Time, in int64, out int64, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := now.Sub(m.lastAt).Seconds()
+	if seconds > 0 {
+		m.rates = ratesStatsResp{
+			In:    roundStat(float64(in-m.lastIn) / seconds),
+			Out:   roundStat(float64(out-m.lastOut) / seconds),
+			OutMb: roundStat(float64(size-m.lastSize) / seconds / 1024 / 1024),
+		}
+	}
+
+	m.lastAt = now
+	m.lastIn = in
+	m.lastOut = out
+	m.lastSize = size
+}
+
+func (m *rateMeter) get() ratesStatsResp {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rates
+}
+
+func (p *Pipeline) collectStatsResp(now time.Time) *pipelineStatsResp {
+	resp := &pipelineStatsResp{
+		Pipeline: p.Name,
+		Events: eventsStatsResp{
+			In:       p.totalIn.Load(),
+			Out:      p.totalCommitted.Load(),
+			OutBytes: p.totalSize.Load(),
+		},
+		Rates: p.rates.get(),
+		Pool: poolStatsResp{
+			Capacity: int(p.eventPool.capacity.Load()),
+			InUse:    p.eventPool.inUse(),
+		},
+		Procs: procsStatsResp{
+			Active: p.activeProcs.Load(),
+			Total:  p.procCount.Load(),
+		},
+		Actions:       make([]actionStatsResp, 0, len(p.actionInfos)),
+		Streams:       make([]streamStatsResp, 0),
+		BannedSources: p.antispamer.bannedSources(),
+	}
+
+	for i, info := range p.actionInfos {
+		resp.Actions = append(resp.Actions, actionStatsResp{
+			Index:    i + 1,
+			Type:     info.Type,
+			Counters: p.actionCounters(info.MetricName),
+		})
+	}
+
+	events, seconds := collectStreamLag(p.streamer, now)
+	for name, queued := range events {
+		resp.Streams = append(resp.Streams, streamStatsResp{
+			Stream:     string(name),
+			Queued:     queued,
+			LagSeconds: roundStat(seconds[name]),
+		})
+	}
+	sort.Slice(resp.Streams, func(i, j int) bool { return resp.Streams[i].Stream < resp.Streams[j].Stream })
+
+	return resp
+}
+
+// actionCounters returns counts of events by the status of the action metric, nil if the metric isn't set.
+func (p *Pipeline) actionCounters(metricName string) map[string]uint64 {
+	if metricName == "" {
+		return nil
+	}
+
+	for _, m := range p.metricsHolder.metrics {
+		if m.name != metricName {
+			continue
+		}
+
+		counters := make(map[string]uint64)
+		for _, status := range []eventStatus{eventStatusReceived, eventStatusDiscarded, eventStatusPassed} {
+			if c := m.current.totalCounter[string(status)]; c != nil {
+				counters[string(status)] = c.Load()
+			} else {
+				counters[string(status)] = 0
+			}
+		}
+		return counters
+	}
+
+	return nil
+}
+
+func (p *Pipeline) serveStatsJSON(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	resp, _ := json.Marshal(p.collectStatsResp(time.Now()))
+	_, _ = w.Write(resp)
+}
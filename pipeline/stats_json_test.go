@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestServeStatsJSON(t *testing.T) {
+	p := New("test", &Settings{Decoder: "raw", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.actionInfos = append(p.actionInfos, &ActionPluginStaticInfo{PluginStaticInfo: &PluginStaticInfo{Type: "discard"}})
+
+	// processors are created on the start
+	p.procCount = atomic.NewInt32(2)
+	p.activeProcs = atomic.NewInt32(1)
+
+	p.totalIn.Store(20)
+	p.totalCommitted.Store(10)
+	p.totalSize.Store(1024)
+	p.rates.update(p.rates.lastAt.Add(2*time.Second), 20, 10, 1024)
+
+	p.streamer.putEvent(1, "stdout", &Event{receivedAt: time.Now()})
+	p.streamer.putEvent(2, "stdout", &Event{receivedAt: time.Now()})
+	p.antispamer.ban(3, "noisy.log")
+
+	w := httptest.NewRecorder()
+	p.serveStatsJSON(w, httptest.NewRequest("GET", "/pipelines/test/stats.json", nil))
+	assert.Equal(t, 200, w.Code, "wrong status")
+
+	resp := &pipelineStatsResp{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp), "response isn't json")
+
+	assert.Equal(t, "test", resp.Pipeline, "wrong pipeline")
+	assert.Equal(t, eventsStatsResp{In: 20, Out: 10, OutBytes: 1024}, resp.Events, "wrong events")
+	assert.Equal(t, float64(10), resp.Rates.In, "wrong in rate")
+	assert.Equal(t, float64(5), resp.Rates.Out, "wrong out rate")
+	assert.Equal(t, 8, resp.Pool.Capacity, "wrong pool capacity")
+	assert.Equal(t, procsStatsResp{Active: 1, Total: 2}, resp.Procs, "wrong procs")
+	require.Equal(t, 1, len(resp.Actions), "wrong actions")
+	assert.Equal(t, actionStatsResp{Index: 1, Type: "discard"}, resp.Actions[0], "counters are set without the metric")
+	require.Equal(t, 1, len(resp.Streams), "wrong streams")
+	assert.Equal(t, 2, resp.Streams[0].Queued, "queues of streams aren't summed")
+	assert.Equal(t, []bannedSourceResp{{ID: 3, Name: "noisy.log"}}, resp.BannedSources, "wrong banned sources")
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// StatsLogText logs pipeline stats as structured fields of the regular log line.
+	StatsLogText = "text"
+	// StatsLogJSON writes pipeline stats as a separate JSON line, so log parsers don't have to parse the log format.
+	StatsLogJSON = "json"
+	// StatsLogOff disables the stats log, metrics are exposed anyway.
+	StatsLogOff = "off"
+)
+
+// pipelineStats is the stats of the pipeline for the last stats interval.
+type pipelineStats struct {
+	Time        string  `json:"time"`
+	Pipeline    string  `json:"pipeline"`
+	Interval    float64 `json:"interval_seconds"`
+	ActiveProcs int32   `json:"active_procs"`
+	Procs       int32   `json:"procs"`
+	Queue       int     `json:"queue"`
+	Capacity    int     `json:"capacity"`
+	OutEvents   int64   `json:"out_events"`
+	OutMb       float64 `json:"out_mb"`
+	Rate        float64 `json:"rate"`
+	RateMb      float64 `json:"rate_mb"`
+	TotalEvents int64   `json:"total_events"`
+	TotalMb     float64 `json:"total_mb"`
+	AvgSize     int64   `json:"avg_size"`
+	MaxSize     int     `json:"max_size"`
+}
+
+// statsLog logs the stats of the pipeline once per interval.
+type statsLog struct {
+	format   string
+	interval time.Duration
+	out      io.Writer
+
+	lastAt        time.Time
+	lastCommitted int64
+	lastSize      int64
+}
+
+func newStatsLog(format string, interval time.Duration, out io.Writer) *statsLog {
+	if format == "" {
+		format = StatsLogText
+	}
+
+	return &statsLog{
+		format:   format,
+		interval: interval,
+		out:      out,
+		lastAt:   time.Now(),
+	}
+}
+
+// isDue returns true if the interval is over, it's called on each maintenance.
+func (l *statsLog) isDue(now time.Time) bool {
+	return l.format != StatsLogOff && now.Sub(l.lastAt) >= l.interval
+}
+
+// collect calculates the stats since the last call from total counters.
+func (l *statsLog) collect(now time.Time, totalCommitted int64, totalSize int64) pipelineStats {
+	interval := now.Sub(l.lastAt)
+	deltaCommitted := totalCommitted - l.lastCommitted
+	deltaSize := totalSize - l.lastSize
+	l.lastAt = now
+	l.lastCommitted = totalCommitted
+	l.lastSize = totalSize
+
+	tc := totalCommitted
+	if tc == 0 {
+		tc = 1
+	}
+
+	stats := pipelineStats{
+		Time:        now.Format(time.RFC3339),
+		Interval:    roundStat(interval.Seconds()),
+		OutEvents:   deltaCommitted,
+		OutMb:       roundStat(float64(deltaSize) / 1024 / 1024),
+		TotalEvents: totalCommitted,
+		TotalMb:     roundStat(float64(totalSize) / 1024 / 1024),
+		AvgSize:     totalSize / tc,
+	}
+	if interval > 0 {
+		stats.Rate = roundStat(float64(deltaCommitted) / interval.Seconds())
+		stats.RateMb = roundStat(float64(deltaSize) / interval.Seconds() / 1024 / 1024)
+	}
+
+	return stats
+}
+
+func (l *statsLog) write(logger *zap.SugaredLogger, stats pipelineStats) {
+	switch l.format {
+	case StatsLogJSON:
+		data, err := json.Marshal(stats)
+		if err != nil {
+			logger.Errorf("can't encode pipeline stats: %s", err.Error())
+			return
+		}
+		_, _ = l.out.Write(append(data, '\n'))
+	default:
+		logger.Infow("pipeline stats",
+			"pipeline", stats.Pipeline,
+			"interval_seconds", stats.Interval,
+			"active_procs", stats.ActiveProcs,
+			"procs", stats.Procs,
+			"queue", stats.Queue,
+			"capacity", stats.Capacity,
+			"out_events", stats.OutEvents,
+			"out_mb", stats.OutMb,
+			"rate", stats.Rate,
+			"rate_mb", stats.RateMb,
+			"total_events", stats.TotalEvents,
+			"total_mb", stats.TotalMb,
+			"avg_size", stats.AvgSize,
+			"max_size", stats.MaxSize,
+		)
+	}
+}
+
+// roundStat keeps one decimal place, the same precision the stats had in the plain text line.
+func roundStat(value float64) float64 {
+	return float64(int64(value*10+0.5)) / 10
+}
@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsLogInterval(t *testing.T) {
+	l := newStatsLog("", 10*time.Second, nil)
+	start := l.lastAt
+
+	assert.False(t, l.isDue(start.Add(5*time.Second)), "stats are logged before the interval is over")
+	assert.True(t, l.isDue(start.Add(10*time.Second)), "stats aren't logged after the interval")
+
+	stats := l.collect(start.Add(10*time.Second), 100, 10*1024*1024)
+	assert.Equal(t, float64(10), stats.Interval, "wrong interval")
+	assert.Equal(t, float64(10), stats.Rate, "wrong rate")
+	assert.Equal(t, float64(1), stats.RateMb, "wrong rate in megabytes")
+	assert.Equal(t, int64(10*1024*1024/100), stats.AvgSize, "wrong avg size")
+
+	stats = l.collect(start.Add(20*time.Second), 150, 10*1024*1024)
+	assert.Equal(t, int64(50), stats.OutEvents, "delta isn't calculated from the last stats")
+	assert.Equal(t, int64(150), stats.TotalEvents, "wrong total")
+
+	assert.False(t, newStatsLog(StatsLogOff, 0, nil).isDue(start.Add(time.Hour)), "disabled stats are logged")
+}
+
+func TestStatsLogJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := newStatsLog(StatsLogJSON, 0, out)
+
+	stats := l.collect(l.lastAt.Add(time.Second), 10, 1024)
+	stats.Pipeline = "test"
+	l.write(logger.Instance, stats)
+
+	result := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &result), "stats line isn't json")
+	assert.Equal(t, "test", result["pipeline"], "wrong pipeline")
+	assert.Equal(t, float64(10), result["out_events"], "wrong out events")
+	assert.Equal(t, byte('\n'), out.Bytes()[out.Len()-1], "stats line isn't terminated")
+}
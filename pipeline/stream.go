@@ -17,7 +17,11 @@ var eventWaitTimeout = time.Second * 30
 type stream struct {
 	chargeIndex int
 	blockIndex  int
+	queue       int // index of the streamer queue the stream is charged to
+	proc        int // id of the processor the stream is attached to
 	len         int
+	quota       int // how many events may be taken in one turn, 0 means no limit
+	taken       int // how many events are taken in the current turn
 	currentSeq  uint64
 	commitSeq   uint64
 	awaySeq     uint64
@@ -27,6 +31,7 @@ type stream struct {
 	sourceName string
 	streamer   *streamer
 	blockTime  time.Time
+	timeout    time.Duration // how long the blocked stream waits for the timeout event, 0 means eventWaitTimeout
 
 	mu   *sync.Mutex
 	cond *sync.Cond
@@ -90,6 +95,21 @@ func (s *stream) tryDetach() {
 	}
 }
 
+// attachedProc returns the id of the attached processor and the count of queued events,
+// false is returned if the stream isn't attached.
+func (s *stream) attachedProc() (int, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.proc, s.len, s.isAttached
+}
+
+func (s *stream) setProc(id int) {
+	s.mu.Lock()
+	s.proc = id
+	s.mu.Unlock()
+}
+
 func (s *stream) attach() {
 	s.mu.Lock()
 	if s.isAttached {
@@ -103,6 +123,7 @@ func (s *stream) attach() {
 	}
 
 	s.isAttached = true
+	s.taken = 0
 	s.mu.Unlock()
 }
 
@@ -170,7 +191,7 @@ func (s *stream) tryUnblock() bool {
 	}
 
 	s.mu.Lock()
-	if time.Now().Sub(s.blockTime) < eventWaitTimeout {
+	if time.Now().Sub(s.blockTime) < s.waitTimeout() {
 		s.mu.Unlock()
 		return false
 	}
@@ -195,6 +216,53 @@ func (s *stream) tryUnblock() bool {
 	return true
 }
 
+// setTimeout changes the timeout of the stream, the new one is used starting from the current block.
+func (s *stream) setTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	s.timeout = timeout
+	s.mu.Unlock()
+}
+
+func (s *stream) waitTimeout() time.Duration {
+	if s.timeout > 0 {
+		return s.timeout
+	}
+
+	return eventWaitTimeout
+}
+
+// isExhausted returns true if the attached processor has taken the quota of events in the current turn.
+func (s *stream) isExhausted() bool {
+	return s.quota > 0 && s.taken >= s.quota
+}
+
+// yield detaches the stream before it's drained, it's charged again once taken events are committed.
+func (s *stream) yield() {
+	s.mu.Lock()
+	s.leave()
+	s.mu.Unlock()
+}
+
+// lag returns the number of queued events and the receive time of the first one.
+func (s *stream) lag() (int, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.first == nil {
+		return s.len, time.Time{}
+	}
+
+	return s.len, s.first.receivedAt
+}
+
+// queued returns the number of events waiting for the processor.
+func (s *stream) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.len
+}
+
 func (s *stream) get() *Event {
 	if s.isDetaching {
 		logger.Panicf("why get while detaching?")
@@ -212,6 +280,7 @@ func (s *stream) get() *Event {
 	if event != nil {
 		event.stage = eventStageProcessor
 		s.len--
+		s.taken++
 	}
 
 	return event
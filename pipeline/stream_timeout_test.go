@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countActionStub holds the first event of the stream and collapses next ones,
+// the held event gets the count of events and is propagated on the timeout.
+type countActionStub struct {
+	controller ActionPluginController
+	held       *Event
+	count      int
+}
+
+func (a *countActionStub) Start(_ AnyConfig, params *ActionPluginParams) {
+	a.controller = params.Controller
+}
+func (a *countActionStub) Stop() {}
+
+func (a *countActionStub) Do(event *Event) ActionResult {
+	if event.IsTimeoutKind() {
+		held := a.held
+		held.Root.AddFieldNoAlloc(held.Root, "message").MutateToString(strconv.Itoa(a.count))
+		a.held = nil
+		a.count = 0
+		a.controller.Propagate(held)
+		return ActionDiscard
+	}
+
+	a.count++
+	if a.held != nil {
+		return ActionCollapse
+	}
+
+	a.controller.SetTimeout(event, 100*time.Millisecond)
+	a.held = event
+	return ActionHold
+}
+
+func TestStreamTimeout(t *testing.T) {
+	output := &collectingOutputStub{}
+	p := New("test", &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour}, prometheus.NewRegistry())
+	p.SetInput(&InputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &inputStub{}}})
+	p.SetOutput(&OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "stub"}, PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: output}})
+	p.AddAction(&ActionPluginStaticInfo{
+		PluginStaticInfo: &PluginStaticInfo{
+			Type: "count",
+			Factory: func() (AnyPlugin, AnyConfig) {
+				return &countActionStub{}, nil
+			},
+		},
+		MatchConditions: MatchConditions{},
+	})
+	p.Start()
+	defer p.Stop()
+
+	p.In(1, "test.log", 1, []byte(`{"a":1}`+"\n"), false)
+	p.In(1, "test.log", 2, []byte(`{"a":2}`+"\n"), false)
+	p.In(1, "test.log", 3, []byte(`{"a":3}`+"\n"), false)
+
+	// the default timeout is 30s, so the count is flushed only by the timeout of the stream
+	require.Eventually(t, func() bool { return len(output.collected()) == 1 }, 5*time.Second, 10*time.Millisecond, "held event isn't flushed")
+	assert.Equal(t, []string{"3"}, output.collected(), "wrong count")
+
+	p.In(1, "test.log", 4, []byte(`{"a":4}`+"\n"), false)
+	require.Eventually(t, func() bool { return len(output.collected()) == 2 }, 5*time.Second, 10*time.Millisecond, "held event isn't flushed")
+	assert.Equal(t, []string{"3", "1"}, output.collected(), "wrong count")
+}
+
+func TestStreamWaitTimeout(t *testing.T) {
+	s := newStream("test", 1, newStreamer())
+	assert.Equal(t, eventWaitTimeout, s.waitTimeout(), "wrong default timeout")
+
+	s.setTimeout(time.Second)
+	assert.Equal(t, time.Second, s.waitTimeout(), "timeout isn't set")
+
+	s.setTimeout(0)
+	assert.Equal(t, eventWaitTimeout, s.waitTimeout(), "timeout isn't reset")
+}
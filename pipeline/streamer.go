@@ -2,11 +2,13 @@ package pipeline
 
 import (
 	"fmt"
+	"regexp"
 	"sync"
 	"time"
 
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/longpanic"
+	"go.uber.org/atomic"
 )
 
 type streamer struct {
@@ -15,28 +17,69 @@ type streamer struct {
 
 	shouldStop bool
 
-	charged     []*stream
-	chargedMu   *sync.Mutex
-	chargedCond *sync.Cond
+	// queues[0] is shared by all processors, others are for streams pinned to dedicated processors
+	queues []*chargedQueue
 
 	blocked   []*stream
 	blockedMu *sync.Mutex
+
+	// fairness is nil if processors take events from a stream until it's drained
+	fairness *fairness
+
+	// stealing makes processors of pinned queues take streams from the shared queue when their own queues are empty
+	stealing bool
+}
+
+// chargedQueue keeps streams which have events to process.
+type chargedQueue struct {
+	pattern *regexp.Regexp // pattern of stream names, it's nil for the shared queue
+	charged []*stream
+	mu      *sync.Mutex
+	cond    *sync.Cond
+}
+
+func newChargedQueue(pattern *regexp.Regexp) *chargedQueue {
+	q := &chargedQueue{
+		pattern: pattern,
+		charged: make([]*stream, 0, 0),
+		mu:      &sync.Mutex{},
+	}
+	q.cond = sync.NewCond(q.mu)
+
+	return q
 }
 
 func newStreamer() *streamer {
 	streamer := &streamer{
 		streams: make(map[SourceID]map[StreamName]*stream),
 		mu:      &sync.RWMutex{},
-		charged: make([]*stream, 0, 0),
+		queues:  []*chargedQueue{newChargedQueue(nil)},
 
-		chargedMu: &sync.Mutex{},
 		blockedMu: &sync.Mutex{},
 	}
-	streamer.chargedCond = sync.NewCond(streamer.chargedMu)
 
 	return streamer
 }
 
+// pinStreams adds a queue for each pattern, streams matching the pattern are processed only by processors of the queue.
+// It should be called before any stream is created.
+func (s *streamer) pinStreams(patterns []*regexp.Regexp) {
+	for _, pattern := range patterns {
+		s.queues = append(s.queues, newChargedQueue(pattern))
+	}
+}
+
+// queueIndex returns the index of the first queue with matching pattern or the shared queue.
+func (s *streamer) queueIndex(name StreamName) int {
+	for i, q := range s.queues[1:] {
+		if q.pattern.MatchString(string(name)) {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
 func (s *streamer) start() {
 	longpanic.Go(s.heartbeat)
 }
@@ -82,37 +125,102 @@ func (s *streamer) getStream(sourceID SourceID, streamName StreamName) *stream {
 	// copy streamName because it's unsafe []byte instead of regular string
 	streamNameCopy := StreamName([]byte(streamName))
 	st = newStream(streamNameCopy, sourceID, s)
+	st.queue = s.queueIndex(streamNameCopy)
+	st.quota = s.fairness.streamQuota(streamNameCopy)
 	s.streams[sourceID][streamNameCopy] = st
 
 	return st
 }
 
 func (s *streamer) makeCharged(stream *stream) {
-	s.chargedMu.Lock()
-	s.charged = append(s.charged, stream)
-	s.chargedCond.Signal()
-	s.chargedMu.Unlock()
+	q := s.queues[stream.queue]
+	q.mu.Lock()
+	q.charged = append(q.charged, stream)
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	// idle processors of pinned queues wait on their own queues, so they're woken up to steal the stream
+	if s.stealing && stream.queue == 0 {
+		for _, pinned := range s.queues[1:] {
+			pinned.mu.Lock()
+			pinned.cond.Signal()
+			pinned.mu.Unlock()
+		}
+	}
 }
 
-// nil means that streamer is stopping
-func (s *streamer) joinStream() *stream {
-	s.chargedMu.Lock()
-	for len(s.charged) == 0 {
-		s.chargedCond.Wait()
+// nil means that streamer is stopping or the processor is retired, retired is nil if the processor can't retire
+func (s *streamer) joinStream(queue int, retired *atomic.Bool) *stream {
+	q := s.queues[queue]
+	q.mu.Lock()
+	for len(q.charged) == 0 {
+		if retired != nil && retired.Load() {
+			q.mu.Unlock()
+			return nil
+		}
+		// the lock of the own queue is kept while stealing, so the wake up from makeCharged isn't missed
+		if s.stealing && queue != 0 {
+			if stream := s.steal(); stream != nil {
+				q.mu.Unlock()
+				stream.attach()
+				return stream
+			}
+		}
+		q.cond.Wait()
 		if s.shouldStop {
-			s.chargedMu.Unlock()
+			q.mu.Unlock()
 			return nil
 		}
 	}
-	l := len(s.charged)
-	stream := s.charged[l-1]
-	s.charged = s.charged[:l-1]
-	s.chargedMu.Unlock()
+	stream := s.take(q)
+	q.mu.Unlock()
 	stream.attach()
 
 	return stream
 }
 
+// take removes the next stream from the queue, it should be called under the lock of the queue.
+func (s *streamer) take(q *chargedQueue) *stream {
+	l := len(q.charged)
+	var stream *stream
+	if s.fairness != nil {
+		// streams take turns, so the one charged earlier goes first
+		stream = q.charged[0]
+		copy(q.charged, q.charged[1:])
+		q.charged[l-1] = nil
+	} else {
+		stream = q.charged[l-1]
+	}
+	q.charged = q.charged[:l-1]
+
+	return stream
+}
+
+// steal takes the stream from the shared queue, nil is returned if it's empty.
+func (s *streamer) steal() *stream {
+	shared := s.queues[0]
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if len(shared.charged) == 0 {
+		return nil
+	}
+
+	return s.take(shared)
+}
+
+// chargedCounts returns the count of streams waiting for processors per queue.
+func (s *streamer) chargedCounts() []int {
+	counts := make([]int, 0, len(s.queues))
+	for _, q := range s.queues {
+		q.mu.Lock()
+		counts = append(counts, len(q.charged))
+		q.mu.Unlock()
+	}
+
+	return counts
+}
+
 func (s *streamer) makeBlocked(stream *stream) {
 	s.blockedMu.Lock()
 	stream.blockIndex = len(s.blocked)
@@ -181,14 +289,23 @@ func (s *streamer) dump() string {
 		return o
 	})
 
-	out += logger.Cond(len(s.charged) == 0, logger.Header("charged streams empty"), func() string {
-		o := logger.Header("charged streams")
-		for _, s := range s.charged {
-			o += fmt.Sprintf("%d(%s)\n", s.sourceID, s.name)
+	for _, q := range s.queues {
+		name := "charged streams"
+		if q.pattern != nil {
+			name = fmt.Sprintf("charged streams pinned by %q", q.pattern.String())
 		}
 
-		return o
-	})
+		q.mu.Lock()
+		out += logger.Cond(len(q.charged) == 0, logger.Header(name+" empty"), func() string {
+			o := logger.Header(name)
+			for _, st := range q.charged {
+				o += fmt.Sprintf("%d(%s)\n", st.sourceID, st.name)
+			}
+
+			return o
+		})
+		q.mu.Unlock()
+	}
 
 	out += logger.Cond(len(s.blocked) == 0, logger.Header("blocked streams empty"), func() string {
 		o := logger.Header("blocked streams")
@@ -204,6 +321,14 @@ func (s *streamer) dump() string {
 	return out
 }
 
-func (s *streamer) unblockProcessor() {
-	s.chargedCond.Signal()
+func (s *streamer) unblockProcessor(queue int) {
+	s.queues[queue].cond.Signal()
+}
+
+// wakeProcessors wakes up all waiting processors of the queue, so retired ones exit.
+func (s *streamer) wakeProcessors(queue int) {
+	q := s.queues[queue]
+	q.mu.Lock()
+	q.cond.Broadcast()
+	q.mu.Unlock()
 }
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamerPinStreams(t *testing.T) {
+	s := newStreamer()
+	s.pinStreams([]*regexp.Regexp{regexp.MustCompile("^heavy"), regexp.MustCompile("^slow")})
+
+	heavy := s.getStream(1, "heavy_regexps")
+	slow := s.getStream(1, "slow")
+	other := s.getStream(2, "other")
+
+	assert.Equal(t, 1, heavy.queue, "wrong queue")
+	assert.Equal(t, 2, slow.queue, "wrong queue")
+	assert.Equal(t, 0, other.queue, "wrong queue")
+
+	heavy.put(newEvent())
+	other.put(newEvent())
+
+	assert.Equal(t, 1, len(s.queues[1].charged), "heavy stream should be charged to the pinned queue")
+	assert.Equal(t, 0, len(s.queues[2].charged), "pinned queue should be empty")
+	assert.Equal(t, heavy, s.joinStream(1, nil), "wrong stream")
+	assert.Equal(t, other, s.joinStream(0, nil), "wrong stream")
+}
+
+func TestStreamerSteal(t *testing.T) {
+	s := newStreamer()
+	s.stealing = true
+	s.pinStreams([]*regexp.Regexp{regexp.MustCompile("^heavy")})
+
+	other := s.getStream(1, "other")
+	other.put(newEvent())
+	assert.Equal(t, []int{1, 0}, s.chargedCounts(), "wrong charged counts")
+	assert.Equal(t, other, s.joinStream(1, nil), "idle pinned processor should steal the stream")
+	assert.Equal(t, []int{0, 0}, s.chargedCounts(), "wrong charged counts")
+
+	// the pinned processor waiting for streams is woken up by the shared stream
+	joined := make(chan *stream)
+	go func() { joined <- s.joinStream(1, nil) }()
+	another := s.getStream(2, "another")
+	another.put(newEvent())
+	assert.Equal(t, another, <-joined, "waiting pinned processor should steal the stream")
+}
+
+func TestRunQueueUpdate(t *testing.T) {
+	s := newStreamer()
+	s.pinStreams([]*regexp.Regexp{regexp.MustCompile("^heavy")})
+	q := newRunQueue("test", prometheus.NewRegistry())
+	queueName := func(queue int) string { return []string{"shared", "^heavy"}[queue] }
+
+	heavy := s.getStream(1, "heavy")
+	heavy.put(newEvent())
+	heavy.put(newEvent())
+	s.getStream(2, "other").put(newEvent())
+
+	q.update(s, queueName)
+	assert.Equal(t, float64(1), testutil.ToFloat64(q.streams.WithLabelValues("shared")), "wrong charged streams")
+	assert.Equal(t, float64(1), testutil.ToFloat64(q.streams.WithLabelValues("^heavy")), "wrong charged streams")
+
+	s.joinStream(1, nil).setProc(7)
+	q.update(s, queueName)
+	assert.Equal(t, float64(0), testutil.ToFloat64(q.streams.WithLabelValues("^heavy")), "wrong charged streams")
+	assert.Equal(t, float64(2), testutil.ToFloat64(q.procEvents.WithLabelValues("^heavy", "7")), "wrong queued events of the processor")
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ozonru/file.d/cfg"
+)
+
+// EventTemplate renders an event into a text line using `{{.field}}` placeholders,
+// e.g. `{{.time}} {{.level}} {{.message}}`. Nested fields are addressed like field selectors: `{{.k8s.pod}}`.
+// Values of the event metadata are rendered by `{{meta.key}}` placeholders.
+// It doesn't use text/template because it would require converting each event into a map.
+type EventTemplate struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	text  string
+	field []string
+	// meta is the metadata key, it's set for `{{meta.key}}` placeholders
+	meta string
+}
+
+const metaPlaceholderPrefix = "meta."
+
+func ParseEventTemplate(template string) (*EventTemplate, error) {
+	parts := make([]templatePart, 0)
+	rest := template
+	for len(rest) > 0 {
+		pos := strings.Index(rest, "{{")
+		if pos == -1 {
+			parts = append(parts, templatePart{text: rest})
+			break
+		}
+		if pos > 0 {
+			parts = append(parts, templatePart{text: rest[:pos]})
+		}
+		rest = rest[pos+2:]
+
+		end := strings.Index(rest, "}}")
+		if end == -1 {
+			return nil, fmt.Errorf("placeholder isn't closed in template %q", template)
+		}
+
+		placeholder := strings.TrimSpace(rest[:end])
+		rest = rest[end+2:]
+		if strings.HasPrefix(placeholder, metaPlaceholderPrefix) && len(placeholder) > len(metaPlaceholderPrefix) {
+			parts = append(parts, templatePart{meta: placeholder[len(metaPlaceholderPrefix):]})
+			continue
+		}
+		if len(placeholder) < 2 || placeholder[0] != '.' {
+			return nil, fmt.Errorf("wrong placeholder %q in template %q, it should look like {{.field}} or {{meta.key}}", placeholder, template)
+		}
+		parts = append(parts, templatePart{field: cfg.ParseFieldSelector(placeholder[1:])})
+	}
+
+	return &EventTemplate{parts: parts}, nil
+}
+
+// IsStatic returns true if the template doesn't have placeholders.
+func (t *EventTemplate) IsStatic() bool {
+	for _, part := range t.parts {
+		if part.field != nil || part.meta != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Render appends rendered event to the out buffer.
+// Missing fields are rendered as empty strings, objects and arrays are rendered as JSON.
+func (t *EventTemplate) Render(out []byte, event *Event) []byte {
+	for _, part := range t.parts {
+		if part.meta != "" {
+			out = append(out, event.Meta[part.meta]...)
+			continue
+		}
+		if part.field == nil {
+			out = append(out, part.text...)
+			continue
+		}
+
+		node := event.Root.Dig(part.field...)
+		if node == nil {
+			continue
+		}
+
+		if node.IsObject() || node.IsArray() {
+			out = node.Encode(out)
+			continue
+		}
+		out = append(out, node.AsString()...)
+	}
+
+	return out
+}
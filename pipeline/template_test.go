@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTemplateRender(t *testing.T) {
+	cases := []struct {
+		template string
+		json     string
+		result   string
+	}{
+		{
+			template: "{{.time}} {{.level}} {{.message}}",
+			json:     `{"time":"2021-06-22 16:24:27","level":"info","message":"hello"}`,
+			result:   "2021-06-22 16:24:27 info hello",
+		},
+		{
+			template: "[{{ .k8s.pod }}] {{.message}}",
+			json:     `{"k8s":{"pod":"pod_1"},"message":"hello"}`,
+			result:   "[pod_1] hello",
+		},
+		{
+			template: "{{.level}}: {{.missing}}{{.obj}} {{.num}}",
+			json:     `{"level":"error","obj":{"a":[1,2]},"num":10}`,
+			result:   `error: {"a":[1,2]} 10`,
+		},
+		{
+			template: "no placeholders",
+			json:     `{"message":"hello"}`,
+			result:   "no placeholders",
+		},
+		{
+			template: "{{meta.namespace}}/{{ meta.pod }}: {{.message}}{{meta.missing}}",
+			json:     `{"message":"hello","namespace":"body"}`,
+			result:   "payments/pod_1: hello",
+		},
+	}
+
+	for _, c := range cases {
+		tmpl, err := ParseEventTemplate(c.template)
+		assert.NoError(t, err, "wrong template %q", c.template)
+
+		event := newEvent()
+		assert.NoError(t, event.parseJSON([]byte(c.json)))
+		event.SetMeta("namespace", "payments")
+		event.SetMeta("pod", "pod_1")
+
+		assert.Equal(t, c.result, string(tmpl.Render(nil, event)), "wrong render result")
+	}
+}
+
+func TestEventTemplateParseErr(t *testing.T) {
+	for _, template := range []string{"{{.time", "{{time}}", "{{.}}", "{{meta.}}"} {
+		_, err := ParseEventTemplate(template)
+		assert.Error(t, err, "template %q should fail", template)
+	}
+}
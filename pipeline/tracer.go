@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTraceSize = 100
+
+	traceVerdictCommitted = "committed"
+	traceVerdictDiscarded = "discarded"
+)
+
+// Trace tags events to record their path through actions, it's set by the `trace` pipeline setting.
+type Trace struct {
+	// MatchConditions tag events which match all conditions.
+	MatchConditions MatchConditions
+	// Rate is the fraction of events tagged regardless of conditions, from 0 to 1.
+	Rate float64
+	// Size is the number of finished traces kept for the `/trace` endpoint.
+	Size int
+}
+
+// eventTrace is the path of the tagged event, it's finished when the event is committed or discarded.
+type eventTrace struct {
+	Source     string       `json:"source"`
+	Offset     int64        `json:"offset"`
+	ReceivedAt time.Time    `json:"received_at"`
+	Input      string       `json:"input"`
+	Steps      []*traceStep `json:"steps"`
+	Verdict    string       `json:"verdict"`
+	Output     string       `json:"output,omitempty"`
+	Duration   string       `json:"duration"`
+}
+
+// traceStep is the action the traced event has passed, JSONs are set if the event matches the action.
+type traceStep struct {
+	Action   int         `json:"action"`
+	Type     string      `json:"type"`
+	Status   eventStatus `json:"status"`
+	Before   string      `json:"before,omitempty"`
+	After    string      `json:"after,omitempty"`
+	Duration string      `json:"duration,omitempty"`
+
+	startedAt time.Time
+}
+
+// tracer keeps the last finished traces in a ring buffer like the event journal.
+type tracer struct {
+	trace *Trace
+
+	mu     *sync.Mutex
+	traces []*eventTrace
+	total  int
+}
+
+func newTracer(trace *Trace) *tracer {
+	size := trace.Size
+	if size <= 0 {
+		size = DefaultTraceSize
+	}
+
+	return &tracer{
+		trace:  trace,
+		mu:     &sync.Mutex{},
+		traces: make([]*eventTrace, size),
+	}
+}
+
+// tag starts the trace of the decoded event if it matches conditions or it's sampled.
+func (t *tracer) tag(event *Event) {
+	isTagged := len(t.trace.MatchConditions) != 0 && t.trace.MatchConditions.IsMatch(event, MatchModeAnd)
+	if !isTagged && t.trace.Rate > 0 {
+		isTagged = rand.Float64() < t.trace.Rate
+	}
+	if !isTagged {
+		return
+	}
+
+	event.trace = &eventTrace{
+		Source:     event.SourceName,
+		Offset:     event.Offset,
+		ReceivedAt: event.receivedAt,
+		Input:      event.Root.EncodeToString(),
+	}
+}
+
+func (t *tracer) finish(event *Event, verdict string) {
+	trace := event.trace
+	event.trace = nil
+
+	trace.Verdict = verdict
+	trace.Output = event.Root.EncodeToString()
+	trace.Duration = time.Since(trace.ReceivedAt).String()
+
+	t.mu.Lock()
+	t.traces[t.total%len(t.traces)] = trace
+	t.total++
+	t.mu.Unlock()
+}
+
+// last returns up to n last traces from the oldest to the newest.
+func (t *tracer) last(n int) []*eventTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if n > len(t.traces) {
+		n = len(t.traces)
+	}
+	if n > t.total {
+		n = t.total
+	}
+
+	result := make([]*eventTrace, 0, n)
+	for i := t.total - n; i < t.total; i++ {
+		result = append(result, t.traces[i%len(t.traces)])
+	}
+
+	return result
+}
+
+// serveTrace returns the last traces as a JSON array, the count is set by the `last` query param.
+func (t *tracer) serveTrace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	n := len(t.traces)
+	if last := r.URL.Query().Get("last"); last != "" {
+		var err error
+		n, err = strconv.Atoi(last)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeErr(w, "`last` should be a non-negative number")
+			return
+		}
+	}
+
+	resp, _ := json.Marshal(t.last(n))
+	_, _ = w.Write(resp)
+}
+
+func (s *traceStep) before(event *Event) {
+	s.Before = event.Root.EncodeToString()
+	s.startedAt = time.Now()
+}
+
+func (s *traceStep) after(event *Event, result ActionResult) {
+	s.Duration = time.Since(s.startedAt).String()
+	s.After = event.Root.EncodeToString()
+
+	switch result {
+	case ActionPass:
+		s.Status = eventStatusPassed
+	case ActionDiscard:
+		s.Status = eventStatusDiscarded
+	case ActionCollapse:
+		s.Status = eventStatusCollapse
+	case ActionHold:
+		s.Status = eventStatusHold
+	}
+}
@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// traceActionStub discards events with the `drop` field and marks others.
+type traceActionStub struct{}
+
+func (a *traceActionStub) Start(AnyConfig, *ActionPluginParams) {}
+func (a *traceActionStub) Stop()                                {}
+
+func (a *traceActionStub) Do(event *Event) ActionResult {
+	if event.Root.Dig("drop") != nil {
+		return ActionDiscard
+	}
+	event.Root.AddFieldNoAlloc(event.Root, "marked").MutateToBool(true)
+	return ActionPass
+}
+
+func TestTrace(t *testing.T) {
+	conditions, err := NewMatchConditions(map[string]string{"level": "error"})
+	require.NoError(t, err)
+
+	settings := &Settings{Decoder: "json", Capacity: 8, MaintenanceInterval: time.Hour, Trace: &Trace{MatchConditions: conditions, Size: 10}}
+	p := New("test", settings, prometheus.NewRegistry())
+	p.SetFixtureMode()
+	p.AddAction(&ActionPluginStaticInfo{
+		PluginStaticInfo: &PluginStaticInfo{
+			Type: "stub",
+			Factory: func() (AnyPlugin, AnyConfig) {
+				return &traceActionStub{}, nil
+			},
+		},
+		MatchConditions: MatchConditions{},
+	})
+	p.AddAction(&ActionPluginStaticInfo{
+		PluginStaticInfo: &PluginStaticInfo{
+			Type: "skipped",
+			Factory: func() (AnyPlugin, AnyConfig) {
+				return &traceActionStub{}, nil
+			},
+		},
+		MatchConditions: MatchConditions{{Field: "service", Value: "none"}},
+	})
+	p.Start()
+	defer p.Stop()
+
+	_, err = p.RunFixture([][]byte{
+		[]byte(`{"level":"error"}`),
+		[]byte(`{"level":"info"}`),
+		[]byte(`{"level":"error","drop":true}`),
+	}, time.Second)
+	require.NoError(t, err)
+
+	traces := p.tracer.last(10)
+	require.Len(t, traces, 2, "only matching events should be traced")
+
+	committed := traces[0]
+	assert.Equal(t, traceVerdictCommitted, committed.Verdict)
+	assert.Equal(t, `{"level":"error"}`, committed.Input)
+	assert.Equal(t, `{"level":"error","marked":true}`, committed.Output)
+	require.Len(t, committed.Steps, 2)
+	assert.Equal(t, eventStatusPassed, committed.Steps[0].Status)
+	assert.Equal(t, `{"level":"error"}`, committed.Steps[0].Before)
+	assert.Equal(t, `{"level":"error","marked":true}`, committed.Steps[0].After)
+	assert.Equal(t, eventStatusNotMatched, committed.Steps[1].Status)
+	assert.Equal(t, "skipped", committed.Steps[1].Type)
+
+	discarded := traces[1]
+	assert.Equal(t, traceVerdictDiscarded, discarded.Verdict)
+	require.Len(t, discarded.Steps, 1)
+	assert.Equal(t, eventStatusDiscarded, discarded.Steps[0].Status)
+
+	w := httptest.NewRecorder()
+	p.serveTrace(w, httptest.NewRequest("GET", "/pipelines/test/trace?last=1", nil))
+	served := make([]map[string]interface{}, 0)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &served))
+	require.Len(t, served, 1)
+	assert.Equal(t, traceVerdictDiscarded, served[0]["verdict"])
+}
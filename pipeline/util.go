@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 	"unsafe"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
 )
 
 func ByteToStringUnsafe(b []byte) string {
@@ -60,7 +62,22 @@ func ParseFormatName(formatName string) (string, error) {
 	}
 }
 
+const LevelUnknown = -1
+
+// LevelNames are canonical names of the syslog severity levels indexed by the level.
+var LevelNames = []string{"emergency", "alert", "critical", "error", "warn", "notice", "info", "debug"}
+
+// ParseLevel returns the syslog severity level, unknown levels are treated as `info`.
 func ParseLevel(level string) int {
+	if l := ParseLevelStrict(level); l != LevelUnknown {
+		return l
+	}
+
+	return 6
+}
+
+// ParseLevelStrict returns the syslog severity level or LevelUnknown.
+func ParseLevelStrict(level string) int {
 	switch strings.ToLower(strings.TrimSpace(level)) {
 	case "0", "emergency":
 		return 0
@@ -79,10 +96,35 @@ func ParseLevel(level string) int {
 	case "7", "debug":
 		return 7
 	default:
-		return 6
+		return LevelUnknown
 	}
 }
 
 func TrimSpaceFunc(r rune) bool {
 	return byte(r) == ' '
 }
+
+// CreateNestedField creates objects along the path and returns the node of the last field.
+// Nodes on the path which aren't objects are mutated to objects.
+func CreateNestedField(root *insaneJSON.Root, path []string) *insaneJSON.Node {
+	curr := root.Node
+	for _, field := range path {
+		if !curr.IsObject() {
+			curr.MutateToObject()
+		}
+		curr = curr.AddFieldNoAlloc(root, field)
+	}
+
+	return curr
+}
+
+// isBlank checks whether data contains only whitespaces and line endings.
+func isBlank(data []byte) bool {
+	for _, c := range data {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return false
+		}
+	}
+
+	return true
+}
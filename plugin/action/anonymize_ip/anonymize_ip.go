@@ -0,0 +1,160 @@
+package anonymize_ip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"net"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It anonymizes IP addresses in the event fields.
+In the `mask` mode it zeroes the low bits of the address and keeps the prefix, so subnet-level analytics is still possible.
+In the `hash` mode it replaces the address with a keyed hash (HMAC-SHA256), so the same addresses can still be correlated.
+Values which aren't IP addresses are kept untouched.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: anonymize_ip
+      fields: [client_ip, x_forwarded_for]
+      ipv4_prefix_len: 24
+      ipv6_prefix_len: 48
+    ...
+```
+It transforms `{"client_ip":"192.168.1.42","x_forwarded_for":"2001:db8:85a3::8a2e:370:7334"}` into
+`{"client_ip":"192.168.1.0","x_forwarded_for":"2001:db8:85a3::"}`.
+}*/
+type Plugin struct {
+	config *Config
+	fields [][]string
+	v4Mask net.IPMask
+	v6Mask net.IPMask
+	hash   hash.Hash
+	buf    []byte
+}
+
+type mode int
+
+const (
+	modeMask mode = iota
+	modeHash
+)
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The list of the fields with IP addresses. Field selectors are supported.
+	Fields []string `json:"fields" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Anonymization mode:
+	//> * `mask` – zeroes the low bits of the address
+	//> * `hash` – replaces the address with the hex encoded HMAC-SHA256 of it
+	Mode  string `json:"mode" default:"mask" options:"mask|hash"` //*
+	Mode_ mode
+
+	//> @3@4@5@6
+	//>
+	//> The number of the leading bits to keep for IPv4 addresses in the `mask` mode.
+	IPv4PrefixLen int `json:"ipv4_prefix_len" default:"24"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The number of the leading bits to keep for IPv6 addresses in the `mask` mode.
+	IPv6PrefixLen int `json:"ipv6_prefix_len" default:"48"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The key for the `hash` mode. Keep it secret, otherwise the addresses can be restored by brute force.
+	HashKey string `json:"hash_key"` //*
+}
+
+const hexDigits = "0123456789abcdef"
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "anonymize_ip",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+
+	for _, field := range p.config.Fields {
+		p.fields = append(p.fields, cfg.ParseFieldSelector(field))
+	}
+
+	switch p.config.Mode {
+	case "mask":
+		p.config.Mode_ = modeMask
+		if p.config.IPv4PrefixLen < 0 || p.config.IPv4PrefixLen > 8*net.IPv4len {
+			params.Logger.Fatalf("wrong ipv4_prefix_len %d", p.config.IPv4PrefixLen)
+		}
+		if p.config.IPv6PrefixLen < 0 || p.config.IPv6PrefixLen > 8*net.IPv6len {
+			params.Logger.Fatalf("wrong ipv6_prefix_len %d", p.config.IPv6PrefixLen)
+		}
+		p.v4Mask = net.CIDRMask(p.config.IPv4PrefixLen, 8*net.IPv4len)
+		p.v6Mask = net.CIDRMask(p.config.IPv6PrefixLen, 8*net.IPv6len)
+	case "hash":
+		p.config.Mode_ = modeHash
+		if p.config.HashKey == "" {
+			params.Logger.Fatalf("hash_key should be set for the hash mode")
+		}
+		p.hash = hmac.New(sha256.New, []byte(p.config.HashKey))
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	for _, field := range p.fields {
+		node := event.Root.Dig(field...)
+		if node == nil || !node.IsString() {
+			continue
+		}
+
+		ip := net.ParseIP(node.AsString())
+		if ip == nil {
+			continue
+		}
+
+		if p.config.Mode_ == modeHash {
+			p.hash.Reset()
+			_, _ = p.hash.Write(ip.To16())
+			p.buf = p.hash.Sum(p.buf[:0])
+
+			l := len(event.Buf)
+			for _, b := range p.buf {
+				event.Buf = append(event.Buf, hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			node.MutateToString(pipeline.ByteToStringUnsafe(event.Buf[l:]))
+			continue
+		}
+
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4.Mask(p.v4Mask)
+		} else {
+			ip = ip.Mask(p.v6Mask)
+		}
+		node.MutateToString(ip.String())
+	}
+
+	return pipeline.ActionPass
+}
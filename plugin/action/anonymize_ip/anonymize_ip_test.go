@@ -0,0 +1,32 @@
+package anonymize_ip
+
+import (
+	"testing"
+
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymizeIPMask(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Fields: []string{"client_ip", "request.ip"}, IPv4PrefixLen: 16}, []string{
+		`{"client_ip":"192.168.1.42","request":{"ip":"2001:db8:85a3::8a2e:370:7334"}}`,
+		`{"client_ip":"not an ip","request":{"ip":10}}`,
+	})
+
+	assert.Equal(t, 2, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"client_ip":"192.168.0.0","request":{"ip":"2001:db8:85a3::"}}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"client_ip":"not an ip","request":{"ip":10}}`, outEvents[1], "wrong out event")
+}
+
+func TestAnonymizeIPHash(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Fields: []string{"client_ip"}, Mode: "hash", HashKey: "secret"}, []string{
+		`{"client_ip":"192.168.1.42"}`,
+		`{"client_ip":"192.168.1.42"}`,
+		`{"client_ip":"192.168.1.43"}`,
+	})
+
+	assert.Equal(t, 3, len(outEvents), "wrong out events count")
+	assert.Equal(t, outEvents[0], outEvents[1], "same addresses should have the same hash")
+	assert.NotEqual(t, outEvents[0], outEvents[2], "different addresses should have different hashes")
+	assert.Equal(t, len(`{"client_ip":""}`)+64, len(outEvents[0]), "wrong hash length")
+}
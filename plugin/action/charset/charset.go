@@ -0,0 +1,191 @@
+package charset
+
+import (
+	"unicode/utf8"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"golang.org/x/text/encoding/charmap"
+)
+
+/*{ introduction
+It converts string values of the event to valid UTF-8.
+Values in the declared `charset` are converted to UTF-8, invalid UTF-8 sequences are replaced with the `replacement`.
+> Outputs like `elasticsearch` reject the whole batch because of one bad byte sequence, so it's useful to put the plugin before them.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: charset
+      fields: message
+      charset: windows-1251
+    ...
+```
+}*/
+type Plugin struct {
+	config  *Config
+	fields  [][]string
+	charmap *charmap.Charmap
+	buf     []byte
+}
+
+const (
+	charsetUTF8        = "utf-8"
+	charsetLatin1      = "latin-1"
+	charsetWindows1251 = "windows-1251"
+)
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> Comma separated list of the fields to convert. All string values of the event, including nested ones, are converted if it's empty.
+	Fields  string `json:"fields" parse:"list"` //*
+	Fields_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The charset of the values:
+	//> * `utf-8` – values are only checked, invalid sequences are replaced
+	//> * `latin-1` – values are converted from ISO-8859-1
+	//> * `windows-1251` – values are converted from Windows-1251
+	Charset string `json:"charset" default:"utf-8" options:"utf-8|latin-1|windows-1251"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The string to replace invalid UTF-8 sequences with.
+	Replacement string `json:"replacement" default:"�"` //*
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "charset",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+
+	for _, field := range p.config.Fields_ {
+		p.fields = append(p.fields, cfg.ParseFieldSelector(field))
+	}
+
+	switch p.config.Charset {
+	case charsetLatin1:
+		p.charmap = charmap.ISO8859_1
+	case charsetWindows1251:
+		p.charmap = charmap.Windows1251
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	if len(p.fields) == 0 {
+		p.convertNode(event.Root, event.Root.Node)
+		return pipeline.ActionPass
+	}
+
+	for _, field := range p.fields {
+		p.convertNode(event.Root, event.Root.Dig(field...))
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) convertNode(root *insaneJSON.Root, node *insaneJSON.Node) {
+	switch {
+	case node == nil:
+		return
+	case node.IsString():
+		p.convertString(root, node)
+	case node.IsObject():
+		for _, field := range node.AsFields() {
+			p.convertNode(root, field.AsFieldValue())
+		}
+	case node.IsArray():
+		for _, elem := range node.AsArray() {
+			p.convertNode(root, elem)
+		}
+	}
+}
+
+func (p *Plugin) convertString(root *insaneJSON.Root, node *insaneJSON.Node) {
+	value := node.AsString()
+	if isASCII(value) {
+		return
+	}
+
+	if p.charmap == nil {
+		if utf8.ValidString(value) {
+			return
+		}
+		p.buf = p.sanitize(p.buf[:0], value)
+	} else {
+		p.buf = p.decode(p.buf[:0], value)
+	}
+
+	node.MutateToBytesCopy(root, p.buf)
+}
+
+// sanitize replaces invalid UTF-8 sequences with the replacement.
+func (p *Plugin) sanitize(out []byte, value string) []byte {
+	isInvalid := false
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if r == utf8.RuneError && size == 1 {
+			// a run of invalid bytes is replaced once
+			if !isInvalid {
+				out = append(out, p.config.Replacement...)
+			}
+			isInvalid = true
+			i++
+			continue
+		}
+
+		isInvalid = false
+		out = append(out, value[i:i+size]...)
+		i += size
+	}
+
+	return out
+}
+
+// decode converts the single byte charset value to UTF-8.
+func (p *Plugin) decode(out []byte, value string) []byte {
+	var runeBuf [utf8.UTFMax]byte
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < utf8.RuneSelf {
+			out = append(out, c)
+			continue
+		}
+
+		n := utf8.EncodeRune(runeBuf[:], p.charmap.DecodeByte(c))
+		out = append(out, runeBuf[:n]...)
+	}
+
+	return out
+}
+
+func isASCII(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
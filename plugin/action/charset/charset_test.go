@@ -0,0 +1,36 @@
+package charset
+
+import (
+	"testing"
+
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeUTF8(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{}, []string{
+		"{\"message\":\"bad \xff\xfe bytes\",\"nested\":{\"list\":[\"\xc3\"]}}",
+		`{"message":"привет","code":1}`,
+	})
+
+	assert.Equal(t, []string{
+		`{"message":"bad � bytes","nested":{"list":["�"]}}`,
+		`{"message":"привет","code":1}`,
+	}, outEvents, "wrong out events")
+}
+
+func TestConvertCharset(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Fields: "message", Charset: charsetWindows1251}, []string{
+		"{\"message\":\"\xef\xf0\xe8\xe2\xe5\xf2\",\"other\":\"\xef\"}",
+	})
+
+	assert.Equal(t, []string{"{\"message\":\"привет\",\"other\":\"\xef\"}"}, outEvents, "wrong out events")
+}
+
+func TestConvertLatin1(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Charset: charsetLatin1, Replacement: "?"}, []string{
+		"{\"message\":\"caf\xe9\"}",
+	})
+
+	assert.Equal(t, []string{`{"message":"café"}`}, outEvents, "wrong out events")
+}
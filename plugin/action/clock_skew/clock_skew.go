@@ -0,0 +1,187 @@
+package clock_skew
+
+import (
+	"math"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/logger"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It compares the event timestamp against the wall clock and handles events with implausible timestamps:
+ones which are further in the future than `max_future` or further in the past than `max_past`.
+Such timestamps come from hosts with broken clocks and wreck the retention and the index routing of storages.
+
+Modes:
+* `correct` – rewrites the timestamp to the ingest time in the same format
+* `tag` – keeps the timestamp and puts the skew into the `tag_field`
+* `drop` – discards the event
+
+The skew is the difference between the event timestamp and the ingest time, e.g. `2h0m0s` for the future timestamp or `-72h0m0s` for the past one.
+Timestamps are strings in one of `formats` or numbers of seconds since the epoch, other events are passed untouched.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: clock_skew
+      field: time
+      max_future: 5m
+      max_past: 24h
+      mode: correct
+    ...
+```
+At `2021-05-01T10:00:00Z` it transforms `{"time":"2021-05-01T15:00:00Z"}` into `{"time":"2021-05-01T10:00:00Z","clock_skew":"5h0m0s"}`.
+}*/
+type Plugin struct {
+	config  *Config
+	formats []string
+	now     func() time.Time
+}
+
+const (
+	modeCorrect = "correct"
+	modeTag     = "tag"
+	modeDrop    = "drop"
+)
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The event field which contains the timestamp.
+	Field  cfg.FieldSelector `json:"field" default:"time" parse:"selector"` //*
+	Field_ []string
+
+	//> @3@4@5@6
+	//>
+	//> Comma separated list of the timestamp formats, e.g. `rfc3339nano,rfc3339,2006-01-02 15:04:05`.
+	//> Names are the same as in the [convert_date](/plugin/action/convert_date/README.md) plugin.
+	Formats  string `json:"formats" default:"rfc3339nano,rfc3339" parse:"list"` //*
+	Formats_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The maximum allowed skew to the future. `0` disables the check.
+	MaxFuture  cfg.Duration `json:"max_future" default:"5m" parse:"duration"` //*
+	MaxFuture_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> The maximum allowed skew to the past. `0` disables the check.
+	MaxPast  cfg.Duration `json:"max_past" default:"168h" parse:"duration"` //*
+	MaxPast_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> What to do with events which timestamps are out of the allowed range.
+	Mode string `json:"mode" default:"correct" options:"correct|tag|drop"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The field to put the skew to in the `correct` and `tag` modes. It's required in the `tag` mode, empty value disables it in the `correct` mode.
+	TagField  cfg.FieldSelector `json:"tag_field" default:"clock_skew" parse:"selector"` //*
+	TagField_ []string
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "clock_skew",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.now = time.Now
+
+	for _, formatName := range p.config.Formats_ {
+		format, err := pipeline.ParseFormatName(formatName)
+		if err != nil {
+			format = formatName
+		}
+		p.formats = append(p.formats, format)
+	}
+
+	if p.config.Mode == modeTag && len(p.config.TagField_) == 0 {
+		logger.Fatalf("tag_field isn't set for the tag mode of clock_skew action")
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		return pipeline.ActionPass
+	}
+
+	format := ""
+	var ts time.Time
+	switch {
+	case node.IsNumber():
+		sec, frac := math.Modf(node.AsFloat())
+		ts = time.Unix(int64(sec), int64(frac*float64(time.Second)))
+	case node.IsString():
+		var ok bool
+		ts, format, ok = p.parse(node.AsString())
+		if !ok {
+			return pipeline.ActionPass
+		}
+	default:
+		return pipeline.ActionPass
+	}
+
+	now := p.now()
+	skew := ts.Sub(now)
+	if !p.isSkewed(skew) {
+		return pipeline.ActionPass
+	}
+
+	switch p.config.Mode {
+	case modeDrop:
+		return pipeline.ActionDiscard
+	case modeCorrect:
+		if format == "" {
+			node.MutateToInt(int(now.Unix()))
+		} else {
+			node.MutateToString(now.In(ts.Location()).Format(format))
+		}
+	}
+
+	if len(p.config.TagField_) != 0 {
+		pipeline.CreateNestedField(event.Root, p.config.TagField_).MutateToString(skew.Truncate(time.Second).String())
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) parse(value string) (time.Time, string, bool) {
+	for _, format := range p.formats {
+		ts, err := time.Parse(format, value)
+		if err == nil {
+			return ts, format, true
+		}
+	}
+
+	return time.Time{}, "", false
+}
+
+func (p *Plugin) isSkewed(skew time.Duration) bool {
+	if p.config.MaxFuture_ > 0 && skew > p.config.MaxFuture_ {
+		return true
+	}
+
+	return p.config.MaxPast_ > 0 && -skew > p.config.MaxPast_
+}
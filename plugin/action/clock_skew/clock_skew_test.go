@@ -0,0 +1,56 @@
+package clock_skew
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockSkewCorrect(t *testing.T) {
+	now := time.Now().UTC()
+	future := now.Add(time.Hour + 30*time.Second).Format(time.RFC3339)
+	past := now.Add(-48*time.Hour - 30*time.Second).Format(time.RFC3339)
+	fine := now.Add(-time.Minute).Format(time.RFC3339)
+
+	outEvents := test.RunAction(factory, &Config{MaxPast: "24h"}, []string{
+		`{"time":"` + future + `"}`,
+		`{"time":"` + past + `"}`,
+		`{"time":"` + fine + `"}`,
+		`{"time":"not a time"}`,
+	})
+
+	assert.Equal(t, 4, len(outEvents), "wrong out events count")
+	assert.Contains(t, outEvents[0], `"clock_skew":"1h0m`, "wrong skew tag")
+	assert.NotContains(t, outEvents[0], future, "timestamp isn't corrected")
+	assert.Contains(t, outEvents[1], `"clock_skew":"-48h0m`, "wrong skew tag")
+	assert.NotContains(t, outEvents[1], past, "timestamp isn't corrected")
+	assert.Equal(t, `{"time":"`+fine+`"}`, outEvents[2], "wrong out event")
+	assert.Equal(t, `{"time":"not a time"}`, outEvents[3], "wrong out event")
+}
+
+func TestClockSkewTag(t *testing.T) {
+	future := strconv.FormatInt(time.Now().Add(2*time.Hour+30*time.Second).Unix(), 10)
+
+	outEvents := test.RunAction(factory, &Config{Mode: "tag", TagField: "meta.skew"}, []string{
+		`{"time":` + future + `}`,
+	})
+
+	assert.Equal(t, 1, len(outEvents), "wrong out events count")
+	assert.Contains(t, outEvents[0], `{"time":`+future+`,"meta":{"skew":"2h0m`, "wrong out event")
+}
+
+func TestClockSkewDrop(t *testing.T) {
+	now := time.Now().UTC()
+
+	outEvents := test.RunActionOut(factory, &Config{Mode: "drop"}, []string{
+		`{"time":"` + now.Add(time.Hour).Format(time.RFC3339Nano) + `"}`,
+		`{"time":"` + now.Add(-30*24*time.Hour).Format(time.RFC3339Nano) + `"}`,
+		`{"time":"` + now.Format(time.RFC3339Nano) + `"}`,
+	}, 1)
+
+	assert.Equal(t, 1, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"time":"`+now.Format(time.RFC3339Nano)+`"}`, outEvents[0], "wrong out event")
+}
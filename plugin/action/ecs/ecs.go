@@ -0,0 +1,239 @@
+package ecs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+/*{ introduction
+It normalizes the event according to [Elastic Common Schema](https://www.elastic.co/guide/en/ecs/current/index.html).
+It moves the event fields to the ECS fields using the mapping table, so Kibana dashboards work out of the box.
+
+If the mapping table isn't set, the default one is used:
+* `msg` → `message`
+* `lvl`, `level` → `log.level`
+* `ts`, `time` → `@timestamp`
+* `host` → `host.name`
+* `trace_id` → `trace.id`
+* `span_id` → `span.id`
+* `k8s_namespace` → `kubernetes.namespace`
+* `k8s_pod` → `kubernetes.pod.name`
+* `k8s_container` → `kubernetes.container.name`
+* `k8s_node` → `kubernetes.node.name`
+* `k8s_pod_label_*` → `kubernetes.labels.*`
+* `k8s_node_label_*` → `kubernetes.node.labels.*`
+
+If the field is mapped into itself, e.g. `host` → `host.name`, only a scalar value is moved, an object is kept since it's already an ECS object.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: ecs
+      fields:
+        msg: message
+        severity: log.level
+        request_*: http.request.*
+    ...
+```
+It transforms `{"msg":"hello","severity":"warn","request_method":"GET"}` into
+`{"message":"hello","log":{"level":"warn"},"http":{"request":{"method":"GET"}},"ecs":{"version":"1.12.0"}}`.
+}*/
+type Plugin struct {
+	config   *Config
+	mappings []*mapping
+	prefixes []*mapping
+	fields   []*insaneJSON.Node
+	path     []string
+}
+
+type mapping struct {
+	from string
+	path []string
+	to   []string
+	// isNested is true if the field is moved into itself, e.g. `host` to `host.name`
+	isNested bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The mapping table of the event fields to the ECS fields. Keys are the event field selectors, values are the ECS field selectors.
+	//> A key ending with `*` is treated as a prefix: all root fields with this prefix are moved, and the rest of the field name replaces `*` in the value.
+	//> If several keys are mapped to the same ECS field, the alphabetically first one wins.
+	Fields map[string]string `json:"fields"` //*
+
+	//> @3@4@5@6
+	//>
+	//> If set, already existing ECS fields are overwritten. Otherwise the event field is kept untouched.
+	Override bool `json:"override"` //*
+
+	//> @3@4@5@6
+	//>
+	//> ECS version to put into the `ecs.version` field.
+	ECSVersion string `json:"ecs_version" default:"1.12.0"` //*
+}
+
+var defaultFields = map[string]string{
+	"msg":              "message",
+	"lvl":              "log.level",
+	"level":            "log.level",
+	"ts":               "@timestamp",
+	"time":             "@timestamp",
+	"host":             "host.name",
+	"trace_id":         "trace.id",
+	"span_id":          "span.id",
+	"k8s_namespace":    "kubernetes.namespace",
+	"k8s_pod":          "kubernetes.pod.name",
+	"k8s_container":    "kubernetes.container.name",
+	"k8s_node":         "kubernetes.node.name",
+	"k8s_pod_label_*":  "kubernetes.labels.*",
+	"k8s_node_label_*": "kubernetes.node.labels.*",
+}
+
+var ecsVersionPath = []string{"ecs", "version"}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "ecs",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+
+	fields := p.config.Fields
+	if len(fields) == 0 {
+		fields = defaultFields
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fields[key]
+		if strings.HasSuffix(key, "*") {
+			if !strings.HasSuffix(value, "*") {
+				params.Logger.Fatalf("ECS field %q should end with * since %q is a prefix", value, key)
+			}
+			p.prefixes = append(p.prefixes, &mapping{
+				from: key[:len(key)-1],
+				to:   cfg.ParseFieldSelector(strings.TrimSuffix(value[:len(value)-1], ".")),
+			})
+			continue
+		}
+
+		m := &mapping{
+			from: key,
+			path: cfg.ParseFieldSelector(key),
+			to:   cfg.ParseFieldSelector(value),
+		}
+		m.isNested = isPrefix(m.path, m.to)
+		p.mappings = append(p.mappings, m)
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	for _, m := range p.mappings {
+		node := event.Root.Dig(m.path...)
+		if node == nil {
+			continue
+		}
+		// the object can't be moved into itself, e.g. `host` is already an ECS object
+		if m.isNested && (node.IsObject() || node.IsArray()) {
+			continue
+		}
+
+		p.move(event, node, m.to, m.isNested)
+	}
+
+	if len(p.prefixes) > 0 {
+		p.movePrefixed(event)
+	}
+
+	pipeline.CreateNestedField(event.Root, ecsVersionPath).MutateToString(p.config.ECSVersion)
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) movePrefixed(event *pipeline.Event) {
+	// collect fields first since moving them changes the root
+	p.fields = append(p.fields[:0], event.Root.AsFields()...)
+	for _, field := range p.fields {
+		name := field.AsString()
+		for _, m := range p.prefixes {
+			if len(name) <= len(m.from) || !strings.HasPrefix(name, m.from) {
+				continue
+			}
+
+			// copy the name since the field is going to be removed
+			l := len(event.Buf)
+			event.Buf = append(event.Buf, name[len(m.from):]...)
+			suffix := pipeline.ByteToStringUnsafe(event.Buf[l:])
+
+			p.path = append(append(p.path[:0], m.to...), suffix)
+			p.move(event, field.AsFieldValue(), p.path, false)
+			break
+		}
+	}
+}
+
+// move moves the node to the path, the nested node is moved in place of itself, so it doesn't occupy the path.
+func (p *Plugin) move(event *pipeline.Event, node *insaneJSON.Node, to []string, isNested bool) {
+	if !p.config.Override && !isNested && p.isOccupied(event, to) {
+		return
+	}
+
+	node.Suicide()
+	pipeline.CreateNestedField(event.Root, to).MutateToNode(node)
+}
+
+func isPrefix(prefix []string, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isOccupied checks whether the field exists or some field on its path isn't an object.
+func (p *Plugin) isOccupied(event *pipeline.Event, path []string) bool {
+	curr := event.Root.Node
+	for i, field := range path {
+		curr = curr.Dig(field)
+		if curr == nil {
+			return false
+		}
+		if i == len(path)-1 || !curr.IsObject() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package ecs
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestECSDefault(t *testing.T) {
+	config := test.NewConfig(&Config{}, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+	wg := &sync.WaitGroup{}
+	wg.Add(4)
+
+	outEvents := make([]string, 0)
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	input.In(0, "test.log", 0, []byte(`{"msg":"hello","lvl":"info","ts":"2021-06-22T16:24:27Z","k8s_pod":"pod_1","k8s_pod_label_app":"app_1"}`))
+	input.In(0, "test.log", 0, []byte(`{"log":"raw log","level":"error","message":"kept","msg":"moved"}`))
+	input.In(0, "test.log", 0, []byte(`{"host":"node_1"}`))
+	input.In(0, "test.log", 0, []byte(`{"host":{"ip":"10.0.0.1"}}`))
+
+	wg.Wait()
+	p.Stop()
+
+	assert.Equal(t, 4, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"log":{"level":"info"},"kubernetes":{"pod":{"name":"pod_1"},"labels":{"app":"app_1"}},"message":"hello","@timestamp":"2021-06-22T16:24:27Z","ecs":{"version":"1.12.0"}}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"log":"raw log","level":"error","message":"kept","msg":"moved","ecs":{"version":"1.12.0"}}`, outEvents[1], "wrong out event")
+	assert.Equal(t, `{"host":{"name":"node_1"},"ecs":{"version":"1.12.0"}}`, outEvents[2], "wrong out event")
+	assert.Equal(t, `{"host":{"ip":"10.0.0.1"},"ecs":{"version":"1.12.0"}}`, outEvents[3], "object shouldn't be moved into itself")
+}
+
+func TestECSCustom(t *testing.T) {
+	config := test.NewConfig(&Config{
+		Fields: map[string]string{
+			"severity":  "log.level",
+			"request_*": "http.request.*",
+		},
+		Override:   true,
+		ECSVersion: "8.0.0",
+	}, nil)
+
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	outEvents := make([]string, 0)
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	input.In(0, "test.log", 0, []byte(`{"log":"raw log","severity":"warn","request_method":"GET","request_id":"1"}`))
+
+	wg.Wait()
+	p.Stop()
+
+	assert.Equal(t, 1, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"log":{"level":"warn"},"http":{"request":{"id":"1","method":"GET"}},"ecs":{"version":"8.0.0"}}`, outEvents[0], "wrong out event")
+}
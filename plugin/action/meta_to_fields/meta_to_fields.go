@@ -0,0 +1,102 @@
+package meta_to_fields
+
+import (
+	"sort"
+
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It copies the event metadata into the event fields, e.g. to keep k8s labels in the stored event.
+The metadata isn't removed, so outputs still can use it in templates.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: meta_to_fields
+      keys: [namespace, pod]
+      prefix: k8s_
+    ...
+```
+}*/
+type Plugin struct {
+	config  *Config
+	keysBuf []string
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The list of the metadata keys to copy. All keys are copied if it's empty.
+	Keys []string `json:"keys" slice:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The prefix of the field names, the field name is the prefix followed by the key.
+	Prefix string `json:"prefix"` //*
+
+	//> @3@4@5@6
+	//>
+	//> If set, the existing fields are overwritten by the metadata values, otherwise they are kept.
+	Overwrite bool `json:"overwrite"` //*
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "meta_to_fields",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	if len(event.Meta) == 0 || !event.Root.IsObject() {
+		return pipeline.ActionPass
+	}
+
+	keys := p.config.Keys
+	if len(keys) == 0 {
+		p.keysBuf = p.keysBuf[:0]
+		for key := range event.Meta {
+			p.keysBuf = append(p.keysBuf, key)
+		}
+		// keep the order of fields stable
+		sort.Strings(p.keysBuf)
+		keys = p.keysBuf
+	}
+
+	for _, key := range keys {
+		value, has := event.Meta[key]
+		if !has {
+			continue
+		}
+
+		field := p.config.Prefix + key
+		node := event.Root.Dig(field)
+		if node != nil && !p.config.Overwrite {
+			continue
+		}
+		if node == nil {
+			node = event.Root.AddFieldNoAlloc(event.Root, field)
+		}
+		node.MutateToString(value)
+	}
+
+	return pipeline.ActionPass
+}
@@ -0,0 +1,45 @@
+package meta_to_fields
+
+import (
+	"testing"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestDo(t *testing.T) {
+	cases := []struct {
+		config *Config
+		result string
+	}{
+		{
+			config: &Config{},
+			result: `{"namespace":"body","pod":"pod_1","team":"payments"}`,
+		},
+		{
+			config: &Config{Keys: []string{"team", "missing"}, Prefix: "k8s_"},
+			result: `{"namespace":"body","k8s_team":"payments"}`,
+		},
+		{
+			config: &Config{Keys: []string{"namespace"}, Overwrite: true},
+			result: `{"namespace":"default"}`,
+		},
+	}
+
+	for _, c := range cases {
+		p := &Plugin{}
+		p.Start(c.config, nil)
+
+		root, err := insaneJSON.DecodeString(`{"namespace":"body"}`)
+		assert.NoError(t, err)
+		event := &pipeline.Event{Root: root}
+		event.SetMeta("namespace", "default")
+		event.SetMeta("team", "payments")
+		event.SetMeta("pod", "pod_1")
+
+		assert.Equal(t, pipeline.ActionPass, p.Do(event), "wrong action result")
+		assert.Equal(t, c.result, root.EncodeToString(), "wrong event")
+		insaneJSON.Release(root)
+	}
+}
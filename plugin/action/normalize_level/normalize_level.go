@@ -0,0 +1,194 @@
+package normalize_level
+
+import (
+	"strings"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It finds the event severity in one of the configured fields and puts the canonical level into the `level_field` and the `level_num_field`.
+Canonical levels are syslog severities: `emergency`(0), `alert`(1), `critical`(2), `error`(3), `warn`(4), `notice`(5), `info`(6), `debug`(7).
+
+Supported representations:
+* names in any case, e.g. `WARNING`, `warn`, `Err`, `fatal`, `trace`
+* numbers and numeric strings from `0` to `7`
+* syslog priorities (`facility * 8 + severity`) or [bunyan](https://github.com/trentm/node-bunyan#levels) levels for numbers greater than `7`, see `numeric_scheme`
+
+If the severity isn't recognized, the event is passed untouched.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: normalize_level
+      fields: severity,lvl
+    ...
+```
+It transforms `{"severity":"WARNING"}` into `{"severity":"WARNING","level":"warn","level_num":4}`.
+}*/
+var levelAliases = map[string]int{
+	"emerg": 0,
+	"fatal": 2,
+	"panic": 2,
+	"trace": 7,
+}
+
+type Plugin struct {
+	config *Config
+	fields [][]string
+}
+
+type numericScheme int
+
+const (
+	numericSchemeSyslog numericScheme = iota
+	numericSchemeBunyan
+)
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> Comma separated list of the fields to find the severity in. The first found field is used.
+	Fields  string `json:"fields" default:"level,lvl,severity,priority" parse:"list"` //*
+	Fields_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The field to put the canonical level name to.
+	LevelField  cfg.FieldSelector `json:"level_field" default:"level" parse:"selector"` //*
+	LevelField_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The field to put the numeric level to.
+	LevelNumField  cfg.FieldSelector `json:"level_num_field" default:"level_num" parse:"selector"` //*
+	LevelNumField_ []string
+
+	//> @3@4@5@6
+	//>
+	//> How to treat numbers greater than `7`:
+	//> * `syslog` – as syslog priorities, the severity is the remainder of the division by `8`
+	//> * `bunyan` – as bunyan levels: `10` and `20` are `debug`, `30` is `info`, `40` is `warn`, `50` is `error`, `60` is `critical`
+	NumericScheme  string `json:"numeric_scheme" default:"syslog" options:"syslog|bunyan"` //*
+	NumericScheme_ numericScheme
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "normalize_level",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+
+	for _, field := range p.config.Fields_ {
+		p.fields = append(p.fields, cfg.ParseFieldSelector(field))
+	}
+
+	switch p.config.NumericScheme {
+	case "syslog":
+		p.config.NumericScheme_ = numericSchemeSyslog
+	case "bunyan":
+		p.config.NumericScheme_ = numericSchemeBunyan
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	level := pipeline.LevelUnknown
+	for _, field := range p.fields {
+		node := event.Root.Dig(field...)
+		if node == nil {
+			continue
+		}
+
+		if node.IsNumber() {
+			level = p.levelByNumber(node.AsInt())
+		} else if node.IsString() {
+			level = parseLevel(node.AsString())
+			if level == pipeline.LevelUnknown {
+				level = p.levelByNumeric(node.AsString())
+			}
+		}
+		break
+	}
+
+	if level == pipeline.LevelUnknown {
+		return pipeline.ActionPass
+	}
+
+	pipeline.CreateNestedField(event.Root, p.config.LevelField_).MutateToString(pipeline.LevelNames[level])
+	pipeline.CreateNestedField(event.Root, p.config.LevelNumField_).MutateToInt(level)
+
+	return pipeline.ActionPass
+}
+
+// parseLevel also accepts names of levels used by application loggers,
+// they aren't parsed by pipeline.ParseLevel to keep levels of other plugins, e.g. gelf, as they are.
+func parseLevel(s string) int {
+	if level, has := levelAliases[strings.ToLower(strings.TrimSpace(s))]; has {
+		return level
+	}
+
+	return pipeline.ParseLevelStrict(s)
+}
+
+// levelByNumeric parses numeric strings greater than 7 since smaller ones are parsed as levels.
+func (p *Plugin) levelByNumeric(s string) int {
+	if s == "" {
+		return pipeline.LevelUnknown
+	}
+
+	num := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' || num > 1000 {
+			return pipeline.LevelUnknown
+		}
+		num = num*10 + int(s[i]-'0')
+	}
+
+	return p.levelByNumber(num)
+}
+
+func (p *Plugin) levelByNumber(num int) int {
+	switch {
+	case num < 0:
+		return pipeline.LevelUnknown
+	case num <= 7:
+		return num
+	case p.config.NumericScheme_ == numericSchemeSyslog:
+		// facility is limited by 23
+		if num >= 24*8 {
+			return pipeline.LevelUnknown
+		}
+		return num % 8
+	}
+
+	switch {
+	case num < 30:
+		return 7
+	case num < 40:
+		return 6
+	case num < 50:
+		return 4
+	case num < 60:
+		return 3
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,38 @@
+package normalize_level
+
+import (
+	"testing"
+
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeLevel(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{}, []string{
+		`{"level":"WARNING"}`,
+		`{"lvl":3}`,
+		`{"severity":"fatal"}`,
+		`{"priority":"165"}`,
+		`{"level":"unknown","lvl":"info"}`,
+		`{"message":"no level"}`,
+	})
+
+	assert.Equal(t, 6, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"level":"warn","level_num":4}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"lvl":3,"level":"error","level_num":3}`, outEvents[1], "wrong out event")
+	assert.Equal(t, `{"severity":"fatal","level":"critical","level_num":2}`, outEvents[2], "wrong out event")
+	assert.Equal(t, `{"priority":"165","level":"notice","level_num":5}`, outEvents[3], "wrong out event")
+	assert.Equal(t, `{"level":"unknown","lvl":"info"}`, outEvents[4], "wrong out event")
+	assert.Equal(t, `{"message":"no level"}`, outEvents[5], "wrong out event")
+}
+
+func TestNormalizeLevelBunyan(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Fields: "log.level", LevelField: "log.severity", NumericScheme: "bunyan"}, []string{
+		`{"log":{"level":30}}`,
+		`{"log":{"level":"50"}}`,
+	})
+
+	assert.Equal(t, 2, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"log":{"level":30,"severity":"info"},"level_num":6}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"log":{"level":"50","severity":"error"},"level_num":3}`, outEvents[1], "wrong out event")
+}
@@ -0,0 +1,115 @@
+package parse_csv
+
+import (
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/decoder"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It parses a CSV line from the event field and adds the values to the event root using the configured column names.
+Quoted values may contain delimiters, a quote inside the quoted value is escaped by doubling it.
+Values without a column are skipped. If the line is malformed, the event is passed untouched.
+
+To parse the whole input line as CSV, set `decoder: csv` (or `decoder: tsv`) and `csv_columns` in the pipeline settings instead.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_csv
+      field: message
+      columns: [time, method, status, path]
+    ...
+```
+It transforms `{"message":"2021-06-22T16:24:27Z,GET,200,\"/api?a=1,2\""}` into
+`{"message":"2021-06-22T16:24:27Z,GET,200,\"/api?a=1,2\"","time":"2021-06-22T16:24:27Z","method":"GET","status":"200","path":"/api?a=1,2"}`.
+}*/
+type Plugin struct {
+	config    *Config
+	columns   []string
+	delimiter byte
+	values    [][]byte
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The event field to parse. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` //*
+	Field_ []string
+
+	//> @3@4@5@6
+	//>
+	//> Column names in the order of the values.
+	Columns []string `json:"columns" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A single character which separates the values. Use `\t` for TSV.
+	Delimiter string `json:"delimiter" default:","` //*
+
+	//> @3@4@5@6
+	//>
+	//> A prefix to add to the column names.
+	Prefix string `json:"prefix" default:""` //*
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_csv",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+
+	if len(p.config.Delimiter) != 1 {
+		params.Logger.Fatalf("delimiter should be a single character, got %q", p.config.Delimiter)
+	}
+	p.delimiter = p.config.Delimiter[0]
+
+	p.columns = make([]string, 0, len(p.config.Columns))
+	for _, column := range p.config.Columns {
+		p.columns = append(p.columns, p.config.Prefix+column)
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil || !node.IsString() {
+		return pipeline.ActionPass
+	}
+
+	// copy data since the field may be overwritten by the columns
+	l := len(event.Buf)
+	event.Buf = append(event.Buf, node.AsString()...)
+
+	var err error
+	p.values, err = decoder.SplitCSV(p.values[:0], event.Buf[l:], p.delimiter)
+	if err != nil {
+		return pipeline.ActionPass
+	}
+
+	for i, value := range p.values {
+		if i == len(p.columns) {
+			break
+		}
+		event.Root.AddFieldNoAlloc(event.Root, p.columns[i]).MutateToBytesCopy(event.Root, value)
+	}
+
+	return pipeline.ActionPass
+}
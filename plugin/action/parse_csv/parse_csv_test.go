@@ -0,0 +1,35 @@
+package parse_csv
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCSV(t *testing.T) {
+	config := test.NewConfig(&Config{Field: "message", Columns: []string{"time", "method", "path"}, Prefix: "csv_"}, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+	wg := &sync.WaitGroup{}
+	wg.Add(3)
+
+	outEvents := make([]string, 0)
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	input.In(0, "test.log", 0, []byte(`{"message":"2021-06-22T16:24:27Z,GET,\"/api?a=1,2\",extra"}`))
+	input.In(0, "test.log", 0, []byte(`{"message":"GET,\"unclosed"}`))
+	input.In(0, "test.log", 0, []byte(`{"message":10}`))
+
+	wg.Wait()
+	p.Stop()
+
+	assert.Equal(t, 3, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"message":"2021-06-22T16:24:27Z,GET,\"/api?a=1,2\",extra","csv_time":"2021-06-22T16:24:27Z","csv_method":"GET","csv_path":"/api?a=1,2"}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"message":"GET,\"unclosed"}`, outEvents[1], "wrong out event")
+	assert.Equal(t, `{"message":10}`, outEvents[2], "wrong out event")
+}
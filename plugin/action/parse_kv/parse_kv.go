@@ -0,0 +1,219 @@
+package parse_kv
+
+import (
+	"bytes"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It extracts `key=value` or `key: value` pairs from the string event field and adds them to the event root.
+It's useful for legacy application logs which aren't a full logfmt. Tokens that aren't pairs are skipped.
+Quoted values may contain separators, a quote inside the value should be escaped with a backslash.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_kv
+      field: message
+      prefix: kv_
+    ...
+```
+It transforms `{"message":"request done method=GET status=200 path=\"/api/v1 list\""}` into
+`{"message":"request done method=GET status=200 path=\"/api/v1 list\"","kv_method":"GET","kv_status":"200","kv_path":"/api/v1 list"}`.
+}*/
+type Plugin struct {
+	config *Config
+	value  []byte
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The event field to parse. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` //*
+	Field_ []string
+
+	//> @3@4@5@6
+	//>
+	//> A separator between a key and a value. Spaces after the separator are skipped, so `:` also handles `key: value` pairs.
+	KVSeparator string `json:"kv_separator" default:"="` //*
+
+	//> @3@4@5@6
+	//>
+	//> A separator between pairs. Spaces around pairs are always skipped.
+	PairSeparator string `json:"pair_separator" default:" "` //*
+
+	//> @3@4@5@6
+	//>
+	//> Characters which can be used to quote values.
+	Quotes string `json:"quotes" default:"\"'"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The maximum number of pairs to extract from one event. The rest of the field is ignored.
+	MaxPairs int `json:"max_pairs" default:"64"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A prefix to add to the extracted keys.
+	Prefix string `json:"prefix" default:""` //*
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_kv",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	if p.config.MaxPairs <= 0 {
+		params.Logger.Fatalf("max_pairs should be positive, got %d", p.config.MaxPairs)
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil || !node.IsString() {
+		return pipeline.ActionPass
+	}
+
+	// copy data since the field may be overwritten by extracted pairs
+	l := len(event.Buf)
+	event.Buf = append(event.Buf, node.AsString()...)
+	data := event.Buf[l:]
+
+	kvSep := []byte(p.config.KVSeparator)
+	pairSep := []byte(p.config.PairSeparator)
+
+	pairs := 0
+	pos := 0
+	for pos < len(data) && pairs < p.config.MaxPairs {
+		pos = p.skip(data, pos, pairSep)
+		if pos == len(data) {
+			break
+		}
+
+		keyStart := pos
+		for pos < len(data) && !p.isKeyEnd(data, pos, kvSep, pairSep) {
+			pos++
+		}
+		key := data[keyStart:pos]
+
+		if len(key) == 0 || !bytes.HasPrefix(data[pos:], kvSep) {
+			// it isn't a pair, go to the next token
+			pos = p.nextToken(data, pos, pairSep)
+			continue
+		}
+		pos += len(kvSep)
+		valueStart := pos
+		for pos < len(data) && data[pos] == ' ' {
+			pos++
+		}
+
+		var value []byte
+		if pos > valueStart && p.isPair(data, pos, kvSep, pairSep) {
+			// spaces are followed by the next pair, so the value is empty
+			value = data[valueStart:valueStart]
+		} else {
+			value, pos = p.readValue(data, pos, pairSep)
+		}
+
+		l := len(event.Buf)
+		event.Buf = append(event.Buf, p.config.Prefix...)
+		event.Buf = append(event.Buf, key...)
+		event.Root.AddFieldNoAlloc(event.Root, pipeline.ByteToStringUnsafe(event.Buf[l:])).MutateToBytesCopy(event.Root, value)
+		pairs++
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) readValue(data []byte, pos int, pairSep []byte) ([]byte, int) {
+	if pos < len(data) && bytes.IndexByte([]byte(p.config.Quotes), data[pos]) != -1 {
+		quote := data[pos]
+		pos++
+
+		p.value = p.value[:0]
+		for pos < len(data) {
+			c := data[pos]
+			if c == '\\' && pos+1 < len(data) && (data[pos+1] == quote || data[pos+1] == '\\') {
+				p.value = append(p.value, data[pos+1])
+				pos += 2
+				continue
+			}
+			pos++
+			if c == quote {
+				return p.value, pos
+			}
+			p.value = append(p.value, c)
+		}
+
+		return p.value, pos
+	}
+
+	start := pos
+	for pos < len(data) && !bytes.HasPrefix(data[pos:], pairSep) && data[pos] != ' ' {
+		pos++
+	}
+
+	return data[start:pos], pos
+}
+
+func (p *Plugin) isKeyEnd(data []byte, pos int, kvSep []byte, pairSep []byte) bool {
+	c := data[pos]
+	if c == ' ' || c == '\t' || bytes.IndexByte([]byte(p.config.Quotes), c) != -1 {
+		return true
+	}
+
+	return bytes.HasPrefix(data[pos:], kvSep) || bytes.HasPrefix(data[pos:], pairSep)
+}
+
+func (p *Plugin) isPair(data []byte, pos int, kvSep []byte, pairSep []byte) bool {
+	start := pos
+	for pos < len(data) && !p.isKeyEnd(data, pos, kvSep, pairSep) {
+		pos++
+	}
+
+	return pos > start && bytes.HasPrefix(data[pos:], kvSep)
+}
+
+func (p *Plugin) skip(data []byte, pos int, pairSep []byte) int {
+	for pos < len(data) {
+		if data[pos] == ' ' || data[pos] == '\t' {
+			pos++
+			continue
+		}
+		if bytes.HasPrefix(data[pos:], pairSep) {
+			pos += len(pairSep)
+			continue
+		}
+		break
+	}
+
+	return pos
+}
+
+func (p *Plugin) nextToken(data []byte, pos int, pairSep []byte) int {
+	for pos < len(data) && !bytes.HasPrefix(data[pos:], pairSep) && data[pos] != ' ' {
+		pos++
+	}
+
+	return pos
+}
@@ -0,0 +1,30 @@
+package parse_kv
+
+import (
+	"testing"
+
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKV(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Field: "message", Prefix: "kv_"}, []string{
+		`{"message":"request done method=GET status=200 path=\"/api/v1 list\" empty= user='a \\'b\\''"}`,
+		`{"message":"no pairs here"}`,
+		`{"message":10}`,
+	})
+
+	assert.Equal(t, 3, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"message":"request done method=GET status=200 path=\"/api/v1 list\" empty= user='a \\'b\\''","kv_method":"GET","kv_status":"200","kv_path":"/api/v1 list","kv_empty":"","kv_user":"a 'b'"}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"message":"no pairs here"}`, outEvents[1], "wrong out event")
+	assert.Equal(t, `{"message":10}`, outEvents[2], "wrong out event")
+}
+
+func TestParseKVSeparators(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Field: "message", KVSeparator: ":", PairSeparator: ",", MaxPairs: 2}, []string{
+		`{"message":"level: info, user:bob,skipped: true"}`,
+	})
+
+	assert.Equal(t, 1, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"message":"level: info, user:bob,skipped: true","level":"info","user":"bob"}`, outEvents[0], "wrong out event")
+}
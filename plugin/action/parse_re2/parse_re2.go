@@ -52,7 +52,7 @@ func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 func (p *Plugin) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
 	p.config = config.(*Config)
 
-	p.re = regexp.MustCompile(p.config.Re2)
+	p.re = pipeline.MustCompileRegexp(p.config.Re2)
 }
 
 func (p *Plugin) Stop() {
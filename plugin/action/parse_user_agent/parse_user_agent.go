@@ -0,0 +1,159 @@
+package parse_user_agent
+
+import (
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It parses a user-agent string from the event field into browser, os and device fields.
+Rules are loaded at startup from a [uap-core](https://github.com/ua-parser/uap-core) compatible `regexes.yaml`.
+Rules which aren't supported by go regexps are skipped. Parsed user-agents are kept in an LRU cache.
+
+If nothing is matched, the family is set to `Other`. Empty values aren't added.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_user_agent
+      field: user_agent
+      rules_file: /etc/file.d/regexes.yaml
+    ...
+```
+It transforms `{"user_agent":"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.114 Safari/537.36"}` into
+`{"user_agent":"...","ua":{"browser":{"family":"Chrome","major":"91","minor":"0","patch":"4472"},"os":{"family":"Linux"},"device":{"family":"Other"}}}`.
+}*/
+type Plugin struct {
+	config  *Config
+	ruleset *ruleset
+	cache   *simplelru.LRU
+	paths   [][][]string
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The event field with the user-agent. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` //*
+	Field_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The path to the uap-core compatible rules file.
+	RulesFile string `json:"rules_file" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The event field to put the parsed values to.
+	Target  cfg.FieldSelector `json:"target" parse:"selector" default:"ua"` //*
+	Target_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The number of parsed user-agents to keep in the cache of each processor.
+	CacheSize int `json:"cache_size" default:"10000"` //*
+}
+
+type parsed [][]string
+
+const otherFamily = "Other"
+
+var (
+	groups     = []string{"browser", "os", "device"}
+	groupNames = [][]string{
+		{"family", "major", "minor", "patch"},
+		{"family", "major", "minor", "patch"},
+		{"family", "brand", "model"},
+	}
+)
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_user_agent",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+
+	rs, err := loadRuleset(p.config.RulesFile)
+	if err != nil {
+		params.Logger.Fatalf("can't load user-agent rules from %s: %s", p.config.RulesFile, err.Error())
+	}
+	if rs.skipped > 0 {
+		params.Logger.Warnf("%d user-agent rules aren't supported and skipped", rs.skipped)
+	}
+	p.ruleset = rs
+
+	p.cache, err = simplelru.NewLRU(p.config.CacheSize, nil)
+	if err != nil {
+		params.Logger.Fatalf("can't create user-agent cache: %s", err.Error())
+	}
+
+	p.paths = make([][][]string, len(groups))
+	for i, group := range groups {
+		for _, name := range groupNames[i] {
+			path := append(append(make([]string, 0, len(p.config.Target_)+2), p.config.Target_...), group, name)
+			p.paths[i] = append(p.paths[i], path)
+		}
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil || !node.IsString() {
+		return pipeline.ActionPass
+	}
+
+	ua := node.AsString()
+	var values parsed
+	if cached, has := p.cache.Get(ua); has {
+		values = cached.(parsed)
+	} else {
+		values = p.parse(ua)
+		// copy the key since it points to the event memory
+		p.cache.Add(string([]byte(ua)), values)
+	}
+
+	for i, groupValues := range values {
+		for j, value := range groupValues {
+			if value == "" {
+				continue
+			}
+			pipeline.CreateNestedField(event.Root, p.paths[i][j]).MutateToString(value)
+		}
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) parse(ua string) parsed {
+	values := parsed{
+		match(p.ruleset.browsers, ua, nil),
+		match(p.ruleset.oses, ua, nil),
+		match(p.ruleset.devices, ua, nil),
+	}
+
+	for i := range values {
+		if values[i] == nil {
+			values[i] = []string{otherFamily}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,83 @@
+package parse_user_agent
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rules = `
+user_agent_parsers:
+  - regex: '(?<!Mobile )(Firefox)/(\d+)\.(\d+)'
+  - regex: '(Chrome)/(\d+)\.(\d+)\.(\d+)'
+  - regex: '(YaBrowser)/(\d+)'
+    family_replacement: 'Yandex Browser'
+os_parsers:
+  - regex: '(Android) (\d+)'
+  - regex: '(Linux)'
+device_parsers:
+  - regex: '; (SM-\w+)'
+    device_replacement: 'Samsung $1'
+    brand_replacement: 'Samsung'
+  - regex: 'iphone'
+    regex_flag: 'i'
+    device_replacement: 'iPhone'
+    brand_replacement: 'Apple'
+`
+
+func writeRules(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "parse_user_agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "regexes.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(rules), 0o644))
+
+	return filename
+}
+
+func TestParseUserAgent(t *testing.T) {
+	config := test.NewConfig(&Config{Field: "user_agent", RulesFile: writeRules(t)}, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+	wg := &sync.WaitGroup{}
+	wg.Add(4)
+
+	outEvents := make([]string, 0)
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	input.In(0, "test.log", 0, []byte(`{"user_agent":"Mozilla/5.0 (Linux; Android 11; SM-G991B) Chrome/91.0.4472.114 Mobile"}`))
+	input.In(0, "test.log", 0, []byte(`{"user_agent":"Mozilla/5.0 (Linux; Android 11; SM-G991B) Chrome/91.0.4472.114 Mobile"}`))
+	input.In(0, "test.log", 0, []byte(`{"user_agent":"curl/7.64.1"}`))
+	input.In(0, "test.log", 0, []byte(`{"user_agent":10}`))
+
+	wg.Wait()
+	p.Stop()
+
+	assert.Equal(t, 4, len(outEvents), "wrong out events count")
+	parsed := `{"user_agent":"Mozilla/5.0 (Linux; Android 11; SM-G991B) Chrome/91.0.4472.114 Mobile","ua":{"browser":{"family":"Chrome","major":"91","minor":"0","patch":"4472"},"os":{"family":"Android","major":"11"},"device":{"family":"Samsung SM-G991B","brand":"Samsung","model":"SM-G991B"}}}`
+	assert.Equal(t, parsed, outEvents[0], "wrong out event")
+	assert.Equal(t, parsed, outEvents[1], "wrong cached out event")
+	assert.Equal(t, `{"user_agent":"curl/7.64.1","ua":{"browser":{"family":"Other"},"os":{"family":"Other"},"device":{"family":"Other"}}}`, outEvents[2], "wrong out event")
+	assert.Equal(t, `{"user_agent":10}`, outEvents[3], "wrong out event")
+}
+
+func TestParseRuleset(t *testing.T) {
+	rs, err := parseRuleset([]byte(rules))
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, rs.skipped, "lookbehind rule should be skipped")
+	assert.Equal(t, []string{"Yandex Browser", "21", "", ""}, match(rs.browsers, "YaBrowser/21.5", nil))
+	assert.Equal(t, []string{"iPhone", "Apple", ""}, match(rs.devices, "Mozilla/5.0 (IPHONE; CPU OS 14_6)", nil))
+	assert.Nil(t, match(rs.oses, "Windows NT", nil))
+}
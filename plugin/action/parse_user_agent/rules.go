@@ -0,0 +1,145 @@
+package parse_user_agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/ozonru/file.d/pipeline"
+	"gopkg.in/yaml.v3"
+)
+
+// ruleset is a uap-core compatible set of parsers, see https://github.com/ua-parser/uap-core/blob/master/regexes.yaml.
+type ruleset struct {
+	browsers []*rule
+	oses     []*rule
+	devices  []*rule
+	skipped  int
+}
+
+type rule struct {
+	re           *regexp.Regexp
+	replacements []string
+}
+
+type rawRuleset struct {
+	UserAgentParsers []map[string]string `yaml:"user_agent_parsers"`
+	OSParsers        []map[string]string `yaml:"os_parsers"`
+	DeviceParsers    []map[string]string `yaml:"device_parsers"`
+}
+
+var (
+	browserReplacements = []string{"family_replacement", "v1_replacement", "v2_replacement", "v3_replacement"}
+	osReplacements      = []string{"os_replacement", "os_v1_replacement", "os_v2_replacement", "os_v3_replacement"}
+	deviceReplacements  = []string{"device_replacement", "brand_replacement", "model_replacement"}
+
+	// device model is the first group by default, brand has no default
+	deviceDefaults = []string{"$1", "", "$1"}
+)
+
+// loadRuleset shares the ruleset across all plugin instances since it's immutable and heavy to compile.
+func loadRuleset(filename string) (*ruleset, error) {
+	rs, err := pipeline.LoadShared("parse_user_agent:"+filename, func() (interface{}, error) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("can't read rules file: %s", err.Error())
+		}
+
+		return parseRuleset(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.(*ruleset), nil
+}
+
+func parseRuleset(data []byte) (*ruleset, error) {
+	raw := &rawRuleset{}
+	if err := yaml.Unmarshal(data, raw); err != nil {
+		return nil, fmt.Errorf("can't parse rules: %s", err.Error())
+	}
+
+	rs := &ruleset{}
+	rs.browsers = rs.compile(raw.UserAgentParsers, browserReplacements, nil)
+	rs.oses = rs.compile(raw.OSParsers, osReplacements, nil)
+	rs.devices = rs.compile(raw.DeviceParsers, deviceReplacements, deviceDefaults)
+
+	return rs, nil
+}
+
+// compile skips rules which can't be compiled since uap-core regexps are PCRE and some of them aren't supported by go.
+func (rs *ruleset) compile(raw []map[string]string, names []string, defaults []string) []*rule {
+	rules := make([]*rule, 0, len(raw))
+	for _, r := range raw {
+		expr := r["regex"]
+		if r["regex_flag"] == "i" {
+			expr = "(?i)" + expr
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			rs.skipped++
+			continue
+		}
+
+		replacements := make([]string, len(names))
+		for i, name := range names {
+			replacement, has := r[name]
+			switch {
+			case has:
+				replacements[i] = replacement
+			case defaults != nil:
+				replacements[i] = defaults[i]
+			default:
+				replacements[i] = fmt.Sprintf("$%d", i+1)
+			}
+		}
+
+		rules = append(rules, &rule{re: re, replacements: replacements})
+	}
+
+	return rules
+}
+
+// match returns values of the first matched rule or nil.
+func match(rules []*rule, ua string, out []string) []string {
+	for _, r := range rules {
+		groups := r.re.FindStringSubmatch(ua)
+		if groups == nil {
+			continue
+		}
+
+		for _, replacement := range r.replacements {
+			out = append(out, expand(replacement, groups))
+		}
+
+		return out
+	}
+
+	return nil
+}
+
+// expand substitutes `$1`-`$9` in the replacement with the matched groups.
+func expand(replacement string, groups []string) string {
+	if strings.IndexByte(replacement, '$') == -1 {
+		return replacement
+	}
+
+	b := strings.Builder{}
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		if c == '$' && i+1 < len(replacement) && replacement[i+1] >= '1' && replacement[i+1] <= '9' {
+			group := int(replacement[i+1] - '0')
+			if group < len(groups) {
+				b.WriteString(groups[group])
+			}
+			i++
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,282 @@
+package parse_xml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+/*{ introduction
+It parses an XML string from the event field and replaces the field with the JSON subtree.
+* An element becomes an object with the element name as a key.
+* Attributes become string fields with the `attribute_prefix`.
+* Repeated child elements become an array.
+* An element which has only text becomes a string, otherwise the text is put into the `text_field`.
+
+If the XML is malformed or exceeds the limits, the event is passed untouched.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_xml
+      field: message
+    ...
+```
+It transforms `{"message":"<Event><System Provider=\"app\"><EventID>4624</EventID></System></Event>"}` into
+`{"message":{"Event":{"System":{"@Provider":"app","EventID":"4624"}}}}`.
+}*/
+type Plugin struct {
+	config *Config
+	reader *bytes.Reader
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The event field to parse. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` //*
+	Field_ []string
+
+	//> @3@4@5@6
+	//>
+	//> A prefix to add to attribute names.
+	AttributePrefix string `json:"attribute_prefix" default:"@"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A field name for the text of elements which also have attributes or children.
+	TextField string `json:"text_field" default:"#text"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The maximum nesting depth of elements.
+	MaxDepth int `json:"max_depth" default:"32"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The maximum size of the XML in bytes.
+	MaxSize int `json:"max_size" default:"65536"` //*
+}
+
+type element struct {
+	name     string
+	attrs    []xml.Attr
+	children []*element
+	text     []byte
+}
+
+var errTooDeep = errors.New("max depth is exceeded")
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_xml",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.reader = bytes.NewReader(nil)
+
+	if p.config.MaxDepth <= 0 {
+		params.Logger.Fatalf("max_depth should be positive, got %d", p.config.MaxDepth)
+	}
+	if p.config.MaxSize <= 0 {
+		params.Logger.Fatalf("max_size should be positive, got %d", p.config.MaxSize)
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil || !node.IsString() {
+		return pipeline.ActionPass
+	}
+
+	data := node.AsBytes()
+	if len(data) > p.config.MaxSize {
+		return pipeline.ActionPass
+	}
+
+	root, err := p.parse(data)
+	if err != nil || root == nil {
+		return pipeline.ActionPass
+	}
+
+	// decoded JSON should live as long as the event
+	l := len(event.Buf)
+	event.Buf = append(event.Buf, '{')
+	event.Buf = p.encode(event.Buf, root)
+	event.Buf = append(event.Buf, '}')
+
+	decoded, err := event.SubparseJSON(event.Buf[l:])
+	if err != nil {
+		return pipeline.ActionPass
+	}
+	node.MutateToNode(decoded)
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) parse(data []byte) (*element, error) {
+	p.reader.Reset(data)
+	decoder := xml.NewDecoder(p.reader)
+
+	var root *element
+	stack := make([]*element, 0, p.config.MaxDepth)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(stack) == p.config.MaxDepth {
+				return nil, errTooDeep
+			}
+
+			el := &element{name: t.Name.Local, attrs: t.Attr}
+			if len(stack) == 0 {
+				root = el
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, el)
+			}
+			stack = append(stack, el)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			el := stack[len(stack)-1]
+			el.text = append(el.text, t...)
+		}
+	}
+}
+
+func (p *Plugin) encode(out []byte, el *element) []byte {
+	out = appendString(out, el.name)
+	out = append(out, ':')
+
+	return p.encodeValue(out, el)
+}
+
+func (p *Plugin) encodeValue(out []byte, el *element) []byte {
+	text := bytes.TrimSpace(el.text)
+	if len(el.attrs) == 0 && len(el.children) == 0 {
+		return appendString(out, string(text))
+	}
+
+	out = append(out, '{')
+	isFirst := true
+	comma := func() {
+		if !isFirst {
+			out = append(out, ',')
+		}
+		isFirst = false
+	}
+
+	for _, attr := range el.attrs {
+		comma()
+		out = appendString(out, p.config.AttributePrefix+attr.Name.Local)
+		out = append(out, ':')
+		out = appendString(out, attr.Value)
+	}
+
+	for i, child := range el.children {
+		if isEncoded(el.children, i) {
+			continue
+		}
+
+		comma()
+		count := 0
+		for _, c := range el.children[i:] {
+			if c.name == child.name {
+				count++
+			}
+		}
+		if count == 1 {
+			out = p.encode(out, child)
+			continue
+		}
+
+		out = appendString(out, child.name)
+		out = append(out, ':', '[')
+		for _, c := range el.children[i:] {
+			if c.name != child.name {
+				continue
+			}
+			if c != child {
+				out = append(out, ',')
+			}
+			out = p.encodeValue(out, c)
+		}
+		out = append(out, ']')
+	}
+
+	if len(text) > 0 {
+		comma()
+		out = appendString(out, p.config.TextField)
+		out = append(out, ':')
+		out = appendString(out, string(text))
+	}
+
+	return append(out, '}')
+}
+
+// isEncoded checks whether the element with the same name is already encoded as a part of an array.
+func isEncoded(children []*element, i int) bool {
+	for _, c := range children[:i] {
+		if c.name == children[i].name {
+			return true
+		}
+	}
+
+	return false
+}
+
+const hex = "0123456789abcdef"
+
+func appendString(out []byte, s string) []byte {
+	out = append(out, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c == '\n':
+			out = append(out, '\\', 'n')
+		case c == '\r':
+			out = append(out, '\\', 'r')
+		case c == '\t':
+			out = append(out, '\\', 't')
+		case c < 0x20:
+			out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return append(out, '"')
+}
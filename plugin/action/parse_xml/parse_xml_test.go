@@ -0,0 +1,36 @@
+package parse_xml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseXML(t *testing.T) {
+	outEvents := test.RunAction(factory, &Config{Field: "message"}, []string{
+		`{"message":"<?xml version=\"1.0\"?><Event><System Provider=\"app\"><EventID>4624</EventID></System><Data Name=\"a\">1</Data><Data Name=\"b\">say \"hi\"</Data><Empty/></Event>"}`,
+		`{"message":"<Event><Unclosed></Event>"}`,
+		`{"message":10}`,
+	})
+
+	assert.Equal(t, 3, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"message":{"Event":{"System":{"@Provider":"app","EventID":"4624"},"Data":[{"@Name":"a","#text":"1"},{"@Name":"b","#text":"say \"hi\""}],"Empty":""}}}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"message":"<Event><Unclosed></Event>"}`, outEvents[1], "wrong out event")
+	assert.Equal(t, `{"message":10}`, outEvents[2], "wrong out event")
+}
+
+func TestParseXMLLimits(t *testing.T) {
+	deep := strings.Repeat("<a>", 4) + strings.Repeat("</a>", 4)
+	outEvents := test.RunAction(factory, &Config{Field: "message", MaxDepth: 3, MaxSize: 64}, []string{
+		`{"message":"` + deep + `"}`,
+		`{"message":"<a>` + strings.Repeat("x", 64) + `</a>"}`,
+		`{"message":"<a><a><a>ok</a></a></a>"}`,
+	})
+
+	assert.Equal(t, 3, len(outEvents), "wrong out events count")
+	assert.Equal(t, `{"message":"`+deep+`"}`, outEvents[0], "wrong out event")
+	assert.Equal(t, `{"message":"<a>`+strings.Repeat("x", 64)+`</a>"}`, outEvents[1], "wrong out event")
+	assert.Equal(t, `{"message":{"a":{"a":{"a":"ok"}}}}`, outEvents[2], "wrong out event")
+}
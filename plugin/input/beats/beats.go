@@ -0,0 +1,208 @@
+package beats
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/decoder"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/longpanic"
+	"github.com/ozonru/file.d/netutil"
+	"github.com/ozonru/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It receives events from Beats agents (filebeat, winlogbeat, etc.) and other clients using the Lumberjack protocol.
+So agents configured with the Logstash output can send events to `file.d` directly.
+
+Events are acked by windows: the ack for the window is sent only when all its events are committed by the pipeline output.
+While the window is being processed the plugin doesn't read the connection and sends keepalive acks,
+so slow pipeline slows down agents instead of making them resend events.
+> It guarantees "at-least-once delivery" due to the commitment mechanism.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    input:
+      type: beats
+      address: ":5044"
+      tls_cert_file: /etc/file.d/cert.pem
+      tls_key_file: /etc/file.d/key.pem
+    ...
+```
+}*/
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	listener   net.Listener
+
+	sourceSeq *atomic.Uint64
+	conns     map[pipeline.SourceID]*beatsConn
+	connsMu   *sync.RWMutex
+	isStopped *atomic.Bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> An address to listen to. Omit ip/host to listen all network interfaces, both IPv4 and IPv6. E.g. `:5044`.
+	//> IPv6 hosts should be in brackets, e.g. `[::1]:5044`
+	Address string `json:"address" default:":5044"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A path to the PEM encoded TLS certificate. TLS is enabled if it's set along with `tls_key_file`.
+	TLSCertFile string `json:"tls_cert_file"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A path to the PEM encoded TLS private key.
+	TLSKeyFile string `json:"tls_key_file"` //*
+
+	//> @3@4@5@6
+	//>
+	//> An interval of keepalive acks which are sent while the window is waiting for the commit.
+	KeepaliveInterval  cfg.Duration `json:"keepalive_interval" default:"3s" parse:"duration"` //*
+	KeepaliveInterval_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> The maximum size of a frame in bytes. The connection is closed if a frame is bigger.
+	MaxFrameSize int `json:"max_frame_size" default:"10485760"` //*
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    "beats",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.controller = params.Controller
+	p.controller.SuggestDecoder(decoder.JSON)
+
+	p.sourceSeq = atomic.NewUint64(0)
+	p.conns = make(map[pipeline.SourceID]*beatsConn)
+	p.connsMu = &sync.RWMutex{}
+	p.isStopped = atomic.NewBool(false)
+
+	if p.config.MaxFrameSize <= 0 {
+		p.logger.Fatalf("max_frame_size should be positive, got %d", p.config.MaxFrameSize)
+	}
+	if p.config.KeepaliveInterval_ <= 0 {
+		p.logger.Fatalf("keepalive_interval should be positive, got %s", p.config.KeepaliveInterval)
+	}
+	if (p.config.TLSCertFile == "") != (p.config.TLSKeyFile == "") {
+		p.logger.Fatalf("both tls_cert_file and tls_key_file should be set")
+	}
+
+	var err error
+	p.listener, err = netutil.Listen("tcp", p.config.Address)
+	if err != nil {
+		p.logger.Fatalf("can't listen %s: %s", p.config.Address, err.Error())
+	}
+
+	if p.config.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.config.TLSCertFile, p.config.TLSKeyFile)
+		if err != nil {
+			p.logger.Fatalf("can't load tls certificate: %s", err.Error())
+		}
+		p.listener = tls.NewListener(p.listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	longpanic.Go(p.accept)
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	_ = p.listener.Close()
+
+	p.connsMu.RLock()
+	for _, c := range p.conns {
+		_ = c.conn.Close()
+	}
+	p.connsMu.RUnlock()
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.connsMu.RLock()
+	c, has := p.conns[event.SourceID]
+	p.connsMu.RUnlock()
+
+	// connection is already closed, so the agent will resend the window
+	if !has {
+		return
+	}
+
+	c.pending.Done()
+}
+
+func (p *Plugin) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if p.isStopped.Load() {
+				return
+			}
+			p.logger.Errorf("can't accept connection: %s", err.Error())
+			continue
+		}
+
+		longpanic.Go(func() {
+			p.serve(conn)
+		})
+	}
+}
+
+func (p *Plugin) serve(conn net.Conn) {
+	c := &beatsConn{
+		conn:              conn,
+		reader:            bufio.NewReader(conn),
+		sourceID:          pipeline.SourceID(p.sourceSeq.Inc()),
+		sourceName:        conn.RemoteAddr().String(),
+		isNewSource:       true,
+		controller:        p.controller,
+		maxFrameSize:      p.config.MaxFrameSize,
+		keepaliveInterval: p.config.KeepaliveInterval_,
+		pending:           &sync.WaitGroup{},
+		root:              insaneJSON.Spawn(),
+	}
+
+	p.connsMu.Lock()
+	p.conns[c.sourceID] = c
+	p.connsMu.Unlock()
+
+	defer func() {
+		p.connsMu.Lock()
+		delete(p.conns, c.sourceID)
+		p.connsMu.Unlock()
+
+		insaneJSON.Release(c.root)
+		_ = conn.Close()
+	}()
+
+	err := c.serve()
+	if err != nil && !p.isStopped.Load() {
+		p.logger.Warnf("beats connection %s is closed: %s", c.sourceName, err.Error())
+	}
+}
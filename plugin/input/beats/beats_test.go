@@ -0,0 +1,163 @@
+package beats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getInputInfo() *pipeline.InputPluginInfo {
+	input, _ := Factory()
+	return &pipeline.InputPluginInfo{
+		PluginStaticInfo: &pipeline.PluginStaticInfo{
+			Type:    "",
+			Factory: nil,
+			Config: &Config{
+				Address:            "127.0.0.1:0",
+				KeepaliveInterval_: time.Second,
+				MaxFrameSize:       1024,
+			},
+		},
+		PluginRuntimeInfo: &pipeline.PluginRuntimeInfo{
+			Plugin: input,
+			ID:     "",
+		},
+	}
+}
+
+func appendUint32(out []byte, x uint32) []byte {
+	b := [4]byte{}
+	binary.BigEndian.PutUint32(b[:], x)
+	return append(out, b[:]...)
+}
+
+func jsonFrame(seq uint32, json string) []byte {
+	out := []byte{protocolV2, frameJSON}
+	out = appendUint32(out, seq)
+	out = appendUint32(out, uint32(len(json)))
+	return append(out, json...)
+}
+
+func dataFrame(seq uint32, pairs ...string) []byte {
+	out := []byte{protocolV2, frameData}
+	out = appendUint32(out, seq)
+	out = appendUint32(out, uint32(len(pairs)/2))
+	for _, s := range pairs {
+		out = appendUint32(out, uint32(len(s)))
+		out = append(out, s...)
+	}
+	return out
+}
+
+func windowFrame(size uint32) []byte {
+	return appendUint32([]byte{protocolV2, frameWindow}, size)
+}
+
+func compressedFrame(frames ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	for _, frame := range frames {
+		_, _ = w.Write(frame)
+	}
+	_ = w.Close()
+
+	out := []byte{protocolV2, frameCompressed}
+	out = appendUint32(out, uint32(buf.Len()))
+	return append(out, buf.Bytes()...)
+}
+
+func readAck(t *testing.T, conn net.Conn) uint32 {
+	for {
+		ack := make([]byte, 6)
+		_, err := io.ReadFull(conn, ack)
+		require.NoError(t, err)
+		require.Equal(t, []byte{protocolV2, frameAck}, ack[:2], "wrong ack frame")
+
+		seq := binary.BigEndian.Uint32(ack[2:])
+		// skip keepalive acks
+		if seq != 0 {
+			return seq
+		}
+	}
+}
+
+func TestWindowAcks(t *testing.T) {
+	p, _, output := test.NewPipelineMock(nil, "passive")
+	p.SetInput(getInputInfo())
+	input := p.GetInput().(*Plugin)
+	p.Start()
+	defer p.Stop()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(5)
+
+	outEvents := make([]string, 0)
+	mu := &sync.Mutex{}
+	output.SetOutFn(func(event *pipeline.Event) {
+		mu.Lock()
+		outEvents = append(outEvents, event.Root.EncodeToString())
+		mu.Unlock()
+		wg.Done()
+	})
+
+	conn, err := net.Dial("tcp", input.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(windowFrame(2))
+	require.NoError(t, err)
+	_, err = conn.Write(jsonFrame(1, `{"message":"1"}`))
+	require.NoError(t, err)
+	_, err = conn.Write(dataFrame(2, "message", "2", "host", "node1"))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), readAck(t, conn), "wrong ack")
+
+	_, err = conn.Write(windowFrame(3))
+	require.NoError(t, err)
+	_, err = conn.Write(compressedFrame(
+		jsonFrame(1, `{"message":"3"}`),
+		jsonFrame(2, `{"message":"4"}`),
+		jsonFrame(3, `{"message":"5"}`),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), readAck(t, conn), "wrong ack")
+
+	wg.Wait()
+
+	require.Equal(t, 5, len(outEvents), "wrong events count")
+	assert.Equal(t, `{"message":"1"}`, outEvents[0], "wrong event")
+	assert.Equal(t, `{"message":"2","host":"node1"}`, outEvents[1], "wrong event")
+	assert.Equal(t, `{"message":"5"}`, outEvents[4], "wrong event")
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	p, _, _ := test.NewPipelineMock(nil, "passive")
+	p.SetInput(getInputInfo())
+	input := p.GetInput().(*Plugin)
+	p.Start()
+	defer p.Stop()
+
+	conn, err := net.Dial("tcp", input.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(jsonFrame(1, string(make([]byte, 2048))))
+	require.NoError(t, err)
+
+	// connection should be closed by the plugin
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err, "connection isn't closed")
+	netErr, ok := err.(net.Error)
+	assert.False(t, ok && netErr.Timeout(), "connection isn't closed")
+}
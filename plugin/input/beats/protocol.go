@@ -0,0 +1,293 @@
+package beats
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/longpanic"
+	"github.com/ozonru/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// Lumberjack protocol frames, see https://github.com/elastic/go-lumber.
+// Each frame starts with the protocol version and the frame type.
+const (
+	protocolV1 = '1'
+	protocolV2 = '2'
+
+	frameWindow     = 'W' // uint32 window size
+	frameCompressed = 'C' // uint32 length, zlib compressed frames
+	frameJSON       = 'J' // uint32 seq, uint32 length, JSON payload
+	frameData       = 'D' // uint32 seq, uint32 pairs count, pairs of uint32 length prefixed key and value
+	frameAck        = 'A' // uint32 seq
+)
+
+// beatsConn is a state of the agent connection.
+type beatsConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	sourceID    pipeline.SourceID
+	sourceName  string
+	isNewSource bool
+
+	controller        pipeline.InputPluginController
+	maxFrameSize      int
+	keepaliveInterval time.Duration
+
+	version byte
+	window  uint32
+	count   uint32
+	lastSeq uint32
+	pending *sync.WaitGroup // events of the current window which aren't committed yet
+
+	header  [8]byte
+	buf     []byte
+	event   []byte
+	root    *insaneJSON.Root
+	zlib    io.ReadCloser
+	zreader *bufio.Reader
+}
+
+func (c *beatsConn) serve() error {
+	for {
+		err := c.readFrame(c.reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *beatsConn) readFrame(r io.Reader) error {
+	if _, err := io.ReadFull(r, c.header[:2]); err != nil {
+		return err
+	}
+
+	version, frameType := c.header[0], c.header[1]
+	if version != protocolV1 && version != protocolV2 {
+		return fmt.Errorf("unsupported protocol version %q", version)
+	}
+
+	switch frameType {
+	case frameWindow:
+		size, err := c.readUint32(r)
+		if err != nil {
+			return err
+		}
+		c.version = version
+		c.window = size
+		c.count = 0
+		return nil
+	case frameCompressed:
+		return c.readCompressed(r)
+	case frameJSON:
+		seq, err := c.readUint32(r)
+		if err != nil {
+			return err
+		}
+		c.buf, err = c.readBytes(r, c.buf[:0])
+		if err != nil {
+			return err
+		}
+		return c.in(seq, c.buf)
+	case frameData:
+		return c.readData(r)
+	default:
+		return fmt.Errorf("unknown frame type %q", frameType)
+	}
+}
+
+func (c *beatsConn) readCompressed(r io.Reader) error {
+	size, err := c.readSize(r)
+	if err != nil {
+		return err
+	}
+
+	compressed := io.LimitReader(r, int64(size))
+	if c.zlib == nil {
+		c.zlib, err = zlib.NewReader(compressed)
+	} else {
+		err = c.zlib.(zlib.Resetter).Reset(compressed, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	// frames inside are read from the separate reader, so nested compressed frames aren't supported
+	if c.zreader == nil {
+		c.zreader = bufio.NewReader(c.zlib)
+	} else {
+		c.zreader.Reset(c.zlib)
+	}
+	zr := c.zreader
+	for {
+		if _, err := zr.Peek(1); err == io.EOF {
+			break
+		}
+
+		frameType, err := zr.Peek(2)
+		if err != nil {
+			return err
+		}
+		if frameType[1] == frameCompressed {
+			return fmt.Errorf("nested compressed frames aren't supported")
+		}
+
+		if err := c.readFrame(zr); err != nil {
+			return err
+		}
+	}
+
+	// skip zlib checksum and padding if any
+	_, err = io.Copy(ioutil.Discard, compressed)
+
+	return err
+}
+
+func (c *beatsConn) readData(r io.Reader) error {
+	seq, err := c.readUint32(r)
+	if err != nil {
+		return err
+	}
+	pairs, err := c.readUint32(r)
+	if err != nil {
+		return err
+	}
+
+	_ = c.root.DecodeString("{}")
+	for i := uint32(0); i < pairs; i++ {
+		c.buf, err = c.readBytes(r, c.buf[:0])
+		if err != nil {
+			return err
+		}
+		key := string(c.buf)
+
+		c.buf, err = c.readBytes(r, c.buf[:0])
+		if err != nil {
+			return err
+		}
+		c.root.AddFieldNoAlloc(c.root, key).MutateToString(string(c.buf))
+	}
+
+	c.event = c.root.Encode(c.event[:0])
+
+	return c.in(seq, c.event)
+}
+
+func (c *beatsConn) in(seq uint32, data []byte) error {
+	c.pending.Add(1)
+	seqID := c.controller.In(c.sourceID, c.sourceName, int64(seq), data, c.isNewSource)
+	c.isNewSource = false
+
+	// event is skipped by the pipeline, so there will be no commit
+	if seqID == 0 {
+		c.pending.Done()
+	}
+
+	c.count++
+	c.lastSeq = seq
+
+	// window may be not sent by old clients, so each event is acked
+	if c.count < c.window {
+		return nil
+	}
+
+	return c.ackWindow()
+}
+
+// ackWindow waits until all events of the window are committed and acks the last one.
+// Connection isn't read meanwhile, so agents are slowed down by TCP back-pressure.
+func (c *beatsConn) ackWindow() error {
+	done := make(chan struct{})
+	longpanic.Go(func() {
+		c.pending.Wait()
+		close(done)
+	})
+
+	ticker := time.NewTicker(c.keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			c.count = 0
+			return c.writeAck(c.lastSeq)
+		case <-ticker.C:
+			// ack with zero seq doesn't ack anything, but resets the agent timeout
+			if err := c.writeAck(0); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *beatsConn) writeAck(seq uint32) error {
+	version := c.version
+	if version == 0 {
+		version = protocolV2
+	}
+
+	ack := [6]byte{version, frameAck}
+	binary.BigEndian.PutUint32(ack[2:], seq)
+	_, err := c.conn.Write(ack[:])
+
+	return err
+}
+
+func (c *beatsConn) readUint32(r io.Reader) (uint32, error) {
+	if _, err := io.ReadFull(r, c.header[:4]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+
+	return binary.BigEndian.Uint32(c.header[:4]), nil
+}
+
+func (c *beatsConn) readSize(r io.Reader) (int, error) {
+	size, err := c.readUint32(r)
+	if err != nil {
+		return 0, err
+	}
+	if int64(size) > int64(c.maxFrameSize) {
+		return 0, fmt.Errorf("frame size %d exceeds max_frame_size %d", size, c.maxFrameSize)
+	}
+
+	return int(size), nil
+}
+
+func (c *beatsConn) readBytes(r io.Reader, out []byte) ([]byte, error) {
+	size, err := c.readSize(r)
+	if err != nil {
+		return out, err
+	}
+
+	l := len(out)
+	if cap(out)-l < size {
+		out = append(out, make([]byte, size)...)
+	} else {
+		out = out[:l+size]
+	}
+
+	if _, err := io.ReadFull(r, out[l:]); err != nil {
+		return out, unexpectedEOF(err)
+	}
+
+	return out, nil
+}
+
+// unexpectedEOF converts EOF in the middle of the frame into an error.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
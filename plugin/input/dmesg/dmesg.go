@@ -104,6 +104,10 @@ func (p *Plugin) read() {
 }
 
 func (p *Plugin) Stop() {
+	// the channel of messages is closed after the reader is closed, so the reading goroutine exits
+	if err := p.parser.Close(); err != nil {
+		p.logger.Errorf("can't close kmsg parser: %s", err.Error())
+	}
 }
 
 func (p *Plugin) Commit(event *pipeline.Event) {
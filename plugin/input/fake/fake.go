@@ -12,6 +12,7 @@ type Plugin struct {
 	controller pipeline.InputPluginController
 	commitFn   func(event *pipeline.Event)
 	inFn       func()
+	pauseFn    func(sourceID pipeline.SourceID, paused bool)
 }
 
 type Config struct {
@@ -41,6 +42,18 @@ func (p *Plugin) Commit(event *pipeline.Event) {
 	}
 }
 
+func (p *Plugin) PauseSource(sourceID pipeline.SourceID) {
+	if p.pauseFn != nil {
+		p.pauseFn(sourceID, true)
+	}
+}
+
+func (p *Plugin) ResumeSource(sourceID pipeline.SourceID) {
+	if p.pauseFn != nil {
+		p.pauseFn(sourceID, false)
+	}
+}
+
 //! fn-list
 //^ fn-list
 
@@ -61,3 +74,8 @@ func (p *Plugin) SetCommitFn(fn func(event *pipeline.Event)) { //*
 func (p *Plugin) SetInFn(fn func()) { //*
 	p.inFn = fn
 }
+
+//> It sets up a hook to make sure the pipeline pauses and resumes sources which have too many events in flight.
+func (p *Plugin) SetPauseFn(fn func(sourceID pipeline.SourceID, paused bool)) { //*
+	p.pauseFn = fn
+}
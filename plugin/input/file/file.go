@@ -1,7 +1,9 @@
 package file
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ozonru/file.d/cfg"
@@ -48,8 +50,14 @@ type Plugin struct {
 
 	workers     []*worker
 	jobProvider *jobProvider
+	gate        *pipeline.InputGate
 }
 
+const (
+	formatJSONStream = "json_stream"
+	eofModeFlush     = "flush"
+)
+
 type persistenceMode int
 
 const (
@@ -117,8 +125,31 @@ type Config struct {
 	//>
 	//> The buffer size used for the file reading.
 	//> > Each worker uses its own buffer so that final memory consumption will be `read_buffer_size*workers_count`.
+	//> > Lines are found by `bytes.IndexByte` over the whole buffer, which is vectorized on amd64 and arm64,
+	//> > so the buffer size mostly affects the count of read syscalls, buffers much bigger than CPU caches are usually slower.
 	ReadBufferSize int `json:"read_buffer_size" default:"131072"` //*
 
+	//> @3@4@5@6
+	//>
+	//> How files are read:
+	//> * `buffered` – files are read into the buffer of the worker
+	//> * `mmap` – big cold files, e.g. multi-GB historical logs of the backfill, are memory-mapped with the sequential access advice
+	//> and processed by chunks of `mmap_chunk_size`, a worker takes the whole chunk in one turn of the job. Other files are read the buffered way.
+	//> > A file is cold if it isn't modified for a minute, mapped files must not be truncated, since reading truncated pages crashes `file.d`.
+	//> > It isn't supported on windows, files are read the buffered way there.
+	ReadMode string `json:"read_mode" default:"buffered" options:"buffered|mmap"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The min size of the file to map it in the `mmap` read mode.
+	MmapMinSize int `json:"mmap_min_size" default:"104857600"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The size of the mapped region of the file in the `mmap` read mode.
+	//> > Each worker maps its own chunk, so the virtual memory of mappings is up to `mmap_chunk_size*workers_count`, pages are in the page cache.
+	MmapChunkSize int `json:"mmap_chunk_size" default:"67108864"` //*
+
 	//> @3@4@5@6
 	//>
 	//> The max amount of opened files. If the limit is exceeded, `file.d` will exit with fatal.
@@ -155,6 +186,51 @@ type Config struct {
 	//> @maintenance
 	MaintenanceInterval  cfg.Duration `json:"maintenance_interval" default:"10s" parse:"duration"` //*
 	MaintenanceInterval_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> The number of replicas watching the same directory, e.g. on a fat host with a shared volume.
+	//> Files are distributed between replicas by the consistent hash of the file inode, so each file is read by exactly one replica.
+	//> Renamed files stay on the same replica. `1` disables sharding.
+	//> > The shard index is added to the `offsets_file` name, e.g. `/data/offsets.yaml.shard2`, so replicas can share the config.
+	ShardsCount int `json:"shards_count" default:"1"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The index of this replica from `0` to `shards_count-1`.
+	//> `hostname` takes the number from the end of the host name, e.g. `2` for the `file-d-2` pod of a StatefulSet.
+	ShardIndex  string `json:"shard_index" default:"0"` //*
+	ShardIndex_ int
+
+	//> @3@4@5@6
+	//>
+	//> How events are delimited in files:
+	//> * `lines` – each line is an event
+	//> * `json_stream` – each top-level JSON object is an event, e.g. elements of one huge JSON array
+	//> or concatenated objects without line breaks. Only objects are events, other values between them are skipped.
+	Format string `json:"format" default:"lines" options:"lines|json_stream"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The delimiter of records in the `lines` format. It may be longer than one char, e.g. `\r\n` or `----`,
+	//> escape sequences like `\x00` are supported. The delimiter isn't a part of the record unless it's `\n`.
+	Delimiter  string `json:"delimiter" default:"\\n"` //*
+	Delimiter_ []byte
+
+	//> @3@4@5@6
+	//>
+	//> The max size of a record in bytes, bigger records are skipped with a warning. `0` means no limit.
+	//> > Bytes of the unfinished record are accumulated in memory, so the limit also bounds memory usage of huge records.
+	MaxRecordSize int `json:"max_record_size" default:"0"` //*
+
+	//> @3@4@5@6
+	//>
+	//> What to do with the data after the last delimiter when the end of file is reached:
+	//> * `wait` – wait for the delimiter, the data is read once the record is completed
+	//> * `flush` – make a record of the data. Use it only if files are completely written before reading,
+	//> otherwise a record being written may be split into two records.
+	//> > It isn't applied to the `json_stream` format.
+	EOFMode string `json:"eof_mode" default:"wait" options:"wait|flush"` //*
 }
 
 func init() {
@@ -162,7 +238,8 @@ func init() {
 		Type:    "file",
 		Factory: Factory,
 		Endpoints: map[string]func(http.ResponseWriter, *http.Request){
-			"reset": ResetterRegistryInstance.Reset,
+			"reset":   ResetterRegistryInstance.Reset,
+			"offsets": ResetterRegistryInstance.Offsets,
 		},
 	})
 }
@@ -176,9 +253,34 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 	p.params = params
 	p.config = config.(*Config)
 
+	if p.config.ShardsCount > 1 {
+		index, err := parseShardIndex(p.config.ShardIndex, p.config.ShardsCount)
+		if err != nil {
+			p.logger.Fatalf("wrong shard index: %s", err.Error())
+		}
+		p.config.ShardIndex_ = index
+		p.config.OffsetsFile = fmt.Sprintf("%s.shard%d", p.config.OffsetsFile, index)
+		p.logger.Infof("sharding is enabled, shard=%d, shards count=%d", index, p.config.ShardsCount)
+	}
+
 	p.config.OffsetsFileTmp = p.config.OffsetsFile + ".atomic"
 
+	if p.config.ReadMode == readModeMmap {
+		if !isMmapSupported {
+			p.logger.Warnf("mmap read mode isn't supported, files are read the buffered way")
+		}
+		if p.config.MmapChunkSize <= 0 {
+			p.logger.Fatalf("mmap chunk size should be positive: %d", p.config.MmapChunkSize)
+		}
+	}
+
+	p.config.Delimiter_ = parseDelimiter(p.config.Delimiter)
+	if len(p.config.Delimiter_) == 0 {
+		p.logger.Fatalf("delimiter can't be empty")
+	}
+
 	p.jobProvider = NewJobProvider(p.config, p.params.Controller, p.logger)
+	p.gate = pipeline.NewInputGate()
 
 	ResetterRegistryInstance.AddResetter(params.PipelineName, p)
 
@@ -189,18 +291,62 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 func (p *Plugin) startWorkers() {
 	p.workers = make([]*worker, p.config.WorkersCount_)
 	for i := range p.workers {
-		p.workers[i] = &worker{}
+		p.workers[i] = p.newWorker()
 		p.workers[i].start(p.params.Controller, p.jobProvider, p.config.ReadBufferSize, p.logger)
 	}
 
 	p.logger.Infof("workers created, count=%d", len(p.workers))
 }
 
+func (p *Plugin) newWorker() *worker {
+	w := &worker{maxRecordSize: int64(p.config.MaxRecordSize), gate: p.gate}
+	if p.config.ReadMode == readModeMmap && isMmapSupported {
+		w.mmapMinSize = int64(p.config.MmapMinSize)
+		w.mmapChunkSize = int64(p.config.MmapChunkSize)
+	}
+	if p.config.Format == formatJSONStream {
+		w.splitter = &jsonSplitter{}
+		return w
+	}
+
+	w.flushOnEOF = p.config.EOFMode == eofModeFlush
+	isNewLine := string(p.config.Delimiter_) == "\n"
+	// plain lines are found in the fast way
+	if isNewLine && w.maxRecordSize == 0 && !w.flushOnEOF {
+		return w
+	}
+
+	// keep the new line since decoders like cri rely on it
+	w.splitter = newDelimiterSplitter(p.config.Delimiter_, isNewLine)
+
+	return w
+}
+
+// parseDelimiter unescapes sequences like `\x00` or `\r\n`, the delimiter is taken as is if it can't be unescaped.
+func parseDelimiter(delimiter string) []byte {
+	unquoted, err := strconv.Unquote(`"` + delimiter + `"`)
+	if err != nil {
+		return []byte(delimiter)
+	}
+
+	return []byte(unquoted)
+}
+
 func (p *Plugin) Commit(event *pipeline.Event) {
 	p.jobProvider.commit(event)
 }
 
+// PauseInput stops reading of files until the input is resumed, workers finish the current read.
+func (p *Plugin) PauseInput() {
+	p.gate.Pause()
+}
+
+func (p *Plugin) ResumeInput() {
+	p.gate.Resume()
+}
+
 func (p *Plugin) Stop() {
+	p.gate.Stop()
 	p.logger.Infof("stopping %d workers", len(p.workers))
 	for range p.workers {
 		p.jobProvider.jobsChan <- nil
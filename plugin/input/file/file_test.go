@@ -69,17 +69,42 @@ func cleanUp() {
 	}
 }
 
+const (
+	testDelimiter     = `\r\n----`
+	testMaxRecordSize = 64
+	// testMmapChunkSize isn't a multiple of the page size, so chunks start at unaligned offsets
+	testMmapChunkSize = 1000
+)
+
 func pluginConfig(opts ...string) *Config {
 	op := ""
 	if test.Opts(opts).Has("tail") {
 		op = "tail"
 	}
 
+	format := ""
+	if test.Opts(opts).Has(formatJSONStream) {
+		format = formatJSONStream
+	}
+
 	config := &Config{
 		WatchingDir:     filesDir,
 		OffsetsFile:     filepath.Join(offsetsDir, offsetsFile),
 		PersistenceMode: "async",
 		OffsetsOp:       op,
+		Format:          format,
+	}
+
+	if test.Opts(opts).Has("delimiter") {
+		config.Delimiter = testDelimiter
+		config.MaxRecordSize = testMaxRecordSize
+		config.EOFMode = eofModeFlush
+	}
+
+	if test.Opts(opts).Has(readModeMmap) {
+		config.ReadMode = readModeMmap
+		config.MmapMinSize = 1
+		config.MmapChunkSize = testMmapChunkSize
 	}
 
 	_ = cfg.Parse(config, map[string]int{"gomaxprocs": runtime.GOMAXPROCS(0)})
@@ -609,6 +634,92 @@ func TestReadBufferOverflow(t *testing.T) {
 	}, eventCount)
 }
 
+// TestReadJSONStream tests if objects of a JSON array are read as events, including ones bigger than the read buffer
+func TestReadJSONStream(t *testing.T) {
+	file := ""
+	config := &Config{}
+	_ = cfg.Parse(config, nil)
+
+	events := []string{
+		`{"field":"value_0","nested":{"braces":"}{"}}`,
+		`{"field":"` + strings.Repeat("a", config.ReadBufferSize+128) + `"}`,
+		`{"field":"value_\"2"}`,
+	}
+	content := "[\n  " + strings.Join(events, ",\n  ") + "\n]\n"
+	size := strings.LastIndex(content, "}") + 1
+
+	run(&test.Case{
+		Prepare: func() {
+			file = createTempFile()
+			addString(file, content, false, false)
+		},
+		Act: func(p *pipeline.Pipeline) {},
+		Assert: func(p *pipeline.Pipeline) {
+			assert.Equal(t, len(events), p.GetEventsTotal(), "wrong event count")
+			for i, s := range events {
+				assert.Equal(t, s, p.GetEventLogItem(i), "wrong event")
+			}
+			assertOffsetsAreEqual(t, genOffsetsContent(file, size), getContent(getConfigByPipeline(p).OffsetsFile))
+		},
+	}, len(events), formatJSONStream)
+}
+
+// TestReadDelimiter tests if records are split by the custom delimiter, big records are skipped
+// and the record without the delimiter at the end of file is read
+func TestReadDelimiter(t *testing.T) {
+	file := ""
+	events := []string{
+		`{"field":"value_0"}`,
+		`{"field":"` + strings.Repeat("a", testMaxRecordSize) + `"}`,
+		`{"field":"value_\r\n_2"}`,
+		`{"field":"value_3"}`,
+	}
+	content := strings.Join(events, "\r\n----")
+
+	run(&test.Case{
+		Prepare: func() {
+			file = createTempFile()
+			addString(file, content, false, false)
+		},
+		Act: func(p *pipeline.Pipeline) {},
+		Assert: func(p *pipeline.Pipeline) {
+			assert.Equal(t, 3, p.GetEventsTotal(), "wrong event count")
+			assert.Equal(t, events[0], p.GetEventLogItem(0), "wrong event")
+			assert.Equal(t, events[2], p.GetEventLogItem(1), "wrong event")
+			assert.Equal(t, events[3], p.GetEventLogItem(2), "wrong event")
+			assertOffsetsAreEqual(t, genOffsetsContent(file, len(content)), getContent(getConfigByPipeline(p).OffsetsFile))
+		},
+	}, 3, "delimiter")
+}
+
+// TestReadMmap tests if lines of the cold file are read by mapped chunks, including lines crossing chunks and bigger than the chunk
+func TestReadMmap(t *testing.T) {
+	file := ""
+	events := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		events = append(events, fmt.Sprintf(`{"field":"value_%d_%s"}`, i, strings.Repeat("a", i%30)))
+	}
+	events = append(events, `{"field":"`+strings.Repeat("b", testMmapChunkSize*2)+`"}`, `{"field":"last"}`)
+	content := strings.Join(events, "\n") + "\n"
+
+	run(&test.Case{
+		Prepare: func() {
+			file = createTempFile()
+			addString(file, content, false, false)
+			coldTime := time.Now().Add(-2 * mmapColdAge)
+			require.NoError(t, os.Chtimes(file, coldTime, coldTime))
+		},
+		Act: func(p *pipeline.Pipeline) {},
+		Assert: func(p *pipeline.Pipeline) {
+			assert.Equal(t, len(events), p.GetEventsTotal(), "wrong event count")
+			for i, s := range events {
+				assert.Equal(t, s, p.GetEventLogItem(i), "wrong event")
+			}
+			assertOffsetsAreEqual(t, genOffsetsContent(file, len(content)), getContent(getConfigByPipeline(p).OffsetsFile))
+		},
+	}, len(events), readModeMmap)
+}
+
 // TestReadManyCharsRace tests if plugin doesn't have race conditions in the case of sequential processing of chars of single line
 func TestReadManyCharsRace(t *testing.T) {
 	file := ""
@@ -0,0 +1,67 @@
+package file
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	readModeBuffered = "buffered"
+	readModeMmap     = "mmap"
+
+	// mmapColdAge is how long the file shouldn't be modified to be mapped.
+	// Mapped files must not be truncated, since reading truncated pages crashes the process.
+	mmapColdAge = time.Minute
+)
+
+// mappedChunk is the region of the file mapped for one turn of the job.
+type mappedChunk struct {
+	// mapping starts at the page boundary, data starts at the offset of the job
+	mapping []byte
+	data    []byte
+	isTaken bool
+}
+
+// mapChunk maps the chunk of the big cold file from the offset,
+// nil is returned if the file should be read the buffered way.
+func (w *worker) mapChunk(file *os.File, offset int64) (*mappedChunk, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+	if size < w.mmapMinSize || size <= offset || time.Since(stat.ModTime()) < mmapColdAge {
+		return nil, nil
+	}
+
+	pageSize := int64(os.Getpagesize())
+	start := offset - offset%pageSize
+	end := offset + w.mmapChunkSize
+	if end > size {
+		end = size
+	}
+
+	mapping, err := mmapFile(file, start, int(end-start))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mappedChunk{mapping: mapping, data: mapping[offset-start:]}, nil
+}
+
+// take returns the chunk once and moves the position of the file past it,
+// so the rest of the job turn is read the buffered way.
+func (c *mappedChunk) take(file *os.File) ([]byte, error) {
+	c.isTaken = true
+	if _, err := file.Seek(int64(len(c.data)), io.SeekCurrent); err != nil {
+		return nil, err
+	}
+
+	return c.data, nil
+}
+
+// unmap releases the mapping, records are copied by the pipeline, so nothing refers to it.
+func (c *mappedChunk) unmap() error {
+	return munmapFile(c.mapping)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const isMmapSupported = true
+
+// mmapFile maps the region of the file for reading with the sequential access advice,
+// so the kernel reads ahead aggressively and drops pages behind.
+func mmapFile(file *os.File, offset int64, length int) ([]byte, error) {
+	data, err := unix.Mmap(int(file.Fd()), offset, length, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	// the advice is only the hint, reading works without it
+	_ = unix.Madvise(data, unix.MADV_SEQUENTIAL)
+
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return unix.Munmap(data)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package file
+
+import (
+	"fmt"
+	"os"
+)
+
+const isMmapSupported = false
+
+func mmapFile(_ *os.File, _ int64, _ int) ([]byte, error) {
+	return nil, fmt.Errorf("mmap isn't supported on windows")
+}
+
+func munmapFile(_ []byte) error {
+	return nil
+}
@@ -27,6 +27,7 @@ type offsetDB struct {
 
 type inodeOffsets struct {
 	filename string
+	inode    inode
 	sourceID pipeline.SourceID
 	streams  map[pipeline.StreamName]int64
 }
@@ -144,6 +145,7 @@ func (o *offsetDB) parseOne(content string, offsets fpOffsets) (string, error) {
 	offsets[fp] = &inodeOffsets{
 		streams:  make(map[pipeline.StreamName]int64),
 		filename: filename,
+		inode:    inode,
 		sourceID: fp,
 	}
 
@@ -243,26 +245,7 @@ func (o *offsetDB) save(jobs map[pipeline.SourceID]*Job, mu *sync.RWMutex) {
 			continue
 		}
 
-		o.buf = append(o.buf, "- file: "...)
-		o.buf = append(o.buf, job.filename...)
-		o.buf = append(o.buf, '\n')
-
-		o.buf = append(o.buf, "  inode: "...)
-		o.buf = strconv.AppendUint(o.buf, uint64(job.inode), 10)
-		o.buf = append(o.buf, '\n')
-
-		o.buf = append(o.buf, "  source_id: "...)
-		o.buf = strconv.AppendUint(o.buf, uint64(job.sourceID), 10)
-		o.buf = append(o.buf, '\n')
-
-		o.buf = append(o.buf, "  streams:\n"...)
-		for _, strOff := range job.offsets {
-			o.buf = append(o.buf, "    "...)
-			o.buf = append(o.buf, string(strOff.stream)...)
-			o.buf = append(o.buf, ": "...)
-			o.buf = strconv.AppendUint(o.buf, uint64(strOff.offset), 10)
-			o.buf = append(o.buf, '\n')
-		}
+		o.buf = appendFileOffsets(o.buf, job.filename, job.inode, job.sourceID, job.offsets)
 		job.mu.Unlock()
 	}
 
@@ -0,0 +1,369 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ozonru/file.d/pipeline"
+)
+
+// PortableOffsets is the node independent format of offsets.
+// Inodes and source ids differ between nodes, so files are matched by names on the import.
+type PortableOffsets struct {
+	Files []*PortableFileOffsets `json:"files"`
+}
+
+type PortableFileOffsets struct {
+	File    string           `json:"file"`
+	Streams map[string]int64 `json:"streams"`
+}
+
+// ImportResult lists files whose offsets are imported and files which are skipped along with the reason.
+type ImportResult struct {
+	Imported []string          `json:"imported"`
+	Skipped  map[string]string `json:"skipped"`
+}
+
+// configProvider is implemented by configs of inputs built on top of the file input, e.g. k8s.
+type configProvider interface {
+	FileInputConfig() *Config
+}
+
+// OffsetsFileOf returns the offsets file of the file input config or the config of inputs built on top of it,
+// the shard suffix is added if the sharding is enabled.
+func OffsetsFileOf(config pipeline.AnyConfig) (string, error) {
+	var c *Config
+	switch typed := config.(type) {
+	case *Config:
+		c = typed
+	case configProvider:
+		c = typed.FileInputConfig()
+	default:
+		return "", fmt.Errorf("input doesn't store offsets in the file")
+	}
+
+	if c.ShardsCount <= 1 {
+		return c.OffsetsFile, nil
+	}
+
+	index, err := parseShardIndex(c.ShardIndex, c.ShardsCount)
+	if err != nil {
+		return "", fmt.Errorf("wrong shard index: %w", err)
+	}
+
+	return fmt.Sprintf("%s.shard%d", c.OffsetsFile, index), nil
+}
+
+// ExportOffsets reads the offsets file into the portable format.
+func ExportOffsets(offsetsFile string) (*PortableOffsets, error) {
+	offsets, err := readOffsetsFile(offsetsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PortableOffsets{Files: make([]*PortableFileOffsets, 0, len(offsets))}
+	for _, inode := range offsets {
+		result.Files = append(result.Files, newPortableFileOffsets(inode.filename, sliceFromMap(inode.streams)))
+	}
+	sortPortableOffsets(result)
+
+	return result, nil
+}
+
+// ImportOffsets matches files of the portable offsets with files of this node and writes their offsets to the offsets file,
+// offsets of other files are kept. It should be called while the input isn't running, since it overwrites the file.
+func ImportOffsets(offsetsFile string, offsets *PortableOffsets) (*ImportResult, error) {
+	current, err := readOffsetsFile(offsetsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{Imported: make([]string, 0), Skipped: make(map[string]string)}
+	for _, file := range offsets.Files {
+		imported, reason := resolveFileOffsets(file)
+		if imported == nil {
+			result.Skipped[file.File] = reason
+			continue
+		}
+
+		current[imported.sourceID] = imported
+		result.Imported = append(result.Imported, file.File)
+	}
+
+	buf := make([]byte, 0, 4096)
+	for _, inode := range current {
+		buf = appendFileOffsets(buf, inode.filename, inode.inode, inode.sourceID, sliceFromMap(inode.streams))
+	}
+
+	tmp := offsetsFile + ".import"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return nil, fmt.Errorf("can't write offsets file: %w", err)
+	}
+	if err := os.Rename(tmp, offsetsFile); err != nil {
+		return nil, fmt.Errorf("can't replace offsets file: %w", err)
+	}
+
+	return result, nil
+}
+
+// readOffsetsFile parses the offsets file, there are no offsets if it doesn't exist.
+func readOffsetsFile(offsetsFile string) (fpOffsets, error) {
+	content, err := os.ReadFile(offsetsFile)
+	if os.IsNotExist(err) {
+		return make(fpOffsets), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read offsets file: %w", err)
+	}
+
+	offsets, err := (&offsetDB{}).parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse offsets file: %w", err)
+	}
+
+	return offsets, nil
+}
+
+// resolveFileOffsets finds the file on this node, nil and the reason are returned if offsets can't be applied to it.
+func resolveFileOffsets(file *PortableFileOffsets) (*inodeOffsets, string) {
+	if len(file.Streams) == 0 {
+		return nil, "no streams"
+	}
+
+	stat, err := os.Stat(file.File)
+	if err != nil {
+		return nil, err.Error()
+	}
+	if stat.IsDir() {
+		return nil, "file is dir"
+	}
+
+	streams := make(map[pipeline.StreamName]int64, len(file.Streams))
+	for stream, offset := range file.Streams {
+		if offset < 0 || offset > stat.Size() {
+			return nil, fmt.Sprintf("offset %d of stream %q is out of the file size %d", offset, stream, stat.Size())
+		}
+		streams[pipeline.StreamName(stream)] = offset
+	}
+
+	return &inodeOffsets{
+		filename: file.File,
+		inode:    getInode(stat),
+		sourceID: sourceIDByStat(stat, ""),
+		streams:  streams,
+	}, ""
+}
+
+// appendFileOffsets appends the entry of the file in the format of the offsets file.
+func appendFileOffsets(buf []byte, filename string, inode inode, sourceID pipeline.SourceID, offsets sliceMap) []byte {
+	buf = append(buf, "- file: "...)
+	buf = append(buf, filename...)
+	buf = append(buf, '\n')
+
+	buf = append(buf, "  inode: "...)
+	buf = strconv.AppendUint(buf, uint64(inode), 10)
+	buf = append(buf, '\n')
+
+	buf = append(buf, "  source_id: "...)
+	buf = strconv.AppendUint(buf, uint64(sourceID), 10)
+	buf = append(buf, '\n')
+
+	buf = append(buf, "  streams:\n"...)
+	for _, strOff := range offsets {
+		buf = append(buf, "    "...)
+		buf = append(buf, string(strOff.stream)...)
+		buf = append(buf, ": "...)
+		buf = strconv.AppendUint(buf, uint64(strOff.offset), 10)
+		buf = append(buf, '\n')
+	}
+
+	return buf
+}
+
+func newPortableFileOffsets(filename string, offsets sliceMap) *PortableFileOffsets {
+	file := &PortableFileOffsets{File: filename, Streams: make(map[string]int64, len(offsets))}
+	for _, strOff := range offsets {
+		file.Streams[string(strOff.stream)] = strOff.offset
+	}
+
+	return file
+}
+
+func sortPortableOffsets(offsets *PortableOffsets) {
+	sort.Slice(offsets.Files, func(i, j int) bool { return offsets.Files[i].File < offsets.Files[j].File })
+}
+
+// exportJobs returns offsets of jobs of the running input.
+func (jp *jobProvider) exportJobs() *PortableOffsets {
+	result := &PortableOffsets{Files: make([]*PortableFileOffsets, 0)}
+
+	jp.jobsMu.RLock()
+	for _, job := range jp.jobs {
+		job.mu.Lock()
+		if len(job.offsets) != 0 {
+			result.Files = append(result.Files, newPortableFileOffsets(job.filename, job.offsets))
+		}
+		job.mu.Unlock()
+	}
+	jp.jobsMu.RUnlock()
+	sortPortableOffsets(result)
+
+	return result
+}
+
+// importJobs seeks jobs of the running input to the imported offsets, files are matched by names.
+// The file is read from the min offset of its streams, the same way offsets are loaded on the start.
+func (jp *jobProvider) importJobs(offsets *PortableOffsets) *ImportResult {
+	byName := make(map[string]*PortableFileOffsets, len(offsets.Files))
+	for _, file := range offsets.Files {
+		byName[file.File] = file
+	}
+
+	result := &ImportResult{Imported: make([]string, 0), Skipped: make(map[string]string)}
+
+	jp.jobsMu.RLock()
+	for _, job := range jp.jobs {
+		job.mu.Lock()
+		file, has := byName[job.filename]
+		job.mu.Unlock()
+		if !has {
+			continue
+		}
+		delete(byName, file.File)
+
+		resolved, reason := resolveFileOffsets(file)
+		if resolved == nil {
+			result.Skipped[file.File] = reason
+			continue
+		}
+		if resolved.sourceID != job.sourceID {
+			result.Skipped[file.File] = "file is replaced"
+			continue
+		}
+
+		jp.seekJob(job, resolved.streams)
+		result.Imported = append(result.Imported, file.File)
+	}
+	jp.jobsMu.RUnlock()
+
+	for name := range byName {
+		result.Skipped[name] = "file isn't read by the input"
+	}
+
+	return result
+}
+
+// seekJob moves the job to the offsets, events read before the seek aren't committed.
+func (jp *jobProvider) seekJob(job *Job, streams map[pipeline.StreamName]int64) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	minOffset := int64(math.MaxInt64)
+	for _, offset := range streams {
+		if offset < minOffset {
+			minOffset = offset
+		}
+	}
+
+	job.ignoreEventsLE = job.lastEventSeq
+	_, err := job.file.Seek(minOffset, io.SeekStart)
+	if err != nil {
+		jp.logger.Fatalf("job import error, file %s seek error: %s", job.filename, err.Error())
+	}
+
+	job.offsets = job.offsets[:0]
+	for stream, offset := range streams {
+		job.offsets.set(stream, offset)
+	}
+
+	jp.logger.Infof("job %d:%s offsets are imported, reading will continue from %d", job.sourceID, job.filename, minOffset)
+}
+
+// Offsets exports offsets of the pipeline input on `GET` and imports them on `POST`.
+// If the input is running, its jobs are moved to the imported offsets, otherwise they're written to the offsets file.
+func (rr *ResetterRegistry) Offsets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	pipe := strings.Split(r.URL.Path, "/")[2]
+	resetter, ok := rr.pipelineToResetter[pipe]
+	if !ok || resetter.plug.jobProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeErr(w, "file input isn't started")
+		return
+	}
+	jp := resetter.plug.jobProvider
+
+	switch r.Method {
+	case http.MethodGet:
+		var offsets *PortableOffsets
+		if jp.isStarted {
+			offsets = jp.exportJobs()
+		} else {
+			var err error
+			offsets, err = ExportOffsets(jp.offsetDB.curOffsetsFile)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				writeErr(w, err.Error())
+				return
+			}
+		}
+
+		resp, _ := json.Marshal(offsets)
+		_, _ = w.Write(resp)
+	case http.MethodPost:
+		offsets := &PortableOffsets{}
+		if err := json.NewDecoder(r.Body).Decode(offsets); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeErr(w, fmt.Sprintf("can't decode offsets: %s", err.Error()))
+			return
+		}
+
+		result, err := resetter.importOffsets(offsets)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeErr(w, err.Error())
+			return
+		}
+
+		resp, _ := json.Marshal(result)
+		_, _ = w.Write(resp)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeErr(w, "Use the GET or POST method.")
+	}
+}
+
+func (r *resetter) importOffsets(offsets *PortableOffsets) (*ImportResult, error) {
+	jp := r.plug.jobProvider
+	if jp.isStarted {
+		return jp.importJobs(offsets), nil
+	}
+
+	r.offsetMu.Lock()
+	defer r.offsetMu.Unlock()
+
+	result, err := ImportOffsets(jp.offsetDB.curOffsetsFile, offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := jp.offsetDB.load()
+	if err != nil {
+		return nil, err
+	}
+	jp.loadedOffsets = loaded
+
+	return result, nil
+}
+
+func writeErr(w io.Writer, err string) {
+	resp, _ := json.Marshal(map[string]string{"error": err})
+	_, _ = w.Write(resp)
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportOffsets(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	missingFile := filepath.Join(dir, "missing.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("line1\nline2\nline3\n"), 0o600))
+
+	// the old node has another inode and source id of the file
+	oldOffsets := filepath.Join(dir, "old_offsets.yaml")
+	require.NoError(t, os.WriteFile(oldOffsets, []byte(`- file: `+logFile+`
+  inode: 1
+  source_id: 2
+  streams:
+    stdout: 6
+    stderr: 12
+- file: `+missingFile+`
+  inode: 3
+  source_id: 4
+  streams:
+    not_set: 100
+`), 0o600))
+
+	exported, err := ExportOffsets(oldOffsets)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(exported.Files), "wrong exported files")
+	assert.Equal(t, missingFile, exported.Files[1].File, "files aren't sorted")
+	assert.Equal(t, map[string]int64{"stdout": 6, "stderr": 12}, exported.Files[0].Streams, "wrong exported streams")
+
+	newOffsets := filepath.Join(dir, "offsets.yaml")
+	result, err := ImportOffsets(newOffsets, exported)
+	require.NoError(t, err)
+	assert.Equal(t, []string{logFile}, result.Imported, "wrong imported files")
+	assert.Contains(t, result.Skipped, missingFile, "missing file isn't skipped")
+
+	loaded, err := readOffsetsFile(newOffsets)
+	require.NoError(t, err)
+	stat, err := os.Stat(logFile)
+	require.NoError(t, err)
+
+	sourceID := sourceIDByStat(stat, "")
+	require.Contains(t, loaded, sourceID, "offsets aren't bound to the file of this node")
+	assert.Equal(t, getInode(stat), loaded[sourceID].inode, "wrong inode")
+	assert.Equal(t, int64(12), loaded[sourceID].streams["stderr"], "wrong offset")
+}
+
+func TestImportOffsetsOutOfSize(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("line1\n"), 0o600))
+
+	result, err := ImportOffsets(filepath.Join(dir, "offsets.yaml"), &PortableOffsets{Files: []*PortableFileOffsets{
+		{File: logFile, Streams: map[string]int64{"not_set": 100}},
+	}})
+	require.NoError(t, err)
+	assert.Empty(t, result.Imported, "offset beyond the file size is imported")
+	assert.Contains(t, result.Skipped[logFile], strconv.Itoa(100), "wrong skip reason")
+}
@@ -239,6 +239,10 @@ func (jp *jobProvider) refreshSymlink(symlink string, inode inode) {
 }
 
 func (jp *jobProvider) refreshFile(stat os.FileInfo, filename string, symlink string) {
+	if !jp.isOwnFile(stat) {
+		return
+	}
+
 	sourceID := sourceIDByStat(stat, symlink)
 	jp.jobsMu.RLock()
 	job, has := jp.jobs[sourceID]
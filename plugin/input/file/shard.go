@@ -0,0 +1,59 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const shardIndexHostname = "hostname"
+
+// isOwnFile checks if the file belongs to the shard of this replica.
+func (jp *jobProvider) isOwnFile(stat os.FileInfo) bool {
+	if jp.config.ShardsCount <= 1 {
+		return true
+	}
+
+	return shardByInode(getInode(stat), jp.config.ShardsCount) == jp.config.ShardIndex_
+}
+
+// shardByInode uses jump consistent hash, so changing the shards count moves only a part of files to other shards.
+// See https://arxiv.org/abs/1406.2294.
+func shardByInode(i inode, shardsCount int) int {
+	// mix bits since inodes are often sequential
+	key := uint64(i)
+	key ^= key >> 33
+	key *= 0xff51afd7ed558ccd
+	key ^= key >> 33
+
+	b, j := int64(-1), int64(0)
+	for j < int64(shardsCount) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
+
+func parseShardIndex(value string, shardsCount int) (int, error) {
+	if value == shardIndexHostname {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return 0, err
+		}
+		value = hostname[strings.LastIndexFunc(hostname, func(r rune) bool { return r < '0' || r > '9' })+1:]
+	}
+
+	index, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse shard index %q: %w", value, err)
+	}
+
+	if index < 0 || index >= shardsCount {
+		return 0, fmt.Errorf("shard index %d is out of range [0, %d)", index, shardsCount)
+	}
+
+	return index, nil
+}
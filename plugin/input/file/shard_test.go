@@ -0,0 +1,40 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardByInode(t *testing.T) {
+	const files = 10000
+
+	counts := make([]int, 4)
+	moved := 0
+	for i := 0; i < files; i++ {
+		shard := shardByInode(inode(i), 4)
+		counts[shard]++
+
+		assert.Equal(t, shard, shardByInode(inode(i), 4), "shard should be stable")
+		if shardByInode(inode(i), 5) != shard {
+			moved++
+		}
+	}
+
+	for shard, count := range counts {
+		assert.InDelta(t, files/4, count, files/20, "files should be distributed evenly, shard=%d", shard)
+	}
+	assert.InDelta(t, files/5, moved, files/20, "only a fifth of files should move when a shard is added")
+}
+
+func TestParseShardIndex(t *testing.T) {
+	index, err := parseShardIndex("2", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, index)
+
+	_, err = parseShardIndex("3", 3)
+	assert.Error(t, err, "index should be less than shards count")
+
+	_, err = parseShardIndex("first", 3)
+	assert.Error(t, err)
+}
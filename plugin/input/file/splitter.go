@@ -0,0 +1,144 @@
+package file
+
+import (
+	"bytes"
+)
+
+// splitter finds records in a stream of read buffers.
+// It keeps the state between calls, so a record may be split across read buffers.
+type splitter interface {
+	// next scans buf which starts at the base offset and returns the offsets of the start
+	// and the end (exclusive) of the next complete record and the offset the scan should continue from.
+	next(buf []byte, base int64) (start int64, end int64, next int64, ok bool)
+	reset()
+}
+
+// jsonSplitter finds top-level JSON objects in a stream, e.g. elements of one huge JSON array
+// or concatenated objects without line breaks. Anything between objects is skipped: brackets,
+// commas, whitespaces and other values, strings are skipped as a whole so braces in them don't count.
+type jsonSplitter struct {
+	depth    int
+	inString bool
+	escaped  bool
+	start    int64
+}
+
+func (s *jsonSplitter) next(buf []byte, base int64) (int64, int64, int64, bool) {
+	for i, c := range buf {
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			s.inString = true
+		case '{':
+			if s.depth == 0 {
+				s.start = base + int64(i)
+			}
+			s.depth++
+		case '}':
+			// skip stray braces between objects
+			if s.depth == 0 {
+				continue
+			}
+			s.depth--
+			if s.depth == 0 {
+				end := base + int64(i) + 1
+				return s.start, end, end, true
+			}
+		}
+	}
+
+	return 0, 0, 0, false
+}
+
+func (s *jsonSplitter) reset() {
+	*s = jsonSplitter{}
+}
+
+// delimiterSplitter finds records ended by an arbitrary delimiter, e.g. `\x00` or `\r\n`.
+// The delimiter isn't a part of the record unless keepDelimiter is set.
+type delimiterSplitter struct {
+	delimiter     []byte
+	keepDelimiter bool
+
+	start int64
+	// tail is the end of the previous buffer, it may contain the beginning of the delimiter
+	tail   []byte
+	joined []byte
+}
+
+func newDelimiterSplitter(delimiter []byte, keepDelimiter bool) *delimiterSplitter {
+	return &delimiterSplitter{
+		delimiter:     delimiter,
+		keepDelimiter: keepDelimiter,
+		tail:          make([]byte, 0, len(delimiter)),
+		joined:        make([]byte, 0, len(delimiter)*2),
+	}
+}
+
+func (s *delimiterSplitter) next(buf []byte, base int64) (int64, int64, int64, bool) {
+	pos := int64(-1)
+
+	// the delimiter may be split between buffers
+	if len(s.tail) != 0 {
+		n := len(s.delimiter) - 1
+		if n > len(buf) {
+			n = len(buf)
+		}
+		s.joined = append(append(s.joined[:0], s.tail...), buf[:n]...)
+		if i := bytes.Index(s.joined, s.delimiter); i != -1 {
+			pos = base - int64(len(s.tail)) + int64(i)
+		}
+	}
+
+	if pos == -1 {
+		if i := bytes.Index(buf, s.delimiter); i != -1 {
+			pos = base + int64(i)
+		}
+	}
+
+	if pos == -1 {
+		s.keepTail(buf)
+		return 0, 0, 0, false
+	}
+
+	start := s.start
+	next := pos + int64(len(s.delimiter))
+	end := pos
+	if s.keepDelimiter {
+		end = next
+	}
+
+	s.start = next
+	s.tail = s.tail[:0]
+
+	return start, end, next, true
+}
+
+func (s *delimiterSplitter) keepTail(buf []byte) {
+	n := len(s.delimiter) - 1
+	if len(buf) >= n {
+		s.tail = append(s.tail[:0], buf[len(buf)-n:]...)
+		return
+	}
+
+	s.tail = append(s.tail, buf...)
+	if len(s.tail) > n {
+		s.tail = s.tail[:copy(s.tail, s.tail[len(s.tail)-n:])]
+	}
+}
+
+func (s *delimiterSplitter) reset() {
+	s.start = 0
+	s.tail = s.tail[:0]
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelimiterSplitter(t *testing.T) {
+	tests := []struct {
+		name          string
+		delimiter     string
+		keepDelimiter bool
+		buffers       []string
+		records       []string
+	}{
+		{
+			name:      "one_buffer",
+			delimiter: "----",
+			buffers:   []string{"a----bb----c"},
+			records:   []string{"a", "bb"},
+		},
+		{
+			name:      "split_delimiter",
+			delimiter: "----",
+			buffers:   []string{"a--", "--bb-", "-", "-", "-c"},
+			records:   []string{"a", "bb"},
+		},
+		{
+			name:      "partial_delimiter",
+			delimiter: "\r\n",
+			buffers:   []string{"a\rb\r", "\nc"},
+			records:   []string{"a\rb"},
+		},
+		{
+			name:          "keep_delimiter",
+			delimiter:     "\n",
+			keepDelimiter: true,
+			buffers:       []string{"a\nb", "\n"},
+			records:       []string{"a\n", "b\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDelimiterSplitter([]byte(tt.delimiter), tt.keepDelimiter)
+			stream := strings.Join(tt.buffers, "")
+
+			records := make([]string, 0)
+			base := int64(0)
+			for _, buf := range tt.buffers {
+				processed := int64(0)
+				for {
+					start, end, next, ok := s.next([]byte(buf)[processed:], base+processed)
+					if !ok {
+						break
+					}
+					records = append(records, stream[start:end])
+					processed = next - base
+				}
+				base += int64(len(buf))
+			}
+
+			assert.Equal(t, tt.records, records, "wrong records")
+		})
+	}
+}
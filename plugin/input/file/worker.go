@@ -9,7 +9,19 @@ import (
 	"go.uber.org/zap"
 )
 
-type worker struct{}
+type worker struct {
+	// splitter is set if records aren't plain lines, otherwise lines are found in the fast way
+	splitter splitter
+	// maxRecordSize is the max size of a record, bigger records are skipped, 0 means no limit
+	maxRecordSize int64
+	// flushOnEOF makes the unfinished record at the end of file a record
+	flushOnEOF bool
+	// gate blocks reading while the input is paused by backpressure of outputs
+	gate *pipeline.InputGate
+	// files of the min size are mapped by chunks of the chunk size, the chunk size is 0 if files are read the buffered way
+	mmapMinSize   int64
+	mmapChunkSize int64
+}
 
 func (w *worker) start(inputController pipeline.InputPluginController, jobProvider *jobProvider, readBufferSize int, logger *zap.SugaredLogger) {
 	longpanic.Go(func() { w.work(inputController, jobProvider, readBufferSize, logger) })
@@ -41,24 +53,48 @@ func (w *worker) work(controller pipeline.InputPluginController, jobProvider *jo
 			logger.Panicf("job is done, why worker should work?")
 		}
 
+		// the job always starts between objects
+		if w.splitter != nil {
+			w.splitter.reset()
+		}
+
 		lastOffset, err := file.Seek(0, io.SeekCurrent)
 		if err != nil {
 			logger.Fatalf("can't get offset, file %d:%s seek error: %s", sourceID, sourceName, err.Error())
 		}
 
+		// the chunk of the big cold file is processed in one turn of the job instead of the read buffer
+		var mapped *mappedChunk
+		if w.mmapChunkSize > 0 {
+			mapped, err = w.mapChunk(file, lastOffset)
+			if err != nil {
+				logger.Warnf("file %d:%s is read the buffered way, mmap error: %s", sourceID, sourceName, err.Error())
+			}
+		}
+
 		isEOF := false
 		wasPut := false
 
 		readTotal := int64(0)
 		accumulated := int64(0)
 		processed := int64(0)
+		// oversize is set when the accumulated record exceeds max record size, its bytes aren't kept anymore
+		oversize := false
 
 		accumBuffer = accumBuffer[:0]
 		for {
-			readBuffer = readBuffer[:readBufferSize]
-			r, err := file.Read(readBuffer)
+			w.gate.Wait(nil)
+
+			chunk := readBuffer[:readBufferSize]
+			r := 0
+			if mapped != nil && !mapped.isTaken {
+				chunk, err = mapped.take(file)
+				r = len(chunk)
+			} else {
+				r, err = file.Read(chunk)
+			}
 			read := int64(r)
-			readBuffer = readBuffer[:read]
+			chunk = chunk[:read]
 
 			if err == io.EOF || read == 0 {
 				isEOF = true
@@ -71,15 +107,25 @@ func (w *worker) work(controller pipeline.InputPluginController, jobProvider *jo
 
 			processed = 0
 			for {
-				if processed >= int64(len(readBuffer)) {
+				if processed >= int64(len(chunk)) {
 					break
 				}
 
-				pos := int64(bytes.IndexByte(readBuffer[processed:], '\n'))
+				// start and end are the offsets of the record from lastOffset, they're used only by the splitter
+				start, end := int64(0), int64(0)
+				pos := int64(-1)
+				if w.splitter == nil {
+					pos = int64(bytes.IndexByte(chunk[processed:], '\n'))
+					if pos != -1 {
+						pos += processed
+					}
+				} else if s, e, next, ok := w.splitter.next(chunk[processed:], accumulated+processed); ok {
+					start, end = s, e
+					pos = next - accumulated - 1
+				}
 				if pos == -1 {
 					break
 				}
-				pos += processed
 
 				// skip first event because file may be opened while event isn't completely written
 				if skipLine {
@@ -87,13 +133,21 @@ func (w *worker) work(controller pipeline.InputPluginController, jobProvider *jo
 					skipLine = false
 				} else {
 					offset := lastOffset + accumulated + pos + 1
-					if len(accumBuffer) != 0 {
-						accumBuffer = append(accumBuffer, readBuffer[processed:pos+1]...)
+					if w.splitter != nil {
+						if (oversize && start < accumulated) || w.isOversize(end-start) {
+							logger.Warnf("record is skipped because it's bigger than max record size, file %d:%s offset=%d", sourceID, sourceName, offset)
+						} else {
+							seqID = controller.In(sourceID, sourceName, offset, record(accumBuffer, chunk, accumulated, start, end), isVirgin)
+							job.lastEventSeq = seqID
+						}
+					} else if len(accumBuffer) != 0 {
+						accumBuffer = append(accumBuffer, chunk[processed:pos+1]...)
 						seqID = controller.In(sourceID, sourceName, offset, accumBuffer, isVirgin)
+						job.lastEventSeq = seqID
 					} else {
-						seqID = controller.In(sourceID, sourceName, offset, readBuffer[processed:pos+1], isVirgin)
+						seqID = controller.In(sourceID, sourceName, offset, chunk[processed:pos+1], isVirgin)
+						job.lastEventSeq = seqID
 					}
-					job.lastEventSeq = seqID
 				}
 				accumBuffer = accumBuffer[:0]
 
@@ -106,11 +160,37 @@ func (w *worker) work(controller pipeline.InputPluginController, jobProvider *jo
 			if wasPut {
 				break
 			} else {
-				accumBuffer = append(accumBuffer, readBuffer[:read]...)
+				if !oversize {
+					accumBuffer = append(accumBuffer, chunk[:read]...)
+				}
 				accumulated += read
+				if w.splitter != nil && w.isOversize(accumulated) {
+					oversize = true
+					accumBuffer = accumBuffer[:0]
+				}
 			}
 		}
 
+		if mapped != nil {
+			if err := mapped.unmap(); err != nil {
+				logger.Fatalf("file %d:%s munmap error: %s", sourceID, sourceName, err.Error())
+			}
+		}
+
+		// the file may end without the delimiter after the last record
+		if isEOF && w.flushOnEOF && !wasPut && accumulated != 0 {
+			offset := lastOffset + accumulated
+			if oversize || skipLine {
+				logger.Warnf("last record is skipped, file %d:%s offset=%d", sourceID, sourceName, offset)
+				job.shouldSkip = false
+			} else {
+				seqID = controller.In(sourceID, sourceName, offset, accumBuffer, isVirgin)
+				job.lastEventSeq = seqID
+			}
+			wasPut = true
+			processed = 0
+		}
+
 		// don't consider accumulated buffer cause we haven't put any events
 		if !wasPut {
 			accumulated = 0
@@ -142,3 +222,18 @@ func (w *worker) work(controller pipeline.InputPluginController, jobProvider *jo
 		}
 	}
 }
+
+func (w *worker) isOversize(size int64) bool {
+	return w.maxRecordSize > 0 && size > w.maxRecordSize
+}
+
+// record gets the record which may be started in the accumulated buffer and ended in the read buffer.
+func record(accumBuffer []byte, readBuffer []byte, accumulated int64, start int64, end int64) []byte {
+	if start >= accumulated {
+		return readBuffer[start-accumulated : end-accumulated]
+	}
+	if end <= accumulated {
+		return accumBuffer[start:end]
+	}
+	return append(accumBuffer[start:], readBuffer[:end-accumulated]...)
+}
@@ -0,0 +1,70 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ozonru/file.d/decoder"
+	"github.com/ozonru/file.d/logger"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// countingController counts lines and signals once the expected count is read.
+type countingController struct {
+	count    int
+	expected int
+	done     chan struct{}
+}
+
+func (c *countingController) In(_ pipeline.SourceID, _ string, _ int64, _ []byte, _ bool) uint64 {
+	c.count++
+	if c.count == c.expected {
+		close(c.done)
+	}
+	return uint64(c.count)
+}
+func (c *countingController) UseSpread()                           {}
+func (c *countingController) DisableStreams()                      {}
+func (c *countingController) SuggestDecoder(_ decoder.DecoderType) {}
+
+func BenchmarkWorkerLines(b *testing.B) {
+	const lines = 1024 * 64
+	line := `{"level":"info","ts":"2021-06-22T16:24:27Z","message":"request is processed","duration":12}` + "\n"
+
+	filename := filepath.Join(b.TempDir(), "lines.log")
+	require.NoError(b, os.WriteFile(filename, []byte(strings.Repeat(line, lines)), 0o644))
+
+	for _, size := range []int{4096, 131072, 1048576} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(len(line) * lines))
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				file, err := os.Open(filename)
+				require.NoError(b, err)
+
+				job := &Job{file: file, filename: filename, mu: &sync.Mutex{}}
+				jp := &jobProvider{
+					jobs:     map[pipeline.SourceID]*Job{0: job},
+					jobsDone: atomic.NewInt32(0),
+					jobsMu:   &sync.RWMutex{},
+					jobsChan: make(chan *Job, 1),
+					logger:   logger.Instance,
+				}
+				controller := &countingController{expected: lines, done: make(chan struct{})}
+
+				jp.jobsChan <- job
+				w := &worker{gate: pipeline.NewInputGate()}
+				w.start(controller, jp, size, logger.Instance)
+				<-controller.done
+				jp.jobsChan <- nil
+				_ = file.Close()
+			}
+		})
+	}
+}
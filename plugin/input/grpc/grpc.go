@@ -0,0 +1,264 @@
+package grpc
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/decoder"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/longpanic"
+	"github.com/ozonru/file.d/netutil"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/plugin/input/grpc/pb"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+/*{ introduction
+It receives structured log entries from clients via the gRPC `Input.Stream` method, the proto is in the `pb` directory.
+Each entry is converted into the event `{"time":"...","level":"...","message":"...",<fields>}`, empty values are omitted.
+
+Each entry is acked with its `seq` once it's committed by the pipeline output or skipped by the pipeline,
+so clients can resend unacked entries after reconnect.
+> It guarantees "at-least-once delivery" if clients resend unacked entries.
+
+If a stream has too many unacked entries, the plugin stops reading it until some entries are committed,
+so gRPC flow control slows down the client.
+}*/
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	listener   net.Listener
+	server     *grpclib.Server
+
+	sourceSeq *atomic.Uint64
+	streams   map[pipeline.SourceID]*entryStream
+	streamsMu *sync.RWMutex
+
+	pb.UnimplementedInputServer
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> An address to listen to. Omit ip/host to listen all network interfaces, both IPv4 and IPv6. E.g. `:9090`.
+	//> IPv6 hosts should be in brackets, e.g. `[::1]:9090`
+	Address string `json:"address" default:":9090"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The maximum number of unacked entries per stream.
+	MaxInflight int `json:"max_inflight" default:"1024"` //*
+}
+
+// entryStream is a state of the client stream.
+type entryStream struct {
+	sourceID pipeline.SourceID
+	inflight chan struct{} // semaphore of unacked entries
+	pending  *sync.WaitGroup
+	acks     chan uint64
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    "grpc",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.controller = params.Controller
+	p.controller.SuggestDecoder(decoder.JSON)
+
+	p.sourceSeq = atomic.NewUint64(0)
+	p.streams = make(map[pipeline.SourceID]*entryStream)
+	p.streamsMu = &sync.RWMutex{}
+
+	if p.config.MaxInflight <= 0 {
+		p.logger.Fatalf("max_inflight should be positive, got %d", p.config.MaxInflight)
+	}
+
+	var err error
+	p.listener, err = netutil.Listen("tcp", p.config.Address)
+	if err != nil {
+		p.logger.Fatalf("can't listen %s: %s", p.config.Address, err.Error())
+	}
+
+	p.server = grpclib.NewServer()
+	pb.RegisterInputServer(p.server, p)
+
+	longpanic.Go(func() {
+		if err := p.server.Serve(p.listener); err != nil {
+			p.logger.Errorf("grpc server error: %s", err.Error())
+		}
+	})
+}
+
+func (p *Plugin) Stop() {
+	p.server.Stop()
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.streamsMu.RLock()
+	s, has := p.streams[event.SourceID]
+	p.streamsMu.RUnlock()
+
+	// stream is already closed, so the client should resend the entry
+	if !has {
+		return
+	}
+
+	s.ack(uint64(event.Offset))
+}
+
+// Stream implements the gRPC method.
+func (p *Plugin) Stream(server pb.Input_StreamServer) error {
+	s := &entryStream{
+		sourceID: pipeline.SourceID(p.sourceSeq.Inc()),
+		inflight: make(chan struct{}, p.config.MaxInflight),
+		pending:  &sync.WaitGroup{},
+		acks:     make(chan uint64, p.config.MaxInflight),
+	}
+
+	sourceName := "grpc"
+	if pr, ok := peer.FromContext(server.Context()); ok {
+		sourceName = pr.Addr.String()
+	}
+
+	p.streamsMu.Lock()
+	p.streams[s.sourceID] = s
+	p.streamsMu.Unlock()
+
+	defer func() {
+		p.streamsMu.Lock()
+		delete(p.streams, s.sourceID)
+		p.streamsMu.Unlock()
+	}()
+
+	sendErr := make(chan error, 1)
+	longpanic.Go(func() {
+		sendErr <- s.sendAcks(server)
+	})
+
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	buf := make([]byte, 0)
+	keys := make([]string, 0)
+	isNewSource := true
+	for {
+		entry, err := server.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// blocks if there are too many unacked entries
+		select {
+		case s.inflight <- struct{}{}:
+		case err := <-sendErr:
+			return err
+		}
+		s.pending.Add(1)
+
+		buf, keys = encodeEntry(root, entry, buf[:0], keys[:0])
+		seqID := p.controller.In(s.sourceID, sourceName, int64(entry.Seq), buf, isNewSource)
+		isNewSource = false
+
+		// entry is skipped by the pipeline, so there will be no commit
+		if seqID == 0 {
+			s.ack(entry.Seq)
+		}
+	}
+
+	// wait for the commits of the rest of the entries since the client is waiting for acks
+	done := make(chan struct{})
+	longpanic.Go(func() {
+		s.pending.Wait()
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case err := <-sendErr:
+		return err
+	}
+
+	// all entries are acked, so flush the rest of the acks
+	p.streamsMu.Lock()
+	delete(p.streams, s.sourceID)
+	p.streamsMu.Unlock()
+	close(s.acks)
+
+	return <-sendErr
+}
+
+func (s *entryStream) ack(seq uint64) {
+	// acks channel has the same capacity as the inflight semaphore, so it never blocks
+	s.acks <- seq
+	<-s.inflight
+	s.pending.Done()
+}
+
+func (s *entryStream) sendAcks(server pb.Input_StreamServer) error {
+	ctx := server.Context()
+	ack := &pb.Ack{}
+	for {
+		select {
+		case seq, ok := <-s.acks:
+			if !ok {
+				return nil
+			}
+			ack.Seq = seq
+			if err := server.Send(ack); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func encodeEntry(root *insaneJSON.Root, entry *pb.Entry, out []byte, keys []string) ([]byte, []string) {
+	_ = root.DecodeString("{}")
+
+	if entry.TimeUnixNano != 0 {
+		ts := time.Unix(0, entry.TimeUnixNano).UTC().Format(time.RFC3339Nano)
+		root.AddFieldNoAlloc(root, "time").MutateToString(ts)
+	}
+	if entry.Level != "" {
+		root.AddFieldNoAlloc(root, "level").MutateToString(entry.Level)
+	}
+	if entry.Message != "" {
+		root.AddFieldNoAlloc(root, "message").MutateToString(entry.Message)
+	}
+
+	// sort keys to have the same field order for the same entries
+	for key := range entry.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		root.AddFieldNoAlloc(root, key).MutateToString(entry.Fields[key])
+	}
+
+	return root.Encode(out), keys
+}
@@ -0,0 +1,106 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/plugin/input/grpc/pb"
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	grpclib "google.golang.org/grpc"
+)
+
+func getInputInfo() *pipeline.InputPluginInfo {
+	input, _ := Factory()
+	return &pipeline.InputPluginInfo{
+		PluginStaticInfo: &pipeline.PluginStaticInfo{
+			Type:    "",
+			Factory: nil,
+			Config:  &Config{Address: "127.0.0.1:0", MaxInflight: 2},
+		},
+		PluginRuntimeInfo: &pipeline.PluginRuntimeInfo{
+			Plugin: input,
+			ID:     "",
+		},
+	}
+}
+
+func TestEncodeEntry(t *testing.T) {
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	entry := &pb.Entry{
+		Seq:          1,
+		TimeUnixNano: time.Date(2021, 6, 22, 16, 24, 27, 0, time.UTC).UnixNano(),
+		Level:        "error",
+		Message:      "some \"message\"",
+		Fields:       map[string]string{"service": "api", "host": "node1"},
+	}
+	out, _ := encodeEntry(root, entry, nil, nil)
+	assert.Equal(t, `{"time":"2021-06-22T16:24:27Z","level":"error","message":"some \"message\"","host":"node1","service":"api"}`, string(out))
+
+	out, _ = encodeEntry(root, &pb.Entry{Seq: 2, Message: "only message"}, out[:0], nil)
+	assert.Equal(t, `{"message":"only message"}`, string(out))
+}
+
+func TestStreamAcks(t *testing.T) {
+	p, _, output := test.NewPipelineMock(nil, "passive")
+	p.SetInput(getInputInfo())
+	input := p.GetInput().(*Plugin)
+	p.Start()
+	defer p.Stop()
+
+	const count = 10
+	wg := &sync.WaitGroup{}
+	wg.Add(count)
+
+	outEvents := make([]string, 0, count)
+	mu := &sync.Mutex{}
+	output.SetOutFn(func(event *pipeline.Event) {
+		mu.Lock()
+		outEvents = append(outEvents, event.Root.EncodeToString())
+		mu.Unlock()
+		wg.Done()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := grpclib.DialContext(ctx, input.listener.Addr().String(), grpclib.WithInsecure(), grpclib.WithBlock())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := pb.NewInputClient(conn).Stream(ctx)
+	require.NoError(t, err)
+
+	// more entries than max_inflight to check that the stream isn't stuck
+	for i := 1; i <= count; i++ {
+		require.NoError(t, stream.Send(&pb.Entry{Seq: uint64(i), Message: "message"}))
+	}
+	require.NoError(t, stream.CloseSend())
+
+	acks := make(map[uint64]bool)
+	for {
+		ack, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		acks[ack.Seq] = true
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, count, len(acks), "wrong acks count")
+	for i := 1; i <= count; i++ {
+		assert.True(t, acks[uint64(i)], "entry %d isn't acked", i)
+	}
+	assert.Equal(t, count, len(outEvents), "wrong events count")
+	assert.Equal(t, `{"message":"message"}`, outEvents[0], "wrong event")
+}
@@ -0,0 +1,137 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// InputClient is the client API for Input service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InputClient interface {
+	// Stream sends entries to file.d and receives acks for the entries which are committed by the pipeline output.
+	// The server stops reading entries if there are too many unacked entries in the stream.
+	Stream(ctx context.Context, opts ...grpc.CallOption) (Input_StreamClient, error)
+}
+
+type inputClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInputClient(cc grpc.ClientConnInterface) InputClient {
+	return &inputClient{cc}
+}
+
+func (c *inputClient) Stream(ctx context.Context, opts ...grpc.CallOption) (Input_StreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Input_ServiceDesc.Streams[0], "/filed.input.Input/Stream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &inputStreamClient{stream}
+	return x, nil
+}
+
+type Input_StreamClient interface {
+	Send(*Entry) error
+	Recv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type inputStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *inputStreamClient) Send(m *Entry) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *inputStreamClient) Recv() (*Ack, error) {
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InputServer is the server API for Input service.
+// All implementations must embed UnimplementedInputServer
+// for forward compatibility
+type InputServer interface {
+	// Stream sends entries to file.d and receives acks for the entries which are committed by the pipeline output.
+	// The server stops reading entries if there are too many unacked entries in the stream.
+	Stream(Input_StreamServer) error
+	mustEmbedUnimplementedInputServer()
+}
+
+// UnimplementedInputServer must be embedded to have forward compatible implementations.
+type UnimplementedInputServer struct {
+}
+
+func (UnimplementedInputServer) Stream(Input_StreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
+}
+func (UnimplementedInputServer) mustEmbedUnimplementedInputServer() {}
+
+// UnsafeInputServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InputServer will
+// result in compilation errors.
+type UnsafeInputServer interface {
+	mustEmbedUnimplementedInputServer()
+}
+
+func RegisterInputServer(s grpc.ServiceRegistrar, srv InputServer) {
+	s.RegisterService(&Input_ServiceDesc, srv)
+}
+
+func _Input_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(InputServer).Stream(&inputStreamServer{stream})
+}
+
+type Input_StreamServer interface {
+	Send(*Ack) error
+	Recv() (*Entry, error)
+	grpc.ServerStream
+}
+
+type inputStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *inputStreamServer) Send(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *inputStreamServer) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Input_ServiceDesc is the grpc.ServiceDesc for Input service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Input_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filed.input.Input",
+	HandlerType: (*InputServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _Input_Stream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "input.proto",
+}
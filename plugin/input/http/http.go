@@ -1,13 +1,17 @@
 package http
 
 import (
+	"context"
 	"io"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/ozonru/file.d/fd"
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/longpanic"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
 )
 
@@ -23,6 +27,9 @@ So you can use Elasticsearch filebeat output plugin to send data to `file.d`.
 > It doesn't wait until events are committed.
 }*/
 
+// shutdownTimeout is how long the stopping plugin waits for requests which are being read
+const shutdownTimeout = 5 * time.Second
+
 type Plugin struct {
 	config     *Config
 	params     *pipeline.InputPluginParams
@@ -40,7 +47,8 @@ type Plugin struct {
 type Config struct {
 	//> @3@4@5@6
 	//>
-	//> An address to listen to. Omit ip/host to listen all network interfaces. E.g. `:88`
+	//> An address to listen to. Omit ip/host to listen all network interfaces, both IPv4 and IPv6. E.g. `:88`.
+	//> IPv6 hosts should be in brackets, e.g. `[::1]:88`
 	Address string `json:"address" default:":9200"` //*
 	//> @3@4@5@6
 	//>
@@ -85,13 +93,20 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 	p.server = &http.Server{Addr: p.config.Address, Handler: mux}
 
 	if p.config.Address != "off" {
-		longpanic.Go(p.listenHTTP)
+		// the address is bound before the start returns, so the old instance is surely gone on reload
+		listener, err := netutil.Listen("tcp", p.config.Address)
+		if err != nil {
+			logger.Fatalf("input plugin http listening error address=%q: %s", p.config.Address, err.Error())
+		}
+		longpanic.Go(func() {
+			p.listenHTTP(listener)
+		})
 	}
 }
 
-func (p *Plugin) listenHTTP() {
-	err := p.server.ListenAndServe()
-	if err != nil {
+func (p *Plugin) listenHTTP(listener net.Listener) {
+	err := p.server.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
 		logger.Fatalf("input plugin http listening error address=%q: %s", p.config.Address, err.Error())
 	}
 }
@@ -184,6 +199,15 @@ func (p *Plugin) processChunk(sourceID pipeline.SourceID, readBuff []byte, event
 }
 
 func (p *Plugin) Stop() {
+	if p.config.Address == "off" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := p.server.Shutdown(ctx); err != nil {
+		logger.Errorf("can't shutdown http server address=%q: %s", p.config.Address, err.Error())
+	}
 }
 
 func (p *Plugin) Commit(_ *pipeline.Event) {
@@ -1,12 +1,21 @@
 package http
 
 import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"sync"
 	"testing"
 
+	"github.com/bitly/go-simplejson"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
 	"github.com/ozonru/file.d/pipeline"
+	_ "github.com/ozonru/file.d/plugin/output/devnull"
 	"github.com/ozonru/file.d/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func getInputInfo() *pipeline.InputPluginInfo {
@@ -147,3 +156,38 @@ func TestProcessChunksContinueMany(t *testing.T) {
 	assert.Equal(t, `{"a":"1"}`, outEvents[0], "wrong event")
 	assert.Equal(t, 0, len(eventBuff), "wrong event")
 }
+
+func newReloadConfig(t *testing.T, address string, emulateMode string) *cfg.Config {
+	raw, err := simplejson.NewJson([]byte(fmt.Sprintf(
+		`{"input":{"type":"http","address":%q,"emulate_mode":%q},"output":{"type":"devnull"}}`, address, emulateMode,
+	)))
+	require.NoError(t, err)
+
+	config := cfg.NewConfig()
+	config.Pipelines["http"] = &cfg.PipelineConfig{Raw: raw}
+
+	return config
+}
+
+func TestReload(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	f := fd.New(newReloadConfig(t, address, "no"), "off")
+	f.Start()
+	defer f.Stop()
+
+	// the old server must release the address, otherwise the new one can't listen to it
+	f.Reload(newReloadConfig(t, address, "elasticsearch"))
+
+	resp, err := http.Get("http://" + address + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "wrong status code")
+	assert.Contains(t, string(body), "cluster_name", "request isn't served by the new server")
+}
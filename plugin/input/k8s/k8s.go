@@ -76,6 +76,12 @@ type Config struct {
 	//> Skips retrieving Kubernetes meta information using Kubernetes API and adds only `k8s_node` field.
 	OnlyNode bool `json:"only_node" default:"false"` //*
 
+	//> @3@4@5@6
+	//>
+	//> If set, k8s fields are also put into the event metadata with the same keys, e.g. `k8s_namespace` or `k8s_pod_label_app`.
+	//> So outputs may use them in templates, like `{{meta.k8s_namespace}}`, even if the fields are removed from the event.
+	FillMeta bool `json:"fill_meta" default:"false"` //*
+
 	//> @3@4@5@6
 	//>
 	//> Kubernetes dir with container logs. It's like `watching_dir` parameter from [file plugin](/plugin/input/file/README.md) config.
@@ -102,7 +108,8 @@ func init() {
 		AdditionalActions: []string{"k8s-multiline"},
 
 		Endpoints: map[string]func(http.ResponseWriter, *http.Request){
-			"reset": file.ResetterRegistryInstance.Reset,
+			"reset":   file.ResetterRegistryInstance.Reset,
+			"offsets": file.ResetterRegistryInstance.Offsets,
 		},
 	})
 	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
@@ -115,6 +122,11 @@ func MultilineActionFactory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 	return &MultilineAction{}, &Config{}
 }
 
+// FileInputConfig returns the config of the underlying file input, e.g. to find its offsets file.
+func (c *Config) FileInputConfig() *file.Config {
+	return &c.FileConfig
+}
+
 func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 	return &Plugin{}, &Config{}
 }
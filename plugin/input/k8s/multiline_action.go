@@ -41,6 +41,7 @@ func (p *MultilineAction) Do(event *pipeline.Event) pipeline.ActionResult {
 	}
 
 	event.Root.AddFieldNoAlloc(event.Root, "k8s_node").MutateToString(selfNodeName)
+	p.setMeta(event, "k8s_node", selfNodeName)
 	if p.config.OnlyNode {
 		return pipeline.ActionPass
 	}
@@ -72,6 +73,9 @@ func (p *MultilineAction) Do(event *pipeline.Event) pipeline.ActionResult {
 	event.Root.AddFieldNoAlloc(event.Root, "k8s_namespace").MutateToString(string(ns))
 	event.Root.AddFieldNoAlloc(event.Root, "k8s_pod").MutateToString(string(pod))
 	event.Root.AddFieldNoAlloc(event.Root, "k8s_container").MutateToString(string(container))
+	p.setMeta(event, "k8s_namespace", string(ns))
+	p.setMeta(event, "k8s_pod", string(pod))
+	p.setMeta(event, "k8s_container", string(container))
 
 	if success {
 		if ns != namespace(podMeta.Namespace) {
@@ -94,6 +98,7 @@ func (p *MultilineAction) Do(event *pipeline.Event) pipeline.ActionResult {
 			event.Buf = append(event.Buf, "k8s_pod_label_"...)
 			event.Buf = append(event.Buf, labelName...)
 			event.Root.AddFieldNoAlloc(event.Root, pipeline.ByteToStringUnsafe(event.Buf[l:])).MutateToString(labelValue)
+			p.setMeta(event, "k8s_pod_label_"+labelName, labelValue)
 		}
 
 		for labelName, labelValue := range nodeLabels {
@@ -109,6 +114,7 @@ func (p *MultilineAction) Do(event *pipeline.Event) pipeline.ActionResult {
 			event.Buf = append(event.Buf, "k8s_node_label_"...)
 			event.Buf = append(event.Buf, labelName...)
 			event.Root.AddFieldNoAlloc(event.Root, pipeline.ByteToStringUnsafe(event.Buf[l:])).MutateToString(labelValue)
+			p.setMeta(event, "k8s_node_label_"+labelName, labelValue)
 		}
 	}
 
@@ -126,3 +132,11 @@ func (p *MultilineAction) Do(event *pipeline.Event) pipeline.ActionResult {
 
 	return pipeline.ActionPass
 }
+
+// setMeta puts the k8s field into the event metadata if it's enabled,
+// values shouldn't point to the event buffer since the metadata outlives it.
+func (p *MultilineAction) setMeta(event *pipeline.Event, key string, value string) {
+	if p.config.FillMeta {
+		event.SetMeta(key, value)
+	}
+}
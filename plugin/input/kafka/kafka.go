@@ -25,6 +25,8 @@ type Plugin struct {
 	context       context.Context
 	controller    pipeline.InputPluginController
 	idByTopic     map[string]int
+	// gate blocks consuming while the input is paused by backpressure of outputs
+	gate *pipeline.InputGate
 }
 
 //! config-params
@@ -67,6 +69,7 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 		p.idByTopic[topic] = i
 	}
 
+	p.gate = pipeline.NewInputGate()
 	p.context, p.cancel = context.WithCancel(context.Background())
 	p.consumerGroup = p.newConsumerGroup()
 	p.controller.UseSpread()
@@ -91,6 +94,17 @@ func (p *Plugin) consume() {
 
 func (p *Plugin) Stop() {
 	p.cancel()
+	p.gate.Stop()
+}
+
+// PauseInput stops consuming of messages until the input is resumed,
+// the session is kept alive, so partitions aren't rebalanced.
+func (p *Plugin) PauseInput() {
+	p.gate.Pause()
+}
+
+func (p *Plugin) ResumeInput() {
+	p.gate.Resume()
 }
 
 func (p *Plugin) Commit(event *pipeline.Event) {
@@ -126,8 +140,13 @@ func (p *Plugin) Cleanup(sarama.ConsumerGroupSession) error {
 	return nil
 }
 
-func (p *Plugin) ConsumeClaim(_ sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+func (p *Plugin) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
+		// the message isn't marked, so it's consumed again by the next session
+		if !p.gate.Wait(session.Context().Done()) {
+			return nil
+		}
+
 		sourceID := assembleSourceID(p.idByTopic[message.Topic], message.Partition)
 		p.controller.In(sourceID, "kafka", message.Offset, message.Value, true)
 	}
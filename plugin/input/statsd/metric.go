@@ -0,0 +1,134 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/ozonru/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+var (
+	errNotMetric      = errors.New("line isn't a metric")
+	errWrongFormat    = errors.New("metric should be in the format name:value|type")
+	errWrongValue     = errors.New("wrong metric value")
+	errWrongType      = errors.New("unknown metric type")
+	errWrongRate      = errors.New("wrong sample rate")
+	errWrongTimestamp = errors.New("wrong timestamp")
+)
+
+var metricTypes = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "histogram",
+	"s":  "set",
+	"d":  "distribution",
+	"m":  "meter",
+}
+
+// parseMetric decodes the statsd line into the root,
+// the root references the line, so the line should live until the root is encoded.
+//
+// Examples of format:
+// api.requests:1|c
+// api.latency:320|ms|@0.1|#env:prod,canary
+// api.workers:-2|g|T1656581400
+func parseMetric(root *insaneJSON.Root, line []byte) error {
+	// dogstatsd events and service checks
+	if bytes.HasPrefix(line, []byte("_e{")) || bytes.HasPrefix(line, []byte("_sc|")) {
+		return errNotMetric
+	}
+
+	colon := bytes.IndexByte(line, ':')
+	if colon <= 0 {
+		return errWrongFormat
+	}
+	name := line[:colon]
+
+	sections := bytes.Split(line[colon+1:], []byte("|"))
+	if len(sections) < 2 || len(sections[0]) == 0 {
+		return errWrongFormat
+	}
+	value := sections[0]
+
+	metricType, has := metricTypes[pipeline.ByteToStringUnsafe(sections[1])]
+	if !has {
+		return errWrongType
+	}
+
+	_ = root.DecodeString("{}")
+	root.AddFieldNoAlloc(root, "name").MutateToBytes(name)
+	root.AddFieldNoAlloc(root, "type").MutateToString(metricType)
+
+	if metricType == "set" {
+		root.AddFieldNoAlloc(root, "value").MutateToBytes(value)
+	} else {
+		x, err := strconv.ParseFloat(pipeline.ByteToStringUnsafe(value), 64)
+		if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+			return errWrongValue
+		}
+		root.AddFieldNoAlloc(root, "value").MutateToFloat(x)
+
+		if metricType == "gauge" && (value[0] == '+' || value[0] == '-') {
+			root.AddFieldNoAlloc(root, "delta").MutateToBool(true)
+		}
+	}
+
+	for _, section := range sections[2:] {
+		if len(section) == 0 {
+			continue
+		}
+
+		switch section[0] {
+		case '@':
+			rate, err := strconv.ParseFloat(pipeline.ByteToStringUnsafe(section[1:]), 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return errWrongRate
+			}
+			root.AddFieldNoAlloc(root, "sample_rate").MutateToFloat(rate)
+		case '#':
+			addTags(root, section[1:])
+		case 'T':
+			ts, err := strconv.ParseInt(pipeline.ByteToStringUnsafe(section[1:]), 10, 64)
+			if err != nil {
+				return errWrongTimestamp
+			}
+			root.AddFieldNoAlloc(root, "timestamp").MutateToInt(int(ts))
+		}
+		// other dogstatsd extensions like container id are ignored
+	}
+
+	return nil
+}
+
+func addTags(root *insaneJSON.Root, tags []byte) {
+	node := root.AddFieldNoAlloc(root, "tags").MutateToObject()
+	for len(tags) > 0 {
+		tag := tags
+		pos := bytes.IndexByte(tags, ',')
+		if pos >= 0 {
+			tag = tags[:pos]
+			tags = tags[pos+1:]
+		} else {
+			tags = tags[:0]
+		}
+		if len(tag) == 0 {
+			continue
+		}
+
+		key, value := tag, tag[:0]
+		if pos := bytes.IndexByte(tag, ':'); pos >= 0 {
+			key, value = tag[:pos], tag[pos+1:]
+		}
+
+		keyStr := pipeline.ByteToStringUnsafe(key)
+		field := node.Dig(keyStr)
+		if field == nil {
+			field = node.AddFieldNoAlloc(root, keyStr)
+		}
+		field.MutateToBytes(value)
+	}
+}
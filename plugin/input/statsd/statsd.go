@@ -0,0 +1,151 @@
+package statsd
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/ozonru/file.d/decoder"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/longpanic"
+	"github.com/ozonru/file.d/netutil"
+	"github.com/ozonru/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It receives metrics in the statsd and dogstatsd formats via UDP and converts each metric into the event:
+`{"name":"...","type":"...","value":...,"sample_rate":...,"tags":{...}}`.
+A packet may contain several metrics delimited by a new line.
+
+Types are `counter`, `gauge`, `timer`, `histogram`, `set`, `distribution` and `meter`.
+The value of `set` is a string, other values are numbers. Gauges with the sign are marked with `"delta":true`.
+Dogstatsd tags without a value have an empty value. Dogstatsd events, service checks and malformed lines are skipped.
+
+> ⚠ UDP has no delivery guarantees, so metrics may be lost under the load.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    input:
+      type: statsd
+      address: ":8125"
+    ...
+```
+It transforms `api.requests:1|c|@0.5|#env:prod,canary` into
+`{"name":"api.requests","type":"counter","value":1,"sample_rate":0.5,"tags":{"env":"prod","canary":""}}`.
+}*/
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	conn       net.PacketConn
+	isStopped  *atomic.Bool
+
+	root  *insaneJSON.Root
+	event []byte
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> An address to listen to. Omit ip/host to listen all network interfaces, both IPv4 and IPv6. E.g. `:8125`.
+	//> IPv6 hosts should be in brackets, e.g. `[::1]:8125`
+	Address string `json:"address" default:":8125"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The maximum size of a UDP packet. Bigger packets are truncated.
+	MaxPacketSize int `json:"max_packet_size" default:"65535"` //*
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    "statsd",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.controller = params.Controller
+	p.controller.SuggestDecoder(decoder.JSON)
+	p.controller.DisableStreams()
+
+	p.isStopped = atomic.NewBool(false)
+	p.root = insaneJSON.Spawn()
+
+	if p.config.MaxPacketSize <= 0 {
+		p.logger.Fatalf("max_packet_size should be positive, got %d", p.config.MaxPacketSize)
+	}
+
+	var err error
+	p.conn, err = netutil.ListenPacket("udp", p.config.Address)
+	if err != nil {
+		p.logger.Fatalf("can't listen %s: %s", p.config.Address, err.Error())
+	}
+
+	longpanic.Go(p.listen)
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	_ = p.conn.Close()
+}
+
+func (p *Plugin) Commit(_ *pipeline.Event) {
+}
+
+func (p *Plugin) listen() {
+	defer insaneJSON.Release(p.root)
+
+	packet := make([]byte, p.config.MaxPacketSize)
+	for {
+		n, _, err := p.conn.ReadFrom(packet)
+		if err != nil {
+			if p.isStopped.Load() {
+				return
+			}
+			p.logger.Errorf("can't read packet: %s", err.Error())
+			continue
+		}
+
+		p.processPacket(packet[:n])
+	}
+}
+
+func (p *Plugin) processPacket(packet []byte) {
+	for len(packet) > 0 {
+		line := packet
+		pos := bytes.IndexByte(packet, '\n')
+		if pos >= 0 {
+			line = packet[:pos]
+			packet = packet[pos+1:]
+		} else {
+			packet = packet[:0]
+		}
+
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := parseMetric(p.root, line); err != nil {
+			continue
+		}
+
+		p.event = p.root.Encode(p.event[:0])
+		p.controller.In(0, "statsd", 0, p.event, false)
+	}
+}
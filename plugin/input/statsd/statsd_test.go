@@ -0,0 +1,110 @@
+package statsd
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestParseMetric(t *testing.T) {
+	cases := []struct {
+		line string
+		out  string
+	}{
+		{
+			line: "api.requests:1|c",
+			out:  `{"name":"api.requests","type":"counter","value":1}`,
+		},
+		{
+			line: "api.requests:1|c|@0.5|#env:prod,canary",
+			out:  `{"name":"api.requests","type":"counter","value":1,"sample_rate":0.5,"tags":{"env":"prod","canary":""}}`,
+		},
+		{
+			line: "api.latency:320.5|ms|#env:prod,env:dev",
+			out:  `{"name":"api.latency","type":"timer","value":320.5,"tags":{"env":"dev"}}`,
+		},
+		{
+			line: "api.workers:-2|g|T1656581400|c:83c0a99c",
+			out:  `{"name":"api.workers","type":"gauge","value":-2,"delta":true,"timestamp":1656581400}`,
+		},
+		{
+			line: `api.users:user"1|s`,
+			out:  `{"name":"api.users","type":"set","value":"user\"1"}`,
+		},
+	}
+
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	for _, c := range cases {
+		err := parseMetric(root, []byte(c.line))
+		require.NoError(t, err, "wrong line %s", c.line)
+		assert.Equal(t, c.out, root.EncodeToString(), "wrong event for %s", c.line)
+	}
+}
+
+func TestParseMetricErrors(t *testing.T) {
+	lines := []string{
+		"api.requests",
+		"api.requests:1",
+		"api.requests:|c",
+		"api.requests:1|x",
+		"api.requests:one|c",
+		"api.requests:NaN|c",
+		"api.requests:1|c|@2",
+		"_e{5,4}:title|text",
+		"_sc|check|0",
+	}
+
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	for _, line := range lines {
+		assert.Error(t, parseMetric(root, []byte(line)), "line %s should be skipped", line)
+	}
+}
+
+func TestListen(t *testing.T) {
+	input, _ := Factory()
+	p, _, output := test.NewPipelineMock(nil, "passive")
+	p.SetInput(&pipeline.InputPluginInfo{
+		PluginStaticInfo: &pipeline.PluginStaticInfo{
+			Config: &Config{Address: "127.0.0.1:0", MaxPacketSize: 1024},
+		},
+		PluginRuntimeInfo: &pipeline.PluginRuntimeInfo{
+			Plugin: input,
+		},
+	})
+	plugin := input.(*Plugin)
+	p.Start()
+	defer p.Stop()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+
+	outEvents := make([]string, 0)
+	output.SetOutFn(func(event *pipeline.Event) {
+		outEvents = append(outEvents, event.Root.EncodeToString())
+		wg.Done()
+	})
+
+	conn, err := net.Dial("udp", plugin.conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("a:1|c\nwrong\nb:2|g\n"))
+	require.NoError(t, err)
+
+	wg.Wait()
+
+	assert.Equal(t, []string{
+		`{"name":"a","type":"counter","value":1}`,
+		`{"name":"b","type":"gauge","value":2}`,
+	}, outEvents)
+}
@@ -0,0 +1,290 @@
+// Package azure_blob is an output plugin that sends events to Azure Blob Storage.
+package azure_blob
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It sends events to Azure Blob Storage, events are delimited by a new line.
+Blob names are partitioned by time: `<path>/<time_format>`.
+
+* `block` blob type: each batch is uploaded as a separate blob, e.g. `file.d/2021-06-22/16/1624378467000000000-1.log`.
+* `append` blob type: batches are appended to the blob of the time partition, e.g. `file.d/2021-06-22/16.log`.
+The blob is created if it doesn't exist.
+
+The account key or the SAS token is used for the authorization.
+The batch is retried until it's accepted, so the pipeline is blocked while the storage isn't available.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: azure_blob
+      account: mystorage
+      account_key: ${AZURE_STORAGE_KEY}
+      container: logs
+      blob_type: append
+    ...
+```
+}*/
+
+const (
+	blobTypeBlock  = "block"
+	blobTypeAppend = "append"
+
+	// maximum size of the append block
+	maxAppendSize = 4 * 1024 * 1024
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	avgLogSize int
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+	client     *blobClient
+	blobSeq    *atomic.Uint64
+	isStopped  atomic.Bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> A name of the storage account.
+	Account string `json:"account" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A key of the storage account. Either `account_key` or `sas_token` should be set.
+	AccountKey string `json:"account_key"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A SAS token with the write permission for the container, e.g. `sv=2020-08-04&ss=b&...`.
+	SASToken string `json:"sas_token"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A custom endpoint of the blob service, e.g. to use Azurite. Defaults to `https://<account>.blob.core.windows.net`.
+	Endpoint string `json:"endpoint"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A name of the container.
+	Container string `json:"container" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A prefix of blob names.
+	Path string `json:"path" default:"file.d"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A time partition of blob names in Go layout.
+	TimeFormat string `json:"time_format" default:"2006-01-02/15"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A type of blobs.
+	BlobType string `json:"blob_type" default:"block" options:"block|append"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` //*
+	WorkersCount_ int
+
+	//> @3@4@5@6
+	//>
+	//> Client timeout when sends requests to the storage.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` //*
+	RequestTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` //*
+	BatchSize_ int
+
+	//> @3@4@5@6
+	//>
+	//> After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"1s" parse:"duration"` //*
+	BatchFlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Retries of the failed request are delayed by this interval.
+	RetryInterval  cfg.Duration `json:"retry_interval" default:"1s" parse:"duration"` //*
+	RetryInterval_ time.Duration
+}
+
+type data struct {
+	outBuf []byte
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    "azure_blob",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgLogSize = params.PipelineSettings.AvgLogSize
+	p.config = config.(*Config)
+	p.blobSeq = atomic.NewUint64(0)
+
+	if (p.config.AccountKey == "") == (p.config.SASToken == "") {
+		p.logger.Fatalf("either account_key or sas_token should be set")
+	}
+
+	endpoint := p.config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", p.config.Account)
+	}
+
+	var err error
+	p.client, err = newBlobClient(
+		&http.Client{Timeout: p.config.RequestTimeout_},
+		strings.TrimRight(endpoint, "/"),
+		p.config.Account,
+		p.config.AccountKey,
+		strings.TrimPrefix(p.config.SASToken, "?"),
+		p.config.Container,
+	)
+	if err != nil {
+		p.logger.Fatalf("can't create blob client: %s", err.Error())
+	}
+
+	p.batcher = pipeline.NewBatcher(
+		params.PipelineName,
+		"azure_blob",
+		p.out,
+		p.maintenance,
+		p.controller,
+		p.config.WorkersCount_,
+		p.config.BatchSize_,
+		p.config.BatchFlushTimeout_,
+		0,
+	)
+	p.batcher.Start()
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+	p.client.close()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+	if *workerData == nil {
+		*workerData = &data{
+			outBuf: make([]byte, 0, p.config.BatchSize_*p.avgLogSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle to much memory consumption
+	if cap(data.outBuf) > p.config.BatchSize_*p.avgLogSize {
+		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgLogSize)
+	}
+
+	outBuf := data.outBuf[:0]
+	for _, event := range batch.Events {
+		outBuf, _ = event.Encode(outBuf)
+		outBuf = append(outBuf, '\n')
+	}
+	data.outBuf = outBuf
+
+	now := time.Now()
+	partition := p.config.Path + "/" + now.Format(p.config.TimeFormat)
+	var err error
+	if p.config.BlobType == blobTypeAppend {
+		err = p.append(partition+".log", outBuf)
+	} else {
+		name := fmt.Sprintf("%s/%d-%d.log", partition, now.UnixNano(), p.blobSeq.Inc())
+		err = p.retry(name, func() error {
+			return p.client.putBlockBlob(name, outBuf)
+		})
+	}
+
+	if err != nil {
+		batch.Fail(err)
+	}
+}
+
+func (p *Plugin) append(name string, outBuf []byte) error {
+	for len(outBuf) > 0 {
+		chunk := outBuf
+		if len(chunk) > maxAppendSize {
+			// don't split events between blocks if it's possible
+			chunk = chunk[:maxAppendSize]
+			if pos := bytes.LastIndexByte(chunk, '\n'); pos > 0 {
+				chunk = chunk[:pos+1]
+			}
+		}
+		outBuf = outBuf[len(chunk):]
+
+		err := p.retry(name, func() error {
+			err := p.client.appendBlock(name, chunk)
+			if err != errBlobNotFound {
+				return err
+			}
+
+			if err := p.client.createAppendBlob(name); err != nil {
+				return err
+			}
+			return p.client.appendBlock(name, chunk)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retry writes the blob until it succeeds, the last error is returned if the output is stopped.
+func (p *Plugin) retry(name string, fn func() error) error {
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		p.logger.Errorf("can't write blob %s/%s: %s", p.config.Container, name, err.Error())
+		// connections are closed by the stop, so the blob can't be written anymore
+		if p.isStopped.Load() {
+			return err
+		}
+		time.Sleep(p.config.RetryInterval_)
+	}
+}
+
+func (p *Plugin) maintenance(_ *pipeline.WorkerData) {}
@@ -0,0 +1,132 @@
+package azure_blob
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+type fakeStorage struct {
+	mu    *sync.Mutex
+	blobs map[string]string
+	types map[string]string
+}
+
+func (s *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey account:") || r.Header.Get("x-ms-date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	name := r.URL.Path
+	_, exists := s.blobs[name]
+
+	switch {
+	case r.URL.Query().Get("comp") == "appendblock":
+		if !exists || s.types[name] != "AppendBlob" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.blobs[name] += string(body)
+	case r.Header.Get("If-None-Match") == "*" && exists:
+		w.WriteHeader(http.StatusConflict)
+		return
+	default:
+		s.blobs[name] = string(body)
+		s.types[name] = r.Header.Get("x-ms-blob-type")
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func newPlugin(t *testing.T, blobType string) (*Plugin, *fakeStorage) {
+	storage := &fakeStorage{mu: &sync.Mutex{}, blobs: make(map[string]string), types: make(map[string]string)}
+	server := httptest.NewServer(storage)
+	t.Cleanup(server.Close)
+
+	key := base64.StdEncoding.EncodeToString([]byte("key"))
+	client, err := newBlobClient(server.Client(), server.URL, "account", key, "", "logs")
+	require.NoError(t, err)
+
+	return &Plugin{
+		config: &Config{
+			Container:  "logs",
+			Path:       "file.d",
+			TimeFormat: "2006",
+			BlobType:   blobType,
+			BatchSize_: 10,
+		},
+		logger:     zap.NewNop().Sugar(),
+		avgLogSize: 16,
+		client:     client,
+		blobSeq:    atomic.NewUint64(0),
+	}, storage
+}
+
+func newBatch(events ...string) *pipeline.Batch {
+	batch := &pipeline.Batch{}
+	for _, event := range events {
+		root, _ := insaneJSON.DecodeString(event)
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+	return batch
+}
+
+func TestAppend(t *testing.T) {
+	p, storage := newPlugin(t, blobTypeAppend)
+
+	workerData := pipeline.WorkerData(nil)
+	p.out(&workerData, newBatch(`{"a":"1"}`, `{"a":"2"}`))
+	p.out(&workerData, newBatch(`{"a":"3"}`))
+
+	name := "/logs/file.d/" + time.Now().Format("2006") + ".log"
+	assert.Equal(t, "{\"a\":\"1\"}\n{\"a\":\"2\"}\n{\"a\":\"3\"}\n", storage.blobs[name])
+	assert.Equal(t, "AppendBlob", storage.types[name])
+}
+
+func TestBlock(t *testing.T) {
+	p, storage := newPlugin(t, blobTypeBlock)
+
+	workerData := pipeline.WorkerData(nil)
+	p.out(&workerData, newBatch(`{"a":"1"}`, `{"a":"2"}`))
+	p.out(&workerData, newBatch(`{"a":"3"}`))
+
+	require.Equal(t, 2, len(storage.blobs), "wrong blobs count")
+	for name, blob := range storage.blobs {
+		assert.True(t, strings.HasPrefix(name, "/logs/file.d/"+time.Now().Format("2006")+"/"), "wrong blob name %s", name)
+		assert.Equal(t, "BlockBlob", storage.types[name])
+		assert.True(t, strings.HasSuffix(blob, "}\n"), "wrong blob %s", blob)
+	}
+}
+
+func TestSign(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("key"))
+	c, err := newBlobClient(nil, "https://account.blob.core.windows.net", "account", key, "", "logs")
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodPut, "https://account.blob.core.windows.net/logs/a.log?comp=appendblock", nil)
+	req.Header.Set("x-ms-date", "Tue, 22 Jun 2021 16:24:27 GMT")
+	req.Header.Set("x-ms-version", apiVersion)
+
+	expected := "PUT\n\n\n10\n\n\n\n\n\n\n\n\n" +
+		"x-ms-date:Tue, 22 Jun 2021 16:24:27 GMT\nx-ms-version:" + apiVersion + "\n" +
+		"/account/logs/a.log\ncomp:appendblock"
+	assert.Equal(t, expected, c.stringToSign(req, "/logs/a.log", req.URL.Query(), 10))
+	assert.NotEqual(t, c.sign(req, "/logs/a.log", req.URL.Query(), 10), c.sign(req, "/logs/b.log", req.URL.Query(), 10))
+}
@@ -0,0 +1,187 @@
+package azure_blob
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiVersion = "2020-04-08"
+
+var errBlobNotFound = errors.New("blob isn't found")
+
+// blobClient is a minimal client of the Blob service REST API,
+// see https://docs.microsoft.com/en-us/rest/api/storageservices/blob-service-rest-api.
+type blobClient struct {
+	client    *http.Client
+	endpoint  string
+	account   string
+	key       []byte
+	sasToken  string
+	container string
+}
+
+func newBlobClient(client *http.Client, endpoint, account, key, sasToken, container string) (*blobClient, error) {
+	c := &blobClient{
+		client:    client,
+		endpoint:  endpoint,
+		account:   account,
+		sasToken:  sasToken,
+		container: container,
+	}
+
+	if key != "" {
+		var err error
+		c.key, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("account key isn't base64 encoded: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *blobClient) close() {
+	c.client.CloseIdleConnections()
+}
+
+func (c *blobClient) putBlockBlob(name string, data []byte) error {
+	header := http.Header{}
+	header.Set("x-ms-blob-type", "BlockBlob")
+
+	_, err := c.do(http.MethodPut, name, nil, header, data)
+
+	return err
+}
+
+func (c *blobClient) createAppendBlob(name string) error {
+	header := http.Header{}
+	header.Set("x-ms-blob-type", "AppendBlob")
+	// blob may be already created by another worker
+	header.Set("If-None-Match", "*")
+
+	code, err := c.do(http.MethodPut, name, nil, header, nil)
+	if code == http.StatusConflict {
+		return nil
+	}
+
+	return err
+}
+
+func (c *blobClient) appendBlock(name string, data []byte) error {
+	code, err := c.do(http.MethodPut, name, url.Values{"comp": {"appendblock"}}, http.Header{}, data)
+	if code == http.StatusNotFound {
+		return errBlobNotFound
+	}
+
+	return err
+}
+
+func (c *blobClient) do(method, name string, query url.Values, header http.Header, body []byte) (int, error) {
+	path := "/" + c.container + "/" + name
+	rawQuery := query.Encode()
+	if c.sasToken != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += c.sasToken
+	}
+
+	u, err := url.Parse(c.endpoint + path)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse url: %w", err)
+	}
+	u.RawQuery = rawQuery
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("can't create request: %w", err)
+	}
+	req.Header = header
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", apiVersion)
+	if c.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+c.account+":"+c.sign(req, u.EscapedPath(), query, len(body)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("can't send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, fmt.Errorf("wrong response code %d: %s", resp.StatusCode, respBody)
+}
+
+// sign builds the Shared Key signature,
+// see https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key.
+func (c *blobClient) sign(req *http.Request, path string, query url.Values, contentLength int) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(c.stringToSign(req, path, query, contentLength)))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *blobClient) stringToSign(req *http.Request, path string, query url.Values, contentLength int) string {
+	length := ""
+	if contentLength > 0 {
+		length = strconv.Itoa(contentLength)
+	}
+
+	b := strings.Builder{}
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.Header.Get("Content-Encoding") + "\n")
+	b.WriteString(req.Header.Get("Content-Language") + "\n")
+	b.WriteString(length + "\n")
+	b.WriteString(req.Header.Get("Content-MD5") + "\n")
+	b.WriteString(req.Header.Get("Content-Type") + "\n")
+	b.WriteString("\n") // date is passed in x-ms-date
+	b.WriteString(req.Header.Get("If-Modified-Since") + "\n")
+	b.WriteString(req.Header.Get("If-Match") + "\n")
+	b.WriteString(req.Header.Get("If-None-Match") + "\n")
+	b.WriteString(req.Header.Get("If-Unmodified-Since") + "\n")
+	b.WriteString(req.Header.Get("Range") + "\n")
+
+	msHeaders := make([]string, 0)
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name)
+		}
+	}
+	sort.Strings(msHeaders)
+	for _, name := range msHeaders {
+		b.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	b.WriteString("/" + c.account + path)
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	return b.String()
+}
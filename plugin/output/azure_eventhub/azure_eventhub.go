@@ -0,0 +1,363 @@
+// Package azure_eventhub is an output plugin that sends events to Azure Event Hubs.
+package azure_eventhub
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It sends events to Azure Event Hubs using AMQP, events of the batch are packed into batch messages of up to 1MB.
+The partition key of a message can be taken from the event field, so events with the same key get into the same partition,
+the batch is split into messages by the partition key.
+
+The shared access policy with the `Send` claim is used for the authorization.
+The message is retried until it's accepted or the batch is expired, so the pipeline is blocked while Event Hubs isn't available.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: azure_eventhub
+      namespace: myns.servicebus.windows.net
+      event_hub: logs
+      key_name: file-d
+      key: ${EVENT_HUB_KEY}
+      partition_key_field: service
+    ...
+```
+}*/
+
+const (
+	// partitionKeyAnnotation is a message annotation which Event Hubs uses to choose the partition.
+	partitionKeyAnnotation = "x-opt-partition-key"
+	// batchMessageFormat marks the message whose data sections are encoded messages.
+	batchMessageFormat uint32 = 0x80013700
+	// maxBatchMessageSize keeps the batch message under the limit of 1MB with a margin for the envelope.
+	maxBatchMessageSize = 1000 * 1024
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	avgLogSize int
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+
+	newSender func() (sender, error)
+	// senders are connections of workers, they're closed on stop
+	senders   map[sender]struct{}
+	sendersMu *sync.Mutex
+	isStopped atomic.Bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> A fully qualified Event Hubs namespace, e.g. `myns.servicebus.windows.net`.
+	Namespace string `json:"namespace" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A name of the event hub.
+	EventHub string `json:"event_hub" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A name of the shared access policy.
+	KeyName string `json:"key_name" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A key of the shared access policy.
+	Key string `json:"key" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The event field which is used as a partition key. If it's empty or missing, Event Hubs chooses the partition.
+	PartitionKeyField  cfg.FieldSelector `json:"partition_key_field" parse:"selector"` //*
+	PartitionKeyField_ []string
+
+	//> @3@4@5@6
+	//>
+	//> How many workers will be instantiated to send batches, each worker has its own connection.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` //*
+	WorkersCount_ int
+
+	//> @3@4@5@6
+	//>
+	//> Timeout of sending one batch message.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` //*
+	RequestTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` //*
+	BatchSize_ int
+
+	//> @3@4@5@6
+	//>
+	//> After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` //*
+	BatchFlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Retries of the failed message are delayed by this interval.
+	RetryInterval  cfg.Duration `json:"retry_interval" default:"1s" parse:"duration"` //*
+	RetryInterval_ time.Duration
+}
+
+type data struct {
+	outBuf   []byte
+	sender   sender
+	messages []*amqp.Message
+}
+
+// sender sends messages to the event hub, it's replaced by the fake one in tests.
+type sender interface {
+	send(ctx context.Context, msg *amqp.Message) error
+	close()
+}
+
+type amqpSender struct {
+	client  *amqp.Client
+	session *amqp.Session
+	sender  *amqp.Sender
+	timeout time.Duration
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    "azure_eventhub",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgLogSize = params.PipelineSettings.AvgLogSize
+	p.config = config.(*Config)
+	p.newSender = p.connect
+	p.senders = make(map[sender]struct{})
+	p.sendersMu = &sync.Mutex{}
+
+	p.batcher = pipeline.NewBatcher(
+		params.PipelineName,
+		"azure_eventhub",
+		p.out,
+		p.maintenance,
+		p.controller,
+		p.config.WorkersCount_,
+		p.config.BatchSize_,
+		p.config.BatchFlushTimeout_,
+		0,
+	)
+	p.batcher.Start()
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+
+	p.sendersMu.Lock()
+	for s := range p.senders {
+		s.close()
+	}
+	p.senders = make(map[sender]struct{})
+	p.sendersMu.Unlock()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+	if *workerData == nil {
+		*workerData = &data{
+			outBuf: make([]byte, 0, p.avgLogSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	messages, err := p.newBatchMessages(data, batch.Events)
+	if err != nil {
+		p.logger.Errorf("can't encode messages for event hub %s/%s: %s", p.config.Namespace, p.config.EventHub, err.Error())
+		batch.Fail(err)
+		return
+	}
+
+	for _, msg := range messages {
+		for {
+			err := p.send(data, msg)
+			if err == nil {
+				break
+			}
+
+			p.logger.Errorf("can't send message to event hub %s/%s: %s", p.config.Namespace, p.config.EventHub, err.Error())
+			p.disconnect(data)
+			// senders are closed by the stop and expired batches are shed by the batcher, so retrying is useless
+			if p.isStopped.Load() || batch.IsExpired() {
+				batch.Fail(err)
+				return
+			}
+			time.Sleep(p.config.RetryInterval_)
+		}
+	}
+}
+
+// newBatchMessages groups events by the partition key and packs each group into batch messages,
+// so the batch is sent by a few requests instead of a request per event.
+// Groups are sent in the order of their first events, the order of events is kept within the group.
+func (p *Plugin) newBatchMessages(data *data, events []*pipeline.Event) ([]*amqp.Message, error) {
+	keys := make([]string, 0)
+	groups := make(map[string][]*pipeline.Event)
+	for _, event := range events {
+		key := p.partitionKey(event)
+		if _, has := groups[key]; !has {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], event)
+	}
+
+	messages := data.messages[:0]
+	for _, key := range keys {
+		var msg *amqp.Message
+		size := 0
+		for _, event := range groups[key] {
+			data.outBuf, _ = event.Encode(data.outBuf[:0])
+			encoded, err := newMessage(key, data.outBuf).MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+
+			if msg == nil || size+len(encoded) > maxBatchMessageSize {
+				msg = newBatchMessage(key)
+				messages = append(messages, msg)
+				size = 0
+			}
+			msg.Data = append(msg.Data, encoded)
+			size += len(encoded)
+		}
+	}
+	data.messages = messages
+
+	return messages, nil
+}
+
+func (p *Plugin) partitionKey(event *pipeline.Event) string {
+	if len(p.config.PartitionKeyField_) == 0 {
+		return ""
+	}
+
+	return event.Root.Dig(p.config.PartitionKeyField_...).AsString()
+}
+
+func newMessage(key string, body []byte) *amqp.Message {
+	msg := amqp.NewMessage(body)
+	if key != "" {
+		msg.Annotations = amqp.Annotations{partitionKeyAnnotation: key}
+	}
+
+	return msg
+}
+
+// newBatchMessage returns the empty message which carries encoded messages as data sections.
+func newBatchMessage(key string) *amqp.Message {
+	msg := &amqp.Message{Format: batchMessageFormat}
+	if key != "" {
+		msg.Annotations = amqp.Annotations{partitionKeyAnnotation: key}
+	}
+
+	return msg
+}
+
+func (p *Plugin) send(data *data, msg *amqp.Message) error {
+	if data.sender == nil {
+		s, err := p.newSender()
+		if err != nil {
+			return err
+		}
+		data.sender = s
+
+		p.sendersMu.Lock()
+		p.senders[s] = struct{}{}
+		p.sendersMu.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.RequestTimeout_)
+	defer cancel()
+
+	return data.sender.send(ctx, msg)
+}
+
+func (p *Plugin) connect() (sender, error) {
+	client, err := amqp.Dial(
+		"amqps://"+p.config.Namespace,
+		amqp.ConnSASLPlain(p.config.KeyName, p.config.Key),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	s, err := session.NewSender(amqp.LinkTargetAddress(p.config.EventHub))
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &amqpSender{client: client, session: session, sender: s, timeout: p.config.RequestTimeout_}, nil
+}
+
+func (p *Plugin) disconnect(data *data) {
+	if data.sender == nil {
+		return
+	}
+
+	p.sendersMu.Lock()
+	delete(p.senders, data.sender)
+	p.sendersMu.Unlock()
+
+	data.sender.close()
+	data.sender = nil
+}
+
+func (p *Plugin) maintenance(_ *pipeline.WorkerData) {}
+
+func (s *amqpSender) send(ctx context.Context, msg *amqp.Message) error {
+	return s.sender.Send(ctx, msg)
+}
+
+// close detaches the link and ends the session before the connection is closed.
+func (s *amqpSender) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_ = s.sender.Close(ctx)
+	_ = s.session.Close(ctx)
+	_ = s.client.Close()
+}
@@ -0,0 +1,124 @@
+package azure_eventhub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+// fakeSender fails the first `failures` sends.
+type fakeSender struct {
+	failures int
+	messages []*amqp.Message
+	isClosed bool
+}
+
+func (s *fakeSender) send(_ context.Context, msg *amqp.Message) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("link is detached")
+	}
+
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *fakeSender) close() {
+	s.isClosed = true
+}
+
+func newPlugin(partitionKeyField []string, newSender func() (sender, error)) *Plugin {
+	return &Plugin{
+		config: &Config{
+			PartitionKeyField_: partitionKeyField,
+			RequestTimeout_:    time.Second,
+		},
+		logger:    zap.NewNop().Sugar(),
+		newSender: newSender,
+		senders:   make(map[sender]struct{}),
+		sendersMu: &sync.Mutex{},
+	}
+}
+
+func newBatch(events ...string) *pipeline.Batch {
+	batch := &pipeline.Batch{}
+	for _, event := range events {
+		root, _ := insaneJSON.DecodeString(event)
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+	return batch
+}
+
+// unpack returns bodies of messages packed into the batch message.
+func unpack(t *testing.T, msg *amqp.Message) []string {
+	bodies := make([]string, 0)
+	for _, encoded := range msg.Data {
+		inner := &amqp.Message{}
+		require.NoError(t, inner.UnmarshalBinary(encoded))
+		bodies = append(bodies, string(inner.GetData()))
+	}
+
+	return bodies
+}
+
+func TestBatchMessages(t *testing.T) {
+	fake := &fakeSender{}
+	p := newPlugin([]string{"service"}, func() (sender, error) {
+		return fake, nil
+	})
+
+	workerData := pipeline.WorkerData(nil)
+	p.out(&workerData, newBatch(
+		`{"service":"api","message":"1"}`,
+		`{"message":"2"}`,
+		`{"service":"db","message":"3"}`,
+		`{"service":"api","message":"4"}`,
+	))
+
+	require.Equal(t, 3, len(fake.messages), "wrong messages count")
+	for _, msg := range fake.messages {
+		assert.Equal(t, batchMessageFormat, msg.Format, "wrong message format")
+	}
+
+	assert.Equal(t, "api", fake.messages[0].Annotations[partitionKeyAnnotation])
+	assert.Equal(t, []string{`{"service":"api","message":"1"}`, `{"service":"api","message":"4"}`}, unpack(t, fake.messages[0]))
+
+	assert.Nil(t, fake.messages[1].Annotations, "partition key shouldn't be set for the missing field")
+	assert.Equal(t, []string{`{"message":"2"}`}, unpack(t, fake.messages[1]))
+
+	assert.Equal(t, "db", fake.messages[2].Annotations[partitionKeyAnnotation])
+	assert.Equal(t, []string{`{"service":"db","message":"3"}`}, unpack(t, fake.messages[2]))
+}
+
+func TestSendRetry(t *testing.T) {
+	senders := []*fakeSender{{failures: 1}, {}}
+	connects := 0
+	p := newPlugin(nil, func() (sender, error) {
+		s := senders[connects]
+		connects++
+		return s, nil
+	})
+
+	workerData := pipeline.WorkerData(nil)
+	batch := newBatch(`{"message":"1"}`, `{"message":"2"}`)
+	p.out(&workerData, batch)
+
+	assert.Equal(t, 2, connects, "sender isn't reconnected after the failure")
+	assert.True(t, senders[0].isClosed, "failed sender isn't closed")
+	require.Equal(t, 1, len(senders[1].messages), "batch message isn't retried")
+	assert.Equal(t, []string{`{"message":"1"}`, `{"message":"2"}`}, unpack(t, senders[1].messages[0]))
+
+	p.batcher = pipeline.NewBatcher("test", "azure_eventhub", p.out, p.maintenance, nil, 1, 1, time.Second, 0)
+	p.batcher.Start()
+	p.Stop()
+	assert.True(t, senders[1].isClosed, "sender isn't closed on stop")
+}
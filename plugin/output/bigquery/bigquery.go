@@ -0,0 +1,400 @@
+// Package bigquery is an output plugin that sends events to Google BigQuery.
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+/*{ introduction
+It sends events to the BigQuery table using the Storage Write API default stream.
+Event fields are mapped to the table columns by `columns`, other fields are dropped.
+
+The table schema is validated on start: mapped columns should exist and have supported types,
+required columns should be mapped. Supported types are `STRING`, `BYTES`, `INTEGER`, `FLOAT`, `BOOLEAN`,
+`TIMESTAMP` (RFC3339 string or unix seconds), `DATE` (`YYYY-MM-DD`) and `GEOGRAPHY` (WKT string).
+Objects and arrays are written to `STRING` columns as JSON.
+
+Rows which can't be converted or are rejected by BigQuery are written to the `dead_letter_file`.
+Other errors are retried, so the pipeline is blocked while BigQuery isn't available.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: bigquery
+      project: my-project
+      dataset: logs
+      table: events
+      columns:
+        time: ts
+        level: level
+        message: message
+        k8s_pod: pod
+      dead_letter_file: /var/log/file.d/bigquery-dead-letter.log
+    ...
+```
+}*/
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+
+	columns    []*column
+	descriptor protoreflect.MessageDescriptor
+	deadLetter *deadLetter
+	client     *managedwriter.Client
+	newStream  func(ctx context.Context) (appender, error)
+	// streams are write streams of workers, they're closed on stop
+	streams   map[appender]struct{}
+	streamsMu *sync.Mutex
+	isStopped atomic.Bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> Google Cloud project of the table.
+	Project string `json:"project" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> BigQuery dataset of the table.
+	Dataset string `json:"dataset" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> BigQuery table.
+	Table string `json:"table" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A path to the service account credentials file. If it's empty, the default credentials are used.
+	CredentialsFile string `json:"credentials_file"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The mapping of the event fields to the table columns. Keys are the event field selectors, values are the column names.
+	Columns map[string]string `json:"columns" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A file to write rows which are rejected by BigQuery. If it's empty, rejected rows are only logged.
+	DeadLetterFile string `json:"dead_letter_file"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How many workers will be instantiated to send batches, each worker has its own write stream.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs" parse:"expression"` //*
+	WorkersCount_ int
+
+	//> @3@4@5@6
+	//>
+	//> Timeout of the append request.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"30s" parse:"duration"` //*
+	RequestTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` //*
+	BatchSize_ int
+
+	//> @3@4@5@6
+	//>
+	//> After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"1s" parse:"duration"` //*
+	BatchFlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Retries of the failed request are delayed by this interval.
+	RetryInterval  cfg.Duration `json:"retry_interval" default:"1s" parse:"duration"` //*
+	RetryInterval_ time.Duration
+}
+
+type data struct {
+	stream appender
+	outBuf []byte
+	rows   [][]byte
+	events []*pipeline.Event
+}
+
+// appender appends rows to the write stream.
+type appender interface {
+	append(ctx context.Context, rows [][]byte) error
+	close()
+}
+
+type managedAppender struct {
+	stream *managedwriter.ManagedStream
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    "bigquery",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+
+	ctx := context.Background()
+	opts := make([]option.ClientOption, 0)
+	if p.config.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(p.config.CredentialsFile))
+	}
+
+	schema, err := p.fetchSchema(ctx, opts)
+	if err != nil {
+		p.logger.Fatalf("can't get schema of the table %s.%s.%s: %s", p.config.Project, p.config.Dataset, p.config.Table, err.Error())
+	}
+
+	descriptorProto, err := p.setup(schema)
+	if err != nil {
+		p.logger.Fatalf("wrong bigquery config: %s", err.Error())
+	}
+
+	p.deadLetter, err = newDeadLetter(p.config.DeadLetterFile, p.logger)
+	if err != nil {
+		p.logger.Fatalf("can't open dead letter file: %s", err.Error())
+	}
+
+	p.client, err = managedwriter.NewClient(ctx, p.config.Project, opts...)
+	if err != nil {
+		p.logger.Fatalf("can't create bigquery write client: %s", err.Error())
+	}
+	p.streams = make(map[appender]struct{})
+	p.streamsMu = &sync.Mutex{}
+
+	table := fmt.Sprintf("projects/%s/datasets/%s/tables/%s", p.config.Project, p.config.Dataset, p.config.Table)
+	p.newStream = func(ctx context.Context) (appender, error) {
+		stream, err := p.client.NewManagedStream(ctx,
+			managedwriter.WithDestinationTable(table),
+			managedwriter.WithType(managedwriter.DefaultStream),
+			managedwriter.WithSchemaDescriptor(descriptorProto),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return &managedAppender{stream: stream}, nil
+	}
+
+	p.batcher = pipeline.NewBatcher(
+		params.PipelineName,
+		"bigquery",
+		p.out,
+		p.maintenance,
+		p.controller,
+		p.config.WorkersCount_,
+		p.config.BatchSize_,
+		p.config.BatchFlushTimeout_,
+		0,
+	)
+	p.batcher.Start()
+}
+
+func (p *Plugin) fetchSchema(ctx context.Context, opts []option.ClientOption) (bq.Schema, error) {
+	client, err := bq.NewClient(ctx, p.config.Project, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	metadata, err := client.Dataset(p.config.Dataset).Table(p.config.Table).Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.Schema, nil
+}
+
+// setup validates the mapping against the schema and builds the row descriptor.
+func (p *Plugin) setup(schema bq.Schema) (*descriptorpb.DescriptorProto, error) {
+	var err error
+	p.descriptor, err = rowDescriptor(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	p.columns, err = newColumns(p.config.Columns, schema, p.descriptor)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeDescriptor(p.descriptor)
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+
+	p.streamsMu.Lock()
+	for stream := range p.streams {
+		stream.close()
+	}
+	p.streams = make(map[appender]struct{})
+	p.streamsMu.Unlock()
+
+	if err := p.client.Close(); err != nil {
+		p.logger.Errorf("can't close bigquery write client: %s", err.Error())
+	}
+	p.deadLetter.close()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+	if *workerData == nil {
+		*workerData = &data{}
+	}
+
+	data := (*workerData).(*data)
+	data.outBuf = data.outBuf[:0]
+	data.rows = data.rows[:0]
+	data.events = data.events[:0]
+
+	for _, event := range batch.Events {
+		l := len(data.outBuf)
+		outBuf, err := p.encodeRow(data.outBuf, event)
+		if err != nil {
+			p.deadLetter.write(event, err)
+			continue
+		}
+
+		data.outBuf = outBuf
+		data.rows = append(data.rows, data.outBuf[l:])
+		data.events = append(data.events, event)
+	}
+
+	if len(data.rows) == 0 {
+		return
+	}
+
+	// rows reference the buffer, so rebuild them if it was reallocated
+	offset := 0
+	for i, row := range data.rows {
+		data.rows[i] = data.outBuf[offset : offset+len(row)]
+		offset += len(row)
+	}
+
+	if err := p.write(data, data.rows, data.events); err != nil {
+		batch.Fail(err)
+	}
+}
+
+// write appends the rows and retries them until they are accepted or rejected.
+// If the request is rejected, rows are appended one by one to find the rejected ones.
+// The error is returned if rows aren't appended before the stop.
+func (p *Plugin) write(data *data, rows [][]byte, events []*pipeline.Event) error {
+	for {
+		err := p.append(data, rows)
+		if err == nil {
+			return nil
+		}
+
+		if isRejected(err) {
+			if len(rows) == 1 {
+				p.deadLetter.write(events[0], err)
+				return nil
+			}
+
+			for i := range rows {
+				if err := p.write(data, rows[i:i+1], events[i:i+1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		p.logger.Errorf("can't append rows to bigquery table %s.%s: %s", p.config.Dataset, p.config.Table, err.Error())
+		if data.stream != nil {
+			p.closeStream(data.stream)
+			data.stream = nil
+		}
+		// streams are closed by the stop, so rows can't be appended anymore
+		if p.isStopped.Load() {
+			return err
+		}
+		time.Sleep(p.config.RetryInterval_)
+	}
+}
+
+func (p *Plugin) append(data *data, rows [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.RequestTimeout_)
+	defer cancel()
+
+	if data.stream == nil {
+		stream, err := p.newStream(ctx)
+		if err != nil {
+			return err
+		}
+		data.stream = stream
+
+		p.streamsMu.Lock()
+		p.streams[stream] = struct{}{}
+		p.streamsMu.Unlock()
+	}
+
+	return data.stream.append(ctx, rows)
+}
+
+func (p *Plugin) closeStream(stream appender) {
+	p.streamsMu.Lock()
+	delete(p.streams, stream)
+	p.streamsMu.Unlock()
+
+	stream.close()
+}
+
+func (p *Plugin) maintenance(_ *pipeline.WorkerData) {}
+
+func isRejected(err error) bool {
+	return status.Code(err) == codes.InvalidArgument
+}
+
+func (a *managedAppender) append(ctx context.Context, rows [][]byte) error {
+	result, err := a.stream.AppendRows(ctx, rows)
+	if err != nil {
+		return err
+	}
+
+	_, err = result.GetResult(ctx)
+
+	return err
+}
+
+func (a *managedAppender) close() {
+	_ = a.stream.Close()
+}
@@ -0,0 +1,171 @@
+package bigquery
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var testSchema = bq.Schema{
+	{Name: "ts", Type: bq.TimestampFieldType, Required: true},
+	{Name: "Level", Type: bq.StringFieldType},
+	{Name: "count", Type: bq.IntegerFieldType},
+	{Name: "ratio", Type: bq.FloatFieldType},
+	{Name: "ok", Type: bq.BooleanFieldType},
+	{Name: "day", Type: bq.DateFieldType},
+	{Name: "extra", Type: bq.StringFieldType},
+}
+
+type fakeAppender struct {
+	rows [][]byte
+	// rows with this substring are rejected
+	reject string
+}
+
+func (a *fakeAppender) append(_ context.Context, rows [][]byte) error {
+	for _, row := range rows {
+		if strings.Contains(string(row), a.reject) {
+			return status.Error(codes.InvalidArgument, "row is rejected")
+		}
+	}
+
+	for _, row := range rows {
+		a.rows = append(a.rows, append([]byte(nil), row...))
+	}
+
+	return nil
+}
+
+func (a *fakeAppender) close() {}
+
+func newPlugin(t *testing.T, columns map[string]string) *Plugin {
+	p := &Plugin{
+		config:    &Config{Columns: columns},
+		logger:    zap.NewNop().Sugar(),
+		streams:   make(map[appender]struct{}),
+		streamsMu: &sync.Mutex{},
+	}
+
+	_, err := p.setup(testSchema)
+	require.NoError(t, err)
+
+	return p
+}
+
+func newBatch(events ...string) *pipeline.Batch {
+	batch := &pipeline.Batch{}
+	for _, event := range events {
+		root, _ := insaneJSON.DecodeString(event)
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+	return batch
+}
+
+func TestColumnsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns map[string]string
+		err     string
+	}{
+		{name: "ok", columns: map[string]string{"time": "ts", "level": "level"}},
+		{name: "missing", columns: map[string]string{"time": "ts", "a": "unknown"}, err: "doesn't exist"},
+		{name: "twice", columns: map[string]string{"time": "ts", "a": "level", "b": "Level"}, err: "more than once"},
+		{name: "required", columns: map[string]string{"level": "level"}, err: "required column"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{config: &Config{Columns: tt.columns}}
+			_, err := p.setup(testSchema)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestEncodeRow(t *testing.T) {
+	p := newPlugin(t, map[string]string{
+		"time":    "ts",
+		"level":   "level",
+		"count":   "count",
+		"ratio":   "ratio",
+		"ok":      "ok",
+		"day":     "day",
+		"k8s.pod": "extra",
+	})
+
+	event := newBatch(`{"time":"2021-06-22T16:24:27.5Z","level":"error","count":"42","ratio":0.5,"ok":true,"day":"1970-01-03","k8s":{"pod":{"a":1}},"other":1}`).Events[0]
+	out, err := p.encodeRow(nil, event)
+	require.NoError(t, err)
+
+	row := dynamicpb.NewMessage(p.descriptor)
+	require.NoError(t, proto.Unmarshal(out, row))
+
+	fields := p.descriptor.Fields()
+	assert.Equal(t, int64(1624379067500000), row.Get(fields.ByName("ts")).Int())
+	assert.Equal(t, "error", row.Get(fields.ByName("level")).String())
+	assert.Equal(t, int64(42), row.Get(fields.ByName("count")).Int())
+	assert.Equal(t, 0.5, row.Get(fields.ByName("ratio")).Float())
+	assert.Equal(t, true, row.Get(fields.ByName("ok")).Bool())
+	assert.Equal(t, int64(2), row.Get(fields.ByName("day")).Int())
+	assert.Equal(t, `{"a":1}`, row.Get(fields.ByName("extra")).String())
+
+	_, err = p.encodeRow(nil, newBatch(`{"time":"yesterday"}`).Events[0])
+	assert.Error(t, err, "wrong timestamp should be rejected")
+
+	_, err = p.encodeRow(nil, newBatch(`{"level":"info"}`).Events[0])
+	assert.Error(t, err, "missing required column should be rejected")
+}
+
+func TestDeadLetter(t *testing.T) {
+	p := newPlugin(t, map[string]string{"time": "ts", "level": "level"})
+	p.config.RetryInterval_ = 0
+	p.config.RequestTimeout_ = time.Second
+
+	fileName := filepath.Join(t.TempDir(), "dead-letter.log")
+	var err error
+	p.deadLetter, err = newDeadLetter(fileName, p.logger)
+	require.NoError(t, err)
+
+	fake := &fakeAppender{reject: "bad"}
+	p.newStream = func(_ context.Context) (appender, error) {
+		return fake, nil
+	}
+
+	workerData := pipeline.WorkerData(nil)
+	p.out(&workerData, newBatch(
+		`{"time":1,"level":"info"}`,
+		`{"time":2,"level":"bad"}`,
+		`{"time":"wrong","level":"info"}`,
+		`{"time":3,"level":"warn"}`,
+	))
+
+	assert.Equal(t, 2, len(fake.rows), "wrong accepted rows count")
+
+	content, err := ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Equal(t, 2, len(lines), "wrong dead letter rows count")
+	assert.Contains(t, lines[0], `"event":{"time":"wrong","level":"info"}`)
+	assert.Contains(t, lines[1], `"error":"rpc error: code = InvalidArgument desc = row is rejected"`)
+	assert.Contains(t, lines[1], `"event":{"time":2,"level":"bad"}`)
+}
@@ -0,0 +1,74 @@
+package bigquery
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/zap"
+)
+
+// deadLetter writes rejected events to the file as JSON lines: `{"error":"...","event":{...}}`.
+type deadLetter struct {
+	mu     *sync.Mutex
+	file   *os.File
+	logger *zap.SugaredLogger
+	outBuf []byte
+}
+
+func newDeadLetter(fileName string, logger *zap.SugaredLogger) (*deadLetter, error) {
+	d := &deadLetter{
+		mu:     &sync.Mutex{},
+		logger: logger,
+	}
+
+	if fileName == "" {
+		return d, nil
+	}
+
+	var err error
+	d.file, err = os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *deadLetter) write(event *pipeline.Event, reason error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := d.outBuf[:0]
+	out = append(out, `{"error":`...)
+	reasonJSON, _ := json.Marshal(reason.Error())
+	out = append(out, reasonJSON...)
+	out = append(out, `,"event":`...)
+	out, _ = event.Encode(out)
+	out = append(out, "}\n"...)
+	d.outBuf = out
+
+	if d.file == nil {
+		d.logger.Errorf("bigquery row is rejected: %s", out[:len(out)-1])
+		return
+	}
+
+	if _, err := d.file.Write(out); err != nil {
+		d.logger.Errorf("can't write to dead letter file %s: %s, row: %s", d.file.Name(), err.Error(), out[:len(out)-1])
+	}
+}
+
+func (d *deadLetter) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file == nil {
+		return
+	}
+
+	if err := d.file.Close(); err != nil {
+		d.logger.Errorf("can't close dead letter file %s: %s", d.file.Name(), err.Error())
+	}
+	d.file = nil
+}
@@ -0,0 +1,229 @@
+package bigquery
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const dateLayout = "2006-01-02"
+
+// column maps the event field to the field of the row message.
+type column struct {
+	selector  []string
+	fieldType bq.FieldType
+	field     protoreflect.FieldDescriptor
+}
+
+func rowDescriptor(schema bq.Schema) (protoreflect.MessageDescriptor, error) {
+	storageSchema, err := adapt.BQSchemaToStorageTableSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("can't convert table schema: %w", err)
+	}
+
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(storageSchema, "root")
+	if err != nil {
+		return nil, fmt.Errorf("can't build row descriptor: %w", err)
+	}
+
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("row descriptor isn't a message descriptor")
+	}
+
+	return messageDescriptor, nil
+}
+
+func normalizeDescriptor(descriptor protoreflect.MessageDescriptor) (*descriptorpb.DescriptorProto, error) {
+	descriptorProto, err := adapt.NormalizeDescriptor(descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("can't normalize row descriptor: %w", err)
+	}
+
+	return descriptorProto, nil
+}
+
+// newColumns validates the mapping against the table schema.
+func newColumns(mapping map[string]string, schema bq.Schema, descriptor protoreflect.MessageDescriptor) ([]*column, error) {
+	if len(mapping) == 0 {
+		return nil, fmt.Errorf("columns mapping is empty")
+	}
+
+	fields := make(map[string]*bq.FieldSchema, len(schema))
+	for _, field := range schema {
+		fields[strings.ToLower(field.Name)] = field
+	}
+
+	// sort selectors to get the same order of columns and errors on each start
+	selectors := make([]string, 0, len(mapping))
+	for selector := range mapping {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+
+	mapped := make(map[string]bool, len(mapping))
+	columns := make([]*column, 0, len(mapping))
+	for _, selector := range selectors {
+		name := strings.ToLower(mapping[selector])
+		field, has := fields[name]
+		if !has {
+			return nil, fmt.Errorf("column %q of field %q doesn't exist in the table", mapping[selector], selector)
+		}
+		if mapped[name] {
+			return nil, fmt.Errorf("column %q is mapped more than once", mapping[selector])
+		}
+		if field.Repeated {
+			return nil, fmt.Errorf("column %q is repeated, it isn't supported", field.Name)
+		}
+		if !isSupported(field.Type) {
+			return nil, fmt.Errorf("column %q has unsupported type %s", field.Name, field.Type)
+		}
+
+		mapped[name] = true
+		columns = append(columns, &column{
+			selector:  cfg.ParseFieldSelector(selector),
+			fieldType: field.Type,
+			field:     descriptor.Fields().ByName(protoreflect.Name(name)),
+		})
+	}
+
+	for _, field := range schema {
+		if field.Required && !mapped[strings.ToLower(field.Name)] {
+			return nil, fmt.Errorf("required column %q isn't mapped", field.Name)
+		}
+	}
+
+	return columns, nil
+}
+
+func isSupported(fieldType bq.FieldType) bool {
+	switch fieldType {
+	case bq.StringFieldType, bq.BytesFieldType, bq.IntegerFieldType, bq.FloatFieldType, bq.BooleanFieldType,
+		bq.TimestampFieldType, bq.DateFieldType, bq.GeographyFieldType:
+		return true
+	default:
+		return false
+	}
+}
+
+// encodeRow appends the serialized row message of the event to the out.
+func (p *Plugin) encodeRow(out []byte, event *pipeline.Event) ([]byte, error) {
+	row := dynamicpb.NewMessage(p.descriptor)
+	for _, c := range p.columns {
+		node := event.Root.Dig(c.selector...)
+		if node == nil || node.IsNull() {
+			continue
+		}
+
+		value, err := convertValue(node, c.fieldType)
+		if err != nil {
+			return out, fmt.Errorf("can't convert field %q: %w", strings.Join(c.selector, "."), err)
+		}
+		row.Set(c.field, value)
+	}
+
+	return proto.MarshalOptions{}.MarshalAppend(out, row)
+}
+
+func convertValue(node *insaneJSON.Node, fieldType bq.FieldType) (protoreflect.Value, error) {
+	switch fieldType {
+	case bq.StringFieldType, bq.GeographyFieldType:
+		if node.IsObject() || node.IsArray() {
+			return protoreflect.ValueOfString(node.EncodeToString()), nil
+		}
+		return protoreflect.ValueOfString(node.AsString()), nil
+	case bq.BytesFieldType:
+		return protoreflect.ValueOfBytes([]byte(node.AsString())), nil
+	case bq.IntegerFieldType:
+		value, err := parseInt(node)
+		return protoreflect.ValueOfInt64(value), err
+	case bq.FloatFieldType:
+		if !node.IsNumber() && !node.IsString() {
+			return protoreflect.Value{}, fmt.Errorf("value isn't a number")
+		}
+		value, err := strconv.ParseFloat(node.AsString(), 64)
+		return protoreflect.ValueOfFloat64(value), err
+	case bq.BooleanFieldType:
+		value, err := parseBool(node)
+		return protoreflect.ValueOfBool(value), err
+	case bq.TimestampFieldType:
+		value, err := parseTimestamp(node)
+		return protoreflect.ValueOfInt64(value), err
+	case bq.DateFieldType:
+		value, err := time.Parse(dateLayout, node.AsString())
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfInt32(int32(value.Unix() / int64(24*time.Hour/time.Second))), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported type %s", fieldType)
+	}
+}
+
+func parseInt(node *insaneJSON.Node) (int64, error) {
+	if !node.IsNumber() && !node.IsString() {
+		return 0, fmt.Errorf("value isn't a number")
+	}
+
+	s := node.AsString()
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return value, nil
+	}
+
+	// integer may be written in the exponential notation
+	f, ferr := strconv.ParseFloat(s, 64)
+	if ferr != nil || f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+		return 0, err
+	}
+
+	return int64(f), nil
+}
+
+func parseBool(node *insaneJSON.Node) (bool, error) {
+	switch {
+	case node.IsTrue():
+		return true, nil
+	case node.IsFalse():
+		return false, nil
+	case node.IsString():
+		return strconv.ParseBool(node.AsString())
+	default:
+		return false, fmt.Errorf("value isn't a bool")
+	}
+}
+
+// parseTimestamp returns microseconds since the epoch which BigQuery expects for timestamps.
+func parseTimestamp(node *insaneJSON.Node) (int64, error) {
+	if node.IsNumber() {
+		seconds, err := strconv.ParseFloat(node.AsString(), 64)
+		if err != nil {
+			return 0, err
+		}
+		return int64(math.Round(seconds * 1e6)), nil
+	}
+
+	if !node.IsString() {
+		return 0, fmt.Errorf("value isn't a timestamp")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, node.AsString())
+	if err != nil {
+		return 0, err
+	}
+
+	return t.UnixNano() / int64(time.Microsecond), nil
+}
@@ -0,0 +1,306 @@
+// Package cloudwatch is an output plugin that sends events to AWS CloudWatch Logs.
+package cloudwatch
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It sends events to AWS CloudWatch Logs using `PutLogEvents`.
+Log group and log stream names are built from the event fields, see `log_group` and `log_stream`.
+Missing log groups and log streams are created automatically.
+
+Each request fits into the CloudWatch limits: 1MB and 10000 events, bigger events are truncated to 256KB.
+Events get the time when the batch is sent as a timestamp, so they are always in the chronological order.
+The batch is retried until it's accepted, so the pipeline is blocked while CloudWatch isn't available.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: cloudwatch
+      region: eu-west-1
+      log_group: /app/%
+      log_group_values: [service]
+      log_stream: "%"
+      log_stream_values: [host]
+    ...
+```
+}*/
+
+const (
+	// limits of PutLogEvents, see https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html
+	maxRequestSize   = 1024 * 1024
+	maxRequestEvents = 10000
+	maxEventSize     = 256 * 1024
+	eventOverhead    = 26
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+	client     cloudwatchlogsiface.CloudWatchLogsAPI
+	streams    *streams
+	isStopped  atomic.Bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> AWS region of CloudWatch Logs.
+	Region string `json:"region" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A custom endpoint of CloudWatch Logs, e.g. to use localstack.
+	Endpoint string `json:"endpoint"` //*
+
+	//> @3@4@5@6
+	//>
+	//> AWS access key id. If it's empty, the default credentials chain is used.
+	AccessKeyID string `json:"access_key_id"` //*
+
+	//> @3@4@5@6
+	//>
+	//> AWS secret access key.
+	SecretAccessKey string `json:"secret_access_key"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The pattern of the log group name. Use `%` character as a placeholder. Use `log_group_values` to define values for the replacement.
+	//> E.g. if `log_group="/app/%"` and `log_group_values="service"` and event is `{"service"="my-service"}`
+	//> then the log group for that event will be `/app/my-service`. If the field is missing, `not_set` is used.
+	LogGroup string `json:"log_group" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A comma-separated list of event fields which will be used for replacement `log_group`.
+	LogGroupValues []string `json:"log_group_values" slice:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The pattern of the log stream name, it works the same way as `log_group`.
+	LogStream string `json:"log_stream" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A comma-separated list of event fields which will be used for replacement `log_stream`.
+	LogStreamValues []string `json:"log_stream_values" slice:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> If set, missing log groups are created. Log streams are always created.
+	CreateLogGroup bool `json:"create_log_group" default:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` //*
+	WorkersCount_ int
+
+	//> @3@4@5@6
+	//>
+	//> A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` //*
+	BatchSize_ int
+
+	//> @3@4@5@6
+	//>
+	//> After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` //*
+	BatchFlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Retries of the failed request are delayed by this interval.
+	RetryInterval  cfg.Duration `json:"retry_interval" default:"1s" parse:"duration"` //*
+	RetryInterval_ time.Duration
+}
+
+type data struct {
+	outBuf  []byte
+	keys    []streamKey
+	events  map[streamKey][]*cloudwatchlogs.InputLogEvent
+	payload []*cloudwatchlogs.InputLogEvent
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    "cloudwatch",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.streams = newStreams()
+
+	if strings.Count(p.config.LogGroup, "%") != len(p.config.LogGroupValues) {
+		p.logger.Fatalf("count of placeholders and values isn't match, check log_group/log_group_values config params")
+	}
+	if strings.Count(p.config.LogStream, "%") != len(p.config.LogStreamValues) {
+		p.logger.Fatalf("count of placeholders and values isn't match, check log_stream/log_stream_values config params")
+	}
+
+	if p.client == nil {
+		p.client = p.newClient()
+	}
+
+	p.batcher = pipeline.NewBatcher(
+		params.PipelineName,
+		"cloudwatch",
+		p.out,
+		p.maintenance,
+		p.controller,
+		p.config.WorkersCount_,
+		p.config.BatchSize_,
+		p.config.BatchFlushTimeout_,
+		0,
+	)
+	p.batcher.Start()
+}
+
+func (p *Plugin) newClient() cloudwatchlogsiface.CloudWatchLogsAPI {
+	awsConfig := aws.NewConfig().WithRegion(p.config.Region)
+	if p.config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(p.config.Endpoint)
+	}
+	if p.config.AccessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(p.config.AccessKeyID, p.config.SecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		p.logger.Fatalf("can't create aws session: %s", err.Error())
+	}
+
+	return cloudwatchlogs.New(sess)
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+	if *workerData == nil {
+		*workerData = &data{
+			outBuf: make([]byte, 0),
+			events: make(map[streamKey][]*cloudwatchlogs.InputLogEvent),
+		}
+	}
+
+	data := (*workerData).(*data)
+	data.keys = data.keys[:0]
+	for key := range data.events {
+		delete(data.events, key)
+	}
+
+	timestamp := aws.Int64(time.Now().UnixNano() / int64(time.Millisecond))
+	for _, event := range batch.Events {
+		data.outBuf = appendName(data.outBuf[:0], p.config.LogGroup, p.config.LogGroupValues, event)
+		group := string(data.outBuf)
+		data.outBuf = appendName(data.outBuf[:0], p.config.LogStream, p.config.LogStreamValues, event)
+		key := streamKey{group: group, stream: string(data.outBuf)}
+
+		data.outBuf = event.Root.Encode(data.outBuf[:0])
+		data.outBuf = truncate(data.outBuf, maxEventSize-eventOverhead)
+
+		if _, has := data.events[key]; !has {
+			data.keys = append(data.keys, key)
+		}
+		data.events[key] = append(data.events[key], &cloudwatchlogs.InputLogEvent{
+			Message:   aws.String(string(data.outBuf)),
+			Timestamp: timestamp,
+		})
+	}
+
+	for _, key := range data.keys {
+		events := data.events[key]
+		for len(events) > 0 {
+			data.payload, events = splitRequest(data.payload[:0], events)
+			if err := p.put(key, data.payload); err != nil {
+				// the batch is given up on the stop, so it isn't committed in the at least once delivery mode
+				batch.Fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (p *Plugin) maintenance(_ *pipeline.WorkerData) {}
+
+// splitRequest moves the events fitting into the request limits into the payload and returns the rest of the events.
+func splitRequest(payload, events []*cloudwatchlogs.InputLogEvent) ([]*cloudwatchlogs.InputLogEvent, []*cloudwatchlogs.InputLogEvent) {
+	size := 0
+	for i, event := range events {
+		size += len(*event.Message) + eventOverhead
+		if len(payload) == maxRequestEvents || (size > maxRequestSize && len(payload) > 0) {
+			return payload, events[i:]
+		}
+		payload = append(payload, event)
+	}
+
+	return payload, nil
+}
+
+// truncate cuts the message to the size without breaking UTF-8 characters.
+func truncate(message []byte, size int) []byte {
+	if len(message) <= size {
+		return message
+	}
+
+	for size > 0 && !utf8.RuneStart(message[size]) {
+		size--
+	}
+
+	return message[:size]
+}
+
+func appendName(out []byte, pattern string, values []string, event *pipeline.Event) []byte {
+	replacements := 0
+	for _, c := range pipeline.StringToByteUnsafe(pattern) {
+		if c != '%' {
+			out = append(out, c)
+			continue
+		}
+
+		value := event.Root.Dig(values[replacements]).AsString()
+		replacements++
+		if value == "" {
+			value = "not_set"
+		}
+		out = append(out, value...)
+	}
+
+	return out
+}
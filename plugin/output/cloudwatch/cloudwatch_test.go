@@ -0,0 +1,166 @@
+package cloudwatch
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+type fakeClient struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+
+	mu      *sync.Mutex
+	groups  map[string]bool
+	streams map[streamKey][]string
+	tokens  map[streamKey]string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		mu:      &sync.Mutex{},
+		groups:  make(map[string]bool),
+		streams: make(map[streamKey][]string),
+		tokens:  make(map[streamKey]string),
+	}
+}
+
+func (c *fakeClient) CreateLogGroup(in *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.groups[*in.LogGroupName] {
+		return nil, &cloudwatchlogs.ResourceAlreadyExistsException{}
+	}
+	c.groups[*in.LogGroupName] = true
+
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (c *fakeClient) CreateLogStream(in *cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.groups[*in.LogGroupName] {
+		return nil, &cloudwatchlogs.ResourceNotFoundException{}
+	}
+	key := streamKey{group: *in.LogGroupName, stream: *in.LogStreamName}
+	if _, has := c.streams[key]; has {
+		return nil, &cloudwatchlogs.ResourceAlreadyExistsException{}
+	}
+	c.streams[key] = []string{}
+
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (c *fakeClient) PutLogEvents(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := streamKey{group: *in.LogGroupName, stream: *in.LogStreamName}
+	if _, has := c.streams[key]; !has {
+		return nil, &cloudwatchlogs.ResourceNotFoundException{}
+	}
+
+	token := c.tokens[key]
+	if token != "" && aws.StringValue(in.SequenceToken) != token {
+		return nil, &cloudwatchlogs.InvalidSequenceTokenException{ExpectedSequenceToken: aws.String(token)}
+	}
+
+	for _, event := range in.LogEvents {
+		c.streams[key] = append(c.streams[key], *event.Message)
+	}
+	c.tokens[key] = token + "1"
+
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String(c.tokens[key])}, nil
+}
+
+func newPlugin(client *fakeClient) *Plugin {
+	return &Plugin{
+		config: &Config{
+			LogGroup:        "/app/%",
+			LogGroupValues:  []string{"service"},
+			LogStream:       "%",
+			LogStreamValues: []string{"host"},
+			CreateLogGroup:  true,
+		},
+		logger:  zap.NewNop().Sugar(),
+		client:  client,
+		streams: newStreams(),
+	}
+}
+
+func newBatch(events ...string) *pipeline.Batch {
+	batch := &pipeline.Batch{}
+	for _, event := range events {
+		root, _ := insaneJSON.DecodeString(event)
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+	return batch
+}
+
+func TestOut(t *testing.T) {
+	client := newFakeClient()
+	p := newPlugin(client)
+
+	workerData := pipeline.WorkerData(nil)
+	p.out(&workerData, newBatch(
+		`{"service":"api","host":"node1","message":"1"}`,
+		`{"service":"api","host":"node2","message":"2"}`,
+		`{"host":"node1","message":"3"}`,
+		`{"service":"api","host":"node1","message":"4"}`,
+	))
+
+	assert.Equal(t, []string{
+		`{"service":"api","host":"node1","message":"1"}`,
+		`{"service":"api","host":"node1","message":"4"}`,
+	}, client.streams[streamKey{group: "/app/api", stream: "node1"}])
+	assert.Equal(t, 1, len(client.streams[streamKey{group: "/app/api", stream: "node2"}]))
+	assert.Equal(t, 1, len(client.streams[streamKey{group: "/app/not_set", stream: "node1"}]))
+
+	// token is expected to be known after the first request
+	p.out(&workerData, newBatch(`{"service":"api","host":"node1","message":"5"}`))
+	assert.Equal(t, 3, len(client.streams[streamKey{group: "/app/api", stream: "node1"}]))
+
+	// token is unknown after restart
+	p = newPlugin(client)
+	workerData = nil
+	p.out(&workerData, newBatch(`{"service":"api","host":"node1","message":"6"}`))
+	assert.Equal(t, 4, len(client.streams[streamKey{group: "/app/api", stream: "node1"}]))
+}
+
+func TestSplitRequest(t *testing.T) {
+	message := strings.Repeat("a", maxRequestSize/4)
+	events := make([]*cloudwatchlogs.InputLogEvent, 0)
+	for i := 0; i < 5; i++ {
+		events = append(events, &cloudwatchlogs.InputLogEvent{Message: aws.String(message)})
+	}
+
+	payload, rest := splitRequest(nil, events)
+	require.Equal(t, 3, len(payload), "wrong payload size")
+	payload, rest = splitRequest(payload[:0], rest)
+	require.Equal(t, 2, len(payload), "wrong payload size")
+	assert.Equal(t, 0, len(rest), "wrong rest")
+
+	events = events[:0]
+	for i := 0; i < maxRequestEvents+1; i++ {
+		events = append(events, &cloudwatchlogs.InputLogEvent{Message: aws.String("a")})
+	}
+	payload, rest = splitRequest(nil, events)
+	assert.Equal(t, maxRequestEvents, len(payload), "wrong payload size")
+	assert.Equal(t, 1, len(rest), "wrong rest")
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", string(truncate([]byte("abc"), 5)))
+	assert.Equal(t, "ab", string(truncate([]byte("abc"), 2)))
+	assert.Equal(t, "a", string(truncate([]byte("aжb"), 2)))
+}
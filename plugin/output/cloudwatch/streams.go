@@ -0,0 +1,114 @@
+package cloudwatch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+type streamKey struct {
+	group  string
+	stream string
+}
+
+// logStream keeps the sequence token of the log stream.
+// Requests to the same log stream are serialized since each request needs the token of the previous one.
+type logStream struct {
+	mu    *sync.Mutex
+	token *string
+}
+
+type streams struct {
+	mu      *sync.Mutex
+	streams map[streamKey]*logStream
+}
+
+func newStreams() *streams {
+	return &streams{
+		mu:      &sync.Mutex{},
+		streams: make(map[streamKey]*logStream),
+	}
+}
+
+func (s *streams) get(key streamKey) *logStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, has := s.streams[key]
+	if !has {
+		stream = &logStream{mu: &sync.Mutex{}}
+		s.streams[key] = stream
+	}
+
+	return stream
+}
+
+// put sends the events to the log stream and retries until they are accepted,
+// the last error is returned if the output is stopped.
+func (p *Plugin) put(key streamKey, events []*cloudwatchlogs.InputLogEvent) error {
+	stream := p.streams.get(key)
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	for {
+		out, err := p.client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(key.group),
+			LogStreamName: aws.String(key.stream),
+			LogEvents:     events,
+			SequenceToken: stream.token,
+		})
+
+		switch e := err.(type) {
+		case nil:
+			stream.token = out.NextSequenceToken
+			if info := out.RejectedLogEventsInfo; info != nil {
+				p.logger.Errorf("some events are rejected by cloudwatch log_group=%s, log_stream=%s: %s", key.group, key.stream, info.String())
+			}
+			return nil
+		case *cloudwatchlogs.DataAlreadyAcceptedException:
+			// previous request has succeeded, but the response was lost
+			stream.token = e.ExpectedSequenceToken
+			return nil
+		case *cloudwatchlogs.InvalidSequenceTokenException:
+			// stream is written by someone else or the token is unknown after restart
+			stream.token = e.ExpectedSequenceToken
+			continue
+		case *cloudwatchlogs.ResourceNotFoundException:
+			err = p.create(key)
+			if err == nil {
+				stream.token = nil
+				continue
+			}
+		}
+
+		p.logger.Errorf("can't put events to cloudwatch log_group=%s, log_stream=%s: %s", key.group, key.stream, err.Error())
+		if p.isStopped.Load() {
+			return err
+		}
+		time.Sleep(p.config.RetryInterval_)
+	}
+}
+
+// create creates the log group and the log stream if they don't exist.
+func (p *Plugin) create(key streamKey) error {
+	if p.config.CreateLogGroup {
+		_, err := p.client.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
+			LogGroupName: aws.String(key.group),
+		})
+		if _, exists := err.(*cloudwatchlogs.ResourceAlreadyExistsException); err != nil && !exists {
+			return err
+		}
+	}
+
+	_, err := p.client.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(key.group),
+		LogStreamName: aws.String(key.stream),
+	})
+	if _, exists := err.(*cloudwatchlogs.ResourceAlreadyExistsException); err != nil && !exists {
+		return err
+	}
+
+	return nil
+}
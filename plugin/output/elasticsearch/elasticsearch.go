@@ -2,34 +2,71 @@ package elasticsearch
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/compression"
 	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 
 	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/signing"
 )
 
 /*{ introduction
 It sends events into Elasticsearch. It uses `_bulk` API to send events in batches.
 If a network error occurs, the batch will infinitely try to be delivered to the random endpoint.
+Events rejected with `429` or `5xx` item statuses are retried as well, events rejected with other statuses are logged and dropped.
+
+OpenSearch is supported as well, since only the plain `_bulk` API is used and the response doesn't have to contain the product header.
+To send events to the AWS managed OpenSearch, set `aws_region` so requests are signed with AWS Signature Version 4.
+Use `aws_service: aoss` for OpenSearch Serverless collections.
+The `signing` option may be used instead, it also supports HMAC signing for proxies in front of the cluster.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: elasticsearch
+      endpoints: [https://search-logs-abcdef.eu-west-1.es.amazonaws.com]
+      aws_region: eu-west-1
+    ...
+```
 }*/
 
+// errStopped fails the batch which isn't sent before the stop.
+var errStopped = errors.New("output is stopped")
+
+// metaIndexValuePrefix marks index values taken from the event metadata.
+const metaIndexValuePrefix = "@meta."
+
 type Plugin struct {
 	logger     *zap.SugaredLogger
 	client     *http.Client
+	dialer     *netutil.Dialer
 	config     *Config
 	avgLogSize int
 	time       string
 	batcher    *pipeline.Batcher
 	controller pipeline.OutputPluginController
 	mu         *sync.Mutex
+	signer     signing.Signer
+	headers    *pipeline.HeaderTemplates
+	// compression is nil if bodies aren't compressed
+	compression *compression.Negotiator
+	isStopped   atomic.Bool
 }
 
 //! config-params
@@ -52,6 +89,7 @@ type Config struct {
 	//>
 	//> A comma-separated list of event fields which will be used for replacement `index_format`.
 	//> There is a special field `@@time` which equals the current time. Use the `time_format` to define a time format.
+	//> Values like `@@meta.<key>` are taken from the event metadata instead of the event fields.
 	//> E.g. `[service, @@time]`
 	IndexValues []string `json:"index_values" default:"[@time]" slice:"true"` //*
 
@@ -67,6 +105,20 @@ type Config struct {
 	ConnectionTimeout  cfg.Duration `json:"connection_timeout" default:"5s" parse:"duration"`  //*
 	ConnectionTimeout_ time.Duration
 
+	//> @3@4@5@6
+	//>
+	//> A network interface to connect from, e.g. `eth1`. Connections are made from its address of the family of the endpoint address.
+	//> IPv6 and IPv4 addresses of the endpoint host are tried in parallel. If it's empty, the interface is chosen by the routing table.
+	SourceInterface string `json:"source_interface"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Compression of request bodies: `none`, `gzip`, `zstd` or `auto`. In the `auto` mode zstd is preferred,
+	//> if the server responds with `415`, or with `400` mentioning the encoding, the output falls back to gzip and then to no compression.
+	//> The preferred codec is tried again in an hour. The negotiated codec and sizes of bodies before and after compression
+	//> are exposed as `file_d_output_compression_codec`, `file_d_output_compression_raw_bytes_total` and `file_d_output_compression_compressed_bytes_total` metrics.
+	Compression string `json:"compression" default:"none" options:"none|gzip|zstd|auto"` //*
+
 	//> @3@4@5@6
 	//>
 	//> It defines how many workers will be instantiated to send batches.
@@ -84,6 +136,41 @@ type Config struct {
 	//> After this timeout batch will be sent even if batch isn't full.
 	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"`  //*
 	BatchFlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> AWS region of the managed OpenSearch domain. If it's set, requests are signed with AWS Signature Version 4.
+	AWSRegion string `json:"aws_region"` //*
+
+	//> @3@4@5@6
+	//>
+	//> AWS service name used for signing: `es` for OpenSearch domains, `aoss` for OpenSearch Serverless.
+	AWSService string `json:"aws_service" default:"es" options:"es|aoss"` //*
+
+	//> @3@4@5@6
+	//>
+	//> AWS access key id. If it's empty, the default credentials chain is used.
+	AWSAccessKeyID string `json:"aws_access_key_id"` //*
+
+	//> @3@4@5@6
+	//>
+	//> AWS secret access key.
+	AWSSecretAccessKey string `json:"aws_secret_access_key"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Additional request headers. Values may contain event fields, e.g. `X-Scope-OrgID: "{{.tenant}}"`,
+	//> in this case the batch is split into requests by the header values. Headers with empty values aren't sent.
+	Headers map[string]string `json:"headers"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Signing of requests, e.g. `{type: hmac, hmac_key: secret}`. The object has the following fields:
+	//> * `type` – `aws_sigv4` or `hmac`
+	//> * `aws_region`, `aws_service` (`es` by default), `aws_access_key_id`, `aws_secret_access_key` – for `aws_sigv4`, the default credentials chain is used if the key id is empty
+	//> * `hmac_key`, `hmac_header` (`X-Signature` by default), `hmac_timestamp_header` (`X-Timestamp` by default), `hmac_algorithm` (`sha256` or `sha512`) – for `hmac`,
+	//> the hex encoded signature of `<unix_timestamp>\n<method>\n<path_with_query>\n<body>` is set to the header
+	Signing *signing.Config `json:"signing"` //*
 }
 
 type data struct {
@@ -119,8 +206,45 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		p.config.Endpoints[i] = endpoint + "/_bulk?_source=false"
 	}
 
+	dialer, err := netutil.NewDialer(p.config.ConnectionTimeout_, p.config.SourceInterface)
+	if err != nil {
+		p.logger.Fatalf("can't create dialer: %s", err.Error())
+	}
+	dialer.SetChaos(params.Chaos)
+	p.dialer = dialer
 	p.client = &http.Client{
-		Timeout: p.config.ConnectionTimeout_,
+		Timeout:   p.config.ConnectionTimeout_,
+		Transport: netutil.NewTransport(dialer),
+	}
+
+	signingConfig := p.config.Signing
+	if signingConfig == nil && p.config.AWSRegion != "" {
+		signingConfig = &signing.Config{
+			Type:               signing.TypeAWSSigV4,
+			AWSRegion:          p.config.AWSRegion,
+			AWSService:         p.config.AWSService,
+			AWSAccessKeyID:     p.config.AWSAccessKeyID,
+			AWSSecretAccessKey: p.config.AWSSecretAccessKey,
+		}
+	}
+	if signingConfig != nil {
+		p.signer, err = signing.New(signingConfig)
+		if err != nil {
+			p.logger.Fatalf("wrong signing: %s", err.Error())
+		}
+	}
+
+	headers, err := pipeline.ParseHeaderTemplates(p.config.Headers)
+	if err != nil {
+		p.logger.Fatalf("wrong headers: %s", err.Error())
+	}
+	p.headers = headers
+
+	if p.config.Compression != compression.CodecNone {
+		p.compression, err = compression.New(p.config.Compression, params.MetricsRegistry, params.PipelineName, "elasticsearch")
+		if err != nil {
+			p.logger.Fatalf("wrong compression: %s", err.Error())
+		}
 	}
 
 	p.maintenance(nil)
@@ -141,6 +265,9 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 }
 
 func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+	p.client.CloseIdleConnections()
 }
 
 func (p *Plugin) Out(event *pipeline.Event) {
@@ -160,14 +287,29 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgLogSize)
 	}
 
-	data.outBuf = data.outBuf[:0]
-	for _, event := range batch.Events {
-		data.outBuf = p.appendEvent(data.outBuf, event)
+	for _, group := range p.headers.Partition(batch.Events) {
+		p.sendBatch(data, group.Events, group.Header, batch)
 	}
+}
+
+// sendBatch retries the request until it succeeds or the batch is expired.
+// Events rejected temporarily, i.e. with 429 or 5xx item statuses, are retried, the rest of rejected events are dropped.
+func (p *Plugin) sendBatch(data *data, events []*pipeline.Event, header http.Header, batch *pipeline.Batch) {
+	body := p.appendEvents(data, events)
+	payload, codec := p.compression.Encode(body)
+	for attempt := 0; ; attempt++ {
+		// expired events are shed by the batcher instead of retrying
+		if attempt > 0 && batch.IsExpired() {
+			break
+		}
+		// the batch is given up on the stop, so it isn't committed in the at least once delivery mode
+		if attempt > 0 && p.isStopped.Load() {
+			batch.Fail(errStopped)
+			break
+		}
 
-	for {
 		endpoint := p.config.Endpoints[rand.Int()%len(p.config.Endpoints)]
-		resp, err := p.client.Post(endpoint, "application/x-ndjson", bytes.NewBuffer(data.outBuf))
+		resp, err := p.send(endpoint, payload, codec, header)
 		if err != nil {
 			p.logger.Errorf("can't send batch to %s, will try other endpoint: %s", endpoint, err.Error())
 			time.Sleep(time.Second)
@@ -181,6 +323,12 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 			continue
 		}
 
+		if p.compression.Reject(codec, resp.StatusCode, respContent) {
+			p.logger.Warnf("%s encoding is rejected by %s, trying %s: status=%d, body=%s", codec, endpoint, p.compression.Codec(), resp.StatusCode, respContent)
+			payload, codec = p.compression.Encode(body)
+			continue
+		}
+
 		if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusAccepted {
 			p.logger.Errorf("response status from %s isn't OK, will try other endpoint: status=%d, body=%s", endpoint, resp.StatusCode, respContent)
 			continue
@@ -193,20 +341,103 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 			continue
 		}
 
-		if root.Dig("errors").AsBool() {
-			for _, node := range root.Dig("items").AsArray() {
-				errNode := node.Dig("index", "error")
-				if errNode != nil {
-					p.logger.Errorf("indexing error: %s", errNode.EncodeToString())
-				}
-			}
-
-			p.controller.Error("some events from batch isn't written")
+		if !root.Dig("errors").AsBool() {
+			insaneJSON.Release(root)
+			break
 		}
 
+		events = p.rejectedEvents(root, events)
 		insaneJSON.Release(root)
-		break
+		if len(events) == 0 {
+			break
+		}
+
+		p.logger.Errorf("%d events are temporarily rejected by %s, will retry them", len(events), endpoint)
+		body = p.appendEvents(data, events)
+		payload, codec = p.compression.Encode(body)
+		time.Sleep(time.Second)
+	}
+}
+
+// rejectedEvents returns events which should be retried, i.e. rejected with 429 or 5xx statuses.
+// Items of the bulk response are in the order of the request, other rejected events are dropped.
+func (p *Plugin) rejectedEvents(root *insaneJSON.Root, events []*pipeline.Event) []*pipeline.Event {
+	items := root.Dig("items").AsArray()
+	if len(items) != len(events) {
+		p.logger.Errorf("bulk response has %d items for %d events, all of them will be retried", len(items), len(events))
+		return events
+	}
+
+	var retry []*pipeline.Event
+	dropped := 0
+	for i, item := range items {
+		status := item.Dig("index", "status").AsInt()
+		if status < http.StatusMultipleChoices {
+			continue
+		}
+
+		if status == http.StatusTooManyRequests || status >= http.StatusInternalServerError {
+			retry = append(retry, events[i])
+			continue
+		}
+
+		p.logger.Errorf("indexing error, event is dropped: status=%d, error=%s", status, item.Dig("index", "error").EncodeToString())
+		dropped++
+	}
+
+	if dropped > 0 {
+		p.controller.Error(fmt.Sprintf("%d events from batch aren't indexed", dropped))
+	}
+
+	return retry
+}
+
+func (p *Plugin) appendEvents(data *data, events []*pipeline.Event) []byte {
+	data.outBuf = data.outBuf[:0]
+	for _, event := range events {
+		data.outBuf = p.appendEvent(data.outBuf, event)
+	}
+
+	return data.outBuf
+}
+
+func (p *Plugin) send(endpoint string, body []byte, codec string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
 	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	compression.SetHeader(req, codec)
+
+	if p.signer != nil {
+		if err := p.signer.Sign(req, body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// the endpoint may have moved to another address
+		p.dialer.ResetHost(p.client, req.URL.Host)
+	}
+
+	return resp, err
+}
+
+// PreviewPayload renders the bulk request body for the events.
+func (p *Plugin) PreviewPayload(events []*pipeline.Event) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	outBuf := make([]byte, 0)
+	for _, event := range events {
+		outBuf = p.appendEvent(outBuf, event)
+	}
+
+	return outBuf
 }
 
 func (p *Plugin) appendEvent(outBuf []byte, event *pipeline.Event) []byte {
@@ -238,13 +469,18 @@ func (p *Plugin) appendIndexName(outBuf []byte, event *pipeline.Event) []byte {
 
 		if value == "@time" {
 			outBuf = append(outBuf, p.time...)
+			continue
+		}
+
+		if strings.HasPrefix(value, metaIndexValuePrefix) {
+			value = event.Meta[value[len(metaIndexValuePrefix):]]
 		} else {
-			value := event.Root.Dig(value).AsString()
-			if value == "" {
-				value = "not_set"
-			}
-			outBuf = append(outBuf, value...)
+			value = event.Root.Dig(value).AsString()
+		}
+		if value == "" {
+			value = "not_set"
 		}
+		outBuf = append(outBuf, value...)
 	}
 	outBuf = append(outBuf, "\"}}"...)
 	return outBuf
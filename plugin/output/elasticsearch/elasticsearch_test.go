@@ -2,14 +2,20 @@ package elasticsearch
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/compression"
 	"github.com/ozonru/file.d/logger"
 	"github.com/ozonru/file.d/pipeline"
 	"github.com/ozonru/file.d/test"
 	"github.com/stretchr/testify/assert"
 	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
 )
 
 func TestAppendEvent(t *testing.T) {
@@ -36,6 +42,15 @@ func TestAppendEvent(t *testing.T) {
 	assert.Equal(t, expected, string(result), "wrong request content")
 }
 
+func TestAppendIndexNameMeta(t *testing.T) {
+	p := &Plugin{config: &Config{IndexFormat: "logs-%-%", IndexValues: []string{"@meta.namespace", "@meta.missing"}}}
+
+	event := &pipeline.Event{}
+	event.SetMeta("namespace", "payments")
+
+	assert.Equal(t, `{"index":{"_index":"logs-payments-not_set"}}`, string(p.appendIndexName(nil, event)), "wrong index name")
+}
+
 func TestConfig(t *testing.T) {
 	p := &Plugin{}
 	config := &Config{
@@ -54,3 +69,119 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, "http://endpoint_1:9000/_bulk?_source=false", p.config.Endpoints[0], "wrong endpoint")
 	assert.Equal(t, "http://endpoint_2:9000/_bulk?_source=false", p.config.Endpoints[1], "wrong endpoint")
 }
+
+func TestSign(t *testing.T) {
+	var authorization, contentHash string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		contentHash = r.Header.Get("X-Amz-Content-Sha256")
+		_, _ = w.Write([]byte(`{"errors":false}`))
+	}))
+	defer server.Close()
+
+	p := &Plugin{}
+	config := &Config{
+		Endpoints:          []string{server.URL},
+		BatchSize:          "1",
+		AWSRegion:          "eu-west-1",
+		AWSService:         "aoss",
+		AWSAccessKeyID:     "key",
+		AWSSecretAccessKey: "secret",
+	}
+
+	err := cfg.Parse(config, map[string]int{"gomaxprocs": 1})
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	p.Start(config, test.NewEmptyOutputPluginParams())
+
+	resp, err := p.send(p.config.Endpoints[0], []byte("{}\n"), compression.CodecNone, nil)
+	assert.NoError(t, err, "request should be sent")
+	_ = resp.Body.Close()
+
+	assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=key/"), "wrong authorization %s", authorization)
+	assert.Contains(t, authorization, "/eu-west-1/aoss/aws4_request", "wrong signing scope")
+	assert.NotEmpty(t, contentHash, "payload hash header should be set")
+}
+
+func TestHeaders(t *testing.T) {
+	var tenant, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.Header.Get("X-Scope-OrgID")
+		contentType = r.Header.Get("Content-Type")
+		_, _ = w.Write([]byte(`{"errors":false}`))
+	}))
+	defer server.Close()
+
+	p := &Plugin{}
+	config := &Config{
+		Endpoints: []string{server.URL},
+		BatchSize: "1",
+		Headers:   map[string]string{"X-Scope-OrgID": "{{.tenant}}", "Content-Type": "text/plain"},
+	}
+
+	err := cfg.Parse(config, map[string]int{"gomaxprocs": 1})
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	p.Start(config, test.NewEmptyOutputPluginParams())
+
+	root, _ := insaneJSON.DecodeBytes([]byte(`{"tenant":"team-a"}`))
+	groups := p.headers.Partition([]*pipeline.Event{{Root: root}})
+	resp, err := p.send(p.config.Endpoints[0], []byte("{}\n"), compression.CodecNone, groups[0].Header)
+	assert.NoError(t, err, "request should be sent")
+	_ = resp.Body.Close()
+
+	assert.Equal(t, "team-a", tenant, "wrong tenant header")
+	assert.Equal(t, "application/x-ndjson", contentType, "content type shouldn't be overridden")
+}
+
+type fakeController struct {
+	errors []string
+}
+
+func (c *fakeController) Commit(_ *pipeline.Event) {}
+func (c *fakeController) Error(err string)          { c.errors = append(c.errors, err) }
+func (c *fakeController) Backpressure(bool)         {}
+
+func TestSendBatchRejected(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) > 1 {
+			_, _ = w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}},{"index":{"status":201}}]}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"errors":true,"items":[` +
+			`{"index":{"status":201}},` +
+			`{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},` +
+			`{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},` +
+			`{"index":{"status":503,"error":{"type":"unavailable_shards_exception"}}}]}`))
+	}))
+	defer server.Close()
+
+	controller := &fakeController{}
+	p := &Plugin{
+		logger:     zap.NewNop().Sugar(),
+		client:     server.Client(),
+		controller: controller,
+		config:     &Config{Endpoints: []string{server.URL}, IndexFormat: "logs"},
+	}
+
+	var events []*pipeline.Event
+	for i := 0; i < 4; i++ {
+		root, _ := insaneJSON.DecodeBytes([]byte(fmt.Sprintf(`{"id":%d}`, i)))
+		events = append(events, &pipeline.Event{Root: root})
+	}
+
+	batch := &pipeline.Batch{Events: events}
+	p.sendBatch(&data{}, events, nil, batch)
+
+	assert.Equal(t, 2, len(bodies), "only the temporarily rejected events should be retried")
+	assert.Equal(t, `{"index":{"_index":"logs"}}`+"\n"+`{"id":1}`+"\n"+`{"index":{"_index":"logs"}}`+"\n"+`{"id":3}`+"\n", bodies[1], "wrong retried events")
+	assert.Equal(t, 1, len(controller.errors), "dropped events should be reported")
+}
@@ -0,0 +1,303 @@
+// Package failover is an output plugin that routes events to the first healthy of the wrapped outputs.
+package failover
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It wraps an ordered list of outputs and routes events to the healthy ones,
+e.g. the primary Kafka DC → the secondary DC → the local file spool.
+
+* `failover` mode: all events go to the first healthy output.
+* `balance` mode: events are distributed between healthy outputs in the round-robin manner.
+
+If no output is healthy, events go to the last one.
+
+An output is unhealthy if:
+* its `health_check` fails, it's either `tcp://host:port` to dial or `http(s)://...` to get a `2xx` response
+* it has events which aren't committed and it hasn't committed anything for the `commit_timeout`
+
+The output is restored automatically when the health check passes and it commits pending events again.
+
+> ⚠ Events which are already passed to the failed output stay there until it recovers.
+> Keep `batch_size` and `workers_count` of the wrapped outputs small enough, so they can't hold the whole pipeline `capacity`.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: failover
+      outputs:
+        - health_check: tcp://kafka-dc1:9092
+          output:
+            type: kafka
+            brokers: [kafka-dc1:9092]
+            default_topic: logs
+            batch_size: 256
+        - health_check: tcp://kafka-dc2:9092
+          output:
+            type: kafka
+            brokers: [kafka-dc2:9092]
+            default_topic: logs
+            batch_size: 256
+        - output:
+            type: file
+            target_file: /var/spool/file.d/logs.log
+    ...
+```
+}*/
+
+const (
+	modeFailover = "failover"
+	modeBalance  = "balance"
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.OutputPluginController
+	outputs    []*output
+	balanceSeq *atomic.Uint64
+	stopCh     chan struct{}
+
+	// changed is closed and replaced when the health of any output changes
+	mu      *sync.Mutex
+	changed chan struct{}
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The ordered list of outputs. It's a list of objects, each object has the following fields:
+	//> * `output` – the config of the wrapped output, the same as the pipeline `output` section
+	//> * `health_check` – an address to check: `tcp://host:port` or `http(s)://...`, if it's empty, only commits are checked
+	Outputs []OutputConfig `json:"outputs" slice:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How to route events between healthy outputs.
+	Mode string `json:"mode" default:"failover" options:"failover|balance"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How often to check the health of outputs.
+	HealthCheckInterval  cfg.Duration `json:"health_check_interval" default:"5s" parse:"duration"` //*
+	HealthCheckInterval_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Timeout of the `health_check`.
+	HealthCheckTimeout  cfg.Duration `json:"health_check_timeout" default:"2s" parse:"duration"` //*
+	HealthCheckTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> The output with pending events is unhealthy if it doesn't commit anything for this timeout.
+	CommitTimeout  cfg.Duration `json:"commit_timeout" default:"30s" parse:"duration"` //*
+	CommitTimeout_ time.Duration
+}
+
+type OutputConfig struct {
+	Output      json.RawMessage `json:"output"`
+	HealthCheck string          `json:"health_check"`
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    "failover",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.balanceSeq = atomic.NewUint64(0)
+	p.stopCh = make(chan struct{})
+	p.mu = &sync.Mutex{}
+	p.changed = make(chan struct{})
+
+	if len(p.config.Outputs) == 0 {
+		p.logger.Fatalf("no outputs are set")
+	}
+
+	values := map[string]int{
+		"capacity":   params.PipelineSettings.Capacity,
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+	}
+
+	for i, outputConfig := range p.config.Outputs {
+		o, err := p.newOutput(i, outputConfig, values)
+		if err != nil {
+			p.logger.Fatalf("wrong output #%d: %s", i, err.Error())
+		}
+		p.outputs = append(p.outputs, o)
+	}
+
+	for _, o := range p.outputs {
+		o.plugin.Start(o.config, &pipeline.OutputPluginParams{
+			PluginDefaultParams: params.PluginDefaultParams,
+			Controller:          o,
+			Logger:              p.logger.With("output", o.name),
+			Chaos:               params.Chaos,
+		})
+		go o.pump(p.stopCh)
+	}
+
+	go p.monitor()
+}
+
+func (p *Plugin) newOutput(index int, config OutputConfig, values map[string]int) (*output, error) {
+	outputJSON, err := simplejson.NewJson(config.Output)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse output config: %w", err)
+	}
+
+	t := outputJSON.Get("type").MustString()
+	if t == "" {
+		return nil, fmt.Errorf("output doesn't have type")
+	}
+	if t == "failover" {
+		return nil, fmt.Errorf("failover can't be nested")
+	}
+
+	if config.HealthCheck != "" {
+		if err := validateAddress(config.HealthCheck); err != nil {
+			return nil, err
+		}
+	}
+
+	info := fd.DefaultPluginRegistry.Get(pipeline.PluginKindOutput, t)
+	plugin, pluginConfig := info.Factory()
+	if err := json.Unmarshal(config.Output, pluginConfig); err != nil {
+		return nil, fmt.Errorf("can't unmarshal config of %s: %w", t, err)
+	}
+	if err := cfg.Parse(pluginConfig, values); err != nil {
+		return nil, fmt.Errorf("wrong config of %s: %w", t, err)
+	}
+
+	return &output{
+		name:         fmt.Sprintf("%d:%s", index, t),
+		plugin:       plugin.(pipeline.OutputPlugin),
+		config:       pluginConfig,
+		parent:       p.controller,
+		healthCheck:  config.HealthCheck,
+		queue:        make(chan *pipeline.Event),
+		inflight:     atomic.NewInt64(0),
+		lastProgress: atomic.NewInt64(time.Now().UnixNano()),
+		healthy:      atomic.NewBool(true),
+	}, nil
+}
+
+func (p *Plugin) Stop() {
+	close(p.stopCh)
+	for _, o := range p.outputs {
+		o.plugin.Stop()
+	}
+}
+
+// Out blocks until the chosen output takes the event,
+// if the health of outputs changes meanwhile, the output is chosen again.
+func (p *Plugin) Out(event *pipeline.Event) {
+	for {
+		p.mu.Lock()
+		changed := p.changed
+		p.mu.Unlock()
+
+		o := p.choose()
+		select {
+		case o.queue <- event:
+			return
+		case <-changed:
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+func (p *Plugin) choose() *output {
+	start := 0
+	if p.config.Mode == modeBalance {
+		start = int(p.balanceSeq.Inc() % uint64(len(p.outputs)))
+	}
+
+	for i := range p.outputs {
+		o := p.outputs[(start+i)%len(p.outputs)]
+		if o.healthy.Load() {
+			return o
+		}
+	}
+
+	return p.outputs[len(p.outputs)-1]
+}
+
+func (p *Plugin) monitor() {
+	ticker := time.NewTicker(p.config.HealthCheckInterval_)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.checkHealth(time.Now())
+		}
+	}
+}
+
+func (p *Plugin) checkHealth(now time.Time) {
+	isChanged := false
+	for _, o := range p.outputs {
+		healthy := true
+		if o.healthCheck != "" {
+			if err := checkAddress(o.healthCheck, p.config.HealthCheckTimeout_); err != nil {
+				p.logger.Warnf("health check of output %s has failed: %s", o.name, err.Error())
+				healthy = false
+			}
+		}
+		if o.isStuck(now, p.config.CommitTimeout_) {
+			healthy = false
+		}
+
+		if o.healthy.Swap(healthy) == healthy {
+			continue
+		}
+
+		isChanged = true
+		if healthy {
+			p.logger.Infof("output %s is restored", o.name)
+		} else {
+			p.logger.Errorf("output %s is unhealthy, events are routed to other outputs", o.name)
+		}
+	}
+
+	if !isChanged {
+		return
+	}
+
+	p.mu.Lock()
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+}
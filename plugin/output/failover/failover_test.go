@@ -0,0 +1,194 @@
+package failover
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// fakeOutput commits events immediately unless it's broken, broken output holds events until it's fixed.
+type fakeOutput struct {
+	mu         *sync.Mutex
+	controller pipeline.OutputPluginController
+	broken     bool
+	received   int
+	held       []*pipeline.Event
+}
+
+type fakeConfig struct{}
+
+var fakeOutputs []*fakeOutput
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type: "failover_fake",
+		Factory: func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+			o := &fakeOutput{mu: &sync.Mutex{}}
+			fakeOutputs = append(fakeOutputs, o)
+			return o, &fakeConfig{}
+		},
+	})
+}
+
+func (o *fakeOutput) Start(_ pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	o.controller = params.Controller
+}
+
+func (o *fakeOutput) Stop() {}
+
+func (o *fakeOutput) Out(event *pipeline.Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.received++
+	if o.broken {
+		o.held = append(o.held, event)
+		return
+	}
+	o.controller.Commit(event)
+}
+
+func (o *fakeOutput) setBroken(broken bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.broken = broken
+	if broken {
+		return
+	}
+	for _, event := range o.held {
+		o.controller.Commit(event)
+	}
+	o.held = nil
+}
+
+func (o *fakeOutput) receivedCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.received
+}
+
+type fakeController struct {
+	commits *atomic.Int64
+}
+
+func (c *fakeController) Commit(_ *pipeline.Event) {
+	c.commits.Inc()
+}
+
+func (c *fakeController) Error(_ string)    {}
+func (c *fakeController) Backpressure(bool) {}
+
+func newPlugin(t *testing.T, mode string, count int) (*Plugin, []*fakeOutput, *fakeController) {
+	fakeOutputs = nil
+	config := &Config{
+		Mode:                 mode,
+		HealthCheckInterval_: time.Hour,
+		CommitTimeout_:       time.Second,
+	}
+	for i := 0; i < count; i++ {
+		config.Outputs = append(config.Outputs, OutputConfig{Output: json.RawMessage(`{"type":"failover_fake"}`)})
+	}
+
+	controller := &fakeController{commits: atomic.NewInt64(0)}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = controller
+
+	p := &Plugin{}
+	p.Start(config, params)
+	t.Cleanup(p.Stop)
+
+	require.Equal(t, count, len(fakeOutputs), "wrong outputs count")
+
+	return p, fakeOutputs, controller
+}
+
+func waitReceived(t *testing.T, o *fakeOutput, count int) {
+	for i := 0; i < 100 && o.receivedCount() < count; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, count, o.receivedCount(), "wrong received count")
+}
+
+func TestFailover(t *testing.T) {
+	p, outputs, controller := newPlugin(t, modeFailover, 2)
+
+	p.Out(&pipeline.Event{})
+	waitReceived(t, outputs[0], 1)
+
+	outputs[0].setBroken(true)
+	p.Out(&pipeline.Event{})
+	waitReceived(t, outputs[0], 2)
+
+	p.checkHealth(time.Now().Add(2 * time.Second))
+	p.Out(&pipeline.Event{})
+	waitReceived(t, outputs[1], 1)
+
+	outputs[0].setBroken(false)
+	p.checkHealth(time.Now())
+	p.Out(&pipeline.Event{})
+	waitReceived(t, outputs[0], 3)
+
+	assert.Equal(t, int64(4), controller.commits.Load(), "all events should be committed")
+}
+
+func TestAllUnhealthy(t *testing.T) {
+	p, outputs, _ := newPlugin(t, modeFailover, 2)
+
+	for _, o := range p.outputs {
+		o.healthy.Store(false)
+	}
+
+	p.Out(&pipeline.Event{})
+	waitReceived(t, outputs[1], 1)
+	assert.Equal(t, 0, outputs[0].receivedCount(), "events should go to the last output")
+}
+
+func TestBalance(t *testing.T) {
+	p, outputs, _ := newPlugin(t, modeBalance, 2)
+
+	for i := 0; i < 10; i++ {
+		p.Out(&pipeline.Event{})
+	}
+	waitReceived(t, outputs[0], 5)
+	waitReceived(t, outputs[1], 5)
+
+	p.outputs[1].healthy.Store(false)
+	for i := 0; i < 10; i++ {
+		p.Out(&pipeline.Event{})
+	}
+	waitReceived(t, outputs[0], 15)
+	waitReceived(t, outputs[1], 5)
+}
+
+func TestCheckAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	assert.NoError(t, checkAddress("tcp://"+listener.Addr().String(), time.Second))
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	assert.NoError(t, checkAddress(server.URL, time.Second))
+	status = http.StatusServiceUnavailable
+	assert.Error(t, checkAddress(server.URL, time.Second))
+
+	assert.Error(t, validateAddress("udp://localhost:1"))
+}
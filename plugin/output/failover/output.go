@@ -0,0 +1,109 @@
+package failover
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/atomic"
+)
+
+// output is the wrapped output, it's also the controller of the wrapped output to track commits.
+type output struct {
+	name        string
+	plugin      pipeline.OutputPlugin
+	config      pipeline.AnyConfig
+	parent      pipeline.OutputPluginController
+	healthCheck string
+	queue       chan *pipeline.Event
+
+	inflight     *atomic.Int64
+	lastProgress *atomic.Int64
+	healthy      *atomic.Bool
+}
+
+// pump passes events to the output, so a blocked output doesn't block choosing another one.
+func (o *output) pump(stopCh chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event := <-o.queue:
+			if o.inflight.Inc() == 1 {
+				o.lastProgress.Store(time.Now().UnixNano())
+			}
+			o.plugin.Out(event)
+		}
+	}
+}
+
+func (o *output) Commit(event *pipeline.Event) {
+	o.inflight.Dec()
+	o.lastProgress.Store(time.Now().UnixNano())
+	o.parent.Commit(event)
+}
+
+func (o *output) Error(err string) {
+	o.parent.Error(err)
+}
+
+// Backpressure isn't passed to the parent since events go to another output when this one is stuck.
+func (o *output) Backpressure(bool) {}
+
+// isStuck checks if the output has pending events but doesn't commit them.
+func (o *output) isStuck(now time.Time, timeout time.Duration) bool {
+	if o.inflight.Load() <= 0 {
+		return false
+	}
+
+	return now.Sub(time.Unix(0, o.lastProgress.Load())) > timeout
+}
+
+func validateAddress(address string) error {
+	u, err := url.Parse(address)
+	if err != nil {
+		return fmt.Errorf("can't parse health check address: %w", err)
+	}
+
+	if u.Scheme != "tcp" && u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unknown health check scheme %q", u.Scheme)
+	}
+
+	return nil
+}
+
+func checkAddress(address string, timeout time.Duration) error {
+	u, err := url.Parse(address)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", u.Host, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http", "https":
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(address)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("wrong response code %d", resp.StatusCode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown health check scheme %q", u.Scheme)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/ozonru/file.d/longpanic"
 	"github.com/ozonru/file.d/pipeline"
 
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
 )
@@ -24,6 +25,7 @@ type Plugin struct {
 	config         *Config
 	avgLogSize     int
 	batcher        *pipeline.Batcher
+	template       *pipeline.EventTemplate
 	file           *os.File
 	ctx            context.Context
 	cancelFunc     context.CancelFunc
@@ -35,9 +37,37 @@ type Plugin struct {
 	fileName      string
 	tsFileName    string
 
-	SealUpCallback func(string)
+	// SealUpHook is notified about sealed up files, e.g. to upload them
+	SealUpHook SealUpHook
 
-	mu *sync.RWMutex
+	mu          *sync.RWMutex
+	eventsCount atomic.Int64 // events written to the current file
+	// isSynced is set for the at least once delivery, the file is synced after every write then
+	isSynced bool
+}
+
+// SealedFile describes the file which is sealed up and won't be written anymore.
+type SealedFile struct {
+	Name string
+	// CreatedAt and SealedAt are the time range of the file
+	CreatedAt time.Time
+	SealedAt  time.Time
+	// EventsCount doesn't include events written to the file before file.d restart
+	EventsCount int64
+	Size        int64
+}
+
+// SealUpHook is called in a separate goroutine for each sealed up file,
+// the context is cancelled when the plugin stops.
+type SealUpHook interface {
+	SealUp(ctx context.Context, file *SealedFile)
+}
+
+// SealUpHookFunc allows to use a function as SealUpHook.
+type SealUpHookFunc func(ctx context.Context, file *SealedFile)
+
+func (f SealUpHookFunc) SealUp(ctx context.Context, file *SealedFile) {
+	f(ctx, file)
 }
 
 type data struct {
@@ -76,6 +106,12 @@ type Config struct {
 	//> File mode for log files
 	FileMode  cfg.Base8 `json:"file_mode" default:"0666" parse:"base8"` //*
 	FileMode_ int64
+
+	//> Format of the lines: `json` writes events as is, `template` renders them using `template` param
+	Format string `json:"format" default:"json" options:"json|template"` //*
+
+	//> Template for the lines if `format` is `template`, e.g. `{{.time}} {{.level}} {{.k8s.pod}}: {{.message}}`
+	Template string `json:"template" default:"{{.time}} {{.level}} {{.message}}"` //*
 }
 
 func init() {
@@ -93,6 +129,7 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.controller = params.Controller
 	p.logger = params.Logger
 	p.config = config.(*Config)
+	p.isSynced = params.PipelineSettings.Delivery == pipeline.DeliveryAtLeastOnce
 
 	dir, file := filepath.Split(p.config.TargetFile)
 	p.targetDir = dir
@@ -100,6 +137,14 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.fileName = file[0 : len(file)-len(p.fileExtension)]
 	p.tsFileName = "%s" + "-" + p.fileName
 
+	if p.config.Format == "template" {
+		template, err := pipeline.ParseEventTemplate(p.config.Template)
+		if err != nil {
+			p.logger.Fatalf("can't parse template: %s", err.Error())
+		}
+		p.template = template
+	}
+
 	p.batcher = pipeline.NewBatcher(
 		params.PipelineName,
 		"file",
@@ -132,7 +177,7 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		p.logger.Panic("next seal up time is nil!")
 	}
 
-	longpanic.Go(p.fileSealUpTicker)
+	longpanic.GoScoped(longpanic.Scope{Pipeline: params.PipelineName, Plugin: "file"}, p.fileSealUpTicker)
 	p.batcher.Start()
 }
 
@@ -161,12 +206,34 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 	outBuf := data.outBuf[:0]
 
 	for _, event := range batch.Events {
-		outBuf, _ = event.Encode(outBuf)
-		outBuf = append(outBuf, byte('\n'))
+		outBuf = p.appendEvent(outBuf, event)
 	}
 	data.outBuf = outBuf
 
-	p.write(outBuf)
+	if err := p.write(outBuf, len(batch.Events)); err != nil {
+		p.logger.Errorf("%s", err.Error())
+		batch.Fail(err)
+	}
+}
+
+// PreviewPayload renders the lines which would be written to the file for the events.
+func (p *Plugin) PreviewPayload(events []*pipeline.Event) []byte {
+	outBuf := make([]byte, 0)
+	for _, event := range events {
+		outBuf = p.appendEvent(outBuf, event)
+	}
+
+	return outBuf
+}
+
+func (p *Plugin) appendEvent(outBuf []byte, event *pipeline.Event) []byte {
+	if p.template != nil {
+		outBuf = p.template.Render(outBuf, event)
+	} else {
+		outBuf, _ = event.Encode(outBuf)
+	}
+
+	return append(outBuf, byte('\n'))
 }
 
 func (p *Plugin) fileSealUpTicker() {
@@ -183,21 +250,36 @@ func (p *Plugin) fileSealUpTicker() {
 }
 
 func (p *Plugin) setNextSealUpTime() {
+	p.nextSealUpTime = p.creationTime().Add(p.config.RetentionInterval_)
+}
+
+// creationTime parses the timestamp from the name of the current file.
+func (p *Plugin) creationTime() time.Time {
 	ts := p.tsFileName[0 : len(p.tsFileName)-len(fileNameSeparator)-len(p.fileName)-len(p.fileExtension)]
 	t, err := strconv.ParseInt(ts, 10, 64)
 	if err != nil {
 		p.logger.Panicf("coult nod convert timestamp to int for file: %s, error: %s", p.tsFileName, err.Error())
 	}
-	creationTime := time.Unix(t, 0)
-	p.nextSealUpTime = creationTime.Add(p.config.RetentionInterval_)
+	return time.Unix(t, 0)
 }
 
-func (p *Plugin) write(data []byte) {
+// write appends data to the current file, the error is returned if the data isn't written or synced to the disk.
+func (p *Plugin) write(data []byte, eventsCount int) error {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	if _, err := p.file.Write(data); err != nil {
-		p.logger.Fatalf("could not write into the file: %s, error: %s", p.file.Name(), err.Error())
+		return fmt.Errorf("can't write into file %s: %w", p.file.Name(), err)
 	}
+	p.eventsCount.Add(int64(eventsCount))
+
+	if !p.isSynced {
+		return nil
+	}
+	if err := p.file.Sync(); err != nil {
+		return fmt.Errorf("can't sync file %s: %w", p.file.Name(), err)
+	}
+
+	return nil
 }
 
 func (p *Plugin) createNew() {
@@ -226,20 +308,35 @@ func (p *Plugin) sealUp() {
 		return
 	}
 
+	now := time.Now()
 	// newFileName will be like: ".var/log/log_1_01-02-2009_15:04.log
-	newFileName := filepath.Join(p.targetDir, fmt.Sprintf("%s%s%d%s%s%s", p.fileName, fileNameSeparator, p.idx, fileNameSeparator, time.Now().Format(p.config.Layout), p.fileExtension))
+	newFileName := filepath.Join(p.targetDir, fmt.Sprintf("%s%s%d%s%s%s", p.fileName, fileNameSeparator, p.idx, fileNameSeparator, now.Format(p.config.Layout), p.fileExtension))
+	sealed := &SealedFile{
+		Name:      newFileName,
+		CreatedAt: p.creationTime(),
+		SealedAt:  now,
+	}
+
 	p.rename(newFileName)
 	oldFile := p.file
 	p.mu.Lock()
+	sealed.EventsCount = p.eventsCount.Swap(0)
 	p.createNew()
-	p.nextSealUpTime = time.Now().Add(p.config.RetentionInterval_)
+	p.nextSealUpTime = now.Add(p.config.RetentionInterval_)
 	p.mu.Unlock()
+
 	if err := oldFile.Close(); err != nil {
 		p.logger.Panicf("could not close file: %s, error: %s", oldFile.Name(), err.Error())
 	}
 
-	if p.SealUpCallback != nil {
-		longpanic.Go(func() { p.SealUpCallback(newFileName) })
+	// the file could be written after the stat above until it's replaced
+	sealed.Size = info.Size()
+	if info, err := os.Stat(newFileName); err == nil {
+		sealed.Size = info.Size()
+	}
+
+	if p.SealUpHook != nil {
+		longpanic.Go(func() { p.SealUpHook.SealUp(p.ctx, sealed) })
 	}
 }
 
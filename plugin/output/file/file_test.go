@@ -1,6 +1,7 @@
 package file
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
@@ -137,6 +138,59 @@ func TestSealUpHasContent(t *testing.T) {
 	}
 }
 
+func TestSealUpHook(t *testing.T) {
+	cfg := Config{
+		TargetFile:         targetFile,
+		RetentionInterval_: time.Hour,
+		Layout:             "01",
+		FileMode_:          0o666,
+	}
+
+	dir, file := filepath.Split(cfg.TargetFile)
+	extension := filepath.Ext(file)
+
+	test.ClearDir(t, dir)
+	createDir(t, dir)
+	defer test.ClearDir(t, dir)
+
+	createdAt := time.Now().Add(-time.Minute).Unix()
+	testFileName := fmt.Sprintf(targetFileThreshold, createdAt, fileNameSeparator)
+	f := createFile(t, testFileName, nil)
+	defer f.Close()
+
+	sealedCh := make(chan *SealedFile, 1)
+	p := Plugin{
+		config:        &cfg,
+		ctx:           context.Background(),
+		mu:            &sync.RWMutex{},
+		file:          f,
+		targetDir:     dir,
+		fileExtension: extension,
+		fileName:      file[0 : len(file)-len(extension)],
+		tsFileName:    path.Base(testFileName),
+		SealUpHook: SealUpHookFunc(func(_ context.Context, file *SealedFile) {
+			sealedCh <- file
+		}),
+	}
+
+	d := []byte("{\"a\":1}\n{\"a\":2}\n")
+	p.write(d, 2)
+	p.sealUp()
+
+	select {
+	case sealed := <-sealedCh:
+		assert.True(t, strings.HasPrefix(filepath.Base(sealed.Name), "log_0_"), "wrong file name %s", sealed.Name)
+		assert.Equal(t, createdAt, sealed.CreatedAt.Unix())
+		assert.False(t, sealed.SealedAt.Before(sealed.CreatedAt))
+		assert.EqualValues(t, 2, sealed.EventsCount)
+		assert.EqualValues(t, len(d), sealed.Size)
+	case <-time.After(time.Second):
+		t.Fatal("seal up hook isn't called")
+	}
+
+	assert.Zero(t, p.eventsCount.Load(), "events count should be reset for the new file")
+}
+
 func TestSealUpNoContent(t *testing.T) {
 	FileSealUpInterval = 200 * time.Millisecond
 	cfg := Config{
@@ -181,6 +235,23 @@ func TestSealUpNoContent(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWriteError(t *testing.T) {
+	test.ClearDir(t, dir)
+	createDir(t, dir)
+	defer test.ClearDir(t, dir)
+	testFileName := fmt.Sprintf(targetFileThreshold, time.Now().Unix(), fileNameSeparator)
+	f := createFile(t, testFileName, nil)
+	p := Plugin{
+		mu:   &sync.RWMutex{},
+		file: f,
+	}
+
+	// writes to the closed file fail, it shouldn't crash file.d
+	assert.NoError(t, f.Close())
+	assert.Error(t, p.write([]byte("event\n"), 1))
+	assert.Zero(t, p.eventsCount.Load(), "events which aren't written shouldn't be counted")
+}
+
 func TestStart(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping testing in short mode")
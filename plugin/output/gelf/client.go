@@ -3,7 +3,8 @@ package gelf
 import (
 	"crypto/tls"
 	"net"
-	"time"
+
+	"github.com/ozonru/file.d/netutil"
 )
 
 type network string
@@ -20,16 +21,32 @@ type client struct {
 	tlsClient *tls.Conn
 }
 
-func newClient(network network, address string, timeout time.Duration, useTLS bool, tlsConfig *tls.Config) (*client, error) {
+func newClient(network network, address string, dialer *netutil.Dialer, useTLS bool, tlsConfig *tls.Config) (*client, error) {
 	if useTLS {
-		c, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, string(network), address, tlsConfig)
+		conn, err := dialer.Dial(string(network), address)
 		if err != nil {
 			return nil, err
 		}
 
+		// the server name is checked the way `tls.Dial` does it
+		if tlsConfig == nil || tlsConfig.ServerName == "" {
+			config := &tls.Config{}
+			if tlsConfig != nil {
+				config = tlsConfig.Clone()
+			}
+			config.ServerName, _, _ = net.SplitHostPort(address)
+			tlsConfig = config
+		}
+
+		c := tls.Client(conn, tlsConfig)
+		if err := c.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
 		return &client{tlsClient: c}, nil
 	} else {
-		c, err := net.DialTimeout(string(network), address, timeout)
+		c, err := dialer.Dial(string(network), address)
 		if err != nil {
 			return nil, err
 		}
@@ -1,13 +1,17 @@
 package gelf
 
 import (
+	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ozonru/file.d/cfg"
 	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
 	"github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -36,8 +40,16 @@ type Plugin struct {
 	avgLogSize int
 	batcher    *pipeline.Batcher
 	controller pipeline.OutputPluginController
+	dialer     *netutil.Dialer
+	// clients are connections of workers, they're closed on stop
+	clients   map[*client]struct{}
+	clientsMu *sync.Mutex
+	isStopped atomic.Bool
 }
 
+// errStopped fails the batch which isn't sent before the stop.
+var errStopped = errors.New("output is stopped")
+
 //! config-params
 //^ config-params
 type Config struct {
@@ -58,6 +70,12 @@ type Config struct {
 	ConnectionTimeout  cfg.Duration `json:"connection_timeout" default:"5s" parse:"duration"` //*
 	ConnectionTimeout_ time.Duration
 
+	//> @3@4@5@6
+	//>
+	//> A network interface to connect from, e.g. `eth1`. Connections are made from its address of the family of the endpoint address.
+	//> IPv6 and IPv4 addresses of the endpoint host are tried in parallel. If it's empty, the interface is chosen by the routing table.
+	SourceInterface string `json:"source_interface"` //*
+
 	//> @3@4@5@6
 	//>
 	//> Which field of the event should be used as `host` GELF field.
@@ -166,6 +184,17 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.config.timestampFieldFormat = format
 	p.config.levelField = pipeline.ByteToStringUnsafe(p.formatExtraField(nil, p.config.LevelField))
 
+	if err := netutil.CheckAddress(p.config.Endpoint); err != nil {
+		p.logger.Fatalf("wrong endpoint: %s", err.Error())
+	}
+	p.dialer, err = netutil.NewDialer(p.config.ConnectionTimeout_, p.config.SourceInterface)
+	if err != nil {
+		p.logger.Fatalf("can't create dialer: %s", err.Error())
+	}
+	p.dialer.SetChaos(params.Chaos)
+	p.clients = make(map[*client]struct{})
+	p.clientsMu = &sync.Mutex{}
+
 	p.batcher = pipeline.NewBatcher(
 		params.PipelineName,
 		"gelf",
@@ -181,6 +210,15 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 }
 
 func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+
+	p.clientsMu.Lock()
+	for gelf := range p.clients {
+		_ = gelf.close()
+	}
+	p.clients = make(map[*client]struct{})
+	p.clientsMu.Unlock()
 }
 
 func (p *Plugin) Out(event *pipeline.Event) {
@@ -204,32 +242,41 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 	outBuf := data.outBuf[:0]
 	encodeBuf := data.encodeBuf[:0]
 	for _, event := range batch.Events {
-		encodeBuf = p.formatEvent(encodeBuf, event)
-		outBuf, _ = event.Encode(outBuf)
-		outBuf = append(outBuf, byte(0))
+		outBuf, encodeBuf = p.appendEvent(outBuf, encodeBuf, event)
 	}
 	data.outBuf = outBuf
 	data.encodeBuf = encodeBuf
 
-	for {
+	for attempt := 0; ; attempt++ {
+		// expired events are shed by the batcher instead of retrying
+		if attempt > 0 && batch.IsExpired() {
+			break
+		}
+
 		if data.gelf == nil {
+			// the connection is closed by the stop, so the worker shouldn't reconnect,
+			// the batch isn't committed in the at least once delivery mode then
+			if p.isStopped.Load() {
+				batch.Fail(errStopped)
+				break
+			}
 			p.logger.Infof("connecting to gelf address=%s", p.config.Endpoint)
 
-			gelf, err := newClient(transportTCP, p.config.Endpoint, p.config.ConnectionTimeout_, false, nil)
+			gelf, err := newClient(transportTCP, p.config.Endpoint, p.dialer, false, nil)
 			if err != nil {
 				p.logger.Errorf("can't connect to gelf endpoint address=%s: %s", p.config.Endpoint, err.Error())
 				time.Sleep(time.Second)
 				continue
 			}
 			data.gelf = gelf
-
+			p.trackClient(gelf)
 		}
 
 		_, err := data.gelf.send(outBuf)
 
 		if err != nil {
 			p.logger.Errorf("can't send data to gelf address=%s", p.config.Endpoint, err.Error())
-			_ = data.gelf.close()
+			p.closeClient(data.gelf)
 			data.gelf = nil
 			time.Sleep(time.Second)
 			continue
@@ -239,6 +286,25 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 	}
 }
 
+// PreviewPayload renders the null delimited GELF messages for the events.
+func (p *Plugin) PreviewPayload(events []*pipeline.Event) []byte {
+	outBuf := make([]byte, 0)
+	encodeBuf := make([]byte, 0)
+	for _, event := range events {
+		outBuf, encodeBuf = p.appendEvent(outBuf, encodeBuf, event)
+	}
+
+	return outBuf
+}
+
+func (p *Plugin) appendEvent(outBuf []byte, encodeBuf []byte, event *pipeline.Event) ([]byte, []byte) {
+	encodeBuf = p.formatEvent(encodeBuf, event)
+	outBuf, _ = event.Encode(outBuf)
+	outBuf = append(outBuf, byte(0))
+
+	return outBuf, encodeBuf
+}
+
 func (p *Plugin) maintenance(workerData *pipeline.WorkerData) {
 	if *workerData == nil {
 		return
@@ -246,10 +312,26 @@ func (p *Plugin) maintenance(workerData *pipeline.WorkerData) {
 
 	p.logger.Infof("reconnecting worker...")
 	data := (*workerData).(*data)
-	_ = data.gelf.close()
+	if data.gelf != nil {
+		p.closeClient(data.gelf)
+	}
 	data.gelf = nil
 }
 
+func (p *Plugin) trackClient(gelf *client) {
+	p.clientsMu.Lock()
+	p.clients[gelf] = struct{}{}
+	p.clientsMu.Unlock()
+}
+
+func (p *Plugin) closeClient(gelf *client) {
+	p.clientsMu.Lock()
+	delete(p.clients, gelf)
+	p.clientsMu.Unlock()
+
+	_ = gelf.close()
+}
+
 func (p *Plugin) formatEvent(encodeBuf []byte, event *pipeline.Event) []byte {
 	root := event.Root
 
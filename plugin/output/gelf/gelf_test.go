@@ -90,6 +90,32 @@ func TestFormatEvent(t *testing.T) {
 				"version":"1.1"
 			}`,
 		},
+		{
+			configJSON: `
+				{
+					"endpoint":"host:1000",
+					"host_field":"my_host_field",
+					"short_message_field":"my_short_message_field",
+					"timestamp_field":"my_timestamp_field",
+					"timestamp_field_format":"rfc3339nano",
+					"level_field":"my_level_field"
+				}`,
+			eventJSON: `
+				{
+					"my_host_field":"my_host_value",
+					"my_short_message_field":"my_short_message_value",
+					"my_timestamp_field":"2009-11-10T23:00:00.423141234Z",
+					"my_level_field":"fatal"
+				}`,
+			formattedJSON: `
+			{
+				"host":"my_host_value",
+				"short_message":"my_short_message_value",
+				"timestamp":1257894000.423141,
+				"level":6,
+				"version":"1.1"
+			}`,
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,196 @@
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/ozonru/file.d/pipeline"
+)
+
+const (
+	headerSourceID = "file_d_source_id"
+	headerOffset   = "file_d_offset"
+)
+
+// dedup skips events which have been produced before the restart but haven't been committed to the input.
+// Produced messages have the source and the offset of the event in the headers,
+// so the last produced offsets of sources are read back from the tail of the topic
+// when the topic is used for the first time.
+type dedup struct {
+	readTail func(topic string) (map[pipeline.SourceID]int64, error)
+
+	mu *sync.Mutex
+	// lastOffsets are the last produced offsets of sources by topics,
+	// the source is removed when its event with a greater offset is produced
+	lastOffsets map[string]map[pipeline.SourceID]int64
+}
+
+func newDedup(readTail func(topic string) (map[pipeline.SourceID]int64, error)) *dedup {
+	return &dedup{
+		readTail:    readTail,
+		mu:          &sync.Mutex{},
+		lastOffsets: make(map[string]map[pipeline.SourceID]int64),
+	}
+}
+
+// isProduced returns true if the event has been already produced to the topic.
+func (d *dedup) isProduced(topic string, event *pipeline.Event) (bool, error) {
+	if event.Offset <= 0 {
+		return false, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	offsets, has := d.lastOffsets[topic]
+	if !has {
+		var err error
+		offsets, err = d.readTail(topic)
+		if err != nil {
+			// the topic is marked as read anyway, so the output isn't stuck
+			d.lastOffsets[topic] = make(map[pipeline.SourceID]int64)
+			return false, err
+		}
+		d.lastOffsets[topic] = offsets
+	}
+
+	lastOffset, has := offsets[event.SourceID]
+	if !has {
+		return false, nil
+	}
+	if event.Offset <= lastOffset {
+		return true, nil
+	}
+
+	// offsets of the source only grow, so the rest of its events aren't produced
+	delete(offsets, event.SourceID)
+	return false, nil
+}
+
+// appendHeaders adds the source and the offset of the event to the message,
+// header values are appended to the buffer to avoid allocations.
+func appendHeaders(outBuf []byte, msg *sarama.ProducerMessage, event *pipeline.Event) []byte {
+	msg.Headers = msg.Headers[:0]
+	if event.Offset <= 0 {
+		return outBuf
+	}
+
+	start := len(outBuf)
+	outBuf = strconv.AppendUint(outBuf, uint64(event.SourceID), 10)
+	sourceID := outBuf[start:]
+
+	start = len(outBuf)
+	outBuf = strconv.AppendInt(outBuf, event.Offset, 10)
+	offset := outBuf[start:]
+
+	msg.Headers = append(msg.Headers,
+		sarama.RecordHeader{Key: []byte(headerSourceID), Value: sourceID},
+		sarama.RecordHeader{Key: []byte(headerOffset), Value: offset},
+	)
+
+	return outBuf
+}
+
+// collectOffsets updates the last offsets of sources by the message headers.
+func collectOffsets(offsets map[pipeline.SourceID]int64, headers []*sarama.RecordHeader) {
+	sourceID, offset := int64(-1), int64(-1)
+	for _, header := range headers {
+		switch string(header.Key) {
+		case headerSourceID:
+			val, err := strconv.ParseUint(string(header.Value), 10, 64)
+			if err != nil {
+				return
+			}
+			sourceID = int64(val)
+		case headerOffset:
+			val, err := strconv.ParseInt(string(header.Value), 10, 64)
+			if err != nil {
+				return
+			}
+			offset = val
+		}
+	}
+
+	if sourceID == -1 || offset == -1 {
+		return
+	}
+	if last, has := offsets[pipeline.SourceID(sourceID)]; !has || offset > last {
+		offsets[pipeline.SourceID(sourceID)] = offset
+	}
+}
+
+// readTail reads last `dedup_tail_size` messages of each partition of the topic.
+func (p *Plugin) readTail(topic string) (map[pipeline.SourceID]int64, error) {
+	client, err := sarama.NewClient(p.config.Brokers, p.newSaramaConfig())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = client.Close() }()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = consumer.Close() }()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[pipeline.SourceID]int64)
+	for _, partition := range partitions {
+		if err := p.readPartitionTail(client, consumer, topic, partition, offsets); err != nil {
+			return nil, err
+		}
+	}
+
+	p.logger.Infof("tail of topic %s is read, sources=%d", topic, len(offsets))
+
+	return offsets, nil
+}
+
+func (p *Plugin) readPartitionTail(client sarama.Client, consumer sarama.Consumer, topic string, partition int32, offsets map[pipeline.SourceID]int64) error {
+	newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+
+	from := newest - int64(p.config.DedupTailSize)
+	if from < oldest {
+		from = oldest
+	}
+	if from >= newest {
+		return nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(topic, partition, from)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = partitionConsumer.Close() }()
+
+	timer := time.NewTimer(p.config.DedupTimeout_)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-partitionConsumer.Messages():
+			collectOffsets(offsets, msg.Headers)
+			// offsets may have gaps, e.g. in compacted topics
+			if msg.Offset+1 >= newest {
+				return nil
+			}
+		case consumerErr := <-partitionConsumer.Errors():
+			return consumerErr.Err
+		case <-timer.C:
+			return fmt.Errorf("timeout reading partition %d", partition)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeadersRoundTrip(t *testing.T) {
+	offsets := make(map[pipeline.SourceID]int64)
+	for _, event := range []*pipeline.Event{
+		{SourceID: 1, Offset: 100},
+		{SourceID: 1, Offset: 200},
+		{SourceID: 2, Offset: 50},
+		{SourceID: 1, Offset: 150},
+		{SourceID: 3},
+	} {
+		msg := &sarama.ProducerMessage{}
+		appendHeaders(nil, msg, event)
+
+		headers := make([]*sarama.RecordHeader, 0, len(msg.Headers))
+		for i := range msg.Headers {
+			headers = append(headers, &msg.Headers[i])
+		}
+		collectOffsets(offsets, headers)
+	}
+
+	assert.Equal(t, map[pipeline.SourceID]int64{1: 200, 2: 50}, offsets, "wrong last offsets")
+}
+
+func TestDedup(t *testing.T) {
+	reads := 0
+	d := newDedup(func(topic string) (map[pipeline.SourceID]int64, error) {
+		reads++
+		if topic == "broken" {
+			return nil, errors.New("broker is down")
+		}
+		return map[pipeline.SourceID]int64{1: 200}, nil
+	})
+
+	isProduced := func(topic string, event *pipeline.Event) bool {
+		produced, _ := d.isProduced(topic, event)
+		return produced
+	}
+
+	assert.True(t, isProduced("logs", &pipeline.Event{SourceID: 1, Offset: 100}), "produced event isn't skipped")
+	assert.True(t, isProduced("logs", &pipeline.Event{SourceID: 1, Offset: 200}), "produced event isn't skipped")
+	assert.False(t, isProduced("logs", &pipeline.Event{SourceID: 2, Offset: 100}), "event of other source is skipped")
+	assert.False(t, isProduced("logs", &pipeline.Event{SourceID: 1}), "event without offset is skipped")
+	assert.False(t, isProduced("logs", &pipeline.Event{SourceID: 1, Offset: 300}), "new event is skipped")
+	assert.False(t, isProduced("logs", &pipeline.Event{SourceID: 1, Offset: 100}), "source isn't forgotten after new event")
+
+	_, err := d.isProduced("broken", &pipeline.Event{SourceID: 1, Offset: 100})
+	require.Error(t, err, "no error for broken topic")
+	assert.False(t, isProduced("broken", &pipeline.Event{SourceID: 1, Offset: 100}), "event of broken topic is skipped")
+
+	assert.Equal(t, 2, reads, "tail is read more than once per topic")
+}
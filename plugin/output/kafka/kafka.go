@@ -6,6 +6,7 @@ import (
 
 	"github.com/ozonru/file.d/cfg"
 	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
 	"go.uber.org/zap"
 
@@ -28,6 +29,8 @@ type Plugin struct {
 
 	producer sarama.SyncProducer
 	batcher  *pipeline.Batcher
+	dedup    *dedup
+	chaos    *netutil.Chaos
 }
 
 //! config-params
@@ -38,6 +41,12 @@ type Config struct {
 	//> List of kafka brokers to write to.
 	Brokers []string `json:"brokers" required:"true"` //*
 
+	//> @3@4@5@6
+	//>
+	//> A network interface to connect from, e.g. `eth1`. Connections are made from its address of the family of the broker address.
+	//> IPv6 and IPv4 addresses of the broker host are tried in parallel. If it's empty, the interface is chosen by the routing table.
+	SourceInterface string `json:"source_interface"` //*
+
 	//> @3@4@5@6
 	//> 
 	//> The default topic name if nothing will be found in the event field or `should_use_topic_field` isn't set.
@@ -70,6 +79,26 @@ type Config struct {
 	//> After this timeout the batch will be sent even if batch isn't full.
 	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` //*
 	BatchFlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> If set, the source and the offset of the event are added to `file_d_source_id` and `file_d_offset` headers of the message.
+	//> When a topic is used for the first time after the start, the plugin reads back the tail of the topic
+	//> and skips events which have been already produced, e.g. if `file.d` has been killed before the input saved offsets.
+	//> Only events with offsets are deduplicated, e.g. ones from the `file` or the `kafka` input.
+	Dedup bool `json:"dedup" default:"false"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How many last messages of each partition are read back to find produced events.
+	//> It should cover the number of events which may be produced but not committed, i.e. about `workers_count*batch_size`.
+	DedupTailSize int `json:"dedup_tail_size" default:"10000"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The timeout of reading back the tail of a partition. If it can't be read, events are produced without deduplication.
+	DedupTimeout  cfg.Duration `json:"dedup_timeout" default:"30s" parse:"duration"` //*
+	DedupTimeout_ time.Duration
 }
 
 func init() {
@@ -88,10 +117,14 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.logger = params.Logger
 	p.avgLogSize = params.PipelineSettings.AvgLogSize
 	p.controller = params.Controller
+	p.chaos = params.Chaos
 
 	p.logger.Infof("workers count=%d, batch size=%d", p.config.WorkersCount_, p.config.BatchSize_)
 
 	p.producer = p.newProducer()
+	if p.config.Dedup {
+		p.dedup = newDedup(p.readTail)
+	}
 	p.batcher = pipeline.NewBatcher(
 		params.PipelineName,
 		"kafka",
@@ -126,9 +159,9 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 
 	outBuf := data.outBuf[:0]
 	start := 0
-	for i, event := range batch.Events {
-		outBuf, start = event.Encode(outBuf)
-
+	i := 0
+	skipped := 0
+	for _, event := range batch.Events {
 		topic := p.config.DefaultTopic
 		if p.config.UseTopicField {
 			fieldValue := event.Root.Dig(p.config.TopicField).AsString()
@@ -137,20 +170,45 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 			}
 		}
 
+		if p.dedup != nil {
+			isProduced, err := p.dedup.isProduced(topic, event)
+			if err != nil {
+				p.logger.Errorf("can't read tail of topic %s, events are produced without deduplication: %s", topic, err.Error())
+			}
+			if isProduced {
+				skipped++
+				continue
+			}
+		}
+
+		outBuf, start = event.Encode(outBuf)
+
 		if data.messages[i] == nil {
 			data.messages[i] = &sarama.ProducerMessage{}
 		}
 		data.messages[i].Value = outBuf[start:]
-		
+
 		// copy topic from json, to temporary out buffer to avoid event reusing issues
 		start = len(outBuf)
 		outBuf = append(outBuf, topic...)
 		data.messages[i].Topic = pipeline.ByteToStringUnsafe(outBuf[start:])
+
+		if p.dedup != nil {
+			outBuf = appendHeaders(outBuf, data.messages[i], event)
+		}
+		i++
 	}
 
 	data.outBuf = outBuf
 
-	err := p.producer.SendMessages(data.messages[:len(batch.Events)])
+	if skipped != 0 {
+		p.logger.Infof("%d events are skipped since they've been already produced", skipped)
+	}
+	if i == 0 {
+		return
+	}
+
+	err := p.producer.SendMessages(data.messages[:i])
 	if err != nil {
 		errs := err.(sarama.ProducerErrors)
 		for _, e := range errs {
@@ -158,6 +216,7 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 		}
 
 		p.controller.Error("some events from batch isn't written")
+		batch.Fail(err)
 	}
 }
 
@@ -165,8 +224,24 @@ func (p *Plugin) Stop() {
 	p.batcher.Stop()
 }
 
-func (p *Plugin) newProducer() sarama.SyncProducer {
+// newSaramaConfig returns the config of kafka clients, brokers are resolved by the shared resolver
+// and connections are made from the source interface if it's set.
+func (p *Plugin) newSaramaConfig() *sarama.Config {
 	config := sarama.NewConfig()
+	dialer, err := netutil.NewDialer(config.Net.DialTimeout, p.config.SourceInterface)
+	if err != nil {
+		p.logger.Fatalf("can't create dialer: %s", err.Error())
+	}
+	dialer.SetChaos(p.chaos)
+	// sarama uses the custom dialer only as the proxy one
+	config.Net.Proxy.Enable = true
+	config.Net.Proxy.Dialer = dialer
+
+	return config
+}
+
+func (p *Plugin) newProducer() sarama.SyncProducer {
+	config := p.newSaramaConfig()
 	config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
 	config.Producer.Flush.Messages = p.config.BatchSize_
 	// kafka plugin itself cares for flush frequency, but we are using batcher so disable it
@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -142,7 +143,7 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	anyPlugin, _ := file.Factory()
 	p.outPlugin = anyPlugin.(*file.Plugin)
 
-	p.outPlugin.SealUpCallback = p.addFileJob
+	p.outPlugin.SealUpHook = file.SealUpHookFunc(p.addFileJob)
 
 	p.outPlugin.Start(&p.config.FileConfig, params)
 	p.uploadExistingFiles()
@@ -204,8 +205,12 @@ func (p *Plugin) getSortFunc(files []string) func(i, j int) bool {
 	}
 }
 
-func (p *Plugin) addFileJob(fileName string) {
-	p.compressCh <- fileName
+func (p *Plugin) addFileJob(ctx context.Context, sealed *file.SealedFile) {
+	select {
+	case p.compressCh <- sealed.Name:
+	case <-ctx.Done():
+		p.logger.Warnf("file %s isn't uploaded, it will be uploaded after restart", sealed.Name)
+	}
 }
 
 // uploadWork uploads compressed files from channel to s3 and then delete compressed file
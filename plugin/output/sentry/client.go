@@ -0,0 +1,135 @@
+package sentry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	clientName = "file.d"
+	// flushCheckInterval is how often the flush checks if requests are finished
+	flushCheckInterval = 10 * time.Millisecond
+)
+
+// errRateLimited is returned when Sentry asks to stop sending events for a while.
+var errRateLimited = errors.New("rate limited by sentry")
+
+// storeClient is a minimal client of the Sentry store API,
+// see https://develop.sentry.dev/sdk/store/.
+type storeClient struct {
+	client    *http.Client
+	storeURL  string
+	publicKey string
+	secretKey string
+	// pending is the number of requests which are being sent
+	pending atomic.Int32
+}
+
+// newStoreClient parses the DSN, e.g. `https://<public_key>@sentry.example.com/<project_id>`.
+func newStoreClient(client *http.Client, dsn string) (*storeClient, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse dsn: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("wrong dsn scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("dsn doesn't contain public key")
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	pos := strings.LastIndexByte(path, '/')
+	projectID := path[pos+1:]
+	if projectID == "" {
+		return nil, fmt.Errorf("dsn doesn't contain project id")
+	}
+
+	secretKey, _ := u.User.Password()
+
+	return &storeClient{
+		client:    client,
+		storeURL:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:pos], projectID),
+		publicKey: u.User.Username(),
+		secretKey: secretKey,
+	}, nil
+}
+
+// send returns errRateLimited and the delay if Sentry rejects the event due to rate limits.
+func (c *storeClient) send(body []byte) (time.Duration, error) {
+	c.pending.Inc()
+	defer c.pending.Dec()
+
+	req, err := http.NewRequest(http.MethodPost, c.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("can't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", c.auth())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("can't send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return retryAfter(resp.Header.Get("Retry-After")), errRateLimited
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("wrong response code %d: %s", resp.StatusCode, respBody)
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return 0, nil
+}
+
+// flush waits for requests which are being sent, it returns false if they aren't finished within the timeout.
+// Idle connections are closed afterwards.
+func (c *storeClient) flush(timeout time.Duration) bool {
+	defer c.client.CloseIdleConnections()
+
+	deadline := time.Now().Add(timeout)
+	for c.pending.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(flushCheckInterval)
+	}
+
+	return true
+}
+
+func (c *storeClient) auth() string {
+	auth := "Sentry sentry_version=7, sentry_client=" + clientName + ", sentry_key=" + c.publicKey
+	if c.secretKey != "" {
+		auth += ", sentry_secret=" + c.secretKey
+	}
+
+	return auth
+}
+
+// retryAfter parses the delay in seconds, Sentry defaults it to a minute.
+func retryAfter(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return time.Minute
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
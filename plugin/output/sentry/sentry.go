@@ -0,0 +1,421 @@
+// Package sentry is an output plugin that sends events to Sentry as issues.
+package sentry
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/pipeline"
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It sends events matching `match_fields` to Sentry, so they become issues. Other events are skipped.
+Match conditions work like the ones of actions: values starting with `/` are regular expressions.
+
+Events are grouped into issues by `fingerprint_fields`, if it's empty, Sentry groups them by the message.
+The level is taken from the `level_field`, the whole event is attached as the `event` extra data.
+
+At most `rate_limit` events are sent per `rate_limit_interval`, the rest is dropped.
+Events are dropped as well while Sentry asks to back off (`429` response) and after `retries` failed attempts,
+so Sentry never blocks the pipeline for long. Events dropped after retries fail the batch, so it's retried in the `at_least_once` delivery mode.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: sentry
+      dsn: https://public_key@sentry.example.com/42
+      match_fields:
+        level: error
+        stacktrace: /.+/
+      fingerprint_fields: service,error_type
+      tags_fields: service,k8s_namespace
+    ...
+```
+}*/
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+	client     *storeClient
+
+	conditions        pipeline.MatchConditions
+	matchMode         pipeline.MatchMode
+	fingerprintFields [][]string
+	tagsFields        [][]string
+
+	mu            *sync.Mutex
+	windowStart   time.Time
+	windowCount   int
+	droppedCount  int
+	disabledUntil time.Time
+
+	isStopped atomic.Bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> Sentry DSN in the following format: `SCHEMA://PUBLIC_KEY@HOST/PROJECT_ID`.
+	DSN string `json:"dsn" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Conditions which events should match to be sent. Keys are the event fields, values are strings or regular expressions.
+	MatchFields map[string]string `json:"match_fields"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How to combine `match_fields` conditions.
+	MatchMode string `json:"match_mode" default:"and" options:"and|or"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The event field which is used as the issue message.
+	MessageField  cfg.FieldSelector `json:"message_field" default:"message" parse:"selector"` //*
+	MessageField_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The event field which is used as the issue level. If it's missing or unknown, the `error` level is used.
+	LevelField  cfg.FieldSelector `json:"level_field" default:"level" parse:"selector"` //*
+	LevelField_ []string
+
+	//> @3@4@5@6
+	//>
+	//> The event field with the stacktrace, it's attached as the `stacktrace` extra data.
+	StacktraceField  cfg.FieldSelector `json:"stacktrace_field" default:"stacktrace" parse:"selector"` //*
+	StacktraceField_ []string
+
+	//> @3@4@5@6
+	//>
+	//> A comma-separated list of event fields which values form the issue fingerprint. Missing fields are replaced with `not_set`.
+	FingerprintFields []string `json:"fingerprint_fields" slice:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A comma-separated list of event fields which are sent as issue tags.
+	TagsFields []string `json:"tags_fields" slice:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The environment of the issues.
+	Environment string `json:"environment"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The release of the issues.
+	Release string `json:"release"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A maximum quantity of events to send per `rate_limit_interval`.
+	RateLimit int `json:"rate_limit" default:"100"` //*
+
+	//> @3@4@5@6
+	//>
+	//> An interval of the rate limit.
+	RateLimitInterval  cfg.Duration `json:"rate_limit_interval" default:"1m" parse:"duration"` //*
+	RateLimitInterval_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> How many times to retry the failed event before dropping it.
+	Retries int `json:"retries" default:"3"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Retries of the failed event are delayed by this interval.
+	RetryInterval  cfg.Duration `json:"retry_interval" default:"1s" parse:"duration"` //*
+	RetryInterval_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Client timeout when sends requests to Sentry.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` //*
+	RequestTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> How long the stopping plugin waits for events which are being sent.
+	FlushTimeout  cfg.Duration `json:"flush_timeout" default:"5s" parse:"duration"` //*
+	FlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs" parse:"expression"` //*
+	WorkersCount_ int
+
+	//> @3@4@5@6
+	//>
+	//> A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` //*
+	BatchSize_ int
+
+	//> @3@4@5@6
+	//>
+	//> After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"1s" parse:"duration"` //*
+	BatchFlushTimeout_ time.Duration
+}
+
+// sentryEvent is a subset of the Sentry event payload,
+// see https://develop.sentry.dev/sdk/event-payloads/.
+type sentryEvent struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   float64                `json:"timestamp"`
+	Platform    string                 `json:"platform"`
+	Logger      string                 `json:"logger"`
+	Level       string                 `json:"level"`
+	Message     string                 `json:"message,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Fingerprint []string               `json:"fingerprint,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra"`
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    "sentry",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.mu = &sync.Mutex{}
+
+	var err error
+	p.client, err = newStoreClient(&http.Client{Timeout: p.config.RequestTimeout_}, p.config.DSN)
+	if err != nil {
+		p.logger.Fatalf("wrong sentry dsn: %s", err.Error())
+	}
+
+	p.conditions, err = pipeline.NewMatchConditions(p.config.MatchFields)
+	if err != nil {
+		p.logger.Fatalf("wrong match_fields: %s", err.Error())
+	}
+	p.matchMode = pipeline.MatchModeAnd
+	if p.config.MatchMode == "or" {
+		p.matchMode = pipeline.MatchModeOr
+	}
+
+	for _, field := range p.config.FingerprintFields {
+		p.fingerprintFields = append(p.fingerprintFields, cfg.ParseFieldSelector(field))
+	}
+	for _, field := range p.config.TagsFields {
+		p.tagsFields = append(p.tagsFields, cfg.ParseFieldSelector(field))
+	}
+
+	p.batcher = pipeline.NewBatcher(
+		params.PipelineName,
+		"sentry",
+		p.out,
+		p.maintenance,
+		p.controller,
+		p.config.WorkersCount_,
+		p.config.BatchSize_,
+		p.config.BatchFlushTimeout_,
+		0,
+	)
+	p.batcher.Start()
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+
+	if !p.client.flush(p.config.FlushTimeout_) {
+		p.logger.Warnf("sentry events aren't sent within the flush timeout %s, they're dropped", p.config.FlushTimeout_)
+	}
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) {
+	for _, event := range batch.Events {
+		if !p.isMatch(event) {
+			continue
+		}
+
+		if !p.allow() {
+			continue
+		}
+
+		body, err := json.Marshal(p.newSentryEvent(event))
+		if err != nil {
+			p.logger.Errorf("can't marshal sentry event: %s", err.Error())
+			continue
+		}
+
+		if err := p.send(body); err != nil {
+			batch.Fail(err)
+		}
+	}
+}
+
+// send retries the event, the error is returned if the event is dropped after retries or on the stop.
+// Events dropped by the back off aren't failed.
+func (p *Plugin) send(body []byte) error {
+	for attempt := 0; ; attempt++ {
+		delay, err := p.client.send(body)
+		if err == nil {
+			return nil
+		}
+
+		if err == errRateLimited {
+			p.backOff(delay)
+			return nil
+		}
+
+		if attempt >= p.config.Retries {
+			p.logger.Errorf("can't send event to sentry, it's dropped: %s", err.Error())
+			return err
+		}
+
+		p.logger.Errorf("can't send event to sentry: %s", err.Error())
+		// the client is flushed by the stop, so the event isn't retried
+		if p.isStopped.Load() {
+			return err
+		}
+		time.Sleep(p.config.RetryInterval_)
+	}
+}
+
+func (p *Plugin) newSentryEvent(event *pipeline.Event) *sentryEvent {
+	now := time.Now()
+	id := uuid.NewV4()
+	e := &sentryEvent{
+		EventID:     hex.EncodeToString(id.Bytes()),
+		Timestamp:   float64(now.UnixNano()) / float64(time.Second),
+		Platform:    "other",
+		Logger:      clientName,
+		Level:       sentryLevel(event.Root.Dig(p.config.LevelField_...).AsString()),
+		Message:     event.Root.Dig(p.config.MessageField_...).AsString(),
+		Environment: p.config.Environment,
+		Release:     p.config.Release,
+		Extra:       map[string]interface{}{"event": json.RawMessage(event.Root.EncodeToByte())},
+	}
+
+	if stacktrace := event.Root.Dig(p.config.StacktraceField_...); stacktrace != nil {
+		e.Extra["stacktrace"] = stacktrace.AsString()
+	}
+
+	for _, field := range p.fingerprintFields {
+		value := event.Root.Dig(field...).AsString()
+		if value == "" {
+			value = "not_set"
+		}
+		e.Fingerprint = append(e.Fingerprint, value)
+	}
+
+	for i, field := range p.tagsFields {
+		node := event.Root.Dig(field...)
+		if node == nil {
+			continue
+		}
+		if e.Tags == nil {
+			e.Tags = make(map[string]string, len(p.tagsFields))
+		}
+		e.Tags[p.config.TagsFields[i]] = node.AsString()
+	}
+
+	return e
+}
+
+func (p *Plugin) isMatch(event *pipeline.Event) bool {
+	if len(p.conditions) == 0 {
+		return true
+	}
+
+	return p.conditions.IsMatch(event, p.matchMode)
+}
+
+// allow applies the rate limit and the back off requested by Sentry.
+func (p *Plugin) allow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(p.disabledUntil) {
+		p.droppedCount++
+		return false
+	}
+
+	if now.Sub(p.windowStart) >= p.config.RateLimitInterval_ {
+		if p.droppedCount > 0 {
+			p.logger.Warnf("%d events aren't sent to sentry due to rate limits", p.droppedCount)
+		}
+		p.windowStart = now
+		p.windowCount = 0
+		p.droppedCount = 0
+	}
+
+	if p.windowCount >= p.config.RateLimit {
+		p.droppedCount++
+		return false
+	}
+	p.windowCount++
+
+	return true
+}
+
+func (p *Plugin) backOff(delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.droppedCount++
+	p.disabledUntil = time.Now().Add(delay)
+	p.logger.Warnf("sentry asks to back off for %s", delay)
+}
+
+func (p *Plugin) maintenance(_ *pipeline.WorkerData) {}
+
+// sentryLevel maps the syslog severity to the Sentry level.
+func sentryLevel(level string) string {
+	// names of application loggers aren't syslog severities
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "fatal", "panic":
+		return "fatal"
+	case "trace":
+		return "debug"
+	}
+
+	switch pipeline.ParseLevelStrict(level) {
+	case 0, 1, 2:
+		return "fatal"
+	case 4:
+		return "warning"
+	case 5, 6:
+		return "info"
+	case 7:
+		return "debug"
+	default:
+		return "error"
+	}
+}
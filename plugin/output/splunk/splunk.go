@@ -5,15 +5,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/ozonru/file.d/auth"
 	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/compression"
 	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/netutil"
 	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/signing"
+	uuid "github.com/satori/go.uuid"
 	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -28,6 +36,17 @@ type Plugin struct {
 	batcher        *pipeline.Batcher
 	controller     pipeline.OutputPluginController
 	requestTimeout time.Duration
+	headers        *pipeline.HeaderTemplates
+	dialer         *netutil.Dialer
+	transport      *http.Transport
+	signer         signing.Signer
+	auth           *auth.Source
+	// compression is nil if bodies aren't compressed
+	compression *compression.Negotiator
+	// retrying is the number of workers retrying requests, the output signals backpressure while it's positive
+	retrying   int
+	retryingMu *sync.Mutex
+	isStopped  atomic.Bool
 }
 
 //! config-params
@@ -40,8 +59,26 @@ type Config struct {
 
 	//> @3@4@5@6
 	//>
-	//> Token for an authentication for a HEC endpoint.
-	Token string `json:"token" required:"true"` //*
+	//> Token for an authentication for a HEC endpoint. It's required if `auth` isn't set.
+	Token string `json:"token"` //*
+
+	//> @3@4@5@6
+	//>
+	//> The event field with the time of the event, it's either a RFC3339 string or a number of seconds since the epoch.
+	//> It's sent as HEC `time` in seconds with milliseconds, so backfilled events are indexed at their own time rather than the receipt time.
+	//> Events without the field or with a wrong value are indexed at the receipt time.
+	TimeField  cfg.FieldSelector `json:"time_field" parse:"selector"` //*
+	TimeField_ []string
+
+	//> @3@4@5@6
+	//>
+	//> HEC channel id sent in the `X-Splunk-Request-Channel` header, it's required if indexer acknowledgment is enabled.
+	Channel string `json:"channel"` //*
+
+	//> @3@4@5@6
+	//>
+	//> If set, a new random channel id is sent with each batch instead of `channel`, retries of the batch use the same id.
+	ChannelPerBatch bool `json:"channel_per_batch"` //*
 
 	//> @3@4@5@6
 	//>
@@ -55,6 +92,20 @@ type Config struct {
 	RequestTimeout  cfg.Duration `json:"request_timeout" default:"1s" parse:"duration"` //*
 	RequestTimeout_ time.Duration
 
+	//> @3@4@5@6
+	//>
+	//> A network interface to connect from, e.g. `eth1`. Connections are made from its address of the family of the endpoint address.
+	//> IPv6 and IPv4 addresses of the endpoint host are tried in parallel. If it's empty, the interface is chosen by the routing table.
+	SourceInterface string `json:"source_interface"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Compression of request bodies: `none`, `gzip`, `zstd` or `auto`. In the `auto` mode zstd is preferred,
+	//> if the server responds with `415`, or with `400` mentioning the encoding, the output falls back to gzip and then to no compression.
+	//> The preferred codec is tried again in an hour. The negotiated codec and sizes of bodies before and after compression
+	//> are exposed as `file_d_output_compression_codec`, `file_d_output_compression_raw_bytes_total` and `file_d_output_compression_compressed_bytes_total` metrics.
+	Compression string `json:"compression" default:"none" options:"none|gzip|zstd|auto"` //*
+
 	//> @3@4@5@6
 	//>
 	//> A maximum quantity of events to pack into one batch.
@@ -66,8 +117,41 @@ type Config struct {
 	//> After this timeout the batch will be sent even if batch isn't completed.
 	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` //*
 	BatchFlushTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Additional request headers. Values may contain event fields, e.g. `X-Scope-OrgID: "{{.tenant}}"`,
+	//> in this case the batch is split into requests by the header values. Headers with empty values aren't sent.
+	Headers map[string]string `json:"headers"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Signing of requests, e.g. `{type: hmac, hmac_key: secret}`. The object has the following fields:
+	//> * `type` – `aws_sigv4` or `hmac`
+	//> * `aws_region`, `aws_service` (`es` by default), `aws_access_key_id`, `aws_secret_access_key` – for `aws_sigv4`, the default credentials chain is used if the key id is empty
+	//> * `hmac_key`, `hmac_header` (`X-Signature` by default), `hmac_timestamp_header` (`X-Timestamp` by default), `hmac_algorithm` (`sha256` or `sha512`) – for `hmac`,
+	//> the hex encoded signature of `<unix_timestamp>\n<method>\n<path_with_query>\n<body>` is set to the header
+	Signing *signing.Config `json:"signing"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Authorization with short-lived tokens, e.g. `{type: oauth2, token_url: https://sso/token, client_id: file-d, client_secret: secret}`.
+	//> The token is refreshed before it expires, if the endpoint responds with `401` the token is fetched again and the request is retried once.
+	//> The object has the following fields:
+	//> * `type` – `oauth2` for the client credentials flow or `token_file` for the token rotated in the file, the file is read again once it's modified
+	//> * `token_url`, `client_id`, `client_secret`, `scopes` – for `oauth2`
+	//> * `token_file` – for `token_file`
+	//> * `refresh_before` – how long before the expiry the token is refreshed, `1m` by default
+	//> * `header` (`Authorization` by default), `scheme` (`Bearer` by default) – the token is set to the header as `<scheme> <token>`
+	Auth *auth.Config `json:"auth"` //*
 }
 
+// errEncodingRejected means the batch should be sent again with another codec.
+var errEncodingRejected = errors.New("encoding is rejected")
+
+// errStopped fails the batch which isn't sent before the stop.
+var errStopped = errors.New("output is stopped")
+
 type data struct {
 	outBuf []byte
 }
@@ -88,6 +172,45 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.logger = params.Logger
 	p.avgLogSize = params.PipelineSettings.AvgLogSize
 	p.config = config.(*Config)
+	p.retryingMu = &sync.Mutex{}
+
+	headers, err := pipeline.ParseHeaderTemplates(p.config.Headers)
+	if err != nil {
+		p.logger.Fatalf("wrong headers: %s", err.Error())
+	}
+	p.headers = headers
+
+	p.dialer, err = netutil.NewDialer(p.config.RequestTimeout_, p.config.SourceInterface)
+	if err != nil {
+		p.logger.Fatalf("can't create dialer: %s", err.Error())
+	}
+	p.dialer.SetChaos(params.Chaos)
+	p.transport = p.newTransport()
+
+	if p.config.Signing != nil {
+		p.signer, err = signing.New(p.config.Signing)
+		if err != nil {
+			p.logger.Fatalf("wrong signing: %s", err.Error())
+		}
+	}
+
+	if p.config.Compression != compression.CodecNone {
+		p.compression, err = compression.New(p.config.Compression, params.MetricsRegistry, params.PipelineName, "splunk")
+		if err != nil {
+			p.logger.Fatalf("wrong compression: %s", err.Error())
+		}
+	}
+
+	if p.config.Token == "" && p.config.Auth == nil {
+		p.logger.Fatalf("token or auth should be set")
+	}
+	if p.config.Auth != nil {
+		tokenClient := &http.Client{Timeout: p.config.RequestTimeout_, Transport: netutil.NewTransport(p.dialer)}
+		p.auth, err = auth.New(p.config.Auth, tokenClient)
+		if err != nil {
+			p.logger.Fatalf("wrong auth: %s", err.Error())
+		}
+	}
 
 	p.batcher = pipeline.NewBatcher(
 		params.PipelineName,
@@ -104,6 +227,9 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 }
 
 func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+	p.transport.CloseIdleConnections()
 }
 
 func (p *Plugin) Out(event *pipeline.Event) {
@@ -123,18 +249,50 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgLogSize)
 	}
 
-	outBuf := data.outBuf[:0]
-	for _, event := range batch.Events {
-		root := insaneJSON.Spawn()
-		root.AddField("event").MutateToNode(event.Root.Node)
-		outBuf = root.Encode(outBuf)
+	for _, group := range p.headers.Partition(batch.Events) {
+		outBuf := data.outBuf[:0]
+		for _, event := range group.Events {
+			outBuf = p.appendEvent(outBuf, event)
+		}
+		data.outBuf = outBuf
+
+		p.sendBatch(outBuf, group.Header, p.batchChannel(), batch)
 	}
-	data.outBuf = outBuf
+}
+
+// sendBatch retries the request until it succeeds or the batch is expired.
+func (p *Plugin) sendBatch(outBuf []byte, header http.Header, channel string, batch *pipeline.Batch) {
+	retrying := false
+	defer func() {
+		if retrying {
+			p.setRetrying(false)
+		}
+	}()
 
-	for {
-		err := p.send(outBuf, p.config.RequestTimeout_)
+	payload, codec := p.compression.Encode(outBuf)
+	for attempt := 0; ; attempt++ {
+		// expired events are shed by the batcher instead of retrying
+		if attempt > 0 && batch.IsExpired() {
+			break
+		}
+		// the batch is given up on the stop, so it isn't committed in the at least once delivery mode
+		if attempt > 0 && p.isStopped.Load() {
+			batch.Fail(errStopped)
+			break
+		}
+
+		err := p.send(payload, codec, header, channel, p.config.RequestTimeout_)
+		if errors.Is(err, errEncodingRejected) {
+			p.logger.Warnf("%s encoding is rejected by splunk address=%s, trying %s", codec, p.config.Endpoint, p.compression.Codec())
+			payload, codec = p.compression.Encode(outBuf)
+			continue
+		}
 		if err != nil {
 			p.logger.Errorf("can't send data to splunk address=%s: %s", p.config.Endpoint, err.Error())
+			if !retrying {
+				retrying = true
+				p.setRetrying(true)
+			}
 			time.Sleep(time.Second)
 
 			continue
@@ -144,16 +302,77 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 	}
 }
 
+// setRetrying counts retrying workers, the output is saturated while any worker is retrying.
+func (p *Plugin) setRetrying(retrying bool) {
+	p.retryingMu.Lock()
+	defer p.retryingMu.Unlock()
+
+	if retrying {
+		p.retrying++
+		if p.retrying == 1 {
+			p.controller.Backpressure(true)
+		}
+		return
+	}
+
+	p.retrying--
+	if p.retrying == 0 {
+		p.controller.Backpressure(false)
+	}
+}
+
+// PreviewPayload renders the HEC request body for the events.
+func (p *Plugin) PreviewPayload(events []*pipeline.Event) []byte {
+	outBuf := make([]byte, 0)
+	for _, event := range events {
+		outBuf = p.appendEvent(outBuf, event)
+	}
+
+	return outBuf
+}
+
+// appendEvent wraps the event into the HEC envelope.
+func (p *Plugin) appendEvent(outBuf []byte, event *pipeline.Event) []byte {
+	root := insaneJSON.Spawn()
+	if len(p.config.TimeField_) != 0 {
+		if ts, ok := pipeline.EventTime(event, p.config.TimeField_); ok {
+			// HEC expects seconds with milliseconds
+			root.AddField("time").MutateToFloat(float64(ts.UnixNano()/int64(time.Millisecond)) / 1000)
+		}
+	}
+	root.AddField("event").MutateToNode(event.Root.Node)
+	return root.Encode(outBuf)
+}
+
+// batchChannel returns the HEC channel id for the next batch, it's empty if channels aren't used.
+func (p *Plugin) batchChannel() string {
+	if p.config.ChannelPerBatch {
+		return uuid.NewV4().String()
+	}
+
+	return p.config.Channel
+}
+
 func (p *Plugin) maintenance(workerData *pipeline.WorkerData) {}
 
-func (p *Plugin) send(data []byte, timeout time.Duration) error {
-	c := http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+// newTransport returns the transport shared by workers, so connections to the endpoint are reused.
+func (p *Plugin) newTransport() *http.Transport {
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
 		},
+		DialContext: p.dialer.DialContext,
+	}
+}
+
+func (p *Plugin) send(data []byte, codec string, header http.Header, channel string, timeout time.Duration) error {
+	var transport http.RoundTripper = p.transport
+	if p.auth != nil {
+		transport = auth.NewTransport(transport, p.auth)
+	}
+	c := http.Client{
+		Timeout:   timeout,
+		Transport: transport,
 	}
 
 	r := bytes.NewReader(data)
@@ -162,9 +381,26 @@ func (p *Plugin) send(data []byte, timeout time.Duration) error {
 		return fmt.Errorf("can't create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Splunk "+p.config.Token)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if p.config.Token != "" {
+		req.Header.Set("Authorization", "Splunk "+p.config.Token)
+	}
+	if channel != "" {
+		req.Header.Set("X-Splunk-Request-Channel", channel)
+	}
+	compression.SetHeader(req, codec)
+	if p.signer != nil {
+		if err := p.signer.Sign(req, data); err != nil {
+			return fmt.Errorf("can't sign request: %w", err)
+		}
+	}
+
 	resp, err := c.Do(req)
 	if err != nil {
+		// the endpoint may have moved to another address
+		p.dialer.ResetHost(&c, req.URL.Host)
 		return fmt.Errorf("can't send request: %w", err)
 	}
 	defer resp.Body.Close()
@@ -174,6 +410,10 @@ func (p *Plugin) send(data []byte, timeout time.Duration) error {
 		return fmt.Errorf("can't read response: %w", err)
 	}
 
+	if p.compression.Reject(codec, resp.StatusCode, b) {
+		return fmt.Errorf("%w: status=%d, body=%s", errEncodingRejected, resp.StatusCode, b)
+	}
+
 	root, err := insaneJSON.DecodeBytes(b)
 	if err != nil {
 		return fmt.Errorf("can't decode response: %w", err)
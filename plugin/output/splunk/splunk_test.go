@@ -0,0 +1,108 @@
+package splunk
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/netutil"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+func newEvent(t *testing.T, json string) *pipeline.Event {
+	root, err := insaneJSON.DecodeString(json)
+	require.NoError(t, err)
+	t.Cleanup(func() { insaneJSON.Release(root) })
+
+	return &pipeline.Event{Root: root}
+}
+
+func TestAppendEventTime(t *testing.T) {
+	p := &Plugin{config: &Config{TimeField_: cfg.ParseFieldSelector("ts")}}
+
+	out := p.appendEvent(nil, newEvent(t, `{"ts":"2021-05-01T10:00:00.123456Z","message":"a"}`))
+	assert.JSONEq(t, `{"time":1619863200.123,"event":{"ts":"2021-05-01T10:00:00.123456Z","message":"a"}}`, string(out))
+
+	out = p.appendEvent(nil, newEvent(t, `{"ts":1619863200.5}`))
+	assert.JSONEq(t, `{"time":1619863200.5,"event":{"ts":1619863200.5}}`, string(out))
+
+	out = p.appendEvent(nil, newEvent(t, `{"ts":"yesterday"}`))
+	assert.JSONEq(t, `{"event":{"ts":"yesterday"}}`, string(out), "wrong time is sent")
+}
+
+func TestSendChannel(t *testing.T) {
+	channels := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		channels = append(channels, r.Header.Get("X-Splunk-Request-Channel"))
+		_, _ = w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+
+	dialer, err := netutil.NewDialer(time.Second, "")
+	require.NoError(t, err)
+	p := &Plugin{config: &Config{Endpoint: server.URL, Token: "token", Channel: "fixed"}, dialer: dialer}
+	p.transport = p.newTransport()
+
+	require.NoError(t, p.send([]byte(`{"event":{}}`), "none", nil, p.batchChannel(), time.Second))
+
+	p.config.ChannelPerBatch = true
+	require.NoError(t, p.send([]byte(`{"event":{}}`), "none", nil, p.batchChannel(), time.Second))
+	require.NoError(t, p.send([]byte(`{"event":{}}`), "none", nil, p.batchChannel(), time.Second))
+
+	require.Equal(t, 3, len(channels))
+	assert.Equal(t, "fixed", channels[0], "wrong channel")
+	assert.Len(t, channels[1], 36, "channel isn't generated")
+	assert.NotEqual(t, channels[1], channels[2], "channel isn't generated per batch")
+}
+
+type fakeController struct{}
+
+func (c *fakeController) Commit(_ *pipeline.Event) {}
+func (c *fakeController) Error(_ string)           {}
+func (c *fakeController) Backpressure(bool)        {}
+
+func TestSendBatchStop(t *testing.T) {
+	p := &Plugin{
+		logger:     zap.NewNop().Sugar(),
+		controller: &fakeController{},
+		retryingMu: &sync.Mutex{},
+	}
+
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		requests.Inc()
+		p.isStopped.Store(true)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dialer, err := netutil.NewDialer(time.Second, "")
+	require.NoError(t, err)
+	p.config = &Config{Endpoint: server.URL, Token: "token"}
+	p.dialer = dialer
+	p.transport = p.newTransport()
+
+	done := make(chan struct{})
+	go func() {
+		p.sendBatch([]byte(`{"event":{}}`), nil, "", &pipeline.Batch{})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch is retried after the stop")
+	}
+	assert.Equal(t, int32(1), requests.Load(), "wrong requests count")
+}
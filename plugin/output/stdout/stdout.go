@@ -5,6 +5,7 @@ import (
 
 	"github.com/ozonru/file.d/fd"
 	"github.com/ozonru/file.d/pipeline"
+	"go.uber.org/zap"
 )
 
 /*{ introduction
@@ -12,10 +13,25 @@ It writes events to stdout(also known as console).
 }*/
 type Plugin struct {
 	controller pipeline.OutputPluginController
+	logger     *zap.SugaredLogger
+	template   *pipeline.EventTemplate
 	outFn      func(event *pipeline.Event)
 }
 
+//! config-params
+//^ config-params
 type Config struct {
+	//> @3@4@5@6
+	//>
+	//> Format of the output lines: `json` writes events as is, `template` renders them using `template` param.
+	Format string `json:"format" default:"json" options:"json|template"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Template for the output lines if `format` is `template`. Use `{{.field}}` placeholders to insert event fields,
+	//> nested fields are separated by dots, e.g. `{{.time}} {{.level}} {{.k8s.pod}}: {{.message}}`.
+	//> Missing fields are rendered as empty strings.
+	Template string `json:"template" default:"{{.time}} {{.level}} {{.message}}"` //*
 }
 
 func init() {
@@ -29,14 +45,28 @@ func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 	return &Plugin{}, &Config{}
 }
 
-func (p *Plugin) Start(_ pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
 	p.controller = params.Controller
+	p.logger = params.Logger
+
+	c := config.(*Config)
+	if c.Format == "template" {
+		template, err := pipeline.ParseEventTemplate(c.Template)
+		if err != nil {
+			p.logger.Fatalf("can't parse template: %s", err.Error())
+		}
+		p.template = template
+	}
 }
 
 func (p *Plugin) Stop() {
 }
 
 func (p *Plugin) Out(event *pipeline.Event) {
-	fmt.Println(event.Root.EncodeToString())
+	if p.template != nil {
+		fmt.Println(string(p.template.Render(nil, event)))
+	} else {
+		fmt.Println(event.Root.EncodeToString())
+	}
 	p.controller.Commit(event)
 }
@@ -0,0 +1,432 @@
+// Package webhook is an output plugin that posts matched events to a webhook.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ozonru/file.d/auth"
+	"github.com/ozonru/file.d/cfg"
+	"github.com/ozonru/file.d/fd"
+	"github.com/ozonru/file.d/netutil"
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/ozonru/file.d/signing"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It posts events matching one of the `rules` to the webhook, e.g. to alert on critical log patterns right from the edge.
+Other events are skipped. Rules are checked in order, the first matching rule is used.
+
+Each rule renders the message by its `template` and has its own rate limit,
+events over the limit are dropped, so a burst of errors doesn't flood the channel.
+
+Formats of the request body:
+* `json` – `{"rule":"<rule name>","message":"<rendered template>","event":{...}}`
+* `slack` – `{"text":"<rendered template>"}`, it's accepted by Slack incoming webhooks and compatible services (Mattermost, Rocket.Chat)
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: webhook
+      endpoint: https://hooks.slack.com/services/T000/B000/XXXX
+      format: slack
+      rules:
+        - name: panics
+          match_fields:
+            level: /^(panic|fatal)$/
+          template: ':fire: {{.service}} on {{.host}}: {{.message}}'
+          rate_limit: 5
+          rate_limit_interval: 1m
+    ...
+```
+}*/
+
+const (
+	formatJSON  = "json"
+	formatSlack = "slack"
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	avgLogSize int
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+	client     *http.Client
+	dialer     *netutil.Dialer
+	rules      []*rule
+	headers    *pipeline.HeaderTemplates
+	signer     signing.Signer
+	isStopped  atomic.Bool
+}
+
+//! config-params
+//^ config-params
+type Config struct {
+	//> @3@4@5@6
+	//>
+	//> The webhook URL.
+	Endpoint string `json:"endpoint" required:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> A format of the request body.
+	Format string `json:"format" default:"json" options:"json|slack"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Additional request headers, e.g. for the authorization.
+	//> Values may contain event fields, e.g. `X-Scope-OrgID: "{{.tenant}}"`. Headers with empty values aren't sent.
+	Headers map[string]string `json:"headers"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Signing of requests, e.g. `{type: hmac, hmac_key: secret}`. The object has the following fields:
+	//> * `type` – `aws_sigv4` or `hmac`
+	//> * `aws_region`, `aws_service` (`es` by default), `aws_access_key_id`, `aws_secret_access_key` – for `aws_sigv4`, the default credentials chain is used if the key id is empty
+	//> * `hmac_key`, `hmac_header` (`X-Signature` by default), `hmac_timestamp_header` (`X-Timestamp` by default), `hmac_algorithm` (`sha256` or `sha512`) – for `hmac`,
+	//> the hex encoded signature of `<unix_timestamp>\n<method>\n<path_with_query>\n<body>` is set to the header
+	Signing *signing.Config `json:"signing"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Authorization with short-lived tokens, e.g. `{type: oauth2, token_url: https://sso/token, client_id: file-d, client_secret: secret}`.
+	//> The token is refreshed before it expires, if the endpoint responds with `401` the token is fetched again and the request is retried once.
+	//> The object has the following fields:
+	//> * `type` – `oauth2` for the client credentials flow or `token_file` for the token rotated in the file, the file is read again once it's modified
+	//> * `token_url`, `client_id`, `client_secret`, `scopes` – for `oauth2`
+	//> * `token_file` – for `token_file`
+	//> * `refresh_before` – how long before the expiry the token is refreshed, `1m` by default
+	//> * `header` (`Authorization` by default), `scheme` (`Bearer` by default) – the token is set to the header as `<scheme> <token>`
+	Auth *auth.Config `json:"auth"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Rules of events to post. It's a list of objects, each object has the following fields:
+	//> * `name` – the name of the rule, it's sent in the `json` format
+	//> * `match_fields` – the map of `event field name => event field value`, values starting with `/` are regular expressions
+	//> * `match_mode` – how to combine `match_fields`: `and` or `or`, `and` by default
+	//> * `template` – the message template, e.g. `{{.service}}: {{.message}}`, `{{.message}}` by default
+	//> * `rate_limit` – a maximum quantity of events to post per `rate_limit_interval`, `10` by default
+	//> * `rate_limit_interval` – an interval of the rate limit, `1m` by default
+	Rules []RuleConfig `json:"rules" slice:"true"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How many times to retry the failed request before dropping the event.
+	Retries int `json:"retries" default:"3"` //*
+
+	//> @3@4@5@6
+	//>
+	//> Retries of the failed request are delayed by this interval.
+	RetryInterval  cfg.Duration `json:"retry_interval" default:"1s" parse:"duration"` //*
+	RetryInterval_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> Client timeout when sends requests to the webhook.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` //*
+	RequestTimeout_ time.Duration
+
+	//> @3@4@5@6
+	//>
+	//> A network interface to connect from, e.g. `eth1`. Connections are made from its address of the family of the endpoint address.
+	//> IPv6 and IPv4 addresses of the endpoint host are tried in parallel. If it's empty, the interface is chosen by the routing table.
+	SourceInterface string `json:"source_interface"` //*
+
+	//> @3@4@5@6
+	//>
+	//> How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"1" parse:"expression"` //*
+	WorkersCount_ int
+
+	//> @3@4@5@6
+	//>
+	//> A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` //*
+	BatchSize_ int
+
+	//> @3@4@5@6
+	//>
+	//> After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"1s" parse:"duration"` //*
+	BatchFlushTimeout_ time.Duration
+}
+
+type RuleConfig struct {
+	Name               string            `json:"name"`
+	MatchFields        map[string]string `json:"match_fields"`
+	MatchMode          string            `json:"match_mode" default:"and" options:"and|or"`
+	Template           string            `json:"template" default:"{{.message}}"`
+	RateLimit          int               `json:"rate_limit" default:"10"`
+	RateLimitInterval  cfg.Duration      `json:"rate_limit_interval" default:"1m" parse:"duration"`
+	RateLimitInterval_ time.Duration
+}
+
+type data struct {
+	outBuf []byte
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    "webhook",
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgLogSize = params.PipelineSettings.AvgLogSize
+	p.config = config.(*Config)
+	dialer, err := netutil.NewDialer(p.config.RequestTimeout_, p.config.SourceInterface)
+	if err != nil {
+		p.logger.Fatalf("can't create dialer: %s", err.Error())
+	}
+	dialer.SetChaos(params.Chaos)
+	p.dialer = dialer
+	p.client = &http.Client{Timeout: p.config.RequestTimeout_, Transport: netutil.NewTransport(dialer)}
+
+	if p.config.Auth != nil {
+		// tokens are requested with a separate client, so they aren't authorized themselves
+		tokenClient := &http.Client{Timeout: p.config.RequestTimeout_, Transport: netutil.NewTransport(dialer)}
+		source, err := auth.New(p.config.Auth, tokenClient)
+		if err != nil {
+			p.logger.Fatalf("wrong auth: %s", err.Error())
+		}
+		p.client.Transport = auth.NewTransport(p.client.Transport, source)
+	}
+
+	if len(p.config.Rules) == 0 {
+		p.logger.Fatalf("no rules are set")
+	}
+
+	headers, err := pipeline.ParseHeaderTemplates(p.config.Headers)
+	if err != nil {
+		p.logger.Fatalf("wrong headers: %s", err.Error())
+	}
+	p.headers = headers
+
+	if p.config.Signing != nil {
+		p.signer, err = signing.New(p.config.Signing)
+		if err != nil {
+			p.logger.Fatalf("wrong signing: %s", err.Error())
+		}
+	}
+
+	for i, ruleConfig := range p.config.Rules {
+		r, err := newRule(ruleConfig)
+		if err != nil {
+			p.logger.Fatalf("wrong rule #%d: %s", i, err.Error())
+		}
+		p.rules = append(p.rules, r)
+	}
+
+	p.batcher = pipeline.NewBatcher(
+		params.PipelineName,
+		"webhook",
+		p.out,
+		p.maintenance,
+		p.controller,
+		p.config.WorkersCount_,
+		p.config.BatchSize_,
+		p.config.BatchFlushTimeout_,
+		0,
+	)
+	p.batcher.Start()
+}
+
+func (p *Plugin) Stop() {
+	p.isStopped.Store(true)
+	p.batcher.Stop()
+	p.client.CloseIdleConnections()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+	if *workerData == nil {
+		*workerData = &data{
+			outBuf: make([]byte, 0, p.avgLogSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	for _, event := range batch.Events {
+		r := p.findRule(event)
+		if r == nil {
+			continue
+		}
+
+		if !r.allow(time.Now()) {
+			continue
+		}
+
+		data.outBuf = r.template.Render(data.outBuf[:0], event)
+		body, err := p.encodeBody(r, string(data.outBuf), event)
+		if err != nil {
+			p.logger.Errorf("can't encode webhook body: %s", err.Error())
+			continue
+		}
+
+		if err := p.send(body, p.headers.Render(event)); err != nil {
+			batch.Fail(err)
+		}
+	}
+}
+
+func (p *Plugin) findRule(event *pipeline.Event) *rule {
+	for _, r := range p.rules {
+		if r.conditions.IsMatch(event, r.matchMode) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) encodeBody(r *rule, message string, event *pipeline.Event) ([]byte, error) {
+	if p.config.Format == formatSlack {
+		return json.Marshal(map[string]string{"text": message})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"rule":    r.name,
+		"message": message,
+		"event":   json.RawMessage(event.Root.EncodeToByte()),
+	})
+}
+
+// send retries the request, the error is returned if the event is dropped after retries or on the stop.
+func (p *Plugin) send(body []byte, header http.Header) error {
+	for attempt := 0; ; attempt++ {
+		err := p.post(body, header)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= p.config.Retries {
+			p.logger.Errorf("can't post event to webhook, it's dropped: %s", err.Error())
+			return err
+		}
+
+		p.logger.Errorf("can't post event to webhook: %s", err.Error())
+		// connections are closed by the stop, so the event isn't retried
+		if p.isStopped.Load() {
+			return err
+		}
+		time.Sleep(p.config.RetryInterval_)
+	}
+}
+
+func (p *Plugin) post(body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, p.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("can't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if p.signer != nil {
+		if err := p.signer.Sign(req, body); err != nil {
+			return fmt.Errorf("can't sign request: %w", err)
+		}
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// the endpoint may have moved to another address
+		p.dialer.ResetHost(p.client, req.URL.Host)
+		return fmt.Errorf("can't send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("wrong response code %d: %s", resp.StatusCode, respBody)
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+func (p *Plugin) maintenance(_ *pipeline.WorkerData) {}
+
+type rule struct {
+	name       string
+	conditions pipeline.MatchConditions
+	matchMode  pipeline.MatchMode
+	template   *pipeline.EventTemplate
+
+	limit    int
+	interval time.Duration
+
+	mu          *sync.Mutex
+	windowStart time.Time
+	windowCount int
+}
+
+func newRule(config RuleConfig) (*rule, error) {
+	conditions, err := pipeline.NewMatchConditions(config.MatchFields)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := pipeline.ParseEventTemplate(config.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	matchMode := pipeline.MatchModeAnd
+	if config.MatchMode == "or" {
+		matchMode = pipeline.MatchModeOr
+	}
+
+	return &rule{
+		name:       config.Name,
+		conditions: conditions,
+		matchMode:  matchMode,
+		template:   template,
+		limit:      config.RateLimit,
+		interval:   config.RateLimitInterval_,
+		mu:         &sync.Mutex{},
+	}, nil
+}
+
+// allow checks the rate limit of the rule using a fixed window.
+func (r *rule) allow(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.windowStart) >= r.interval {
+		r.windowStart = now
+		r.windowCount = 0
+	}
+
+	if r.windowCount >= r.limit {
+		return false
+	}
+	r.windowCount++
+
+	return true
+}
@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozonru/file.d/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+type fakeWebhook struct {
+	mu     *sync.Mutex
+	bodies []string
+	auth   string
+	tenant string
+}
+
+func (s *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auth = r.Header.Get("Authorization")
+	s.tenant = r.Header.Get("X-Scope-OrgID")
+	body, _ := ioutil.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+}
+
+func newPlugin(t *testing.T, format string, rules ...RuleConfig) (*Plugin, *fakeWebhook) {
+	webhook := &fakeWebhook{mu: &sync.Mutex{}}
+	server := httptest.NewServer(webhook)
+	t.Cleanup(server.Close)
+
+	p := &Plugin{
+		config: &Config{
+			Endpoint: server.URL,
+			Format:   format,
+			Headers:  map[string]string{"Authorization": "Bearer token", "X-Scope-OrgID": "{{.service}}"},
+		},
+		logger: zap.NewNop().Sugar(),
+		client: server.Client(),
+	}
+
+	headers, err := pipeline.ParseHeaderTemplates(p.config.Headers)
+	require.NoError(t, err)
+	p.headers = headers
+
+	for _, ruleConfig := range rules {
+		r, err := newRule(ruleConfig)
+		require.NoError(t, err)
+		p.rules = append(p.rules, r)
+	}
+
+	return p, webhook
+}
+
+func newBatch(events ...string) *pipeline.Batch {
+	batch := &pipeline.Batch{}
+	for _, event := range events {
+		root, _ := insaneJSON.DecodeString(event)
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+	return batch
+}
+
+func TestJSON(t *testing.T) {
+	p, webhook := newPlugin(t, formatJSON,
+		RuleConfig{
+			Name:               "panics",
+			MatchFields:        map[string]string{"level": "/^(panic|fatal)$/"},
+			Template:           "{{.service}}: {{.message}}",
+			RateLimit:          10,
+			RateLimitInterval_: time.Minute,
+		},
+		RuleConfig{
+			Name:               "timeouts",
+			MatchFields:        map[string]string{"error": "timeout"},
+			Template:           "timeout in {{.service}}",
+			RateLimit:          10,
+			RateLimitInterval_: time.Minute,
+		},
+	)
+
+	workerData := pipeline.WorkerData(nil)
+	p.out(&workerData, newBatch(
+		`{"level":"panic","service":"api","message":"nil pointer"}`,
+		`{"level":"info","service":"api","message":"ok"}`,
+		`{"level":"error","service":"db","error":"timeout"}`,
+	))
+
+	require.Equal(t, 2, len(webhook.bodies), "wrong requests count")
+	assert.Equal(t, `{"event":{"level":"panic","service":"api","message":"nil pointer"},"message":"api: nil pointer","rule":"panics"}`, webhook.bodies[0])
+	assert.Equal(t, `{"event":{"level":"error","service":"db","error":"timeout"},"message":"timeout in db","rule":"timeouts"}`, webhook.bodies[1])
+	assert.Equal(t, "Bearer token", webhook.auth)
+	assert.Equal(t, "db", webhook.tenant, "header isn't rendered from the event")
+}
+
+func TestSlackRateLimit(t *testing.T) {
+	p, webhook := newPlugin(t, formatSlack, RuleConfig{
+		MatchFields:        map[string]string{"level": "error"},
+		Template:           "{{.message}}",
+		RateLimit:          2,
+		RateLimitInterval_: time.Minute,
+	})
+
+	workerData := pipeline.WorkerData(nil)
+	p.out(&workerData, newBatch(
+		`{"level":"error","message":"1"}`,
+		`{"level":"error","message":"2"}`,
+		`{"level":"error","message":"3"}`,
+	))
+
+	assert.Equal(t, []string{`{"text":"1"}`, `{"text":"2"}`}, webhook.bodies, "events over the rate limit should be dropped")
+
+	assert.True(t, p.rules[0].allow(time.Now().Add(time.Minute)), "rate limit should be reset in the next window")
+}
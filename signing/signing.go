@@ -0,0 +1,157 @@
+// Package signing signs requests of http outputs, so they can talk to managed cloud sinks without a separate proxy.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/ozonru/file.d/cfg"
+)
+
+const (
+	// TypeAWSSigV4 signs requests with AWS Signature Version 4, e.g. for OpenSearch or Kinesis.
+	TypeAWSSigV4 = "aws_sigv4"
+	// TypeHMAC adds the HMAC signature of the request to the header.
+	TypeHMAC = "hmac"
+)
+
+// Config is the `signing` object of the output config.
+type Config struct {
+	// Type is `aws_sigv4` or `hmac`.
+	Type string `json:"type" required:"true" options:"aws_sigv4|hmac"`
+
+	// AWSRegion is the region of the service, it's required for `aws_sigv4`.
+	AWSRegion string `json:"aws_region"`
+	// AWSService is the name of the service used for signing, e.g. `es`, `aoss` or `kinesis`.
+	AWSService string `json:"aws_service" default:"es"`
+	// AWSAccessKeyID is the access key id, the default credentials chain is used if it's empty.
+	AWSAccessKeyID     string `json:"aws_access_key_id"`
+	AWSSecretAccessKey string `json:"aws_secret_access_key"`
+
+	// HMACKey is the secret key, it's required for `hmac`.
+	HMACKey string `json:"hmac_key"`
+	// HMACHeader is the header with the hex encoded signature.
+	HMACHeader string `json:"hmac_header" default:"X-Signature"`
+	// HMACTimestampHeader is the header with unix time of the request in seconds, it's signed along with the request.
+	HMACTimestampHeader string `json:"hmac_timestamp_header" default:"X-Timestamp"`
+	HMACAlgorithm       string `json:"hmac_algorithm" default:"sha256" options:"sha256|sha512"`
+}
+
+// Signer signs the request with the body, the body of the request is set by the caller.
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// New checks the config, sets defaults and returns the signer.
+func New(config *Config) (Signer, error) {
+	if err := cfg.Parse(config, nil); err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case TypeAWSSigV4:
+		return newAWSSigner(config)
+	case TypeHMAC:
+		return newHMACSigner(config)
+	default:
+		return nil, fmt.Errorf("unknown signing type %q", config.Type)
+	}
+}
+
+type awsSigner struct {
+	signer  *v4.Signer
+	region  string
+	service string
+	now     func() time.Time
+}
+
+func newAWSSigner(config *Config) (*awsSigner, error) {
+	if config.AWSRegion == "" {
+		return nil, fmt.Errorf("aws_region should be set for %q signing", TypeAWSSigV4)
+	}
+
+	awsConfig := aws.NewConfig().WithRegion(config.AWSRegion)
+	if config.AWSAccessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(config.AWSAccessKeyID, config.AWSSecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can't create aws session: %w", err)
+	}
+
+	return &awsSigner{
+		signer:  v4.NewSigner(sess.Config.Credentials),
+		region:  config.AWSRegion,
+		service: config.AWSService,
+		now:     time.Now,
+	}, nil
+}
+
+func (s *awsSigner) Sign(req *http.Request, body []byte) error {
+	// some services, e.g. OpenSearch Serverless, require the payload hash header
+	hash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+
+	_, err := s.signer.Sign(req, bytes.NewReader(body), s.service, s.region, s.now())
+	return err
+}
+
+// hmacSigner signs the string `<timestamp>\n<method>\n<path with query>\n<body>`,
+// so the receiver can check the request isn't modified or replayed later.
+type hmacSigner struct {
+	key             []byte
+	header          string
+	timestampHeader string
+	newHash         func() hash.Hash
+	now             func() time.Time
+}
+
+func newHMACSigner(config *Config) (*hmacSigner, error) {
+	if config.HMACKey == "" {
+		return nil, fmt.Errorf("hmac_key should be set for %q signing", TypeHMAC)
+	}
+
+	newHash := sha256.New
+	if config.HMACAlgorithm == "sha512" {
+		newHash = sha512.New
+	}
+
+	return &hmacSigner{
+		key:             []byte(config.HMACKey),
+		header:          config.HMACHeader,
+		timestampHeader: config.HMACTimestampHeader,
+		newHash:         newHash,
+		now:             time.Now,
+	}, nil
+}
+
+func (s *hmacSigner) Sign(req *http.Request, body []byte) error {
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+
+	mac := hmac.New(s.newHash, s.key)
+	_, _ = mac.Write([]byte(timestamp))
+	_, _ = mac.Write([]byte{'\n'})
+	_, _ = mac.Write([]byte(req.Method))
+	_, _ = mac.Write([]byte{'\n'})
+	_, _ = mac.Write([]byte(req.URL.RequestURI()))
+	_, _ = mac.Write([]byte{'\n'})
+	_, _ = mac.Write(body)
+
+	req.Header.Set(s.timestampHeader, timestamp)
+	req.Header.Set(s.header, hex.EncodeToString(mac.Sum(nil)))
+
+	return nil
+}
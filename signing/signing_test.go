@@ -0,0 +1,67 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMAC(t *testing.T) {
+	signer, err := New(&Config{Type: TypeHMAC, HMACKey: "secret"})
+	require.NoError(t, err)
+	signer.(*hmacSigner).now = func() time.Time { return time.Unix(1600000000, 0) }
+
+	body := []byte(`{"message":"hello"}`)
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/ingest?tenant=a", nil)
+	require.NoError(t, err)
+	require.NoError(t, signer.Sign(req, body))
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	_, _ = mac.Write([]byte("1600000000\nPOST\n/ingest?tenant=a\n" + string(body)))
+
+	assert.Equal(t, "1600000000", req.Header.Get("X-Timestamp"), "wrong timestamp header")
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Signature"), "wrong signature")
+}
+
+func TestHMACSHA512(t *testing.T) {
+	signer, err := New(&Config{Type: TypeHMAC, HMACKey: "secret", HMACAlgorithm: "sha512", HMACHeader: "X-Sign"})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/", nil)
+	require.NoError(t, err)
+	require.NoError(t, signer.Sign(req, []byte("body")))
+
+	assert.Len(t, req.Header.Get("X-Sign"), 128, "wrong signature length")
+}
+
+func TestAWSSigV4(t *testing.T) {
+	signer, err := New(&Config{Type: TypeAWSSigV4, AWSRegion: "eu-west-1", AWSAccessKeyID: "key", AWSSecretAccessKey: "secret"})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/_bulk", nil)
+	require.NoError(t, err)
+	require.NoError(t, signer.Sign(req, []byte("body")))
+
+	auth := req.Header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/"), "wrong authorization header %q", auth)
+	assert.Contains(t, auth, "/eu-west-1/es/aws4_request", "wrong credential scope")
+	assert.NotEmpty(t, req.Header.Get("X-Amz-Content-Sha256"), "no payload hash")
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(&Config{Type: TypeAWSSigV4})
+	assert.Error(t, err, "region isn't checked")
+
+	_, err = New(&Config{Type: TypeHMAC})
+	assert.Error(t, err, "key isn't checked")
+
+	_, err = New(&Config{Type: "basic"})
+	assert.Error(t, err, "type isn't checked")
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ozonru/file.d/cfg"
@@ -164,6 +165,38 @@ func NewPipelineMock(actions []*pipeline.ActionPluginStaticInfo, pipelineOpts ..
 	return p, p.GetInput().(*fake.Plugin), p.GetOutput().(*devnull.Plugin)
 }
 
+// RunAction passes events through the pipeline with the single action and returns encoded output events,
+// the config is parsed with default values. Each event should get to the output.
+func RunAction(factory pipeline.PluginFactory, config pipeline.AnyConfig, events []string) []string {
+	return RunActionOut(factory, config, events, len(events))
+}
+
+// RunActionOut is like RunAction, but it waits only for outCount events to get to the output,
+// so the action may discard others. Unexpected output events are returned as well, since the pipeline is drained on the stop.
+func RunActionOut(factory pipeline.PluginFactory, config pipeline.AnyConfig, events []string, outCount int) []string {
+	NewConfig(config, nil)
+	p, input, output := NewPipelineMock(NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+	wg := &sync.WaitGroup{}
+	wg.Add(outCount)
+
+	outEvents := make([]string, 0)
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		if len(outEvents) <= outCount {
+			wg.Done()
+		}
+	})
+
+	for _, event := range events {
+		input.In(0, "test.log", 0, []byte(event))
+	}
+
+	wg.Wait()
+	p.Stop()
+
+	return outEvents
+}
+
 func NewPluginStaticInfo(factory pipeline.PluginFactory, config pipeline.AnyConfig) *pipeline.PluginStaticInfo {
 	return &pipeline.PluginStaticInfo{
 		Type:    "test_plugin",
@@ -188,6 +221,7 @@ func NewEmptyOutputPluginParams() *pipeline.OutputPluginParams {
 		PluginDefaultParams: &pipeline.PluginDefaultParams{
 			PipelineName:     "test_pipeline",
 			PipelineSettings: &pipeline.Settings{},
+			MetricsRegistry:  prometheus.NewRegistry(),
 		},
 		Controller: nil,
 		Logger:     zap.L().Sugar(),